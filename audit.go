@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/auth"
+)
+
+const auditUsage = `Usage: antal audit export [--since WHEN] [--stats]
+
+Exports the SQLite audit store (audit.sqlite.path) as JSON Lines to stdout:
+the audit events in the format of the audit file, which antal replay --from
+reads, or with --stats the daily usage stats per user and outcome.
+
+--since is an RFC 3339 time or a duration back from now (e.g. 168h); by
+default everything is exported. The store can be exported while the
+service is running.
+`
+
+// runAudit implements `antal audit`. It returns the process exit code.
+func runAudit(args []string) int {
+	if len(args) != 1 || args[0] != "export" {
+		fmt.Fprint(os.Stderr, auditUsage)
+		return 2
+	}
+	since, err := parseSince(viper.GetString("since"), time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --since: %v\n", err)
+		return 2
+	}
+	path := viper.GetString("audit.sqlite.path")
+	export := auth.ExportAuditEvents
+	if stats, _ := pflag.CommandLine.GetBool("stats"); stats {
+		export = auth.ExportUsageStats
+	}
+	// Progress goes to stderr, as the logs would mix with the records on stdout.
+	n, err := export(path, since, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit export failed after %d records: %v\n", n, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d records from %s\n", n, path)
+	return 0
+}
+
+// parseSince parses --since: empty for everything, an RFC 3339 time or a
+// duration before now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", value)
+	}
+	return now.Add(-d), nil
+}
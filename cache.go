@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/auth"
+)
+
+const cacheUsage = `Usage: antal cache <command> [--creds FILE]
+
+Operates directly on the token cache bucket (token_cache.bucket), for
+maintenance when the admin HTTP API is unreachable.
+
+Commands:
+  ls [USERNAME]   list entries (HMAC key, user, scopes, groups, last verified)
+  rm KEY...       remove entries by key
+  purge           remove every entry (all users re-verify with GitLab)
+  stats           print the capacity report as JSON
+
+Connects to nats.url with --creds, or with the nats.* credentials.
+`
+
+// runCache implements `antal cache`. It returns the process exit code.
+func runCache(args []string) int {
+	if len(args) == 0 || !slices.Contains([]string{"ls", "rm", "purge", "stats"}, args[0]) {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		return 2
+	}
+	logger := slog.With("component", "cache_cli")
+
+	nc, err := connectMaintenance("antal cache")
+	if err != nil {
+		logger.Error("Failed to connect to NATS", "error", err)
+		return 1
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		logger.Error("Failed to get JetStream context", "error", err)
+		return 1
+	}
+	admin, err := auth.OpenTokenCacheAdmin(js, viper.GetString("token_cache.bucket"))
+	if err != nil {
+		logger.Error("Failed to open token cache", "error", err)
+		return 1
+	}
+
+	if err := cacheCommand(admin, os.Stdout, args); err != nil {
+		if errors.Is(err, errCacheUsage) {
+			fmt.Fprint(os.Stderr, cacheUsage)
+			return 2
+		}
+		logger.Error("Cache command failed", "command", args[0], "error", err)
+		return 1
+	}
+	return 0
+}
+
+var errCacheUsage = errors.New("invalid usage")
+
+// cacheCommand runs one `antal cache` command against the bucket.
+func cacheCommand(admin *auth.TokenCacheAdmin, out io.Writer, args []string) error {
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "ls":
+		if len(rest) > 1 {
+			return errCacheUsage
+		}
+		items, err := admin.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tUSERNAME\tUSER ID\tSCOPES\tGROUPS\tLAST VERIFIED\tEXPIRES")
+		for _, item := range items {
+			e := item.Entry
+			if len(rest) == 1 && e.Username != rest[0] {
+				continue
+			}
+			expires := e.ExpiresAt
+			if item.Expired {
+				expires += " (expired)"
+			}
+			userID := ""
+			if e.UserID > 0 {
+				userID = strconv.FormatInt(e.UserID, 10)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", item.Key, e.Username, userID, e.Scopes, e.Groups, e.LastVerifiedAt, expires)
+		}
+		return tw.Flush()
+
+	case "rm":
+		if len(rest) == 0 {
+			return errCacheUsage
+		}
+		removed, err := admin.Remove(rest...)
+		fmt.Fprintf(out, "Removed %d entries\n", removed)
+		return err
+
+	case "purge":
+		if len(rest) != 0 {
+			return errCacheUsage
+		}
+		purged, err := admin.Purge()
+		fmt.Fprintf(out, "Purged %d entries\n", purged)
+		return err
+
+	case "stats":
+		if len(rest) != 0 {
+			return errCacheUsage
+		}
+		report, err := admin.Stats(viper.GetDuration("token_cache.ttl"))
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return errCacheUsage
+}
+
+// connectMaintenance connects to nats.url for maintenance commands, with the
+// credentials file given by --creds or the configured credentials.
+func connectMaintenance(name string) (*nats.Conn, error) {
+	opts := []nats.Option{nats.Name(name), nats.MaxReconnects(0)}
+	if creds, _ := pflag.CommandLine.GetString("creds"); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	} else {
+		opts = append(opts, auth.LoadNATSCredentials().Options()...)
+	}
+	tlsConfig, err := auth.LoadNATSTLSConfig().TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+	return nats.Connect(viper.GetString("nats.url"), opts...)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/auth"
+)
+
+// serviceInfo is the output of `antal info`.
+type serviceInfo struct {
+	Version string `json:"version"`
+	auth.ServiceInfo
+	Endpoints endpointsInfo `json:"endpoints"`
+}
+
+// endpointsInfo lists the HTTP endpoints of the service.
+type endpointsInfo struct {
+	// Listen is the address the HTTP server binds.
+	Listen string `json:"listen"`
+	// Public are served to every allowed source.
+	Public []string `json:"public"`
+	// Admin require the admin token; empty without admin.token.
+	Admin []string `json:"admin"`
+}
+
+// publicPaths and adminPaths are the paths main mounts; keep them in sync
+// with the srv.Handle calls.
+var (
+	publicPaths = []string{"/health", "/metrics", "/ready", "/info/schema", "/token/check"}
+	adminPaths  = []string{
+		"/admin/issuer/rotate",
+		"/admin/token_cache/report",
+		"/admin/token_cache/invalidate",
+		"/admin/subject_usage",
+		"/admin/config/reload",
+		"/admin/config/changelog",
+		"/admin/config/drift",
+	}
+)
+
+// runInfo implements `antal info`: it prints the public keys, subjects and
+// endpoints the NATS servers and the infrastructure around the service must
+// match, as text or, with --json, as stable JSON for Terraform or Ansible.
+// It reads the configuration only and never prints secrets. It returns the
+// process exit code.
+func runInfo() int {
+	info, err := loadServiceInfo()
+	if err != nil {
+		slog.With("component", "info").Error("Failed to describe the service", "error", err)
+		return 1
+	}
+	if viper.GetBool("json") {
+		err = writeInfoJSON(os.Stdout, info)
+	} else {
+		err = writeInfoText(os.Stdout, info)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write service info: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func loadServiceInfo() (serviceInfo, error) {
+	base, err := auth.LoadServiceInfo()
+	if err != nil {
+		return serviceInfo{}, err
+	}
+	info := serviceInfo{
+		Version:     version,
+		ServiceInfo: base,
+		Endpoints: endpointsInfo{
+			Listen: net.JoinHostPort(viper.GetString("server.host"), strconv.Itoa(viper.GetInt("server.port"))),
+			Public: publicPaths,
+			Admin:  []string{},
+		},
+	}
+	if viper.GetString("admin.token") != "" {
+		info.Endpoints.Admin = adminPaths
+	}
+	return info, nil
+}
+
+func writeInfoJSON(out io.Writer, info serviceInfo) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(info)
+}
+
+func writeInfoText(out io.Writer, info serviceInfo) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	row := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s\t%s\n", key, value)
+		}
+	}
+	row("version", info.Version)
+	row("issuer", info.AuthCallout.Issuer)
+	row("standby issuer", info.AuthCallout.StandbyIssuer)
+	row("xkey", info.AuthCallout.XKey)
+	row("account", info.AuthCallout.Account)
+	row("auth users", strings.Join(info.AuthCallout.AuthUsers, ", "))
+	for _, a := range info.Accounts {
+		account := a.Account
+		if a.SigningKey != "" {
+			account += " (signing key " + a.SigningKey + ")"
+		}
+		row("account for "+a.Group, account)
+	}
+	row("auth callout subject", info.Subjects.AuthCallout)
+	row("internal subjects", info.Subjects.Internal)
+	row("permission probe subject", info.Subjects.CanI)
+	row("audit subject", info.Subjects.Audit)
+	row("reserved prefixes", strings.Join(info.Subjects.ReservedPrefixes, ", "))
+	row("http listen", info.Endpoints.Listen)
+	row("http endpoints", strings.Join(info.Endpoints.Public, ", "))
+	row("admin endpoints", strings.Join(info.Endpoints.Admin, ", "))
+	return w.Flush()
+}
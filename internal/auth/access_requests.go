@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"go.yaml.in/yaml/v3"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// AccessRequestsConfig configures self-service permission requests via
+// GitLab issues.
+type AccessRequestsConfig struct {
+	Enabled bool
+	// Project is the GitLab project (ID or full path) holding the requests.
+	Project string
+	// Token is a PAT able to read the project's issues.
+	Token string
+	// Label marks an issue as an access request.
+	Label string
+	// ApprovedLabel is added by maintainers to approve a request.
+	ApprovedLabel string
+	Interval      time.Duration
+	Bucket        string
+	Replicas      int
+}
+
+// LoadAccessRequestsConfig reads the access_requests.* settings.
+func LoadAccessRequestsConfig() AccessRequestsConfig {
+	return AccessRequestsConfig{
+		Enabled:       viper.GetBool("access_requests.enabled"),
+		Project:       viper.GetString("access_requests.project"),
+		Token:         viper.GetString("access_requests.token"),
+		Label:         viper.GetString("access_requests.label"),
+		ApprovedLabel: viper.GetString("access_requests.approved_label"),
+		Interval:      viper.GetDuration("access_requests.interval"),
+		Bucket:        viper.GetString("access_requests.bucket"),
+		Replicas:      viper.GetInt("access_requests.replicas"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg AccessRequestsConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch {
+	case cfg.Project == "" || cfg.Token == "":
+		return fmt.Errorf("access_requests: project and token are required")
+	case cfg.Label == "" || cfg.ApprovedLabel == "" || cfg.Label == cfg.ApprovedLabel:
+		return fmt.Errorf("access_requests: label and approved_label must be set and differ")
+	case cfg.Interval <= 0:
+		return fmt.Errorf("access_requests: interval must be > 0")
+	}
+	return nil
+}
+
+// accessRequestSpec is the YAML document in an access request issue:
+//
+//	publish: ["orders.created"]
+//	subscribe: ["orders.>"]
+type accessRequestSpec struct {
+	Publish   []string `yaml:"publish"`
+	Subscribe []string `yaml:"subscribe"`
+}
+
+// yamlBlock matches a fenced yaml code block in Markdown.
+var yamlBlock = regexp.MustCompile("(?s)```ya?ml\\s*\\n(.*?)```")
+
+// parseAccessRequest extracts the requested permissions from an issue
+// description: the first ```yaml block, or the whole description.
+func parseAccessRequest(description string, reserved []string) (accessRequestSpec, error) {
+	doc := description
+	if m := yamlBlock.FindStringSubmatch(description); m != nil {
+		doc = m[1]
+	}
+
+	var spec accessRequestSpec
+	if err := yaml.Unmarshal([]byte(doc), &spec); err != nil {
+		return accessRequestSpec{}, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if len(spec.Publish) == 0 && len(spec.Subscribe) == 0 {
+		return accessRequestSpec{}, fmt.Errorf("no publish or subscribe subjects requested")
+	}
+	for _, subject := range concat(spec.Publish, spec.Subscribe) {
+		if err := validateRequestedSubject(subject, reserved); err != nil {
+			return accessRequestSpec{}, err
+		}
+	}
+	return spec, nil
+}
+
+// validateRequestedSubject rejects subjects that cannot be granted to a
+// single user: malformed ones, templates and reserved namespaces.
+func validateRequestedSubject(subject string, reserved []string) error {
+	switch {
+	case subject == "" || strings.ContainsAny(subject, " \t\r\n"):
+		return fmt.Errorf("invalid subject %q", subject)
+	case strings.Contains(subject, "{{"):
+		return fmt.Errorf("templates are not allowed in requested subjects: %q", subject)
+	case subject == ">" || strings.HasPrefix(subject, "*"):
+		return fmt.Errorf("subject %q is too broad", subject)
+	}
+	if prefix, ok := reservedPrefixFor(subject, reserved); ok {
+		return fmt.Errorf("subject %q is in the reserved namespace %q", subject, prefix)
+	}
+	return nil
+}
+
+// grantStore is where approved access requests end up.
+type grantStore interface {
+	Grants() map[string]UserGrant
+	Put(username string, grant UserGrant) error
+	Delete(username string) error
+}
+
+// AccessRequestSync turns approved access request issues into user grants.
+// The grants bucket mirrors the approved, open issues: closing an issue or
+// removing the approval label revokes the grant on the next sync.
+type AccessRequestSync struct {
+	cfg    AccessRequestsConfig
+	git    *gitlab.Client
+	store  grantStore
+	logger *slog.Logger
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewAccessRequestSync creates the sync; call Start to begin polling.
+func NewAccessRequestSync(cfg AccessRequestsConfig, gitlabURL string, store grantStore) (*AccessRequestSync, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	git, err := gitlab.NewClient(cfg.Token,
+		gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", gitlabURL)),
+		gitlab.WithCustomRetry(gitlabCheckRetry),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitLab client: %w", err)
+	}
+	return &AccessRequestSync{
+		cfg:    cfg,
+		git:    git,
+		store:  store,
+		logger: slog.With("component", "access_requests"),
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Start syncs immediately and then every interval until Stop.
+func (s *AccessRequestSync) Start() {
+	s.logger.Info("Watching access request issues", "project", s.cfg.Project, "label", s.cfg.Label, "approved_label", s.cfg.ApprovedLabel)
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Interval)
+			if err := s.Sync(ctx); err != nil {
+				s.logger.Error("Access request sync failed", "error", err)
+				sentry.CaptureException(fmt.Errorf("access request sync failed: %w", err))
+			}
+			cancel()
+
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends polling and waits for a running sync to finish.
+func (s *AccessRequestSync) Stop() {
+	close(s.stop)
+	s.done.Wait()
+}
+
+// Sync reconciles the grants bucket with the approved, open issues. Nothing
+// is revoked when the issues cannot be listed.
+func (s *AccessRequestSync) Sync(ctx context.Context) error {
+	issues, err := s.approvedIssues(ctx)
+	if err != nil {
+		return err
+	}
+
+	desired := s.desiredGrants(issues)
+	current := s.store.Grants()
+
+	for username, grant := range desired {
+		if have, ok := current[username]; ok && grantsEqual(have, grant) {
+			continue
+		}
+		if err := s.store.Put(username, grant); err != nil {
+			return fmt.Errorf("failed to store grant for %s: %w", username, err)
+		}
+		audit("access_request.grant", "ok", "username", username,
+			"publish", grant.Publish, "subscribe", grant.Subscribe, "issues", grant.Issues)
+	}
+	for username, grant := range current {
+		if _, ok := desired[username]; ok {
+			continue
+		}
+		if err := s.store.Delete(username); err != nil {
+			return fmt.Errorf("failed to revoke grant of %s: %w", username, err)
+		}
+		audit("access_request.revoke", "ok", "username", username, "issues", grant.Issues)
+	}
+	return nil
+}
+
+// approvedIssues lists every open issue carrying both labels.
+func (s *AccessRequestSync) approvedIssues(ctx context.Context) ([]*gitlab.Issue, error) {
+	opts := &gitlab.ListProjectIssuesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		State:       gitlab.Ptr("opened"),
+		Labels:      &gitlab.LabelOptions{s.cfg.Label, s.cfg.ApprovedLabel},
+	}
+
+	var all []*gitlab.Issue
+	for {
+		issues, resp, err := s.git.Issues.ListProjectIssues(s.cfg.Project, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access request issues: %w", err)
+		}
+		all = append(all, issues...)
+		if resp == nil || resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// desiredGrants merges the approved requests per issue author. Invalid
+// requests are skipped and reported; they never block the valid ones.
+func (s *AccessRequestSync) desiredGrants(issues []*gitlab.Issue) map[string]UserGrant {
+	reserved := LoadReservedPrefixes()
+	desired := map[string]UserGrant{}
+	for _, issue := range issues {
+		if issue.Author == nil || issue.Author.Username == "" {
+			continue
+		}
+		ref := fmt.Sprintf("%s#%d", s.cfg.Project, issue.IID)
+
+		spec, err := parseAccessRequest(issue.Description, reserved)
+		if err != nil {
+			accessRequestsInvalidTotal.Inc()
+			s.logger.Warn("Ignoring invalid access request", "issue", ref, "author", issue.Author.Username, "error", err)
+			continue
+		}
+
+		grant := desired[issue.Author.Username]
+		grant.Publish = dedupe(concat(grant.Publish, spec.Publish))
+		grant.Subscribe = dedupe(concat(grant.Subscribe, spec.Subscribe))
+		grant.Issues = append(grant.Issues, ref)
+		desired[issue.Author.Username] = grant
+	}
+	return desired
+}
+
+func grantsEqual(a, b UserGrant) bool {
+	return slices.Equal(a.Publish, b.Publish) && slices.Equal(a.Subscribe, b.Subscribe) && slices.Equal(a.Issues, b.Issues)
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccessRequest(t *testing.T) {
+	reserved := []string{"$SYS", "antal"}
+
+	t.Run("fenced block", func(t *testing.T) {
+		spec, err := parseAccessRequest("I need orders access.\n\n```yaml\npublish: [\"orders.created\"]\nsubscribe:\n  - orders.>\n```\nThanks!", reserved)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"orders.created"}, spec.Publish)
+		assert.Equal(t, []string{"orders.>"}, spec.Subscribe)
+	})
+
+	t.Run("whole description", func(t *testing.T) {
+		spec, err := parseAccessRequest("subscribe: [metrics.>]", reserved)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"metrics.>"}, spec.Subscribe)
+	})
+
+	for name, description := range map[string]string{
+		"not yaml":       "please give me access",
+		"empty":          "publish: []",
+		"reserved":       "publish: [antal.internal.issuer.rotated]",
+		"template":       "publish: ['user.{{.Username}}']",
+		"too broad":      "subscribe: ['>']",
+		"leading wild":   "subscribe: ['*.secrets']",
+		"invalid syntax": "publish: [\"a b\"]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseAccessRequest(description, reserved)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAccessRequestsConfig_Validate(t *testing.T) {
+	valid := AccessRequestsConfig{Enabled: true, Project: "platform/nats-access", Token: "t",
+		Label: "nats-access", ApprovedLabel: "nats-access::approved", Interval: time.Minute}
+	assert.NoError(t, valid.Validate())
+	assert.NoError(t, AccessRequestsConfig{}.Validate(), "disabled")
+
+	noToken := valid
+	noToken.Token = ""
+	assert.Error(t, noToken.Validate())
+
+	sameLabel := valid
+	sameLabel.ApprovedLabel = sameLabel.Label
+	assert.Error(t, sameLabel.Validate())
+}
+
+type memoryGrantStore struct {
+	grants map[string]UserGrant
+}
+
+func (s *memoryGrantStore) Grants() map[string]UserGrant { return maps.Clone(s.grants) }
+func (s *memoryGrantStore) Put(username string, grant UserGrant) error {
+	s.grants[username] = grant
+	return nil
+}
+func (s *memoryGrantStore) Delete(username string) error {
+	delete(s.grants, username)
+	return nil
+}
+
+func TestAccessRequestSync_Sync(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	issues := []map[string]any{
+		{"id": 101, "iid": 1, "author": map[string]any{"username": "alice"}, "description": "```yaml\npublish: [orders.created]\n```"},
+		{"id": 102, "iid": 2, "author": map[string]any{"username": "alice"}, "description": "subscribe: [orders.>]\npublish: [orders.created]"},
+		{"id": 103, "iid": 3, "author": map[string]any{"username": "mallory"}, "description": "subscribe: ['$SYS.>']"},
+	}
+	fail := false
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		query = r.URL.RawQuery
+		assert.Equal(t, "/api/v4/projects/platform/nats-access/issues", r.URL.Path)
+		_ = json.NewEncoder(w).Encode(issues)
+	}))
+	defer srv.Close()
+
+	store := &memoryGrantStore{grants: map[string]UserGrant{
+		"bob": {Subscribe: []string{"legacy.>"}, Issues: []string{"platform/nats-access#0"}},
+	}}
+	s, err := NewAccessRequestSync(AccessRequestsConfig{Enabled: true, Project: "platform/nats-access", Token: "t",
+		Label: "nats-access", ApprovedLabel: "nats-access::approved", Interval: time.Minute}, srv.URL, store)
+	require.NoError(t, err)
+	s.logger = slog.Default()
+
+	require.NoError(t, s.Sync(context.Background()))
+	assert.Contains(t, query, "state=opened")
+	assert.Contains(t, query, "labels=nats-access%2Cnats-access%3A%3Aapproved")
+
+	assert.Equal(t, map[string]UserGrant{
+		"alice": {
+			Publish:   []string{"orders.created"},
+			Subscribe: []string{"orders.>"},
+			Issues:    []string{"platform/nats-access#1", "platform/nats-access#2"},
+		},
+	}, store.grants, "bob's request is no longer approved and mallory's is invalid")
+
+	// GitLab errors must not revoke anything.
+	fail = true
+	assert.Error(t, s.Sync(context.Background()))
+	assert.Contains(t, store.grants, "alice")
+}
+
+func TestUserGrants_Apply(t *testing.T) {
+	g := newUserGrants(nil, slog.Default())
+
+	require.NoError(t, g.apply("alice", []byte(`{"publish":["orders.created"],"issues":["p#1"]}`), false))
+	grant, ok := g.Lookup("alice")
+	require.True(t, ok)
+	assert.Equal(t, []string{"orders.created"}, grant.Publish)
+
+	assert.Error(t, g.apply("bob", []byte("not json"), false))
+	_, ok = g.Lookup("bob")
+	assert.False(t, ok)
+
+	require.NoError(t, g.apply("alice", nil, true))
+	assert.Empty(t, g.Grants())
+}
+
+func TestResolvePermissions_UserGrants(t *testing.T) {
+	setTenantsConfig(t)
+	grants := newUserGrants(nil, slog.Default())
+	require.NoError(t, grants.apply("alice", []byte(`{"publish":["orders.created","antal.x"],"subscribe":["orders.>"]}`), false))
+	c := &NATSClient{logger: slog.Default(), userGrants: grants, reservedPrefixes: []string{"antal"}}
+
+	t.Run("grant follows the verified user", func(t *testing.T) {
+		result := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+		set := c.resolvePermissions(result, "alice", nil)
+		assert.Equal(t, []string{"user.alice.>", "orders.created"}, set.Publish.Allow)
+	})
+
+	t.Run("client-supplied username does not select a grant", func(t *testing.T) {
+		result := AuthorizeResult{Verified: &VerifiedToken{Username: "eve"}}
+		set := c.resolvePermissions(result, "alice", nil)
+		assert.Equal(t, []string{"user.alice.>"}, set.Publish.Allow)
+	})
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// Account budget modes.
+const (
+	AccountBudgetOff     = "off"
+	AccountBudgetWarn    = "warn"
+	AccountBudgetEnforce = "enforce"
+)
+
+// AccountBudgetConfig configures the connection budget of the users' account.
+type AccountBudgetConfig struct {
+	// Mode is off, warn (log and count only) or enforce (deny).
+	Mode string
+	// Account is the account whose connections are counted; defaults to
+	// nats.audience.
+	Account string
+	// MaxConnections is the budget; requests are denied (or flagged) once
+	// the account has this many connections.
+	MaxConnections int
+	// Refresh is how often the connection count is fetched.
+	Refresh        time.Duration
+	RequestTimeout time.Duration
+}
+
+// LoadAccountBudgetConfig reads the account_budget.* settings. Mode and
+// max_connections are read on every request so they can change at runtime.
+func LoadAccountBudgetConfig() AccountBudgetConfig {
+	mode := strings.ToLower(strings.TrimSpace(viper.GetString("account_budget.mode")))
+	switch mode {
+	case AccountBudgetOff, AccountBudgetWarn, AccountBudgetEnforce:
+	default:
+		mode = AccountBudgetOff
+	}
+	account := viper.GetString("account_budget.account")
+	if account == "" {
+		account = viper.GetString("nats.audience")
+	}
+	return AccountBudgetConfig{
+		Mode:           mode,
+		Account:        account,
+		MaxConnections: viper.GetInt("account_budget.max_connections"),
+		Refresh:        viper.GetDuration("account_budget.refresh"),
+		RequestTimeout: viper.GetDuration("account_budget.request_timeout"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg AccountBudgetConfig) Validate() error {
+	switch {
+	case cfg.Mode == AccountBudgetOff:
+		return nil
+	case cfg.Account == "":
+		return fmt.Errorf("account_budget: account (or nats.audience) is required")
+	case cfg.MaxConnections <= 0:
+		return fmt.Errorf("account_budget: max_connections must be > 0")
+	case cfg.Refresh <= 0 || cfg.RequestTimeout <= 0:
+		return fmt.Errorf("account_budget: refresh and request_timeout must be > 0")
+	}
+	return nil
+}
+
+// AccountBudget keeps the connection count of the users' account, fetched
+// in the background via $SYS.REQ.ACCOUNT.PING.STATZ, so checking the budget
+// never adds a system request to the auth path.
+type AccountBudget struct {
+	cfg    AccountBudgetConfig
+	gather gatherFunc
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	conns   int
+	updated time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewAccountBudget creates the budget; call Start to begin refreshing.
+func NewAccountBudget(cfg AccountBudgetConfig, gather gatherFunc) *AccountBudget {
+	return &AccountBudget{
+		cfg:    cfg,
+		gather: gather,
+		logger: slog.With("component", "account_budget"),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start refreshes the count immediately and then every refresh interval.
+func (b *AccountBudget) Start() {
+	b.logger.Info("Tracking account connections", "account", b.cfg.Account, "refresh", b.cfg.Refresh)
+	b.done.Add(1)
+	go func() {
+		defer b.done.Done()
+		ticker := time.NewTicker(b.cfg.Refresh)
+		defer ticker.Stop()
+		for {
+			if err := b.Refresh(time.Now()); err != nil {
+				b.logger.Warn("Failed to refresh account connection count", "error", err)
+			}
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends refreshing.
+func (b *AccountBudget) Stop() {
+	close(b.stop)
+	b.done.Wait()
+}
+
+// accountStatz is the part of a STATZ response the budget needs.
+type accountStatz struct {
+	Accounts []struct {
+		Account string `json:"acc"`
+		Conns   int    `json:"conns"`
+	} `json:"account_statz"`
+}
+
+// Refresh sums the account's client connections over every server.
+func (b *AccountBudget) Refresh(now time.Time) error {
+	query, _ := json.Marshal(map[string]any{"accounts": []string{b.cfg.Account}})
+	responses, err := b.gather("$SYS.REQ.ACCOUNT.PING.STATZ", query, b.cfg.RequestTimeout)
+	if err != nil {
+		return err
+	}
+	if len(responses) == 0 {
+		return errors.New("no server answered the account statistics request (is the connection in the system account?)")
+	}
+
+	total := 0
+	for _, resp := range responses {
+		// A partial count would understate usage; keep the previous one.
+		if resp.Error != nil {
+			return fmt.Errorf("server %s: %s", resp.Server.ID, resp.Error.Description)
+		}
+		var statz accountStatz
+		if err := json.Unmarshal(resp.Data, &statz); err != nil {
+			return fmt.Errorf("server %s: %w", resp.Server.ID, err)
+		}
+		for _, acc := range statz.Accounts {
+			if acc.Account == b.cfg.Account {
+				total += acc.Conns
+			}
+		}
+	}
+
+	b.mu.Lock()
+	b.conns, b.updated = total, now
+	b.mu.Unlock()
+	accountConnections.Set(float64(total))
+	return nil
+}
+
+// Connections returns the last known connection count and whether it is
+// recent enough (at most three refresh intervals old) to act on.
+func (b *AccountBudget) Connections(now time.Time) (int, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	fresh := !b.updated.IsZero() && now.Sub(b.updated) <= 3*b.cfg.Refresh
+	return b.conns, fresh
+}
+
+// initAccountBudget optionally starts tracking the account's connections.
+func (c *NATSClient) initAccountBudget() error {
+	cfg := LoadAccountBudgetConfig()
+	if cfg.Mode == AccountBudgetOff {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.accountBudget = NewAccountBudget(cfg, c.gatherResponses)
+	c.accountBudget.Start()
+	return nil
+}
+
+// checkAccountBudget reports whether the request must be denied because the
+// account is at its connection budget. Without a fresh count it fails open:
+// the budget is backpressure, not a security control.
+func (c *NATSClient) checkAccountBudget(username string) bool {
+	if c.accountBudget == nil {
+		return false
+	}
+	cfg := LoadAccountBudgetConfig()
+	if cfg.Mode == AccountBudgetOff || cfg.MaxConnections <= 0 {
+		return false
+	}
+	conns, fresh := c.accountBudget.Connections(time.Now())
+	if !fresh {
+		c.logger.Debug("Account connection count is stale, budget not applied", "username", username)
+		return false
+	}
+	if conns < cfg.MaxConnections {
+		return false
+	}
+
+	accountBudgetExceededTotal.WithLabelValues(cfg.Mode).Inc()
+	c.logger.Warn("Account is at its connection budget",
+		"username", username,
+		"account", cfg.Account,
+		"connections", conns,
+		"max_connections", cfg.MaxConnections,
+		"mode", cfg.Mode,
+	)
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "account_budget",
+		Message:  "Account at connection budget",
+		Level:    sentry.LevelWarning,
+		Data:     map[string]interface{}{"connections": conns, "max_connections": cfg.MaxConnections, "mode": cfg.Mode},
+	})
+	return cfg.Mode == AccountBudgetEnforce
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statzResponse(t *testing.T, serverID string, conns map[string]int) serverAPIResponse {
+	t.Helper()
+	var statz struct {
+		Accounts []map[string]any `json:"account_statz"`
+	}
+	for acc, n := range conns {
+		statz.Accounts = append(statz.Accounts, map[string]any{"acc": acc, "conns": n})
+	}
+	raw, err := json.Marshal(statz)
+	require.NoError(t, err)
+
+	var resp serverAPIResponse
+	resp.Server.ID = serverID
+	resp.Data = raw
+	return resp
+}
+
+func TestAccountBudget_RefreshSumsServers(t *testing.T) {
+	responses := []serverAPIResponse{
+		statzResponse(t, "S1", map[string]int{"APP": 40, "OTHER": 1000}),
+		statzResponse(t, "S2", map[string]int{"APP": 2}),
+	}
+	b := NewAccountBudget(AccountBudgetConfig{Account: "APP", Refresh: time.Second, RequestTimeout: time.Second},
+		func(subject string, _ []byte, _ time.Duration) ([]serverAPIResponse, error) {
+			assert.Equal(t, "$SYS.REQ.ACCOUNT.PING.STATZ", subject)
+			return responses, nil
+		})
+
+	now := time.Now()
+	_, fresh := b.Connections(now)
+	assert.False(t, fresh, "no count before the first refresh")
+
+	require.NoError(t, b.Refresh(now))
+	conns, fresh := b.Connections(now.Add(time.Second))
+	assert.Equal(t, 42, conns)
+	assert.True(t, fresh)
+
+	_, fresh = b.Connections(now.Add(4 * time.Second))
+	assert.False(t, fresh)
+
+	// A failing server keeps the previous count.
+	failed := serverAPIResponse{}
+	failed.Server.ID = "S3"
+	failed.Error = &struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	}{Code: 500, Description: "boom"}
+	responses = append(responses, failed)
+	assert.ErrorContains(t, b.Refresh(now.Add(time.Second)), "server S3: boom")
+	conns, _ = b.Connections(now)
+	assert.Equal(t, 42, conns)
+}
+
+func TestCheckAccountBudget(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.audience", "APP")
+	viper.Set("account_budget.mode", AccountBudgetEnforce)
+	viper.Set("account_budget.max_connections", 10)
+
+	count := 9
+	b := NewAccountBudget(AccountBudgetConfig{Account: "APP", Refresh: time.Minute}, func(string, []byte, time.Duration) ([]serverAPIResponse, error) {
+		return []serverAPIResponse{statzResponse(t, "S1", map[string]int{"APP": count})}, nil
+	})
+	c := &NATSClient{logger: slog.Default(), accountBudget: b}
+
+	assert.False(t, c.checkAccountBudget("alice"), "stale count fails open")
+
+	require.NoError(t, b.Refresh(time.Now()))
+	assert.False(t, c.checkAccountBudget("alice"))
+
+	count = 10
+	require.NoError(t, b.Refresh(time.Now()))
+	assert.True(t, c.checkAccountBudget("alice"))
+
+	viper.Set("account_budget.mode", AccountBudgetWarn)
+	assert.False(t, c.checkAccountBudget("alice"), "warn only counts")
+
+	assert.False(t, (&NATSClient{logger: slog.Default()}).checkAccountBudget("alice"), "disabled")
+}
+
+func TestAccountBudgetConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.audience", "APP")
+	viper.Set("account_budget.mode", "bogus")
+
+	cfg := LoadAccountBudgetConfig()
+	assert.Equal(t, AccountBudgetOff, cfg.Mode, "unknown modes never enforce")
+	assert.Equal(t, "APP", cfg.Account)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Mode = AccountBudgetEnforce
+	assert.ErrorContains(t, cfg.Validate(), "max_connections")
+	cfg.MaxConnections, cfg.Refresh, cfg.RequestTimeout = 100, time.Second, time.Second
+	assert.NoError(t, cfg.Validate())
+}
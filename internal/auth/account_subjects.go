@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// inboxSubjects are always valid in an account, since request/reply relies on them.
+var inboxSubjects = []string{"_INBOX.>"}
+
+// LoadAccountSubjects returns the subjects valid in the users' account, built
+// from the configured nats.account.exports and nats.account.imports. An empty
+// result means the feature is disabled and permissions are issued as configured.
+func LoadAccountSubjects() []string {
+	exports := viper.GetStringSlice("nats.account.exports")
+	imports := viper.GetStringSlice("nats.account.imports")
+	if len(exports) == 0 && len(imports) == 0 {
+		return nil
+	}
+
+	out := make([]string, 0, len(exports)+len(imports)+len(inboxSubjects))
+	out = append(out, exports...)
+	out = append(out, imports...)
+	out = append(out, inboxSubjects...)
+	return out
+}
+
+// intersectSubjects returns the subject matched by both a and b, if any.
+// Wildcards follow NATS semantics: "*" matches exactly one token and ">"
+// matches one or more trailing tokens.
+func intersectSubjects(a, b string) (string, bool) {
+	at := strings.Split(a, ".")
+	bt := strings.Split(b, ".")
+
+	out := make([]string, 0, max(len(at), len(bt)))
+	for i := 0; ; i++ {
+		switch {
+		case i == len(at) && i == len(bt):
+			return strings.Join(out, "."), true
+		case i == len(at) || i == len(bt):
+			return "", false
+		case at[i] == ">":
+			return strings.Join(append(out, bt[i:]...), "."), true
+		case bt[i] == ">":
+			return strings.Join(append(out, at[i:]...), "."), true
+		case at[i] == "*":
+			out = append(out, bt[i])
+		case bt[i] == "*" || at[i] == bt[i]:
+			out = append(out, at[i])
+		default:
+			return "", false
+		}
+	}
+}
+
+// intersectWithAccount narrows a granted subject to the parts valid in the
+// account. A grant may map to several account subjects (e.g. ">" against a
+// list of exports), or to none at all.
+func intersectWithAccount(subject string, accountSubjects []string) []string {
+	var out []string
+	for _, as := range accountSubjects {
+		if s, ok := intersectSubjects(subject, as); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ValidateAccountSubjects reports allow entries that cannot match any subject
+// valid in the account. Templates are rendered with a placeholder username,
+// with and without scopes.
+func ValidateAccountSubjects(accountSubjects []string) []string {
+	if len(accountSubjects) == 0 {
+		return nil
+	}
+
+	var unusable []string
+	for _, list := range configuredAllowLists() {
+		for _, subject := range list.subjects {
+			for _, rendered := range placeholderRenders(subject) {
+				if len(intersectWithAccount(rendered, accountSubjects)) == 0 {
+					unusable = append(unusable, fmt.Sprintf("%s: %q", list.key, subject))
+					break
+				}
+			}
+		}
+	}
+	return unusable
+}
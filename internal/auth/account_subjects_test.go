@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAccountSubjects(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	assert.Nil(t, LoadAccountSubjects())
+
+	viper.Set("nats.account.exports", []string{"orders.>"})
+	viper.Set("nats.account.imports", []string{"billing.events.*"})
+	assert.Equal(t, []string{"orders.>", "billing.events.*", "_INBOX.>"}, LoadAccountSubjects())
+}
+
+func TestIntersectSubjects(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+		ok   bool
+	}{
+		{"orders.>", "orders.>", "orders.>", true},
+		{">", "orders.created", "orders.created", true},
+		{"orders.*", "orders.created", "orders.created", true},
+		{"orders.*.eu", "orders.created.*", "orders.created.eu", true},
+		{"orders.>", "orders", "", false},
+		{"orders.*", "orders.a.b", "", false},
+		{"user.alice.>", "orders.>", "", false},
+		{"a.b", "a.c", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := intersectSubjects(tt.a, tt.b)
+		assert.Equal(t, tt.ok, ok, "%s ∩ %s", tt.a, tt.b)
+		assert.Equal(t, tt.want, got, "%s ∩ %s", tt.a, tt.b)
+
+		// Intersection is symmetric.
+		got, ok = intersectSubjects(tt.b, tt.a)
+		assert.Equal(t, tt.ok, ok, "%s ∩ %s", tt.b, tt.a)
+		assert.Equal(t, tt.want, got, "%s ∩ %s", tt.b, tt.a)
+	}
+}
+
+func TestValidateAccountSubjects(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"orders.{{.Username}}.>", "legacy.>"})
+
+	assert.Nil(t, ValidateAccountSubjects(nil))
+	assert.Equal(t,
+		[]string{`nats.permissions.publish.allow: "legacy.>"`},
+		ValidateAccountSubjects([]string{"orders.>", "_INBOX.>"}),
+	)
+}
+
+func TestRestrictPermissions_IntersectsWithAccountSubjects(t *testing.T) {
+	c := &NATSClient{
+		logger:          slog.Default(),
+		accountSubjects: []string{"orders.>", "_INBOX.>"},
+	}
+
+	set := c.restrictPermissions(AllowOnly(">", "legacy.>"), "alice")
+
+	assert.Equal(t, []string{"orders.>", "_INBOX.>"}, set.Publish.Allow)
+}
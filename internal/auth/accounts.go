@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+)
+
+// AccountMapping issues the members of a top-level GitLab group into a NATS
+// account.
+type AccountMapping struct {
+	Group string `mapstructure:"group"`
+	// Account is the account name (the JWT audience) or, with a signing
+	// key, the account's public key.
+	Account string `mapstructure:"account"`
+	// SigningKeySeed is a signing key of the account (operator mode). User
+	// JWTs of the account are signed with it instead of the issuer key.
+	SigningKeySeed string `mapstructure:"signing_key_seed"`
+}
+
+// LoadAccountMappings reads accounts.groups.
+func LoadAccountMappings() ([]AccountMapping, error) {
+	var mappings []AccountMapping
+	if err := viper.UnmarshalKey("accounts.groups", &mappings); err != nil {
+		return nil, fmt.Errorf("invalid accounts.groups: %w", err)
+	}
+	return mappings, nil
+}
+
+// accountsConfigured reports whether accounts are selected by group, which
+// needs the token owner's groups.
+func accountsConfigured() bool {
+	mappings, _ := LoadAccountMappings()
+	return len(mappings) > 0
+}
+
+// userAccount is the account a user JWT is issued into.
+type userAccount struct {
+	Name string
+	// Signer signs the user JWTs of the account; nil signs them with the
+	// issuer key.
+	Signer Signer
+}
+
+// accountSelector selects the account of a user from their top-level
+// GitLab groups.
+type accountSelector struct {
+	// groups maps lowercased group paths to accounts.
+	groups map[string]userAccount
+}
+
+// newAccountSelector validates the mappings and parses their signing keys.
+func newAccountSelector(mappings []AccountMapping) (*accountSelector, error) {
+	s := &accountSelector{groups: make(map[string]userAccount, len(mappings))}
+	for i, m := range mappings {
+		key := fmt.Sprintf("accounts.groups[%d]", i)
+		group := strings.ToLower(strings.TrimSpace(m.Group))
+		switch {
+		case group == "":
+			return nil, fmt.Errorf("%s: group is required", key)
+		case m.Account == "":
+			return nil, fmt.Errorf("%s (%s): account is required", key, m.Group)
+		}
+		if _, dup := s.groups[group]; dup {
+			return nil, fmt.Errorf("%s: group %q is mapped twice", key, m.Group)
+		}
+		account := userAccount{Name: m.Account}
+		if m.SigningKeySeed != "" {
+			signer, err := accountSigner(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s (%s): %w", key, m.Group, err)
+			}
+			account.Signer = signer
+		}
+		s.groups[group] = account
+	}
+	return s, nil
+}
+
+// accountSigner parses the signing key of an operator-mode account.
+func accountSigner(m AccountMapping) (Signer, error) {
+	if !nkeys.IsValidPublicAccountKey(m.Account) {
+		return nil, fmt.Errorf("account must be the account's public key when a signing key is set")
+	}
+	kp, err := nkeys.FromSeed([]byte(m.SigningKeySeed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing_key_seed: %w", err)
+	}
+	if pub, err := kp.PublicKey(); err != nil || !nkeys.IsValidPublicAccountKey(pub) {
+		return nil, fmt.Errorf("signing_key_seed is not an account seed")
+	}
+	return NewKeyPairSigner(kp), nil
+}
+
+// Select returns the account of the first (sorted, lowercased) group
+// mapped to one.
+func (s *accountSelector) Select(groups []string) (userAccount, bool) {
+	if s == nil {
+		return userAccount{}, false
+	}
+	return selectByGroup(s.groups, groups)
+}
+
+// selectByGroup returns the value mapped to the first of groups, sorted and
+// lowercased, so users in several mapped groups always get the same one.
+// The keys of mapped are lowercased group paths.
+func selectByGroup[T any](mapped map[string]T, groups []string) (T, bool) {
+	var zero T
+	if len(mapped) == 0 {
+		return zero, false
+	}
+	lowered := make([]string, len(groups))
+	for i, g := range groups {
+		lowered[i] = strings.ToLower(g)
+	}
+	slices.Sort(lowered)
+	for _, g := range lowered {
+		if v, ok := mapped[g]; ok {
+			return v, true
+		}
+	}
+	return zero, false
+}
+
+// fingerprint describes the mappings for the JWT cache config hash: the
+// accounts and their signing public keys, never the seeds.
+func (s *accountSelector) fingerprint() map[string]string {
+	if s == nil {
+		return nil
+	}
+	out := make(map[string]string, len(s.groups))
+	for group, account := range s.groups {
+		out[group] = account.Name
+		if account.Signer != nil {
+			pub, _ := account.Signer.PublicKey()
+			out[group] += "/" + pub
+		}
+	}
+	return out
+}
+
+// userAccount returns the account a user JWT is issued into: the account
+// of the user's top-level group, else nats.audience signed by the issuer.
+func (c *NATSClient) userAccount(result AuthorizeResult) userAccount {
+	if account, ok := c.accounts.Select(result.Groups()); ok {
+		return account
+	}
+	return userAccount{Name: viper.GetString("nats.audience")}
+}
+
+// initAccounts optionally maps top-level GitLab groups to accounts.
+func (c *NATSClient) initAccounts() error {
+	mappings, err := LoadAccountMappings()
+	if err != nil || len(mappings) == 0 {
+		return err
+	}
+	accounts, err := newAccountSelector(mappings)
+	if err != nil {
+		return err
+	}
+	c.accounts = accounts
+	c.logger.Info("Selecting accounts from GitLab groups", "groups", len(mappings))
+	return nil
+}
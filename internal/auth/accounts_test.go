@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/callout"
+)
+
+func TestNewAccountSelector_Validation(t *testing.T) {
+	_, _, accountPub := newAccountKey(t)
+	_, signingSeed, _ := newAccountKey(t)
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userSeed, _ := user.Seed()
+
+	for name, tc := range map[string]struct {
+		mappings []AccountMapping
+		err      string
+	}{
+		"missing group":      {[]AccountMapping{{Account: "A"}}, "group is required"},
+		"missing account":    {[]AccountMapping{{Group: "payments"}}, "account is required"},
+		"duplicate group":    {[]AccountMapping{{Group: "payments", Account: "A"}, {Group: "Payments", Account: "B"}}, "mapped twice"},
+		"account not a key":  {[]AccountMapping{{Group: "payments", Account: "PAYMENTS", SigningKeySeed: signingSeed}}, "public key"},
+		"invalid seed":       {[]AccountMapping{{Group: "payments", Account: accountPub, SigningKeySeed: "SAnope"}}, "invalid signing_key_seed"},
+		"not an account key": {[]AccountMapping{{Group: "payments", Account: accountPub, SigningKeySeed: string(userSeed)}}, "not an account seed"},
+	} {
+		_, err := newAccountSelector(tc.mappings)
+		assert.ErrorContains(t, err, tc.err, name)
+	}
+
+	_, err = newAccountSelector([]AccountMapping{{Group: "payments", Account: accountPub, SigningKeySeed: signingSeed}})
+	assert.NoError(t, err)
+}
+
+func TestAccountSelector_Select(t *testing.T) {
+	s, err := newAccountSelector([]AccountMapping{
+		{Group: "Payments", Account: "PAYMENTS"},
+		{Group: "logistics", Account: "LOGISTICS"},
+	})
+	require.NoError(t, err)
+
+	account, ok := s.Select([]string{"payments"})
+	require.True(t, ok)
+	assert.Equal(t, "PAYMENTS", account.Name)
+	assert.Nil(t, account.Signer)
+
+	account, ok = s.Select([]string{"ops", "Payments", "logistics"})
+	require.True(t, ok)
+	assert.Equal(t, "LOGISTICS", account.Name, "the first mapped group in alphabetical order wins")
+
+	_, ok = s.Select([]string{"ops"})
+	assert.False(t, ok)
+	_, ok = (*accountSelector)(nil).Select([]string{"payments"})
+	assert.False(t, ok)
+}
+
+func TestUserAccount(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.audience", "APP")
+	_, _, accountPub := newAccountKey(t)
+	_, signingSeed, _ := newAccountKey(t)
+	selector, err := newAccountSelector([]AccountMapping{{Group: "payments", Account: accountPub, SigningKeySeed: signingSeed}})
+	require.NoError(t, err)
+	c := &NATSClient{accounts: selector}
+
+	assert.Equal(t, userAccount{Name: "APP"}, c.userAccount(AuthorizeResult{Verified: &VerifiedToken{Groups: []string{"ops"}}}))
+
+	account := c.userAccount(AuthorizeResult{Verified: &VerifiedToken{Groups: []string{"payments"}}})
+	require.NotNil(t, account.Signer)
+	uc := callout.BuildUserClaims(callout.Identity{UserNkey: testUserNkey(t), Name: "alice", Audience: account.Name, IssuerAccount: account.Name}, callout.Policy{})
+	token, err := encodeClaims(uc, account.Signer)
+	require.NoError(t, err)
+
+	decoded, err := jwt.DecodeUserClaims(token)
+	require.NoError(t, err)
+	assert.Equal(t, accountPub, decoded.IssuerAccount)
+	signingPub, _ := account.Signer.PublicKey()
+	assert.Equal(t, signingPub, decoded.Issuer, "signed with the account's signing key")
+}
+
+func testUserNkey(t *testing.T) string {
+	t.Helper()
+	kp, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	pub, err := kp.PublicKey()
+	require.NoError(t, err)
+	return pub
+}
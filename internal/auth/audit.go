@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"cmp"
+	"log/slog"
+	"sync"
+	"time"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// Audit event kinds.
+const (
+	// AuditKindAdmin marks security-relevant administrative actions.
+	AuditKindAdmin = "admin"
+	// AuditKindDecision marks authorization decisions.
+	AuditKindDecision = "decision"
+)
+
+// auditLogger records security-relevant administrative actions. Entries
+// use the "audit" component so they can be routed separately from regular logs.
+var auditLogger = slog.With("component", "audit")
+
+// AuditEvent is a security event exported to the configured audit sinks.
+type AuditEvent struct {
+	Time    time.Time      `json:"time"`
+	Kind    string         `json:"kind"`
+	Action  string         `json:"action"`
+	Outcome string         `json:"outcome"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// AuditSink receives audit events. Export must not block the caller.
+type AuditSink interface {
+	Export(event AuditEvent)
+}
+
+var auditSinks struct {
+	mu    sync.RWMutex
+	sinks []AuditSink
+}
+
+// AddAuditSink registers a sink for all subsequent audit events.
+func AddAuditSink(sink AuditSink) {
+	auditSinks.mu.Lock()
+	defer auditSinks.mu.Unlock()
+	auditSinks.sinks = append(auditSinks.sinks, sink)
+}
+
+// exportAuditEvent hands an event to every registered sink.
+func exportAuditEvent(event AuditEvent) {
+	auditSinks.mu.RLock()
+	defer auditSinks.mu.RUnlock()
+	for _, sink := range auditSinks.sinks {
+		sink.Export(event)
+	}
+}
+
+// auditAttrs converts slog-style key/value pairs into a map. Errors are
+// stored as their message so the map can be serialized.
+func auditAttrs(attrs []any) map[string]any {
+	data := map[string]any{}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		if err, isErr := attrs[i+1].(error); isErr && err != nil {
+			data[key] = err.Error()
+		} else {
+			data[key] = attrs[i+1]
+		}
+	}
+	return data
+}
+
+// audit records an administrative action with its outcome and details, and
+// leaves a Sentry breadcrumb so the action shows up next to related errors.
+func audit(action, outcome string, attrs ...any) {
+	auditLogger.Warn("Audit event", append([]any{"action", action, "outcome", outcome}, attrs...)...)
+
+	data := auditAttrs(attrs)
+	exportAuditEvent(AuditEvent{Time: time.Now().UTC(), Kind: AuditKindAdmin, Action: action, Outcome: outcome, Attrs: data})
+
+	breadcrumb := map[string]interface{}{"outcome": outcome}
+	for k, v := range data {
+		breadcrumb[k] = v
+	}
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "audit",
+		Message:  action,
+		Level:    sentry.LevelWarning,
+		Data:     breadcrumb,
+	})
+}
+
+// authDecision describes the outcome of one authorization request.
+type authDecision struct {
+	Username string
+	ServerID string
+	ClientIP string
+	Allowed  bool
+	Code     DenyCode
+	// Reason is the built-in message of a denial.
+	Reason      string
+	Source      string
+	MonitorOnly bool
+	// Tags are the trusted client tags the grant was rendered with.
+	Tags map[string]string
+	// Account is the account the user JWT was issued into.
+	Account string
+	// Principal is the shared team principal the user was issued as; the
+	// audit trail is the only place it is linked to the username.
+	Principal string
+
+	// Received is when the handler got the request; the exported duration
+	// runs from there to the export, i.e. after the response was sent.
+	Received time.Time
+	// Queued is how long the request waited before it was received.
+	Queued time.Duration
+	// GitLab, TokenCache and Sign are the time spent in each dependency;
+	// zero when it was not called.
+	GitLab     time.Duration
+	TokenCache time.Duration
+	Sign       time.Duration
+}
+
+// durationMillis converts d to milliseconds with microsecond precision.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// decisionOutcome classifies a decision for the outcome metric: denials
+// caused by a failure on our side or upstream are errors, not denials.
+func decisionOutcome(d authDecision) string {
+	switch {
+	case d.Allowed:
+		return "allow"
+	case d.Code == DenyAuthError || d.Code == DenyInvalidClaims || d.Code == DenyInternalError:
+		return "error"
+	}
+	return "deny"
+}
+
+// exportDecision counts an authorization decision and sends it to the audit
+// sinks. Decisions are not logged here; the request handler already logs them.
+func exportDecision(d authDecision) {
+	authRequestsTotal.WithLabelValues(decisionOutcome(d), cmp.Or(d.Source, "none")).Inc()
+
+	outcome, attrs := "deny", map[string]any{"username": d.Username, "server_id": d.ServerID}
+	if d.Allowed {
+		outcome = "allow"
+		attrs["monitor_only"] = d.MonitorOnly
+	} else {
+		attrs["code"] = string(d.Code)
+		if d.Reason != "" {
+			attrs["reason"] = d.Reason
+		}
+	}
+	if d.ClientIP != "" {
+		attrs["client_ip"] = d.ClientIP
+	}
+	if d.Source != "" {
+		attrs["source"] = d.Source
+	}
+	if len(d.Tags) > 0 {
+		attrs["tags"] = d.Tags
+	}
+	if d.Account != "" {
+		attrs["account"] = d.Account
+	}
+	if d.Principal != "" {
+		attrs["principal"] = d.Principal
+	}
+	if !d.Received.IsZero() {
+		attrs["duration_ms"] = durationMillis(time.Since(d.Received))
+	}
+	for name, took := range map[string]time.Duration{"queued_ms": d.Queued, "gitlab_ms": d.GitLab, "token_cache_ms": d.TokenCache, "sign_ms": d.Sign} {
+		if took > 0 {
+			attrs[name] = durationMillis(took)
+		}
+	}
+	exportAuditEvent(AuditEvent{Time: time.Now().UTC(), Kind: AuditKindDecision, Action: "auth", Outcome: outcome, Attrs: attrs})
+}
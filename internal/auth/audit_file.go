@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// fileAuditQueue is the number of events the file sink buffers; events
+// arriving while it is full are dropped and counted.
+const fileAuditQueue = 4096
+
+// FileSinkConfig configures the JSON Lines audit file.
+type FileSinkConfig struct {
+	Enabled bool
+	Path    string
+	// Decisions also writes every authorization decision, not only
+	// administrative audit events.
+	Decisions bool
+}
+
+// LoadFileSinkConfig reads the audit.file.* settings.
+func LoadFileSinkConfig() FileSinkConfig {
+	return FileSinkConfig{
+		Enabled:   viper.GetBool("audit.file.enabled"),
+		Path:      viper.GetString("audit.file.path"),
+		Decisions: viper.GetBool("audit.file.decisions"),
+	}
+}
+
+// FileAuditSink appends audit events to a file, one JSON object per line.
+// Writes happen in the background, so a slow disk never delays
+// authentication.
+type FileAuditSink struct {
+	file      *os.File
+	decisions bool
+	logger    *slog.Logger
+
+	// mu guards closed; events is closed once, by Close.
+	mu     sync.RWMutex
+	closed bool
+	events chan AuditEvent
+	done   chan struct{}
+}
+
+// NewFileAuditSink opens (or creates) the file for appending and starts
+// writing.
+func NewFileAuditSink(cfg FileSinkConfig) (*FileAuditSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit.file: path is required")
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit.file: %w", err)
+	}
+
+	s := &FileAuditSink{
+		file:      f,
+		decisions: cfg.Decisions,
+		logger:    slog.With("component", "audit_file"),
+		events:    make(chan AuditEvent, fileAuditQueue),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	s.logger.Info("File audit sink enabled", "path", cfg.Path, "decisions", cfg.Decisions)
+	return s, nil
+}
+
+// Export queues an event for writing.
+func (s *FileAuditSink) Export(event AuditEvent) {
+	if event.Kind == AuditKindDecision && !s.decisions {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		auditExportErrorsTotal.WithLabelValues("file").Inc()
+		s.logger.Error("Audit file queue full, event dropped", "action", event.Action)
+	}
+}
+
+// run writes queued events, flushing whenever the queue runs empty.
+func (s *FileAuditSink) run() {
+	defer close(s.done)
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for event := range s.events {
+		if err := enc.Encode(event); err != nil {
+			auditExportErrorsTotal.WithLabelValues("file").Inc()
+			s.logger.Error("Failed to write audit event", "action", event.Action, "error", err)
+			continue
+		}
+		auditEventsExportedTotal.WithLabelValues("file").Inc()
+		if len(s.events) == 0 {
+			if err := w.Flush(); err != nil {
+				s.logger.Error("Failed to flush audit file", "error", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		s.logger.Error("Failed to flush audit file", "error", err)
+	}
+}
+
+// Close writes the queued events and closes the file. Events exported
+// afterwards are lost, so the sink is closed after the NATS client.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+	<-s.done
+	return s.file.Close()
+}
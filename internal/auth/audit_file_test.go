@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditFile(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"kind":"admin","action":"earlier"}`+"\n"), 0o600))
+
+	sink, err := NewFileAuditSink(FileSinkConfig{Enabled: true, Path: path, Decisions: true})
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sink.Export(AuditEvent{Time: now, Kind: AuditKindDecision, Action: "auth", Outcome: "deny", Attrs: map[string]any{"username": "jdoe"}})
+	sink.Export(AuditEvent{Time: now, Kind: AuditKindAdmin, Action: "issuer.rotate", Outcome: "ok"})
+	require.NoError(t, sink.Close())
+	sink.Export(AuditEvent{Kind: AuditKindAdmin, Action: "late"})
+
+	events := readAuditFile(t, path)
+	require.Len(t, events, 3, "events are appended")
+	assert.Equal(t, "earlier", events[0].Action)
+	assert.Equal(t, "auth", events[1].Action)
+	assert.Equal(t, "jdoe", events[1].Attrs["username"])
+	assert.Equal(t, "issuer.rotate", events[2].Action)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileAuditSink_WithoutDecisions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileAuditSink(FileSinkConfig{Enabled: true, Path: path})
+	require.NoError(t, err)
+	sink.Export(AuditEvent{Kind: AuditKindDecision, Action: "auth"})
+	sink.Export(AuditEvent{Kind: AuditKindAdmin, Action: "config.reload"})
+	require.NoError(t, sink.Close())
+
+	events := readAuditFile(t, path)
+	require.Len(t, events, 1)
+	assert.Equal(t, "config.reload", events[0].Action)
+}
+
+func TestNewAuditSinks_Validate(t *testing.T) {
+	_, err := NewFileAuditSink(FileSinkConfig{Enabled: true})
+	assert.Error(t, err)
+	_, err = NewFileAuditSink(FileSinkConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "missing", "audit.jsonl")})
+	assert.Error(t, err)
+	_, err = NewNATSAuditSink(nil, NATSSinkConfig{Enabled: true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKafkaSinkConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("audit.kafka.enabled", true)
+	viper.Set("audit.kafka.brokers", []string{"kafka-1:9093"})
+	viper.Set("audit.kafka.topic", "security.antal")
+	viper.Set("audit.kafka.sasl.mechanism", "SCRAM-SHA-512")
+	viper.Set("audit.kafka.tls.enabled", true)
+
+	cfg := LoadKafkaSinkConfig()
+	assert.Equal(t, []string{"kafka-1:9093"}, cfg.Brokers)
+	assert.Equal(t, "scram-sha-512", cfg.SASLMechanism)
+	assert.True(t, cfg.TLSEnabled)
+}
+
+func TestKafkaSinkConfig_SASL(t *testing.T) {
+	for _, mechanism := range []string{"plain", "scram-sha-256", "scram-sha-512"} {
+		m, err := KafkaSinkConfig{SASLMechanism: mechanism, SASLUsername: "u", SASLPassword: "p"}.saslMechanism()
+		require.NoError(t, err, mechanism)
+		assert.NotNil(t, m, mechanism)
+	}
+
+	m, err := KafkaSinkConfig{}.saslMechanism()
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = KafkaSinkConfig{SASLMechanism: "gssapi"}.saslMechanism()
+	assert.Error(t, err)
+}
+
+func TestKafkaSinkConfig_TLS(t *testing.T) {
+	tc, err := KafkaSinkConfig{}.tlsConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tc)
+
+	tc, err = KafkaSinkConfig{TLSEnabled: true}.tlsConfig()
+	require.NoError(t, err)
+	assert.Nil(t, tc.RootCAs, "system roots")
+
+	bad := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bad, []byte("not a certificate"), 0o600))
+	_, err = KafkaSinkConfig{TLSEnabled: true, TLSCAFile: bad}.tlsConfig()
+	assert.Error(t, err)
+}
+
+func TestNewKafkaAuditSink_Validation(t *testing.T) {
+	_, err := NewKafkaAuditSink(KafkaSinkConfig{Enabled: true, Topic: "t"})
+	assert.Error(t, err, "no brokers")
+
+	_, err = NewKafkaAuditSink(KafkaSinkConfig{Enabled: true, Brokers: []string{"kafka:9092"}, Topic: "t", SASLMechanism: "bogus"})
+	assert.Error(t, err)
+
+	sink, err := NewKafkaAuditSink(KafkaSinkConfig{Enabled: true, Brokers: []string{"kafka:9092"}, Topic: "t"})
+	require.NoError(t, err)
+	assert.NoError(t, sink.Close())
+}
+
+func TestKafkaAuditMessage(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	msg, err := kafkaAuditMessage(AuditEvent{Time: at, Kind: AuditKindDecision, Action: "auth", Outcome: "deny",
+		Attrs: map[string]any{"username": "jdoe", "code": "invalid_credentials"}})
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", string(msg.Key))
+	assert.Equal(t, at, msg.Time)
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(msg.Value, &decoded))
+	assert.Equal(t, "decision", decoded["kind"])
+	assert.Equal(t, "deny", decoded["outcome"])
+
+	msg, err = kafkaAuditMessage(AuditEvent{Time: at, Kind: AuditKindAdmin, Action: "issuer.rotate", Outcome: "ok"})
+	require.NoError(t, err)
+	assert.Equal(t, "issuer.rotate", string(msg.Key), "keyed by action without a username")
+}
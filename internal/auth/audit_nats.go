@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// NATSSinkConfig configures publishing audit events to a NATS subject.
+type NATSSinkConfig struct {
+	Enabled bool
+	Subject string
+	// Decisions also publishes every authorization decision, not only
+	// administrative audit events.
+	Decisions bool
+}
+
+// LoadNATSSinkConfig reads the audit.nats.* settings.
+func LoadNATSSinkConfig() NATSSinkConfig {
+	return NATSSinkConfig{
+		Enabled:   viper.GetBool("audit.nats.enabled"),
+		Subject:   viper.GetString("audit.nats.subject"),
+		Decisions: viper.GetBool("audit.nats.decisions"),
+	}
+}
+
+// NATSAuditSink publishes audit events as JSON to a subject. Publishing is
+// buffered by the NATS client and never waits for subscribers; collect the
+// events with a subscriber or a JetStream stream on the subject.
+type NATSAuditSink struct {
+	nc        *nats.Conn
+	subject   string
+	decisions bool
+	logger    *slog.Logger
+}
+
+// NewNATSAuditSink creates the sink on an established connection.
+func NewNATSAuditSink(nc *nats.Conn, cfg NATSSinkConfig) (*NATSAuditSink, error) {
+	if cfg.Subject == "" {
+		return nil, fmt.Errorf("audit.nats: subject is required")
+	}
+	return &NATSAuditSink{nc: nc, subject: cfg.Subject, decisions: cfg.Decisions, logger: slog.With("component", "audit_nats")}, nil
+}
+
+// Export publishes an event.
+func (s *NATSAuditSink) Export(event AuditEvent) {
+	if event.Kind == AuditKindDecision && !s.decisions {
+		return
+	}
+	if s.nc.IsClosed() {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err == nil {
+		err = s.nc.Publish(s.subject, data)
+	}
+	if err != nil {
+		auditExportErrorsTotal.WithLabelValues("nats").Inc()
+		s.logger.Error("Failed to publish audit event", "action", event.Action, "error", err)
+		return
+	}
+	auditEventsExportedTotal.WithLabelValues("nats").Inc()
+}
+
+// initAuditNATS optionally publishes audit events on the coordination
+// connection.
+func (c *NATSClient) initAuditNATS() error {
+	cfg := LoadNATSSinkConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	sink, err := NewNATSAuditSink(c.coordination(), cfg)
+	if err != nil {
+		return err
+	}
+	AddAuditSink(sink)
+	c.logger.Info("NATS audit sink enabled", "subject", cfg.Subject, "decisions", cfg.Decisions)
+	return nil
+}
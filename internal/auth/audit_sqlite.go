@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite" // pure Go SQLite driver, registered as "sqlite"
+)
+
+const (
+	// sqliteAuditQueue is the number of events the SQLite sink buffers;
+	// events arriving while it is full are dropped and counted.
+	sqliteAuditQueue = 4096
+	// sqliteAuditBatch caps the events written in one transaction.
+	sqliteAuditBatch = 256
+	// sqlitePruneInterval is how often records past their retention are
+	// deleted.
+	sqlitePruneInterval = time.Hour
+)
+
+// sqliteAuditSchema creates the tables on first use. Times are stored as
+// Unix nanoseconds so they sort and compare as numbers.
+const sqliteAuditSchema = `
+CREATE TABLE IF NOT EXISTS audit_events (
+	id      INTEGER PRIMARY KEY,
+	time_ns INTEGER NOT NULL,
+	kind    TEXT NOT NULL,
+	action  TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	attrs   TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_events_time ON audit_events (time_ns);
+CREATE TABLE IF NOT EXISTS usage_daily (
+	day      TEXT NOT NULL,
+	username TEXT NOT NULL,
+	outcome  TEXT NOT NULL,
+	count    INTEGER NOT NULL,
+	PRIMARY KEY (day, username, outcome)
+);
+`
+
+// SQLiteSinkConfig configures the embedded SQLite store of audit events and
+// usage stats, for single-node deployments without JetStream persistence.
+type SQLiteSinkConfig struct {
+	Enabled bool
+	Path    string
+	// Decisions also stores every authorization decision, not only
+	// administrative audit events. Usage stats are counted either way.
+	Decisions bool
+	// Retention and StatsRetention are how long audit events and daily
+	// usage stats are kept; 0 keeps them forever.
+	Retention      time.Duration
+	StatsRetention time.Duration
+}
+
+// LoadSQLiteSinkConfig reads the audit.sqlite.* settings.
+func LoadSQLiteSinkConfig() SQLiteSinkConfig {
+	return SQLiteSinkConfig{
+		Enabled:        viper.GetBool("audit.sqlite.enabled"),
+		Path:           viper.GetString("audit.sqlite.path"),
+		Decisions:      viper.GetBool("audit.sqlite.decisions"),
+		Retention:      viper.GetDuration("audit.sqlite.retention"),
+		StatsRetention: viper.GetDuration("audit.sqlite.stats_retention"),
+	}
+}
+
+// openAuditDB opens the SQLite database at path, creating it and its tables
+// when missing. WAL mode lets `antal audit export` read while the service
+// writes.
+func openAuditDB(path string) (*sql.DB, error) {
+	if path == "" {
+		return nil, errors.New("audit.sqlite: path is required")
+	}
+	dsn := "file:" + url.PathEscape(path) + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("audit.sqlite: %w", err)
+	}
+	// A single connection serializes the writers, as SQLite does anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteAuditSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("audit.sqlite: failed to create tables: %w", err)
+	}
+	return db, nil
+}
+
+// SQLiteAuditSink stores audit events and daily usage stats in an embedded
+// SQLite database. Writes happen in the background in batches, so a slow
+// disk never delays authentication, and records past their retention are
+// deleted hourly.
+type SQLiteAuditSink struct {
+	db     *sql.DB
+	cfg    SQLiteSinkConfig
+	logger *slog.Logger
+	now    func() time.Time
+
+	// mu guards closed; events is closed once, by Close.
+	mu     sync.RWMutex
+	closed bool
+	events chan AuditEvent
+	done   chan struct{}
+}
+
+// NewSQLiteAuditSink opens (or creates) the database and starts writing.
+func NewSQLiteAuditSink(cfg SQLiteSinkConfig) (*SQLiteAuditSink, error) {
+	db, err := openAuditDB(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteAuditSink{
+		db:     db,
+		cfg:    cfg,
+		logger: slog.With("component", "audit_sqlite"),
+		now:    time.Now,
+		events: make(chan AuditEvent, sqliteAuditQueue),
+		done:   make(chan struct{}),
+	}
+	s.prune()
+	go s.run()
+	s.logger.Info("SQLite audit store enabled", "path", cfg.Path, "decisions", cfg.Decisions,
+		"retention", cfg.Retention, "stats_retention", cfg.StatsRetention)
+	return s, nil
+}
+
+// Export queues an event for writing.
+func (s *SQLiteAuditSink) Export(event AuditEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		auditExportErrorsTotal.WithLabelValues("sqlite").Inc()
+		s.logger.Error("Audit store queue full, event dropped", "action", event.Action)
+	}
+}
+
+// run writes queued events in batches and prunes expired records.
+func (s *SQLiteAuditSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(sqlitePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case first, ok := <-s.events:
+			if !ok {
+				return
+			}
+			batch := []AuditEvent{first}
+		drain:
+			for len(batch) < sqliteAuditBatch {
+				select {
+				case event, ok := <-s.events:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, event)
+				default:
+					break drain
+				}
+			}
+			if err := s.write(batch); err != nil {
+				auditExportErrorsTotal.WithLabelValues("sqlite").Add(float64(len(batch)))
+				s.logger.Error("Failed to store audit events", "events", len(batch), "error", err)
+				continue
+			}
+			auditEventsExportedTotal.WithLabelValues("sqlite").Add(float64(len(batch)))
+		case <-ticker.C:
+			s.prune()
+		}
+	}
+}
+
+// write stores a batch in one transaction: the events, decisions only when
+// configured, and the usage stats of the decisions.
+func (s *SQLiteAuditSink) write(batch []AuditEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, event := range batch {
+		if event.Kind == AuditKindDecision {
+			username, _ := event.Attrs["username"].(string)
+			if _, err := tx.Exec(`INSERT INTO usage_daily (day, username, outcome, count) VALUES (?, ?, ?, 1)
+				ON CONFLICT (day, username, outcome) DO UPDATE SET count = count + 1`,
+				event.Time.UTC().Format(time.DateOnly), username, event.Outcome); err != nil {
+				return err
+			}
+			if !s.cfg.Decisions {
+				continue
+			}
+		}
+		var attrs []byte
+		if len(event.Attrs) > 0 {
+			if attrs, err = json.Marshal(event.Attrs); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO audit_events (time_ns, kind, action, outcome, attrs) VALUES (?, ?, ?, ?, ?)`,
+			event.Time.UnixNano(), event.Kind, event.Action, event.Outcome, string(attrs)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune deletes the events and usage stats past their retention.
+func (s *SQLiteAuditSink) prune() {
+	now := s.now().UTC()
+	if s.cfg.Retention > 0 {
+		res, err := s.db.Exec(`DELETE FROM audit_events WHERE time_ns < ?`, now.Add(-s.cfg.Retention).UnixNano())
+		if err != nil {
+			s.logger.Error("Failed to prune audit events", "error", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			s.logger.Info("Pruned audit events past retention", "events", n, "retention", s.cfg.Retention)
+		}
+	}
+	if s.cfg.StatsRetention > 0 {
+		cutoff := now.Add(-s.cfg.StatsRetention).Format(time.DateOnly)
+		if _, err := s.db.Exec(`DELETE FROM usage_daily WHERE day < ?`, cutoff); err != nil {
+			s.logger.Error("Failed to prune usage stats", "error", err)
+		}
+	}
+}
+
+// Close writes the queued events and closes the database. Events exported
+// afterwards are lost, so the sink is closed after the NATS client.
+func (s *SQLiteAuditSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+	<-s.done
+	return s.db.Close()
+}
+
+// openAuditExport opens an existing database for an export.
+func openAuditExport(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("audit.sqlite: %w", err)
+	}
+	return openAuditDB(path)
+}
+
+// UsageStat is the number of authorization decisions with one outcome for
+// one user on one day (UTC).
+type UsageStat struct {
+	Day      string `json:"day"`
+	Username string `json:"username"`
+	Outcome  string `json:"outcome"`
+	Count    int64  `json:"count"`
+}
+
+// ExportAuditEvents writes the events stored at path since the given time
+// to out as JSON Lines, in the format of the audit file, so they can be
+// archived or replayed with `antal replay`. It returns how many it wrote.
+func ExportAuditEvents(path string, since time.Time, out io.Writer) (int, error) {
+	db, err := openAuditExport(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = db.Close() }()
+
+	// UnixNano is undefined for the zero time, which exports everything.
+	sinceNs := int64(math.MinInt64)
+	if !since.IsZero() {
+		sinceNs = since.UnixNano()
+	}
+	rows, err := db.Query(`SELECT time_ns, kind, action, outcome, attrs FROM audit_events WHERE time_ns >= ? ORDER BY time_ns, id`,
+		sinceNs)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	enc := json.NewEncoder(out)
+	n := 0
+	for rows.Next() {
+		var (
+			timeNs int64
+			event  AuditEvent
+			attrs  string
+		)
+		if err := rows.Scan(&timeNs, &event.Kind, &event.Action, &event.Outcome, &attrs); err != nil {
+			return n, err
+		}
+		event.Time = time.Unix(0, timeNs).UTC()
+		if attrs != "" {
+			if err := json.Unmarshal([]byte(attrs), &event.Attrs); err != nil {
+				return n, fmt.Errorf("invalid attributes of a %s event: %w", event.Action, err)
+			}
+		}
+		if err := enc.Encode(event); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// ExportUsageStats writes the daily usage stats stored at path since the
+// given day to out as JSON Lines. It returns how many rows it wrote.
+func ExportUsageStats(path string, since time.Time, out io.Writer) (int, error) {
+	db, err := openAuditExport(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = db.Close() }()
+
+	rows, err := db.Query(`SELECT day, username, outcome, count FROM usage_daily WHERE day >= ? ORDER BY day, username, outcome`,
+		since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	enc := json.NewEncoder(out)
+	n := 0
+	for rows.Next() {
+		var stat UsageStat
+		if err := rows.Scan(&stat.Day, &stat.Username, &stat.Outcome, &stat.Count); err != nil {
+			return n, err
+		}
+		if err := enc.Encode(stat); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	day := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	sink, err := NewSQLiteAuditSink(SQLiteSinkConfig{Enabled: true, Path: path, Decisions: false})
+	require.NoError(t, err)
+	sink.Export(AuditEvent{Time: day, Kind: AuditKindAdmin, Action: "issuer.rotate", Outcome: "ok", Attrs: map[string]any{"reason": "leak"}})
+	sink.Export(AuditEvent{Time: day, Kind: AuditKindDecision, Action: "auth", Outcome: "allow", Attrs: map[string]any{"username": "alice"}})
+	sink.Export(AuditEvent{Time: day.Add(time.Hour), Kind: AuditKindDecision, Action: "auth", Outcome: "allow", Attrs: map[string]any{"username": "alice"}})
+	sink.Export(AuditEvent{Time: day.Add(24 * time.Hour), Kind: AuditKindDecision, Action: "auth", Outcome: "deny", Attrs: map[string]any{"username": "bob"}})
+	require.NoError(t, sink.Close())
+	sink.Export(AuditEvent{Time: day, Kind: AuditKindAdmin, Action: "late", Outcome: "ok"})
+
+	var out bytes.Buffer
+	n, err := ExportAuditEvents(path, time.Time{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "decisions are counted, not stored, without audit.sqlite.decisions")
+	var event AuditEvent
+	require.NoError(t, json.Unmarshal(out.Bytes(), &event))
+	assert.Equal(t, AuditEvent{Time: day, Kind: AuditKindAdmin, Action: "issuer.rotate", Outcome: "ok", Attrs: map[string]any{"reason": "leak"}}, event)
+
+	out.Reset()
+	n, err = ExportAuditEvents(path, day.Add(time.Nanosecond), &out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "only events since the given time")
+
+	out.Reset()
+	n, err = ExportUsageStats(path, time.Time{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	var stats []UsageStat
+	for sc := bufio.NewScanner(&out); sc.Scan(); {
+		var stat UsageStat
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &stat))
+		stats = append(stats, stat)
+	}
+	assert.Equal(t, []UsageStat{
+		{Day: "2026-10-14", Username: "alice", Outcome: "allow", Count: 2},
+		{Day: "2026-10-15", Username: "bob", Outcome: "deny", Count: 1},
+	}, stats)
+
+	out.Reset()
+	n, err = ExportUsageStats(path, day.Add(24*time.Hour), &out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "only days since the given one")
+
+	_, err = ExportAuditEvents(filepath.Join(t.TempDir(), "missing.db"), time.Time{}, &out)
+	assert.Error(t, err, "exports do not create a database")
+}
+
+func TestSQLiteAuditSink_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	cfg := SQLiteSinkConfig{Enabled: true, Path: path, Decisions: true, Retention: 24 * time.Hour, StatsRetention: 48 * time.Hour}
+
+	sink, err := NewSQLiteAuditSink(cfg)
+	require.NoError(t, err)
+	sink.now = func() time.Time { return now }
+	for _, age := range []time.Duration{72 * time.Hour, 36 * time.Hour, time.Hour} {
+		sink.Export(AuditEvent{Time: now.Add(-age), Kind: AuditKindDecision, Action: "auth", Outcome: "allow", Attrs: map[string]any{"username": "alice"}})
+	}
+	require.NoError(t, sink.Close())
+
+	// Reopened, as after a restart, and pruned as on the hourly tick.
+	sink, err = NewSQLiteAuditSink(cfg)
+	require.NoError(t, err)
+	sink.now = func() time.Time { return now }
+	sink.prune()
+	require.NoError(t, sink.Close())
+
+	var out bytes.Buffer
+	n, err := ExportAuditEvents(path, time.Time{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "events older than a day are deleted")
+	out.Reset()
+	n, err = ExportUsageStats(path, time.Time{}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "stats of the last two days are kept")
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *recordingSink) Export(event AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+// withAuditSink registers a recording sink for the duration of the test.
+func withAuditSink(t *testing.T) *recordingSink {
+	t.Helper()
+	auditSinks.mu.Lock()
+	saved := auditSinks.sinks
+	auditSinks.sinks = nil
+	auditSinks.mu.Unlock()
+	t.Cleanup(func() {
+		auditSinks.mu.Lock()
+		auditSinks.sinks = saved
+		auditSinks.mu.Unlock()
+	})
+
+	sink := &recordingSink{}
+	AddAuditSink(sink)
+	return sink
+}
+
+func TestAudit_ExportsEvents(t *testing.T) {
+	sink := withAuditSink(t)
+
+	audit("issuer.rotate", "failed", "reason", "leak", "error", errors.New("boom"))
+
+	require.Len(t, sink.events, 1)
+	e := sink.events[0]
+	assert.Equal(t, AuditKindAdmin, e.Kind)
+	assert.Equal(t, "issuer.rotate", e.Action)
+	assert.Equal(t, "failed", e.Outcome)
+	assert.Equal(t, map[string]any{"reason": "leak", "error": "boom"}, e.Attrs)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestExportDecision(t *testing.T) {
+	sink := withAuditSink(t)
+
+	exportDecision(authDecision{Username: "jdoe", ServerID: "NSRV", Code: DenyInvalidCredentials, Source: "gitlab"})
+	exportDecision(authDecision{Username: "jdoe", ServerID: "NSRV", Allowed: true, MonitorOnly: true, Source: "cache"})
+
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, AuditKindDecision, sink.events[0].Kind)
+	assert.Equal(t, "deny", sink.events[0].Outcome)
+	assert.Equal(t, map[string]any{"username": "jdoe", "server_id": "NSRV", "code": "invalid_credentials", "source": "gitlab"}, sink.events[0].Attrs)
+
+	assert.Equal(t, "allow", sink.events[1].Outcome)
+	assert.Equal(t, map[string]any{"username": "jdoe", "server_id": "NSRV", "monitor_only": true, "source": "cache"}, sink.events[1].Attrs)
+}
+
+func TestExportDecision_ClientAndReason(t *testing.T) {
+	sink := withAuditSink(t)
+
+	exportDecision(authDecision{Username: "jdoe", ClientIP: "10.1.2.3", Code: DenyLockedOut, Reason: "too many failed attempts"})
+	exportDecision(authDecision{Username: "jdoe", ClientIP: "10.1.2.3", Allowed: true, Reason: "ignored"})
+
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, "10.1.2.3", sink.events[0].Attrs["client_ip"])
+	assert.Equal(t, "too many failed attempts", sink.events[0].Attrs["reason"])
+	assert.Equal(t, "10.1.2.3", sink.events[1].Attrs["client_ip"])
+	assert.NotContains(t, sink.events[1].Attrs, "reason", "only denials have a reason")
+}
+
+func TestExportDecision_Timings(t *testing.T) {
+	sink := withAuditSink(t)
+
+	exportDecision(authDecision{
+		Username: "jdoe", Allowed: true, Source: "gitlab",
+		Received: time.Now().Add(-time.Second),
+		Queued:   1500 * time.Microsecond,
+		GitLab:   120 * time.Millisecond,
+		Sign:     250 * time.Microsecond,
+	})
+
+	require.Len(t, sink.events, 1)
+	attrs := sink.events[0].Attrs
+	assert.GreaterOrEqual(t, attrs["duration_ms"], float64(1000))
+	assert.Equal(t, 1.5, attrs["queued_ms"])
+	assert.Equal(t, float64(120), attrs["gitlab_ms"])
+	assert.Equal(t, 0.25, attrs["sign_ms"])
+	assert.NotContains(t, attrs, "token_cache_ms", "dependencies that were not called are omitted")
+}
+
+func TestExportDecision_CountsOutcomes(t *testing.T) {
+	withAuditSink(t)
+	authRequestsTotal.Reset()
+
+	exportDecision(authDecision{Allowed: true, Source: "cache"})
+	exportDecision(authDecision{Code: DenyInvalidCredentials, Source: "gitlab"})
+	exportDecision(authDecision{Code: DenyAuthError, Source: "gitlab"})
+	exportDecision(authDecision{Code: DenyInvalidRequest})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(authRequestsTotal.WithLabelValues("allow", "cache")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(authRequestsTotal.WithLabelValues("deny", "gitlab")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(authRequestsTotal.WithLabelValues("error", "gitlab")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(authRequestsTotal.WithLabelValues("deny", "none")), "requests rejected before verification")
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// AttemptLimits are the auth.max_attempts_* settings: auth requests accepted
+// per username and per client IP within a sliding minute, before the token
+// is verified. 0 disables a limit.
+type AttemptLimits struct {
+	PerUser int
+	PerIP   int
+}
+
+// LoadAttemptLimits reads the auth.max_attempts_* settings. They are read on
+// every request so they can be changed at runtime.
+func LoadAttemptLimits() AttemptLimits {
+	return AttemptLimits{
+		PerUser: viper.GetInt("auth.max_attempts_per_minute"),
+		PerIP:   viper.GetInt("auth.max_attempts_per_ip_per_minute"),
+	}
+}
+
+// checkAuthAttempts counts an auth request against the attempt limits and
+// reports which one it exceeded ("username" or "ip"), or "". Unlike the
+// issuance limit it runs before GitLab is asked, so a credential-stuffing
+// storm is turned away without reaching GitLab or the issuer.
+func (c *NATSClient) checkAuthAttempts(username, host string) string {
+	limits := LoadAttemptLimits()
+	if c.attempts == nil || (limits.PerUser <= 0 && limits.PerIP <= 0) {
+		return ""
+	}
+	now := time.Now()
+
+	// The IP is checked first: a flood from one address should not use up
+	// the budget of every username it tries.
+	if host != "" && limits.PerIP > 0 {
+		if allowed, count := c.attempts.Allow("ip:"+host, limits.PerIP, now); !allowed {
+			c.attemptLimited("ip", username, host, count, limits.PerIP)
+			return "ip"
+		}
+	}
+	if username != "" && limits.PerUser > 0 {
+		if allowed, count := c.attempts.Allow("user:"+strings.ToLower(username), limits.PerUser, now); !allowed {
+			c.attemptLimited("username", username, host, count, limits.PerUser)
+			return "username"
+		}
+	}
+	return ""
+}
+
+func (c *NATSClient) attemptLimited(key, username, host string, count, limit int) {
+	authAttemptsLimitedTotal.WithLabelValues(key).Inc()
+	c.logger.Warn("Auth attempt rate exceeded",
+		"limit_key", key,
+		"username", username,
+		"client_host", host,
+		"attempts_last_minute", count,
+		"limit", limit,
+	)
+}
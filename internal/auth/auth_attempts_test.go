@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAuthAttempts(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	c := &NATSClient{logger: slog.Default(), attempts: newIssuanceLimiter()}
+	for range 5 {
+		assert.Empty(t, c.checkAuthAttempts("alice", "10.0.0.1"), "disabled by default")
+	}
+	assert.Empty(t, c.attempts.issued, "nothing is counted while disabled")
+
+	viper.Set("auth.max_attempts_per_minute", 2)
+	assert.Empty(t, c.checkAuthAttempts("alice", "10.0.0.1"))
+	assert.Empty(t, c.checkAuthAttempts("Alice", "10.0.0.2"))
+	before := testutil.ToFloat64(authAttemptsLimitedTotal.WithLabelValues("username"))
+	assert.Equal(t, "username", c.checkAuthAttempts("alice", "10.0.0.3"), "the username is limited across IPs, ignoring case")
+	assert.Equal(t, before+1, testutil.ToFloat64(authAttemptsLimitedTotal.WithLabelValues("username")))
+	assert.Empty(t, c.checkAuthAttempts("bob", "10.0.0.1"))
+	assert.Empty(t, c.checkAuthAttempts("", "10.0.0.1"), "requests without a username only count per IP")
+
+	viper.Set("auth.max_attempts_per_ip_per_minute", 3)
+	assert.Empty(t, c.checkAuthAttempts("carol", "10.0.0.9"))
+	assert.Empty(t, c.checkAuthAttempts("dave", "10.0.0.9"))
+	assert.Empty(t, c.checkAuthAttempts("erin", "10.0.0.9"))
+	assert.Equal(t, "ip", c.checkAuthAttempts("frank", "10.0.0.9"), "one IP is limited across usernames")
+	assert.NotContains(t, c.attempts.issued, "user:frank", "attempts denied by IP do not use up the username's budget")
+}
+
+func TestCheckAuthAttempts_NilLimiter(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auth.max_attempts_per_minute", 1)
+
+	c := &NATSClient{logger: slog.Default()}
+	assert.Empty(t, c.checkAuthAttempts("alice", ""))
+	assert.Empty(t, c.checkAuthAttempts("alice", ""))
+}
@@ -15,31 +15,74 @@ type GitLabVerifier interface {
 	VerifyTokenInfo(token string) (*VerifiedToken, error)
 }
 
+// contextVerifier is implemented by verifiers that stop at the context's
+// deadline, e.g. the remaining callout budget of a request.
+type contextVerifier interface {
+	VerifyTokenInfoContext(ctx context.Context, token string) (*VerifiedToken, error)
+}
+
+// verifyToken verifies the token within ctx where the verifier supports it.
+func verifyToken(ctx context.Context, verifier GitLabVerifier, token string) (*VerifiedToken, error) {
+	if cv, ok := verifier.(contextVerifier); ok {
+		return cv.VerifyTokenInfoContext(ctx, token)
+	}
+	return verifier.VerifyTokenInfo(token)
+}
+
 type AuthorizeResult struct {
 	Allow     bool
 	FromCache bool
+	// FromMemory is set when the token was verified moments ago and
+	// neither GitLab nor KV was asked (implies FromCache).
+	FromMemory bool
 	// Verified is populated when GitLab verification succeeded.
 	Verified *VerifiedToken
+	// Cached is populated when the decision was served from the token cache.
+	Cached *TokenCacheEntry
+	// UnknownToken is set when GitLab rejected the token as unknown, rather
+	// than expired or revoked.
+	UnknownToken bool
 	// CacheWriteErr is set when GitLab verification succeeds, but writing to KV fails.
 	// Authorization should still proceed (ALLOW) in that case.
 	CacheWriteErr error
+	// GitLabDuration and CacheDuration are the time spent verifying the
+	// token with GitLab and reading or writing the token cache.
+	GitLabDuration time.Duration
+	CacheDuration  time.Duration
 }
 
 // AuthorizeToken implements the strict authorization flow:
 //  1. Always call GitLab first.
 //  2. If GitLab returns invalid token (401): deny immediately, do not check cache.
-//  3. If GitLab returns timeout/network/5xx: fallback to token cache (JetStream KV).
+//  3. If GitLab returns timeout/network/5xx/429: fallback to token cache (JetStream KV).
 //  4. Cache hit (and not expired via KV TTL): allow.
+//
+// With the in-memory layer (token_cache.memory), a token GitLab verified
+// within the last seconds is allowed before step 1.
 func AuthorizeToken(ctx context.Context, token string, verifier GitLabVerifier, cache TokenCache, now func() time.Time) (AuthorizeResult, error) {
-	vt, err := verifier.VerifyTokenInfo(token)
+	if recent, ok := cache.(recentVerifications); ok {
+		if entry, ok := recent.Recent(token); ok {
+			return AuthorizeResult{Allow: true, FromCache: true, FromMemory: true, Cached: entry}, nil
+		}
+	}
+
+	started := now()
+	vt, err := verifyToken(ctx, verifier, token)
+	gitlabDuration := now().Sub(started)
 	if err == nil {
-		res := AuthorizeResult{Allow: true, Verified: vt}
+		res := AuthorizeResult{Allow: true, Verified: vt, GitLabDuration: gitlabDuration}
 		if cache != nil {
+			cacheStarted := now()
 			err := cache.Put(ctx, token, TokenCacheEntry{
+				UserID:         vt.UserID,
 				Username:       vt.Username,
 				Scopes:         strings.Join(vt.Scopes, ","),
+				Groups:         strings.Join(vt.Groups, ","),
+				TokenType:      vt.TokenType,
+				Project:        vt.Project,
 				LastVerifiedAt: now().UTC().Format(time.RFC3339),
 			})
+			res.CacheDuration = now().Sub(cacheStarted)
 			if err != nil {
 				res.CacheWriteErr = err
 			}
@@ -47,21 +90,97 @@ func AuthorizeToken(ctx context.Context, token string, verifier GitLabVerifier,
 		return res, nil
 	}
 	if errors.Is(err, ErrInvalidToken) {
-		return AuthorizeResult{Allow: false}, nil
+		return AuthorizeResult{Allow: false, UnknownToken: errors.Is(err, errUnknownToken), GitLabDuration: gitlabDuration}, nil
 	}
 
 	if cache != nil && isFallbackToCacheError(err) {
-		_, cErr := cache.Get(ctx, token)
+		cacheStarted := now()
+		entry, cErr := cache.Get(ctx, token)
+		cacheDuration := now().Sub(cacheStarted)
 		if cErr == nil {
-			return AuthorizeResult{Allow: true, FromCache: true}, nil
+			return AuthorizeResult{Allow: true, FromCache: true, Cached: entry, GitLabDuration: gitlabDuration, CacheDuration: cacheDuration}, nil
 		}
 		if errors.Is(cErr, ErrTokenCacheMiss) {
-			return AuthorizeResult{Allow: false}, nil
+			return AuthorizeResult{Allow: false, GitLabDuration: gitlabDuration, CacheDuration: cacheDuration}, nil
 		}
-		return AuthorizeResult{Allow: false}, cErr
+		return AuthorizeResult{Allow: false, GitLabDuration: gitlabDuration, CacheDuration: cacheDuration}, cErr
+	}
+
+	return AuthorizeResult{Allow: false, GitLabDuration: gitlabDuration}, err
+}
+
+// Groups returns the GitLab top-level groups of the authorized user, from
+// either the fresh verification or the cache entry.
+func (r AuthorizeResult) Groups() []string {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.Groups
+	case r.Cached != nil && r.Cached.Groups != "":
+		return strings.Split(r.Cached.Groups, ",")
+	}
+	return nil
+}
+
+// Username returns the GitLab username of the token owner, from either the
+// fresh verification or the cache entry. Unlike the username sent by the
+// client, it is verified.
+func (r AuthorizeResult) Username() string {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.Username
+	case r.Cached != nil:
+		return r.Cached.Username
 	}
+	return ""
+}
 
-	return AuthorizeResult{Allow: false}, err
+// UserID returns the numeric GitLab user ID of the token owner, or 0 when it
+// is unknown (cache entries written by older versions).
+func (r AuthorizeResult) UserID() int64 {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.UserID
+	case r.Cached != nil:
+		return r.Cached.UserID
+	}
+	return 0
+}
+
+// Scopes returns the token's scopes, from either the fresh verification or
+// the cache entry. It is empty when GitLab did not report them.
+func (r AuthorizeResult) Scopes() []string {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.Scopes
+	case r.Cached != nil && r.Cached.Scopes != "":
+		return strings.Split(r.Cached.Scopes, ",")
+	}
+	return nil
+}
+
+// TokenType returns the kind of the authorized token, from either the fresh
+// verification or the cache entry. It is empty for cache entries written
+// before the type was recorded, which are never deploy tokens.
+func (r AuthorizeResult) TokenType() string {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.TokenType
+	case r.Cached != nil:
+		return r.Cached.TokenType
+	}
+	return ""
+}
+
+// Project returns the job's project path of a CI/CD job token, from either
+// the fresh verification or the cache entry; "" for other tokens.
+func (r AuthorizeResult) Project() string {
+	switch {
+	case r.Verified != nil:
+		return r.Verified.Project
+	case r.Cached != nil:
+		return r.Cached.Project
+	}
+	return ""
 }
 
 func statusCodeFromGitLabError(err error) (int, bool) {
@@ -76,7 +195,7 @@ func isFallbackToCacheError(err error) bool {
 	if err == nil {
 		return false
 	}
-	if errors.Is(err, context.DeadlineExceeded) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrGitLabRateLimited) || errors.Is(err, ErrGitLabCircuitOpen) {
 		return true
 	}
 
@@ -88,7 +88,7 @@ func TestAuthorizeToken_HappyPath_WritesCache(t *testing.T) {
 
 	verifier := mockGitLabVerifier{verify: func(token string) (*VerifiedToken, error) {
 		require.Equal(t, "glpat-valid", token)
-		return &VerifiedToken{Username: "tester", Scopes: []string{"read_api", "read_user"}}, nil
+		return &VerifiedToken{UserID: 42, Username: "tester", Scopes: []string{"read_api", "read_user"}}, nil
 	}}
 
 	res, err := AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
@@ -99,6 +99,10 @@ func TestAuthorizeToken_HappyPath_WritesCache(t *testing.T) {
 	require.Nil(t, res.CacheWriteErr)
 	require.Equal(t, 0, cache.GetCalls())
 	require.Equal(t, 1, cache.PutCalls())
+
+	entry, err := cache.Get(ctx, "glpat-valid")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), entry.UserID)
 }
 
 func TestAuthorizeToken_CacheFallback_OnTimeout_AllowsOnHit(t *testing.T) {
@@ -203,3 +207,50 @@ func TestAuthorizeToken_MultiInstance_SharedKV(t *testing.T) {
 	require.Equal(t, 1, cacheB.GetCalls())
 	require.Equal(t, 0, cacheB.PutCalls())
 }
+
+// slowTokenCache advances the test clock on every call.
+type slowTokenCache struct {
+	TokenCache
+	clock *time.Time
+	delay time.Duration
+}
+
+func (s slowTokenCache) Get(ctx context.Context, token string) (*TokenCacheEntry, error) {
+	*s.clock = s.clock.Add(s.delay)
+	return s.TokenCache.Get(ctx, token)
+}
+
+func (s slowTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	*s.clock = s.clock.Add(s.delay)
+	return s.TokenCache.Put(ctx, token, entry)
+}
+
+func TestAuthorizeToken_RecordsDependencyTimings(t *testing.T) {
+	ctx := context.Background()
+
+	clock := time.Date(2025, 12, 14, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	kv := &mockSharedKV{now: now, ttl: 24 * time.Hour, data: map[string]mockKVRecord{}}
+	cache := slowTokenCache{TokenCache: &mockTokenCache{secret: []byte("secret"), kv: kv}, clock: &clock, delay: 20 * time.Millisecond}
+
+	gitlabUp := true
+	verifier := mockGitLabVerifier{verify: func(token string) (*VerifiedToken, error) {
+		clock = clock.Add(300 * time.Millisecond)
+		if !gitlabUp {
+			return nil, context.DeadlineExceeded
+		}
+		return &VerifiedToken{Username: "tester"}, nil
+	}}
+
+	res, err := AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
+	require.NoError(t, err)
+	require.Equal(t, 300*time.Millisecond, res.GitLabDuration)
+	require.Equal(t, 20*time.Millisecond, res.CacheDuration, "cache write")
+
+	gitlabUp = false
+	res, err = AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
+	require.NoError(t, err)
+	require.True(t, res.FromCache)
+	require.Equal(t, 300*time.Millisecond, res.GitLabDuration, "timed out GitLab call")
+	require.Equal(t, 20*time.Millisecond, res.CacheDuration, "cache fallback read")
+}
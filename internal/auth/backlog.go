@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Stale request policies.
+const (
+	StaleRequestsProcess = "process"
+	StaleRequestsDrop    = "drop"
+)
+
+// iatResolution is the resolution of the request's issued-at claim (whole
+// seconds), added to the callout timeout so fresh requests are never dropped.
+const iatResolution = time.Second
+
+// BacklogPolicy decides what happens to auth requests that waited in the
+// subscription backlog longer than the NATS server waits for a response.
+type BacklogPolicy struct {
+	// CalloutTimeout is the server-side auth callout timeout.
+	CalloutTimeout time.Duration
+	// Stale is process (handle every request) or drop (skip requests the
+	// server has already given up on).
+	Stale string
+	// ResponseReserve is kept from the remaining budget of a request for
+	// the cache fallback, signing and sending the response.
+	ResponseReserve time.Duration
+}
+
+// LoadBacklogPolicy reads auth.callout_timeout and auth.stale_requests.
+// Unknown policies fall back to process, which never rejects anyone.
+func LoadBacklogPolicy() BacklogPolicy {
+	stale := strings.ToLower(strings.TrimSpace(viper.GetString("auth.stale_requests")))
+	if stale != StaleRequestsDrop {
+		stale = StaleRequestsProcess
+	}
+	return BacklogPolicy{
+		CalloutTimeout:  viper.GetDuration("auth.callout_timeout"),
+		Stale:           stale,
+		ResponseReserve: viper.GetDuration("auth.response_reserve"),
+	}
+}
+
+// Drop reports whether a request of the given age is dropped.
+func (p BacklogPolicy) Drop(age time.Duration) bool {
+	return p.Stale == StaleRequestsDrop && p.CalloutTimeout > 0 && age > p.CalloutTimeout+iatResolution
+}
+
+// Budget returns how long the handler has left to answer a request: until
+// the expiry the server set on the request or, without one, until issued-at
+// plus the callout timeout. When neither is known, or the deadline has
+// already passed (the request is stale, or the clocks are skewed), it falls
+// back to the full callout timeout. Zero means no deadline.
+func (p BacklogPolicy) Budget(issuedAt, expires int64, now time.Time) time.Duration {
+	var deadline time.Time
+	switch {
+	case expires > 0:
+		deadline = time.Unix(expires, 0)
+	case issuedAt > 0 && p.CalloutTimeout > 0:
+		deadline = time.Unix(issuedAt, 0).Add(p.CalloutTimeout)
+	default:
+		return p.CalloutTimeout
+	}
+	if remaining := deadline.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return p.CalloutTimeout
+}
+
+// verificationDeadline returns the deadline for verifying a request
+// received at now with the given budget, keeping ResponseReserve for what
+// follows the verification. It reports false when there is no deadline.
+func (p BacklogPolicy) verificationDeadline(budget time.Duration, now time.Time) (time.Time, bool) {
+	if budget <= 0 {
+		return time.Time{}, false
+	}
+	return now.Add(budget - p.ResponseReserve), true
+}
+
+// requestAge returns how long ago the server issued an auth request. The
+// server stamps requests with its own clock, so the age includes clock skew.
+func requestAge(issuedAt int64, now time.Time) time.Duration {
+	if issuedAt <= 0 {
+		return 0
+	}
+	age := now.Sub(time.Unix(issuedAt, 0))
+	if age < 0 {
+		return 0
+	}
+	return age
+}
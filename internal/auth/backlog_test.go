@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestAge(t *testing.T) {
+	now := time.Unix(1000, 500*int64(time.Millisecond))
+
+	assert.Equal(t, 2500*time.Millisecond, requestAge(998, now))
+	assert.Zero(t, requestAge(0, now), "missing iat")
+	assert.Zero(t, requestAge(1005, now), "server clock ahead")
+}
+
+func TestLoadBacklogPolicy(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	assert.Equal(t, StaleRequestsProcess, LoadBacklogPolicy().Stale)
+
+	viper.Set("auth.callout_timeout", "3s")
+	viper.Set("auth.stale_requests", " DROP ")
+	assert.Equal(t, BacklogPolicy{CalloutTimeout: 3 * time.Second, Stale: StaleRequestsDrop}, LoadBacklogPolicy())
+
+	viper.Set("auth.stale_requests", "bogus")
+	assert.Equal(t, StaleRequestsProcess, LoadBacklogPolicy().Stale)
+}
+
+func TestBacklogPolicy_Drop(t *testing.T) {
+	drop := BacklogPolicy{CalloutTimeout: 2 * time.Second, Stale: StaleRequestsDrop}
+	assert.False(t, drop.Drop(500*time.Millisecond))
+	assert.False(t, drop.Drop(3*time.Second), "within iat resolution")
+	assert.True(t, drop.Drop(3100*time.Millisecond))
+
+	process := BacklogPolicy{CalloutTimeout: 2 * time.Second, Stale: StaleRequestsProcess}
+	assert.False(t, process.Drop(time.Minute))
+
+	assert.False(t, BacklogPolicy{Stale: StaleRequestsDrop}.Drop(time.Minute), "no timeout configured")
+}
+
+func TestBacklogPolicy_Budget(t *testing.T) {
+	now := time.Unix(1000, 500*int64(time.Millisecond))
+	p := BacklogPolicy{CalloutTimeout: 2 * time.Second}
+
+	assert.Equal(t, 500*time.Millisecond, p.Budget(999, 0, now), "issued-at plus callout timeout")
+	assert.Equal(t, 3500*time.Millisecond, p.Budget(999, 1004, now), "the request's expiry wins")
+	assert.Equal(t, 2*time.Second, p.Budget(0, 0, now), "no timing claims")
+	assert.Equal(t, 2*time.Second, p.Budget(990, 0, now), "deadline passed (stale or clock skew)")
+	assert.Zero(t, BacklogPolicy{}.Budget(999, 0, now), "no deadline without a callout timeout")
+}
+
+func TestBacklogPolicy_VerificationDeadline(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := BacklogPolicy{ResponseReserve: 250 * time.Millisecond}
+
+	deadline, ok := p.verificationDeadline(1500*time.Millisecond, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(1250*time.Millisecond), deadline)
+
+	_, ok = p.verificationDeadline(0, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// requestInfoHeader is set by the NATS server on requests that cross a
+// service import with share: true; it describes the requesting client.
+const requestInfoHeader = "Nats-Request-Info"
+
+// CanIConfig configures the permission probe service.
+type CanIConfig struct {
+	Enabled bool
+	// Subject is the request subject, exported from the auth callout account
+	// and imported (with share: true) into the users' account.
+	Subject string
+	// MaxUsers bounds the remembered grants.
+	MaxUsers int
+}
+
+// LoadCanIConfig reads the can_i.* settings.
+func LoadCanIConfig() CanIConfig {
+	return CanIConfig{
+		Enabled:  viper.GetBool("can_i.enabled"),
+		Subject:  viper.GetString("can_i.subject"),
+		MaxUsers: viper.GetInt("can_i.max_users"),
+	}
+}
+
+// Validate checks the probe settings.
+func (cfg CanIConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Subject == "" || strings.ContainsAny(cfg.Subject, " \t\r\n*>") {
+		return fmt.Errorf("can_i.subject must be a literal subject, got %q", cfg.Subject)
+	}
+	if cfg.MaxUsers <= 0 {
+		return fmt.Errorf("can_i.max_users must be > 0")
+	}
+	return nil
+}
+
+// issuedGrants remembers the permissions last issued by this replica to each
+// user, keyed by the JWT name.
+type issuedGrants struct {
+	mu       sync.RWMutex
+	maxUsers int
+	byUser   map[string]PermissionSet
+}
+
+func newIssuedGrants(maxUsers int) *issuedGrants {
+	return &issuedGrants{maxUsers: maxUsers, byUser: make(map[string]PermissionSet)}
+}
+
+// Record stores the grant of a user. When full, an arbitrary other user is
+// forgotten; their probes go unanswered until they reconnect.
+func (g *issuedGrants) Record(username string, set PermissionSet) {
+	if g == nil || username == "" {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.byUser[username]; !ok && len(g.byUser) >= g.maxUsers {
+		for evict := range g.byUser {
+			delete(g.byUser, evict)
+			break
+		}
+	}
+	g.byUser[username] = set
+}
+
+// Lookup returns the grant last issued to a user.
+func (g *issuedGrants) Lookup(username string) (PermissionSet, bool) {
+	if g == nil {
+		return PermissionSet{}, false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	set, ok := g.byUser[username]
+	return set, ok
+}
+
+// canIRequest asks whether the requesting user may publish or subscribe.
+type canIRequest struct {
+	Action  string `json:"action"`
+	Subject string `json:"subject"`
+}
+
+// canIResponse is the evaluation of a canIRequest against the issued grant.
+type canIResponse struct {
+	Username string `json:"username,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	Allowed  bool   `json:"allowed"`
+	// AllowedBy lists the allow entries covering the subject, DeniedBy the
+	// deny entries overlapping it (deny wins).
+	AllowedBy []string `json:"allowed_by,omitempty"`
+	DeniedBy  []string `json:"denied_by,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// requestUser returns the JWT name of the client that sent msg, from the
+// client info the server attaches to shared service imports.
+func requestUser(msg *nats.Msg) (string, bool) {
+	raw := msg.Header.Get(requestInfoHeader)
+	if raw == "" {
+		return "", false
+	}
+	var info struct {
+		NameTag string `json:"name_tag"`
+		User    string `json:"user"`
+	}
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		return "", false
+	}
+	if info.NameTag != "" {
+		return info.NameTag, true
+	}
+	return info.User, info.User != ""
+}
+
+// evaluateCanI checks a request against a grant.
+func evaluateCanI(username string, req canIRequest, set PermissionSet) canIResponse {
+	rules := set.Publish
+	if req.Action == "subscribe" {
+		rules = set.Subscribe
+	}
+	resp := canIResponse{Username: username, Action: req.Action, Subject: req.Subject, Allowed: rules.Allows(req.Subject)}
+	for _, a := range rules.Allow {
+		if subjectSubsetOf(req.Subject, a) {
+			resp.AllowedBy = append(resp.AllowedBy, a)
+		}
+	}
+	for _, d := range rules.Deny {
+		if _, overlap := intersectSubjects(req.Subject, d); overlap {
+			resp.DeniedBy = append(resp.DeniedBy, d)
+		}
+	}
+	return resp
+}
+
+// canIAnswer returns the answer to a permission probe. Every replica
+// receives each probe, but only the one that issued the user's JWT knows the
+// grant and answers; malformed probes are answered by all (identically).
+func (c *NATSClient) canIAnswer(msg *nats.Msg) (canIResponse, bool) {
+	var req canIRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return canIResponse{Error: `invalid request, expected {"action": "publish|subscribe", "subject": "..."}`}, true
+	}
+	req.Action = strings.ToLower(strings.TrimSpace(req.Action))
+	if req.Action != "publish" && req.Action != "subscribe" {
+		return canIResponse{Action: req.Action, Subject: req.Subject, Error: "action must be publish or subscribe"}, true
+	}
+	if req.Subject == "" || strings.ContainsAny(req.Subject, " \t\r\n") {
+		return canIResponse{Action: req.Action, Subject: req.Subject, Error: "invalid subject"}, true
+	}
+
+	username, ok := requestUser(msg)
+	if !ok {
+		return canIResponse{Action: req.Action, Subject: req.Subject, Error: "requester unknown; the probe subject must be imported with share: true"}, true
+	}
+	set, ok := c.issuedGrants.Lookup(username)
+	if !ok {
+		return canIResponse{}, false // issued by another replica
+	}
+	return evaluateCanI(username, req, set), true
+}
+
+// handleCanI answers permission probes.
+func (c *NATSClient) handleCanI(msg *nats.Msg) {
+	resp, ok := c.canIAnswer(msg)
+	if !ok {
+		return
+	}
+	c.logger.Debug("Answering permission probe", "username", resp.Username, "action", resp.Action, "subject", resp.Subject, "allowed", resp.Allowed)
+	data, _ := json.Marshal(resp)
+	if err := msg.Respond(data); err != nil {
+		c.logger.Warn("Failed to answer permission probe", "error", err)
+	}
+}
+
+// initCanI optionally starts remembering issued grants for the permission
+// probe service; the subscription is made in Start.
+func (c *NATSClient) initCanI() error {
+	cfg := LoadCanIConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.issuedGrants = newIssuedGrants(cfg.MaxUsers)
+	c.logger.Info("Permission probes enabled", "subject", cfg.Subject)
+	return nil
+}
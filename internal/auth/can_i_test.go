@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanIConfig_Validate(t *testing.T) {
+	assert.NoError(t, CanIConfig{}.Validate(), "disabled")
+	assert.NoError(t, CanIConfig{Enabled: true, Subject: "antal.can-i", MaxUsers: 1}.Validate())
+	assert.Error(t, CanIConfig{Enabled: true, Subject: "antal.*", MaxUsers: 1}.Validate())
+	assert.Error(t, CanIConfig{Enabled: true, Subject: "antal.can-i"}.Validate())
+}
+
+func TestEvaluateCanI(t *testing.T) {
+	set := PermissionSet{
+		Publish:   SubjectRules{Allow: []string{"orders.>", "user.jdoe.>"}, Deny: []string{"orders.admin.>"}},
+		Subscribe: SubjectRules{Allow: []string{"_INBOX.>"}},
+	}
+
+	resp := evaluateCanI("jdoe", canIRequest{Action: "publish", Subject: "orders.new"}, set)
+	assert.Equal(t, canIResponse{Username: "jdoe", Action: "publish", Subject: "orders.new", Allowed: true, AllowedBy: []string{"orders.>"}}, resp)
+
+	resp = evaluateCanI("jdoe", canIRequest{Action: "publish", Subject: "orders.admin.reset"}, set)
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, []string{"orders.>"}, resp.AllowedBy)
+	assert.Equal(t, []string{"orders.admin.>"}, resp.DeniedBy)
+
+	resp = evaluateCanI("jdoe", canIRequest{Action: "subscribe", Subject: "orders.>"}, set)
+	assert.False(t, resp.Allowed, "subscribe is checked against the subscribe rules")
+	assert.Empty(t, resp.AllowedBy)
+}
+
+func TestCanIAnswer(t *testing.T) {
+	c := &NATSClient{issuedGrants: newIssuedGrants(10), logger: slog.Default()}
+	c.issuedGrants.Record("jdoe", PermissionSet{Publish: SubjectRules{Allow: []string{"orders.>"}}})
+
+	probe := func(data, requestInfo string) *nats.Msg {
+		msg := &nats.Msg{Data: []byte(data), Header: nats.Header{}}
+		if requestInfo != "" {
+			msg.Header.Set(requestInfoHeader, requestInfo)
+		}
+		return msg
+	}
+
+	t.Run("answers for users it issued a JWT to", func(t *testing.T) {
+		resp, ok := c.canIAnswer(probe(`{"action": "Publish", "subject": "orders.new"}`, `{"name_tag": "jdoe", "user": "ignored"}`))
+		require.True(t, ok)
+		assert.True(t, resp.Allowed)
+		assert.Equal(t, "jdoe", resp.Username)
+	})
+
+	t.Run("falls back to the connect user", func(t *testing.T) {
+		resp, ok := c.canIAnswer(probe(`{"action": "publish", "subject": "billing.new"}`, `{"user": "jdoe"}`))
+		require.True(t, ok)
+		assert.False(t, resp.Allowed)
+	})
+
+	t.Run("stays silent for users of other replicas", func(t *testing.T) {
+		_, ok := c.canIAnswer(probe(`{"action": "publish", "subject": "orders.new"}`, `{"name_tag": "alice"}`))
+		assert.False(t, ok)
+	})
+
+	t.Run("rejects malformed probes", func(t *testing.T) {
+		resp, ok := c.canIAnswer(probe(`{"action": "delete", "subject": "orders.new"}`, `{"name_tag": "jdoe"}`))
+		require.True(t, ok)
+		assert.Contains(t, resp.Error, "publish or subscribe")
+
+		resp, ok = c.canIAnswer(probe(`{"action": "publish", "subject": "orders new"}`, `{"name_tag": "jdoe"}`))
+		require.True(t, ok)
+		assert.Equal(t, "invalid subject", resp.Error)
+	})
+
+	t.Run("needs the shared request info", func(t *testing.T) {
+		resp, ok := c.canIAnswer(probe(`{"action": "publish", "subject": "orders.new"}`, ""))
+		require.True(t, ok)
+		assert.Contains(t, resp.Error, "share: true")
+	})
+}
+
+func TestIssuedGrants_Bounded(t *testing.T) {
+	g := newIssuedGrants(2)
+	g.Record("a", AllowAll())
+	g.Record("b", AllowAll())
+	g.Record("a", AllowOnly("x"))
+	assert.Len(t, g.byUser, 2, "updating a user does not evict")
+	g.Record("c", AllowAll())
+	assert.Len(t, g.byUser, 2)
+	_, ok := g.Lookup("c")
+	assert.True(t, ok)
+
+	var none *issuedGrants
+	none.Record("a", AllowAll())
+	_, ok = none.Lookup("a")
+	assert.False(t, ok)
+}
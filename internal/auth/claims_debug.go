@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+)
+
+// claimsDebugUser reports whether username is listed in logging.claims_users.
+// The list is read on every request, so it can change at runtime.
+func claimsDebugUser(username string) bool {
+	for _, user := range viper.GetStringSlice("logging.claims_users") {
+		if strings.EqualFold(strings.TrimSpace(user), username) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizedClaims returns the claims of an issued user JWT as JSON. Only the
+// decoded claims are returned; the signed JWT, which the user could connect
+// with until it expires, never is.
+func sanitizedClaims(userJwt string) (string, error) {
+	uc, err := jwt.DecodeUserClaims(userJwt)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode user JWT: %w", err)
+	}
+	data, err := json.Marshal(uc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// logIssuedClaims logs the full claims issued to a user listed in
+// logging.claims_users. It logs at info level, so support can see exactly
+// what one user receives without debug logging for all traffic.
+func (c *NATSClient) logIssuedClaims(username, userJwt string, fromCache bool) {
+	if !claimsDebugUser(username) {
+		return
+	}
+	claims, err := sanitizedClaims(userJwt)
+	if err != nil {
+		c.logger.Warn("Failed to log issued claims", "username", username, "error", err)
+		return
+	}
+	c.logger.Info("Issued user claims (logging.claims_users)", "username", username, "jwt_cache_hit", fromCache, "claims", claims)
+}
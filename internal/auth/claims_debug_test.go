@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogIssuedClaims(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	account, _, _ := newAccountKey(t)
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userPub, err := user.PublicKey()
+	require.NoError(t, err)
+	uc := jwt.NewUserClaims(userPub)
+	uc.Name = "alice"
+	uc.Pub.Allow.Add("orders.>")
+	userJwt, err := uc.Encode(account)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	c := &NATSClient{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	c.logIssuedClaims("alice", userJwt, false)
+	assert.Empty(t, buf.String(), "users are not logged unless listed")
+
+	viper.Set("logging.claims_users", []string{"bob", "Alice"})
+	c.logIssuedClaims("alice", userJwt, true)
+	var line struct {
+		Msg         string `json:"msg"`
+		JWTCacheHit bool   `json:"jwt_cache_hit"`
+		Claims      string `json:"claims"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.True(t, line.JWTCacheHit)
+	assert.NotContains(t, buf.String(), userJwt, "the signed JWT is never logged")
+
+	var claims jwt.UserClaims
+	require.NoError(t, json.Unmarshal([]byte(line.Claims), &claims))
+	assert.Equal(t, userPub, claims.Subject)
+	assert.Equal(t, jwt.StringList{"orders.>"}, claims.Pub.Allow)
+}
+
+func TestParseUsernamesOverride(t *testing.T) {
+	users, err := parseUsernamesOverride(" alice, bob ,,")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, users)
+}
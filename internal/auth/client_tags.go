@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// clientTagSeparator separates the tags a client appends to its connection
+// name, e.g. "ingest;environment=staging;region=eu".
+const clientTagSeparator = ";"
+
+// clientTagPattern is what tag names and values must look like: a single
+// subject token, so rendering one into a subject cannot widen a grant.
+var clientTagPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ClientTagRule trusts one client tag, optionally limited to some values.
+type ClientTagRule struct {
+	Name string `mapstructure:"name" json:"name"`
+	// Values lists the accepted values; empty accepts any single-token value.
+	Values []string `mapstructure:"values" json:"values,omitempty"`
+}
+
+// ClientTagsConfig is the allow-list of tags clients may send.
+type ClientTagsConfig struct {
+	Allowed []ClientTagRule
+}
+
+// LoadClientTagsConfig reads client_tags.allowed.
+func LoadClientTagsConfig() (ClientTagsConfig, error) {
+	var cfg ClientTagsConfig
+	if err := viper.UnmarshalKey("client_tags.allowed", &cfg.Allowed); err != nil {
+		return cfg, fmt.Errorf("invalid client_tags.allowed: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the allow-list.
+func (cfg ClientTagsConfig) Validate() error {
+	seen := make(map[string]bool, len(cfg.Allowed))
+	for i, rule := range cfg.Allowed {
+		name := strings.ToLower(rule.Name)
+		if !clientTagPattern.MatchString(name) {
+			return fmt.Errorf("client_tags.allowed[%d]: invalid tag name %q", i, rule.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("client_tags.allowed[%d]: duplicate tag %q", i, rule.Name)
+		}
+		seen[name] = true
+		for _, value := range rule.Values {
+			if !clientTagPattern.MatchString(value) {
+				return fmt.Errorf("client_tags.allowed[%d]: invalid value %q, values must be single subject tokens", i, value)
+			}
+		}
+	}
+	return nil
+}
+
+// clientTagPolicy filters client-sent tags against the allow-list.
+type clientTagPolicy struct {
+	// allowed maps lowercase tag names to their accepted values (nil: any).
+	allowed map[string][]string
+}
+
+func newClientTagPolicy(cfg ClientTagsConfig) *clientTagPolicy {
+	p := &clientTagPolicy{allowed: make(map[string][]string, len(cfg.Allowed))}
+	for _, rule := range cfg.Allowed {
+		p.allowed[strings.ToLower(rule.Name)] = rule.Values
+	}
+	return p
+}
+
+// Parse returns the trusted tags in a connection name. Tags that are not
+// allowed, have an unaccepted value or repeat an earlier tag are dropped and
+// returned by name in dropped.
+func (p *clientTagPolicy) Parse(connectionName string) (tags map[string]string, dropped []string) {
+	if p == nil {
+		return nil, nil
+	}
+	segments := strings.Split(connectionName, clientTagSeparator)
+	for _, segment := range segments[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(segment), "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		values, allowed := p.allowed[name]
+		switch {
+		case !allowed:
+			clientTagsDroppedTotal.WithLabelValues("not_allowed").Inc()
+		case !clientTagPattern.MatchString(value) || (len(values) > 0 && !slices.Contains(values, value)):
+			clientTagsDroppedTotal.WithLabelValues("invalid_value").Inc()
+		case tags[name] != "":
+			clientTagsDroppedTotal.WithLabelValues("duplicate").Inc()
+		default:
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[name] = value
+			continue
+		}
+		dropped = append(dropped, name)
+	}
+	return tags, dropped
+}
+
+// placeholders returns sample tags for startup validation of templates:
+// every allowed tag with its first accepted value, or its name when any
+// value is accepted.
+func (cfg ClientTagsConfig) placeholders() map[string]string {
+	if len(cfg.Allowed) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(cfg.Allowed))
+	for _, rule := range cfg.Allowed {
+		name := strings.ToLower(rule.Name)
+		out[name] = name
+		if len(rule.Values) > 0 {
+			out[name] = rule.Values[0]
+		}
+	}
+	return out
+}
+
+// clientTags returns the trusted tags of a connection name.
+func (c *NATSClient) clientTags(connectionName, username string) map[string]string {
+	tags, dropped := c.clientTagPolicy.Parse(connectionName)
+	if len(dropped) > 0 {
+		c.logger.Debug("Ignoring untrusted client tags", "username", username, "tags", dropped)
+	}
+	return tags
+}
+
+// tagList renders tags as sorted "name:value" JWT tags.
+func tagList(tags map[string]string) []string {
+	out := make([]string, 0, len(tags))
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		out = append(out, name+":"+tags[name])
+	}
+	return out
+}
+
+// initClientTags optionally accepts tags from the clients' connection names.
+func (c *NATSClient) initClientTags() error {
+	cfg, err := LoadClientTagsConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if len(cfg.Allowed) == 0 {
+		return nil
+	}
+	c.clientTagPolicy = newClientTagPolicy(cfg)
+	c.logger.Info("Accepting client tags", "tags", slices.Sorted(maps.Keys(c.clientTagPolicy.allowed)))
+	return nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTagsConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("client_tags.allowed", []any{
+		map[string]any{"name": "Environment", "values": []any{"staging", "production"}},
+		map[string]any{"name": "region"},
+	})
+	cfg, err := LoadClientTagsConfig()
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, map[string]string{"environment": "staging", "region": "region"}, cfg.placeholders())
+
+	assert.ErrorContains(t, ClientTagsConfig{Allowed: []ClientTagRule{{Name: "env.name"}}}.Validate(), "invalid tag name")
+	assert.ErrorContains(t, ClientTagsConfig{Allowed: []ClientTagRule{{Name: "env"}, {Name: "ENV"}}}.Validate(), "duplicate tag")
+	assert.ErrorContains(t, ClientTagsConfig{Allowed: []ClientTagRule{{Name: "env", Values: []string{"*"}}}}.Validate(), "single subject tokens")
+}
+
+func TestClientTagPolicy_Parse(t *testing.T) {
+	clientTagsDroppedTotal.Reset()
+	p := newClientTagPolicy(ClientTagsConfig{Allowed: []ClientTagRule{
+		{Name: "environment", Values: []string{"staging", "production"}},
+		{Name: "region"},
+	}})
+
+	tags, dropped := p.Parse("ingest; Environment=staging ;region=eu-1;team=payments;region=us;noise")
+	assert.Equal(t, map[string]string{"environment": "staging", "region": "eu-1"}, tags)
+	assert.Equal(t, []string{"team", "region"}, dropped)
+
+	tags, dropped = p.Parse("ingest;environment=dev;region=>")
+	assert.Empty(t, tags)
+	assert.Equal(t, []string{"environment", "region"}, dropped, "values must be accepted single tokens")
+	assert.Equal(t, float64(2), testutil.ToFloat64(clientTagsDroppedTotal.WithLabelValues("invalid_value")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(clientTagsDroppedTotal.WithLabelValues("duplicate")))
+
+	tags, _ = p.Parse("environment=staging")
+	assert.Empty(t, tags, "the first segment is the name")
+
+	var disabled *clientTagPolicy
+	tags, dropped = disabled.Parse("ingest;environment=staging")
+	assert.Nil(t, tags)
+	assert.Nil(t, dropped)
+}
+
+func TestResolvePermissions_ClientTags(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{
+		`{{if .HasTag "environment" "staging"}}staging.{{.Username}}.>{{end}}`,
+		`{{if .Tag "region"}}metrics.{{.Tag "region"}}.>{{end}}`,
+	})
+	c := &NATSClient{logger: slog.Default()}
+	result := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+
+	set := c.resolvePermissions(result, "alice", map[string]string{"environment": "staging", "region": "eu"})
+	assert.ElementsMatch(t, []string{"metrics.eu.>", "staging.alice.>"}, set.Publish.Allow)
+
+	set = c.resolvePermissions(result, "alice", nil)
+	assert.Empty(t, set.Publish.Allow, "tag conditions fail closed")
+
+	out, err := renderPermissionTemplate(`x.{{.Tags.region}}`, permissionTemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, "x.<no value>", out, "why templates should use .Tag")
+
+	assert.Equal(t, []string{"environment:staging", "region:eu"}, tagList(map[string]string{"region": "eu", "environment": "staging"}))
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// Config reload triggers recorded in the changelog.
+const (
+	ReloadTriggerSIGHUP     = "sighup"
+	ReloadTriggerFileChange = "file_change"
+	ReloadTriggerAdminAPI   = "admin_api"
+)
+
+// ReloadSource describes what triggered a config reload.
+type ReloadSource struct {
+	Trigger string
+	// Actor is who asked for the reload; only set via the admin API, where
+	// it is whatever the caller sent.
+	Actor string
+}
+
+// ConfigChangelogConfig holds the config_reload.changelog.* settings.
+type ConfigChangelogConfig struct {
+	Enabled  bool
+	Bucket   string
+	Replicas int
+	// MaxAge is how long changes are kept; 0 keeps them forever.
+	MaxAge time.Duration
+}
+
+// LoadConfigChangelogConfig reads the config_reload.changelog.* settings.
+func LoadConfigChangelogConfig() ConfigChangelogConfig {
+	return ConfigChangelogConfig{
+		Enabled:  viper.GetBool("config_reload.changelog.enabled"),
+		Bucket:   viper.GetString("config_reload.changelog.bucket"),
+		Replicas: viper.GetInt("config_reload.changelog.replicas"),
+		MaxAge:   viper.GetDuration("config_reload.changelog.max_age"),
+	}
+}
+
+// ConfigChange is one applied config reload.
+type ConfigChange struct {
+	Time     time.Time `json:"time"`
+	Instance string    `json:"instance"`
+	Trigger  string    `json:"trigger"`
+	Actor    string    `json:"actor,omitempty"`
+	// ChangedKeys are the config file keys added, removed or changed since
+	// the previous reload (or startup) of the instance. Values are never
+	// recorded, as the file holds secrets.
+	ChangedKeys []string `json:"changed_keys"`
+	// ConfigHash identifies the config file contents; replicas running the
+	// same file report the same hash.
+	ConfigHash string `json:"config_hash"`
+}
+
+// configChangelog stores applied config reloads in a KV bucket, one key per
+// change. Keys start with the time, so they sort chronologically.
+type configChangelog struct {
+	kv nats.KeyValue
+
+	// mu guards applied, the config file settings of the last reload.
+	mu      sync.Mutex
+	applied map[string]string
+}
+
+func newConfigChangelog(kv nats.KeyValue) *configChangelog {
+	applied, _ := configFileSettings()
+	return &configChangelog{kv: kv, applied: applied}
+}
+
+// configFileSettings returns the settings of the config file, flattened to
+// JSON-encoded values by key. Defaults, environment variables, secrets read
+// from files or Vault and config overrides are not part of it.
+func configFileSettings() (map[string]string, error) {
+	out := map[string]string{}
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return out, nil
+	}
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	for _, key := range file.AllKeys() {
+		value, _ := json.Marshal(file.Get(key))
+		out[key] = string(value)
+	}
+	return out, nil
+}
+
+// changedKeys returns the sorted keys whose values differ between before and after.
+func changedKeys(before, after map[string]string) []string {
+	changed := []string{}
+	for key, value := range after {
+		if prev, ok := before[key]; !ok || prev != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// Record stores a reload: the keys changed since the previous one and the
+// hash of the current config file.
+func (l *configChangelog) Record(instance string, source ReloadSource, now time.Time) (ConfigChange, error) {
+	settings, err := configFileSettings()
+	if err != nil {
+		return ConfigChange{}, err
+	}
+	l.mu.Lock()
+	change := ConfigChange{
+		Time:        now.UTC(),
+		Instance:    instance,
+		Trigger:     source.Trigger,
+		Actor:       source.Actor,
+		ChangedKeys: changedKeys(l.applied, settings),
+		ConfigHash:  hashJSON(settings),
+	}
+	l.applied = settings
+	l.mu.Unlock()
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return change, err
+	}
+	key := fmt.Sprintf("%019d.%s", change.Time.UnixNano(), instance)
+	_, err = l.kv.Put(key, data)
+	return change, err
+}
+
+// List returns up to limit changes, newest first, optionally only those of
+// one instance.
+func (l *configChangelog) List(limit int, instance string) ([]ConfigChange, error) {
+	keys, err := l.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return []ConfigChange{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	slices.Reverse(keys)
+
+	changes := []ConfigChange{}
+	for _, key := range keys {
+		if len(changes) >= limit {
+			break
+		}
+		entry, err := l.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue // expired meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		var change ConfigChange
+		if err := json.Unmarshal(entry.Value(), &change); err != nil {
+			continue
+		}
+		if instance == "" || change.Instance == instance {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// recordConfigChange adds an applied reload to the changelog, if enabled.
+// Failing to record it does not undo the reload.
+func (c *NATSClient) recordConfigChange(source ReloadSource) {
+	if c.configChangelog == nil {
+		return
+	}
+	change, err := c.configChangelog.Record(c.instanceID, source, time.Now())
+	if err != nil {
+		c.logger.Warn("Failed to record config change", "error", err)
+		return
+	}
+	c.logger.Info("Config change recorded", "trigger", change.Trigger, "actor", change.Actor,
+		"changed_keys", change.ChangedKeys, "config_hash", change.ConfigHash)
+}
+
+// initConfigChangelog optionally binds the config changelog bucket.
+func (c *NATSClient) initConfigChangelog() error {
+	cfg := LoadConfigChangelogConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Bucket == "" {
+		return errors.New("config_reload.changelog.bucket is empty")
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	kv, _, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal applied config reloads",
+		Replicas:    cfg.Replicas,
+		TTL:         cfg.MaxAge,
+	})
+	if err != nil {
+		return err
+	}
+	c.configChangelog = newConfigChangelog(kv)
+	c.logger.Info("Config changelog enabled (JetStream KV)", "bucket", cfg.Bucket)
+
+	return nil
+}
+
+// maxConfigChanges caps the changes returned by the changelog endpoint.
+const maxConfigChanges = 1000
+
+// ConfigChangelogHandler serves GET /admin/config/changelog: the applied
+// config reloads of all replicas, newest first. ?limit (default 50) and
+// ?instance narrow the result.
+func (c *NATSClient) ConfigChangelogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.configChangelog == nil {
+			http.Error(w, "config changelog is not enabled", http.StatusNotFound)
+			return
+		}
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxConfigChanges)
+		}
+
+		changes, err := c.configChangelog.List(limit, r.URL.Query().Get("instance"))
+		if err != nil {
+			c.logger.Error("Failed to read config changelog", "error", err)
+			http.Error(w, "failed to read config changelog", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(changes)
+	})
+}
+
+// ConfigReloadHandler serves POST /admin/config/reload, which reloads the
+// config file of this replica through reload. The optional JSON body
+// {"actor": "..."} is recorded in the changelog.
+func ConfigReloadHandler(reload func(ReloadSource) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Actor string `json:"actor"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid JSON body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := reload(ReloadSource{Trigger: ReloadTriggerAdminAPI, Actor: body.Actor})
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			audit("config.reload", "failed", "actor", body.Actor, "error", err)
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		audit("config.reload", "ok", "actor", body.Actor)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "applied"})
+	})
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedKeys(t *testing.T) {
+	before := map[string]string{"a": "1", "b": "2", "c": "3"}
+	after := map[string]string{"a": "1", "b": "20", "d": "4"}
+	assert.Equal(t, []string{"b", "c", "d"}, changedKeys(before, after))
+	assert.Empty(t, changedKeys(after, after))
+}
+
+func TestConfigChangelog_RecordAndList(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write("gitlab:\n  timeout: 5\nadmin:\n  token: secret\n")
+	viper.SetConfigFile(path)
+	require.NoError(t, viper.ReadInConfig())
+
+	kv := newRevisionKV()
+	changelog := newConfigChangelog(kv)
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+
+	write("gitlab:\n  timeout: 9\n  retries: 2\nadmin:\n  token: secret\n")
+	first, err := changelog.Record("antal-1", ReloadSource{Trigger: ReloadTriggerAdminAPI, Actor: "jane"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gitlab.retries", "gitlab.timeout"}, first.ChangedKeys)
+	assert.NotEmpty(t, first.ConfigHash)
+
+	second, err := changelog.Record("antal-1", ReloadSource{Trigger: ReloadTriggerSIGHUP}, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, second.ChangedKeys, "nothing changed since the previous reload")
+	assert.Equal(t, first.ConfigHash, second.ConfigHash)
+
+	other, err := newConfigChangelog(kv).Record("antal-2", ReloadSource{Trigger: ReloadTriggerFileChange}, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, first.ConfigHash, other.ConfigHash, "replicas with the same file report the same hash")
+
+	for _, value := range kv.data {
+		assert.NotContains(t, string(value), "secret", "values are never recorded")
+	}
+
+	changes, err := changelog.List(10, "")
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, "antal-2", changes[0].Instance, "newest first")
+	assert.Equal(t, "jane", changes[2].Actor)
+
+	changes, err = changelog.List(1, "antal-1")
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, ReloadTriggerSIGHUP, changes[0].Trigger)
+}
+
+func TestConfigChangelogHandler(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	rec := httptest.NewRecorder()
+	c.ConfigChangelogHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/changelog", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c.configChangelog = &configChangelog{kv: newRevisionKV()}
+	rec = httptest.NewRecorder()
+	c.ConfigChangelogHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/changelog?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	c.ConfigChangelogHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/changelog", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestConfigReloadHandler(t *testing.T) {
+	var got ReloadSource
+	var fail error
+	handler := ConfigReloadHandler(func(source ReloadSource) error {
+		got = source
+		return fail
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/config/reload", strings.NewReader(`{"actor": "jane"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ReloadSource{Trigger: ReloadTriggerAdminAPI, Actor: "jane"}, got)
+
+	fail = errors.New("invalid permissions config")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "invalid permissions config", body["error"])
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/reload", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
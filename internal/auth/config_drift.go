@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// ConfigDriftConfig holds the config_reload.drift.* settings.
+type ConfigDriftConfig struct {
+	Enabled  bool
+	Bucket   string
+	Replicas int
+	// Interval is how often a replica publishes its config hash and
+	// compares it with the others.
+	Interval time.Duration
+	// GracePeriod is how long replicas may run different configs, e.g.
+	// during a rolling reload, before it is reported as drift.
+	GracePeriod time.Duration
+}
+
+// LoadConfigDriftConfig reads the config_reload.drift.* settings.
+func LoadConfigDriftConfig() ConfigDriftConfig {
+	return ConfigDriftConfig{
+		Enabled:     viper.GetBool("config_reload.drift.enabled"),
+		Bucket:      viper.GetString("config_reload.drift.bucket"),
+		Replicas:    viper.GetInt("config_reload.drift.replicas"),
+		Interval:    viper.GetDuration("config_reload.drift.interval"),
+		GracePeriod: viper.GetDuration("config_reload.drift.grace_period"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg ConfigDriftConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.Bucket == "":
+		return errors.New("config_reload.drift: bucket is required")
+	case cfg.Interval <= 0:
+		return errors.New("config_reload.drift: interval must be > 0")
+	case cfg.GracePeriod < 0:
+		return errors.New("config_reload.drift: grace_period must be >= 0")
+	}
+	return nil
+}
+
+// ReplicaConfig is the config a replica published to the drift bucket.
+type ReplicaConfig struct {
+	Instance string `json:"instance"`
+	// ConfigHash is the hash of the config file, as in the changelog.
+	ConfigHash string `json:"config_hash"`
+	// AppliedAt is when the replica loaded the config: at startup or on
+	// its last reload.
+	AppliedAt time.Time `json:"applied_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConfigDriftReport compares the configs of the live replicas.
+type ConfigDriftReport struct {
+	Replicas []ReplicaConfig `json:"replicas"`
+	// CurrentHash is the most recently applied config hash.
+	CurrentHash string `json:"current_hash"`
+	// Stale lists the replicas running another config than CurrentHash.
+	Stale []string `json:"stale"`
+	// DivergedSince is when this replica first saw differing hashes; zero
+	// while all replicas agree.
+	DivergedSince time.Time `json:"diverged_since,omitzero"`
+	// Drift is set once the replicas diverged for longer than the grace
+	// period.
+	Drift bool `json:"drift"`
+}
+
+// ConfigDrift publishes the config hash of this replica to a KV bucket, one
+// key per replica, and reports replicas that keep running another config,
+// e.g. after a rollout or reload that did not reach every replica and left
+// them enforcing old permissions.
+type ConfigDrift struct {
+	kv       nats.KeyValue
+	cfg      ConfigDriftConfig
+	instance string
+	logger   *slog.Logger
+
+	mu            sync.Mutex
+	hash          string
+	appliedAt     time.Time
+	divergedSince time.Time
+	drift         bool
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewConfigDrift creates the detector; call Start to begin publishing.
+func NewConfigDrift(kv nats.KeyValue, cfg ConfigDriftConfig, instance string) *ConfigDrift {
+	return &ConfigDrift{
+		kv:       kv,
+		cfg:      cfg,
+		instance: instance,
+		logger:   slog.With("component", "config_drift"),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Applied records the config hash loaded at startup or by a reload and
+// publishes it.
+func (d *ConfigDrift) Applied(hash string, now time.Time) error {
+	d.mu.Lock()
+	d.hash, d.appliedAt = hash, now.UTC()
+	d.mu.Unlock()
+	return d.publish(now)
+}
+
+// publish writes this replica's entry; the bucket TTL removes entries of
+// replicas that stopped publishing.
+func (d *ConfigDrift) publish(now time.Time) error {
+	d.mu.Lock()
+	entry := ReplicaConfig{Instance: d.instance, ConfigHash: d.hash, AppliedAt: d.appliedAt, UpdatedAt: now.UTC()}
+	d.mu.Unlock()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = d.kv.Put(d.instance, data)
+	return err
+}
+
+// replicas returns the entries of all live replicas, sorted by instance.
+func (d *ConfigDrift) replicas() ([]ReplicaConfig, error) {
+	keys, err := d.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	var replicas []ReplicaConfig
+	for _, key := range keys {
+		entry, err := d.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue // expired meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		var replica ReplicaConfig
+		if err := json.Unmarshal(entry.Value(), &replica); err != nil {
+			continue
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
+// Check compares the configs of the live replicas and updates the drift
+// state. The most recently applied hash is taken as current: a stale
+// replica is one a rollout or reload has not reached.
+func (d *ConfigDrift) Check(now time.Time) (ConfigDriftReport, error) {
+	replicas, err := d.replicas()
+	if err != nil {
+		return ConfigDriftReport{}, err
+	}
+	report := ConfigDriftReport{Replicas: replicas, Stale: []string{}}
+	if report.Replicas == nil {
+		report.Replicas = []ReplicaConfig{}
+	}
+	var latest time.Time
+	hashes := map[string]int{}
+	for _, r := range replicas {
+		hashes[r.ConfigHash]++
+		if r.AppliedAt.After(latest) {
+			latest, report.CurrentHash = r.AppliedAt, r.ConfigHash
+		}
+	}
+	for _, r := range replicas {
+		if r.ConfigHash != report.CurrentHash {
+			report.Stale = append(report.Stale, r.Instance)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case len(hashes) <= 1:
+		if d.drift {
+			d.logger.Info("Replica configs converged", "config_hash", report.CurrentHash)
+		}
+		d.divergedSince, d.drift = time.Time{}, false
+	case d.divergedSince.IsZero():
+		d.divergedSince = now.UTC()
+	}
+	report.DivergedSince = d.divergedSince
+	report.Drift = !d.divergedSince.IsZero() && now.Sub(d.divergedSince) >= d.cfg.GracePeriod
+	if report.Drift && !d.drift {
+		d.logger.Warn("Replicas run different configs beyond the grace period",
+			"stale", report.Stale,
+			"current_hash", report.CurrentHash,
+			"diverged_since", d.divergedSince,
+			"grace_period", d.cfg.GracePeriod,
+		)
+	}
+	d.drift = report.Drift
+
+	configHashes.Set(float64(len(hashes)))
+	if report.Drift {
+		configStaleReplicas.Set(float64(len(report.Stale)))
+	} else {
+		configStaleReplicas.Set(0)
+	}
+	return report, nil
+}
+
+// Start publishes and checks every interval, until Stop.
+func (d *ConfigDrift) Start() {
+	d.done.Add(1)
+	go func() {
+		defer d.done.Done()
+		ticker := time.NewTicker(d.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := d.publish(now); err != nil {
+					d.logger.Warn("Failed to publish config hash", "error", err)
+				}
+				if _, err := d.Check(now); err != nil {
+					d.logger.Warn("Failed to compare replica configs", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends publishing and removes this replica's entry, so a replica
+// shutting down is not reported as stale.
+func (d *ConfigDrift) Stop() {
+	close(d.stop)
+	d.done.Wait()
+	if err := d.kv.Delete(d.instance); err != nil {
+		d.logger.Debug("Failed to remove config hash", "error", err)
+	}
+}
+
+// recordConfigHash publishes the hash of the config file just applied, if
+// drift detection is enabled.
+func (c *NATSClient) recordConfigHash() {
+	if c.configDrift == nil {
+		return
+	}
+	settings, err := configFileSettings()
+	if err != nil {
+		c.logger.Warn("Failed to hash config file", "error", err)
+		return
+	}
+	if err := c.configDrift.Applied(hashJSON(settings), time.Now()); err != nil {
+		c.logger.Warn("Failed to publish config hash", "error", err)
+	}
+}
+
+// initConfigDrift optionally publishes the config hash and compares it with
+// the other replicas.
+func (c *NATSClient) initConfigDrift() error {
+	cfg := LoadConfigDriftConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	kv, _, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal config hashes of live replicas",
+		Replicas:    cfg.Replicas,
+		// Entries of replicas that stopped publishing expire.
+		TTL: 3 * cfg.Interval,
+	})
+	if err != nil {
+		return err
+	}
+	c.configDrift = NewConfigDrift(kv, cfg, c.instanceID)
+	c.recordConfigHash()
+	c.configDrift.Start()
+	c.logger.Info("Config drift detection enabled (JetStream KV)", "bucket", cfg.Bucket,
+		"interval", cfg.Interval, "grace_period", cfg.GracePeriod)
+	return nil
+}
+
+// ConfigDriftHandler serves GET /admin/config/drift: the config hashes of
+// all live replicas and the replicas running a stale config.
+func (c *NATSClient) ConfigDriftHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.configDrift == nil {
+			http.Error(w, "config drift detection is not enabled", http.StatusNotFound)
+			return
+		}
+		report, err := c.configDrift.Check(time.Now())
+		if err != nil {
+			c.logger.Error("Failed to compare replica configs", "error", err)
+			http.Error(w, "failed to read config hashes", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDriftConfig_Validate(t *testing.T) {
+	assert.NoError(t, ConfigDriftConfig{}.Validate())
+	assert.ErrorContains(t, ConfigDriftConfig{Enabled: true, Interval: time.Second}.Validate(), "bucket")
+	assert.ErrorContains(t, ConfigDriftConfig{Enabled: true, Bucket: "b"}.Validate(), "interval")
+	assert.NoError(t, ConfigDriftConfig{Enabled: true, Bucket: "b", Interval: time.Second}.Validate())
+}
+
+func TestConfigDrift_Check(t *testing.T) {
+	kv := newRevisionKV()
+	cfg := ConfigDriftConfig{Interval: time.Second, GracePeriod: 5 * time.Minute}
+	one := NewConfigDrift(kv, cfg, "antal-1")
+	two := NewConfigDrift(kv, cfg, "antal-2")
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+
+	report, err := one.Check(now)
+	require.NoError(t, err)
+	assert.Empty(t, report.Replicas)
+	assert.False(t, report.Drift)
+
+	require.NoError(t, one.Applied("old", now))
+	require.NoError(t, two.Applied("old", now))
+	report, err = one.Check(now)
+	require.NoError(t, err)
+	assert.Len(t, report.Replicas, 2)
+	assert.Equal(t, "old", report.CurrentHash)
+	assert.Empty(t, report.Stale)
+	assert.Equal(t, float64(1), testutil.ToFloat64(configHashes))
+
+	// Only antal-1 got the reload.
+	require.NoError(t, one.Applied("new", now.Add(time.Minute)))
+	report, err = one.Check(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "new", report.CurrentHash)
+	assert.Equal(t, []string{"antal-2"}, report.Stale)
+	assert.False(t, report.Drift, "within the grace period")
+	assert.Equal(t, float64(0), testutil.ToFloat64(configStaleReplicas))
+
+	report, err = one.Check(now.Add(6 * time.Minute))
+	require.NoError(t, err)
+	assert.True(t, report.Drift)
+	assert.Equal(t, now.Add(time.Minute), report.DivergedSince)
+	assert.Equal(t, float64(2), testutil.ToFloat64(configHashes))
+	assert.Equal(t, float64(1), testutil.ToFloat64(configStaleReplicas))
+
+	require.NoError(t, two.Applied("new", now.Add(7*time.Minute)))
+	report, err = one.Check(now.Add(7 * time.Minute))
+	require.NoError(t, err)
+	assert.False(t, report.Drift)
+	assert.True(t, report.DivergedSince.IsZero())
+	assert.Equal(t, float64(0), testutil.ToFloat64(configStaleReplicas))
+}
+
+func TestConfigDriftHandler(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	rec := httptest.NewRecorder()
+	c.ConfigDriftHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/drift", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c.configDrift = NewConfigDrift(newRevisionKV(), ConfigDriftConfig{Interval: time.Second}, "antal-1")
+	require.NoError(t, c.configDrift.Applied("abc", time.Now()))
+	rec = httptest.NewRecorder()
+	c.ConfigDriftHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config/drift", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report ConfigDriftReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, "abc", report.CurrentHash)
+	assert.Len(t, report.Replicas, 1)
+
+	rec = httptest.NewRecorder()
+	c.ConfigDriftHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/config/drift", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// ConfigOverridesConfig configures the KV bucket holding fleet-wide config overrides.
+type ConfigOverridesConfig struct {
+	Enabled  bool
+	Bucket   string
+	Replicas int
+}
+
+// LoadConfigOverridesConfig reads the config_overrides.* settings.
+func LoadConfigOverridesConfig() ConfigOverridesConfig {
+	return ConfigOverridesConfig{
+		Enabled:  viper.GetBool("config_overrides.enabled"),
+		Bucket:   viper.GetString("config_overrides.bucket"),
+		Replicas: viper.GetInt("config_overrides.replicas"),
+	}
+}
+
+// overrideParser validates and converts a raw KV value for one config key.
+type overrideParser func(raw string) (any, error)
+
+// overridableKeys lists the config keys that may be overridden via KV.
+// Anything else written to the bucket is ignored with a warning, so the
+// bucket cannot be used to change security-relevant settings like seeds or
+// permissions.
+var overridableKeys = map[string]overrideParser{
+	"logging.level":       parseLogLevelOverride,
+	"auth.monitor_only":   parseBoolOverride,
+	"auth.scope_policy":   parseScopePolicyOverride,
+	"auth.stale_requests": parseStaleRequestsOverride,
+
+	"auth.max_jwts_per_minute": parseLimitOverride,
+
+	"account_budget.mode":            parseAccountBudgetModeOverride,
+	"account_budget.max_connections": parseLimitOverride,
+
+	"token_cache.ttl_overrides": parseTTLOverridesOverride,
+
+	"gitlab.circuitBreakerFailures":    parseLimitOverride,
+	"gitlab.circuitBreakerOpenSeconds": parseLimitOverride,
+
+	"logging.claims_users": parseUsernamesOverride,
+}
+
+// parseUsernamesOverride parses a comma-separated list of usernames.
+func parseUsernamesOverride(raw string) (any, error) {
+	users := []string{}
+	for _, user := range strings.Split(raw, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func parseBoolOverride(raw string) (any, error) {
+	return strconv.ParseBool(strings.TrimSpace(raw))
+}
+
+func parseScopePolicyOverride(raw string) (any, error) {
+	mode := strings.ToLower(strings.TrimSpace(raw))
+	switch mode {
+	case ScopePolicyOff, ScopePolicyWarn, ScopePolicyEnforce:
+		return mode, nil
+	}
+	return nil, fmt.Errorf("unknown scope policy %q", raw)
+}
+
+func parseStaleRequestsOverride(raw string) (any, error) {
+	policy := strings.ToLower(strings.TrimSpace(raw))
+	switch policy {
+	case StaleRequestsProcess, StaleRequestsDrop:
+		return policy, nil
+	}
+	return nil, fmt.Errorf("unknown stale request policy %q", raw)
+}
+
+func parseAccountBudgetModeOverride(raw string) (any, error) {
+	mode := strings.ToLower(strings.TrimSpace(raw))
+	switch mode {
+	case AccountBudgetOff, AccountBudgetWarn, AccountBudgetEnforce:
+		return mode, nil
+	}
+	return nil, fmt.Errorf("unknown account budget mode %q", raw)
+}
+
+func parseLimitOverride(raw string) (any, error) {
+	limit, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid limit %q, expected an integer >= 0", raw)
+	}
+	return limit, nil
+}
+
+func parseLogLevelOverride(raw string) (any, error) {
+	level := strings.ToLower(strings.TrimSpace(raw))
+	switch level {
+	case "debug", "info", "warn", "error":
+		return level, nil
+	}
+	return nil, fmt.Errorf("unknown log level %q", raw)
+}
+
+// overrideHooks are notified whenever an overridable key changes value,
+// for settings that are not re-read from viper on use (e.g. the log level).
+var (
+	overrideHooksMu sync.Mutex
+	overrideHooks   = map[string][]func(value any){}
+)
+
+// OnConfigOverride registers fn to be called with the new effective value
+// whenever key is overridden or an override is removed.
+func OnConfigOverride(key string, fn func(value any)) {
+	overrideHooksMu.Lock()
+	defer overrideHooksMu.Unlock()
+	overrideHooks[key] = append(overrideHooks[key], fn)
+}
+
+func notifyOverrideHooks(key string, value any) {
+	overrideHooksMu.Lock()
+	hooks := append([]func(any){}, overrideHooks[key]...)
+	overrideHooksMu.Unlock()
+
+	for _, fn := range hooks {
+		fn(value)
+	}
+}
+
+// ConfigOverrides watches a KV bucket and applies its entries on top of the
+// local configuration. KV keys are config keys (e.g. "logging.level"), values
+// are plain strings. Deleting a key restores the locally configured value,
+// including changes to it loaded by a config reload meanwhile.
+type ConfigOverrides struct {
+	kv      nats.KeyValue
+	watcher nats.KeyWatcher
+	logger  *slog.Logger
+
+	mu sync.Mutex
+	// overridden holds the currently overridden keys.
+	overridden map[string]bool
+
+	// trace handles the trace.<replica> flags.
+	trace *traceOverride
+}
+
+// NewConfigOverrides binds to (or creates) the overrides bucket. instance
+// identifies this replica in trace flags.
+func NewConfigOverrides(js nats.JetStreamContext, cfg ConfigOverridesConfig, instance string) (*ConfigOverrides, error) {
+	logger := slog.With("component", "config_overrides")
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("config_overrides.bucket is empty")
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+
+	kv, created, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal fleet-wide config overrides",
+		Replicas:    cfg.Replicas,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Config overrides bucket ready", "bucket", cfg.Bucket, "created", created)
+
+	o := newConfigOverrides(kv, logger)
+	o.trace = newTraceOverride(instance, logger)
+	return o, nil
+}
+
+func newConfigOverrides(kv nats.KeyValue, logger *slog.Logger) *ConfigOverrides {
+	return &ConfigOverrides{kv: kv, logger: logger, overridden: make(map[string]bool), trace: newTraceOverride("", logger)}
+}
+
+// Start applies the current overrides and keeps watching for changes.
+// It returns once the initial values have been applied, so that overrides
+// are in effect before authentication requests are processed.
+func (o *ConfigOverrides) Start() error {
+	watcher, err := o.kv.WatchAll()
+	if err != nil {
+		return fmt.Errorf("failed to watch config overrides: %w", err)
+	}
+	o.watcher = watcher
+
+	// The watcher sends a nil entry once all existing values were delivered.
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		o.applyEntry(entry)
+	}
+
+	go func() {
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				o.applyEntry(entry)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the bucket. Applied overrides stay in effect; full
+// tracing ends.
+func (o *ConfigOverrides) Stop() {
+	if o.watcher != nil {
+		_ = o.watcher.Stop()
+	}
+	o.trace.set(time.Time{})
+}
+
+func (o *ConfigOverrides) applyEntry(entry nats.KeyValueEntry) {
+	deleted := entry.Operation() == nats.KeyValueDelete || entry.Operation() == nats.KeyValuePurge
+	var err error
+	if target, ok := strings.CutPrefix(entry.Key(), traceOverridePrefix); ok {
+		err = o.trace.apply(target, string(entry.Value()), entry.Created(), deleted)
+	} else {
+		err = o.apply(entry.Key(), string(entry.Value()), deleted)
+	}
+	if err != nil {
+		o.logger.Warn("Ignoring config override", "key", entry.Key(), "revision", entry.Revision(), "error", err)
+	}
+}
+
+// apply sets (or, when deleted, removes) the override for a single key.
+func (o *ConfigOverrides) apply(key, raw string, deleted bool) error {
+	parse, ok := overridableKeys[key]
+	if !ok {
+		return fmt.Errorf("key is not overridable")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if deleted {
+		if !o.overridden[key] {
+			return nil
+		}
+		delete(o.overridden, key)
+		// A nil set value falls through to the config file, env and defaults,
+		// so the key is not pinned to its value at the time of the override.
+		viper.Set(key, nil)
+		local := viper.Get(key)
+		o.logger.Info("Config override removed", "key", key, "value", local)
+		notifyOverrideHooks(key, local)
+		return nil
+	}
+
+	value, err := parse(raw)
+	if err != nil {
+		return err
+	}
+	o.overridden[key] = true
+	viper.Set(key, value)
+	o.logger.Info("Config override applied", "key", key, "value", value)
+	notifyOverrideHooks(key, value)
+	return nil
+}
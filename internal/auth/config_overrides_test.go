@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigOverridesConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("config_overrides.enabled", true)
+	viper.Set("config_overrides.bucket", "overrides")
+	viper.Set("config_overrides.replicas", 1)
+
+	cfg := LoadConfigOverridesConfig()
+	assert.True(t, cfg.Enabled)
+	assert.Equal(t, "overrides", cfg.Bucket)
+	assert.Equal(t, 1, cfg.Replicas)
+}
+
+func TestConfigOverrides_Apply(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetDefault("logging.level", "info")
+
+	var seen []any
+	OnConfigOverride("logging.level", func(value any) { seen = append(seen, value) })
+
+	o := newConfigOverrides(nil, slog.Default())
+
+	t.Run("rejects keys that are not overridable", func(t *testing.T) {
+		require.Error(t, o.apply("nats.issuer_seed", "SA...", false))
+		assert.Empty(t, viper.GetString("nats.issuer_seed"))
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		require.Error(t, o.apply("logging.level", "loud", false))
+		require.Error(t, o.apply("auth.monitor_only", "maybe", false))
+		assert.Equal(t, "info", viper.GetString("logging.level"))
+	})
+
+	t.Run("applies and restores overrides", func(t *testing.T) {
+		require.NoError(t, o.apply("logging.level", " DEBUG ", false))
+		assert.Equal(t, "debug", viper.GetString("logging.level"))
+
+		require.NoError(t, o.apply("logging.level", "warn", false))
+		assert.Equal(t, "warn", viper.GetString("logging.level"))
+
+		require.NoError(t, o.apply("logging.level", "", true))
+		assert.Equal(t, "info", viper.GetString("logging.level"))
+
+		assert.Equal(t, []any{"debug", "warn", "info"}, seen)
+	})
+
+	t.Run("bool overrides", func(t *testing.T) {
+		require.NoError(t, o.apply("auth.monitor_only", "true", false))
+		assert.True(t, viper.GetBool("auth.monitor_only"))
+
+		require.NoError(t, o.apply("auth.monitor_only", "", true))
+		assert.False(t, viper.GetBool("auth.monitor_only"))
+	})
+
+	t.Run("deleting a key that was never overridden is a no-op", func(t *testing.T) {
+		require.NoError(t, o.apply("auth.monitor_only", "", true))
+	})
+}
+
+func TestConfigOverrides_GitLabBreaker(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetDefault("gitlab.circuitBreakerFailures", 5)
+	viper.SetDefault("gitlab.circuitBreakerOpenSeconds", 30)
+
+	c := &NATSClient{gitlabClient: NewGitLabClient()}
+	c.reloadGitLabOnOverride()
+	o := newConfigOverrides(nil, slog.Default())
+
+	require.NoError(t, o.apply("gitlab.circuitBreakerFailures", "2", false))
+	require.NoError(t, o.apply("gitlab.circuitBreakerOpenSeconds", "60", false))
+	assert.Equal(t, breakerSettings{failures: 2, open: time.Minute}, c.gitlabClient.settings().breaker)
+
+	require.NoError(t, o.apply("gitlab.circuitBreakerFailures", "", true))
+	assert.Equal(t, 5, c.gitlabClient.settings().breaker.failures)
+	require.Error(t, o.apply("gitlab.circuitBreakerOpenSeconds", "-1", false))
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// ReloadConfig re-reads the config file and applies it to the settings that
+// are only read at startup: the permission blocks (global, roles and tenants), the reserved
+// prefixes, the account subjects, the deny messages and the GitLab client
+// settings. Settings
+// read on every request take effect without it. Issued JWTs cached under the
+// old permissions are dropped; connected clients keep their JWTs.
+//
+// Permissions that fail validation are rejected and the previous ones stay
+// in effect. Applied reloads are recorded in the config changelog, if enabled.
+func (c *NATSClient) ReloadConfig(source ReloadSource) error {
+	if err := viper.ReadInConfig(); err != nil {
+		configReloadsTotal.WithLabelValues("failed").Inc()
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := LoadDeployTokensConfig().Validate(); err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid deploy tokens config, keeping the previous config: %w", err)
+	}
+
+	if c.gitlabClient != nil {
+		c.gitlabClient.Reload()
+	}
+
+	reservedPrefixes := LoadReservedPrefixes()
+	if err := ValidateReservedSubjects(reservedPrefixes); err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid permissions config, keeping the previous permissions: %w", err)
+	}
+	if err := LoadRolesConfig().Validate(); err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid roles config, keeping the previous permissions: %w", err)
+	}
+	if err := LoadUserLimits().Validate("nats.limits"); err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid limits, keeping the previous permissions: %w", err)
+	}
+	if err := checkPermissionTemplates(c.logger.Warn); err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid permissions config, keeping the previous permissions: %w", err)
+	}
+	denyTemplates, err := loadDenyTemplates()
+	if err != nil {
+		configReloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("invalid deny messages, keeping the previous config: %w", err)
+	}
+	accountSubjects := LoadAccountSubjects()
+	for _, entry := range ValidateAccountSubjects(accountSubjects) {
+		c.logger.Warn("Permission does not match any account export/import and will never be issued", "permission", entry)
+	}
+
+	c.reloadMu.Lock()
+	if c.permissions != nil {
+		c.permissions = loadPermissionsSnapshot()
+	}
+	c.reservedPrefixes = reservedPrefixes
+	c.accountSubjects = accountSubjects
+	c.denyTemplates = denyTemplates
+	c.reloadMu.Unlock()
+
+	c.jwtCache.Reset(c.jwtConfigHash())
+	configReloadsTotal.WithLabelValues("applied").Inc()
+	c.logger.Info("Config reloaded")
+	c.recordConfigChange(source)
+	c.recordConfigHash()
+	return nil
+}
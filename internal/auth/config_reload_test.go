@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write("nats:\n  permissions:\n    publish:\n      allow: [\"old.{{.Username}}.>\"]\ngitlab:\n  timeout: 5\n")
+	viper.SetConfigFile(path)
+	require.NoError(t, viper.ReadInConfig())
+
+	gitlabClient := NewGitLabClient()
+	c := &NATSClient{logger: slog.Default(), gitlabClient: gitlabClient, permissions: loadPermissionsSnapshot(),
+		reservedPrefixes: LoadReservedPrefixes(), jwtCache: newJWTCache(JWTCacheConfig{TTL: time.Minute, MaxEntries: 10}, "")}
+	c.jwtCache.Reset(c.jwtConfigHash())
+	c.jwtCache.Put("key", "jwt", time.Now())
+	result := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+	assert.Equal(t, []string{"old.alice.>"}, c.resolvePermissions(result, "alice", nil).Publish.Allow)
+
+	write("nats:\n  permissions:\n    publish:\n      allow: [\"new.{{.Username}}.>\"]\ngitlab:\n  timeout: 9\n")
+	require.NoError(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}))
+	assert.Equal(t, []string{"new.alice.>"}, c.resolvePermissions(result, "alice", nil).Publish.Allow)
+	assert.Equal(t, 9*time.Second, gitlabClient.settings().timeout)
+	_, ok := c.jwtCache.Get("key", time.Now())
+	assert.False(t, ok, "JWTs issued under the old permissions are dropped")
+
+	rejected := testutil.ToFloat64(configReloadsTotal.WithLabelValues("rejected"))
+	write("nats:\n  permissions:\n    publish:\n      allow: [\"antal.>\"]\n")
+	assert.ErrorContains(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}), "keeping the previous permissions")
+	assert.Equal(t, []string{"new.alice.>"}, c.resolvePermissions(result, "alice", nil).Publish.Allow)
+	assert.Equal(t, float64(1), testutil.ToFloat64(configReloadsTotal.WithLabelValues("rejected"))-rejected)
+
+	require.NoError(t, os.Remove(path))
+	assert.ErrorContains(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}), "failed to read config file")
+}
+
+func TestReloadConfig_LimitsResetJWTCache(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write("nats:\n  limits:\n    subs: 100\n")
+	viper.SetConfigFile(path)
+	require.NoError(t, viper.ReadInConfig())
+
+	c := &NATSClient{logger: slog.Default(), gitlabClient: NewGitLabClient(), permissions: loadPermissionsSnapshot(),
+		reservedPrefixes: LoadReservedPrefixes(), jwtCache: newJWTCache(JWTCacheConfig{TTL: time.Minute, MaxEntries: 10}, "")}
+	c.jwtCache.Reset(c.jwtConfigHash())
+	c.jwtCache.Put("key", "jwt", time.Now())
+
+	write("nats:\n  limits:\n    subs: 10\n")
+	require.NoError(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}))
+	assert.Equal(t, int64(10), c.limitsConfig().Subs)
+	_, ok := c.jwtCache.Get("key", time.Now())
+	assert.False(t, ok, "JWTs issued under the old limits are dropped")
+}
+
+func TestReloadConfig_RemovedOverrideFallsBackToFile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write("logging:\n  level: info\n")
+	viper.SetConfigFile(path)
+	require.NoError(t, viper.ReadInConfig())
+
+	o := newConfigOverrides(nil, slog.Default())
+	c := &NATSClient{logger: slog.Default(), configOverrides: o}
+	require.NoError(t, o.apply("logging.level", "debug", false))
+
+	write("logging:\n  level: warn\n")
+	require.NoError(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}))
+	assert.Equal(t, "debug", viper.GetString("logging.level"), "the override survives the reload")
+
+	require.NoError(t, o.apply("logging.level", "", true))
+	assert.Equal(t, "warn", viper.GetString("logging.level"), "removing the override restores the reloaded value")
+
+	write("logging:\n  level: error\n")
+	require.NoError(t, c.ReloadConfig(ReloadSource{Trigger: ReloadTriggerSIGHUP}))
+	assert.Equal(t, "error", viper.GetString("logging.level"), "the key is no longer pinned")
+}
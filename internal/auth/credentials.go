@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+)
+
+// NATSCredentials are the credentials of a NATS connection: a user and
+// password, a creds file (user JWT and nkey seed, as for decentralized auth)
+// or the seed of a user nkey listed in the servers' config. At most one kind
+// is set; none connects without credentials.
+type NATSCredentials struct {
+	User      string
+	Pass      string
+	CredsFile string
+	NkeySeed  string
+}
+
+// LoadNATSCredentials reads the credentials of the auth connection (nats.*).
+func LoadNATSCredentials() NATSCredentials {
+	return NATSCredentials{
+		User:      viper.GetString("nats.user"),
+		Pass:      viper.GetString("nats.pass"),
+		CredsFile: viper.GetString("nats.creds_file"),
+		NkeySeed:  viper.GetString("nats.nkey_seed"),
+	}
+}
+
+// Validate checks that at most one kind of credentials is set; prefix names
+// the settings in errors.
+func (creds NATSCredentials) Validate(prefix string) error {
+	kinds := 0
+	for _, set := range []bool{creds.User != "" || creds.Pass != "", creds.CredsFile != "", creds.NkeySeed != ""} {
+		if set {
+			kinds++
+		}
+	}
+	switch {
+	case kinds > 1:
+		return fmt.Errorf("%s: set only one of user/pass, creds_file and nkey_seed", prefix)
+	case (creds.User != "") != (creds.Pass != ""):
+		return fmt.Errorf("%s: user and pass must both be set", prefix)
+	case creds.NkeySeed != "":
+		if _, err := userNkey(creds.NkeySeed); err != nil {
+			return fmt.Errorf("%s: invalid nkey_seed: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// userNkey parses the seed of a user nkey.
+func userNkey(seed string) (nkeys.KeyPair, error) {
+	kp, err := nkeys.FromSeed([]byte(seed))
+	if err != nil {
+		return nil, err
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if !nkeys.IsValidPublicUserKey(pub) {
+		return nil, fmt.Errorf("not a user seed (SU...)")
+	}
+	return kp, nil
+}
+
+// Options returns the connection options authenticating with the
+// credentials. An invalid nkey seed adds none; Validate reports it.
+func (creds NATSCredentials) Options() []nats.Option {
+	switch {
+	case creds.CredsFile != "":
+		return []nats.Option{nats.UserCredentials(creds.CredsFile)}
+	case creds.NkeySeed != "":
+		kp, err := userNkey(creds.NkeySeed)
+		if err != nil {
+			return nil
+		}
+		pub, _ := kp.PublicKey()
+		return []nats.Option{nats.Nkey(pub, kp.Sign)}
+	case creds.User != "" && creds.Pass != "":
+		return []nats.Option{nats.UserInfo(creds.User, creds.Pass)}
+	}
+	return nil
+}
+
+// AuthUser returns how the servers know the connection: the user, the
+// public user nkey or the subject of the creds file's user JWT. It belongs
+// in auth_callout.auth_users, so the connection bypasses the callout.
+func (creds NATSCredentials) AuthUser() (string, error) {
+	switch {
+	case creds.CredsFile != "":
+		data, err := os.ReadFile(creds.CredsFile)
+		if err != nil {
+			return "", fmt.Errorf("creds_file: %w", err)
+		}
+		token, err := jwt.ParseDecoratedJWT(data)
+		if err != nil {
+			return "", fmt.Errorf("creds_file: %w", err)
+		}
+		uc, err := jwt.DecodeUserClaims(token)
+		if err != nil {
+			return "", fmt.Errorf("creds_file: %w", err)
+		}
+		return uc.Subject, nil
+	case creds.NkeySeed != "":
+		kp, err := userNkey(creds.NkeySeed)
+		if err != nil {
+			return "", fmt.Errorf("invalid nkey_seed: %w", err)
+		}
+		return kp.PublicKey()
+	}
+	return creds.User, nil
+}
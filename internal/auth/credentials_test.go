@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCredsFile writes a creds file of a new user and returns its path and
+// the user's public key.
+func writeCredsFile(t *testing.T) (string, string) {
+	t.Helper()
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	seed, _ := user.Seed()
+	pub, _ := user.PublicKey()
+	account, _, _ := newAccountKey(t)
+	userJwt, err := jwt.NewUserClaims(pub).Encode(account)
+	require.NoError(t, err)
+	creds, err := jwt.FormatUserConfig(userJwt, seed)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "auth.creds")
+	require.NoError(t, os.WriteFile(path, creds, 0o600))
+	return path, pub
+}
+
+func TestNATSCredentials_Validate(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userSeed, _ := user.Seed()
+	_, accountSeed, _ := newAccountKey(t)
+
+	for name, tc := range map[string]struct {
+		creds NATSCredentials
+		err   string
+	}{
+		"none":           {NATSCredentials{}, ""},
+		"user and pass":  {NATSCredentials{User: "auth", Pass: "secret"}, ""},
+		"creds file":     {NATSCredentials{CredsFile: "auth.creds"}, ""},
+		"nkey seed":      {NATSCredentials{NkeySeed: string(userSeed)}, ""},
+		"pass missing":   {NATSCredentials{User: "auth"}, "user and pass must both be set"},
+		"two kinds":      {NATSCredentials{User: "auth", Pass: "secret", CredsFile: "auth.creds"}, "only one of"},
+		"account seed":   {NATSCredentials{NkeySeed: accountSeed}, "not a user seed"},
+		"malformed seed": {NATSCredentials{NkeySeed: "SUxxx"}, "invalid nkey_seed"},
+	} {
+		err := tc.creds.Validate("nats")
+		if tc.err == "" {
+			assert.NoError(t, err, name)
+		} else {
+			assert.ErrorContains(t, err, tc.err, name)
+		}
+	}
+}
+
+func TestNATSCredentials_AuthUser(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userSeed, _ := user.Seed()
+	userPub, _ := user.PublicKey()
+
+	name, err := NATSCredentials{User: "auth", Pass: "secret"}.AuthUser()
+	require.NoError(t, err)
+	assert.Equal(t, "auth", name)
+
+	name, err = NATSCredentials{NkeySeed: string(userSeed)}.AuthUser()
+	require.NoError(t, err)
+	assert.Equal(t, userPub, name)
+
+	path, credsPub := writeCredsFile(t)
+	name, err = NATSCredentials{CredsFile: path}.AuthUser()
+	require.NoError(t, err)
+	assert.Equal(t, credsPub, name, "the subject of the creds file's JWT")
+
+	_, err = NATSCredentials{CredsFile: filepath.Join(t.TempDir(), "missing.creds")}.AuthUser()
+	assert.ErrorContains(t, err, "creds_file")
+}
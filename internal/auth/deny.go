@@ -0,0 +1,66 @@
+package auth
+
+// DenyCode is a stable, machine-readable reason attached to every denied
+// authorization response. The response error has the form "<code>: <message>",
+// so operators and client tooling can match on the code while the message
+// stays human-readable.
+//
+// NOTE: pkg/antalclient mirrors these values; keep both in sync.
+type DenyCode string
+
+const (
+	// DenyInvalidRequest means the authorization request could not be decoded.
+	DenyInvalidRequest DenyCode = "invalid_request"
+	// DenyInvalidCredentials means GitLab rejected the token (or the cache had no entry during an outage).
+	DenyInvalidCredentials DenyCode = "invalid_credentials"
+	// DenyAuthError means the token could not be verified because of an internal or upstream error.
+	DenyAuthError DenyCode = "auth_error"
+	// DenyInvalidClaims means the user claims built from configuration failed validation.
+	DenyInvalidClaims DenyCode = "invalid_claims"
+	// DenyInternalError means the user JWT could not be produced.
+	DenyInternalError DenyCode = "internal_error"
+	// DenyExcessiveScopes means the token carries scopes rejected by the scope policy.
+	DenyExcessiveScopes DenyCode = "excessive_scopes"
+	// DenyRateLimited means the user exceeded auth.max_jwts_per_minute, or the
+	// username or client IP exceeded auth.max_attempts_*.
+	DenyRateLimited DenyCode = "rate_limited"
+	// DenyAccountAtCapacity means the users' account reached account_budget.max_connections.
+	DenyAccountAtCapacity DenyCode = "account_at_capacity"
+	// DenyUsernameRequired means the connect options carried no username and
+	// none could be taken from the token (or auth.empty_username is deny).
+	DenyUsernameRequired DenyCode = "username_required"
+	// DenyRetryLater means the request was shed because too many requests
+	// were queued; the message carries the suggested delay.
+	DenyRetryLater DenyCode = "retry_later"
+	// DenyLockedOut means the username is locked out after repeated invalid
+	// tokens; the message carries when the lockout ends.
+	DenyLockedOut DenyCode = "locked_out"
+	// DenyPasswordSent means GitLab did not know the credential and it looks
+	// like an account password rather than a token (auth.password_detection).
+	DenyPasswordSent DenyCode = "password_sent"
+	// DenyUsernameMismatch means the connect options username is not the
+	// token owner's (auth.require_token_owner).
+	DenyUsernameMismatch DenyCode = "username_mismatch"
+)
+
+// denyCodes lists every DenyCode.
+var denyCodes = []DenyCode{
+	DenyInvalidRequest,
+	DenyInvalidCredentials,
+	DenyAuthError,
+	DenyInvalidClaims,
+	DenyInternalError,
+	DenyExcessiveScopes,
+	DenyRateLimited,
+	DenyAccountAtCapacity,
+	DenyUsernameRequired,
+	DenyRetryLater,
+	DenyLockedOut,
+	DenyPasswordSent,
+	DenyUsernameMismatch,
+}
+
+// denyMessage formats the error string sent back to the NATS server.
+func denyMessage(code DenyCode, msg string) string {
+	return string(code) + ": " + msg
+}
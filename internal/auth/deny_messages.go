@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// denyMessageData is what a deny message template renders.
+type denyMessageData struct {
+	// Code is the deny code, e.g. invalid_credentials.
+	Code DenyCode
+	// Message is the built-in English message.
+	Message string
+	// Username is the username the client connected with (may be empty).
+	Username string
+	// HelpURL is deny_messages.help_url.
+	HelpURL string
+}
+
+// denyTemplates renders the human-readable part of deny responses from the
+// deny_messages.* settings. The "<code>: " prefix is always kept, so client
+// tooling matching on the code is unaffected.
+type denyTemplates struct {
+	helpURL   string
+	templates map[DenyCode]*template.Template
+}
+
+// loadDenyTemplates parses deny_messages.templates. Templates for unknown
+// codes are rejected, as they are most likely typos.
+func loadDenyTemplates() (*denyTemplates, error) {
+	raw := viper.GetStringMapString("deny_messages.templates")
+	d := &denyTemplates{
+		helpURL:   viper.GetString("deny_messages.help_url"),
+		templates: make(map[DenyCode]*template.Template, len(raw)),
+	}
+	for _, key := range sortedKeys(raw) {
+		code := DenyCode(strings.ToLower(key))
+		if !slices.Contains(denyCodes, code) {
+			return nil, fmt.Errorf("deny_messages.templates: unknown deny code %q", key)
+		}
+		tmpl, err := template.New(string(code)).Option("missingkey=error").Parse(raw[key])
+		if err != nil {
+			return nil, fmt.Errorf("deny_messages.templates.%s: %w", key, err)
+		}
+		d.templates[code] = tmpl
+	}
+	return d, nil
+}
+
+// render returns the deny response for code. Without a template, or when the
+// template fails, the built-in message is used.
+func (d *denyTemplates) render(code DenyCode, msg, username string) (string, error) {
+	if d == nil {
+		return denyMessage(code, msg), nil
+	}
+	tmpl, ok := d.templates[code]
+	if !ok {
+		return denyMessage(code, msg), nil
+	}
+	var out strings.Builder
+	data := denyMessageData{Code: code, Message: msg, Username: username, HelpURL: d.helpURL}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return denyMessage(code, msg), err
+	}
+	return denyMessage(code, out.String()), nil
+}
+
+// denyResponse renders the deny response for code with the operator's
+// template, if any.
+func (c *NATSClient) denyResponse(code DenyCode, msg, username string) string {
+	c.reloadMu.RLock()
+	templates := c.denyTemplates
+	c.reloadMu.RUnlock()
+
+	text, err := templates.render(code, msg, username)
+	if err != nil {
+		c.logger.Warn("Failed to render deny message template", "code", code, "error", err)
+	}
+	return text
+}
+
+// initDenyTemplates parses the operator's deny message templates.
+func (c *NATSClient) initDenyTemplates() error {
+	templates, err := loadDenyTemplates()
+	if err != nil {
+		return err
+	}
+	if len(templates.templates) > 0 {
+		c.logger.Info("Custom deny messages enabled", "codes", slices.Sorted(maps.Keys(templates.templates)))
+	}
+	c.denyTemplates = templates
+	return nil
+}
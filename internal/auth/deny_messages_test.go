@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenyTemplates(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Reset()
+	viper.Set("deny_messages.help_url", "https://wiki.example/nats")
+	viper.Set("deny_messages.templates", map[string]string{
+		"invalid_credentials": "{{.Username}}: create a new token, see {{.HelpURL}}",
+		"excessive_scopes":    "{{.Message}} ({{.Code}})",
+		"rate_limited":        "{{.Missing}}",
+	})
+
+	templates, err := loadDenyTemplates()
+	require.NoError(t, err)
+
+	msg, err := templates.render(DenyInvalidCredentials, "invalid credentials", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_credentials: alice: create a new token, see https://wiki.example/nats", msg)
+
+	msg, err = templates.render(DenyExcessiveScopes, "token scopes exceed the allowed scopes", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "excessive_scopes: token scopes exceed the allowed scopes (excessive_scopes)", msg)
+
+	msg, err = templates.render(DenyAuthError, "authentication error", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "auth_error: authentication error", msg, "codes without a template keep the built-in message")
+
+	msg, err = templates.render(DenyRateLimited, "too many JWTs issued", "alice")
+	assert.Error(t, err)
+	assert.Equal(t, "rate_limited: too many JWTs issued", msg, "a failing template falls back")
+
+	var none *denyTemplates
+	msg, err = none.render(DenyInternalError, "internal error", "")
+	require.NoError(t, err)
+	assert.Equal(t, "internal_error: internal error", msg)
+}
+
+func TestLoadDenyTemplates_Invalid(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Reset()
+	viper.Set("deny_messages.templates", map[string]string{"invalid_credential": "typo"})
+	_, err := loadDenyTemplates()
+	assert.ErrorContains(t, err, "unknown deny code")
+
+	viper.Reset()
+	viper.Set("deny_messages.templates", map[string]string{"auth_error": "{{.Message"})
+	_, err = loadDenyTemplates()
+	assert.ErrorContains(t, err, "deny_messages.templates.auth_error")
+}
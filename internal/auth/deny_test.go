@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/antalclient"
+)
+
+// The client helper package mirrors the server's deny codes; make sure they
+// never drift apart.
+func TestDenyCodes_InSyncWithClientPackage(t *testing.T) {
+	pairs := map[DenyCode]antalclient.DenyCode{
+		DenyInvalidRequest:     antalclient.DenyInvalidRequest,
+		DenyInvalidCredentials: antalclient.DenyInvalidCredentials,
+		DenyAuthError:          antalclient.DenyAuthError,
+		DenyInvalidClaims:      antalclient.DenyInvalidClaims,
+		DenyInternalError:      antalclient.DenyInternalError,
+		DenyExcessiveScopes:    antalclient.DenyExcessiveScopes,
+		DenyRateLimited:        antalclient.DenyRateLimited,
+		DenyAccountAtCapacity:  antalclient.DenyAccountAtCapacity,
+		DenyUsernameRequired:   antalclient.DenyUsernameRequired,
+		DenyRetryLater:         antalclient.DenyRetryLater,
+		DenyLockedOut:          antalclient.DenyLockedOut,
+		DenyPasswordSent:       antalclient.DenyPasswordSent,
+		DenyUsernameMismatch:   antalclient.DenyUsernameMismatch,
+	}
+	assert.Len(t, denyCodes, len(pairs), "denyCodes lists every code")
+	for server, client := range pairs {
+		assert.Equal(t, string(server), string(client))
+
+		code, ok := antalclient.ParseDenyCode(denyMessage(server, "details: with colon"))
+		assert.True(t, ok)
+		assert.Equal(t, client, code)
+	}
+}
@@ -6,24 +6,55 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/spf13/viper"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
 )
 
 // GitLabClient handles interactions with GitLab API
 type GitLabClient struct {
-	baseURL           string
+	baseURL string
+
+	// settingsMu guards the settings below, which are replaced when the
+	// config file is reloaded.
+	settingsMu        sync.RWMutex
 	timeout           time.Duration
 	retries           int
 	retryDelaySeconds time.Duration
+	rateLimitPause    time.Duration
+	breaker           breakerSettings
+	deployTokens      DeployTokensConfig
+	jobTokens         JobTokensConfig
+	// fetchGroups enables looking up the token owner's top-level groups.
+	fetchGroups bool
+
+	// pausedUntil is shared by all verifications: after a 429 nobody calls
+	// GitLab until the pause is over.
+	pauseMu     sync.Mutex
+	pausedUntil time.Time
+
+	// circuit is shared by all verifications, like the pause.
+	circuit circuitBreaker
 }
 
 type VerifiedToken struct {
+	// UserID is the numeric GitLab user ID; unlike the username, it survives renames.
+	UserID   int64
 	Username string
 	Scopes   []string
+	// TokenType is TokenTypePersonal, TokenTypeOAuth, TokenTypeDeploy or
+	// TokenTypeJob.
+	TokenType string
+	// Project is the full path of the project running the job; only set
+	// for CI/CD job tokens.
+	Project string
+	// Groups are the token owner's top-level group paths; only fetched when
+	// group-based features (tenants, roles by group) are configured.
+	Groups []string
 }
 
 // NewGitLabClient creates a new GitLab client
@@ -33,12 +64,62 @@ func NewGitLabClient() *GitLabClient {
 		timeout:           time.Duration(viper.GetInt("gitlab.timeout")) * time.Second,
 		retries:           viper.GetInt("gitlab.retries"),
 		retryDelaySeconds: time.Duration(viper.GetInt("gitlab.retryDelaySeconds")) * time.Second,
+		rateLimitPause:    time.Duration(viper.GetInt("gitlab.rateLimitPauseSeconds")) * time.Second,
+		deployTokens:      LoadDeployTokensConfig(),
+		jobTokens:         LoadJobTokensConfig(),
+		fetchGroups:       LoadTenantsConfig().Enabled() || len(LoadRolesConfig().Groups) > 0 || accountsConfigured() || teamsConfigured(),
+		breaker: breakerSettings{
+			failures: viper.GetInt("gitlab.circuitBreakerFailures"),
+			open:     time.Duration(viper.GetInt("gitlab.circuitBreakerOpenSeconds")) * time.Second,
+		},
 	}
 }
 
+// gitlabSettings are the GitLab client settings applied to each verification.
+type gitlabSettings struct {
+	timeout        time.Duration
+	retries        int
+	retryDelay     time.Duration
+	rateLimitPause time.Duration
+	breaker        breakerSettings
+	deployTokens   DeployTokensConfig
+	jobTokens      JobTokensConfig
+}
+
+// settings returns the current verification settings.
+func (c *GitLabClient) settings() gitlabSettings {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	return gitlabSettings{timeout: c.timeout, retries: c.retries, retryDelay: c.retryDelaySeconds, rateLimitPause: c.rateLimitPause, breaker: c.breaker, deployTokens: c.deployTokens, jobTokens: c.jobTokens}
+}
+
+// Reload re-reads gitlab.timeout, gitlab.retries, gitlab.retryDelaySeconds,
+// gitlab.rateLimitPauseSeconds, gitlab.circuitBreaker*, deploy_tokens.* and
+// ci_job_tokens.*. Verifications in progress keep the settings they started
+// with.
+func (c *GitLabClient) Reload() {
+	fresh := NewGitLabClient()
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	c.timeout = fresh.timeout
+	c.retries = fresh.retries
+	c.retryDelaySeconds = fresh.retryDelaySeconds
+	c.rateLimitPause = fresh.rateLimitPause
+	c.breaker = fresh.breaker
+	c.deployTokens = fresh.deployTokens
+	c.jobTokens = fresh.jobTokens
+}
+
 // VerifyTokenInfo checks if the provided token is valid and, on success,
 // returns basic information needed for caching.
 func (c *GitLabClient) VerifyTokenInfo(token string) (*VerifiedToken, error) {
+	return c.VerifyTokenInfoContext(context.Background(), token)
+}
+
+// VerifyTokenInfoContext is VerifyTokenInfo bounded by ctx: every attempt
+// ends at the context's deadline at the latest, and no retry is started
+// that could not finish before it.
+func (c *GitLabClient) VerifyTokenInfoContext(parent context.Context, token string) (*VerifiedToken, error) {
 	logger := slog.With("service", "gitlab")
 	logger.Debug("Verifying GitLab token")
 
@@ -48,8 +129,40 @@ func (c *GitLabClient) VerifyTokenInfo(token string) (*VerifiedToken, error) {
 		return nil, ErrInvalidToken
 	}
 
+	// Don't call GitLab at all while it asked us to back off
+	if until, paused := c.pausedUntilTime(); paused {
+		logger.Debug("GitLab verification paused after rate limiting", "until", until)
+		return nil, fmt.Errorf("%w until %s", ErrGitLabRateLimited, until.Format(time.RFC3339))
+	}
+
+	// Nor while it is down: fail fast to the token cache
+	settings := c.settings()
+	if until, ok := c.circuit.Allow(settings.breaker, time.Now()); !ok {
+		logger.Debug("GitLab circuit breaker open", "until", until)
+		return nil, fmt.Errorf("%w until %s", ErrGitLabCircuitOpen, until.Format(time.RFC3339))
+	}
+	vt, err := c.verifyTokenInfo(parent, token, settings)
+	if c.circuit.Record(err, settings.breaker, time.Now()) {
+		logger.Warn("GitLab unavailable, circuit breaker opened", "failures", settings.breaker.failures, "open_for", settings.breaker.open)
+	}
+	return vt, err
+}
+
+// verifyTokenInfo calls GitLab to verify a token.
+func (c *GitLabClient) verifyTokenInfo(parent context.Context, token string, settings gitlabSettings) (*VerifiedToken, error) {
+	logger := slog.With("service", "gitlab")
+
+	// Deploy and job tokens cannot read their owner; they are looked up instead
+	kind := tokenType(token)
+	switch kind {
+	case TokenTypeDeploy:
+		return c.verifyDeployToken(parent, token, settings)
+	case TokenTypeJob:
+		return c.verifyJobToken(parent, token, settings)
+	}
+
 	// Initialize the GitLab client with the user's token and custom base URL
-	git, err := gitlab.NewClient(token, gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", c.baseURL)))
+	git, err := c.newAPIClient(token)
 	if err != nil {
 		logger.Error("Failed to create GitLab client", "error", err)
 		sentry.CaptureException(err)
@@ -57,30 +170,45 @@ func (c *GitLabClient) VerifyTokenInfo(token string) (*VerifiedToken, error) {
 	}
 
 	// Try to get the current user (token owner) with retries
-	maxAttempts := c.retries + 1
+	maxAttempts := settings.retries + 1
 	var lastErr error
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
 		// Create fresh context with timeout for each attempt
-		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancel := context.WithTimeout(parent, settings.timeout)
 		user, _, err := git.Users.CurrentUser(gitlab.WithContext(ctx))
 
 		var scopes []string
-		if err == nil {
+		if err == nil && user != nil {
 			// Best-effort: retrieve token scopes for caching.
 			// Not all token types may support this endpoint.
-			pat, _, patErr := git.PersonalAccessTokens.GetSinglePersonalAccessToken(gitlab.WithContext(ctx))
-			if patErr == nil && pat != nil {
-				scopes = pat.Scopes
-			} else if patErr != nil {
+			var scopesErr error
+			scopes, scopesErr = c.tokenScopes(ctx, git, token, kind, user.ID)
+			if scopesErr != nil {
 				// If the token is unauthorized, treat it as invalid.
-				if isUnauthorizedError(patErr) {
+				if isUnauthorizedError(scopesErr) || errors.Is(scopesErr, ErrInvalidToken) {
+					recordGitLabError(scopesErr)
 					cancel()
-					logger.Info("GitLab token validation failed", "error", patErr)
+					logger.Info("GitLab token validation failed", "token_type", kind, "error", scopesErr)
 					return nil, ErrInvalidToken
 				}
 				// Non-fatal: we still consider the token verified based on CurrentUser.
-				logger.Debug("Unable to retrieve token scopes", "error", patErr)
+				logger.Debug("Unable to retrieve token scopes", "token_type", kind, "error", scopesErr)
+			}
+		}
+		var groups []string
+		if err == nil && c.fetchGroups {
+			var groupsErr error
+			groups, groupsErr = listTopLevelGroups(ctx, git)
+			if groupsErr != nil {
+				if isUnauthorizedError(groupsErr) {
+					recordGitLabError(groupsErr)
+					cancel()
+					logger.Info("GitLab token validation failed", "error", groupsErr)
+					return nil, ErrInvalidToken
+				}
+				// Non-fatal: the user is treated as not belonging to any group.
+				logger.Debug("Unable to retrieve token owner groups", "error", groupsErr)
 			}
 		}
 		cancel() // Cancel immediately after the call(s)
@@ -90,22 +218,39 @@ func (c *GitLabClient) VerifyTokenInfo(token string) (*VerifiedToken, error) {
 				logger.Info("GitLab returned an empty user")
 				return nil, ErrInvalidToken
 			}
-			logger.Info("GitLab token verification successful", "token_username", user.Username, "scopes", strings.Join(scopes, ","))
-			return &VerifiedToken{Username: user.Username, Scopes: scopes}, nil
+			logger.Info("GitLab token verification successful", "token_username", user.Username, "token_type", kind, "scopes", strings.Join(scopes, ","))
+			return &VerifiedToken{UserID: user.ID, Username: user.Username, Scopes: scopes, Groups: groups, TokenType: kind}, nil
 		}
 
+		recordGitLabError(err)
+
 		// Check if it's an authentication error (401 Unauthorized)
 		if isUnauthorizedError(err) {
 			logger.Info("GitLab token validation failed", "error", err)
+			if isUnknownTokenError(err) {
+				return nil, errUnknownToken
+			}
 			return nil, ErrInvalidToken
 		}
 
+		// GitLab is throttling us: pause all verifications instead of retrying
+		if pause, limited := retryAfterFromError(err, settings.rateLimitPause, time.Now()); limited {
+			until := c.pauseFor(pause)
+			gitlabRateLimitPausesTotal.Inc()
+			logger.Warn("GitLab rate limit hit, pausing verification", "retry_after", pause, "until", until)
+			return nil, fmt.Errorf("%w: %w", ErrGitLabRateLimited, err)
+		}
+
 		// Store the error for potential retry
 		lastErr = err
 
 		// Check if we should retry
 		if attempt < maxAttempts-1 {
-			delay := c.retryDelaySeconds
+			delay := settings.retryDelay
+			if deadline, ok := parent.Deadline(); ok && time.Until(deadline) <= delay {
+				logger.Warn("GitLab API call failed, no time left to retry", "attempt", attempt+1, "error", err)
+				return nil, fmt.Errorf("error calling GitLab API, request deadline reached: %w: %w", context.DeadlineExceeded, err)
+			}
 			logger.Warn("GitLab API call failed, retrying", "attempt", attempt+1, "max_attempts", maxAttempts, "error", err)
 			timeSleep(delay)
 		}
@@ -132,7 +277,7 @@ func (c *GitLabClient) VerifyToken(token string) (bool, error) {
 	}
 
 	// Initialize the GitLab client with the user's token and custom base URL
-	git, err := gitlab.NewClient(token, gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", c.baseURL)))
+	git, err := c.newAPIClient(token)
 	if err != nil {
 		logger.Error("Failed to create GitLab client", "error", err)
 		sentry.CaptureException(err)
@@ -140,12 +285,13 @@ func (c *GitLabClient) VerifyToken(token string) (bool, error) {
 	}
 
 	// Try to get the current user (token owner) with retries
-	maxAttempts := c.retries + 1
+	settings := c.settings()
+	maxAttempts := settings.retries + 1
 	var lastErr error
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
 		// Create fresh context with timeout for each attempt
-		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), settings.timeout)
 		user, _, err := git.Users.CurrentUser(gitlab.WithContext(ctx))
 		cancel() // Cancel immediately after the call
 
@@ -158,6 +304,8 @@ func (c *GitLabClient) VerifyToken(token string) (bool, error) {
 			return true, nil
 		}
 
+		recordGitLabError(err)
+
 		// Check if it's an authentication error (401 Unauthorized)
 		if isUnauthorizedError(err) {
 			logger.Info("GitLab token validation failed", "error", err)
@@ -169,7 +317,7 @@ func (c *GitLabClient) VerifyToken(token string) (bool, error) {
 
 		// Check if we should retry
 		if attempt < maxAttempts-1 {
-			delay := c.retryDelaySeconds
+			delay := settings.retryDelay
 			logger.Warn("GitLab API call failed, retrying", "attempt", attempt+1, "max_attempts", maxAttempts, "error", err)
 			timeSleep(delay)
 		}
@@ -181,6 +329,39 @@ func (c *GitLabClient) VerifyToken(token string) (bool, error) {
 	return false, fmt.Errorf("error calling GitLab API after %d attempts: %w", maxAttempts, lastErr)
 }
 
+// listTopLevelGroups returns the paths of the top-level groups the token
+// owner is a member of. Only the first 100 groups are considered.
+func listTopLevelGroups(ctx context.Context, git *gitlab.Client) ([]string, error) {
+	groups, _, err := git.Groups.ListGroups(&gitlab.ListGroupsOptions{
+		ListOptions:    gitlab.ListOptions{PerPage: 100},
+		TopLevelOnly:   gitlab.Ptr(true),
+		MinAccessLevel: gitlab.Ptr(gitlab.GuestPermissions),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(groups))
+	for _, g := range groups {
+		paths = append(paths, g.FullPath)
+	}
+	return paths, nil
+}
+
+// newAPIClient creates a GitLab API client authenticated with the user's
+// token: as a bearer token for OAuth2 access tokens, else as a private token.
+func (c *GitLabClient) newAPIClient(token string) (*gitlab.Client, error) {
+	opts := []gitlab.ClientOptionFunc{
+		gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", c.baseURL)),
+		gitlab.WithCustomRetry(gitlabCheckRetry),
+		gitlab.WithInterceptor(instrumentGitLab),
+	}
+	if tokenType(token) == TokenTypeOAuth {
+		return gitlab.NewOAuthClient(token, opts...)
+	}
+	return gitlab.NewClient(token, opts...)
+}
+
 // isUnauthorizedError checks if the error is an HTTP 401 Unauthorized error
 func isUnauthorizedError(err error) bool {
 	if err == nil {
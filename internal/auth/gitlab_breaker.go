@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrGitLabCircuitOpen is returned while the circuit breaker is open after
+// consecutive GitLab outages. Authorization falls back to the token cache.
+var ErrGitLabCircuitOpen = errors.New("gitlab circuit breaker open")
+
+// Circuit breaker states, as reported by gcs_antal_gitlab_circuit_breaker_state.
+const (
+	breakerClosed   = 0
+	breakerOpen     = 1
+	breakerHalfOpen = 2
+)
+
+// breakerSettings are the gitlab.circuitBreaker* settings.
+type breakerSettings struct {
+	// failures is the number of consecutive failed verifications that opens
+	// the breaker; 0 disables it.
+	failures int
+	// open is how long the breaker stays open before one verification is let
+	// through to probe GitLab.
+	open time.Duration
+}
+
+// circuitBreaker stops calling GitLab while it is down, so auth requests go
+// straight to the token cache instead of spending timeout × retries each.
+// The zero value is a closed breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// probing is set while the one verification let through after the open
+	// period is in flight (half-open).
+	probing bool
+}
+
+// Allow reports whether a verification may call GitLab. When it may not,
+// it returns when the breaker lets the next probe through.
+func (b *circuitBreaker) Allow(settings breakerSettings, now time.Time) (time.Time, bool) {
+	if settings.failures <= 0 {
+		return time.Time{}, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.failures < settings.failures:
+		return time.Time{}, true
+	case now.Before(b.openUntil) || b.probing:
+		return b.openUntil, false
+	}
+	b.probing = true
+	gitlabBreakerState.Set(breakerHalfOpen)
+	return time.Time{}, true
+}
+
+// Record counts the outcome of a verification that called GitLab. Outages
+// (timeouts, network errors, 5xx) count as failures; any answer from GitLab,
+// an invalid token or a 429 included, closes the breaker. It reports whether
+// the breaker opened.
+func (b *circuitBreaker) Record(err error, settings breakerSettings, now time.Time) bool {
+	if settings.failures <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isFallbackToCacheError(err) || errors.Is(err, ErrGitLabRateLimited) {
+		if b.failures > 0 || b.probing {
+			gitlabBreakerState.Set(breakerClosed)
+		}
+		b.failures, b.probing = 0, false
+		return false
+	}
+
+	b.failures++
+	if b.failures < settings.failures && !b.probing {
+		return false
+	}
+	b.failures = max(b.failures, settings.failures)
+	b.openUntil, b.probing = now.Add(settings.open), false
+	gitlabBreakerState.Set(breakerOpen)
+	gitlabBreakerOpensTotal.Inc()
+	return true
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	settings := breakerSettings{failures: 2, open: 30 * time.Second}
+	outage := context.DeadlineExceeded
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	var b circuitBreaker
+
+	_, ok := b.Allow(settings, now)
+	require.True(t, ok)
+	assert.False(t, b.Record(outage, settings, now))
+	assert.False(t, b.Record(nil, settings, now), "a success resets the count")
+	assert.False(t, b.Record(outage, settings, now))
+	assert.True(t, b.Record(outage, settings, now), "opens after consecutive failures")
+
+	until, ok := b.Allow(settings, now.Add(10*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, now.Add(30*time.Second), until)
+
+	// Half-open: one probe, everybody else keeps failing fast.
+	_, ok = b.Allow(settings, now.Add(31*time.Second))
+	require.True(t, ok)
+	_, ok = b.Allow(settings, now.Add(31*time.Second))
+	assert.False(t, ok)
+	assert.True(t, b.Record(outage, settings, now.Add(32*time.Second)), "a failed probe opens it again")
+	_, ok = b.Allow(settings, now.Add(40*time.Second))
+	assert.False(t, ok)
+
+	_, ok = b.Allow(settings, now.Add(63*time.Second))
+	require.True(t, ok)
+	assert.False(t, b.Record(ErrInvalidToken, settings, now.Add(63*time.Second)), "any answer from GitLab closes it")
+	_, ok = b.Allow(settings, now.Add(63*time.Second))
+	assert.True(t, ok)
+
+	var disabled circuitBreaker
+	for range 5 {
+		assert.False(t, disabled.Record(outage, breakerSettings{}, now))
+	}
+	_, ok = disabled.Allow(breakerSettings{}, now)
+	assert.True(t, ok)
+}
+
+func TestVerifyTokenInfo_CircuitBreakerFailsFast(t *testing.T) {
+	var calls atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second,
+		breaker: breakerSettings{failures: 2, open: time.Minute}}
+
+	for range 2 {
+		_, err := client.VerifyTokenInfo("token")
+		require.Error(t, err)
+	}
+	before := calls.Load()
+
+	_, err := client.VerifyTokenInfo("token")
+	assert.ErrorIs(t, err, ErrGitLabCircuitOpen)
+	assert.True(t, isFallbackToCacheError(err), "an open breaker falls back to the cache")
+	assert.Equal(t, before, calls.Load(), "GitLab is not called while open")
+}
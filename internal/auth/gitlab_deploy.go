@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// deployTokenPrefix starts every GitLab deploy token.
+const deployTokenPrefix = "gldt-"
+
+// DeployTokensConfig holds the deploy_tokens.* settings.
+type DeployTokensConfig struct {
+	Enabled bool
+	// AdminToken is an administrator's PAT used to look up deploy tokens,
+	// which cannot call the API themselves.
+	AdminToken string
+}
+
+// LoadDeployTokensConfig reads the deploy_tokens.* settings.
+func LoadDeployTokensConfig() DeployTokensConfig {
+	return DeployTokensConfig{
+		Enabled:    viper.GetBool("deploy_tokens.enabled"),
+		AdminToken: viper.GetString("deploy_tokens.admin_token"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg DeployTokensConfig) Validate() error {
+	if cfg.Enabled && cfg.AdminToken == "" {
+		return errors.New("deploy_tokens: admin_token is required")
+	}
+	return nil
+}
+
+// deployTokenInfo is the response of GitLab's token information API for a
+// deploy token.
+type deployTokenInfo struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes"`
+	Revoked  bool     `json:"revoked"`
+	Expired  bool     `json:"expired"`
+}
+
+// deployTokenInfo looks a deploy token up via POST /api/v4/admin/token
+// (GitLab 17.5+). Unknown tokens are answered with 404 and reported as
+// ErrInvalidToken. Other failures are reported like API errors, so server
+// errors fall back to the token cache.
+func (c *GitLabClient) deployTokenInfo(ctx context.Context, adminToken, token string) (*deployTokenInfo, error) {
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v4/admin/token", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", adminToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Transport: instrumentGitLab(http.DefaultTransport)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: unknown deploy token", ErrInvalidToken)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// The admin token is at fault, not the client's.
+		return nil, fmt.Errorf("deploy_tokens.admin_token rejected by GitLab: %s", resp.Status)
+	default:
+		return nil, &gitlab.ErrorResponse{Response: resp, Message: "token info: " + resp.Status}
+	}
+	var info deployTokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid token info: %w", err)
+	}
+	return &info, nil
+}
+
+// verifyDeployToken verifies a deploy token. Unlike user tokens it is tried
+// once: the lookup is a single cheap call and failures fall back to the
+// token cache.
+func (c *GitLabClient) verifyDeployToken(parent context.Context, token string, settings gitlabSettings) (*VerifiedToken, error) {
+	logger := slog.With("service", "gitlab", "token_type", TokenTypeDeploy)
+	if !settings.deployTokens.Enabled {
+		logger.Info("Deploy token rejected, deploy tokens are not enabled")
+		return nil, ErrInvalidToken
+	}
+
+	ctx, cancel := context.WithTimeout(parent, settings.timeout)
+	defer cancel()
+	info, err := c.deployTokenInfo(ctx, settings.deployTokens.AdminToken, token)
+	if err != nil {
+		return nil, c.lookupFailed(logger, err, settings)
+	}
+	if info.Revoked || info.Expired || info.Username == "" {
+		logger.Info("GitLab token validation failed", "deploy_token", info.Name, "revoked", info.Revoked, "expired", info.Expired)
+		return nil, ErrInvalidToken
+	}
+
+	logger.Info("GitLab token verification successful", "token_username", info.Username, "deploy_token", info.Name)
+	return &VerifiedToken{Username: info.Username, Scopes: info.Scopes, TokenType: TokenTypeDeploy}, nil
+}
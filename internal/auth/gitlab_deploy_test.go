@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyTokenInfo_DeployToken(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v4/admin/token", r.URL.Path)
+		require.Equal(t, http.MethodPost, r.Method)
+		if r.Header.Get("PRIVATE-TOKEN") != "admin-pat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		switch body.Token {
+		case "gldt-valid":
+			_, _ = w.Write([]byte(`{"id": 7, "name": "ci", "username": "gitlab+deploy-token-7", "scopes": ["read_registry"], "revoked": false, "expired": false}`))
+		case "gldt-revoked":
+			_, _ = w.Write([]byte(`{"id": 8, "name": "old", "username": "gitlab+deploy-token-8", "scopes": ["read_registry"], "revoked": true, "expired": false}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second,
+		deployTokens: DeployTokensConfig{Enabled: true, AdminToken: "admin-pat"}}
+
+	vt, err := client.VerifyTokenInfo("gldt-valid")
+	require.NoError(t, err)
+	assert.Equal(t, &VerifiedToken{Username: "gitlab+deploy-token-7", Scopes: []string{"read_registry"}, TokenType: TokenTypeDeploy}, vt)
+
+	_, err = client.VerifyTokenInfo("gldt-revoked")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = client.VerifyTokenInfo("gldt-unknown")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	client.deployTokens.AdminToken = "wrong"
+	_, err = client.VerifyTokenInfo("gldt-valid")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidToken, "a rejected admin token is not the client's fault")
+
+	client.deployTokens.Enabled = false
+	_, err = client.VerifyTokenInfo("gldt-valid")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestResolvePermissions_DeployToken(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auth.identity", IdentityUserID)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Identity}}.>"})
+	viper.Set("roles.profiles.admin.permissions.publish.allow", []string{">"})
+	viper.Set("roles.default", "admin")
+	viper.Set("deploy_tokens.permissions.publish.allow", []string{"deploy.{{.Identity}}.>"})
+	c := &NATSClient{logger: slog.Default(), permissions: loadPermissionsSnapshot()}
+
+	deploy := AuthorizeResult{Cached: &TokenCacheEntry{Username: "gitlab+deploy-token-7", TokenType: TokenTypeDeploy}}
+	set := c.resolvePermissions(deploy, "gitlab+deploy-token-7", nil)
+	assert.Equal(t, []string{"deploy.gitlab+deploy-token-7.>"}, set.Publish.Allow, "roles and nats.permissions do not apply")
+	assert.Empty(t, set.Subscribe.Allow, "an empty allow list grants nothing")
+	_, _, ok := c.userRole(deploy)
+	assert.False(t, ok)
+
+	set = c.resolvePermissions(AuthorizeResult{Verified: &VerifiedToken{UserID: 3, Username: "alice"}}, "alice", nil)
+	assert.Equal(t, []string{">"}, set.Publish.Allow)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// GitLab error classes used as metric labels.
+const (
+	gitlabErrUnauthorized = "unauthorized"
+	gitlabErrForbidden    = "forbidden"
+	gitlabErrRateLimited  = "rate_limited"
+	gitlabErrServer       = "server_error"
+	gitlabErrClient       = "client_error"
+	gitlabErrDNS          = "dns"
+	gitlabErrTLS          = "tls"
+	gitlabErrTimeout      = "timeout"
+	gitlabErrNetwork      = "network"
+	gitlabErrOther        = "other"
+)
+
+// classifyGitLabError maps a failed GitLab API call to a coarse error class,
+// so dashboards can tell GitLab throttling apart from local network trouble.
+func classifyGitLabError(err error) string {
+	if code, ok := statusCodeFromGitLabError(err); ok {
+		switch {
+		case code == http.StatusUnauthorized:
+			return gitlabErrUnauthorized
+		case code == http.StatusForbidden:
+			return gitlabErrForbidden
+		case code == http.StatusTooManyRequests:
+			return gitlabErrRateLimited
+		case code >= 500:
+			return gitlabErrServer
+		default:
+			return gitlabErrClient
+		}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return gitlabErrDNS
+	}
+
+	if isTLSError(err) {
+		return gitlabErrTLS
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return gitlabErrTimeout
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return gitlabErrTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return gitlabErrNetwork
+	}
+
+	return gitlabErrOther
+}
+
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		certInvalid      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+		recordHeaderErr  tls.RecordHeaderError
+		verificationErr  *tls.CertificateVerificationError
+		alertErr         tls.AlertError
+	)
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &certInvalid) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &verificationErr) ||
+		errors.As(err, &alertErr)
+}
+
+// recordGitLabError increments the GitLab error taxonomy counter.
+func recordGitLabError(err error) {
+	gitlabErrorsTotal.WithLabelValues(classifyGitLabError(err)).Inc()
+}
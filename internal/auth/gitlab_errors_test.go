@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func gitlabStatusError(code int) error {
+	return &gitlab.ErrorResponse{Response: &http.Response{StatusCode: code, Request: &http.Request{Method: "GET", URL: &url.URL{}}}}
+}
+
+func TestClassifyGitLabError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"401", gitlabStatusError(401), gitlabErrUnauthorized},
+		{"403", gitlabStatusError(403), gitlabErrForbidden},
+		{"429", gitlabStatusError(429), gitlabErrRateLimited},
+		{"502", gitlabStatusError(502), gitlabErrServer},
+		{"404", gitlabStatusError(404), gitlabErrClient},
+		{"dns", &url.Error{Op: "Get", URL: "https://gitlab", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "gitlab"}}}, gitlabErrDNS},
+		{"tls", &url.Error{Op: "Get", URL: "https://gitlab", Err: x509.UnknownAuthorityError{}}, gitlabErrTLS},
+		{"deadline", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), gitlabErrTimeout},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, gitlabErrNetwork},
+		{"other", fmt.Errorf("boom"), gitlabErrOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, classifyGitLabError(tt.err))
+		})
+	}
+}
+
+func TestVerifyTokenInfo_RecordsErrorClass(t *testing.T) {
+	originalSleep := timeSleep
+	timeSleep = func(d time.Duration) {}
+	defer func() { timeSleep = originalSleep }()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second, retries: 1}
+
+	before := testutil.ToFloat64(gitlabErrorsTotal.WithLabelValues(gitlabErrForbidden))
+	_, err := client.VerifyTokenInfo("token")
+	assert.Error(t, err)
+	after := testutil.ToFloat64(gitlabErrorsTotal.WithLabelValues(gitlabErrForbidden))
+
+	assert.Equal(t, float64(2), after-before)
+}
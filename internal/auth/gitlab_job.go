@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// jobTokenPrefix starts every GitLab CI/CD job token (CI_JOB_TOKEN).
+const jobTokenPrefix = "glcbt-"
+
+// JobTokensConfig holds the ci_job_tokens.* settings.
+type JobTokensConfig struct {
+	Enabled bool
+}
+
+// LoadJobTokensConfig reads the ci_job_tokens.* settings.
+func LoadJobTokensConfig() JobTokensConfig {
+	return JobTokensConfig{Enabled: viper.GetBool("ci_job_tokens.enabled")}
+}
+
+// jobProjectPath returns the full path of the project a job belongs to,
+// taken from the job's web URL (<gitlab>/<path>/-/jobs/<id>). The path of a
+// GitLab installed under a relative URL root (baseURL) is stripped.
+func jobProjectPath(baseURL, webURL string) (string, error) {
+	u, err := url.Parse(webURL)
+	if err != nil {
+		return "", err
+	}
+	path, _, ok := strings.Cut(u.Path, "/-/jobs/")
+	if !ok {
+		return "", fmt.Errorf("unexpected job URL %q", webURL)
+	}
+	if base, err := url.Parse(baseURL); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(base.Path, "/"))
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", fmt.Errorf("unexpected job URL %q", webURL)
+	}
+	return path, nil
+}
+
+// projectSubject turns a project path into subject tokens: group/sub.project
+// becomes group.sub_project, so each path segment is one token.
+func projectSubject(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(segment, ".", "_")
+	}
+	return strings.Join(segments, ".")
+}
+
+// verifyJobToken verifies a CI/CD job token via GET /api/v4/job, which only
+// answers while the job is running. The token owner is the user who runs
+// the job. Like deploy tokens, it is tried once.
+func (c *GitLabClient) verifyJobToken(parent context.Context, token string, settings gitlabSettings) (*VerifiedToken, error) {
+	logger := slog.With("service", "gitlab", "token_type", TokenTypeJob)
+	if !settings.jobTokens.Enabled {
+		logger.Info("CI job token rejected, CI job tokens are not enabled")
+		return nil, ErrInvalidToken
+	}
+
+	git, err := gitlab.NewJobClient(token,
+		gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", c.baseURL)),
+		gitlab.WithCustomRetry(gitlabCheckRetry),
+		gitlab.WithInterceptor(instrumentGitLab),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitLab client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, settings.timeout)
+	defer cancel()
+	job, _, err := git.Jobs.GetJobTokensJob(nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, c.lookupFailed(logger, err, settings)
+	}
+	if job == nil || job.User == nil || job.User.Username == "" {
+		logger.Info("GitLab returned a job without a user")
+		return nil, ErrInvalidToken
+	}
+	project, err := jobProjectPath(c.baseURL, job.WebURL)
+	if err != nil {
+		logger.Warn("Unable to determine the job's project", "error", err)
+		return nil, ErrInvalidToken
+	}
+
+	logger.Info("GitLab token verification successful", "token_username", job.User.Username, "project", project, "job_id", job.ID)
+	return &VerifiedToken{UserID: job.User.ID, Username: job.User.Username, TokenType: TokenTypeJob, Project: project}, nil
+}
+
+// lookupFailed classifies a failed single-attempt token lookup (deploy and
+// job tokens): 401 is an invalid token, 429 pauses verification and anything
+// else is returned as is, so server errors fall back to the token cache.
+func (c *GitLabClient) lookupFailed(logger *slog.Logger, err error, settings gitlabSettings) error {
+	if errors.Is(err, ErrInvalidToken) || isUnauthorizedError(err) {
+		if isUnauthorizedError(err) {
+			recordGitLabError(err)
+		}
+		logger.Info("GitLab token validation failed", "error", err)
+		return ErrInvalidToken
+	}
+	recordGitLabError(err)
+	if pause, limited := retryAfterFromError(err, settings.rateLimitPause, time.Now()); limited {
+		until := c.pauseFor(pause)
+		gitlabRateLimitPausesTotal.Inc()
+		logger.Warn("GitLab rate limit hit, pausing verification", "retry_after", pause, "until", until)
+		return fmt.Errorf("%w: %w", ErrGitLabRateLimited, err)
+	}
+	logger.Error("Error calling GitLab API", "error", err)
+	return fmt.Errorf("error calling GitLab API: %w", err)
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobProjectPath(t *testing.T) {
+	path, err := jobProjectPath("https://gitlab.example.com", "https://gitlab.example.com/platform/ci/my.app/-/jobs/42")
+	require.NoError(t, err)
+	assert.Equal(t, "platform/ci/my.app", path)
+	assert.Equal(t, "platform.ci.my_app", projectSubject(path))
+
+	path, err = jobProjectPath("https://example.com/gitlab/", "https://example.com/gitlab/ops/deploy/-/jobs/7")
+	require.NoError(t, err)
+	assert.Equal(t, "ops/deploy", path, "the relative URL root is stripped")
+
+	_, err = jobProjectPath("https://gitlab.example.com", "https://gitlab.example.com/ops/deploy")
+	assert.Error(t, err)
+}
+
+func TestVerifyTokenInfo_JobToken(t *testing.T) {
+	var serverURL string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v4/job", r.URL.Path)
+		if r.Header.Get("JOB-TOKEN") != "glcbt-running" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": 42, "web_url": "` + serverURL + `/platform/app/-/jobs/42", "user": {"id": 3, "username": "alice"}}`))
+	}))
+	defer testServer.Close()
+	serverURL = testServer.URL
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second, jobTokens: JobTokensConfig{Enabled: true}}
+
+	vt, err := client.VerifyTokenInfo("glcbt-running")
+	require.NoError(t, err)
+	assert.Equal(t, &VerifiedToken{UserID: 3, Username: "alice", TokenType: TokenTypeJob, Project: "platform/app"}, vt)
+
+	_, err = client.VerifyTokenInfo("glcbt-finished")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	client.jobTokens.Enabled = false
+	_, err = client.VerifyTokenInfo("glcbt-running")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestResolvePermissions_JobToken(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	viper.Set("ci_job_tokens.permissions.publish.allow", []string{"ci.{{.Project}}.>"})
+	viper.Set("ci_job_tokens.permissions.subscribe.allow", []string{"ci.{{.Project}}.{{.Username}}.>"})
+	c := &NATSClient{logger: slog.Default(), permissions: loadPermissionsSnapshot()}
+
+	job := AuthorizeResult{Cached: &TokenCacheEntry{Username: "alice", UserID: 3, TokenType: TokenTypeJob, Project: "platform/my.app"}}
+	set := c.resolvePermissions(job, "alice", nil)
+	assert.Equal(t, []string{"ci.platform.my_app.>"}, set.Publish.Allow, "nats.permissions does not apply")
+	assert.Equal(t, []string{"ci.platform.my_app.alice.>"}, set.Subscribe.Allow)
+	_, _, ok := c.userRole(job)
+	assert.False(t, ok)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// gitlabStatusClass returns the metric label of an HTTP status: "2xx" to
+// "5xx", or "error" when no response was received.
+func gitlabStatusClass(resp *http.Response, err error) string {
+	if err != nil || resp == nil || resp.StatusCode < 100 || resp.StatusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+// gitlabMetricsTransport records the duration and status class of every
+// HTTP request to GitLab, including the retries of the API client.
+type gitlabMetricsTransport struct {
+	next http.RoundTripper
+	now  func() time.Time
+}
+
+// instrumentGitLab is a gitlab.Interceptor adding request metrics.
+func instrumentGitLab(next http.RoundTripper) http.RoundTripper {
+	return gitlabMetricsTransport{next: next, now: time.Now}
+}
+
+func (t gitlabMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := t.now()
+	resp, err := t.next.RoundTrip(req)
+	class := gitlabStatusClass(resp, err)
+	gitlabRequestDuration.WithLabelValues(class).Observe(t.now().Sub(started).Seconds())
+	gitlabResponsesTotal.WithLabelValues(class).Inc()
+	return resp, err
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLabStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", gitlabStatusClass(&http.Response{StatusCode: http.StatusOK}, nil))
+	assert.Equal(t, "4xx", gitlabStatusClass(&http.Response{StatusCode: http.StatusTooManyRequests}, nil))
+	assert.Equal(t, "5xx", gitlabStatusClass(&http.Response{StatusCode: http.StatusBadGateway}, nil))
+	assert.Equal(t, "error", gitlabStatusClass(nil, errors.New("connection refused")))
+	assert.Equal(t, "error", gitlabStatusClass(&http.Response{StatusCode: 0}, nil))
+}
+
+func TestVerifyTokenInfo_RecordsRequestMetrics(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v4/user" {
+			_, _ = w.Write([]byte(`{"id": 1, "username": "tester"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound) // token scopes unavailable
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second}
+	ok2xx := testutil.ToFloat64(gitlabResponsesTotal.WithLabelValues("2xx"))
+	ok4xx := testutil.ToFloat64(gitlabResponsesTotal.WithLabelValues("4xx"))
+
+	_, err := client.VerifyTokenInfo("token")
+	require.NoError(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(gitlabResponsesTotal.WithLabelValues("2xx"))-ok2xx)
+	assert.Equal(t, float64(1), testutil.ToFloat64(gitlabResponsesTotal.WithLabelValues("4xx"))-ok4xx)
+	assert.Positive(t, testutil.CollectAndCount(gitlabRequestDuration), "durations are observed")
+}
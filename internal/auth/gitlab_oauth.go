@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Token types reported in VerifiedToken.TokenType.
+const (
+	TokenTypePersonal = "personal_access_token"
+	TokenTypeOAuth    = "oauth"
+	TokenTypeDeploy   = "deploy_token"
+	TokenTypeJob      = "ci_job_token"
+)
+
+// tokenType guesses the kind of a GitLab token from its format. Deploy
+// tokens start with gldt-, CI/CD job tokens with glcbt-, OAuth2 access tokens issued by GitLab are 64 hex
+// characters; everything else is treated as a personal (or project/group)
+// access token.
+func tokenType(token string) string {
+	if strings.HasPrefix(token, deployTokenPrefix) {
+		return TokenTypeDeploy
+	}
+	if strings.HasPrefix(token, jobTokenPrefix) {
+		return TokenTypeJob
+	}
+	if len(token) == 64 {
+		if _, err := hex.DecodeString(token); err == nil {
+			return TokenTypeOAuth
+		}
+	}
+	return TokenTypePersonal
+}
+
+// oauthTokenInfo is the response of GitLab's /oauth/token/info.
+type oauthTokenInfo struct {
+	ResourceOwnerID int64    `json:"resource_owner_id"`
+	Scope           []string `json:"scope"`
+	Application     struct {
+		UID string `json:"uid"`
+	} `json:"application"`
+}
+
+// oauthTokenInfo validates an OAuth2 access token and returns its resource
+// owner and scopes. Unknown, revoked and expired tokens are answered with
+// 401, which is reported like an API 401 so callers treat it as invalid.
+func (c *GitLabClient) oauthTokenInfo(ctx context.Context, token string) (*oauthTokenInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/oauth/token/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Transport: instrumentGitLab(http.DefaultTransport)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &gitlab.ErrorResponse{Response: resp, Message: "oauth token info: " + resp.Status}
+	}
+	var info oauthTokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid oauth token info: %w", err)
+	}
+	return &info, nil
+}
+
+// tokenScopes returns the scopes of a token whose owner was just verified.
+// OAuth tokens are validated against /oauth/token/info, which must name the
+// same resource owner. For personal access tokens the scopes are best
+// effort: not every token type can read its own details.
+func (c *GitLabClient) tokenScopes(ctx context.Context, git *gitlab.Client, token, kind string, userID int64) ([]string, error) {
+	if kind == TokenTypeOAuth {
+		info, err := c.oauthTokenInfo(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if info.ResourceOwnerID != userID {
+			return nil, fmt.Errorf("%w: oauth token belongs to user %d, not %d", ErrInvalidToken, info.ResourceOwnerID, userID)
+		}
+		slog.Debug("OAuth token verified", "service", "gitlab", "user_id", userID, "application", info.Application.UID)
+		return info.Scope, nil
+	}
+
+	pat, _, err := git.PersonalAccessTokens.GetSinglePersonalAccessToken(gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pat == nil {
+		return nil, nil
+	}
+	return pat.Scopes, nil
+}
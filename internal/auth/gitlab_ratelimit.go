@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// ErrGitLabRateLimited is returned while the verifier is paused after GitLab
+// answered 429 Too Many Requests. Authorization falls back to the token cache.
+var ErrGitLabRateLimited = errors.New("gitlab rate limited")
+
+// maxRateLimitPause caps the pause derived from a Retry-After header, so a
+// misbehaving proxy cannot disable GitLab verification for hours.
+const maxRateLimitPause = 10 * time.Minute
+
+// gitlabCheckRetry is the transport-level retry policy for GitLab API calls.
+// 429 responses are never retried in the transport: retrying immediately only
+// digs the hole deeper, so they are surfaced and handled by pausing instead.
+func gitlabCheckRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if err == nil && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return false, nil
+	}
+	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+}
+
+// retryAfterFromError reports whether err is a GitLab 429 response and, if so,
+// how long to pause. The Retry-After header is honored when present (seconds
+// or HTTP date); otherwise fallback is used.
+func retryAfterFromError(err error, fallback time.Duration, now time.Time) (time.Duration, bool) {
+	var errResp *gitlab.ErrorResponse
+	if !errors.As(err, &errResp) || errResp == nil || errResp.Response == nil {
+		return 0, false
+	}
+	if errResp.Response.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	pause := fallback
+	if d, ok := parseRetryAfter(errResp.Response.Header.Get("Retry-After"), now); ok {
+		pause = d
+	}
+	return min(pause, maxRateLimitPause), true
+}
+
+// parseRetryAfter parses a Retry-After header value in either delay-seconds
+// or HTTP-date form.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// pauseFor pauses GitLab verification for d, extending (never shortening) an
+// existing pause. It returns the effective end of the pause.
+func (c *GitLabClient) pauseFor(d time.Duration) time.Time {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+	return c.pausedUntil
+}
+
+// pausedUntilTime returns the end of the current pause, if one is active.
+func (c *GitLabClient) pausedUntilTime() (time.Time, bool) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	return c.pausedUntil, time.Now().Before(c.pausedUntil)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 12, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 120 * time.Second, true},
+		{"-1", 0, false},
+		{"Sun, 14 Dec 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Sun, 14 Dec 2025 11:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
+
+func TestVerifyTokenInfo_RateLimitedPausesVerifier(t *testing.T) {
+	var requests atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second, retries: 2, rateLimitPause: time.Second}
+
+	_, err := client.VerifyTokenInfo("token")
+	require.ErrorIs(t, err, ErrGitLabRateLimited)
+	assert.Equal(t, int32(1), requests.Load(), "429 must not be retried")
+
+	until, paused := client.pausedUntilTime()
+	require.True(t, paused)
+	assert.WithinDuration(t, time.Now().Add(120*time.Second), until, 5*time.Second)
+
+	// While paused, GitLab is not called at all.
+	_, err = client.VerifyTokenInfo("token")
+	require.ErrorIs(t, err, ErrGitLabRateLimited)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestPauseFor_NeverShortensPause(t *testing.T) {
+	client := &GitLabClient{}
+
+	long := client.pauseFor(time.Minute)
+	short := client.pauseFor(time.Second)
+	assert.Equal(t, long, short)
+
+	_, paused := client.pausedUntilTime()
+	assert.True(t, paused)
+}
+
+func TestAuthorizeToken_RateLimited_FallsBackToCache(t *testing.T) {
+	ctx := context.Background()
+	now := func() time.Time { return time.Date(2025, 12, 14, 12, 0, 0, 0, time.UTC) }
+
+	kv := &mockSharedKV{now: now, ttl: 24 * time.Hour, data: map[string]mockKVRecord{}}
+	cache := &mockTokenCache{secret: []byte("secret"), kv: kv}
+	require.NoError(t, cache.Put(ctx, "glpat-cached", TokenCacheEntry{Username: "tester"}))
+	cache.ResetCounts()
+
+	verifier := mockGitLabVerifier{verify: func(token string) (*VerifiedToken, error) {
+		return nil, ErrGitLabRateLimited
+	}}
+
+	res, err := AuthorizeToken(ctx, "glpat-cached", verifier, cache, now)
+	require.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.True(t, res.FromCache)
+	assert.Equal(t, 1, cache.GetCalls())
+}
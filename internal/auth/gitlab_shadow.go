@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Shadow verification results, as reported by gcs_antal_gitlab_shadow_results_total.
+const (
+	shadowMatch    = "match"
+	shadowMismatch = "mismatch"
+	shadowError    = "error"
+	shadowSkipped  = "skipped"
+)
+
+// GitLabShadowConfig holds the gitlab.shadow.* settings.
+type GitLabShadowConfig struct {
+	Enabled bool
+	// URL is the shadow GitLab instance, e.g. the upgraded or migrated one.
+	URL     string
+	Timeout time.Duration
+	// MaxConcurrent bounds the shadow verifications in flight; tokens
+	// verified while the limit is reached are not compared.
+	MaxConcurrent int
+}
+
+// LoadGitLabShadowConfig reads the gitlab.shadow.* settings.
+func LoadGitLabShadowConfig() GitLabShadowConfig {
+	return GitLabShadowConfig{
+		Enabled:       viper.GetBool("gitlab.shadow.enabled"),
+		URL:           strings.TrimSuffix(viper.GetString("gitlab.shadow.url"), "/"),
+		Timeout:       viper.GetDuration("gitlab.shadow.timeout"),
+		MaxConcurrent: viper.GetInt("gitlab.shadow.max_concurrent"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg GitLabShadowConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.URL == "":
+		return errors.New("gitlab.shadow: url is required")
+	case cfg.Timeout <= 0:
+		return errors.New("gitlab.shadow: timeout must be > 0")
+	case cfg.MaxConcurrent <= 0:
+		return errors.New("gitlab.shadow: max_concurrent must be > 0")
+	}
+	return nil
+}
+
+// ShadowVerifier verifies tokens with the primary GitLab and, in the
+// background, with a shadow instance, and reports where the two disagree.
+// Only the primary decides: the shadow result is logged and counted, never
+// enforced, so a GitLab upgrade or migration can be validated on real
+// traffic before the cutover.
+type ShadowVerifier struct {
+	primary *GitLabClient
+	shadow  *GitLabClient
+	timeout time.Duration
+	logger  *slog.Logger
+
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+}
+
+// NewShadowVerifier wraps the primary client. The shadow client uses the
+// gitlab.* settings of the primary, with its own circuit breaker.
+func NewShadowVerifier(primary *GitLabClient, cfg GitLabShadowConfig) *ShadowVerifier {
+	shadow := NewGitLabClient()
+	shadow.baseURL = cfg.URL
+	return &ShadowVerifier{
+		primary: primary,
+		shadow:  shadow,
+		timeout: cfg.Timeout,
+		logger:  slog.With("component", "gitlab_shadow"),
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// VerifyTokenInfo verifies the token with the primary GitLab.
+func (s *ShadowVerifier) VerifyTokenInfo(token string) (*VerifiedToken, error) {
+	return s.VerifyTokenInfoContext(context.Background(), token)
+}
+
+// VerifyTokenInfoContext verifies the token with the primary GitLab and,
+// when it reached a decision (valid or invalid), starts the shadow
+// verification. Errors of the primary (timeouts, rate limits) are not
+// compared.
+func (s *ShadowVerifier) VerifyTokenInfoContext(ctx context.Context, token string) (*VerifiedToken, error) {
+	vt, err := s.primary.VerifyTokenInfoContext(ctx, token)
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		s.compare(token, vt)
+	}
+	return vt, err
+}
+
+// compare verifies the token with the shadow instance in the background and
+// reports whether it agrees with the primary result (nil for an invalid
+// token).
+func (s *ShadowVerifier) compare(token string, primary *VerifiedToken) {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		gitlabShadowResultsTotal.WithLabelValues(shadowSkipped).Inc()
+		return
+	}
+	s.inFlight.Add(1)
+	go func() {
+		defer func() {
+			<-s.slots
+			s.inFlight.Done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		shadow, err := s.shadow.VerifyTokenInfoContext(ctx, token)
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			gitlabShadowResultsTotal.WithLabelValues(shadowError).Inc()
+			s.logger.Debug("Shadow GitLab verification failed", "error", err)
+			return
+		}
+		diffs := shadowDiff(primary, shadow)
+		if len(diffs) == 0 {
+			gitlabShadowResultsTotal.WithLabelValues(shadowMatch).Inc()
+			return
+		}
+		gitlabShadowResultsTotal.WithLabelValues(shadowMismatch).Inc()
+		s.logger.Warn("Shadow GitLab decision differs from primary",
+			"username", shadowUsername(primary, shadow),
+			"fields", diffs,
+			"primary", shadowSummary(primary),
+			"shadow", shadowSummary(shadow),
+		)
+	}()
+}
+
+// wait waits for the shadow verifications in flight.
+func (s *ShadowVerifier) wait() {
+	s.inFlight.Wait()
+}
+
+// shadowDiff lists the fields on which two verifications disagree; nil
+// stands for an invalid token.
+func shadowDiff(primary, shadow *VerifiedToken) []string {
+	switch {
+	case primary == nil && shadow == nil:
+		return nil
+	case primary == nil || shadow == nil:
+		return []string{"valid"}
+	}
+	var diffs []string
+	if primary.UserID != shadow.UserID {
+		diffs = append(diffs, "user_id")
+	}
+	if primary.Username != shadow.Username {
+		diffs = append(diffs, "username")
+	}
+	if primary.TokenType != shadow.TokenType {
+		diffs = append(diffs, "token_type")
+	}
+	if primary.Project != shadow.Project {
+		diffs = append(diffs, "project")
+	}
+	if !sameStrings(primary.Scopes, shadow.Scopes) {
+		diffs = append(diffs, "scopes")
+	}
+	if !sameStrings(primary.Groups, shadow.Groups) {
+		diffs = append(diffs, "groups")
+	}
+	return diffs
+}
+
+// sameStrings reports whether a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
+
+func shadowUsername(primary, shadow *VerifiedToken) string {
+	switch {
+	case primary != nil:
+		return primary.Username
+	case shadow != nil:
+		return shadow.Username
+	}
+	return ""
+}
+
+// shadowSummary describes a verification for the mismatch log; never the
+// token itself.
+func shadowSummary(vt *VerifiedToken) string {
+	if vt == nil {
+		return "invalid"
+	}
+	return fmt.Sprintf("valid user_id=%d username=%s type=%s scopes=%s groups=%s",
+		vt.UserID, vt.Username, vt.TokenType, strings.Join(vt.Scopes, ","), strings.Join(vt.Groups, ","))
+}
+
+// initGitLabShadow optionally compares every GitLab verification with a
+// shadow instance.
+func (c *NATSClient) initGitLabShadow() error {
+	cfg := LoadGitLabShadowConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.gitlabShadow = NewShadowVerifier(c.gitlabClient, cfg)
+	c.logger.Info("Shadow GitLab verification enabled", "url", cfg.URL, "max_concurrent", cfg.MaxConcurrent)
+	return nil
+}
+
+// verifier returns what verifies tokens: the GitLab client, wrapped by the
+// shadow verifier when enabled.
+func (c *NATSClient) verifier() GitLabVerifier {
+	if c.gitlabShadow != nil {
+		return c.gitlabShadow
+	}
+	return c.gitlabClient
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitLab answers /api/v4/user with the user of each known token and a
+// 401 for any other token.
+func fakeGitLab(t *testing.T, users map[string]string) *GitLabClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		user, ok := users[r.Header.Get("Private-Token")]
+		switch {
+		case r.URL.Path != "/api/v4/user":
+			w.WriteHeader(http.StatusNotFound)
+		case !ok:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"401 Unauthorized"}`))
+		default:
+			_, _ = w.Write([]byte(user))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &GitLabClient{baseURL: srv.URL, timeout: time.Second}
+}
+
+func TestGitLabShadowConfig_Validate(t *testing.T) {
+	assert.NoError(t, GitLabShadowConfig{}.Validate())
+	assert.ErrorContains(t, GitLabShadowConfig{Enabled: true, Timeout: time.Second, MaxConcurrent: 1}.Validate(), "url")
+	assert.ErrorContains(t, GitLabShadowConfig{Enabled: true, URL: "https://gitlab-new.example", MaxConcurrent: 1}.Validate(), "timeout")
+	assert.NoError(t, GitLabShadowConfig{Enabled: true, URL: "https://gitlab-new.example", Timeout: time.Second, MaxConcurrent: 1}.Validate())
+}
+
+func TestShadowVerifier(t *testing.T) {
+	primary := fakeGitLab(t, map[string]string{
+		"same":    `{"id": 1, "username": "alice"}`,
+		"renamed": `{"id": 2, "username": "bob"}`,
+	})
+	s := &ShadowVerifier{
+		primary: primary,
+		shadow: fakeGitLab(t, map[string]string{
+			"same":    `{"id": 1, "username": "alice"}`,
+			"renamed": `{"id": 2, "username": "bobby"}`,
+			"revoked": `{"id": 3, "username": "carol"}`,
+		}),
+		timeout: time.Second,
+		logger:  slog.Default(),
+		slots:   make(chan struct{}, 10),
+	}
+	count := func(result string) float64 {
+		return testutil.ToFloat64(gitlabShadowResultsTotal.WithLabelValues(result))
+	}
+	match, mismatch := count(shadowMatch), count(shadowMismatch)
+
+	vt, err := s.VerifyTokenInfo("same")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", vt.Username)
+	_, err = s.VerifyTokenInfo("unknown")
+	assert.ErrorIs(t, err, ErrInvalidToken, "invalid in both")
+	s.wait()
+	assert.Equal(t, float64(2), count(shadowMatch)-match)
+
+	vt, err = s.VerifyTokenInfo("renamed")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", vt.Username, "the primary decides")
+	_, err = s.VerifyTokenInfo("revoked")
+	assert.ErrorIs(t, err, ErrInvalidToken, "the shadow never allows")
+	s.wait()
+	assert.Equal(t, float64(2), count(shadowMismatch)-mismatch)
+}
+
+func TestShadowVerifier_Skipped(t *testing.T) {
+	s := &ShadowVerifier{
+		primary: fakeGitLab(t, map[string]string{"same": `{"id": 1, "username": "alice"}`}),
+		logger:  slog.Default(),
+		slots:   make(chan struct{}, 1),
+	}
+	s.slots <- struct{}{}
+	skipped := testutil.ToFloat64(gitlabShadowResultsTotal.WithLabelValues(shadowSkipped))
+
+	_, err := s.VerifyTokenInfo("same")
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(gitlabShadowResultsTotal.WithLabelValues(shadowSkipped))-skipped)
+}
+
+func TestShadowDiff(t *testing.T) {
+	alice := &VerifiedToken{UserID: 1, Username: "alice", Scopes: []string{"read_api", "read_user"}, Groups: []string{"ops"}}
+
+	assert.Empty(t, shadowDiff(nil, nil))
+	assert.Equal(t, []string{"valid"}, shadowDiff(alice, nil))
+	assert.Empty(t, shadowDiff(alice, &VerifiedToken{UserID: 1, Username: "alice", Scopes: []string{"read_user", "read_api"}, Groups: []string{"ops"}}),
+		"order does not matter")
+	assert.Equal(t, []string{"user_id", "scopes", "groups"},
+		shadowDiff(alice, &VerifiedToken{UserID: 7, Username: "alice", Scopes: []string{"read_api"}}))
+	assert.NotContains(t, shadowSummary(alice), "token")
+	assert.Equal(t, "invalid", shadowSummary(nil))
+}
+
+func TestShadowVerifier_PrimaryErrorsAreNotCompared(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+	s := &ShadowVerifier{
+		primary: &GitLabClient{baseURL: srv.URL, timeout: time.Second},
+		logger:  slog.Default(),
+		slots:   make(chan struct{}, 1),
+	}
+
+	_, err := s.VerifyTokenInfo("token")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidToken))
+	assert.Empty(t, s.slots, "no shadow verification was started")
+}
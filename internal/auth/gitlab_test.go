@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -202,3 +204,62 @@ func TestVerifyToken(t *testing.T) {
 		}
 	})
 }
+
+func TestVerifyTokenInfo_FetchesGroups(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 1, "username": "tester"}`))
+		case "/api/v4/personal_access_tokens/self":
+			_, _ = w.Write([]byte(`{"id": 7, "scopes": ["read_api"]}`))
+		case "/api/v4/groups":
+			assert.Equal(t, "true", r.URL.Query().Get("top_level_only"))
+			assert.Equal(t, "10", r.URL.Query().Get("min_access_level"))
+			_, _ = w.Write([]byte(`[{"id": 1, "full_path": "payments"}, {"id": 2, "full_path": "billing"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	client := &GitLabClient{baseURL: testServer.URL, timeout: time.Second, fetchGroups: true}
+
+	vt, err := client.VerifyTokenInfo("valid_token")
+	assert.NoError(t, err)
+	assert.Equal(t, "tester", vt.Username)
+	assert.Equal(t, []string{"read_api"}, vt.Scopes)
+	assert.Equal(t, []string{"payments", "billing"}, vt.Groups)
+
+	// Groups are not looked up unless needed.
+	client.fetchGroups = false
+	vt, err = client.VerifyTokenInfo("valid_token")
+	assert.NoError(t, err)
+	assert.Nil(t, vt.Groups)
+}
+
+func TestVerifyTokenInfoContext_StopsAtDeadline(t *testing.T) {
+	viper.Reset()
+	var requests atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer testServer.Close()
+
+	client := newMockGitLabClient(testServer).client
+	client.retryDelaySeconds = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err := client.VerifyTokenInfoContext(ctx, "slow_token")
+
+	assert.Less(t, time.Since(started), time.Second, "the request deadline is shorter than gitlab.timeout")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, isFallbackToCacheError(err))
+	assert.Equal(t, int32(1), requests.Load(), "no retry without time left for it")
+}
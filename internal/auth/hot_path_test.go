@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/callout"
+)
+
+// benchmarkClient returns a client configured like a typical deployment:
+// templated global permissions plus tenants, logging discarded.
+func benchmarkClient(b *testing.B) *NATSClient {
+	b.Helper()
+	viper.Reset()
+	b.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>", "events.>"})
+	viper.Set("nats.permissions.subscribe.allow", []string{"_INBOX.>", "user.{{.Username}}.>", "broadcast.>"})
+	viper.Set("tenants.defaults.permissions.subscribe.allow", []string{"tenants.announcements"})
+	viper.Set("tenants.groups.payments.permissions.publish.allow", []string{"payments.{{.Username}}.>"})
+
+	issuer, err := nkeys.CreateAccount()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &NATSClient{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		issuerSigner: NewKeyPairSigner(issuer),
+		permissions:  loadPermissionsSnapshot(),
+	}
+}
+
+func BenchmarkRenderPermissionTemplate(b *testing.B) {
+	b.Run("static", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _ = renderPermissionTemplate("broadcast.>", permissionTemplateData{Username: "alice"})
+		}
+	})
+	b.Run("templated", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _ = renderPermissionTemplate("user.{{.Username}}.>", permissionTemplateData{Username: "alice"})
+		}
+	})
+}
+
+func BenchmarkResolvePermissions(b *testing.B) {
+	c := benchmarkClient(b)
+	result := AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "alice", Groups: []string{"payments"}}}
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = c.resolvePermissions(result, "alice", nil)
+	}
+}
+
+// BenchmarkIssueUserJWT covers the per-request work after authorization:
+// permissions, claims, signing and the response JWT.
+func BenchmarkIssueUserJWT(b *testing.B) {
+	c := benchmarkClient(b)
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		b.Fatal(err)
+	}
+	userNkey, _ := user.PublicKey()
+	result := AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "alice", Groups: []string{"payments"}}}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		var policy callout.Policy
+		c.resolvePermissions(result, "alice", nil).Apply(&policy.Permissions)
+		uc := callout.BuildUserClaims(callout.Identity{UserNkey: userNkey, Name: "alice"}, policy)
+		userJwt, err := encodeClaims(uc, c.issuer())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := c.buildResponse(userNkey, "NSERVER", userJwt, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestHotPathAllocations guards the request path against regressions the
+// benchmarks above would only show when someone runs them.
+func TestHotPathAllocations(t *testing.T) {
+	t.Run("static subjects are not parsed as templates", func(t *testing.T) {
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = renderPermissionTemplate("broadcast.>", permissionTemplateData{Username: "alice"})
+		})
+		assert.Zero(t, allocs)
+	})
+
+	t.Run("templates are parsed once", func(t *testing.T) {
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = renderPermissionTemplate("user.{{.Username}}.>", permissionTemplateData{Username: "alice"})
+		})
+		assert.LessOrEqual(t, allocs, 10.0)
+	})
+
+	t.Run("permissions are not read from viper per request", func(t *testing.T) {
+		viper.Reset()
+		t.Cleanup(viper.Reset)
+		viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+		c := &NATSClient{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), permissions: loadPermissionsSnapshot()}
+
+		// A later change is not picked up: the snapshot is what requests use.
+		viper.Set("nats.permissions.publish.allow", []string{"other.>"})
+		set := c.resolvePermissions(AuthorizeResult{}, "alice", nil)
+		assert.Equal(t, []string{"user.alice.>"}, set.Publish.Allow)
+	})
+}
+
+func TestRenderPermissionTemplate_CachesParseErrors(t *testing.T) {
+	for range 2 {
+		_, err := renderPermissionTemplate("user.{{.Username", permissionTemplateData{Username: "alice"})
+		assert.Error(t, err)
+	}
+	out, err := renderPermissionTemplate("user.{{.Username}}.inbox", permissionTemplateData{Username: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "user.bob.inbox", out)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+)
+
+// Identity modes decide what {{.Identity}} renders in permission templates.
+const (
+	// IdentityUsername renders the username: renaming a GitLab user moves
+	// them to a different subject space.
+	IdentityUsername = "username"
+	// IdentityUserID renders the numeric GitLab user ID, which survives
+	// renames.
+	IdentityUserID = "user_id"
+)
+
+// Empty username policies decide what happens to requests whose connect
+// options carry only the token.
+const (
+	// EmptyUsernameDerive uses the username of the verified token.
+	EmptyUsernameDerive = "derive"
+	// EmptyUsernameDeny denies the request before the token is verified.
+	EmptyUsernameDeny = "deny"
+)
+
+// emptyUsernamePolicy returns auth.empty_username, defaulting to derive.
+func emptyUsernamePolicy() string {
+	if strings.ToLower(strings.TrimSpace(viper.GetString("auth.empty_username"))) == EmptyUsernameDeny {
+		return EmptyUsernameDeny
+	}
+	return EmptyUsernameDerive
+}
+
+// bearerPrefix is stripped from tokens sent as "Bearer <PAT>".
+const bearerPrefix = "bearer "
+
+// connectCredentials returns the username and token of a request. The token
+// is the password or, for clients that send only the PAT as a bearer token
+// (auth_token, e.g. nats.Token), the token field; a "Bearer " prefix is
+// dropped either way.
+func connectCredentials(opts jwt.ConnectOptions) (username, token string) {
+	token = opts.Password
+	if token == "" {
+		token = opts.Token
+	}
+	if len(token) >= len(bearerPrefix) && strings.EqualFold(token[:len(bearerPrefix)], bearerPrefix) {
+		token = strings.TrimSpace(token[len(bearerPrefix):])
+	}
+	return opts.Username, token
+}
+
+// effectiveUsername returns the username to issue the JWT for: the one from
+// the connect options, or the token owner's when the client sent none. It
+// reports false when neither is known, which happens when monitor-only mode
+// let an unverified token through.
+func effectiveUsername(username string, result AuthorizeResult) (string, bool) {
+	if username != "" {
+		return username, true
+	}
+	owner := result.Username()
+	return owner, owner != ""
+}
+
+// requireTokenOwner returns auth.require_token_owner.
+func requireTokenOwner() bool {
+	return viper.GetBool("auth.require_token_owner")
+}
+
+// tokenOwnerUsername checks the connect options username against the token
+// owner's. It returns the owner's spelling when they match, ignoring case as
+// GitLab does, and false when they differ or the owner is unknown (monitor-only
+// mode let an unverified token through, or a cache entry lacks the username).
+func tokenOwnerUsername(username string, result AuthorizeResult) (string, bool) {
+	owner := result.Username()
+	if owner == "" || !strings.EqualFold(owner, username) {
+		return username, false
+	}
+	return owner, true
+}
+
+// connectUsername returns the username a connect with the connect options
+// username and the token is issued for: the token owner's when the client
+// sent none, and GitLab's spelling when they match, so permissions and audit
+// never depend on how the client typed it. A non-empty deny code says why the
+// connect is refused: DenyUsernameRequired when no username is known, and with
+// auth.require_token_owner on, DenyUsernameMismatch when the username is not
+// the owner's or DenyAuthError when the owner is unknown, so an unverifiable
+// username is never trusted. Both the callout and the token check use it, so
+// a check reports what a connect would get.
+func connectUsername(username string, result AuthorizeResult) (string, DenyCode, string) {
+	username, ok := effectiveUsername(username, result)
+	if !ok {
+		return "", DenyUsernameRequired, "connect with a username"
+	}
+	owner, ok := tokenOwnerUsername(username, result)
+	switch {
+	case ok || !requireTokenOwner():
+	case result.Username() == "":
+		return username, DenyAuthError, "token owner unknown, retry when GitLab is reachable"
+	default:
+		return username, DenyUsernameMismatch, "the username is not the token owner's"
+	}
+	return owner, "", ""
+}
+
+// identityMode returns auth.identity, defaulting to username.
+func identityMode() string {
+	if strings.ToLower(strings.TrimSpace(viper.GetString("auth.identity"))) == IdentityUserID {
+		return IdentityUserID
+	}
+	return IdentityUsername
+}
+
+// templateIdentity returns the value of {{.Identity}} for a request. It
+// reports false when the mode needs the user ID and it is unknown, which
+// happens for cache entries written before the ID was recorded. Falling back
+// to the username there would be unsafe: a numeric username could collide
+// with another user's ID. Deploy tokens have no user ID and always render
+// the username.
+func templateIdentity(mode, username string, result AuthorizeResult) (string, bool) {
+	if mode != IdentityUserID || result.TokenType() == TokenTypeDeploy {
+		return username, true
+	}
+	id := result.UserID()
+	if id <= 0 {
+		return "", false
+	}
+	return strconv.FormatInt(id, 10), true
+}
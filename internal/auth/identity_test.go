@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityMode(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	assert.Equal(t, IdentityUsername, identityMode(), "default")
+	viper.Set("auth.identity", " User_ID ")
+	assert.Equal(t, IdentityUserID, identityMode())
+	viper.Set("auth.identity", "email")
+	assert.Equal(t, IdentityUsername, identityMode(), "unknown modes keep the username")
+}
+
+func TestTemplateIdentity(t *testing.T) {
+	verified := AuthorizeResult{Verified: &VerifiedToken{UserID: 42, Username: "alice"}}
+	legacy := AuthorizeResult{Cached: &TokenCacheEntry{Username: "alice"}}
+
+	id, ok := templateIdentity(IdentityUsername, "alice", legacy)
+	require.True(t, ok)
+	assert.Equal(t, "alice", id)
+
+	id, ok = templateIdentity(IdentityUserID, "alice", verified)
+	require.True(t, ok)
+	assert.Equal(t, "42", id)
+
+	id, ok = templateIdentity(IdentityUserID, "alice", AuthorizeResult{Cached: &TokenCacheEntry{UserID: 42, Username: "alice"}})
+	require.True(t, ok)
+	assert.Equal(t, "42", id)
+
+	_, ok = templateIdentity(IdentityUserID, "alice", legacy)
+	assert.False(t, ok, "entries without a user ID must not fall back to the username")
+}
+
+func TestResolvePermissions_IdentitySurvivesRename(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Identity}}.>", "legacy.{{.Username}}"})
+	viper.Set("auth.identity", IdentityUserID)
+	c := &NATSClient{logger: slog.Default()}
+
+	before := c.resolvePermissions(AuthorizeResult{Verified: &VerifiedToken{UserID: 42, Username: "alice"}}, "alice", nil)
+	after := c.resolvePermissions(AuthorizeResult{Verified: &VerifiedToken{UserID: 42, Username: "alice.smith"}}, "alice.smith", nil)
+	assert.Equal(t, []string{"user.42.>", "legacy.alice"}, before.Publish.Allow)
+	assert.Equal(t, []string{"user.42.>", "legacy.alice.smith"}, after.Publish.Allow)
+}
+
+func TestEmptyUsernamePolicy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	assert.Equal(t, EmptyUsernameDerive, emptyUsernamePolicy(), "default")
+	viper.Set("auth.empty_username", " Deny ")
+	assert.Equal(t, EmptyUsernameDeny, emptyUsernamePolicy())
+	viper.Set("auth.empty_username", "allow")
+	assert.Equal(t, EmptyUsernameDerive, emptyUsernamePolicy(), "unknown policies derive")
+}
+
+func TestConnectCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		opts     jwt.ConnectOptions
+		username string
+		token    string
+	}{
+		{jwt.ConnectOptions{Username: "alice", Password: "glpat-x"}, "alice", "glpat-x"},
+		{jwt.ConnectOptions{Token: "glpat-x"}, "", "glpat-x"},
+		{jwt.ConnectOptions{Token: "Bearer glpat-x"}, "", "glpat-x"},
+		{jwt.ConnectOptions{Password: "bearer glpat-x"}, "", "glpat-x"},
+		{jwt.ConnectOptions{Username: "alice", Password: "glpat-x", Token: "glpat-y"}, "alice", "glpat-x"},
+		{jwt.ConnectOptions{Token: "Bearer "}, "", ""},
+	} {
+		username, token := connectCredentials(tc.opts)
+		assert.Equal(t, tc.username, username, "%+v", tc.opts)
+		assert.Equal(t, tc.token, token, "%+v", tc.opts)
+	}
+}
+
+func TestTokenOwnerUsername(t *testing.T) {
+	verified := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+
+	username, ok := tokenOwnerUsername("alice", verified)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", username)
+
+	username, ok = tokenOwnerUsername("Alice", verified)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", username, "the owner's spelling is used")
+
+	_, ok = tokenOwnerUsername("bob", verified)
+	assert.False(t, ok)
+	_, ok = tokenOwnerUsername("bob", AuthorizeResult{Cached: &TokenCacheEntry{Username: "alice"}})
+	assert.False(t, ok, "cached owners are checked too")
+
+	username, ok = tokenOwnerUsername("bob", AuthorizeResult{})
+	assert.False(t, ok, "unknown owners never match")
+	assert.Equal(t, "bob", username)
+}
+
+func TestConnectUsername(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auth.require_token_owner", true)
+	verified := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+
+	username, code, _ := connectUsername("", verified)
+	assert.Empty(t, code)
+	assert.Equal(t, "alice", username, "the owner's username without one")
+
+	username, code, _ = connectUsername("ALICE", verified)
+	assert.Empty(t, code)
+	assert.Equal(t, "alice", username, "the owner's spelling")
+
+	username, code, _ = connectUsername("bob", verified)
+	assert.Equal(t, DenyUsernameMismatch, code)
+	assert.Equal(t, "bob", username)
+
+	_, code, _ = connectUsername("", AuthorizeResult{})
+	assert.Equal(t, DenyUsernameRequired, code)
+	_, code, _ = connectUsername("bob", AuthorizeResult{Cached: &TokenCacheEntry{UserID: 7}})
+	assert.Equal(t, DenyAuthError, code, "an unknown owner is not trusted")
+
+	viper.Set("auth.require_token_owner", false)
+	username, code, _ = connectUsername("bob", verified)
+	assert.Empty(t, code)
+	assert.Equal(t, "bob", username)
+}
+
+func TestEffectiveUsername(t *testing.T) {
+	verified := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+
+	username, ok := effectiveUsername("bob", verified)
+	require.True(t, ok)
+	assert.Equal(t, "bob", username, "the connect options username is kept")
+
+	username, ok = effectiveUsername("", verified)
+	require.True(t, ok)
+	assert.Equal(t, "alice", username)
+
+	username, ok = effectiveUsername("", AuthorizeResult{Cached: &TokenCacheEntry{Username: "alice"}})
+	require.True(t, ok)
+	assert.Equal(t, "alice", username)
+
+	_, ok = effectiveUsername("", AuthorizeResult{})
+	assert.False(t, ok, "unverified tokens have no owner to derive from")
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+)
+
+// ServiceInfo is what the NATS servers and the surrounding infrastructure
+// need to know about this service: the values of the servers' auth_callout
+// block, the accounts users are issued into and the subjects Antal uses. It
+// is read from the configuration alone, without connecting anywhere, and
+// its JSON field names are stable for provisioning tools.
+type ServiceInfo struct {
+	AuthCallout AuthCalloutInfo `json:"auth_callout"`
+	Accounts    []AccountInfo   `json:"accounts"`
+	Subjects    SubjectsInfo    `json:"subjects"`
+}
+
+// AuthCalloutInfo holds the values of the servers' auth_callout block.
+type AuthCalloutInfo struct {
+	// Issuer is the public key signing user JWTs (auth_callout.issuer).
+	Issuer string `json:"issuer"`
+	// StandbyIssuer is the key issuer rotation switches to; trust it on the
+	// servers ahead of a rotation.
+	StandbyIssuer string `json:"standby_issuer,omitempty"`
+	// XKey is the public curve key of encrypted callouts (auth_callout.xkey).
+	XKey string `json:"xkey,omitempty"`
+	// Account is the default account of issued users (auth_callout.account).
+	Account string `json:"account"`
+	// AuthUsers are the users bypassing the callout (auth_callout.auth_users):
+	// the auth connection and, when configured, the platform connection.
+	AuthUsers []string `json:"auth_users"`
+}
+
+// AccountInfo is an account users are issued into by GitLab group.
+type AccountInfo struct {
+	Group   string `json:"group"`
+	Account string `json:"account"`
+	// SigningKey is the public key of the account's signing key; register it
+	// on the account in operator mode.
+	SigningKey string `json:"signing_key,omitempty"`
+}
+
+// SubjectsInfo lists the subjects Antal listens or publishes on.
+type SubjectsInfo struct {
+	AuthCallout string `json:"auth_callout"`
+	// Internal is the coordination namespace between replicas.
+	Internal string `json:"internal"`
+	// CanI is the permission probe subject, when enabled.
+	CanI string `json:"can_i,omitempty"`
+	// Audit is the audit export subject, when enabled.
+	Audit string `json:"audit,omitempty"`
+	// ReservedPrefixes are never granted to users.
+	ReservedPrefixes []string `json:"reserved_prefixes"`
+}
+
+// LoadServiceInfo builds the service info from the configuration.
+func LoadServiceInfo() (ServiceInfo, error) {
+	info := ServiceInfo{
+		AuthCallout: AuthCalloutInfo{
+			Account:   viper.GetString("nats.audience"),
+			AuthUsers: []string{},
+		},
+		Accounts: []AccountInfo{},
+		Subjects: SubjectsInfo{
+			AuthCallout:      authCalloutSubject,
+			Internal:         internalSubjectPrefix + ".>",
+			ReservedPrefixes: LoadReservedPrefixes(),
+		},
+	}
+
+	var err error
+	if info.AuthCallout.Issuer, err = issuerPublicKey(); err != nil {
+		return ServiceInfo{}, err
+	}
+	if cfg := LoadIssuerRotationConfig(); cfg.StandbySeed != "" {
+		if _, info.AuthCallout.StandbyIssuer, err = parseStandbyIssuer(cfg); err != nil {
+			return ServiceInfo{}, err
+		}
+	}
+	xKeyPair, err := parseXKeySeed(viper.GetString("nats.xkey_seed"))
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("invalid xKey seed: %w", err)
+	}
+	if xKeyPair != nil {
+		info.AuthCallout.XKey, _ = xKeyPair.PublicKey()
+	}
+
+	user, err := LoadNATSCredentials().AuthUser()
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("nats: %w", err)
+	}
+	if user != "" {
+		info.AuthCallout.AuthUsers = append(info.AuthCallout.AuthUsers, user)
+	}
+	if cfg := LoadPlatformConfig(); cfg.Enabled && cfg.User != "" && !slices.Contains(info.AuthCallout.AuthUsers, cfg.User) {
+		info.AuthCallout.AuthUsers = append(info.AuthCallout.AuthUsers, cfg.User)
+	}
+
+	mappings, err := LoadAccountMappings()
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	if _, err := newAccountSelector(mappings); err != nil {
+		return ServiceInfo{}, err
+	}
+	for _, m := range mappings {
+		account := AccountInfo{Group: m.Group, Account: m.Account}
+		if m.SigningKeySeed != "" {
+			signer, _ := accountSigner(m)
+			account.SigningKey, _ = signer.PublicKey()
+		}
+		info.Accounts = append(info.Accounts, account)
+	}
+
+	if cfg := LoadCanIConfig(); cfg.Enabled {
+		info.Subjects.CanI = cfg.Subject
+	}
+	if cfg := LoadNATSSinkConfig(); cfg.Enabled {
+		info.Subjects.Audit = cfg.Subject
+	}
+	return info, nil
+}
+
+// issuerPublicKey returns the issuer's public key without contacting a
+// remote signer: it is configured as signer.public_key there.
+func issuerPublicKey() (string, error) {
+	if viper.GetString("signer.type") == SignerRemote {
+		pub := LoadRemoteSignerConfig().PublicKey
+		if pub == "" {
+			return "", fmt.Errorf("signer.public_key is required for the remote signer")
+		}
+		return pub, nil
+	}
+	kp, err := nkeys.FromSeed([]byte(viper.GetString("nats.issuer_seed")))
+	if err != nil {
+		return "", fmt.Errorf("invalid issuer seed: %w", err)
+	}
+	return kp.PublicKey()
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadServiceInfo(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	_, issuerSeed, issuerPub := newAccountKey(t)
+	_, standbySeed, standbyPub := newAccountKey(t)
+	_, signingSeed, signingPub := newAccountKey(t)
+	_, _, paymentsPub := newAccountKey(t)
+	viper.Set("nats.issuer_seed", issuerSeed)
+	viper.Set("nats.audience", "APP")
+	viper.Set("nats.user", "auth")
+	viper.Set("platform.enabled", true)
+	viper.Set("platform.user", "platform")
+	viper.Set("issuer_rotation.standby_seed", standbySeed)
+	viper.Set("accounts.groups", []map[string]any{
+		{"group": "payments", "account": paymentsPub, "signing_key_seed": signingSeed},
+		{"group": "ops", "account": "OPS"},
+	})
+	viper.Set("can_i.enabled", true)
+	viper.Set("can_i.subject", "antal.can_i")
+
+	info, err := LoadServiceInfo()
+	require.NoError(t, err)
+	assert.Equal(t, AuthCalloutInfo{
+		Issuer:        issuerPub,
+		StandbyIssuer: standbyPub,
+		Account:       "APP",
+		AuthUsers:     []string{"auth", "platform"},
+	}, info.AuthCallout)
+	assert.Equal(t, []AccountInfo{
+		{Group: "payments", Account: paymentsPub, SigningKey: signingPub},
+		{Group: "ops", Account: "OPS"},
+	}, info.Accounts)
+	assert.Equal(t, "$SYS.REQ.USER.AUTH", info.Subjects.AuthCallout)
+	assert.Equal(t, "antal.internal.>", info.Subjects.Internal)
+	assert.Equal(t, "antal.can_i", info.Subjects.CanI)
+	assert.Empty(t, info.Subjects.Audit)
+
+	viper.Set("nats.issuer_seed", "")
+	viper.Set("signer.type", SignerRemote)
+	viper.Set("signer.public_key", "AREMOTE")
+	info, err = LoadServiceInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "AREMOTE", info.AuthCallout.Issuer, "the remote signer is not contacted")
+
+	viper.Set("signer.type", SignerLocal)
+	_, err = LoadServiceInfo()
+	assert.ErrorContains(t, err, "invalid issuer seed")
+}
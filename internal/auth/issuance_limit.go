@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// issuanceWindow is the sliding window of the per-user issuance limit.
+const issuanceWindow = time.Minute
+
+// maxJWTsPerMinute returns the per-user issuance limit (0 disables it). It is
+// read on every request so it can be changed at runtime.
+func maxJWTsPerMinute() int {
+	return viper.GetInt("auth.max_jwts_per_minute")
+}
+
+// issuanceLimiter counts the JWTs issued per user in a sliding window. It
+// protects against clients stuck in tight reconnect loops, which would
+// otherwise hammer GitLab and the cache and hide the real problem.
+type issuanceLimiter struct {
+	mu        sync.Mutex
+	issued    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newIssuanceLimiter() *issuanceLimiter {
+	return &issuanceLimiter{issued: make(map[string][]time.Time)}
+}
+
+// Allow records an issuance for username and reports whether it stays within
+// limit, together with the number of JWTs issued to the user in the window
+// (including this one). Denied attempts are not recorded, so a throttled
+// client gets through again once its earlier JWTs leave the window.
+func (l *issuanceLimiter) Allow(username string, limit int, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	recent := pruneBefore(l.issued[username], now.Add(-issuanceWindow))
+	if limit > 0 && len(recent) >= limit {
+		l.issued[username] = recent
+		return false, len(recent)
+	}
+	recent = append(recent, now)
+	l.issued[username] = recent
+	return true, len(recent)
+}
+
+// sweep forgets users without issuances in the window, at most once per
+// window, so the map does not grow with every user ever seen.
+func (l *issuanceLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < issuanceWindow {
+		return
+	}
+	l.lastSweep = now
+	cutoff := now.Add(-issuanceWindow)
+	for username, times := range l.issued {
+		if len(pruneBefore(times, cutoff)) == 0 {
+			delete(l.issued, username)
+		}
+	}
+}
+
+// pruneBefore drops the (ascending) timestamps not after cutoff.
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// checkIssuanceRate applies the per-user issuance limit and reports whether
+// the user exceeded it.
+func (c *NATSClient) checkIssuanceRate(username string) bool {
+	if username == "" {
+		return false
+	}
+	limit := maxJWTsPerMinute()
+	allowed, count := c.issuance.Allow(username, limit, time.Now())
+	if allowed {
+		userIssuanceRate.Observe(float64(count))
+		return false
+	}
+
+	rateLimitedTotal.Inc()
+	c.logger.Warn("JWT issuance rate exceeded; the client is probably stuck in a reconnect loop",
+		"username", username,
+		"issued_last_minute", count,
+		"limit", limit,
+	)
+	return true
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuanceLimiter_SlidingWindow(t *testing.T) {
+	l := newIssuanceLimiter()
+	start := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		allowed, count := l.Allow("alice", 3, start.Add(time.Duration(i)*time.Second))
+		assert.True(t, allowed)
+		assert.Equal(t, i, count)
+	}
+	allowed, count := l.Allow("alice", 3, start.Add(10*time.Second))
+	assert.False(t, allowed)
+	assert.Equal(t, 3, count)
+
+	// Other users have their own budget.
+	allowed, _ = l.Allow("bob", 3, start.Add(10*time.Second))
+	assert.True(t, allowed)
+
+	// The first issuance leaves the window after a minute.
+	allowed, count = l.Allow("alice", 3, start.Add(time.Minute+time.Second+time.Millisecond))
+	assert.True(t, allowed)
+	assert.Equal(t, 3, count)
+
+	// Without a limit issuances are only counted.
+	allowed, count = l.Allow("alice", 0, start.Add(time.Minute+time.Second+2*time.Millisecond))
+	assert.True(t, allowed)
+	assert.Equal(t, 4, count)
+}
+
+func TestIssuanceLimiter_ForgetsIdleUsers(t *testing.T) {
+	l := newIssuanceLimiter()
+	start := time.Now()
+	l.Allow("alice", 1, start)
+	l.Allow("bob", 1, start.Add(2*time.Minute))
+	assert.NotContains(t, l.issued, "alice")
+	assert.Contains(t, l.issued, "bob")
+}
+
+func TestCheckIssuanceRate(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	c := &NATSClient{logger: slog.Default(), issuance: newIssuanceLimiter()}
+	for range 5 {
+		assert.False(t, c.checkIssuanceRate("alice"), "disabled by default")
+	}
+
+	viper.Set("auth.max_jwts_per_minute", 5)
+	assert.True(t, c.checkIssuanceRate("alice"))
+	assert.False(t, c.checkIssuanceRate("bob"))
+	assert.False(t, c.checkIssuanceRate(""), "unverified requests are never counted")
+}
@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// issuerRotatedSubject tells the other replicas to switch to their standby
+// issuer key. It is a coordination subject, never granted to users.
+const issuerRotatedSubject = internalSubjectPrefix + ".issuer.rotated"
+
+// IssuerRotationConfig configures the emergency issuer key rotation.
+type IssuerRotationConfig struct {
+	// StandbySeed is the pre-provisioned issuer key switched to on rotation.
+	StandbySeed string
+	// OperatorSeed and Account, when both set (operator mode), are used to
+	// re-sign the users' account JWT with the compromised signing key
+	// replaced by the standby one.
+	OperatorSeed string
+	Account      string
+	// KickAccount, when set, is the account whose client connections are
+	// disconnected so that every user re-authenticates.
+	KickAccount    string
+	RequestTimeout time.Duration
+}
+
+// LoadIssuerRotationConfig reads the issuer_rotation.* settings.
+func LoadIssuerRotationConfig() IssuerRotationConfig {
+	return IssuerRotationConfig{
+		StandbySeed:    viper.GetString("issuer_rotation.standby_seed"),
+		OperatorSeed:   viper.GetString("issuer_rotation.operator_seed"),
+		Account:        viper.GetString("issuer_rotation.account"),
+		KickAccount:    viper.GetString("issuer_rotation.kick_account"),
+		RequestTimeout: viper.GetDuration("issuer_rotation.request_timeout"),
+	}
+}
+
+// IssuerRotationResult reports what an issuer rotation did. Steps after the
+// key switch are best-effort: failures are listed in Warnings.
+type IssuerRotationResult struct {
+	OldPublicKey       string   `json:"old_public_key"`
+	NewPublicKey       string   `json:"new_public_key"`
+	AccountUpdated     bool     `json:"account_updated"`
+	CacheEntriesPurged int      `json:"cache_entries_purged"`
+	ConnectionsKicked  int      `json:"connections_kicked"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+type issuerRotatedEvent struct {
+	OldPublicKey string `json:"old_public_key"`
+	NewPublicKey string `json:"new_public_key"`
+	Reason       string `json:"reason"`
+}
+
+// issuer returns the signer currently used to sign JWTs.
+func (c *NATSClient) issuer() Signer {
+	c.issuerMu.RLock()
+	defer c.issuerMu.RUnlock()
+	return c.issuerSigner
+}
+
+// swapIssuer replaces the signing key if the active one still has the
+// expected public key, and reports whether it did.
+func (c *NATSClient) swapIssuer(expectedPub string, next Signer) bool {
+	c.issuerMu.Lock()
+	defer c.issuerMu.Unlock()
+	if pub, err := c.issuerSigner.PublicKey(); err != nil || pub != expectedPub {
+		return false
+	}
+	c.issuerSigner = next
+	return true
+}
+
+// RotateIssuer is the key-compromise response: it switches to the standby
+// issuer key on this and every other replica, updates the account JWT where
+// configured, invalidates the token cache and disconnects users so they
+// re-authenticate with JWTs signed by the new key. Every step is audited.
+func (c *NATSClient) RotateIssuer(ctx context.Context, reason string) (IssuerRotationResult, error) {
+	cfg := LoadIssuerRotationConfig()
+
+	if _, local := c.issuer().(keyPairSigner); !local {
+		err := errors.New("the issuer key is held by the external signer; rotate it there")
+		audit("issuer.rotate", "failed", "reason", reason, "error", err)
+		return IssuerRotationResult{}, err
+	}
+	standby, standbyPub, err := parseStandbyIssuer(cfg)
+	if err != nil {
+		audit("issuer.rotate", "failed", "reason", reason, "error", err)
+		return IssuerRotationResult{}, err
+	}
+	oldPub, err := c.issuer().PublicKey()
+	if err != nil {
+		return IssuerRotationResult{}, fmt.Errorf("failed to read active issuer key: %w", err)
+	}
+	if oldPub == standbyPub {
+		err := errors.New("standby issuer key is already active; configure a new issuer_rotation.standby_seed")
+		audit("issuer.rotate", "failed", "reason", reason, "error", err)
+		return IssuerRotationResult{}, err
+	}
+
+	if !c.swapIssuer(oldPub, NewKeyPairSigner(standby)) {
+		return IssuerRotationResult{}, errors.New("issuer key changed concurrently, rotation aborted")
+	}
+	audit("issuer.rotate", "key_switched", "reason", reason, "old_public_key", oldPub, "new_public_key", standbyPub)
+
+	res := IssuerRotationResult{OldPublicKey: oldPub, NewPublicKey: standbyPub}
+	warn := func(step string, err error) {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("%s: %v", step, err))
+		audit("issuer.rotate."+step, "failed", "error", err)
+	}
+
+	// Make the other replicas stop signing with the compromised key.
+	event, _ := json.Marshal(issuerRotatedEvent{OldPublicKey: oldPub, NewPublicKey: standbyPub, Reason: reason})
+	if err := c.coordination().Publish(issuerRotatedSubject, event); err != nil {
+		warn("notify_replicas", err)
+	}
+
+	if cfg.OperatorSeed != "" && cfg.Account != "" {
+		if err := c.replaceAccountSigningKey(cfg, oldPub, standbyPub); err != nil {
+			warn("update_account", err)
+		} else {
+			res.AccountUpdated = true
+			audit("issuer.rotate.update_account", "ok", "account", cfg.Account)
+		}
+	}
+
+	if inv, ok := c.tokenCache.(TokenCacheInvalidator); ok {
+		n, err := inv.InvalidateAll(ctx)
+		res.CacheEntriesPurged = n
+		if err != nil {
+			warn("invalidate_cache", err)
+		} else {
+			audit("issuer.rotate.invalidate_cache", "ok", "entries", n)
+		}
+	}
+
+	if cfg.KickAccount != "" {
+		n, err := c.kickAccountConnections(ctx, cfg)
+		res.ConnectionsKicked = n
+		if err != nil {
+			warn("kick_connections", err)
+		} else {
+			audit("issuer.rotate.kick_connections", "ok", "account", cfg.KickAccount, "connections", n)
+		}
+	}
+
+	audit("issuer.rotate", "completed",
+		"old_public_key", oldPub,
+		"new_public_key", standbyPub,
+		"account_updated", res.AccountUpdated,
+		"cache_entries_purged", res.CacheEntriesPurged,
+		"connections_kicked", res.ConnectionsKicked,
+		"warnings", len(res.Warnings),
+	)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("security_event", "issuer_rotation")
+		scope.SetContext("issuer_rotation", sentry.Context{
+			"old_public_key": oldPub,
+			"new_public_key": standbyPub,
+			"reason":         reason,
+			"warnings":       res.Warnings,
+		})
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureMessage("Issuer key rotated")
+	})
+	c.logger.Warn("Issuer key rotated; set nats.issuer_seed to the former standby seed and provision a new standby before restarting",
+		"new_public_key", standbyPub,
+	)
+	return res, nil
+}
+
+func parseStandbyIssuer(cfg IssuerRotationConfig) (nkeys.KeyPair, string, error) {
+	if cfg.StandbySeed == "" {
+		return nil, "", errors.New("issuer_rotation.standby_seed is not configured")
+	}
+	kp, err := nkeys.FromSeed([]byte(cfg.StandbySeed))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid issuer_rotation.standby_seed: %w", err)
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid issuer_rotation.standby_seed: %w", err)
+	}
+	return kp, pub, nil
+}
+
+// handleIssuerRotated makes this replica follow a rotation started on another one.
+func (c *NATSClient) handleIssuerRotated(msg *nats.Msg) {
+	var event issuerRotatedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		c.logger.Warn("Ignoring malformed issuer rotation event", "error", err)
+		return
+	}
+
+	current, _ := c.issuer().PublicKey()
+	if current == event.NewPublicKey {
+		return // we started this rotation, or already followed it
+	}
+	if current != event.OldPublicKey {
+		c.logger.Warn("Ignoring issuer rotation event for a key this replica does not use",
+			"old_public_key", event.OldPublicKey, "active_public_key", current)
+		return
+	}
+
+	standby, standbyPub, err := parseStandbyIssuer(LoadIssuerRotationConfig())
+	if err == nil && standbyPub != event.NewPublicKey {
+		err = fmt.Errorf("standby key %s does not match the rotated fleet key %s", standbyPub, event.NewPublicKey)
+	}
+	if err != nil {
+		audit("issuer.rotate.follow", "failed", "old_public_key", event.OldPublicKey, "error", err)
+		c.logger.Error("Cannot follow issuer rotation, this replica still signs with the compromised key", "error", err)
+		sentry.CaptureException(fmt.Errorf("cannot follow issuer rotation: %w", err))
+		return
+	}
+
+	if c.swapIssuer(event.OldPublicKey, NewKeyPairSigner(standby)) {
+		audit("issuer.rotate.follow", "ok", "reason", event.Reason, "old_public_key", event.OldPublicKey, "new_public_key", standbyPub)
+	}
+}
+
+// replaceAccountSigningKey re-signs the account JWT with the compromised
+// signing key replaced by the new one and pushes it to the NATS resolver.
+func (c *NATSClient) replaceAccountSigningKey(cfg IssuerRotationConfig, oldPub, newPub string) error {
+	operator, err := nkeys.FromSeed([]byte(cfg.OperatorSeed))
+	if err != nil {
+		return fmt.Errorf("invalid issuer_rotation.operator_seed: %w", err)
+	}
+
+	msg, err := c.nc.Request(fmt.Sprintf("$SYS.REQ.ACCOUNT.%s.CLAIMS.LOOKUP", cfg.Account), nil, cfg.RequestTimeout)
+	if err != nil {
+		return fmt.Errorf("account JWT lookup failed: %w", err)
+	}
+	ac, err := jwt.DecodeAccountClaims(string(msg.Data))
+	if err != nil {
+		return fmt.Errorf("invalid account JWT: %w", err)
+	}
+
+	ac.SigningKeys.Remove(oldPub)
+	ac.SigningKeys.Add(newPub)
+	token, err := ac.Encode(operator)
+	if err != nil {
+		return fmt.Errorf("failed to sign account JWT: %w", err)
+	}
+
+	msg, err = c.nc.Request("$SYS.REQ.CLAIMS.UPDATE", []byte(token), cfg.RequestTimeout)
+	if err != nil {
+		return fmt.Errorf("account JWT update failed: %w", err)
+	}
+	var resp serverAPIResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return fmt.Errorf("invalid account JWT update response: %w", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("account JWT update rejected: %s", resp.Error.Description)
+	}
+	return nil
+}
+
+// serverAPIResponse is the envelope of NATS system account API responses.
+type serverAPIResponse struct {
+	Server struct {
+		ID string `json:"id"`
+	} `json:"server"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error,omitempty"`
+}
+
+// kickAccountConnections disconnects every client of the account on every
+// server, forcing re-authentication through the auth callout. The kick
+// requests are sent at once and their answers awaited for one request
+// timeout in total, so the rotation does not wait a round trip per client.
+func (c *NATSClient) kickAccountConnections(ctx context.Context, cfg IssuerRotationConfig) (int, error) {
+	query, _ := json.Marshal(map[string]any{"acc": cfg.KickAccount, "limit": 100000})
+	responses, err := c.gatherResponses("$SYS.REQ.SERVER.PING.CONNZ", query, cfg.RequestTimeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(responses) == 0 {
+		return 0, errors.New("no server answered the connection list request (is the connection in the system account?)")
+	}
+
+	ownServer, ownCID := c.nc.ConnectedServerId(), uint64(0)
+	if cid, err := c.nc.GetClientID(); err == nil {
+		ownCID = cid
+	}
+
+	inbox := c.nc.NewRespInbox()
+	sub, err := c.nc.SubscribeSync(inbox)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	sent := 0
+	var errs []error
+	for _, resp := range responses {
+		var connz struct {
+			Connections []struct {
+				CID uint64 `json:"cid"`
+			} `json:"connections"`
+		}
+		if resp.Error != nil {
+			errs = append(errs, fmt.Errorf("server %s: %s", resp.Server.ID, resp.Error.Description))
+			continue
+		}
+		if err := json.Unmarshal(resp.Data, &connz); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", resp.Server.ID, err))
+			continue
+		}
+		for _, conn := range connz.Connections {
+			if resp.Server.ID == ownServer && conn.CID == ownCID {
+				continue
+			}
+			payload, _ := json.Marshal(map[string]uint64{"cid": conn.CID})
+			if err := c.nc.PublishRequest(fmt.Sprintf("$SYS.REQ.SERVER.%s.KICK", resp.Server.ID), inbox, payload); err != nil {
+				errs = append(errs, fmt.Errorf("server %s cid %d: %w", resp.Server.ID, conn.CID, err))
+				continue
+			}
+			sent++
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
+	defer cancel()
+	kicked := 0
+	for answered := 0; answered < sent; answered++ {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d of %d kick requests unanswered: %w", sent-answered, sent, err))
+			break
+		}
+		var resp serverAPIResponse
+		switch err := json.Unmarshal(msg.Data, &resp); {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid kick response: %w", err))
+		case resp.Error != nil:
+			errs = append(errs, fmt.Errorf("server %s: %s", resp.Server.ID, resp.Error.Description))
+		default:
+			kicked++
+		}
+	}
+	return kicked, errors.Join(errs...)
+}
+
+// gatherResponses sends a request answered by several servers and collects
+// the responses until none has arrived for the timeout.
+func (c *NATSClient) gatherResponses(subject string, data []byte, timeout time.Duration) ([]serverAPIResponse, error) {
+	inbox := c.nc.NewRespInbox()
+	sub, err := c.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	if err := c.nc.PublishRequest(subject, inbox, data); err != nil {
+		return nil, err
+	}
+
+	var out []serverAPIResponse
+	for {
+		msg, err := sub.NextMsg(timeout)
+		if errors.Is(err, nats.ErrTimeout) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		var resp serverAPIResponse
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			continue
+		}
+		out = append(out, resp)
+	}
+}
+
+// IssuerRotationHandler exposes RotateIssuer over HTTP (POST, optional JSON
+// body {"reason": "..."}). It must be mounted behind admin authentication.
+func (c *NATSClient) IssuerRotationHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid JSON body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		res, err := c.RotateIssuer(r.Context(), body.Reason)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAccountKey(t *testing.T) (nkeys.KeyPair, string, string) {
+	t.Helper()
+	kp, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	seed, err := kp.Seed()
+	require.NoError(t, err)
+	pub, err := kp.PublicKey()
+	require.NoError(t, err)
+	return kp, string(seed), pub
+}
+
+func TestRotateIssuer_RequiresUsableStandbyKey(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	active, activeSeed, _ := newAccountKey(t)
+	c := &NATSClient{issuerSigner: NewKeyPairSigner(active), logger: slog.Default()}
+
+	_, err := c.RotateIssuer(context.Background(), "test")
+	assert.ErrorContains(t, err, "standby_seed is not configured")
+
+	viper.Set("issuer_rotation.standby_seed", "not-a-seed")
+	_, err = c.RotateIssuer(context.Background(), "test")
+	assert.ErrorContains(t, err, "invalid issuer_rotation.standby_seed")
+
+	viper.Set("issuer_rotation.standby_seed", activeSeed)
+	_, err = c.RotateIssuer(context.Background(), "test")
+	assert.ErrorContains(t, err, "already active")
+	assert.Equal(t, NewKeyPairSigner(active), c.issuer())
+}
+
+func TestHandleIssuerRotated_FollowsFleetRotation(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	active, _, activePub := newAccountKey(t)
+	_, standbySeed, standbyPub := newAccountKey(t)
+	viper.Set("issuer_rotation.standby_seed", standbySeed)
+
+	event := func(oldPub, newPub string) *nats.Msg {
+		data, err := json.Marshal(issuerRotatedEvent{OldPublicKey: oldPub, NewPublicKey: newPub, Reason: "test"})
+		require.NoError(t, err)
+		return &nats.Msg{Data: data}
+	}
+	activeKey := func(c *NATSClient) string {
+		pub, err := c.issuer().PublicKey()
+		require.NoError(t, err)
+		return pub
+	}
+
+	t.Run("ignores events for another key", func(t *testing.T) {
+		c := &NATSClient{issuerSigner: NewKeyPairSigner(active), logger: slog.Default()}
+		c.handleIssuerRotated(event("AOTHER", standbyPub))
+		assert.Equal(t, activePub, activeKey(c))
+	})
+
+	t.Run("refuses a standby key that differs from the fleet key", func(t *testing.T) {
+		c := &NATSClient{issuerSigner: NewKeyPairSigner(active), logger: slog.Default()}
+		c.handleIssuerRotated(event(activePub, "ADIFFERENT"))
+		assert.Equal(t, activePub, activeKey(c))
+	})
+
+	t.Run("switches to the standby key", func(t *testing.T) {
+		c := &NATSClient{issuerSigner: NewKeyPairSigner(active), logger: slog.Default()}
+		c.handleIssuerRotated(event(activePub, standbyPub))
+		assert.Equal(t, standbyPub, activeKey(c))
+	})
+}
+
+func TestIssuerRotationHandler_RejectsNonPost(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	rec := httptest.NewRecorder()
+	c.IssuerRotationHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/issuer/rotate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
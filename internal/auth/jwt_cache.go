@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// JWTCacheConfig configures the cache of issued user JWTs.
+type JWTCacheConfig struct {
+	Enabled bool
+	// TTL is how long an issued JWT is reused.
+	TTL time.Duration
+	// MaxEntries bounds the cache; when full, new JWTs are not cached.
+	MaxEntries int
+}
+
+// LoadJWTCacheConfig reads the jwt_cache.* settings.
+func LoadJWTCacheConfig() JWTCacheConfig {
+	return JWTCacheConfig{
+		Enabled:    viper.GetBool("jwt_cache.enabled"),
+		TTL:        viper.GetDuration("jwt_cache.ttl"),
+		MaxEntries: viper.GetInt("jwt_cache.max_entries"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg JWTCacheConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.TTL <= 0:
+		return fmt.Errorf("jwt_cache: ttl must be > 0")
+	case cfg.MaxEntries <= 0:
+		return fmt.Errorf("jwt_cache: max_entries must be > 0")
+	}
+	return nil
+}
+
+// jwtCache keeps recently issued user JWTs, so a client reconnecting in a
+// storm gets the same JWT again without building and signing new claims. It
+// lives in memory only: a JWT is a signed credential and must not be shared
+// through the token cache bucket.
+type jwtCache struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	// configHash covers everything static that shapes a JWT: permission
+	// blocks, reserved prefixes, account subjects, the audience and the
+	// identity mode.
+	configHash string
+	entries    map[string]cachedJWT
+}
+
+type cachedJWT struct {
+	jwt     string
+	expires time.Time
+}
+
+func newJWTCache(cfg JWTCacheConfig, configHash string) *jwtCache {
+	return &jwtCache{
+		ttl:        cfg.TTL,
+		maxEntries: cfg.MaxEntries,
+		configHash: configHash,
+		entries:    make(map[string]cachedJWT),
+	}
+}
+
+// Get returns the cached JWT for key, if it has not expired. A nil cache
+// never hits.
+func (j *jwtCache) Get(key string, now time.Time) (string, bool) {
+	if j == nil {
+		return "", false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.entries[key]
+	if ok && now.Before(entry.expires) {
+		jwtCacheLookupsTotal.WithLabelValues("hit").Inc()
+		return entry.jwt, true
+	}
+	if ok {
+		delete(j.entries, key)
+	}
+	jwtCacheLookupsTotal.WithLabelValues("miss").Inc()
+	return "", false
+}
+
+// Put caches an issued JWT. When the cache is full, expired entries are
+// dropped first; if it is still full, the JWT is not cached.
+func (j *jwtCache) Put(key, token string, now time.Time) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[key]; !ok && len(j.entries) >= j.maxEntries {
+		for k, entry := range j.entries {
+			if !now.Before(entry.expires) {
+				delete(j.entries, k)
+			}
+		}
+		if len(j.entries) >= j.maxEntries {
+			return
+		}
+	}
+	j.entries[key] = cachedJWT{jwt: token, expires: now.Add(j.ttl)}
+}
+
+// jwtCacheKey identifies the JWT a request would be issued: the user, the
+// user nkey the JWT is bound to, everything that varies per user (groups,
+// token scopes, client tags, access request grant), the issuer and the
+// static configuration. Returns "" when the cache is disabled.
+func (c *NATSClient) jwtCacheKey(userNkey, username string, result AuthorizeResult, tags map[string]string) string {
+	if c.jwtCache == nil {
+		return ""
+	}
+	issuer, err := c.issuer().PublicKey()
+	if err != nil {
+		return ""
+	}
+
+	groups := slices.Clone(result.Groups())
+	for i := range groups {
+		groups[i] = strings.ToLower(groups[i])
+	}
+	slices.Sort(groups)
+
+	var grant UserGrant
+	if c.userGrants != nil {
+		grant, _ = c.userGrants.Lookup(result.Username())
+	}
+
+	scopes := normalizeScopes(strings.Join(result.Scopes(), ","))
+	return hashJSON([]any{c.jwtCache.hash(), issuer, userNkey, username, result.Username(), result.UserID(), result.TokenType(), result.Project(), groups, scopes, tagList(tags), grant})
+}
+
+// hashJSON returns the hex SHA-256 of v's JSON encoding.
+func hashJSON(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// initJWTCache optionally enables caching of issued JWTs.
+func (c *NATSClient) initJWTCache() error {
+	cfg := LoadJWTCacheConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	c.jwtCache = newJWTCache(cfg, c.jwtConfigHash())
+	c.logger.Info("Caching issued JWTs", "ttl", cfg.TTL, "max_entries", cfg.MaxEntries)
+	return nil
+}
+
+// jwtConfigHash hashes the configuration that shapes every issued JWT.
+func (c *NATSClient) jwtConfigHash() string {
+	global, tenants := c.permissionsConfig()
+	deploy, _ := c.tokenTypePermissions(TokenTypeDeploy)
+	jobs, _ := c.tokenTypePermissions(TokenTypeJob)
+	reservedPrefixes, accountSubjects := c.subjectLimits()
+	return hashJSON([]any{global, c.rolesConfig(), tenants, deploy, jobs, reservedPrefixes, accountSubjects, c.limitsConfig(), viper.GetString("nats.audience"), c.accounts.fingerprint(), c.teams.fingerprint(), c.policyHook.fingerprint(), identityMode()})
+}
+
+// hash returns the configuration hash the cached JWTs were issued under.
+func (j *jwtCache) hash() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.configHash
+}
+
+// Reset drops all cached JWTs when the configuration hash changed. A nil
+// cache is a no-op.
+func (j *jwtCache) Reset(configHash string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.configHash == configHash {
+		return
+	}
+	j.configHash = configHash
+	clear(j.entries)
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTCache_GetPut(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := newJWTCache(JWTCacheConfig{Enabled: true, TTL: 30 * time.Second, MaxEntries: 2}, "cfg")
+
+	_, ok := cache.Get("a", now)
+	assert.False(t, ok, "empty cache")
+
+	cache.Put("a", "jwt-a", now)
+	got, ok := cache.Get("a", now.Add(29*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, "jwt-a", got)
+
+	_, ok = cache.Get("a", now.Add(30*time.Second))
+	assert.False(t, ok, "expired")
+
+	t.Run("full cache drops expired entries first", func(t *testing.T) {
+		cache.Put("b", "jwt-b", now)
+		cache.Put("c", "jwt-c", now)
+		cache.Put("d", "jwt-d", now)
+		_, ok := cache.Get("d", now)
+		assert.False(t, ok, "no room while b and c are live")
+
+		later := now.Add(time.Minute)
+		cache.Put("d", "jwt-d", later)
+		got, ok := cache.Get("d", later)
+		require.True(t, ok)
+		assert.Equal(t, "jwt-d", got)
+	})
+
+	t.Run("nil cache never hits", func(t *testing.T) {
+		var disabled *jwtCache
+		disabled.Put("a", "jwt-a", now)
+		_, ok := disabled.Get("a", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestJWTCacheKey(t *testing.T) {
+	issuer, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	grants := newUserGrants(nil, slog.Default())
+	c := &NATSClient{
+		logger:       slog.Default(),
+		issuerSigner: NewKeyPairSigner(issuer),
+		jwtCache:     newJWTCache(JWTCacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10}, "cfg"),
+		userGrants:   grants,
+	}
+	result := AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "alice", Groups: []string{"Payments", "billing"}}}
+	key := c.jwtCacheKey("UAAA", "alice", result, nil)
+
+	assert.Equal(t, key, c.jwtCacheKey("UAAA", "alice", AuthorizeResult{Allow: true, Cached: &TokenCacheEntry{Username: "alice", Groups: "billing,payments"}}, nil),
+		"group order and case do not matter")
+	assert.NotEqual(t, key, c.jwtCacheKey("UBBB", "alice", result, nil), "user nkey")
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "bob", result, nil), "username")
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "alice", AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "alice"}}, nil), "groups")
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "alice", AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "alice", Groups: []string{"billing", "payments"}, Scopes: []string{"api"}}}, nil), "scopes")
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "alice", result, map[string]string{"environment": "staging"}), "client tags")
+
+	require.NoError(t, grants.apply("alice", []byte(`{"publish":["orders.>"]}`), false))
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "alice", result, nil), "grant")
+
+	rotated, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	c.issuerSigner = NewKeyPairSigner(rotated)
+	assert.NotEqual(t, key, c.jwtCacheKey("UAAA", "alice", result, nil), "issuer")
+
+	assert.Empty(t, (&NATSClient{}).jwtCacheKey("UAAA", "alice", result, nil), "disabled")
+}
+
+func TestInitJWTCache(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	c := &NATSClient{logger: slog.Default()}
+
+	require.NoError(t, c.initJWTCache())
+	assert.Nil(t, c.jwtCache, "disabled by default")
+
+	viper.Set("jwt_cache.enabled", true)
+	viper.Set("jwt_cache.ttl", "30s")
+	assert.ErrorContains(t, c.initJWTCache(), "max_entries")
+
+	viper.Set("jwt_cache.max_entries", 100)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	require.NoError(t, c.initJWTCache())
+	require.NotNil(t, c.jwtCache)
+	first := c.jwtCache.configHash
+
+	viper.Set("nats.permissions.publish.allow", []string{"other.>"})
+	require.NoError(t, c.initJWTCache())
+	assert.NotEqual(t, first, c.jwtCache.configHash, "permission config is part of the key")
+}
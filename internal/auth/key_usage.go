@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// Key usage anomaly kinds, as reported by gcs_antal_issuer_anomalies_total.
+const (
+	anomalySpike    = "spike"
+	anomalyOffHours = "off_hours"
+)
+
+// keyUsageWarmup is how many minutes of history a key needs before spikes
+// are reported, so the first minutes after a start are not compared with an
+// empty baseline.
+const keyUsageWarmup = 10
+
+// KeyUsageConfig holds the key_usage.* settings.
+type KeyUsageConfig struct {
+	Enabled bool
+	// SpikeFactor reports a minute with more than SpikeFactor times the
+	// baseline (the moving average of the previous minutes) and at least
+	// SpikeMinPerMinute JWTs.
+	SpikeFactor       float64
+	SpikeMinPerMinute int
+	// BaselineMinutes is the span of the moving average.
+	BaselineMinutes int
+	// BusinessHours ("08:00-18:00") and BusinessDays in Location; outside of
+	// them more than OffHoursMaxPerMinute JWTs a minute are reported. An
+	// empty BusinessHours or a zero maximum disables the check.
+	BusinessHours        string
+	BusinessDays         []string
+	Location             *time.Location
+	OffHoursMaxPerMinute int
+	// AlertInterval is the minimum time between two alerts of the same kind
+	// for the same key.
+	AlertInterval time.Duration
+}
+
+// LoadKeyUsageConfig reads the key_usage.* settings.
+func LoadKeyUsageConfig() (KeyUsageConfig, error) {
+	cfg := KeyUsageConfig{
+		Enabled:              viper.GetBool("key_usage.enabled"),
+		SpikeFactor:          viper.GetFloat64("key_usage.spike_factor"),
+		SpikeMinPerMinute:    viper.GetInt("key_usage.spike_min_per_minute"),
+		BaselineMinutes:      viper.GetInt("key_usage.baseline_minutes"),
+		BusinessHours:        strings.TrimSpace(viper.GetString("key_usage.business_hours")),
+		BusinessDays:         viper.GetStringSlice("key_usage.business_days"),
+		OffHoursMaxPerMinute: viper.GetInt("key_usage.off_hours_max_per_minute"),
+		AlertInterval:        viper.GetDuration("key_usage.alert_interval"),
+	}
+	loc, err := time.LoadLocation(viper.GetString("key_usage.timezone"))
+	if err != nil {
+		return KeyUsageConfig{}, fmt.Errorf("key_usage: invalid timezone: %w", err)
+	}
+	cfg.Location = loc
+	return cfg, nil
+}
+
+// Validate checks an enabled configuration.
+func (cfg KeyUsageConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch {
+	case cfg.SpikeFactor <= 1:
+		return fmt.Errorf("key_usage: spike_factor must be > 1")
+	case cfg.BaselineMinutes <= 0:
+		return fmt.Errorf("key_usage: baseline_minutes must be > 0")
+	case cfg.AlertInterval <= 0:
+		return fmt.Errorf("key_usage: alert_interval must be > 0")
+	}
+	if _, _, err := parseBusinessHours(cfg.BusinessHours); err != nil {
+		return err
+	}
+	for _, day := range cfg.BusinessDays {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("key_usage: unknown business day %q", day)
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseBusinessHours parses "HH:MM-HH:MM" into minutes since midnight; the
+// empty string yields 0, 0.
+func parseBusinessHours(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if !ok || err1 != nil || err2 != nil || !end.After(start) {
+		return 0, 0, fmt.Errorf("key_usage: business_hours must be HH:MM-HH:MM, got %q", s)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// offHours reports whether t lies outside the business hours and days.
+func (cfg KeyUsageConfig) offHours(t time.Time) bool {
+	start, end, err := parseBusinessHours(cfg.BusinessHours)
+	if err != nil || start == end {
+		return false
+	}
+	t = t.In(cfg.Location)
+	if len(cfg.BusinessDays) > 0 && !slices.ContainsFunc(cfg.BusinessDays, func(day string) bool {
+		return weekdays[strings.ToLower(day)] == t.Weekday()
+	}) {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	return minute < start || minute >= end
+}
+
+// keyStats is the issuance history of one issuer key.
+type keyStats struct {
+	minute time.Time
+	count  int
+	// baseline is the exponential moving average of the JWTs per minute
+	// over the previous minutes; history counts them up to the warmup.
+	baseline  float64
+	history   int
+	lastAlert map[string]time.Time
+}
+
+// KeyUsage counts the JWTs signed by each issuer key per minute and flags
+// spikes against the key's own baseline and issuance outside business
+// hours, an early signal that the signing capability is abused. Counts are
+// per replica.
+type KeyUsage struct {
+	cfg    KeyUsageConfig
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	keys map[string]*keyStats
+}
+
+// NewKeyUsage creates the key usage statistics.
+func NewKeyUsage(cfg KeyUsageConfig) *KeyUsage {
+	return &KeyUsage{cfg: cfg, logger: slog.With("component", "key_usage"), keys: make(map[string]*keyStats)}
+}
+
+// Record counts a JWT signed by issuer at now and returns the anomalies to
+// alert on, with the count of the current minute.
+func (u *KeyUsage) Record(issuer string, now time.Time) ([]string, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st, ok := u.keys[issuer]
+	if !ok {
+		st = &keyStats{minute: now.Truncate(time.Minute), lastAlert: map[string]time.Time{}}
+		u.keys[issuer] = st
+	}
+	u.advance(st, now.Truncate(time.Minute))
+	st.count++
+	issuerIssuanceBaseline.WithLabelValues(issuer).Set(st.baseline)
+
+	var anomalies []string
+	threshold := math.Max(float64(u.cfg.SpikeMinPerMinute), u.cfg.SpikeFactor*st.baseline)
+	if st.history >= keyUsageWarmup && float64(st.count) > threshold {
+		anomalies = append(anomalies, anomalySpike)
+	}
+	if u.cfg.OffHoursMaxPerMinute > 0 && st.count > u.cfg.OffHoursMaxPerMinute && u.cfg.offHours(now) {
+		anomalies = append(anomalies, anomalyOffHours)
+	}
+	return slices.DeleteFunc(anomalies, func(kind string) bool {
+		if now.Sub(st.lastAlert[kind]) < u.cfg.AlertInterval {
+			return true
+		}
+		st.lastAlert[kind] = now
+		return false
+	}), st.count
+}
+
+// advance closes the minutes before minute, folding their counts (zero for
+// minutes without issuance) into the baseline.
+func (u *KeyUsage) advance(st *keyStats, minute time.Time) {
+	if !minute.After(st.minute) {
+		return
+	}
+	alpha := 2 / float64(u.cfg.BaselineMinutes+1)
+	st.baseline = alpha*float64(st.count) + (1-alpha)*st.baseline
+	gap := int(minute.Sub(st.minute)/time.Minute) - 1
+	if gap > 0 {
+		st.baseline *= math.Pow(1-alpha, float64(gap))
+	}
+	st.history = min(st.history+1+gap, keyUsageWarmup)
+	st.minute, st.count = minute, 0
+}
+
+// initKeyUsage optionally starts watching issuer key usage for anomalies.
+func (c *NATSClient) initKeyUsage() error {
+	cfg, err := LoadKeyUsageConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.keyUsage = NewKeyUsage(cfg)
+	c.logger.Info("Issuer key usage anomaly alerts enabled",
+		"spike_factor", cfg.SpikeFactor, "business_hours", cfg.BusinessHours, "off_hours_max_per_minute", cfg.OffHoursMaxPerMinute)
+	return nil
+}
+
+// recordIssuance counts a user JWT signed by signer (the issuer key or an
+// account signing key) and alerts on anomalies.
+func (c *NATSClient) recordIssuance(username string, signer Signer) {
+	issuer, err := signer.PublicKey()
+	if err != nil {
+		return
+	}
+	issuerJWTsIssuedTotal.WithLabelValues(issuer).Inc()
+	if c.keyUsage == nil {
+		return
+	}
+
+	anomalies, count := c.keyUsage.Record(issuer, time.Now())
+	for _, kind := range anomalies {
+		issuerAnomaliesTotal.WithLabelValues(kind).Inc()
+		c.keyUsage.logger.Warn("Unusual issuer key usage",
+			"kind", kind,
+			"issuer", issuer,
+			"jwts_this_minute", count,
+			"username", username,
+		)
+		sentry.WithScope(func(scope *sentry.Scope) {
+			scope.SetTag("anomaly", kind)
+			scope.SetTag("issuer", issuer)
+			scope.SetLevel(sentry.LevelWarning)
+			scope.SetContext("key_usage", sentry.Context{"jwts_this_minute": count})
+			sentry.CaptureMessage("Unusual issuer key usage: " + kind)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyUsageConfig() KeyUsageConfig {
+	return KeyUsageConfig{Enabled: true, SpikeFactor: 5, SpikeMinPerMinute: 10, BaselineMinutes: 10,
+		Location: time.UTC, AlertInterval: 15 * time.Minute}
+}
+
+func TestKeyUsageConfig_Validate(t *testing.T) {
+	assert.NoError(t, KeyUsageConfig{}.Validate(), "disabled")
+	assert.NoError(t, testKeyUsageConfig().Validate())
+
+	cfg := testKeyUsageConfig()
+	cfg.SpikeFactor = 1
+	assert.Error(t, cfg.Validate())
+
+	cfg = testKeyUsageConfig()
+	cfg.BusinessHours = "18:00-08:00"
+	assert.Error(t, cfg.Validate())
+	cfg.BusinessHours = "08:00-18:00"
+	cfg.BusinessDays = []string{"Mon", "funday"}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestKeyUsageConfig_OffHours(t *testing.T) {
+	cfg := testKeyUsageConfig()
+	monday := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	assert.False(t, cfg.offHours(monday), "no business hours configured")
+
+	cfg.BusinessHours, cfg.BusinessDays = "08:00-18:00", []string{"mon", "tue", "wed", "thu", "fri"}
+	assert.True(t, cfg.offHours(monday.Add(7*time.Hour+59*time.Minute)))
+	assert.False(t, cfg.offHours(monday.Add(8*time.Hour)))
+	assert.True(t, cfg.offHours(monday.Add(18*time.Hour)))
+	assert.True(t, cfg.offHours(monday.Add(-12*time.Hour)), "Sunday noon")
+
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	require.NoError(t, err)
+	cfg.Location = warsaw
+	assert.False(t, cfg.offHours(monday.Add(16*time.Hour-time.Minute)), "15:59 UTC is 17:59 in Warsaw")
+	assert.True(t, cfg.offHours(monday.Add(16*time.Hour)), "18:00 in Warsaw")
+}
+
+func TestKeyUsage_Spike(t *testing.T) {
+	u := NewKeyUsage(testKeyUsageConfig())
+	start := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+	// Two JWTs a minute make the baseline.
+	for minute := range keyUsageWarmup {
+		for i := range 2 {
+			anomalies, _ := u.Record("AISSUER", start.Add(time.Duration(minute)*time.Minute+time.Duration(i)*time.Second))
+			assert.Empty(t, anomalies)
+		}
+	}
+
+	spike := start.Add(keyUsageWarmup * time.Minute)
+	var alerts [][]string
+	for i := range 30 {
+		anomalies, _ := u.Record("AISSUER", spike.Add(time.Duration(i)*time.Second))
+		if len(anomalies) > 0 {
+			alerts = append(alerts, anomalies)
+		}
+	}
+	require.Len(t, alerts, 1, "alerted once per interval")
+	assert.Equal(t, []string{anomalySpike}, alerts[0])
+
+	anomalies, count := u.Record("ANEWKEY", spike)
+	assert.Empty(t, anomalies, "a new key has no history yet")
+	assert.Equal(t, 1, count)
+}
+
+func TestKeyUsage_IdleMinutesLowerTheBaseline(t *testing.T) {
+	u := NewKeyUsage(testKeyUsageConfig())
+	start := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	for i := range 20 {
+		u.Record("AISSUER", start.Add(time.Duration(i)*time.Second))
+	}
+	u.Record("AISSUER", start.Add(time.Minute))
+	after := u.keys["AISSUER"].baseline
+	u.Record("AISSUER", start.Add(time.Hour))
+	assert.Less(t, u.keys["AISSUER"].baseline, after/10)
+	assert.Equal(t, keyUsageWarmup, u.keys["AISSUER"].history)
+}
+
+func TestKeyUsage_OffHours(t *testing.T) {
+	cfg := testKeyUsageConfig()
+	cfg.BusinessHours, cfg.OffHoursMaxPerMinute = "08:00-18:00", 3
+	u := NewKeyUsage(cfg)
+
+	night := time.Date(2025, 6, 2, 2, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		anomalies, _ := u.Record("AISSUER", night.Add(time.Duration(i)*time.Second))
+		assert.Empty(t, anomalies)
+	}
+	anomalies, count := u.Record("AISSUER", night.Add(4*time.Second))
+	assert.Equal(t, []string{anomalyOffHours}, anomalies)
+	assert.Equal(t, 4, count)
+
+	day := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		anomalies, _ := u.Record("AOTHER", day.Add(time.Duration(i)*time.Second))
+		assert.Empty(t, anomalies)
+	}
+}
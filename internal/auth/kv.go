@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// KV connection modes (nats.kv_connection).
+const (
+	kvConnectionShared    = "shared"
+	kvConnectionDedicated = "dedicated"
+)
+
+// LoadKVConnectionMode reads nats.kv_connection and checks its value.
+func LoadKVConnectionMode() (string, error) {
+	switch mode := viper.GetString("nats.kv_connection"); mode {
+	case "", kvConnectionShared:
+		return kvConnectionShared, nil
+	case kvConnectionDedicated:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("nats.kv_connection must be %q or %q, got %q", kvConnectionShared, kvConnectionDedicated, mode)
+	}
+}
+
+// connectKV opens the dedicated connection for JetStream KV traffic, with
+// the credentials of the auth connection: the buckets live in its account.
+func connectKV(logger *slog.Logger, url string, creds NATSCredentials, tlsConfig *tls.Config) (*nats.Conn, error) {
+	logger = logger.With("connection", "kv")
+	opts := append(buildNATSOptions(logger, creds, tlsConfig), nats.Name("gcs_antal kv"))
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect KV connection: %w", err)
+	}
+	logger.Info("Connected dedicated KV connection", "url", nc.ConnectedUrl())
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "nats",
+		Message:  "Connected dedicated KV connection",
+		Level:    sentry.LevelInfo,
+		Data: map[string]interface{}{
+			"server": nc.ConnectedUrl(),
+		},
+	})
+	return nc, nil
+}
+
+// jetStream returns the JetStream context for KV buckets: on the dedicated
+// KV connection when configured, so slow KV responses and cache traffic
+// cannot hold up the callout subscription, on the auth connection otherwise.
+func (c *NATSClient) jetStream() (nats.JetStreamContext, error) {
+	if c.kvConn != nil {
+		return c.kvConn.JetStream()
+	}
+	return c.nc.JetStream()
+}
+
+// jetStreamAPI returns the jetstream API on the connection jetStream uses.
+// Its KV operations take a context, so they end at the caller's deadline.
+func (c *NATSClient) jetStreamAPI() (jetstream.JetStream, error) {
+	if c.kvConn != nil {
+		return jetstream.New(c.kvConn)
+	}
+	return jetstream.New(c.nc)
+}
+
+// bindOrCreateKV binds to an existing JetStream KV bucket, creating it from
+// cfg when it does not exist yet. It reports whether the bucket was created.
+// Operations on the bucket retry transient errors (see nats.kv_retry).
+func bindOrCreateKV(js nats.JetStreamContext, cfg *nats.KeyValueConfig) (nats.KeyValue, bool, error) {
+	kv, err := js.KeyValue(cfg.Bucket)
+	if err == nil {
+		return newRetryKV(kv, LoadKVRetryConfig()), false, nil
+	}
+	if !errors.Is(err, nats.ErrBucketNotFound) {
+		return nil, false, fmt.Errorf("failed to access bucket %q: %w", cfg.Bucket, err)
+	}
+
+	kv, err = js.CreateKeyValue(cfg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create bucket %q: %w", cfg.Bucket, err)
+	}
+	return newRetryKV(kv, LoadKVRetryConfig()), true, nil
+}
+
+// bindOrCreateKeyValue is bindOrCreateKV for the jetstream API.
+func bindOrCreateKeyValue(ctx context.Context, js jetstream.JetStream, cfg jetstream.KeyValueConfig) (jetstream.KeyValue, bool, error) {
+	kv, err := js.KeyValue(ctx, cfg.Bucket)
+	if err == nil {
+		return newRetryKeyValue(kv, LoadKVRetryConfig()), false, nil
+	}
+	if !errors.Is(err, jetstream.ErrBucketNotFound) {
+		return nil, false, fmt.Errorf("failed to access bucket %q: %w", cfg.Bucket, err)
+	}
+
+	kv, err = js.CreateKeyValue(ctx, cfg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create bucket %q: %w", cfg.Bucket, err)
+	}
+	return newRetryKeyValue(kv, LoadKVRetryConfig()), true, nil
+}
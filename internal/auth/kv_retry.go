@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+)
+
+// KVRetryConfig holds the nats.kv_retry.* settings.
+type KVRetryConfig struct {
+	// Attempts is how often a KV operation is tried in total; 1 or less
+	// disables retries.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles with every
+	// further retry.
+	Backoff time.Duration
+}
+
+// LoadKVRetryConfig reads the nats.kv_retry.* settings.
+func LoadKVRetryConfig() KVRetryConfig {
+	return KVRetryConfig{
+		Attempts: viper.GetInt("nats.kv_retry.attempts"),
+		Backoff:  viper.GetDuration("nats.kv_retry.backoff"),
+	}
+}
+
+// isTransientKVError reports whether a KV operation failed because no
+// JetStream server answered, as happens for a moment while a NATS server
+// restarts and its streams move to another leader. The operation never
+// reached a stream, so trying again is safe.
+func isTransientKVError(err error) bool {
+	return errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrNoStreamResponse) ||
+		errors.Is(err, nats.ErrJetStreamNotEnabled) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, jetstream.ErrJetStreamNotEnabled)
+}
+
+// kvRetrier runs KV operations with the nats.kv_retry policy.
+type kvRetrier struct {
+	cfg    KVRetryConfig
+	bucket string
+	sleep  func(time.Duration)
+	logger *slog.Logger
+}
+
+func newKVRetrier(bucket string, cfg KVRetryConfig) kvRetrier {
+	return kvRetrier{
+		cfg:    cfg,
+		bucket: bucket,
+		sleep:  time.Sleep,
+		logger: slog.With("component", "kv_retry", "bucket", bucket),
+	}
+}
+
+// do runs op until it succeeds, fails permanently or runs out of attempts.
+func (k kvRetrier) do(name string, op func() error) error {
+	backoff := k.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if !isTransientKVError(err) {
+			if attempt > 1 {
+				kvRetriesTotal.WithLabelValues(k.bucket, "recovered").Inc()
+				k.logger.Debug("KV operation recovered after retry", "op", name, "attempts", attempt)
+			}
+			return err
+		}
+		if attempt >= k.cfg.Attempts {
+			kvRetriesTotal.WithLabelValues(k.bucket, "exhausted").Inc()
+			k.logger.Warn("KV operation failed after retries", "op", name, "attempts", attempt, "error", err)
+			return err
+		}
+		k.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// retryKV retries KV operations that failed with a transient error, so a
+// rolling restart of NATS does not turn into cache misses or auth errors.
+// Errors that remain after the last attempt are returned as before, and the
+// caller's usual handling (e.g. denying with auth_error) applies. Watches
+// are passed through; they resume on their own after a reconnect.
+type retryKV struct {
+	nats.KeyValue
+	kvRetrier
+}
+
+// newRetryKV wraps kv with retries, unless they are disabled.
+func newRetryKV(kv nats.KeyValue, cfg KVRetryConfig) nats.KeyValue {
+	if cfg.Attempts <= 1 {
+		return kv
+	}
+	return &retryKV{KeyValue: kv, kvRetrier: newKVRetrier(kv.Bucket(), cfg)}
+}
+
+func (k *retryKV) Get(key string) (entry nats.KeyValueEntry, err error) {
+	err = k.do("get", func() error {
+		entry, err = k.KeyValue.Get(key)
+		return err
+	})
+	return entry, err
+}
+
+func (k *retryKV) GetRevision(key string, revision uint64) (entry nats.KeyValueEntry, err error) {
+	err = k.do("get", func() error {
+		entry, err = k.KeyValue.GetRevision(key, revision)
+		return err
+	})
+	return entry, err
+}
+
+func (k *retryKV) Put(key string, value []byte) (rev uint64, err error) {
+	err = k.do("put", func() error {
+		rev, err = k.KeyValue.Put(key, value)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKV) PutString(key string, value string) (uint64, error) {
+	return k.Put(key, []byte(value))
+}
+
+func (k *retryKV) Create(key string, value []byte) (rev uint64, err error) {
+	err = k.do("create", func() error {
+		rev, err = k.KeyValue.Create(key, value)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKV) Update(key string, value []byte, last uint64) (rev uint64, err error) {
+	err = k.do("update", func() error {
+		rev, err = k.KeyValue.Update(key, value, last)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKV) Delete(key string, opts ...nats.DeleteOpt) error {
+	return k.do("delete", func() error { return k.KeyValue.Delete(key, opts...) })
+}
+
+func (k *retryKV) Purge(key string, opts ...nats.DeleteOpt) error {
+	return k.do("purge", func() error { return k.KeyValue.Purge(key, opts...) })
+}
+
+func (k *retryKV) Keys(opts ...nats.WatchOpt) (keys []string, err error) {
+	err = k.do("keys", func() error {
+		keys, err = k.KeyValue.Keys(opts...)
+		return err
+	})
+	return keys, err
+}
+
+func (k *retryKV) History(key string, opts ...nats.WatchOpt) (entries []nats.KeyValueEntry, err error) {
+	err = k.do("history", func() error {
+		entries, err = k.KeyValue.History(key, opts...)
+		return err
+	})
+	return entries, err
+}
+
+// retryKeyValue is retryKV for the jetstream KV API. An operation whose
+// context is done fails with the context's error, which is not retried.
+type retryKeyValue struct {
+	jetstream.KeyValue
+	kvRetrier
+}
+
+// newRetryKeyValue wraps kv with retries, unless they are disabled.
+func newRetryKeyValue(kv jetstream.KeyValue, cfg KVRetryConfig) jetstream.KeyValue {
+	if cfg.Attempts <= 1 {
+		return kv
+	}
+	return &retryKeyValue{KeyValue: kv, kvRetrier: newKVRetrier(kv.Bucket(), cfg)}
+}
+
+func (k *retryKeyValue) Get(ctx context.Context, key string) (entry jetstream.KeyValueEntry, err error) {
+	err = k.do("get", func() error {
+		entry, err = k.KeyValue.Get(ctx, key)
+		return err
+	})
+	return entry, err
+}
+
+func (k *retryKeyValue) Put(ctx context.Context, key string, value []byte) (rev uint64, err error) {
+	err = k.do("put", func() error {
+		rev, err = k.KeyValue.Put(ctx, key, value)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKeyValue) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (rev uint64, err error) {
+	err = k.do("create", func() error {
+		rev, err = k.KeyValue.Create(ctx, key, value, opts...)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKeyValue) Update(ctx context.Context, key string, value []byte, last uint64) (rev uint64, err error) {
+	err = k.do("update", func() error {
+		rev, err = k.KeyValue.Update(ctx, key, value, last)
+		return err
+	})
+	return rev, err
+}
+
+func (k *retryKeyValue) Delete(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	return k.do("delete", func() error { return k.KeyValue.Delete(ctx, key, opts...) })
+}
+
+func (k *retryKeyValue) Purge(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	return k.do("purge", func() error { return k.KeyValue.Purge(ctx, key, opts...) })
+}
+
+func (k *retryKeyValue) ListKeys(ctx context.Context, opts ...jetstream.WatchOpt) (lister jetstream.KeyLister, err error) {
+	err = k.do("keys", func() error {
+		lister, err = k.KeyValue.ListKeys(ctx, opts...)
+		return err
+	})
+	return lister, err
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failoverKV fails the next failures operations like a KV bucket whose
+// stream has no leader yet.
+type failoverKV struct {
+	*revisionKV
+	failures int
+	err      error
+	calls    int
+}
+
+func (k *failoverKV) fail() error {
+	k.calls++
+	if k.failures > 0 {
+		k.failures--
+		return k.err
+	}
+	return nil
+}
+
+func (k *failoverKV) Bucket() string { return "test_retry" }
+
+func (k *failoverKV) Get(key string) (nats.KeyValueEntry, error) {
+	if err := k.fail(); err != nil {
+		return nil, err
+	}
+	return k.revisionKV.Get(key)
+}
+
+func (k *failoverKV) Put(key string, value []byte) (uint64, error) {
+	if err := k.fail(); err != nil {
+		return 0, err
+	}
+	return k.revisionKV.Put(key, value)
+}
+
+func newTestRetryKV(kv *failoverKV, attempts int) (*retryKV, *[]time.Duration) {
+	var waits []time.Duration
+	r := newRetryKV(kv, KVRetryConfig{Attempts: attempts, Backoff: 100 * time.Millisecond}).(*retryKV)
+	r.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return r, &waits
+}
+
+func TestRetryKV_Recovers(t *testing.T) {
+	kv := &failoverKV{revisionKV: newRevisionKV(), failures: 2, err: nats.ErrNoResponders}
+	r, waits := newTestRetryKV(kv, 3)
+	recovered := testutil.ToFloat64(kvRetriesTotal.WithLabelValues("test_retry", "recovered"))
+
+	_, err := r.Put("alice", []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, kv.calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *waits, "the backoff doubles")
+	assert.Equal(t, recovered+1, testutil.ToFloat64(kvRetriesTotal.WithLabelValues("test_retry", "recovered")))
+
+	kv.calls = 0
+	entry, err := r.Get("alice")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), entry.Value())
+	assert.Equal(t, 1, kv.calls)
+}
+
+func TestRetryKV_Exhausted(t *testing.T) {
+	kv := &failoverKV{revisionKV: newRevisionKV(), failures: 5, err: nats.ErrJetStreamNotEnabled}
+	r, _ := newTestRetryKV(kv, 3)
+	exhausted := testutil.ToFloat64(kvRetriesTotal.WithLabelValues("test_retry", "exhausted"))
+
+	_, err := r.Get("alice")
+	assert.ErrorIs(t, err, nats.ErrJetStreamNotEnabled, "the last error is returned")
+	assert.Equal(t, 3, kv.calls)
+	assert.Equal(t, exhausted+1, testutil.ToFloat64(kvRetriesTotal.WithLabelValues("test_retry", "exhausted")))
+}
+
+func TestRetryKV_PermanentErrors(t *testing.T) {
+	kv := &failoverKV{revisionKV: newRevisionKV()}
+	r, waits := newTestRetryKV(kv, 3)
+
+	_, err := r.Get("missing")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	assert.Equal(t, 1, kv.calls)
+
+	kv.calls, kv.failures, kv.err = 0, 1, errors.New("boom")
+	_, err = r.Get("missing")
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, kv.calls)
+	assert.Empty(t, *waits)
+}
+
+func TestNewRetryKV_Disabled(t *testing.T) {
+	kv := &failoverKV{revisionKV: newRevisionKV()}
+	assert.Same(t, kv, newRetryKV(kv, KVRetryConfig{Attempts: 1}))
+	assert.Same(t, kv, newRetryKV(kv, KVRetryConfig{}))
+}
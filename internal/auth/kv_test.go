@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKVConnectionMode(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	mode, err := LoadKVConnectionMode()
+	require.NoError(t, err)
+	assert.Equal(t, kvConnectionShared, mode, "unset shares the auth connection")
+
+	viper.Set("nats.kv_connection", "dedicated")
+	mode, err = LoadKVConnectionMode()
+	require.NoError(t, err)
+	assert.Equal(t, kvConnectionDedicated, mode)
+
+	viper.Set("nats.kv_connection", "separate")
+	_, err = LoadKVConnectionMode()
+	assert.ErrorContains(t, err, "nats.kv_connection")
+}
+
+func TestJetStream_SharedConnection(t *testing.T) {
+	c := &NATSClient{nc: &nats.Conn{}}
+	js, err := c.jetStream()
+	require.NoError(t, err, "falls back to the auth connection")
+	assert.NotNil(t, js)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// LoadSheddingConfig configures how requests are shed once the auth
+// requests pile up in the subscription.
+type LoadSheddingConfig struct {
+	Enabled bool
+	// QueueDepth is the number of pending auth requests from which new
+	// requests are shed.
+	QueueDepth int
+	// Percent of the requests shed while the queue is at least QueueDepth.
+	Percent int
+	// RetryAfter is the delay suggested to the shed clients.
+	RetryAfter time.Duration
+}
+
+// LoadLoadSheddingConfig reads the load_shedding.* settings. They are read
+// on every request so they can change at runtime.
+func LoadLoadSheddingConfig() LoadSheddingConfig {
+	return LoadSheddingConfig{
+		Enabled:    viper.GetBool("load_shedding.enabled"),
+		QueueDepth: viper.GetInt("load_shedding.queue_depth"),
+		Percent:    viper.GetInt("load_shedding.percent"),
+		RetryAfter: viper.GetDuration("load_shedding.retry_after"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg LoadSheddingConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.QueueDepth <= 0:
+		return fmt.Errorf("load_shedding: queue_depth must be > 0")
+	case cfg.Percent <= 0 || cfg.Percent > 100:
+		return fmt.Errorf("load_shedding: percent must be between 1 and 100")
+	case cfg.RetryAfter <= 0:
+		return fmt.Errorf("load_shedding: retry_after must be > 0")
+	}
+	return nil
+}
+
+// Shed reports whether a request is shed at the given queue depth; roll is
+// a uniformly random number in [0, 100).
+func (cfg LoadSheddingConfig) Shed(depth, roll int) bool {
+	return cfg.Enabled && cfg.QueueDepth > 0 && depth >= cfg.QueueDepth && roll < cfg.Percent
+}
+
+// retryLaterText is the deny message of shed requests. Clients parse the
+// delay with antalclient.RetryAfter.
+func retryLaterText(retryAfter time.Duration) string {
+	return "overloaded, retry after " + retryAfter.String()
+}
+
+// authQueueDepth returns the number of auth requests waiting in the
+// subscription.
+func (c *NATSClient) authQueueDepth() int {
+	sub := c.authSub.Load()
+	if sub == nil {
+		return 0
+	}
+	pending, _, err := sub.Pending()
+	if err != nil {
+		return 0
+	}
+	return pending
+}
+
+// checkLoadShedding reports whether a request is turned away because the
+// queue is too deep. Requests the in-memory token cache can answer without
+// GitLab are cheap and never shed.
+func (c *NATSClient) checkLoadShedding(token string) (time.Duration, bool) {
+	depth := c.authQueueDepth()
+	authQueueDepth.Set(float64(depth))
+
+	cfg := LoadLoadSheddingConfig()
+	if !cfg.Shed(depth, rand.IntN(100)) {
+		return 0, false
+	}
+	if recent, ok := c.tokenCache.(recentVerifications); ok {
+		if _, ok := recent.Recent(token); ok {
+			return 0, false
+		}
+	}
+	loadShedRequestsTotal.Inc()
+	return cfg.RetryAfter, true
+}
+
+// initLoadShedding validates the load shedding settings; the policy itself
+// is read on every request.
+func (c *NATSClient) initLoadShedding() error {
+	cfg := LoadLoadSheddingConfig()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Enabled {
+		c.logger.Info("Load shedding enabled", "queue_depth", cfg.QueueDepth, "percent", cfg.Percent, "retry_after", cfg.RetryAfter)
+	}
+	return nil
+}
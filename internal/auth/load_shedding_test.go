@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/antalclient"
+)
+
+func TestLoadSheddingConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("load_shedding.enabled", true)
+	viper.Set("load_shedding.queue_depth", 100)
+	viper.Set("load_shedding.percent", 25)
+	viper.Set("load_shedding.retry_after", "3s")
+
+	cfg := LoadLoadSheddingConfig()
+	require.NoError(t, cfg.Validate())
+	assert.False(t, cfg.Shed(99, 0), "below the queue depth")
+	assert.True(t, cfg.Shed(100, 24))
+	assert.False(t, cfg.Shed(100, 25), "only percent of the requests")
+
+	assert.False(t, LoadSheddingConfig{QueueDepth: 1, Percent: 100}.Shed(10, 0), "disabled")
+	assert.ErrorContains(t, LoadSheddingConfig{Enabled: true, QueueDepth: 1, Percent: 101, RetryAfter: time.Second}.Validate(), "percent")
+	assert.ErrorContains(t, LoadSheddingConfig{Enabled: true, Percent: 50, RetryAfter: time.Second}.Validate(), "queue_depth")
+}
+
+func TestCheckLoadShedding(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("load_shedding.enabled", true)
+	viper.Set("load_shedding.queue_depth", 1)
+	viper.Set("load_shedding.percent", 100)
+	viper.Set("load_shedding.retry_after", "3s")
+
+	c := &NATSClient{logger: slog.Default()}
+	_, shed := c.checkLoadShedding("glpat-x")
+	assert.False(t, shed, "no subscription, no queue")
+
+	delay, ok := antalclient.RetryAfter(errors.New(denyMessage(DenyRetryLater, retryLaterText(3*time.Second))))
+	assert.True(t, ok, "clients can parse the delay")
+	assert.Equal(t, 3*time.Second, delay)
+	assert.Zero(t, testutil.ToFloat64(authQueueDepth))
+}
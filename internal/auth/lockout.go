@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// LockoutConfig holds the lockout.* settings.
+type LockoutConfig struct {
+	Enabled bool
+	// MaxFailures consecutive invalid tokens for a username within Window
+	// lock the username out for Duration.
+	MaxFailures int
+	Window      time.Duration
+	Duration    time.Duration
+	Bucket      string
+	Replicas    int
+}
+
+// LoadLockoutConfig reads the lockout.* settings.
+func LoadLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		Enabled:     viper.GetBool("lockout.enabled"),
+		MaxFailures: viper.GetInt("lockout.max_failures"),
+		Window:      viper.GetDuration("lockout.window"),
+		Duration:    viper.GetDuration("lockout.duration"),
+		Bucket:      viper.GetString("lockout.bucket"),
+		Replicas:    viper.GetInt("lockout.replicas"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg LockoutConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.MaxFailures <= 0:
+		return errors.New("lockout: max_failures must be > 0")
+	case cfg.Window <= 0 || cfg.Duration <= 0:
+		return errors.New("lockout: window and duration must be > 0")
+	case cfg.Bucket == "":
+		return errors.New("lockout: bucket is empty")
+	}
+	return nil
+}
+
+// lockoutKeyPattern matches the usernames that can be KV keys, which covers
+// every GitLab username. Other usernames are never locked out.
+var lockoutKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// lockoutKey is the record key of username. GitLab usernames are
+// case-insensitive, so Alice and alice share one failure count.
+func lockoutKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// lockoutRecord is the failure count of one username, stored as JSON in the
+// lockout bucket under the username.
+type lockoutRecord struct {
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LockedUntil  time.Time `json:"locked_until,omitzero"`
+}
+
+// Lockouts counts consecutive invalid tokens per username in a KV bucket
+// shared by the replicas, so a lockout holds whichever replica answers. A
+// KV watcher keeps an in-memory copy: checking a username never costs a KV
+// round trip, only failures do.
+type Lockouts struct {
+	cfg     LockoutConfig
+	kv      nats.KeyValue
+	watcher nats.KeyWatcher
+	logger  *slog.Logger
+
+	mu      sync.RWMutex
+	records map[string]lockoutRecord
+}
+
+// NewLockouts binds to (or creates) the lockout bucket. Entries expire with
+// the bucket's MaxAge, the longer of the window and the lockout duration.
+func NewLockouts(js nats.JetStreamContext, cfg LockoutConfig) (*Lockouts, error) {
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+	kv, created, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal failed auth attempts per username",
+		Replicas:    cfg.Replicas,
+		TTL:         max(cfg.Window, cfg.Duration),
+	})
+	if err != nil {
+		return nil, err
+	}
+	l := newLockouts(cfg, kv, slog.With("component", "lockout"))
+	l.logger.Info("Lockout bucket ready", "bucket", cfg.Bucket, "created", created)
+	return l, nil
+}
+
+func newLockouts(cfg LockoutConfig, kv nats.KeyValue, logger *slog.Logger) *Lockouts {
+	return &Lockouts{cfg: cfg, kv: kv, logger: logger, records: make(map[string]lockoutRecord)}
+}
+
+// Start loads the current records and keeps watching for changes. It
+// returns once the initial records are loaded.
+func (l *Lockouts) Start() error {
+	watcher, err := l.kv.WatchAll()
+	if err != nil {
+		return fmt.Errorf("failed to watch lockouts: %w", err)
+	}
+	l.watcher = watcher
+
+	// The watcher sends a nil entry once all existing values were delivered.
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		l.applyEntry(entry)
+	}
+
+	go func() {
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				l.applyEntry(entry)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the bucket.
+func (l *Lockouts) Stop() {
+	if l.watcher != nil {
+		_ = l.watcher.Stop()
+	}
+}
+
+func (l *Lockouts) applyEntry(entry nats.KeyValueEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Operation() == nats.KeyValueDelete || entry.Operation() == nats.KeyValuePurge {
+		delete(l.records, entry.Key())
+		return
+	}
+	var rec lockoutRecord
+	if err := json.Unmarshal(entry.Value(), &rec); err != nil {
+		l.logger.Warn("Ignoring lockout record", "username", entry.Key(), "revision", entry.Revision(), "error", err)
+		return
+	}
+	l.records[entry.Key()] = rec
+}
+
+// LockedUntil reports whether username is locked out at now, and until when.
+func (l *Lockouts) LockedUntil(username string, now time.Time) (time.Time, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rec, ok := l.records[lockoutKey(username)]
+	if !ok || !now.Before(rec.LockedUntil) {
+		return time.Time{}, false
+	}
+	return rec.LockedUntil, true
+}
+
+// maxLockoutConflicts bounds the retries when replicas update the same
+// record concurrently.
+const maxLockoutConflicts = 5
+
+// Fail counts an invalid token for username. It reports whether this
+// failure locked the username out, and until when.
+func (l *Lockouts) Fail(username string, now time.Time) (time.Time, bool, error) {
+	username = lockoutKey(username)
+	if !lockoutKeyPattern.MatchString(username) {
+		return time.Time{}, false, nil
+	}
+
+	for range maxLockoutConflicts {
+		var rec lockoutRecord
+		var revision uint64
+		entry, err := l.kv.Get(username)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(entry.Value(), &rec); err != nil {
+				l.logger.Warn("Replacing invalid lockout record", "username", username, "error", err)
+				rec = lockoutRecord{}
+			}
+			revision = entry.Revision()
+		case !errors.Is(err, nats.ErrKeyNotFound):
+			return time.Time{}, false, fmt.Errorf("failed to read lockout record: %w", err)
+		}
+
+		locked := rec.fail(l.cfg, now)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if revision == 0 {
+			_, err = l.kv.Create(username, data)
+		} else {
+			_, err = l.kv.Update(username, data, revision)
+		}
+		switch {
+		case err == nil:
+			l.mu.Lock()
+			l.records[username] = rec
+			l.mu.Unlock()
+			return rec.LockedUntil, locked, nil
+		case !errors.Is(err, nats.ErrKeyExists):
+			return time.Time{}, false, fmt.Errorf("failed to write lockout record: %w", err)
+		}
+		// Another replica wrote the record in between; count on top of it.
+	}
+	return time.Time{}, false, fmt.Errorf("failed to write lockout record: %d concurrent updates", maxLockoutConflicts)
+}
+
+// fail counts a failure in rec and reports whether it locked the username
+// out. Failures while locked out are not counted; failures older than the
+// window and ended lockouts start over.
+func (rec *lockoutRecord) fail(cfg LockoutConfig, now time.Time) bool {
+	if now.Before(rec.LockedUntil) {
+		return false
+	}
+	if rec.Failures == 0 || !rec.LockedUntil.IsZero() || now.Sub(rec.FirstFailure) > cfg.Window {
+		*rec = lockoutRecord{FirstFailure: now}
+	}
+	rec.Failures++
+	if rec.Failures < cfg.MaxFailures {
+		return false
+	}
+	rec.LockedUntil = now.Add(cfg.Duration)
+	return true
+}
+
+// Reset forgets the failures of username after a valid token. Users without
+// failures cost nothing.
+func (l *Lockouts) Reset(username string) error {
+	username = lockoutKey(username)
+	l.mu.RLock()
+	_, ok := l.records[username]
+	l.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := l.kv.Delete(username); err != nil {
+		return fmt.Errorf("failed to reset lockout record: %w", err)
+	}
+	l.mu.Lock()
+	delete(l.records, username)
+	l.mu.Unlock()
+	return nil
+}
+
+// initLockout optionally starts counting failed attempts per username.
+func (c *NATSClient) initLockout() error {
+	cfg := LoadLockoutConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	lockouts, err := NewLockouts(js, cfg)
+	if err != nil {
+		return err
+	}
+	if err := lockouts.Start(); err != nil {
+		return err
+	}
+	c.lockouts = lockouts
+	c.logger.Info("Failed-attempt lockout enabled (JetStream KV)", "bucket", cfg.Bucket,
+		"max_failures", cfg.MaxFailures, "window", cfg.Window, "duration", cfg.Duration)
+	return nil
+}
+
+// checkLockout reports whether username is locked out, and until when.
+func (c *NATSClient) checkLockout(username string) (time.Time, bool) {
+	if c.lockouts == nil || username == "" {
+		return time.Time{}, false
+	}
+	until, locked := c.lockouts.LockedUntil(username, time.Now())
+	if locked {
+		lockoutDeniedTotal.Inc()
+		c.logger.Info("Username locked out after failed attempts", "username", username, "until", until)
+	}
+	return until, locked
+}
+
+// recordAuthFailure counts an invalid token for username and raises a
+// warning when it locks the username out.
+func (c *NATSClient) recordAuthFailure(username string) {
+	if c.lockouts == nil || username == "" {
+		return
+	}
+	until, locked, err := c.lockouts.Fail(username, time.Now())
+	if err != nil {
+		c.logger.Warn("Failed to record failed attempt", "username", username, "error", err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	lockoutsTotal.Inc()
+	c.logger.Warn("Username locked out after repeated invalid tokens",
+		"username", username,
+		"max_failures", c.lockouts.cfg.MaxFailures,
+		"window", c.lockouts.cfg.Window,
+		"until", until,
+	)
+	audit("lockout.lock", "ok", "username", username, "until", until)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetUser(sentry.User{Username: username})
+		scope.SetTag("auth_status", "locked_out")
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureMessage("Username locked out after repeated invalid tokens")
+	})
+}
+
+// resetAuthFailures forgets the failures of username after a valid token.
+func (c *NATSClient) resetAuthFailures(username string) {
+	if c.lockouts == nil || username == "" {
+		return
+	}
+	if err := c.lockouts.Reset(username); err != nil {
+		c.logger.Warn("Failed to reset failed attempts", "username", username, "error", err)
+	}
+}
+
+// resetOwnerFailures forgets the failures of the token owner once a request
+// with a valid token passed the owner check (code is the deny code of
+// connectUsername). The username the client sent is never reset: anybody
+// could otherwise lift somebody else's lockout by connecting with their
+// username and a valid token of their own.
+func (c *NATSClient) resetOwnerFailures(result AuthorizeResult, code DenyCode) {
+	if !result.Allow || code != "" {
+		return
+	}
+	c.resetAuthFailures(result.Username())
+}
+
+// lockedOutText is the deny message of locked out usernames.
+func lockedOutText(until time.Time) string {
+	return "too many failed attempts, locked until " + until.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLockoutConfig() LockoutConfig {
+	return LockoutConfig{Enabled: true, MaxFailures: 3, Window: 10 * time.Minute, Duration: 15 * time.Minute, Bucket: "antal_lockouts"}
+}
+
+func TestLockoutConfig_Validate(t *testing.T) {
+	assert.NoError(t, LockoutConfig{}.Validate(), "disabled")
+	assert.NoError(t, testLockoutConfig().Validate())
+
+	cfg := testLockoutConfig()
+	cfg.MaxFailures = 0
+	assert.Error(t, cfg.Validate())
+	cfg = testLockoutConfig()
+	cfg.Duration = 0
+	assert.Error(t, cfg.Validate())
+	cfg = testLockoutConfig()
+	cfg.Bucket = ""
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLockouts_LocksAfterConsecutiveFailures(t *testing.T) {
+	kv := newRevisionKV()
+	l := newLockouts(testLockoutConfig(), kv, slog.Default())
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range 2 {
+		_, locked, err := l.Fail("alice", now.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+		assert.False(t, locked)
+	}
+	_, locked := l.LockedUntil("alice", now.Add(2*time.Minute))
+	assert.False(t, locked)
+
+	until, locked, err := l.Fail("alice", now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, locked)
+	assert.Equal(t, now.Add(17*time.Minute), until)
+
+	got, locked := l.LockedUntil("alice", now.Add(12*time.Minute))
+	assert.True(t, locked, "the lockout outlasts the window")
+	assert.Equal(t, until, got)
+	_, locked = l.LockedUntil("bob", now.Add(3*time.Minute))
+	assert.False(t, locked)
+
+	_, locked, err = l.Fail("alice", now.Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, locked, "failures while locked out do not lock again")
+
+	_, locked = l.LockedUntil("alice", until)
+	assert.False(t, locked, "the lockout ends")
+	_, locked, err = l.Fail("alice", until)
+	require.NoError(t, err)
+	assert.False(t, locked, "an ended lockout starts over")
+
+	var rec lockoutRecord
+	entry, err := kv.Get("alice")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(entry.Value(), &rec))
+	assert.Equal(t, 1, rec.Failures)
+	assert.True(t, rec.LockedUntil.IsZero())
+}
+
+func TestLockouts_WindowAndReset(t *testing.T) {
+	kv := newRevisionKV()
+	l := newLockouts(testLockoutConfig(), kv, slog.Default())
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	_, _, _ = l.Fail("alice", now)
+	_, _, _ = l.Fail("alice", now.Add(time.Minute))
+	_, locked, err := l.Fail("alice", now.Add(11*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, locked, "failures older than the window start over")
+
+	require.NoError(t, l.Reset("alice"))
+	_, err = kv.Get("alice")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+	require.NoError(t, l.Reset("bob"), "users without failures are left alone")
+
+	_, locked, err = l.Fail("alice", now.Add(12*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, locked, "a valid token resets the count")
+}
+
+func TestLockouts_IgnoresCase(t *testing.T) {
+	kv := newRevisionKV()
+	l := newLockouts(testLockoutConfig(), kv, slog.Default())
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	_, _, _ = l.Fail("Alice", now)
+	_, _, _ = l.Fail("ALICE", now.Add(time.Minute))
+	_, locked, err := l.Fail("alice", now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, locked, "every spelling counts towards one record")
+	assert.Contains(t, kv.data, "alice")
+
+	_, locked = l.LockedUntil("aLiCe", now.Add(3*time.Minute))
+	assert.True(t, locked)
+	require.NoError(t, l.Reset("Alice"))
+	assert.NotContains(t, kv.data, "alice")
+}
+
+func TestLockouts_IgnoresInvalidKeys(t *testing.T) {
+	kv := newRevisionKV()
+	l := newLockouts(testLockoutConfig(), kv, slog.Default())
+	for _, username := range []string{"", "alice smith", "a*", "alice.", ".alice", "a>b"} {
+		_, locked, err := l.Fail(username, time.Now())
+		require.NoError(t, err)
+		assert.False(t, locked)
+	}
+	assert.Empty(t, kv.data)
+	assert.True(t, lockoutKeyPattern.MatchString("john.doe-2_x"))
+}
+
+// racingKV writes a competing update before the first read is answered, as
+// another replica would.
+type racingKV struct {
+	*revisionKV
+	raced bool
+}
+
+func (r *racingKV) Get(key string) (nats.KeyValueEntry, error) {
+	if !r.raced {
+		r.raced = true
+		data, _ := json.Marshal(lockoutRecord{Failures: 2, FirstFailure: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)})
+		_, _ = r.revisionKV.Put(key, data)
+		entry, _ := r.revisionKV.Get(key)
+		_, _ = r.revisionKV.Put(key, data)
+		return entry, nil
+	}
+	return r.revisionKV.Get(key)
+}
+
+func TestLockouts_RetriesConcurrentUpdates(t *testing.T) {
+	kv := &racingKV{revisionKV: newRevisionKV()}
+	l := newLockouts(testLockoutConfig(), kv, slog.Default())
+
+	_, locked, err := l.Fail("alice", time.Date(2025, 6, 1, 12, 1, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, locked, "the failure is counted on top of the other replica's")
+}
+
+func TestCheckLockout(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	c.recordAuthFailure("alice")
+	c.resetAuthFailures("alice")
+	_, locked := c.checkLockout("alice")
+	assert.False(t, locked, "disabled")
+
+	cfg := testLockoutConfig()
+	cfg.MaxFailures = 1
+	c.lockouts = newLockouts(cfg, newRevisionKV(), slog.Default())
+	c.recordAuthFailure("")
+	_, locked = c.checkLockout("")
+	assert.False(t, locked, "requests without a username are not counted")
+
+	c.recordAuthFailure("alice")
+	until, locked := c.checkLockout("alice")
+	assert.True(t, locked)
+	assert.Contains(t, lockedOutText(until), "too many failed attempts, locked until ")
+}
+
+func TestResetOwnerFailures(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	cfg := testLockoutConfig()
+	cfg.MaxFailures = 2
+	bob := AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "bob"}}
+
+	for _, requireOwner := range []bool{true, false} {
+		viper.Set("auth.require_token_owner", requireOwner)
+		c := &NATSClient{logger: slog.Default(), lockouts: newLockouts(cfg, newRevisionKV(), slog.Default())}
+		c.recordAuthFailure("alice")
+
+		// bob connects as alice with his own valid token.
+		_, code, _ := connectUsername("alice", bob)
+		c.resetOwnerFailures(bob, code)
+		c.recordAuthFailure("alice")
+		_, locked := c.checkLockout("alice")
+		assert.True(t, locked, "a valid token of another user does not clear alice's failures (require_token_owner %v)", requireOwner)
+	}
+
+	c := &NATSClient{logger: slog.Default(), lockouts: newLockouts(cfg, newRevisionKV(), slog.Default())}
+	c.recordAuthFailure("bob")
+	c.resetOwnerFailures(bob, "")
+	c.recordAuthFailure("bob")
+	_, locked := c.checkLockout("bob")
+	assert.False(t, locked, "the owner's failures are cleared")
+}
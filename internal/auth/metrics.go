@@ -0,0 +1,579 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "gcs_antal"
+
+var (
+	// gitlabErrorsTotal counts failed GitLab API calls by error class.
+	gitlabErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "errors_total",
+		Help:      "Failed GitLab API calls by error class (unauthorized, forbidden, rate_limited, server_error, client_error, dns, tls, timeout, network, other).",
+	}, []string{"class"})
+
+	// gitlabRateLimitPausesTotal counts how often GitLab verification was paused after a 429.
+	gitlabRateLimitPausesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "rate_limit_pauses_total",
+		Help:      "Number of times GitLab verification was paused after a 429 Too Many Requests response.",
+	})
+
+	// gitlabBreakerState is the state of the GitLab circuit breaker.
+	gitlabBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the GitLab circuit breaker: 0 closed, 1 open, 2 half-open.",
+	})
+
+	// gitlabBreakerOpensTotal counts how often the GitLab circuit breaker opened.
+	gitlabBreakerOpensTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "circuit_breaker_opens_total",
+		Help:      "Number of times the GitLab circuit breaker opened after consecutive failed verifications.",
+	})
+
+	// gitlabShadowResultsTotal counts shadow GitLab verifications by result.
+	gitlabShadowResultsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "shadow_results_total",
+		Help:      "Shadow GitLab verifications compared with the primary decision, by result (match, mismatch, error, skipped).",
+	}, []string{"result"})
+
+	// monitorOnlyMode is 1 while monitor-only (allow-all) mode is active.
+	monitorOnlyMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "monitor_only_mode",
+		Help:      "1 when monitor-only (allow-all) mode is active, 0 otherwise.",
+	})
+
+	// monitorOnlyOverridesTotal counts denials turned into allows by monitor-only mode.
+	monitorOnlyOverridesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "monitor_only_overrides_total",
+		Help:      "Authorization requests that would have been denied but were allowed by monitor-only mode, by deny code.",
+	}, []string{"code"})
+
+	// tokenCacheWriteQueueDepth is the number of token cache writes waiting for the background writer.
+	tokenCacheWriteQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "write_queue_depth",
+		Help:      "Token cache writes waiting in the background write queue.",
+	})
+
+	// tokenCacheWritesDroppedTotal counts writes dropped because the queue was full.
+	tokenCacheWritesDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "writes_dropped_total",
+		Help:      "Token cache writes dropped because the background write queue was full.",
+	})
+
+	// tokenCacheWriteErrorsTotal counts failed background token cache writes.
+	tokenCacheWriteErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "write_errors_total",
+		Help:      "Background token cache writes that failed.",
+	})
+
+	// outdatedServerRequestsTotal counts auth requests of servers older than nats.server_compat.
+	outdatedServerRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "outdated_server_requests_total",
+		Help:      "Auth callout requests from NATS servers older than nats.server_compat, by server version.",
+	}, []string{"version"})
+
+	// permissionTemplateErrorsTotal counts permission templates that failed
+	// to render, by template hash (see templateHash).
+	permissionTemplateErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "permissions",
+		Name:      "template_errors_total",
+		Help:      "Permission templates that failed to render and were issued raw, by template hash.",
+	}, []string{"template"})
+
+	// policyHookEvaluationsTotal counts policy module evaluations by result.
+	policyHookEvaluationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "policy_hook",
+		Name:      "evaluations_total",
+		Help:      "Evaluations of the WebAssembly policy module, by result (ok, error).",
+	}, []string{"result"})
+
+	// policyHookDuration is the time one policy module evaluation takes.
+	policyHookDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "policy_hook",
+		Name:      "duration_seconds",
+		Help:      "Duration of WebAssembly policy module evaluations, instantiation included.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1},
+	})
+
+	// cacheFallbackRatio is the share of verifications the token cache answered instead of GitLab.
+	cacheFallbackRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "fallback_ratio",
+		Help:      "Share of successful token verifications answered from the token cache instead of GitLab over token_cache.fallback_alert.window.",
+	})
+
+	// cacheFallbackDegraded is 1 while the fallback share is above the threshold.
+	cacheFallbackDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "fallback_degraded",
+		Help:      "1 while the token cache answers more than token_cache.fallback_alert.threshold of the verifications, 0 otherwise.",
+	})
+
+	// tokenCacheTimeoutsTotal counts token cache KV operations that ran out of time.
+	tokenCacheTimeoutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "timeouts_total",
+		Help:      "Token cache KV operations (get, put) that ran out of time: token_cache.get_timeout/put_timeout or the auth request's deadline.",
+	}, []string{"op"})
+
+	// tokenCacheHedgedGetsTotal counts hedged token cache reads by the read that answered.
+	tokenCacheHedgedGetsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "hedged_gets_total",
+		Help:      "Token cache reads that sent a second read after token_cache.hedge_delay, by the read that answered (primary, hedge, none).",
+	}, []string{"winner"})
+
+	// kvRetriesTotal counts KV operations retried after a transient error.
+	kvRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "kv",
+		Name:      "retries_total",
+		Help:      "JetStream KV operations retried after no JetStream server answered, by bucket and result (recovered, exhausted).",
+	}, []string{"bucket", "result"})
+
+	// excessiveScopesTotal counts tokens carrying scopes rejected by the scope policy.
+	excessiveScopesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "excessive_scopes_total",
+		Help:      "Authorized tokens carrying forbidden or not allowed scopes, by scope and scope policy mode (warn, enforce).",
+	}, []string{"scope", "mode"})
+
+	// authRequestAge observes how old auth requests are when processing starts.
+	authRequestAge = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "request_age_seconds",
+		Help:      "Age of auth callout requests when processing starts (time since the NATS server issued them, whole-second resolution).",
+		Buckets:   []float64{0.5, 1, 2, 3, 5, 10, 30},
+	})
+
+	// authRequestBudget observes the time left to answer auth requests.
+	authRequestBudget = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "request_budget_seconds",
+		Help:      "Time left to answer auth callout requests when processing starts, from the request's expiry or issued-at.",
+		Buckets:   []float64{0.25, 0.5, 1, 1.5, 2, 3, 5},
+	})
+
+	// staleRequestsDroppedTotal counts requests dropped because the server had already timed out.
+	staleRequestsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "stale_requests_dropped_total",
+		Help:      "Auth callout requests dropped unprocessed because they were older than the callout timeout.",
+	})
+
+	// probeUp is 1 when the last canary authentication probe succeeded.
+	probeUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "probe",
+		Name:      "up",
+		Help:      "1 when the last canary authentication probe succeeded, 0 otherwise.",
+	})
+
+	// probeDuration observes the duration of successful canary probes.
+	probeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "probe",
+		Name:      "duration_seconds",
+		Help:      "Duration of successful canary authentication probes (connect, auth callout, flush).",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// probeLastSuccess is the Unix time of the last successful canary probe.
+	probeLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "probe",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful canary authentication probe.",
+	})
+
+	// probeFailuresTotal counts failed canary probes by reason.
+	probeFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "probe",
+		Name:      "failures_total",
+		Help:      "Failed canary authentication probes by reason (authorization, timeout, connection).",
+	}, []string{"reason"})
+
+	// auditEventsExportedTotal counts audit events delivered to external sinks.
+	auditEventsExportedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "audit",
+		Name:      "events_exported_total",
+		Help:      "Audit events delivered to external sinks, by sink.",
+	}, []string{"sink"})
+
+	// auditExportErrorsTotal counts audit events that could not be delivered.
+	auditExportErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "audit",
+		Name:      "export_errors_total",
+		Help:      "Audit events that could not be delivered to external sinks, by sink.",
+	}, []string{"sink"})
+
+	// accessRequestsInvalidTotal counts approved access request issues that could not be parsed.
+	accessRequestsInvalidTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "access_requests",
+		Name:      "invalid_total",
+		Help:      "Approved access request issues skipped because their request was invalid (counted on every sync).",
+	})
+
+	// handlerPanicsTotal counts panics recovered in NATS message handlers.
+	handlerPanicsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "handler_panics_total",
+		Help:      "Panics recovered in NATS message handlers, by handler.",
+	}, []string{"handler"})
+
+	// remoteSignDuration tracks signing requests to the external signer.
+	remoteSignDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "signer",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of signing requests to the external signer.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	// remoteSignErrorsTotal counts failed signing requests to the external signer.
+	remoteSignErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "signer",
+		Name:      "errors_total",
+		Help:      "Signing requests to the external signer that failed or returned an invalid signature.",
+	})
+
+	// userIssuanceRate tracks how many JWTs a user received in the last minute.
+	userIssuanceRate = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "user_jwts_per_minute",
+		Help:      "JWTs issued to the same user in the last minute, observed on every issuance.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100},
+	})
+
+	// rateLimitedTotal counts requests denied by the per-user issuance limit.
+	rateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "rate_limited_total",
+		Help:      "Auth requests denied because the user exceeded auth.max_jwts_per_minute.",
+	})
+
+	// authAttemptsLimitedTotal counts requests denied by the attempt limits.
+	authAttemptsLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "attempts_limited_total",
+		Help:      "Auth requests denied before verification by auth.max_attempts_per_minute (username), auth.max_attempts_per_ip_per_minute (ip) or token_check.max_checks_per_ip_per_minute (token_check).",
+	}, []string{"key"})
+
+	// issuerJWTsIssuedTotal counts the user JWTs signed by each issuer key.
+	issuerJWTsIssuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "issuer",
+		Name:      "jwts_issued_total",
+		Help:      "User JWTs signed, by issuer public key.",
+	}, []string{"issuer"})
+
+	// issuerIssuanceBaseline is the moving average of JWTs per minute per key.
+	issuerIssuanceBaseline = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "issuer",
+		Name:      "jwts_per_minute_baseline",
+		Help:      "Moving average of the user JWTs signed per minute by this replica, by issuer public key (key_usage.enabled).",
+	}, []string{"issuer"})
+
+	// issuerAnomaliesTotal counts key usage anomaly alerts.
+	issuerAnomaliesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "issuer",
+		Name:      "anomalies_total",
+		Help:      "Issuer key usage anomaly alerts, by kind (spike, off_hours).",
+	}, []string{"kind"})
+
+	// passwordsDetectedTotal counts rejected credentials that looked like passwords.
+	passwordsDetectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "passwords_detected_total",
+		Help:      "Rejected credentials that looked like GitLab account passwords rather than tokens, by auth.password_detection mode.",
+	}, []string{"mode"})
+
+	// lockoutsTotal counts usernames locked out after repeated invalid tokens.
+	lockoutsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "lockout",
+		Name:      "lockouts_total",
+		Help:      "Usernames locked out after lockout.max_failures consecutive invalid tokens.",
+	})
+
+	// lockoutDeniedTotal counts requests denied because the username is locked out.
+	lockoutDeniedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "lockout",
+		Name:      "denied_total",
+		Help:      "Auth requests denied because the username was locked out.",
+	})
+
+	// subjectUsageUnusedGrants is the number of subscribe grants never seen in use.
+	subjectUsageUnusedGrants = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "subject_usage",
+		Name:      "unused_grants",
+		Help:      "Subscribe grants that matched no subscription in any sample, as of the last report.",
+	})
+
+	// subjectUsageNarrowerGrants is the number of wildcard grants used only for narrower subjects.
+	subjectUsageNarrowerGrants = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "subject_usage",
+		Name:      "narrower_grants",
+		Help:      "Wildcard subscribe grants only used for more specific subjects, as of the last report.",
+	})
+
+	// subjectUsageSampleErrorsTotal counts failed subscription samples.
+	subjectUsageSampleErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "subject_usage",
+		Name:      "sample_errors_total",
+		Help:      "Subscription samples (CONNZ requests) that failed, fully or for some servers.",
+	})
+
+	// accountConnections is the last fetched connection count of the users' account.
+	accountConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "account_budget",
+		Name:      "connections",
+		Help:      "Client connections of the users' account across all servers, as last fetched.",
+	})
+
+	// accountBudgetExceededTotal counts requests made while the account was at its budget.
+	accountBudgetExceededTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "account_budget",
+		Name:      "exceeded_total",
+		Help:      "Auth requests received while the account was at its connection budget, by mode (warn, enforce).",
+	}, []string{"mode"})
+
+	// jwtCacheLookupsTotal counts issued-JWT cache lookups by result.
+	jwtCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "jwt_cache",
+		Name:      "lookups_total",
+		Help:      "Issued-JWT cache lookups, by result (hit, miss).",
+	}, []string{"result"})
+
+	// tokenCacheMemoryLookupsTotal counts lookups in the in-memory token cache layer.
+	tokenCacheMemoryLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_cache",
+		Name:      "memory_lookups_total",
+		Help:      "Lookups of recently verified tokens in the in-memory cache layer, by result (hit, miss).",
+	}, []string{"result"})
+
+	// replicaKeyMismatch is set once a replica of the same cluster announced
+	// a different key.
+	replicaKeyMismatch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "replica_check",
+		Name:      "key_mismatch",
+		Help:      "1 once another replica of the cluster announced a different key, by key (issuer, xkey); cleared on restart.",
+	}, []string{"key"})
+
+	// trustedServerRequestsTotal counts requests of trusted servers by cluster.
+	trustedServerRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "trusted_servers",
+		Name:      "requests_total",
+		Help:      "Auth requests from trusted servers, by configured cluster name.",
+	}, []string{"cluster"})
+
+	// untrustedServerRequestsTotal counts requests left unanswered because
+	// their server is not trusted.
+	untrustedServerRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "trusted_servers",
+		Name:      "rejected_total",
+		Help:      "Auth requests ignored because the server is not trusted, by reason (unknown_cluster, unknown_server, issuer_mismatch).",
+	}, []string{"reason"})
+
+	// clientTagsDroppedTotal counts client tags ignored by the allow-list.
+	clientTagsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "client_tags",
+		Name:      "dropped_total",
+		Help:      "Tags in client connection names ignored, by reason (not_allowed, invalid_value, duplicate).",
+	}, []string{"reason"})
+
+	// authRequestsTotal counts answered auth requests by outcome and source.
+	authRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "requests_total",
+		Help:      "Answered auth requests, by outcome (allow, deny, error) and source of the token verification (gitlab, cache, memory, none).",
+	}, []string{"outcome", "source"})
+
+	// authRequestsInFlight tracks auth requests being processed.
+	authRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "requests_in_flight",
+		Help:      "Auth requests currently being processed.",
+	})
+
+	// gitlabRequestDuration tracks HTTP requests to GitLab made to verify
+	// tokens, each retry observed on its own.
+	gitlabRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests to GitLab for token verification, by status class (2xx, 3xx, 4xx, 5xx, error).",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"status_class"})
+
+	// gitlabResponsesTotal counts HTTP requests to GitLab by status class.
+	gitlabResponsesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "gitlab",
+		Name:      "responses_total",
+		Help:      "HTTP requests to GitLab for token verification, by status class (2xx, 3xx, 4xx, 5xx, error when no response was received).",
+	}, []string{"status_class"})
+
+	// migrationsSchemaVersion reports the schema version of the KV buckets.
+	migrationsSchemaVersion = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "migrations",
+		Name:      "schema_version",
+		Help:      "Schema version of the KV buckets, by schema (token_cache, user_grants).",
+	}, []string{"schema"})
+
+	// migrationsEntriesTotal counts KV entries rewritten by migrations.
+	migrationsEntriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "migrations",
+		Name:      "entries_total",
+		Help:      "KV entries processed by schema migrations, by schema.",
+	}, []string{"schema"})
+
+	// authQueueDepth tracks the auth requests waiting in the subscription.
+	authQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "queue_depth",
+		Help:      "Auth requests waiting in the subscription, sampled when a request is processed.",
+	})
+
+	// loadShedRequestsTotal counts requests denied with retry_later.
+	loadShedRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "load_shedding",
+		Name:      "requests_total",
+		Help:      "Auth requests denied with retry_later because the queue was too deep.",
+	})
+
+	// configHashes is the number of distinct config hashes of live replicas.
+	configHashes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "config",
+		Name:      "hashes",
+		Help:      "Distinct config file hashes published by the live replicas (1 when all agree).",
+	})
+
+	// configStaleReplicas is the number of replicas running a stale config
+	// for longer than the grace period.
+	configStaleReplicas = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "config",
+		Name:      "stale_replicas",
+		Help:      "Replicas running another config than the most recently applied one for longer than the grace period.",
+	})
+
+	// configReloadsTotal counts config file reloads by result.
+	configReloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "config",
+		Name:      "reloads_total",
+		Help:      "Config file reloads, by result (applied, rejected, failed).",
+	}, []string{"result"})
+
+	// tokenChecksTotal counts token check requests by outcome.
+	tokenChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "token_check",
+		Name:      "requests_total",
+		Help:      "Token check requests, by outcome (valid, denied, invalid, error, limited).",
+	}, []string{"outcome"})
+
+	// authSubscriptionActive is 1 while the auth callout subscription is active.
+	authSubscriptionActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "subscription_active",
+		Help:      "Whether the auth callout subscription is active (1) or not (0), apart from the connection state.",
+	})
+
+	// authLastRequestTimestamp is when the last auth request was received.
+	authLastRequestTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "auth",
+		Name:      "last_request_timestamp_seconds",
+		Help:      "Unix time of the last auth request received on the auth callout subscription.",
+	})
+
+	// traceOverrideActive is 1 while every auth request of this replica is traced.
+	traceOverrideActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sentry",
+		Name:      "trace_override_active",
+		Help:      "Whether every auth request of this replica is traced because of a trace flag (1) or not (0).",
+	})
+
+	// standbyActive is 1 while this replica holds the active lease.
+	standbyActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "standby",
+		Name:      "active",
+		Help:      "Whether this replica holds the active lease in standby mode (1) or not (0).",
+	})
+
+	// standbyPromotionsTotal counts takeovers by this replica.
+	standbyPromotionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "standby",
+		Name:      "promotions_total",
+		Help:      "Times this replica was promoted to active in standby mode.",
+	})
+)
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// MigrationsConfig holds the migrations.* settings.
+type MigrationsConfig struct {
+	Enabled  bool
+	Bucket   string
+	Replicas int
+	// LockTTL is how long a replica may hold the migration lock before
+	// another replica may take it over.
+	LockTTL time.Duration
+	// Wait is how long a replica waits for another one to finish migrating.
+	Wait time.Duration
+}
+
+// LoadMigrationsConfig reads the migrations.* settings.
+func LoadMigrationsConfig() MigrationsConfig {
+	return MigrationsConfig{
+		Enabled:  viper.GetBool("migrations.enabled"),
+		Bucket:   viper.GetString("migrations.bucket"),
+		Replicas: viper.GetInt("migrations.replicas"),
+		LockTTL:  viper.GetDuration("migrations.lock_ttl"),
+		Wait:     viper.GetDuration("migrations.wait"),
+	}
+}
+
+// kvMigration upgrades the entries of a bucket by one schema version.
+type kvMigration struct {
+	Description string
+	// Entry returns the upgraded value of an entry, or nil to delete it.
+	Entry func(key string, value []byte) ([]byte, error)
+}
+
+// kvSchema describes the entry format of a KV bucket. Entries written
+// before buckets were versioned are version 1; Migrations[i] upgrades
+// entries from version i+1 to i+2.
+type kvSchema struct {
+	Name       string
+	Migrations []kvMigration
+}
+
+// Version is the schema version this build reads and writes.
+func (s kvSchema) Version() int { return len(s.Migrations) + 1 }
+
+var (
+	tokenCacheSchema = kvSchema{Name: "token_cache"}
+	userGrantsSchema = kvSchema{Name: "user_grants"}
+)
+
+// errSchemaTooNew is returned when a bucket was migrated by a newer build,
+// whose entries this build could misread or overwrite.
+var errSchemaTooNew = errors.New("bucket schema is newer than this build supports")
+
+// schemaVersion is the value stored per bucket in the migrations bucket.
+type schemaVersion struct {
+	Version    int       `json:"version"`
+	Instance   string    `json:"instance"`
+	MigratedAt time.Time `json:"migrated_at"`
+}
+
+// migrationLock is the value of the key electing the migrating replica.
+type migrationLock struct {
+	Instance  string    `json:"instance"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// kvMigrator versions KV buckets and runs their migrations. When several
+// replicas start at once, the one holding the bucket's lock migrates and the
+// others wait for it to finish.
+type kvMigrator struct {
+	meta     nats.KeyValue
+	instance string
+	lockTTL  time.Duration
+	wait     time.Duration
+	poll     time.Duration
+	now      func() time.Time
+	logger   *slog.Logger
+}
+
+// newKVMigrator binds to (or creates) the migrations bucket.
+func newKVMigrator(js nats.JetStreamContext, cfg MigrationsConfig, instance string) (*kvMigrator, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("migrations.bucket is empty")
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+	meta, _, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal KV schema versions",
+		Replicas:    cfg.Replicas,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kvMigrator{
+		meta:     meta,
+		instance: instance,
+		lockTTL:  cfg.LockTTL,
+		wait:     cfg.Wait,
+		poll:     time.Second,
+		now:      time.Now,
+		logger:   slog.With("component", "migrations"),
+	}, nil
+}
+
+func schemaKey(schema kvSchema, bucket string) string {
+	return "schema." + schema.Name + "." + bucket
+}
+
+func lockKey(schema kvSchema, bucket string) string {
+	return "lock." + schema.Name + "." + bucket
+}
+
+// Ensure brings bucket up to the schema version of this build. It fails
+// when the bucket was migrated by a newer build, or when another replica
+// holds the lock for longer than the configured wait.
+func (m *kvMigrator) Ensure(schema kvSchema, bucket string, kv nats.KeyValue) error {
+	deadline := m.now().Add(m.wait)
+	for {
+		version, err := m.version(schema, bucket)
+		if err != nil {
+			return err
+		}
+		migrationsSchemaVersion.WithLabelValues(schema.Name).Set(float64(version))
+		switch {
+		case version > schema.Version():
+			return fmt.Errorf("bucket %q is at %s schema version %d, this build supports up to %d: %w",
+				bucket, schema.Name, version, schema.Version(), errSchemaTooNew)
+		case version == schema.Version():
+			return nil
+		}
+
+		rev, acquired, err := m.lock(schema, bucket)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			err := m.migrate(schema, bucket, kv)
+			m.unlock(schema, bucket, rev)
+			return err
+		}
+
+		if !m.now().Before(deadline) {
+			return fmt.Errorf("timed out waiting for another replica to migrate bucket %q", bucket)
+		}
+		m.logger.Info("Waiting for another replica to migrate bucket", "bucket", bucket, "schema", schema.Name)
+		time.Sleep(m.poll)
+	}
+}
+
+// version returns the recorded schema version of bucket; buckets without a
+// record are version 1.
+func (m *kvMigrator) version(schema kvSchema, bucket string) (int, error) {
+	entry, err := m.meta.Get(schemaKey(schema, bucket))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version of bucket %q: %w", bucket, err)
+	}
+	var v schemaVersion
+	if err := json.Unmarshal(entry.Value(), &v); err != nil {
+		return 0, fmt.Errorf("invalid schema version of bucket %q: %w", bucket, err)
+	}
+	return v.Version, nil
+}
+
+// lock tries to take the migration lock of bucket, taking over a lock whose
+// holder did not release it in time.
+func (m *kvMigrator) lock(schema kvSchema, bucket string) (uint64, bool, error) {
+	key := lockKey(schema, bucket)
+	data, err := json.Marshal(migrationLock{Instance: m.instance, ExpiresAt: m.now().Add(m.lockTTL).UTC()})
+	if err != nil {
+		return 0, false, err
+	}
+
+	rev, err := m.meta.Create(key, data)
+	if err == nil {
+		return rev, true, nil
+	}
+	if !errors.Is(err, nats.ErrKeyExists) {
+		return 0, false, fmt.Errorf("failed to take migration lock of bucket %q: %w", bucket, err)
+	}
+
+	entry, err := m.meta.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return 0, false, nil // released meanwhile; retry on the next round
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read migration lock of bucket %q: %w", bucket, err)
+	}
+	var held migrationLock
+	if json.Unmarshal(entry.Value(), &held) == nil && m.now().Before(held.ExpiresAt) {
+		return 0, false, nil
+	}
+	rev, err = m.meta.Update(key, data, entry.Revision())
+	if err != nil {
+		return 0, false, nil // another replica took it over first
+	}
+	m.logger.Warn("Took over expired migration lock", "bucket", bucket, "previous_holder", held.Instance)
+	return rev, true, nil
+}
+
+func (m *kvMigrator) unlock(schema kvSchema, bucket string, rev uint64) {
+	if err := m.meta.Delete(lockKey(schema, bucket), nats.LastRevision(rev)); err != nil {
+		m.logger.Warn("Failed to release migration lock", "bucket", bucket, "error", err)
+	}
+}
+
+// migrate runs the pending migrations of bucket and records the new version.
+// The version is re-read under the lock, as another replica may have
+// finished since it was last checked.
+func (m *kvMigrator) migrate(schema kvSchema, bucket string, kv nats.KeyValue) error {
+	from, err := m.version(schema, bucket)
+	if err != nil {
+		return err
+	}
+	for version := from; version < schema.Version(); version++ {
+		migration := schema.Migrations[version-1]
+		m.logger.Info("Migrating bucket", "bucket", bucket, "schema", schema.Name,
+			"from", version, "to", version+1, "description", migration.Description)
+		if err := m.migrateEntries(schema, bucket, kv, migration); err != nil {
+			return fmt.Errorf("failed to migrate bucket %q to %s schema version %d: %w", bucket, schema.Name, version+1, err)
+		}
+	}
+
+	data, err := json.Marshal(schemaVersion{Version: schema.Version(), Instance: m.instance, MigratedAt: m.now().UTC()})
+	if err != nil {
+		return err
+	}
+	if _, err := m.meta.Put(schemaKey(schema, bucket), data); err != nil {
+		return fmt.Errorf("failed to record schema version of bucket %q: %w", bucket, err)
+	}
+	migrationsSchemaVersion.WithLabelValues(schema.Name).Set(float64(schema.Version()))
+	m.logger.Info("Bucket schema up to date", "bucket", bucket, "schema", schema.Name, "version", schema.Version())
+	return nil
+}
+
+// migrateEntries rewrites every entry of bucket. Entries are only replaced
+// at the revision they were read at, so a concurrent write is re-read and
+// migrated again rather than overwritten.
+func (m *kvMigrator) migrateEntries(schema kvSchema, bucket string, kv nats.KeyValue, migration kvMigration) error {
+	keys, err := kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := m.migrateEntry(kv, key, migration); err != nil {
+			return fmt.Errorf("entry %q: %w", key, err)
+		}
+		migrationsEntriesTotal.WithLabelValues(schema.Name).Inc()
+	}
+	return nil
+}
+
+func (m *kvMigrator) migrateEntry(kv nats.KeyValue, key string, migration kvMigration) error {
+	const attempts = 3
+	var err error
+	for range attempts {
+		var entry nats.KeyValueEntry
+		entry, err = kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil // expired or deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		var value []byte
+		value, err = migration.Entry(key, entry.Value())
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			err = kv.Delete(key, nats.LastRevision(entry.Revision()))
+		} else {
+			_, err = kv.Update(key, value, entry.Revision())
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// migrateKV brings a bucket up to the schema of this build when migrations
+// are enabled.
+func (c *NATSClient) migrateKV(js nats.JetStreamContext, schema kvSchema, bucket string, kv nats.KeyValue) error {
+	cfg := LoadMigrationsConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	migrator, err := newKVMigrator(js, cfg, c.instanceID)
+	if err != nil {
+		return err
+	}
+	return migrator.Ensure(schema, bucket, kv)
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// revisionKV is an in-memory KV bucket with revisions, for compare-and-set.
+type revisionKV struct {
+	nats.KeyValue
+	data map[string][]byte
+	revs map[string]uint64
+	rev  uint64
+}
+
+type revisionKVEntry struct {
+	nats.KeyValueEntry
+	value []byte
+	rev   uint64
+}
+
+func (e revisionKVEntry) Value() []byte    { return e.value }
+func (e revisionKVEntry) Revision() uint64 { return e.rev }
+
+func newRevisionKV() *revisionKV {
+	return &revisionKV{data: map[string][]byte{}, revs: map[string]uint64{}}
+}
+
+func (m *revisionKV) Keys(...nats.WatchOpt) ([]string, error) {
+	if len(m.data) == 0 {
+		return nil, nats.ErrNoKeysFound
+	}
+	return slices.Sorted(maps.Keys(m.data)), nil
+}
+
+func (m *revisionKV) Get(key string) (nats.KeyValueEntry, error) {
+	value, ok := m.data[key]
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return revisionKVEntry{value: value, rev: m.revs[key]}, nil
+}
+
+func (m *revisionKV) Put(key string, value []byte) (uint64, error) {
+	m.rev++
+	m.data[key], m.revs[key] = value, m.rev
+	return m.rev, nil
+}
+
+func (m *revisionKV) Create(key string, value []byte) (uint64, error) {
+	if _, ok := m.data[key]; ok {
+		return 0, nats.ErrKeyExists
+	}
+	return m.Put(key, value)
+}
+
+func (m *revisionKV) Update(key string, value []byte, last uint64) (uint64, error) {
+	if m.revs[key] != last {
+		return 0, nats.ErrKeyExists
+	}
+	return m.Put(key, value)
+}
+
+func (m *revisionKV) Delete(key string, _ ...nats.DeleteOpt) error {
+	delete(m.data, key)
+	delete(m.revs, key)
+	return nil
+}
+
+func (m *revisionKV) Purge(key string, opts ...nats.DeleteOpt) error {
+	return m.Delete(key, opts...)
+}
+
+func newTestMigrator(meta nats.KeyValue, instance string, now time.Time) *kvMigrator {
+	return &kvMigrator{meta: meta, instance: instance, lockTTL: time.Minute, poll: time.Millisecond,
+		now: func() time.Time { return now }, logger: slog.Default()}
+}
+
+func TestKVMigrator_Migrates(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	meta, kv := newRevisionKV(), newRevisionKV()
+	_, _ = kv.Put("alice", []byte("v1:alice"))
+	_, _ = kv.Put("stale", []byte("v1:stale"))
+
+	schema := kvSchema{Name: "test", Migrations: []kvMigration{
+		{Description: "prefix v2", Entry: func(_ string, value []byte) ([]byte, error) {
+			return bytes.Replace(value, []byte("v1:"), []byte("v2:"), 1), nil
+		}},
+		{Description: "drop stale", Entry: func(key string, value []byte) ([]byte, error) {
+			if key == "stale" {
+				return nil, nil
+			}
+			return bytes.Replace(value, []byte("v2:"), []byte("v3:"), 1), nil
+		}},
+	}}
+
+	m := newTestMigrator(meta, "replica-a", now)
+	require.NoError(t, m.Ensure(schema, "bucket", kv))
+	assert.Equal(t, map[string][]byte{"alice": []byte("v3:alice")}, kv.data)
+
+	var recorded schemaVersion
+	require.NoError(t, json.Unmarshal(meta.data["schema.test.bucket"], &recorded))
+	assert.Equal(t, schemaVersion{Version: 3, Instance: "replica-a", MigratedAt: now}, recorded)
+	assert.NotContains(t, meta.data, "lock.test.bucket", "the lock is released")
+
+	require.NoError(t, m.Ensure(schema, "bucket", kv), "already up to date")
+	assert.Equal(t, map[string][]byte{"alice": []byte("v3:alice")}, kv.data)
+
+	err := m.Ensure(kvSchema{Name: "test"}, "bucket", kv)
+	assert.ErrorIs(t, err, errSchemaTooNew, "an older build refuses to start")
+}
+
+func TestKVMigrator_Lock(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	meta, kv := newRevisionKV(), newRevisionKV()
+	schema := kvSchema{Name: "test", Migrations: []kvMigration{{Entry: func(_ string, v []byte) ([]byte, error) { return v, nil }}}}
+
+	holder := newTestMigrator(meta, "replica-a", now)
+	_, acquired, err := holder.lock(schema, "bucket")
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	waiter := newTestMigrator(meta, "replica-b", now)
+	_, acquired, err = waiter.lock(schema, "bucket")
+	require.NoError(t, err)
+	assert.False(t, acquired, "held by replica-a")
+	assert.ErrorContains(t, waiter.Ensure(schema, "bucket", kv), "timed out waiting")
+
+	waiter.now = func() time.Time { return now.Add(2 * time.Minute) }
+	require.NoError(t, waiter.Ensure(schema, "bucket", kv), "an expired lock is taken over")
+	version, err := waiter.version(schema, "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+}
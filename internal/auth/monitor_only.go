@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"log/slog"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// monitorOnlyEnabled reports whether monitor-only (allow-all) mode is active.
+// In this mode every request is still verified and logged, but requests that
+// would be denied are allowed with the configured permissions. It exists for
+// migrating clusters from static credentials to auth callout without risking
+// lockouts, and must never stay enabled in normal operation.
+//
+// The flag is read on every request so it can be flipped at runtime.
+func monitorOnlyEnabled() bool {
+	enabled := viper.GetBool("auth.monitor_only")
+	if enabled {
+		monitorOnlyMode.Set(1)
+	} else {
+		monitorOnlyMode.Set(0)
+	}
+	return enabled
+}
+
+// warnMonitorOnly emits the loud startup warning for monitor-only mode.
+func warnMonitorOnly(logger *slog.Logger) {
+	logger.Warn("!!! MONITOR-ONLY MODE ENABLED: authentication failures are logged but NOT enforced; every client is allowed !!!",
+		"setting", "auth.monitor_only")
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("auth_mode", "monitor_only")
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureMessage("GCS Antal running in monitor-only mode")
+	})
+}
+
+// monitorOnlyOverride decides whether a would-be denial is turned into an
+// allow because monitor-only mode is active. Overrides are always logged and counted.
+func (c *NATSClient) monitorOnlyOverride(username string, code DenyCode) bool {
+	if !monitorOnlyEnabled() {
+		return false
+	}
+
+	c.logger.Warn("Monitor-only mode: allowing request that would have been denied",
+		"username", username,
+		"deny_code", code,
+	)
+	monitorOnlyOverridesTotal.WithLabelValues(string(code)).Inc()
+	return true
+}
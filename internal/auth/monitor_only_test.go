@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorOnlyOverride(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	c := &NATSClient{logger: slog.Default()}
+	counter := monitorOnlyOverridesTotal.WithLabelValues(string(DenyInvalidCredentials))
+
+	t.Run("disabled by default", func(t *testing.T) {
+		before := testutil.ToFloat64(counter)
+		assert.False(t, c.monitorOnlyOverride("alice", DenyInvalidCredentials))
+		assert.Equal(t, before, testutil.ToFloat64(counter))
+		assert.Equal(t, float64(0), testutil.ToFloat64(monitorOnlyMode))
+	})
+
+	t.Run("enabled overrides and counts denials", func(t *testing.T) {
+		viper.Set("auth.monitor_only", true)
+
+		before := testutil.ToFloat64(counter)
+		assert.True(t, c.monitorOnlyOverride("alice", DenyInvalidCredentials))
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+		assert.Equal(t, float64(1), testutil.ToFloat64(monitorOnlyMode))
+	})
+}
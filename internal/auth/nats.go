@@ -1,29 +1,136 @@
 package auth
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
 	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+	"git.sgw.equipment/restricted/gcs_antal/pkg/callout"
 )
 
 // NATSClient handles NATS authentication requests
 type NATSClient struct {
-	nc            *nats.Conn
-	issuerKeyPair nkeys.KeyPair
-	xKeyPair      nkeys.KeyPair // May be nil if not using encryption
-	gitlabClient  *GitLabClient
-	tokenCache    TokenCache
-	logger        *slog.Logger
+	nc *nats.Conn
+	// issuerSigner signs user JWTs and responses; it can be replaced at
+	// runtime by RotateIssuer, so access it through issuer().
+	issuerMu     sync.RWMutex
+	issuerSigner Signer
+	xKeyPair     nkeys.KeyPair // May be nil if not using encryption
+	gitlabClient *GitLabClient
+	tokenCache   TokenCache
+	logger       *slog.Logger
+
+	// issuance counts issued JWTs per user for auth.max_jwts_per_minute.
+	issuance *issuanceLimiter
+	// attempts counts auth requests per username and client IP for
+	// auth.max_attempts_*.
+	attempts *issuanceLimiter
+
+	// platform is the connection for coordination subjects (antal.internal.>);
+	// nil unless platform credentials are configured.
+	platform *nats.Conn
+	// kvConn is the connection for JetStream KV traffic; nil unless
+	// nats.kv_connection is dedicated.
+	kvConn *nats.Conn
+
+	// userGrants and accessRequests are nil unless self-service access
+	// requests are enabled.
+	userGrants     *UserGrants
+	accessRequests *AccessRequestSync
+
+	// subjectUsage is nil unless subject usage sampling is enabled.
+	subjectUsage *SubjectUsage
+
+	// accountBudget is nil unless account_budget.mode is warn or enforce.
+	accountBudget *AccountBudget
+
+	// keyUsage is nil unless issuer key usage anomaly alerts are enabled.
+	keyUsage *KeyUsage
+	// serverCompat is the oldest nats-server release line of the cluster.
+	serverCompat    serverCompat
+	outdatedServers serverVersions
+	// policyHook is nil unless a WebAssembly policy module is configured.
+	policyHook *PolicyHook
+	// cacheFallback is nil unless the token cache fallback alert is enabled.
+	cacheFallback *CacheFallbackMonitor
+
+	// lockouts is nil unless the failed-attempt lockout is enabled.
+	lockouts *Lockouts
+
+	// gitlabShadow is nil unless tokens are also verified with a shadow GitLab.
+	gitlabShadow *ShadowVerifier
+
+	// configOverrides is nil unless KV config overrides are enabled.
+	configOverrides *ConfigOverrides
+
+	// jwtCache is nil unless issued JWTs are cached for reconnects.
+	jwtCache *jwtCache
+
+	// issuedGrants is nil unless permission probes (can_i) are enabled.
+	issuedGrants *issuedGrants
+
+	// clientTagPolicy is nil unless client tags are allowed.
+	clientTagPolicy *clientTagPolicy
+
+	// trustedServers is nil unless only listed servers are answered.
+	trustedServers *trustedServers
+
+	// authSub is the auth callout subscription, whose pending requests
+	// drive load shedding; nil while not subscribed (e.g. on standby).
+	authSub atomic.Pointer[nats.Subscription]
+	// subHealth tracks the auth subscription for readiness.
+	subHealth authSubHealth
+
+	// instanceID and startedAt identify this replica in instance
+	// announcements.
+	instanceID string
+	startedAt  time.Time
+
+	// reloadMu guards permissions, reservedPrefixes, accountSubjects and
+	// denyTemplates, which are replaced when the config file is reloaded.
+	reloadMu sync.RWMutex
+
+	// permissions holds the permission blocks read at startup; nil reads
+	// them from configuration on every request.
+	permissions *permissionsSnapshot
+
+	// reservedPrefixes are subject namespaces never granted to users.
+	reservedPrefixes []string
+	// accountSubjects, when set, limit issued allow entries to subjects
+	// exported from or imported into the users' account.
+	accountSubjects []string
+	// denyTemplates render operator-defined deny messages.
+	denyTemplates *denyTemplates
+
+	// standby is nil unless this replica runs in active/passive mode.
+	standby *Standby
+	// configChangelog records applied config reloads; nil when disabled.
+	configChangelog *configChangelog
+	// configDrift compares the config hashes of the replicas; nil when
+	// disabled.
+	configDrift *ConfigDrift
+	// accounts selects the account of a user from their groups; nil issues
+	// every user into nats.audience.
+	accounts *accountSelector
+	// teams selects the shared team principal of a user from their groups;
+	// nil issues every user as themselves.
+	teams *teamSelector
 }
 
 // NewNATSClient creates a new NATS client
@@ -43,11 +150,44 @@ func NewNATSClient(url, user, pass string, issuerSeed, xKeySeed string, gitlabCl
 		},
 	})
 
-	// Parse the issuer seed
-	issuerKeyPair, err := nkeys.FromSeed([]byte(issuerSeed))
+	// Refuse to start with permissions that grant reserved subjects
+	reservedPrefixes := LoadReservedPrefixes()
+	if err := ValidateReservedSubjects(reservedPrefixes); err != nil {
+		sentry.CaptureException(fmt.Errorf("invalid permissions config: %w", err))
+		return nil, fmt.Errorf("invalid permissions config: %w", err)
+	}
+
+	// Refuse to start with users mapped to undefined roles
+	if err := LoadRolesConfig().Validate(); err != nil {
+		sentry.CaptureException(fmt.Errorf("invalid roles config: %w", err))
+		return nil, fmt.Errorf("invalid roles config: %w", err)
+	}
+	if err := LoadUserLimits().Validate("nats.limits"); err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+	if err := LoadDeployTokensConfig().Validate(); err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	// Refuse to start (or warn) with templates that render wrong subjects
+	if err := checkPermissionTemplates(logger.Warn); err != nil {
+		sentry.CaptureException(fmt.Errorf("invalid permissions config: %w", err))
+		return nil, fmt.Errorf("invalid permissions config: %w", err)
+	}
+
+	// Warn early about grants that can never be used in the account
+	accountSubjects := LoadAccountSubjects()
+	for _, entry := range ValidateAccountSubjects(accountSubjects) {
+		logger.Warn("Permission does not match any account export/import and will never be issued", "permission", entry)
+	}
+
+	// Set up the issuer signer (local seed or external signer)
+	issuerSigner, err := newIssuerSigner(issuerSeed)
 	if err != nil {
-		sentry.CaptureException(fmt.Errorf("invalid issuer seed: %w", err))
-		return nil, fmt.Errorf("invalid issuer seed: %w", err)
+		sentry.CaptureException(err)
+		return nil, err
 	}
 
 	// Parse the xKey seed if provided
@@ -56,9 +196,28 @@ func NewNATSClient(url, user, pass string, issuerSeed, xKeySeed string, gitlabCl
 		sentry.CaptureException(fmt.Errorf("invalid xKey seed: %w", err))
 		return nil, fmt.Errorf("invalid xKey seed: %w", err)
 	}
+	if xKeyPair != nil {
+		xKeyPub, _ := xKeyPair.PublicKey()
+		logger.Info("Auth callout encryption available; set it as auth_callout xkey on the servers", "xkey", xKeyPub)
+	}
+
+	serverCompat, err := LoadServerCompat()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := LoadNATSCredentials()
+	creds.User, creds.Pass = user, pass
+	if err := creds.Validate("nats"); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := LoadNATSTLSConfig().TLSConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// Connect to NATS
-	nc, err := nats.Connect(url, buildNATSOptions(logger, user, pass)...)
+	nc, err := nats.Connect(url, buildNATSOptions(logger, creds, tlsConfig)...)
 	if err != nil {
 		sentry.CaptureException(fmt.Errorf("failed to connect to NATS: %w", err))
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
@@ -74,12 +233,62 @@ func NewNATSClient(url, user, pass string, issuerSeed, xKeySeed string, gitlabCl
 		},
 	})
 
+	// Optional: separate credentials for coordination between replicas.
+	var platform *nats.Conn
+	if platformCfg := LoadPlatformConfig(); platformCfg.Enabled {
+		if platform, err = connectPlatform(logger, platformCfg, tlsConfig); err != nil {
+			nc.Close()
+			sentry.CaptureException(err)
+			return nil, err
+		}
+	} else {
+		logger.Info("Platform account not configured, coordination subjects use the auth connection")
+	}
+
+	// Optional: a second connection for KV traffic.
+	var kvConn *nats.Conn
+	kvMode, err := LoadKVConnectionMode()
+	if err == nil && kvMode == kvConnectionDedicated {
+		kvConn, err = connectKV(logger, url, creds, tlsConfig)
+	}
+	if err != nil {
+		nc.Close()
+		if platform != nil {
+			platform.Close()
+		}
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
 	client := &NATSClient{
-		nc:            nc,
-		issuerKeyPair: issuerKeyPair,
-		xKeyPair:      xKeyPair,
-		gitlabClient:  gitlabClient,
-		logger:        logger,
+		nc:           nc,
+		platform:     platform,
+		kvConn:       kvConn,
+		issuerSigner: issuerSigner,
+		issuance:     newIssuanceLimiter(),
+		attempts:     newIssuanceLimiter(),
+		xKeyPair:     xKeyPair,
+		serverCompat: serverCompat,
+		gitlabClient: gitlabClient,
+		logger:       logger,
+		instanceID:   newInstanceID(),
+		startedAt:    time.Now().UTC(),
+
+		permissions:      loadPermissionsSnapshot(),
+		reservedPrefixes: reservedPrefixes,
+		accountSubjects:  accountSubjects,
+	}
+	client.watchAuthSubscriptionErrors()
+
+	// Every step below loads its state (overrides, lockouts, grants, policy
+	// snapshots) before returning, and Start subscribes to auth requests
+	// only after all of them, so no request is answered on partial state
+	// after a deploy.
+
+	// Optional: apply fleet-wide config overrides from JetStream KV. First,
+	// so the other steps already see the overridden settings.
+	if err := client.initConfigOverrides(); err != nil {
+		return nil, err
 	}
 
 	// Optional: initialize JetStream KV token cache.
@@ -87,6 +296,107 @@ func NewNATSClient(url, user, pass string, issuerSeed, xKeySeed string, gitlabCl
 		return nil, err
 	}
 
+	// Optional: alert when the token cache answers for GitLab too often.
+	if err := client.initCacheFallbackAlert(); err != nil {
+		return nil, err
+	}
+
+	// Optional: per-user grants from approved GitLab access request issues.
+	if err := client.initAccessRequests(); err != nil {
+		return nil, err
+	}
+
+	// Optional: publish audit events to a NATS subject.
+	if err := client.initAuditNATS(); err != nil {
+		return nil, err
+	}
+
+	// Optional: alert on unusual issuer key usage.
+	if err := client.initKeyUsage(); err != nil {
+		return nil, err
+	}
+
+	// Optional: lock usernames out after repeated invalid tokens.
+	if err := client.initLockout(); err != nil {
+		return nil, err
+	}
+
+	// Optional: compare GitLab verifications with a shadow instance.
+	if err := client.initGitLabShadow(); err != nil {
+		return nil, err
+	}
+
+	// Optional: issue users into accounts by top-level GitLab group.
+	if err := client.initAccounts(); err != nil {
+		return nil, err
+	}
+
+	// Optional: issue members of team groups as shared team principals.
+	if err := client.initTeams(); err != nil {
+		return nil, err
+	}
+
+	// Optional: a WebAssembly policy module adjusts grants before issuance.
+	// Before the JWT cache, whose config hash includes the module.
+	if err := client.initPolicyHook(); err != nil {
+		return nil, err
+	}
+
+	// Optional: reuse issued JWTs for reconnect storms.
+	if err := client.initJWTCache(); err != nil {
+		return nil, err
+	}
+
+	// Optional: compare issued subscribe grants with actual subscriptions.
+	if err := client.initSubjectUsage(); err != nil {
+		return nil, err
+	}
+
+	// Optional: connection budget of the users' account.
+	if err := client.initAccountBudget(); err != nil {
+		return nil, err
+	}
+
+	// Optional: answer permission probes of connected clients.
+	if err := client.initCanI(); err != nil {
+		return nil, err
+	}
+
+	// Optional: tags from the clients' connection names.
+	if err := client.initClientTags(); err != nil {
+		return nil, err
+	}
+
+	// Optional: answer only requests of known servers.
+	if err := client.initTrustedServers(); err != nil {
+		return nil, err
+	}
+
+	// Optional: operator-defined deny messages.
+	if err := client.initDenyTemplates(); err != nil {
+		return nil, err
+	}
+
+	// Optional: shed requests when the auth queue is too deep.
+	if err := client.initLoadShedding(); err != nil {
+		return nil, err
+	}
+
+	// Optional: record applied config reloads.
+	if err := client.initConfigChangelog(); err != nil {
+		return nil, err
+	}
+
+	// Optional: detect replicas running a stale config.
+	if err := client.initConfigDrift(); err != nil {
+		return nil, err
+	}
+
+	// Optional: answer auth requests only while holding the active lease.
+	if err := client.initStandby(); err != nil {
+		return nil, err
+	}
+
 	return client, nil
 }
 
@@ -96,12 +406,20 @@ func parseXKeySeed(xKeySeed string) (nkeys.KeyPair, error) {
 	if xKeySeed == "" {
 		return nil, nil
 	}
-	return nkeys.FromSeed([]byte(xKeySeed))
+	kp, err := nkeys.FromSeed([]byte(xKeySeed))
+	if err != nil {
+		return nil, err
+	}
+	// Only curve keys can seal and open callout messages.
+	if pub, err := kp.PublicKey(); err != nil || !nkeys.IsValidPublicCurveKey(pub) {
+		return nil, errors.New("not a curve (xkey) seed")
+	}
+	return kp, nil
 }
 
 // buildNATSOptions builds the standard set of NATS connection options,
 // including reconnect/error handlers and optional user/password auth.
-func buildNATSOptions(logger *slog.Logger, user, pass string) []nats.Option {
+func buildNATSOptions(logger *slog.Logger, creds NATSCredentials, tlsConfig *tls.Config) []nats.Option {
 	opts := []nats.Option{
 		nats.ReconnectWait(5 * time.Second),
 		nats.MaxReconnects(-1),
@@ -136,12 +454,12 @@ func buildNATSOptions(logger *slog.Logger, user, pass string) []nats.Option {
 		}),
 	}
 
-	// Add authentication if provided
-	if user != "" && pass != "" {
-		opts = append(opts, nats.UserInfo(user, pass))
+	if tlsConfig != nil {
+		opts = append(opts, nats.Secure(tlsConfig))
 	}
 
-	return opts
+	// Add authentication if provided
+	return append(opts, creds.Options()...)
 }
 
 // initTokenCache optionally initializes the JetStream KV token cache based
@@ -163,41 +481,206 @@ func (c *NATSClient) initTokenCache() error {
 
 	c.logger.Info("Token cache config loaded (JetStream KV)", logFields...)
 
-	js, err := c.nc.JetStream()
+	overrides, err := LoadTTLOverrides()
+	if err != nil {
+		return err
+	}
+	for _, o := range overrides {
+		if o.TTL > cacheCfg.TTL {
+			c.logger.Warn("Token cache TTL override exceeds token_cache.ttl and is capped",
+				"users", o.Users, "groups", o.Groups, "ttl", o.TTL, "max_ttl", cacheCfg.TTL)
+		}
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	jsAPI, err := c.jetStreamAPI()
 	if err != nil {
 		return fmt.Errorf("failed to initialize JetStream: %w", err)
 	}
 	c.logger.Info("JetStream initialized")
 
-	cache, err := NewJetStreamTokenCache(js, cacheCfg)
+	cache, err := NewJetStreamTokenCache(context.Background(), jsAPI, cacheCfg)
 	if err != nil {
 		return err
 	}
+	if err := c.migrateTokenCache(js, cacheCfg.Bucket); err != nil {
+		return err
+	}
 	c.tokenCache = cache
+
+	// Optional: tenants with their own buckets.
+	tenantCaches, err := c.initTenantTokenCaches(js, jsAPI, cacheCfg)
+	if err != nil {
+		return err
+	}
+	if len(tenantCaches) > 0 {
+		c.tokenCache = NewTenantTokenCache(cache, tenantCaches)
+	}
+
+	if cacheCfg.WriteQueueSize > 0 {
+		c.tokenCache = NewBufferedTokenCache(c.tokenCache, cacheCfg.WriteQueueSize, cacheCfg.WriteBatchSize, cacheCfg.PutTimeout)
+	}
+	if cacheCfg.MemorySize > 0 && cacheCfg.MemoryTTL > 0 {
+		c.tokenCache = NewMemoryTokenCache(c.tokenCache, cacheCfg.HMACSecret, cacheCfg.MemorySize, cacheCfg.MemoryTTL)
+	}
 	c.logger.Info("Token cache enabled (JetStream KV)",
 		"bucket", cacheCfg.Bucket,
 		"ttl", cacheCfg.TTL,
 		"replicas", cacheCfg.Replicas,
+		"write_queue_size", cacheCfg.WriteQueueSize,
+		"write_batch_size", cacheCfg.WriteBatchSize,
+		"memory_size", cacheCfg.MemorySize,
+		"memory_ttl", cacheCfg.MemoryTTL,
+		"tenant_buckets", len(tenantCaches),
 	)
 
 	return nil
 }
 
-// Start starts listening for authentication requests
+// initConfigOverrides optionally starts watching the config overrides KV
+// bucket. The current overrides are applied before this returns.
+func (c *NATSClient) initConfigOverrides() error {
+	cfg := LoadConfigOverridesConfig()
+	if !cfg.Enabled {
+		c.logger.Info("Config overrides disabled (JetStream KV)")
+		return nil
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+
+	overrides, err := NewConfigOverrides(js, cfg, c.instanceID)
+	if err != nil {
+		return err
+	}
+	c.reloadGitLabOnOverride()
+	if err := overrides.Start(); err != nil {
+		return err
+	}
+	c.configOverrides = overrides
+	c.logger.Info("Config overrides enabled (JetStream KV)", "bucket", cfg.Bucket, "instance", c.instanceID)
+
+	return nil
+}
+
+// reloadGitLabOnOverride re-reads the GitLab client settings whenever one
+// of the overridable gitlab.* keys changes, as the client copies them.
+func (c *NATSClient) reloadGitLabOnOverride() {
+	if c.gitlabClient == nil {
+		return
+	}
+	for _, key := range []string{"gitlab.circuitBreakerFailures", "gitlab.circuitBreakerOpenSeconds"} {
+		OnConfigOverride(key, func(any) { c.gitlabClient.Reload() })
+	}
+}
+
+// initAccessRequests optionally loads the user grants bucket and starts
+// syncing approved access request issues into it.
+func (c *NATSClient) initAccessRequests() error {
+	cfg := LoadAccessRequestsConfig()
+	if !cfg.Enabled {
+		c.logger.Info("Access requests disabled (GitLab issues)")
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+
+	grants, err := NewUserGrants(js, cfg.Bucket, cfg.Replicas)
+	if err != nil {
+		return err
+	}
+	if err := c.migrateKV(js, userGrantsSchema, cfg.Bucket, grants.kv); err != nil {
+		return err
+	}
+	if err := grants.Start(); err != nil {
+		return err
+	}
+	requestSync, err := NewAccessRequestSync(cfg, c.gitlabClient.baseURL, grants)
+	if err != nil {
+		grants.Stop()
+		return err
+	}
+	requestSync.Start()
+
+	c.userGrants, c.accessRequests = grants, requestSync
+	c.logger.Info("Access requests enabled (GitLab issues)", "project", cfg.Project, "bucket", cfg.Bucket)
+	return nil
+}
+
+// subscribeAuthRequests subscribes to the auth_callout subject.
+func (c *NATSClient) subscribeAuthRequests() error {
+	// Use a queue subscription so that only one of the active instances handles a given request.
+	// A panic while handling one request must not take the subscription down.
+	sub, err := c.nc.QueueSubscribe(authCalloutSubject, "gcs_antal_auth_callout",
+		c.recoverHandler("auth_request", c.handleAuthRequest, c.denyAfterPanic))
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("failed to subscribe to auth requests: %w", err))
+		return fmt.Errorf("failed to subscribe to auth requests: %w", err)
+	}
+	c.authSub.Store(sub)
+	c.subHealth.subscribed(time.Now())
+	return nil
+}
+
+// Start starts listening for authentication requests. The state loaded by
+// NewNATSClient is complete by now; the auth subscription comes after the
+// subscriptions that keep it current.
 func (c *NATSClient) Start() error {
+	if monitorOnlyEnabled() {
+		warnMonitorOnly(c.logger)
+	}
+
 	// Start Sentry transaction for NATS subscription
 	ctx := context.Background()
 	span := sentry.StartTransaction(ctx, "nats.subscribe.$SYS.REQ.USER.AUTH")
 	defer span.Finish()
 
-	// Subscribe to the auth_callout subject
-	// Use a queue subscription so that only one of the active instances handles a given request.
-	_, err := c.nc.QueueSubscribe("$SYS.REQ.USER.AUTH", "gcs_antal_auth_callout", func(msg *nats.Msg) {
-		c.handleAuthRequest(msg)
-	})
-	if err != nil {
-		sentry.CaptureException(fmt.Errorf("failed to subscribe to auth requests: %w", err))
-		return fmt.Errorf("failed to subscribe to auth requests: %w", err)
+	// Every replica follows issuer rotations, so no queue group here. The
+	// server has the subscription before the first auth request is
+	// answered, so a rotation during startup is not missed and no user JWT
+	// is signed with a rotated-out key.
+	if _, err := c.coordination().Subscribe(issuerRotatedSubject, c.recoverHandler("issuer_rotated", c.handleIssuerRotated, nil)); err != nil {
+		sentry.CaptureException(fmt.Errorf("failed to subscribe to issuer rotation events: %w", err))
+		return fmt.Errorf("failed to subscribe to issuer rotation events: %w", err)
+	}
+	if err := c.coordination().Flush(); err != nil {
+		sentry.CaptureException(fmt.Errorf("failed to subscribe to issuer rotation events: %w", err))
+		return fmt.Errorf("failed to subscribe to issuer rotation events: %w", err)
+	}
+
+	// A standby replica only subscribes once it is promoted.
+	if c.standby != nil {
+		c.standby.Start()
+	} else if err := c.subscribeAuthRequests(); err != nil {
+		return err
+	}
+
+	// Every replica receives permission probes; the one that issued the
+	// user's JWT answers.
+	if c.issuedGrants != nil {
+		if _, err := c.nc.Subscribe(LoadCanIConfig().Subject, c.recoverHandler("can_i", c.handleCanI, nil)); err != nil {
+			sentry.CaptureException(fmt.Errorf("failed to subscribe to permission probes: %w", err))
+			return fmt.Errorf("failed to subscribe to permission probes: %w", err)
+		}
+	}
+
+	// Catch replicas that sign with another issuer key or use another xkey.
+	if cfg := LoadReplicaCheckConfig(); cfg.Enabled {
+		if err := c.announceInstance(cfg); err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
 	}
 
 	c.logger.Info("Started listening for authentication requests")
@@ -214,17 +697,36 @@ func (c *NATSClient) Start() error {
 func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 	// Start Sentry transaction for auth request
 	ctx := context.Background()
-	tx := sentry.StartTransaction(ctx, "auth.request")
+	tx := sentry.StartTransaction(ctx, "auth.request", c.traceOptions(time.Now())...)
 	defer tx.Finish()
+	received := time.Now()
+	c.subHealth.request(received)
+	authRequestsInFlight.Inc()
+	defer authRequestsInFlight.Dec()
 
 	c.logger.Debug("Received auth request", "data_length", len(msg.Data))
 
+	// Decrypt the request if the server sealed it for our xkey
+	data, _, err := c.openRequest(msg)
+	if err != nil {
+		c.logger.Error("Failed to open auth request", "error", err)
+		c.respondMsg(msg, "", "", "", c.denyResponse(DenyInvalidRequest, "cannot decrypt request", ""))
+		exportDecision(authDecision{Code: DenyInvalidRequest, Reason: "cannot decrypt request", Received: received})
+
+		sentry.WithScope(func(scope *sentry.Scope) {
+			scope.SetTag("error_type", "decrypt_auth_request")
+			sentry.CaptureException(err)
+		})
+		return
+	}
+
 	// Decode the authorization request claims
-	rc, err := jwt.DecodeAuthorizationRequestClaims(string(msg.Data))
+	rc, err := jwt.DecodeAuthorizationRequestClaims(string(data))
 	if err != nil {
 		c.logger.Error("Failed to decode auth request", "error", err)
 		// Nie znamy userNkey ani serverId, więc wysyłamy puste
-		c.respondMsg(msg.Reply, "", "", "", "invalid request format")
+		c.respondMsg(msg, "", "", "", c.denyResponse(DenyInvalidRequest, "invalid request format", ""))
+		exportDecision(authDecision{Code: DenyInvalidRequest, Reason: "invalid request format", Received: received})
 
 		sentry.WithScope(func(scope *sentry.Scope) {
 			scope.SetTag("error_type", "decode_auth_request")
@@ -234,11 +736,37 @@ func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 		return
 	}
 
+	// Requests of unknown servers are left unanswered.
+	if c.checkServer(rc) {
+		tx.SetTag("auth_status", "untrusted_server")
+		return
+	}
+	c.checkServerVersion(rc)
+
 	// Wyciągnij potrzebne dane z żądania JWT
 	userNkey := rc.UserNkey
 	serverId := rc.Server.ID
-	username := rc.ConnectOptions.Username
-	token := rc.ConnectOptions.Password
+	username, token := connectCredentials(rc.ConnectOptions)
+
+	// Requests that waited out the server's callout timeout in the backlog
+	// would be answered into the void; optionally skip them.
+	age := requestAge(rc.IssuedAt, received)
+	authRequestAge.Observe(age.Seconds())
+	backlog := LoadBacklogPolicy()
+	if backlog.Drop(age) {
+		staleRequestsDroppedTotal.Inc()
+		c.logger.Warn("Dropping stale auth request", "username", username, "age", age)
+		tx.SetTag("auth_status", "stale")
+		return
+	}
+
+	// Every answered request is exported as a decision to the audit sinks.
+	decision := authDecision{Username: username, ServerID: serverId, ClientIP: rc.ClientInformation.Host, Received: received, Queued: age}
+	deny := func(code DenyCode, text string) {
+		decision.Code, decision.Reason = code, text
+		c.respondMsg(msg, userNkey, serverId, "", c.denyResponse(code, text, username))
+		exportDecision(decision)
+	}
 
 	// Add context to Sentry transaction
 	tx.SetTag("username", username)
@@ -246,14 +774,81 @@ func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 
 	c.logger.Info("Processing auth request", "username", username)
 
-	// Create child span for GitLab verification
-	gitlabCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
-	span := sentry.StartSpan(gitlabCtx, "auth.authorize_token")
+	overridden := false
+
+	// A deep queue means GitLab cannot keep up; some clients are told to come
+	// back later rather than waiting in the queue until they time out.
+	if retryAfter, shed := c.checkLoadShedding(token); shed {
+		if overridden = c.monitorOnlyOverride(username, DenyRetryLater); !overridden {
+			tx.SetTag("auth_status", "load_shed")
+			decision.Code, decision.Reason = DenyRetryLater, retryLaterText(retryAfter)
+			c.respondMsg(msg, userNkey, serverId, "", c.denyResponse(DenyRetryLater, decision.Reason, username))
+			exportDecision(decision)
+			return
+		}
+	}
 
-	result, err := AuthorizeToken(ctx, token, c.gitlabClient, c.tokenCache, time.Now)
+	// Clients that send only the token either get the token owner's username
+	// (below, once verified) or are turned away without asking GitLab.
+	if !overridden && username == "" && emptyUsernamePolicy() == EmptyUsernameDeny {
+		if overridden = c.monitorOnlyOverride(username, DenyUsernameRequired); !overridden {
+			tx.SetTag("auth_status", "username_required")
+			deny(DenyUsernameRequired, "connect with a username")
+			return
+		}
+	}
+
+	// Attempts are limited before verification, so a storm of guessed
+	// tokens never reaches GitLab.
+	if !overridden {
+		if key := c.checkAuthAttempts(username, rc.ClientInformation.Host); key != "" {
+			if overridden = c.monitorOnlyOverride(username, DenyRateLimited); !overridden {
+				tx.SetTag("auth_status", "attempts_limited")
+				deny(DenyRateLimited, "too many auth attempts, slow down")
+				return
+			}
+		}
+	}
+
+	// Locked out usernames are turned away without asking GitLab.
+	if !overridden {
+		if until, locked := c.checkLockout(username); locked {
+			if overridden = c.monitorOnlyOverride(username, DenyLockedOut); !overridden {
+				tx.SetTag("auth_status", "locked_out")
+				deny(DenyLockedOut, lockedOutText(until))
+				return
+			}
+		}
+	}
+
+	// Create child span for GitLab verification; every span of the request
+	// shares one hub context.
+	hubCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
+	span := sentry.StartSpan(hubCtx, "auth.authorize_token")
+
+	// GitLab gets what is left of the server's callout timeout for this
+	// request, so the response still lands in time when it is slow.
+	verifyCtx := ctx
+	budget := backlog.Budget(rc.IssuedAt, rc.Expires, received)
+	authRequestBudget.Observe(budget.Seconds())
+	if deadline, ok := backlog.verificationDeadline(budget, received); ok {
+		var cancel context.CancelFunc
+		verifyCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	result, err := AuthorizeToken(verifyCtx, token, c.verifier(), c.tokenCache, time.Now)
+	decision.GitLab, decision.TokenCache = result.GitLabDuration, result.CacheDuration
+	switch {
+	case result.FromMemory:
+		decision.Source = "memory"
+	case result.FromCache:
+		decision.Source = "cache"
+	default:
+		decision.Source = "gitlab"
+	}
 	if err != nil {
 		c.logger.Error("Error authorizing token", "error", err)
-		c.respondMsg(msg.Reply, userNkey, serverId, "", "authentication error")
 
 		span.Status = sentry.SpanStatusInternalError
 		span.SetData("error", err.Error())
@@ -264,89 +859,197 @@ func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 			scope.SetTag("error_type", "authorize_token")
 			sentry.CaptureException(err)
 		})
+
+		if overridden = c.monitorOnlyOverride(username, DenyAuthError); !overridden {
+			deny(DenyAuthError, "authentication error")
+			return
+		}
+	} else {
+		if result.CacheWriteErr != nil {
+			c.logger.Warn("Failed to write token cache", "error", result.CacheWriteErr)
+		}
+		span.Finish()
+
+		if !result.Allow {
+			c.logger.Info("Authentication failed", "username", username)
+
+			sentry.WithScope(func(scope *sentry.Scope) {
+				scope.SetUser(sentry.User{Username: username})
+				scope.SetTag("auth_status", "failed")
+				scope.SetLevel(sentry.LevelWarning)
+				sentry.CaptureMessage("Authentication failed - invalid credentials")
+			})
+			c.recordAuthFailure(username)
+
+			if c.checkPasswordSent(username, token, result) {
+				if overridden = c.monitorOnlyOverride(username, DenyPasswordSent); !overridden {
+					tx.SetTag("auth_status", "password_sent")
+					deny(DenyPasswordSent, passwordSentText)
+					return
+				}
+			}
+
+			if overridden = c.monitorOnlyOverride(username, DenyInvalidCredentials); !overridden {
+				deny(DenyInvalidCredentials, "invalid credentials")
+				return
+			}
+		} else {
+			c.recordCacheFallback(result)
+		}
+	}
+
+	// Templates rendered with an empty {{.Username}} would yield broken
+	// subjects, so no JWT is issued without a username, not even in
+	// monitor-only mode. Templates also render the username into subjects,
+	// so a client connecting with someone else's username and its own token
+	// would get their subject namespace. GitLab usernames are
+	// case-insensitive: permissions and audit always use GitLab's spelling,
+	// so Alice and alice share one subject namespace.
+	resolved, code, text := connectUsername(username, result)
+	switch {
+	case code == DenyUsernameRequired:
+		c.logger.Warn("No username in connect options and none known for the token")
+		tx.SetTag("auth_status", "username_required")
+		deny(code, text)
 		return
+	case code != "" && !overridden:
+		if overridden = c.monitorOnlyOverride(username, code); !overridden {
+			if code == DenyAuthError {
+				c.logger.Warn("Token owner unknown, cannot check the username", "username", username)
+			} else {
+				c.logger.Warn("Username differs from the token owner", "username", username, "token_username", result.Username())
+			}
+			tx.SetTag("auth_status", string(code))
+			deny(code, text)
+			return
+		}
 	}
-	if result.CacheWriteErr != nil {
-		c.logger.Warn("Failed to write token cache", "error", result.CacheWriteErr)
+	c.resetOwnerFailures(result, code)
+	if resolved != username {
+		c.logger.Debug("Using the token owner's username", "username", resolved)
+		username, decision.Username = resolved, resolved
+		tx.SetTag("username", resolved)
 	}
-	span.Finish()
 
-	if !result.Allow {
-		c.logger.Info("Authentication failed", "username", username)
-		c.respondMsg(msg.Reply, userNkey, serverId, "", "invalid credentials")
+	// Tags the client appended to its connection name, filtered by the
+	// client_tags allow-list; they may narrow or widen templated grants.
+	tags := c.clientTags(rc.ConnectOptions.Name, username)
+	decision.Tags = tags
 
-		sentry.WithScope(func(scope *sentry.Scope) {
-			scope.SetUser(sentry.User{Username: username})
-			scope.SetTag("auth_status", "failed")
-			scope.SetLevel(sentry.LevelWarning)
-			sentry.CaptureMessage("Authentication failed - invalid credentials")
-		})
-		return
+	if !overridden && c.checkScopes(username, result) {
+		if overridden = c.monitorOnlyOverride(username, DenyExcessiveScopes); !overridden {
+			tx.SetTag("auth_status", "excessive_scopes")
+			deny(DenyExcessiveScopes, "token scopes exceed the allowed scopes")
+			return
+		}
 	}
 
-	if result.FromCache {
-		tx.SetTag("auth_source", "cache")
-	} else {
-		tx.SetTag("auth_source", "gitlab")
+	// Only verified users are counted, so nobody can throttle another user by
+	// connecting with their username and a bogus token.
+	if !overridden && c.checkIssuanceRate(result.Username()) {
+		if overridden = c.monitorOnlyOverride(username, DenyRateLimited); !overridden {
+			tx.SetTag("auth_status", "rate_limited")
+			deny(DenyRateLimited, "too many JWTs issued, slow down reconnects")
+			return
+		}
 	}
 
-	// Authentication successful
-	c.logger.Info("Authentication successful", "username", username)
-	tx.SetTag("auth_status", "success")
-
-	// Create span for JWT creation
-	jwtCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
-	jwtSpan := sentry.StartSpan(jwtCtx, "jwt.create_user_claims")
+	if !overridden && c.checkAccountBudget(username) {
+		if overridden = c.monitorOnlyOverride(username, DenyAccountAtCapacity); !overridden {
+			tx.SetTag("auth_status", "account_at_capacity")
+			deny(DenyAccountAtCapacity, "account is at its connection budget")
+			return
+		}
+	}
 
-	// Create user claims with permissions
-	uc := jwt.NewUserClaims(userNkey)
-	uc.Name = username
+	tx.SetTag("auth_source", decision.Source)
 
-	// Use Audience from configuration
-	uc.Audience = viper.GetString("nats.audience")
+	// Members of a team group are issued the shared team principal; their
+	// own username is kept for the audit trail only.
+	name := username
+	if principal, ok := c.teamPrincipal(result); ok {
+		name, decision.Principal = principal, principal
+	}
 
-	// Set permissions from configuration
-	// Publish permissions
-	pubAllow := viper.GetStringSlice("nats.permissions.publish.allow")
-	for _, subject := range pubAllow {
-		processedSubject := c.processPermissionTemplate(subject, username)
-		uc.Permissions.Pub.Allow.Add(processedSubject)
-		c.logger.Debug("Added publish allow permission", "subject", processedSubject)
+	if overridden {
+		tx.SetTag("auth_status", "monitor_only")
+	} else {
+		// Authentication successful
+		c.logger.Info("Authentication successful", "username", username, "principal", name)
+		tx.SetTag("auth_status", "success")
 	}
 
-	pubDeny := viper.GetStringSlice("nats.permissions.publish.deny")
-	for _, subject := range pubDeny {
-		processedSubject := c.processPermissionTemplate(subject, username)
-		uc.Permissions.Pub.Deny.Add(processedSubject)
-		c.logger.Debug("Added publish deny permission", "subject", processedSubject)
+	// With auth.identity user_id, permissions cannot be rendered for cache
+	// entries that predate the recorded user ID; GitLab has to be asked again.
+	// Team principals render no user ID.
+	if _, ok := templateIdentity(identityMode(), username, result); !ok && decision.Principal == "" {
+		c.logger.Warn("GitLab user ID unknown, cannot render permissions by user ID", "username", username)
+		tx.SetTag("auth_status", "unknown_user_id")
+		deny(DenyAuthError, "user ID unknown, retry when GitLab is reachable")
+		return
 	}
 
-	// Subscribe permissions
-	subAllow := viper.GetStringSlice("nats.permissions.subscribe.allow")
-	for _, subject := range subAllow {
-		processedSubject := c.processPermissionTemplate(subject, username)
-		uc.Permissions.Sub.Allow.Add(processedSubject)
-		c.logger.Debug("Added subscribe allow permission", "subject", processedSubject)
+	// A client reconnecting within jwt_cache.ttl gets the JWT issued moments
+	// ago; building and signing the claims again would yield the same grant.
+	cacheKey := c.jwtCacheKey(userNkey, name, result, tags)
+	if userJwt, ok := c.jwtCache.Get(cacheKey, time.Now()); ok {
+		tx.SetTag("jwt_cache", "hit")
+		c.logIssuedClaims(username, userJwt, true)
+		c.respondMsg(msg, userNkey, serverId, userJwt, "")
+		decision.Allowed, decision.MonitorOnly = true, overridden
+		exportDecision(decision)
+		return
 	}
 
-	subDeny := viper.GetStringSlice("nats.permissions.subscribe.deny")
-	for _, subject := range subDeny {
-		processedSubject := c.processPermissionTemplate(subject, username)
-		uc.Permissions.Sub.Deny.Add(processedSubject)
-		c.logger.Debug("Added subscribe deny permission", "subject", processedSubject)
+	// Create span for JWT creation
+	jwtSpan := sentry.StartSpan(hubCtx, "jwt.create_user_claims")
+
+	// Create user claims with permissions
+	// Set permissions from configuration, including the user's tenants
+	perms := c.resolvePermissions(result, username, tags)
+	limits := c.userLimits(result)
+	account := c.userAccount(result)
+	decision.Account = account.Name
+
+	// Optional: the policy module adjusts the grant.
+	perms, limits, ok := c.applyPolicyHook(hubCtx, result, decision, perms, limits)
+	if !ok {
+		if overridden = c.monitorOnlyOverride(username, DenyInternalError); !overridden {
+			jwtSpan.Finish()
+			tx.SetTag("auth_status", "policy_hook_error")
+			deny(DenyInternalError, "policy check failed")
+			return
+		}
+	}
+	policy := callout.Policy{Limits: callout.Limits(limits)}
+	perms.Apply(&policy.Permissions)
+	identity := callout.Identity{
+		UserNkey: userNkey,
+		Name:     name,
+		Audience: account.Name,
+		Tags:     tagList(tags),
 	}
+	signer := c.issuer()
+	if account.Signer != nil {
+		identity.IssuerAccount, signer = account.Name, account.Signer
+	}
+	uc := callout.BuildUserClaims(identity, policy)
+	if c.subjectUsage != nil {
+		// Keyed by the JWT name, which is what the servers report in CONNZ.
+		c.subjectUsage.RecordGrant(uc.Name, perms)
+	}
+	c.issuedGrants.Record(uc.Name, perms)
 	jwtSpan.Finish()
 
 	// Validate the claims
-	valCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
-	validationSpan := sentry.StartSpan(valCtx, "jwt.validate_claims")
+	validationSpan := sentry.StartSpan(hubCtx, "jwt.validate_claims")
 	vr := jwt.CreateValidationResults()
 	uc.Validate(vr)
 	validationSpan.Finish()
 
 	if len(vr.Errors()) > 0 {
 		c.logger.Error("Error validating user claims", "errors", vr.Errors())
-		c.respondMsg(msg.Reply, userNkey, serverId, "", fmt.Sprintf("error validating claims: %s", vr.Errors()))
+		deny(DenyInvalidClaims, fmt.Sprintf("error validating claims: %s", vr.Errors()))
 
 		sentry.WithScope(func(scope *sentry.Scope) {
 			scope.SetUser(sentry.User{Username: username})
@@ -358,14 +1061,15 @@ func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 	}
 
 	// Encode the user claims
-	encodeCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
-	encodeSpan := sentry.StartSpan(encodeCtx, "jwt.encode_claims")
-	userJwt, err := uc.Encode(c.issuerKeyPair)
+	encodeSpan := sentry.StartSpan(hubCtx, "jwt.encode_claims")
+	signStarted := time.Now()
+	userJwt, err := encodeClaims(uc, signer)
+	decision.Sign = time.Since(signStarted)
 	encodeSpan.Finish()
 
 	if err != nil {
 		c.logger.Error("Error encoding user JWT", "error", err)
-		c.respondMsg(msg.Reply, userNkey, serverId, "", "error encoding user JWT")
+		deny(DenyInternalError, "error encoding user JWT")
 
 		sentry.WithScope(func(scope *sentry.Scope) {
 			scope.SetUser(sentry.User{Username: username})
@@ -375,61 +1079,268 @@ func (c *NATSClient) handleAuthRequest(msg *nats.Msg) {
 		return
 	}
 
+	c.recordIssuance(username, signer)
+	c.jwtCache.Put(cacheKey, userJwt, time.Now())
+	c.logIssuedClaims(username, userJwt, false)
+
 	// Send response with encoded JWT - use userNkey instead of issuerPubKey
-	responseCtx := sentry.SetHubOnContext(ctx, sentry.CurrentHub())
-	responseSpan := sentry.StartSpan(responseCtx, "nats.send_response")
-	c.respondMsg(msg.Reply, userNkey, serverId, userJwt, "")
+	responseSpan := sentry.StartSpan(hubCtx, "nats.send_response")
+	c.respondMsg(msg, userNkey, serverId, userJwt, "")
 	responseSpan.Finish()
 
-	// Add successful authentication metric to Sentry
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category: "auth",
-		Message:  "User successfully authenticated",
-		Level:    sentry.LevelInfo,
-		Data: map[string]interface{}{
-			"username": username,
-		},
-	})
-}
+	decision.Allowed, decision.MonitorOnly = true, overridden
+	exportDecision(decision)
 
-// processPermissionTemplate processes Go template strings in permission subjects
-func (c *NATSClient) processPermissionTemplate(subjectTemplate string, username string) string {
-	// Define template data structure
-	type TemplateData struct {
-		Username string
+	// Add successful authentication metric to Sentry; skip building the
+	// breadcrumb when Sentry is not configured.
+	if sentryEnabled() {
+		sentry.AddBreadcrumb(&sentry.Breadcrumb{
+			Category: "auth",
+			Message:  "User successfully authenticated",
+			Level:    sentry.LevelInfo,
+			Data: map[string]interface{}{
+				"username": username,
+			},
+		})
 	}
+}
 
-	// Create template
-	tmpl, err := template.New("permission").Parse(subjectTemplate)
+// sentryEnabled reports whether a Sentry client is configured.
+func sentryEnabled() bool {
+	return sentry.CurrentHub().Client() != nil
+}
+
+// processPermissionTemplate processes Go template strings in permission subjects
+func (c *NATSClient) processPermissionTemplate(subjectTemplate string, data permissionTemplateData) string {
+	processed, err := renderPermissionTemplate(subjectTemplate, data)
 	if err != nil {
 		// Log error but return original string if template is invalid
-		c.logger.Error("Invalid permission template", "template", subjectTemplate, "error", err)
+		hash := templateHash(subjectTemplate)
+		permissionTemplateErrorsTotal.WithLabelValues(hash).Inc()
+		c.logger.Error("Failed to process permission template", "template", subjectTemplate, "template_hash", hash, "error", err)
 		return subjectTemplate
 	}
 
-	// Prepare data for template
-	data := TemplateData{
-		Username: username,
+	if processed != subjectTemplate && c.logger.Enabled(context.Background(), slog.LevelDebug) {
+		c.logger.Debug("Processed permission template", "original", subjectTemplate, "processed", processed)
 	}
 
-	// Execute template
-	var result bytes.Buffer
-	if err := tmpl.Execute(&result, data); err != nil {
-		c.logger.Error("Failed to process permission template", "template", subjectTemplate, "error", err)
-		return subjectTemplate
+	return processed
+}
+
+// permissionTemplateData is the data available to permission templates.
+type permissionTemplateData struct {
+	Username string
+	// UserID is the numeric GitLab user ID; 0 when unknown.
+	UserID int64
+	// Identity is the username or the user ID, depending on auth.identity.
+	Identity string
+	// Scopes are the token's scopes; empty when GitLab does not report them.
+	Scopes []string
+	// Tags are the trusted tags the client sent in its connection name.
+	Tags map[string]string
+	// Project is the job's project path as subject tokens (group.sub.project)
+	// and ProjectPath the path itself; only set for CI/CD job tokens.
+	Project     string
+	ProjectPath string
+}
+
+// HasScope reports whether the token carries the scope. Tokens with unknown
+// scopes have none, so scope-conditional grants fail closed.
+func (d permissionTemplateData) HasScope(scope string) bool {
+	return slices.Contains(d.Scopes, scope)
+}
+
+// Tag returns the value of a trusted client tag, "" when the client did not
+// send it. Unlike {{.Tags.name}} it never renders "<no value>".
+func (d permissionTemplateData) Tag(name string) string {
+	return d.Tags[name]
+}
+
+// HasTag reports whether the client sent the tag with the value.
+func (d permissionTemplateData) HasTag(name, value string) bool {
+	v, ok := d.Tags[name]
+	return ok && v == value
+}
+
+// parsedTemplate is a cached parse result of a permission template.
+type parsedTemplate struct {
+	tmpl *template.Template
+	err  error
+}
+
+// permissionTemplates caches parsed permission templates by their source.
+// Templates only come from configuration, so the cache stays small.
+var permissionTemplates sync.Map
+
+// templateHash identifies a permission template in metrics: the first 12 hex
+// digits of the SHA-256 of its source, which keeps subjects out of labels.
+func templateHash(subjectTemplate string) string {
+	sum := sha256.Sum256([]byte(subjectTemplate))
+	return hex.EncodeToString(sum[:6])
+}
+
+// renderPermissionTemplate executes a single permission subject template.
+// Subjects without template actions are returned as is.
+func renderPermissionTemplate(subjectTemplate string, data permissionTemplateData) (string, error) {
+	if !strings.Contains(subjectTemplate, "{{") {
+		return subjectTemplate, nil
 	}
 
-	processed := result.String()
-	if processed != subjectTemplate {
-		c.logger.Debug("Processed permission template", "original", subjectTemplate, "processed", processed)
+	cached, ok := permissionTemplates.Load(subjectTemplate)
+	if !ok {
+		tmpl, err := template.New("permission").Parse(subjectTemplate)
+		cached, _ = permissionTemplates.LoadOrStore(subjectTemplate, parsedTemplate{tmpl: tmpl, err: err})
+	}
+	parsed := cached.(parsedTemplate)
+	if parsed.err != nil {
+		return "", parsed.err
 	}
 
-	return processed
+	var result strings.Builder
+	result.Grow(len(subjectTemplate) + len(data.Username))
+	if err := parsed.tmpl.Execute(&result, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(result.String()), nil
 }
 
-// respondMsg sends an authentication response to NATS
-func (c *NATSClient) respondMsg(replySubject, userNkey, serverId, userJwt, errMsg string) {
-	// If userNkey is empty or invalid, generate a temporary one
+// renderPermissions renders a permissions block for the user into a
+// PermissionSet. Subjects rendering to nothing (e.g. a scope condition that
+// does not hold) are dropped. For the global block an empty allow list keeps
+// its NATS meaning of "everything", but only when nothing is configured: a
+// list emptied by scope conditions grants nothing. For additional blocks
+// (tenants) an empty allow list always grants nothing.
+func (c *NATSClient) renderPermissions(cfg PermissionsConfig, data permissionTemplateData, emptyMeansAll bool) PermissionSet {
+	render := func(rules PermissionRules) SubjectRules {
+		out := SubjectRules{}
+		for _, subject := range rules.Allow {
+			if rendered := c.processPermissionTemplate(subject, data); rendered != "" {
+				out.Allow = append(out.Allow, rendered)
+			}
+		}
+		for _, subject := range rules.Deny {
+			if rendered := c.processPermissionTemplate(subject, data); rendered != "" {
+				out.Deny = append(out.Deny, rendered)
+			}
+		}
+		if len(rules.Allow) == 0 && emptyMeansAll {
+			out.Allow = []string{">"}
+		}
+		return out
+	}
+
+	return PermissionSet{
+		Publish:   render(cfg.Publish),
+		Subscribe: render(cfg.Subscribe),
+	}.Normalize()
+}
+
+// resolvePermissions returns the permissions for an authorized user: the
+// block of the user's role (or the global nats.permissions block), extended
+// by the blocks of the tenants (GitLab top-level groups) the user belongs to.
+// Reserved namespaces are removed and, when configured, the result is
+// limited to the account's subjects. Deploy and CI/CD job tokens get only
+// their own block (deploy_tokens.permissions, ci_job_tokens.permissions).
+func (c *NATSClient) resolvePermissions(result AuthorizeResult, username string, tags map[string]string) PermissionSet {
+	identity, _ := templateIdentity(identityMode(), username, result)
+	data := permissionTemplateData{Username: username, UserID: result.UserID(), Identity: identity, Scopes: result.Scopes(), Tags: tags}
+	principal, team := c.teamPrincipal(result)
+	if team {
+		// Team members share the team's subject namespace; nothing of their
+		// own identity is rendered.
+		data.Username, data.UserID, data.Identity = principal, 0, principal
+	}
+	if project := result.Project(); project != "" {
+		data.Project, data.ProjectPath = projectSubject(project), project
+	}
+	if block, ok := c.tokenTypePermissions(result.TokenType()); ok {
+		c.logger.Debug("Applying token type permissions", "username", username, "token_type", result.TokenType())
+		return c.restrictPermissions(c.renderPermissions(block, data, false), username)
+	}
+
+	global, tenants := c.permissionsConfig()
+	if name, role, ok := c.userRole(result); ok {
+		c.logger.Debug("Applying role permissions", "username", username, "role", name)
+		global = role.Permissions
+	}
+	set := c.renderPermissions(global, data, true)
+
+	if matched := tenants.Match(result.Groups()); len(matched) > 0 {
+		c.logger.Debug("Applying tenant permissions", "username", username, "tenants", matched)
+		for _, block := range tenants.Blocks(matched) {
+			set = set.Union(c.renderPermissions(block, data, false))
+		}
+	}
+
+	if c.userGrants != nil && !team {
+		// Grants belong to the verified GitLab user, not the client-supplied
+		// name, and never to a team principal.
+		if grant, ok := c.userGrants.Lookup(result.Username()); ok {
+			c.logger.Debug("Applying user grant", "username", username, "issues", grant.Issues)
+			set = set.Union(grant.PermissionSet())
+		}
+	}
+
+	return c.restrictPermissions(set, username)
+}
+
+// restrictPermissions removes reserved namespaces from a user's permissions
+// and narrows them to the account subjects when those are configured.
+func (c *NATSClient) restrictPermissions(set PermissionSet, username string) PermissionSet {
+	reservedPrefixes, accountSubjects := c.subjectLimits()
+	for kind, rules := range map[string]SubjectRules{"publish": set.Publish, "subscribe": set.Subscribe} {
+		for _, subject := range rules.Allow {
+			if prefix, hit := reservedPrefixFor(subject, reservedPrefixes); hit {
+				c.reportReservedGrant(kind, subject, prefix, username)
+			}
+		}
+	}
+	if len(reservedPrefixes) > 0 {
+		set = set.Subtract(DenyOnly(reservedDenySubjects(reservedPrefixes)...))
+	}
+
+	if len(accountSubjects) > 0 {
+		// Narrow the grants to the subjects that actually exist in the account.
+		set = set.Intersect(AllowOnly(accountSubjects...))
+	}
+
+	c.logger.Debug("Resolved user permissions",
+		"username", username,
+		"publish_allow", set.Publish.Allow,
+		"publish_deny", set.Publish.Deny,
+		"subscribe_allow", set.Subscribe.Allow,
+		"subscribe_deny", set.Subscribe.Deny,
+	)
+	return set
+}
+
+// reportReservedGrant raises an alert when a rendered allow entry would grant
+// a reserved subject. This should be impossible with a validated config, so
+// it usually means a template rendered into a reserved namespace.
+func (c *NATSClient) reportReservedGrant(kind, subject, prefix, username string) {
+	c.logger.Error("Stripped reserved subject from user permissions",
+		"kind", kind,
+		"subject", subject,
+		"reserved_prefix", prefix,
+		"username", username,
+	)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetUser(sentry.User{Username: username})
+		scope.SetTag("error_type", "reserved_subject_grant")
+		scope.SetTag("reserved_prefix", prefix)
+		scope.SetContext("permission", sentry.Context{"kind": kind, "subject": subject})
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureMessage("Stripped reserved subject from user permissions")
+	})
+}
+
+// buildResponse encodes the authorization response JWT sent back to the
+// server. If userNkey is empty or invalid (e.g. the request could not be
+// decoded), a temporary user key is generated, as the server requires a
+// subject.
+func (c *NATSClient) buildResponse(userNkey, serverId, userJwt, errMsg string) (string, error) {
 	if userNkey == "" || !strings.HasPrefix(userNkey, "U") {
 		c.logger.Warn("Invalid userNkey, generating temporary one", "userNkey", userNkey)
 
@@ -444,36 +1355,43 @@ func (c *NATSClient) respondMsg(replySubject, userNkey, serverId, userJwt, errMs
 
 		keypair, err := nkeys.CreateUser()
 		if err != nil {
-			c.logger.Error("Failed to generate temporary NKey", "error", err)
-			sentry.CaptureException(err)
-			return
+			return "", fmt.Errorf("failed to generate temporary NKey: %w", err)
 		}
-
-		userNkey, err = keypair.PublicKey()
-		if err != nil {
-			c.logger.Error("Failed to get public key from temporary NKey", "error", err)
-			sentry.CaptureException(err)
-			return
+		if userNkey, err = keypair.PublicKey(); err != nil {
+			return "", fmt.Errorf("failed to get public key from temporary NKey: %w", err)
 		}
 	}
 
-	// Create authorization response claims
-	rc := jwt.NewAuthorizationResponseClaims(userNkey)
-	if serverId != "" {
-		rc.Audience = serverId
+	rc, err := callout.BuildResponse(userNkey, serverId, userJwt, errMsg)
+	if err != nil {
+		return "", err
 	}
-	rc.Error = errMsg
-	rc.Jwt = userJwt
 
 	// Sign with the issuer key
-	token, err := rc.Encode(c.issuerKeyPair)
+	token, err := encodeClaims(rc, c.issuer())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response JWT: %w", err)
+	}
+	return token, nil
+}
+
+// respondMsg sends the authentication response to a request, encrypted for
+// the server when the request was.
+func (c *NATSClient) respondMsg(req *nats.Msg, userNkey, serverId, userJwt, errMsg string) {
+	replySubject := req.Reply
+	token, err := c.buildResponse(userNkey, serverId, userJwt, errMsg)
 	if err != nil {
-		c.logger.Error("Failed to encode response JWT", "error", err)
+		c.logger.Error("Failed to build auth response", "error", err)
 		sentry.CaptureException(err)
 		return
 	}
 
-	data := []byte(token)
+	data, err := c.sealResponse(token, req.Header.Get(serverXKeyHeader))
+	if err != nil {
+		c.logger.Error("Failed to encrypt auth response", "error", err)
+		sentry.CaptureException(err)
+		return
+	}
 
 	// Send the response
 	if err := c.nc.Publish(replySubject, data); err != nil {
@@ -500,8 +1418,78 @@ func (c *NATSClient) respondMsg(replySubject, userNkey, serverId, userJwt, errMs
 	}
 }
 
+// drainAuthRequests leaves the auth callout queue group and answers the
+// requests already delivered, waiting at most timeout. Another process in the
+// queue group (e.g. the new binary during an upgrade) receives every request
+// from then on, so none is lost. A timeout of 0 unsubscribes right away.
+func (c *NATSClient) drainAuthRequests(timeout time.Duration) {
+	sub := c.authSub.Swap(nil)
+	c.subHealth.unsubscribed()
+	if sub == nil || !sub.IsValid() {
+		return
+	}
+	if timeout <= 0 {
+		_ = sub.Unsubscribe()
+		return
+	}
+	if err := sub.Drain(); err != nil {
+		c.logger.Warn("Failed to drain auth requests", "error", err)
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for sub.IsValid() {
+		if time.Now().After(deadline) {
+			pending, _, _ := sub.Pending()
+			c.logger.Warn("Timed out draining auth requests", "pending", pending)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.logger.Info("Drained auth requests")
+}
+
 // Stop cleanly closes the NATS connection
 func (c *NATSClient) Stop() {
+	if c.standby != nil {
+		// Hand the lease over first, so a standby is promoted while this
+		// replica drains.
+		c.standby.Stop()
+	}
+	c.drainAuthRequests(viper.GetDuration("nats.drain_timeout"))
+	if c.accountBudget != nil {
+		c.accountBudget.Stop()
+	}
+	if c.subjectUsage != nil {
+		c.subjectUsage.Stop()
+	}
+	if c.accessRequests != nil {
+		c.accessRequests.Stop()
+	}
+	if c.userGrants != nil {
+		c.userGrants.Stop()
+	}
+	if c.lockouts != nil {
+		c.lockouts.Stop()
+	}
+	if c.configOverrides != nil {
+		c.configOverrides.Stop()
+	}
+	if c.configDrift != nil {
+		c.configDrift.Stop()
+	}
+	if closer, ok := c.tokenCache.(interface{ Close() }); ok {
+		// Flush queued cache writes while the connection is still open.
+		closer.Close()
+	}
+	if c.policyHook != nil {
+		c.policyHook.Close()
+	}
+	if c.platform != nil && !c.platform.IsClosed() {
+		c.platform.Close()
+	}
+	if c.kvConn != nil && !c.kvConn.IsClosed() {
+		c.kvConn.Close()
+	}
 	if c.nc != nil && !c.nc.IsClosed() {
 		c.logger.Info("Closing NATS connection")
 		sentry.AddBreadcrumb(&sentry.Breadcrumb{
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/tls"
 	"log/slog"
 	"testing"
 	"time"
@@ -41,6 +42,17 @@ func TestParseXKeySeed(t *testing.T) {
 		require.Error(t, err)
 		assert.Nil(t, kp)
 	})
+
+	t.Run("non-curve seed returns an error", func(t *testing.T) {
+		account, err := nkeys.CreateAccount()
+		require.NoError(t, err)
+		seed, err := account.Seed()
+		require.NoError(t, err)
+
+		kp, err := parseXKeySeed(string(seed))
+		require.ErrorContains(t, err, "not a curve")
+		assert.Nil(t, kp)
+	})
 }
 
 func applyOptions(t *testing.T, opts []nats.Option) nats.Options {
@@ -56,7 +68,7 @@ func TestBuildNATSOptions(t *testing.T) {
 	logger := slog.Default()
 
 	t.Run("sets standard reconnect and handler options", func(t *testing.T) {
-		opts := buildNATSOptions(logger, "", "")
+		opts := buildNATSOptions(logger, NATSCredentials{}, nil)
 		o := applyOptions(t, opts)
 
 		assert.Equal(t, 5*time.Second, o.ReconnectWait)
@@ -69,7 +81,7 @@ func TestBuildNATSOptions(t *testing.T) {
 	})
 
 	t.Run("adds user/password auth when both provided", func(t *testing.T) {
-		opts := buildNATSOptions(logger, "alice", "secret")
+		opts := buildNATSOptions(logger, NATSCredentials{User: "alice", Pass: "secret"}, nil)
 		o := applyOptions(t, opts)
 
 		assert.Equal(t, "alice", o.User)
@@ -77,14 +89,41 @@ func TestBuildNATSOptions(t *testing.T) {
 	})
 
 	t.Run("skips auth when only user or only password provided", func(t *testing.T) {
-		o := applyOptions(t, buildNATSOptions(logger, "alice", ""))
+		o := applyOptions(t, buildNATSOptions(logger, NATSCredentials{User: "alice"}, nil))
 		assert.Empty(t, o.User)
 		assert.Empty(t, o.Password)
 
-		o = applyOptions(t, buildNATSOptions(logger, "", "secret"))
+		o = applyOptions(t, buildNATSOptions(logger, NATSCredentials{Pass: "secret"}, nil))
 		assert.Empty(t, o.User)
 		assert.Empty(t, o.Password)
 	})
+
+	t.Run("authenticates with a user nkey seed", func(t *testing.T) {
+		kp, err := nkeys.CreateUser()
+		require.NoError(t, err)
+		seed, _ := kp.Seed()
+		pub, _ := kp.PublicKey()
+
+		o := applyOptions(t, buildNATSOptions(logger, NATSCredentials{NkeySeed: string(seed)}, nil))
+		assert.Equal(t, pub, o.Nkey)
+		sig, err := o.SignatureCB([]byte("nonce"))
+		require.NoError(t, err)
+		assert.NoError(t, kp.Verify([]byte("nonce"), sig))
+	})
+
+	t.Run("connects with the TLS config", func(t *testing.T) {
+		tc := &tls.Config{MinVersion: tls.VersionTLS12}
+		o := applyOptions(t, buildNATSOptions(logger, NATSCredentials{}, tc))
+		assert.True(t, o.Secure)
+		assert.Same(t, tc, o.TLSConfig)
+	})
+
+	t.Run("authenticates with a creds file", func(t *testing.T) {
+		path, _ := writeCredsFile(t)
+		o := applyOptions(t, buildNATSOptions(logger, NATSCredentials{CredsFile: path}, nil))
+		assert.NotNil(t, o.UserJWT)
+		assert.NotNil(t, o.SignatureCB)
+	})
 }
 
 func TestInitTokenCache_Disabled(t *testing.T) {
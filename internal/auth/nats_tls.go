@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// NATSTLSConfig holds the nats.tls.* settings of the connections to NATS.
+type NATSTLSConfig struct {
+	// CAFile verifies the servers' certificates; the system roots when empty.
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented to servers
+	// that verify clients.
+	CertFile string
+	KeyFile  string
+	// Insecure skips the verification of the servers' certificates.
+	Insecure bool
+}
+
+// LoadNATSTLSConfig reads the nats.tls.* settings.
+func LoadNATSTLSConfig() NATSTLSConfig {
+	return NATSTLSConfig{
+		CAFile:   viper.GetString("nats.tls.ca_file"),
+		CertFile: viper.GetString("nats.tls.cert_file"),
+		KeyFile:  viper.GetString("nats.tls.key_file"),
+		Insecure: viper.GetBool("nats.tls.insecure"),
+	}
+}
+
+// TLSConfig builds the TLS configuration of the connections, nil when no
+// setting is made: a tls:// URL still connects with TLS and the system roots.
+func (cfg NATSTLSConfig) TLSConfig() (*tls.Config, error) {
+	if cfg == (NATSTLSConfig{}) {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.Insecure, // #nosec G402 -- explicit opt-in for test clusters
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats.tls: failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("nats.tls: no certificates found in %s", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if (cfg.CertFile != "") != (cfg.KeyFile != "") {
+		return nil, fmt.Errorf("nats.tls: cert_file and key_file must both be set")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats.tls: failed to load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self-signed certificate and its key as PEM files.
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "antal"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNATSTLSConfig(t *testing.T) {
+	tc, err := NATSTLSConfig{}.TLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, tc, "no settings leaves TLS to the URL scheme")
+
+	certFile, keyFile := writeTestCert(t)
+	tc, err = NATSTLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}.TLSConfig()
+	require.NoError(t, err)
+	assert.NotNil(t, tc.RootCAs)
+	assert.Len(t, tc.Certificates, 1)
+	assert.False(t, tc.InsecureSkipVerify)
+
+	tc, err = NATSTLSConfig{Insecure: true}.TLSConfig()
+	require.NoError(t, err)
+	assert.True(t, tc.InsecureSkipVerify)
+	assert.Nil(t, tc.RootCAs, "system roots")
+
+	_, err = NATSTLSConfig{CertFile: certFile}.TLSConfig()
+	assert.ErrorContains(t, err, "cert_file and key_file must both be set")
+	_, err = NATSTLSConfig{CAFile: keyFile}.TLSConfig()
+	assert.ErrorContains(t, err, "no certificates found")
+	_, err = NATSTLSConfig{CertFile: certFile, KeyFile: certFile}.TLSConfig()
+	assert.ErrorContains(t, err, "client certificate")
+}
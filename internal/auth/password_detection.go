@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Password detection modes.
+const (
+	PasswordDetectionOff    = "off"
+	PasswordDetectionWarn   = "warn"
+	PasswordDetectionStrict = "strict"
+)
+
+// passwordDetectionMode returns auth.password_detection; unknown values count
+// as off. It is read on every request so it can be changed at runtime.
+func passwordDetectionMode() string {
+	mode := strings.ToLower(strings.TrimSpace(viper.GetString("auth.password_detection")))
+	switch mode {
+	case PasswordDetectionWarn, PasswordDetectionStrict:
+		return mode
+	}
+	return PasswordDetectionOff
+}
+
+// errUnknownToken is returned for tokens GitLab does not know at all, as
+// opposed to expired or revoked ones.
+var errUnknownToken = fmt.Errorf("%w: unknown to GitLab", ErrInvalidToken)
+
+// isUnknownTokenError reports whether a 401 is GitLab's generic answer
+// ({"message":"401 Unauthorized"}). Expired and revoked tokens get an
+// invalid_token error with a description instead.
+func isUnknownTokenError(err error) bool {
+	var errResp *gitlab.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil || errResp.Response.StatusCode != 401 {
+		return false
+	}
+	var body struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(errResp.Body, &body) != nil {
+		return false
+	}
+	return body.Error == "" && strings.HasPrefix(body.Message, "401")
+}
+
+var (
+	// glTokenPrefix starts the tokens of GitLab 14.5+ (glpat-, gloas-, ...).
+	glTokenPrefix = regexp.MustCompile(`^gl[a-z]+-`)
+	// legacyTokenPattern matches personal access tokens created before
+	// GitLab added prefixes.
+	legacyTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{20}$`)
+)
+
+// looksLikePassword reports whether a credential has none of the formats of
+// GitLab tokens, which is typical of users sending their account password.
+func looksLikePassword(token string) bool {
+	return token != "" &&
+		!glTokenPrefix.MatchString(token) &&
+		!legacyTokenPattern.MatchString(token) &&
+		tokenType(token) != TokenTypeOAuth
+}
+
+// passwordSentText is the deny message of requests carrying a password.
+const passwordSentText = "this looks like a GitLab account password; create a personal access token and connect with it instead"
+
+// checkPasswordSent reports whether a rejected token is denied as a password:
+// GitLab did not know it and it has no token format. The credential itself is
+// never logged.
+func (c *NATSClient) checkPasswordSent(username, token string, result AuthorizeResult) bool {
+	mode := passwordDetectionMode()
+	if mode == PasswordDetectionOff || !result.UnknownToken || !looksLikePassword(token) {
+		return false
+	}
+	passwordsDetectedTotal.WithLabelValues(mode).Inc()
+	c.logger.Warn("Password sent instead of a GitLab token", "username", username, "mode", mode)
+	return mode == PasswordDetectionStrict
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// SubjectRules is the allow/deny pair for one direction (publish or
+// subscribe) of rendered subjects.
+//
+// Semantics follow NATS, with deny always winning over allow. Unlike a raw
+// NATS permission, an empty Allow list means "nothing": "everything" is
+// spelled out as [">"], so that set operations can never silently widen a
+// grant to the whole account.
+type SubjectRules struct {
+	Allow []string
+	Deny  []string
+}
+
+// PermissionSet holds the rendered publish and subscribe rules of a user.
+// Use Union, Intersect and Subtract to combine sets instead of appending to
+// slices: the results are normalized and never contain contradictory entries.
+type PermissionSet struct {
+	Publish   SubjectRules
+	Subscribe SubjectRules
+}
+
+// AllowAll returns a set allowing every subject in both directions.
+func AllowAll() PermissionSet {
+	return PermissionSet{
+		Publish:   SubjectRules{Allow: []string{">"}},
+		Subscribe: SubjectRules{Allow: []string{">"}},
+	}
+}
+
+// AllowOnly returns a set allowing exactly the given subjects in both directions.
+func AllowOnly(subjects ...string) PermissionSet {
+	return PermissionSet{
+		Publish:   SubjectRules{Allow: slices.Clone(subjects)},
+		Subscribe: SubjectRules{Allow: slices.Clone(subjects)},
+	}.Normalize()
+}
+
+// DenyOnly returns a set that allows nothing itself and denies the given
+// subjects; it is meant as the right-hand side of Subtract.
+func DenyOnly(subjects ...string) PermissionSet {
+	return PermissionSet{
+		Publish:   SubjectRules{Deny: slices.Clone(subjects)},
+		Subscribe: SubjectRules{Deny: slices.Clone(subjects)},
+	}
+}
+
+// Union grants what either set grants. Denies of both sets are kept (deny
+// wins), which makes the union conservative: a subject denied by one set is
+// denied in the result even if the other set allows it.
+func (p PermissionSet) Union(o PermissionSet) PermissionSet {
+	return PermissionSet{
+		Publish:   p.Publish.union(o.Publish),
+		Subscribe: p.Subscribe.union(o.Subscribe),
+	}
+}
+
+// Intersect grants only what both sets grant. The result is exact.
+func (p PermissionSet) Intersect(o PermissionSet) PermissionSet {
+	return PermissionSet{
+		Publish:   p.Publish.intersect(o.Publish),
+		Subscribe: p.Subscribe.intersect(o.Subscribe),
+	}
+}
+
+// Subtract removes everything o allows or denies from p. Denies in o are
+// treated as subjects to remove as well, so subtraction never grants more
+// than p did.
+func (p PermissionSet) Subtract(o PermissionSet) PermissionSet {
+	return PermissionSet{
+		Publish:   p.Publish.subtract(o.Publish),
+		Subscribe: p.Subscribe.subtract(o.Subscribe),
+	}
+}
+
+// Normalize removes duplicates, redundant entries, allows shadowed by a
+// deny, and denies that do not affect any allow.
+func (p PermissionSet) Normalize() PermissionSet {
+	return PermissionSet{
+		Publish:   p.Publish.normalize(),
+		Subscribe: p.Subscribe.normalize(),
+	}
+}
+
+// Apply writes the set into NATS user permissions. A direction with nothing
+// allowed is encoded as deny ">", because an empty NATS allow list would
+// grant everything.
+func (p PermissionSet) Apply(perms *jwt.Permissions) {
+	p.Publish.apply(&perms.Pub)
+	p.Subscribe.apply(&perms.Sub)
+}
+
+// Allows reports whether the subject (which may contain wildcards) is fully
+// allowed: covered by an allow entry and not overlapping any deny entry.
+func (r SubjectRules) Allows(subject string) bool {
+	covered := false
+	for _, a := range r.Allow {
+		if subjectSubsetOf(subject, a) {
+			covered = true
+			break
+		}
+	}
+	if !covered {
+		return false
+	}
+	for _, d := range r.Deny {
+		if _, overlap := intersectSubjects(subject, d); overlap {
+			return false
+		}
+	}
+	return true
+}
+
+func (r SubjectRules) union(o SubjectRules) SubjectRules {
+	return SubjectRules{
+		Allow: concat(r.Allow, o.Allow),
+		Deny:  concat(r.Deny, o.Deny),
+	}.normalize()
+}
+
+func (r SubjectRules) intersect(o SubjectRules) SubjectRules {
+	var allow []string
+	for _, a := range r.Allow {
+		for _, b := range o.Allow {
+			if s, ok := intersectSubjects(a, b); ok {
+				allow = append(allow, s)
+			}
+		}
+	}
+	return SubjectRules{
+		Allow: allow,
+		Deny:  concat(r.Deny, o.Deny),
+	}.normalize()
+}
+
+func (r SubjectRules) subtract(o SubjectRules) SubjectRules {
+	return SubjectRules{
+		Allow: r.Allow,
+		Deny:  concat(r.Deny, o.Allow, o.Deny),
+	}.normalize()
+}
+
+func (r SubjectRules) normalize() SubjectRules {
+	deny := dropCovered(dedupe(r.Deny))
+
+	var allow []string
+	for _, a := range dropCovered(dedupe(r.Allow)) {
+		if !coveredByAny(a, deny) {
+			allow = append(allow, a)
+		}
+	}
+
+	var relevantDeny []string
+	for _, d := range deny {
+		for _, a := range allow {
+			if _, overlap := intersectSubjects(a, d); overlap {
+				relevantDeny = append(relevantDeny, d)
+				break
+			}
+		}
+	}
+
+	return SubjectRules{Allow: allow, Deny: relevantDeny}
+}
+
+func (r SubjectRules) apply(perm *jwt.Permission) {
+	if len(r.Allow) == 0 {
+		perm.Deny.Add(">")
+		return
+	}
+	perm.Allow.Add(r.Allow...)
+	perm.Deny.Add(r.Deny...)
+}
+
+// subjectSubsetOf reports whether every subject matched by a is also matched by b.
+func subjectSubsetOf(a, b string) bool {
+	at := strings.Split(a, ".")
+	bt := strings.Split(b, ".")
+	for i := 0; ; i++ {
+		switch {
+		case i == len(bt):
+			return i == len(at)
+		case bt[i] == ">":
+			return i < len(at)
+		case i == len(at):
+			return false
+		case at[i] == ">":
+			return false
+		case bt[i] == "*" || at[i] == bt[i]:
+			continue
+		default:
+			return false
+		}
+	}
+}
+
+// coveredByAny reports whether subject is a subset of any entry in list.
+func coveredByAny(subject string, list []string) bool {
+	for _, s := range list {
+		if subjectSubsetOf(subject, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropCovered removes entries that are a strict subset of another entry.
+// The input must not contain duplicates.
+func dropCovered(list []string) []string {
+	var out []string
+	for i, s := range list {
+		covered := false
+		for j, other := range list {
+			if i != j && subjectSubsetOf(s, other) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func dedupe(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func concat(lists ...[]string) []string {
+	var out []string
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectSubsetOf(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"orders.new", "orders.new", true},
+		{"orders.new", "orders.*", true},
+		{"orders.new", "orders.>", true},
+		{"orders.new.eu", "orders.>", true},
+		{"orders.*", "orders.>", true},
+		{"orders.>", "orders.>", true},
+		{"orders", "orders.>", false},
+		{"orders.>", "orders.*", false},
+		{"orders.*", "orders.new", false},
+		{">", "orders.>", false},
+		{"orders.new", ">", true},
+		{"billing.new", "orders.>", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, subjectSubsetOf(tt.a, tt.b), "%s ⊆ %s", tt.a, tt.b)
+	}
+}
+
+func TestPermissionSet_Union(t *testing.T) {
+	a := PermissionSet{Publish: SubjectRules{Allow: []string{"orders.*"}, Deny: []string{"orders.admin"}}}
+	b := PermissionSet{Publish: SubjectRules{Allow: []string{"orders.>", "billing.>"}}}
+
+	got := a.Union(b)
+	assert.Equal(t, []string{"orders.>", "billing.>"}, got.Publish.Allow)
+	// Deny wins: the union never re-grants what one side denied.
+	assert.Equal(t, []string{"orders.admin"}, got.Publish.Deny)
+	assert.Empty(t, got.Subscribe.Allow)
+}
+
+func TestPermissionSet_Intersect(t *testing.T) {
+	got := AllowOnly(">", "legacy.>").Intersect(AllowOnly("orders.>", "_INBOX.>"))
+	assert.Equal(t, []string{"orders.>", "_INBOX.>"}, got.Publish.Allow)
+
+	got = AllowOnly("orders.*.eu").Intersect(AllowOnly("orders.new.*"))
+	assert.Equal(t, []string{"orders.new.eu"}, got.Subscribe.Allow)
+
+	got = AllowOnly("orders.>").Intersect(AllowOnly("billing.>"))
+	assert.Empty(t, got.Publish.Allow)
+}
+
+func TestPermissionSet_Subtract(t *testing.T) {
+	got := AllowOnly(">", "antal.x").Subtract(DenyOnly("antal", "antal.>", "$SYS.>"))
+	assert.Equal(t, []string{">"}, got.Publish.Allow)
+	assert.Equal(t, []string{"antal", "antal.>", "$SYS.>"}, got.Publish.Deny)
+
+	got = AllowOnly("user.alice.>", "antal.x").Subtract(DenyOnly("antal.>"))
+	assert.Equal(t, []string{"user.alice.>"}, got.Publish.Allow)
+	// The deny no longer overlaps any allow and is dropped.
+	assert.Empty(t, got.Publish.Deny)
+}
+
+func TestPermissionSet_Normalize(t *testing.T) {
+	got := PermissionSet{Publish: SubjectRules{
+		Allow: []string{"a.b", "a.>", "a.>", "c.d", "x.y"},
+		Deny:  []string{"c.>", "z.>", "a.secret"},
+	}}.Normalize()
+
+	assert.Equal(t, []string{"a.>", "x.y"}, got.Publish.Allow)
+	assert.Equal(t, []string{"a.secret"}, got.Publish.Deny)
+}
+
+func TestPermissionSet_Apply(t *testing.T) {
+	var perms jwt.Permissions
+	PermissionSet{
+		Publish: SubjectRules{Allow: []string{"orders.>"}, Deny: []string{"orders.admin"}},
+	}.Apply(&perms)
+
+	assert.Equal(t, jwt.StringList{"orders.>"}, perms.Pub.Allow)
+	assert.Equal(t, jwt.StringList{"orders.admin"}, perms.Pub.Deny)
+	// Nothing allowed must not turn into NATS' "empty allow list means everything".
+	assert.Empty(t, perms.Sub.Allow)
+	assert.Equal(t, jwt.StringList{">"}, perms.Sub.Deny)
+}
+
+func TestSubjectRules_Allows(t *testing.T) {
+	r := SubjectRules{Allow: []string{"orders.>"}, Deny: []string{"orders.admin.*"}}
+
+	assert.True(t, r.Allows("orders.new"))
+	assert.True(t, r.Allows("orders.*"))
+	assert.False(t, r.Allows("orders.admin.reset"))
+	assert.False(t, r.Allows("orders.>"), "overlaps a deny")
+	assert.False(t, r.Allows("billing.new"))
+	assert.False(t, SubjectRules{}.Allows("orders.new"))
+}
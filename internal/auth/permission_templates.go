@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/spf13/viper"
+)
+
+// Modes of auth.unresolved_templates.
+const (
+	UnresolvedTemplatesError = "error"
+	UnresolvedTemplatesWarn  = "warn"
+)
+
+// unresolvedTemplatesMode reads auth.unresolved_templates; unknown values
+// fail, so a typo cannot turn the check into a warning.
+func unresolvedTemplatesMode() string {
+	if strings.ToLower(strings.TrimSpace(viper.GetString("auth.unresolved_templates"))) == UnresolvedTemplatesWarn {
+		return UnresolvedTemplatesWarn
+	}
+	return UnresolvedTemplatesError
+}
+
+// templateVariables are the fields and methods of permissionTemplateData.
+var templateVariables = func() map[string]bool {
+	t := reflect.TypeOf(permissionTemplateData{})
+	out := make(map[string]bool, t.NumField()+t.NumMethod())
+	for i := range t.NumField() {
+		out[t.Field(i).Name] = true
+	}
+	for i := range t.NumMethod() {
+		out[t.Method(i).Name] = true
+	}
+	return out
+}()
+
+// tagVariables are the template variables fed by client tags.
+var tagVariables = map[string]bool{"Tags": true, "Tag": true, "HasTag": true}
+
+// templateRef is a variable referenced by a template: the field or method
+// name and, for tags, the tag name when it is a literal.
+type templateRef struct {
+	name string
+	tag  string
+}
+
+// templateRefs returns the variables a template references on the top-level
+// data. References inside range and with bodies, where the dot is something
+// else, are skipped.
+func templateRefs(subject string) ([]templateRef, error) {
+	tmpl, err := template.New("permission").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	var refs []templateRef
+	var walk func(node parse.Node, root bool)
+	walk = func(node parse.Node, root bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, root)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, root)
+		case *parse.IfNode:
+			walk(n.Pipe, root)
+			walk(n.List, root)
+			walk(n.ElseList, root)
+		case *parse.RangeNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.WithNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, root)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 1 && root {
+				// {{Tag "name"}} and {{HasTag "name" "value"}}
+				if field, ok := n.Args[0].(*parse.FieldNode); ok && (field.Ident[0] == "Tag" || field.Ident[0] == "HasTag") {
+					if name, ok := n.Args[1].(*parse.StringNode); ok {
+						refs = append(refs, templateRef{name: field.Ident[0], tag: name.Text})
+						for _, arg := range n.Args[2:] {
+							walk(arg, root)
+						}
+						return
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg, root)
+			}
+		case *parse.ChainNode:
+			walk(n.Node, root)
+		case *parse.FieldNode:
+			if root {
+				refs = append(refs, fieldRef(n.Ident))
+			}
+		case *parse.VariableNode:
+			// $ is the top-level data everywhere.
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				refs = append(refs, fieldRef(n.Ident[1:]))
+			}
+		}
+	}
+	walk(tmpl.Tree.Root, true)
+	return refs, nil
+}
+
+func fieldRef(ident []string) templateRef {
+	ref := templateRef{name: ident[0]}
+	if ident[0] == "Tags" && len(ident) > 1 {
+		ref.tag = ident[1]
+	}
+	return ref
+}
+
+// ValidatePermissionTemplates reports permission templates referencing
+// variables that can never resolve: names permissionTemplateData does not
+// have (rendering fails and the raw template would be issued) and client tags
+// that are not allowed (they always render empty).
+func ValidatePermissionTemplates() []string {
+	tagsCfg, _ := LoadClientTagsConfig()
+	allowedTags := make(map[string]bool, len(tagsCfg.Allowed))
+	for _, rule := range tagsCfg.Allowed {
+		allowedTags[strings.ToLower(rule.Name)] = true
+	}
+
+	var problems []string
+	blocks := configuredPermissionBlocks()
+	for _, key := range sortedKeys(blocks) {
+		block := blocks[key]
+		lists := []allowList{
+			{key: key + ".publish.allow", subjects: block.Publish.Allow},
+			{key: key + ".publish.deny", subjects: block.Publish.Deny},
+			{key: key + ".subscribe.allow", subjects: block.Subscribe.Allow},
+			{key: key + ".subscribe.deny", subjects: block.Subscribe.Deny},
+		}
+		for _, list := range lists {
+			for _, subject := range list.subjects {
+				if !strings.Contains(subject, "{{") {
+					continue
+				}
+				for _, problem := range templateProblems(subject, allowedTags) {
+					problems = append(problems, fmt.Sprintf("%s: %q: %s", list.key, subject, problem))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// templateProblems returns why parts of a template never resolve.
+func templateProblems(subject string, allowedTags map[string]bool) []string {
+	refs, err := templateRefs(subject)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	seen := make(map[templateRef]bool, len(refs))
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		switch {
+		case !templateVariables[ref.name]:
+			problems = append(problems, fmt.Sprintf(".%s is not a template variable (available: %s)", ref.name, strings.Join(sortedKeys(templateVariables), ", ")))
+		case tagVariables[ref.name] && len(allowedTags) == 0:
+			problems = append(problems, fmt.Sprintf(".%s needs client tags, but client_tags.allowed is empty", ref.name))
+		case ref.tag != "" && !allowedTags[ref.tag]:
+			problems = append(problems, fmt.Sprintf("tag %q is not in client_tags.allowed (tag names are lowercase)", ref.tag))
+		}
+	}
+	return problems
+}
+
+// checkPermissionTemplates applies auth.unresolved_templates to the problems
+// ValidatePermissionTemplates finds.
+func checkPermissionTemplates(warn func(msg string, args ...any)) error {
+	problems := ValidatePermissionTemplates()
+	if len(problems) == 0 {
+		return nil
+	}
+	if unresolvedTemplatesMode() == UnresolvedTemplatesWarn {
+		for _, problem := range problems {
+			warn("Permission template never resolves", "problem", problem)
+		}
+		return nil
+	}
+	return fmt.Errorf("permission templates never resolve: %s", strings.Join(problems, "; "))
+}
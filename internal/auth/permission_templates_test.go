@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPermissionTemplate_Scopes(t *testing.T) {
+	tests := []struct {
+		template string
+		scopes   []string
+		want     string
+	}{
+		{`user.{{.Username}}.>`, nil, "user.alice.>"},
+		{`{{if .HasScope "api"}}orders.>{{end}}`, []string{"read_api", "api"}, "orders.>"},
+		{`{{if .HasScope "api"}}orders.>{{end}}`, []string{"read_api"}, ""},
+		{`{{if .HasScope "api"}}orders.>{{end}}`, nil, ""},
+		{`{{if not (.HasScope "api")}} readonly.{{.Username}} {{end}}`, []string{"read_api"}, "readonly.alice"},
+		{`scopes.{{range $i, $s := .Scopes}}{{if $i}}_{{end}}{{$s}}{{end}}`, []string{"read_api", "read_user"}, "scopes.read_api_read_user"},
+	}
+	for _, tt := range tests {
+		got, err := renderPermissionTemplate(tt.template, permissionTemplateData{Username: "alice", Scopes: tt.scopes})
+		require.NoError(t, err, tt.template)
+		assert.Equal(t, tt.want, got, "%s with %v", tt.template, tt.scopes)
+	}
+}
+
+func TestResolvePermissions_ScopeAwareTemplates(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{`{{if .HasScope "api"}}orders.{{.Username}}.>{{end}}`})
+	viper.Set("nats.permissions.subscribe.allow", []string{"orders.>"})
+	c := &NATSClient{logger: slog.Default()}
+
+	t.Run("api scoped tokens may publish", func(t *testing.T) {
+		set := c.resolvePermissions(AuthorizeResult{Verified: &VerifiedToken{Username: "alice", Scopes: []string{"api"}}}, "alice", nil)
+		assert.Equal(t, []string{"orders.alice.>"}, set.Publish.Allow)
+		assert.Equal(t, []string{"orders.>"}, set.Subscribe.Allow)
+	})
+
+	t.Run("read-only tokens get subscribe only", func(t *testing.T) {
+		set := c.resolvePermissions(AuthorizeResult{Cached: &TokenCacheEntry{Username: "alice", Scopes: "read_api"}}, "alice", nil)
+		// An allow list emptied by a scope condition grants nothing, it does
+		// not fall back to "everything".
+		assert.Empty(t, set.Publish.Allow)
+		assert.Equal(t, []string{"orders.>"}, set.Subscribe.Allow)
+	})
+}
+
+func TestPlaceholderRenders(t *testing.T) {
+	assert.Equal(t, []string{"user.user"}, placeholderRenders("user.{{.Username}}"))
+	assert.Equal(t, []string{"readonly.>", "orders.>"}, placeholderRenders(`{{if .HasScope "api"}}orders.>{{else}}readonly.>{{end}}`))
+	assert.Equal(t, []string{"orders.>"}, placeholderRenders(`{{if .HasScope "api"}}orders.>{{end}}`))
+	assert.Empty(t, placeholderRenders("user.{{.Username"))
+}
+
+func TestTemplateProblems(t *testing.T) {
+	tags := map[string]bool{"region": true}
+	clean := []string{
+		`user.{{.Username}}.>`,
+		`{{if .HasScope "api"}}orders.{{$.Identity}}.>{{end}}`,
+		`scopes.{{range $i, $s := .Scopes}}{{if $i}}_{{end}}{{$s}}{{end}}`,
+		`{{with .Tags}}{{.region}}{{end}}`,
+		`metrics.{{.Tag "region"}}.{{.Tags.region}}`,
+	}
+	for _, subject := range clean {
+		assert.Empty(t, templateProblems(subject, tags), subject)
+	}
+
+	problems := templateProblems(`team.{{.Groups}}.{{.Groups}}.>`, tags)
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], ".Groups is not a template variable")
+
+	assert.Len(t, templateProblems(`{{if .HasTag "environment" "staging"}}staging.>{{end}}`, tags), 1)
+	assert.Len(t, templateProblems(`metrics.{{.Tags.Region}}`, tags), 1)
+	assert.Contains(t, templateProblems(`metrics.{{.Tag "region"}}`, nil)[0], "client_tags.allowed is empty")
+	assert.Len(t, templateProblems(`user.{{.Username`, tags), 1)
+}
+
+func TestCheckPermissionTemplates(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	viper.Set("tenants.groups.acme.permissions.subscribe.deny", []string{"acme.{{.Email}}.>"})
+
+	var warned []string
+	warn := func(_ string, args ...any) { warned = append(warned, args[1].(string)) }
+
+	err := checkPermissionTemplates(warn)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `tenants.groups.acme.permissions.subscribe.deny: "acme.{{.Email}}.>"`)
+	assert.Empty(t, warned)
+
+	viper.Set("auth.unresolved_templates", "warn")
+	require.NoError(t, checkPermissionTemplates(warn))
+	require.Len(t, warned, 1)
+	assert.Contains(t, warned[0], ".Email is not a template variable")
+
+	viper.Set("tenants.groups.acme.permissions.subscribe.deny", []string{"acme.{{.Identity}}.>"})
+	assert.NoError(t, checkPermissionTemplates(warn))
+}
+
+func TestProcessPermissionTemplate_CountsErrors(t *testing.T) {
+	const broken = `user.{{.Email}}.>`
+	c := &NATSClient{logger: slog.Default()}
+	hash := templateHash(broken)
+	assert.Len(t, hash, 12)
+	before := testutil.ToFloat64(permissionTemplateErrorsTotal.WithLabelValues(hash))
+
+	assert.Equal(t, broken, c.processPermissionTemplate(broken, permissionTemplateData{Username: "alice"}), "the raw template is issued")
+	assert.Equal(t, "user.alice.>", c.processPermissionTemplate(`user.{{.Username}}.>`, permissionTemplateData{Username: "alice"}))
+	assert.Equal(t, before+1, testutil.ToFloat64(permissionTemplateErrorsTotal.WithLabelValues(hash)))
+}
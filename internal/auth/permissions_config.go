@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// PermissionRules holds the allow and deny subject templates for either
+// publish or subscribe.
+type PermissionRules struct {
+	Allow []string
+	Deny  []string
+}
+
+// PermissionsConfig holds the publish and subscribe rules of one permissions block.
+type PermissionsConfig struct {
+	Publish   PermissionRules
+	Subscribe PermissionRules
+}
+
+// LoadPermissionsConfig reads a permissions block (publish/subscribe
+// allow/deny lists) rooted at the given config key.
+func LoadPermissionsConfig(key string) PermissionsConfig {
+	return PermissionsConfig{
+		Publish: PermissionRules{
+			Allow: viper.GetStringSlice(key + ".publish.allow"),
+			Deny:  viper.GetStringSlice(key + ".publish.deny"),
+		},
+		Subscribe: PermissionRules{
+			Allow: viper.GetStringSlice(key + ".subscribe.allow"),
+			Deny:  viper.GetStringSlice(key + ".subscribe.deny"),
+		},
+	}
+}
+
+// allowList is a named allow list from configuration, used for validation.
+type allowList struct {
+	key      string
+	subjects []string
+}
+
+// configuredPermissionBlocks returns every permissions block that can end up
+// in issued permissions by config key: the global nats.permissions block, all
+// role blocks, all tenant blocks and the deploy and job token blocks.
+func configuredPermissionBlocks() map[string]PermissionsConfig {
+	blocks := map[string]PermissionsConfig{
+		"nats.permissions":          LoadPermissionsConfig("nats.permissions"),
+		"deploy_tokens.permissions": LoadPermissionsConfig("deploy_tokens.permissions"),
+		"ci_job_tokens.permissions": LoadPermissionsConfig("ci_job_tokens.permissions"),
+	}
+	for name, role := range LoadRolesConfig().Profiles {
+		blocks["roles.profiles."+name+".permissions"] = role.Permissions
+	}
+	tenants := LoadTenantsConfig()
+	blocks["tenants.defaults.permissions"] = tenants.Defaults.Permissions
+	for group, tenant := range tenants.Groups {
+		blocks["tenants.groups."+group+".permissions"] = tenant.Permissions
+	}
+	return blocks
+}
+
+// configuredAllowLists returns the allow lists of every configured
+// permissions block.
+func configuredAllowLists() []allowList {
+	blocks := configuredPermissionBlocks()
+	var out []allowList
+	for _, key := range sortedKeys(blocks) {
+		block := blocks[key]
+		if len(block.Publish.Allow) > 0 {
+			out = append(out, allowList{key: key + ".publish.allow", subjects: block.Publish.Allow})
+		}
+		if len(block.Subscribe.Allow) > 0 {
+			out = append(out, allowList{key: key + ".subscribe.allow", subjects: block.Subscribe.Allow})
+		}
+	}
+	return out
+}
+
+// permissionsSnapshot holds the global, role, tenant, deploy token and job
+// token permission blocks and the user limits. None can be overridden at
+// runtime, so they are read once (and on config reload) instead of being
+// decoded from viper on every request.
+type permissionsSnapshot struct {
+	global  PermissionsConfig
+	roles   RolesConfig
+	tenants TenantsConfig
+	deploy  PermissionsConfig
+	jobs    PermissionsConfig
+	limits  RoleLimits
+}
+
+func loadPermissionsSnapshot() *permissionsSnapshot {
+	return &permissionsSnapshot{
+		global:  LoadPermissionsConfig("nats.permissions"),
+		roles:   LoadRolesConfig(),
+		tenants: LoadTenantsConfig(),
+		deploy:  LoadPermissionsConfig("deploy_tokens.permissions"),
+		jobs:    LoadPermissionsConfig("ci_job_tokens.permissions"),
+		limits:  LoadUserLimits(),
+	}
+}
+
+// permissionsConfig returns the permission blocks for the request path.
+func (c *NATSClient) permissionsConfig() (PermissionsConfig, TenantsConfig) {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	if c.permissions != nil {
+		return c.permissions.global, c.permissions.tenants
+	}
+	return LoadPermissionsConfig("nats.permissions"), LoadTenantsConfig()
+}
+
+// rolesConfig returns the permission profiles for the request path.
+func (c *NATSClient) rolesConfig() RolesConfig {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	if c.permissions != nil {
+		return c.permissions.roles
+	}
+	return LoadRolesConfig()
+}
+
+// limitsConfig returns the user limits for the request path.
+func (c *NATSClient) limitsConfig() RoleLimits {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	if c.permissions != nil {
+		return c.permissions.limits
+	}
+	return LoadUserLimits()
+}
+
+// tokenTypePermissions returns the permissions block of token types that
+// get only their own block (deploy and CI/CD job tokens).
+func (c *NATSClient) tokenTypePermissions(kind string) (PermissionsConfig, bool) {
+	key := map[string]string{TokenTypeDeploy: "deploy_tokens.permissions", TokenTypeJob: "ci_job_tokens.permissions"}[kind]
+	if key == "" {
+		return PermissionsConfig{}, false
+	}
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	switch {
+	case c.permissions == nil:
+		return LoadPermissionsConfig(key), true
+	case kind == TokenTypeDeploy:
+		return c.permissions.deploy, true
+	default:
+		return c.permissions.jobs, true
+	}
+}
+
+// subjectLimits returns the reserved prefixes and account subjects that
+// restrict issued permissions.
+func (c *NATSClient) subjectLimits() (reservedPrefixes, accountSubjects []string) {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	return c.reservedPrefixes, c.accountSubjects
+}
+
+// gitlabScopes are the personal access token scopes known to GitLab, used to
+// render scope-conditional templates for startup validation.
+var gitlabScopes = []string{
+	"api", "read_api", "read_user", "create_runner", "manage_runner", "k8s_proxy",
+	"read_repository", "write_repository", "read_registry", "write_registry",
+	"read_virtual_registry", "write_virtual_registry", "ai_features",
+	"read_service_ping", "admin_mode", "sudo",
+}
+
+// placeholderRenders renders a subject template the ways startup validation
+// needs to see it: for a placeholder user with no scopes and tags, and with
+// every known scope and allowed client tag, so grants hidden behind scope or
+// tag conditions are checked too. Renders that fail or are empty are left out.
+func placeholderRenders(subject string) []string {
+	tagsCfg, _ := LoadClientTagsConfig()
+	samples := []permissionTemplateData{
+		{Username: "user", UserID: 1, Identity: "user"},
+		{Username: "user", UserID: 1, Identity: "user", Scopes: gitlabScopes, Tags: tagsCfg.placeholders()},
+	}
+
+	var out []string
+	for _, data := range samples {
+		rendered, err := renderPermissionTemplate(subject, data)
+		if err == nil && rendered != "" && !slices.Contains(out, rendered) {
+			out = append(out, rendered)
+		}
+	}
+	return out
+}
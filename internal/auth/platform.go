@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// internalSubjectPrefix is the subject namespace used for coordination
+// between Antal replicas (leader election, config and revocation
+// notifications). It is always reserved, so users can never be granted it.
+const internalSubjectPrefix = "antal.internal"
+
+// PlatformConfig holds the credentials of the platform connection used for
+// coordination subjects, separate from the auth callout connection.
+type PlatformConfig struct {
+	Enabled   bool
+	URL       string
+	User      string
+	Pass      string
+	CredsFile string
+}
+
+// LoadPlatformConfig reads the platform.* settings. The URL defaults to nats.url.
+func LoadPlatformConfig() PlatformConfig {
+	cfg := PlatformConfig{
+		Enabled:   viper.GetBool("platform.enabled"),
+		URL:       viper.GetString("platform.url"),
+		User:      viper.GetString("platform.user"),
+		Pass:      viper.GetString("platform.pass"),
+		CredsFile: viper.GetString("platform.creds"),
+	}
+	if cfg.URL == "" {
+		cfg.URL = viper.GetString("nats.url")
+	}
+	return cfg
+}
+
+// Validate checks that the platform connection has exactly one kind of credentials.
+func (cfg PlatformConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	hasUser := cfg.User != "" || cfg.Pass != ""
+	switch {
+	case hasUser && cfg.CredsFile != "":
+		return fmt.Errorf("platform: set either user/pass or creds, not both")
+	case hasUser && (cfg.User == "" || cfg.Pass == ""):
+		return fmt.Errorf("platform: user and pass must both be set")
+	case !hasUser && cfg.CredsFile == "":
+		return fmt.Errorf("platform: user/pass or creds are required")
+	}
+	return nil
+}
+
+// connectPlatform opens the platform connection, with the TLS settings of
+// the auth connection.
+func connectPlatform(logger *slog.Logger, cfg PlatformConfig, tlsConfig *tls.Config) (*nats.Conn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger = logger.With("connection", "platform")
+	creds := NATSCredentials{User: cfg.User, Pass: cfg.Pass, CredsFile: cfg.CredsFile}
+	opts := append(buildNATSOptions(logger, creds, tlsConfig), nats.Name("gcs_antal platform"))
+
+	nc, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect platform account: %w", err)
+	}
+	logger.Info("Connected platform account", "url", nc.ConnectedUrl())
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "nats",
+		Message:  "Connected platform account",
+		Level:    sentry.LevelInfo,
+		Data: map[string]interface{}{
+			"server": nc.ConnectedUrl(),
+		},
+	})
+	return nc, nil
+}
+
+// coordination returns the connection used for antal.internal subjects:
+// the platform connection when configured, the auth connection otherwise.
+func (c *NATSClient) coordination() *nats.Conn {
+	if c.platform != nil {
+		return c.platform
+	}
+	return c.nc
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPlatformConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("nats.url", "nats://auth:4222")
+	viper.Set("platform.enabled", true)
+	viper.Set("platform.user", "antal_platform")
+	viper.Set("platform.pass", "secret")
+
+	cfg := LoadPlatformConfig()
+	assert.Equal(t, "nats://auth:4222", cfg.URL, "defaults to nats.url")
+	assert.NoError(t, cfg.Validate())
+
+	viper.Set("platform.url", "nats://platform:4222")
+	assert.Equal(t, "nats://platform:4222", LoadPlatformConfig().URL)
+}
+
+func TestPlatformConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     PlatformConfig
+		wantErr bool
+	}{
+		{"disabled", PlatformConfig{}, false},
+		{"user and pass", PlatformConfig{Enabled: true, User: "u", Pass: "p"}, false},
+		{"creds", PlatformConfig{Enabled: true, CredsFile: "/etc/antal/platform.creds"}, false},
+		{"no credentials", PlatformConfig{Enabled: true}, true},
+		{"user without pass", PlatformConfig{Enabled: true, User: "u"}, true},
+		{"both", PlatformConfig{Enabled: true, User: "u", Pass: "p", CredsFile: "x.creds"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCoordinationConnection(t *testing.T) {
+	auth, platform := &nats.Conn{}, &nats.Conn{}
+
+	c := &NATSClient{nc: auth}
+	assert.Same(t, auth, c.coordination())
+
+	c.platform = platform
+	assert.Same(t, platform, c.coordination())
+}
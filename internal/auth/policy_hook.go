@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// What the issuance does when the policy module fails (policy_hook.on_error).
+const (
+	policyHookDeny = "deny"
+	policyHookSkip = "skip"
+)
+
+// PolicyHookConfig holds the policy_hook.* settings.
+type PolicyHookConfig struct {
+	// Module is the path of the .wasm file; empty disables the hook.
+	Module string
+	// Timeout bounds one evaluation, instantiation included.
+	Timeout time.Duration
+	// OnError is deny (deny the connection) or skip (issue the grant the
+	// module could not adjust).
+	OnError string
+}
+
+// LoadPolicyHookConfig reads the policy_hook.* settings.
+func LoadPolicyHookConfig() PolicyHookConfig {
+	return PolicyHookConfig{
+		Module:  strings.TrimSpace(viper.GetString("policy_hook.module")),
+		Timeout: viper.GetDuration("policy_hook.timeout"),
+		OnError: strings.ToLower(viper.GetString("policy_hook.on_error")),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg PolicyHookConfig) Validate() error {
+	switch {
+	case cfg.Module == "":
+		return nil
+	case cfg.Timeout <= 0:
+		return fmt.Errorf("policy_hook: timeout must be > 0")
+	case cfg.OnError != policyHookDeny && cfg.OnError != policyHookSkip:
+		return fmt.Errorf("policy_hook: on_error must be %q or %q, got %q", policyHookDeny, policyHookSkip, cfg.OnError)
+	}
+	return nil
+}
+
+// PolicyRules are the subjects of one direction in policy module JSON.
+type PolicyRules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// PolicyPermissions are a grant in policy module JSON.
+type PolicyPermissions struct {
+	Publish   PolicyRules `json:"publish"`
+	Subscribe PolicyRules `json:"subscribe"`
+}
+
+// PolicyLimits are connection limits in policy module JSON; 0 is unlimited
+// in the input and "keep" in the result.
+type PolicyLimits struct {
+	Subs    int64 `json:"subs,omitempty"`
+	Data    int64 `json:"data,omitempty"`
+	Payload int64 `json:"payload,omitempty"`
+}
+
+// PolicyInput is what the policy module receives: who is connecting, from
+// where, and the grant Antal is about to issue.
+type PolicyInput struct {
+	Username string `json:"username"`
+	// Principal is the team principal the user is issued as, if any.
+	Principal string   `json:"principal,omitempty"`
+	UserID    int64    `json:"user_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	// Project is the project of a CI/CD job token.
+	Project string `json:"project,omitempty"`
+	Account string `json:"account"`
+	// Source is how the token was verified: gitlab, cache or memory.
+	Source      string            `json:"source"`
+	ClientIP    string            `json:"client_ip,omitempty"`
+	ServerID    string            `json:"server_id"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Permissions PolicyPermissions `json:"permissions"`
+	Limits      PolicyLimits      `json:"limits"`
+}
+
+// PolicyResult is what the policy module returns. Allowed subjects are
+// added to the grant and denied ones removed; non-zero limits replace the
+// issued ones. An empty result changes nothing.
+type PolicyResult struct {
+	PolicyPermissions
+	Limits PolicyLimits `json:"limits"`
+}
+
+// apply adjusts a grant and its limits by the result.
+func (r PolicyResult) apply(set PermissionSet, limits RoleLimits) (PermissionSet, RoleLimits) {
+	set = set.Union(PermissionSet{
+		Publish:   SubjectRules{Allow: r.Publish.Allow},
+		Subscribe: SubjectRules{Allow: r.Subscribe.Allow},
+	}).Subtract(PermissionSet{
+		Publish:   SubjectRules{Deny: r.Publish.Deny},
+		Subscribe: SubjectRules{Deny: r.Subscribe.Deny},
+	})
+	return set, RoleLimits(r.Limits).or(limits)
+}
+
+// PolicyHook runs a WebAssembly policy module between verification and
+// issuance. The module exports its memory and two functions:
+//
+//	alloc(size i32) -> i32                  // a buffer for the input
+//	evaluate(ptr i32, len i32) -> i64       // (result ptr << 32) | result len
+//
+// The input is a PolicyInput and the result a PolicyResult, both JSON.
+// Every evaluation gets a fresh instance, so no state leaks between users,
+// and WASI is available for modules built with TinyGo or GOOS=wasip1
+// (reactors exporting _initialize).
+type PolicyHook struct {
+	cfg      PolicyHookConfig
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	// hash identifies the module in the JWT cache config hash.
+	hash   string
+	logger *slog.Logger
+}
+
+// NewPolicyHook compiles the module and checks its exports.
+func NewPolicyHook(cfg PolicyHookConfig, code []byte) (*PolicyHook, error) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("policy_hook: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("policy_hook: invalid module: %w", err)
+	}
+	functions := compiled.ExportedFunctions()
+	for _, name := range []string{"alloc", "evaluate"} {
+		if _, ok := functions[name]; !ok {
+			_ = runtime.Close(ctx)
+			return nil, fmt.Errorf("policy_hook: module does not export %s", name)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("policy_hook: module does not export memory")
+	}
+	sum := sha256.Sum256(code)
+	return &PolicyHook{
+		cfg:      cfg,
+		runtime:  runtime,
+		compiled: compiled,
+		hash:     hex.EncodeToString(sum[:]),
+		logger:   slog.With("component", "policy_hook"),
+	}, nil
+}
+
+// Evaluate runs the module on input.
+func (h *PolicyHook) Evaluate(ctx context.Context, input PolicyInput) (PolicyResult, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return PolicyResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	mod, err := h.runtime.InstantiateModule(ctx, h.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("failed to instantiate policy module: %w", err)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("policy module alloc: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, data) {
+		return PolicyResult{}, fmt.Errorf("policy module alloc returned %d, outside its memory", ptr)
+	}
+	res, err = mod.ExportedFunction("evaluate").Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("policy module evaluate: %w", err)
+	}
+	resultPtr, resultLen := uint32(res[0]>>32), uint32(res[0])
+	var result PolicyResult
+	if resultLen == 0 {
+		return result, nil
+	}
+	out, ok := mod.Memory().Read(resultPtr, resultLen)
+	if !ok {
+		return PolicyResult{}, fmt.Errorf("policy module result is outside its memory")
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return PolicyResult{}, fmt.Errorf("policy module result: %w", err)
+	}
+	return result, nil
+}
+
+// Close releases the runtime.
+func (h *PolicyHook) Close() {
+	_ = h.runtime.Close(context.Background())
+}
+
+// fingerprint identifies the module for the JWT cache config hash.
+func (h *PolicyHook) fingerprint() string {
+	if h == nil {
+		return ""
+	}
+	return h.hash
+}
+
+// policyInput describes an issuance to the policy module.
+func policyInput(result AuthorizeResult, decision authDecision, set PermissionSet, limits RoleLimits) PolicyInput {
+	return PolicyInput{
+		Username:  decision.Username,
+		Principal: decision.Principal,
+		UserID:    result.UserID(),
+		TokenType: result.TokenType(),
+		Scopes:    result.Scopes(),
+		Groups:    result.Groups(),
+		Project:   result.Project(),
+		Account:   decision.Account,
+		Source:    decision.Source,
+		ClientIP:  decision.ClientIP,
+		ServerID:  decision.ServerID,
+		Tags:      decision.Tags,
+		Permissions: PolicyPermissions{
+			Publish:   PolicyRules(set.Publish),
+			Subscribe: PolicyRules(set.Subscribe),
+		},
+		Limits: PolicyLimits(limits),
+	}
+}
+
+// applyPolicyHook lets the policy module adjust a grant before it is issued.
+// It returns false when the module failed and on_error denies.
+func (c *NATSClient) applyPolicyHook(ctx context.Context, result AuthorizeResult, decision authDecision, set PermissionSet, limits RoleLimits) (PermissionSet, RoleLimits, bool) {
+	if c.policyHook == nil {
+		return set, limits, true
+	}
+	start := time.Now()
+	adjust, err := c.policyHook.Evaluate(ctx, policyInput(result, decision, set, limits))
+	policyHookDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		policyHookEvaluationsTotal.WithLabelValues("error").Inc()
+		c.policyHook.logger.Error("Policy module failed", "username", decision.Username, "error", err, "on_error", c.policyHook.cfg.OnError)
+		return set, limits, c.policyHook.cfg.OnError == policyHookSkip
+	}
+	policyHookEvaluationsTotal.WithLabelValues("ok").Inc()
+	set, limits = adjust.apply(set, limits)
+	// The module may not grant what the config may not either.
+	return c.restrictPermissions(set, decision.Username), limits, true
+}
+
+// initPolicyHook optionally loads the WebAssembly policy module.
+func (c *NATSClient) initPolicyHook() error {
+	cfg := LoadPolicyHookConfig()
+	if cfg.Module == "" {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	code, err := os.ReadFile(cfg.Module)
+	if err != nil {
+		return fmt.Errorf("policy_hook: %w", err)
+	}
+	hook, err := NewPolicyHook(cfg, code)
+	if err != nil {
+		return err
+	}
+	c.policyHook = hook
+	c.logger.Info("Policy module loaded", "module", cfg.Module, "sha256", hook.hash, "timeout", cfg.Timeout, "on_error", cfg.OnError)
+	return nil
+}
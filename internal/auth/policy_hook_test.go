@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wasmSection encodes a module section; contents must stay below 128 bytes.
+func wasmSection(id byte, contents ...byte) []byte {
+	return append([]byte{id, byte(len(contents))}, contents...)
+}
+
+// policyModule assembles a policy module whose alloc returns offset 1024
+// and whose evaluate runs body, with result stored at offset 0. Result
+// lengths stay below 64 so i64.const needs a single byte.
+func policyModule(body []byte, result string) []byte {
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	// Types: (i32) -> i32 and (i32, i32) -> i64.
+	module = append(module, wasmSection(1, 2, 0x60, 1, 0x7f, 1, 0x7f, 0x60, 2, 0x7f, 0x7f, 1, 0x7e)...)
+	module = append(module, wasmSection(3, 2, 0, 1)...)
+	module = append(module, wasmSection(5, 1, 0x00, 1)...)
+	module = append(module, wasmSection(7, 3,
+		6, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0,
+		5, 'a', 'l', 'l', 'o', 'c', 0x00, 0,
+		8, 'e', 'v', 'a', 'l', 'u', 'a', 't', 'e', 0x00, 1)...)
+	alloc := []byte{0, 0x41, 0x80, 0x08, 0x0b} // i32.const 1024
+	evaluate := append([]byte{0}, body...)
+	code := append([]byte{2, byte(len(alloc))}, alloc...)
+	code = append(append(code, byte(len(evaluate))), evaluate...)
+	module = append(module, wasmSection(10, code...)...)
+	data := append([]byte{1, 0x00, 0x41, 0, 0x0b, byte(len(result))}, result...)
+	return append(module, wasmSection(11, data...)...)
+}
+
+// returnResult returns the result at offset 0 with its length.
+func returnResult(result string) []byte {
+	return []byte{0x42, byte(len(result)), 0x0b}
+}
+
+var (
+	trapBody = []byte{0x00, 0x0b}
+	loopBody = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b}
+)
+
+func newTestPolicyHook(t *testing.T, body []byte, result, onError string) *PolicyHook {
+	t.Helper()
+	hook, err := NewPolicyHook(PolicyHookConfig{Module: "test.wasm", Timeout: 200 * time.Millisecond, OnError: onError}, policyModule(body, result))
+	require.NoError(t, err)
+	t.Cleanup(hook.Close)
+	return hook
+}
+
+func TestPolicyHook_Evaluate(t *testing.T) {
+	const result = `{"publish":{"allow":["audit.>"]},"limits":{"subs":10}}`
+	hook := newTestPolicyHook(t, returnResult(result), result, policyHookDeny)
+
+	adjust, err := hook.Evaluate(context.Background(), PolicyInput{Username: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"audit.>"}, adjust.Publish.Allow)
+	assert.Equal(t, int64(10), adjust.Limits.Subs)
+
+	set, limits := adjust.apply(
+		PermissionSet{Publish: SubjectRules{Allow: []string{"orders.>"}}, Subscribe: SubjectRules{Allow: []string{"_INBOX.>"}}},
+		RoleLimits{Subs: 100, Payload: 1024},
+	)
+	assert.ElementsMatch(t, []string{"audit.>", "orders.>"}, set.Publish.Allow)
+	assert.Equal(t, RoleLimits{Subs: 10, Payload: 1024}, limits, "zero limits keep the issued ones")
+
+	set, _ = PolicyResult{PolicyPermissions: PolicyPermissions{Publish: PolicyRules{Deny: []string{"orders.>"}}}}.apply(set, limits)
+	assert.Equal(t, []string{"audit.>"}, set.Publish.Allow)
+}
+
+func TestPolicyHook_Failures(t *testing.T) {
+	hook := newTestPolicyHook(t, trapBody, "", policyHookDeny)
+	_, err := hook.Evaluate(context.Background(), PolicyInput{})
+	assert.ErrorContains(t, err, "evaluate")
+
+	hook = newTestPolicyHook(t, loopBody, "", policyHookDeny)
+	start := time.Now()
+	_, err = hook.Evaluate(context.Background(), PolicyInput{})
+	assert.Error(t, err, "the timeout stops a module that never returns")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	hook = newTestPolicyHook(t, returnResult("not json"), "not json", policyHookDeny)
+	_, err = hook.Evaluate(context.Background(), PolicyInput{})
+	assert.ErrorContains(t, err, "policy module result")
+
+	_, err = NewPolicyHook(PolicyHookConfig{}, []byte("not wasm"))
+	assert.ErrorContains(t, err, "invalid module")
+}
+
+func TestApplyPolicyHook(t *testing.T) {
+	const result = `{"subscribe":{"allow":["antal.internal.>","metrics.>"]}}`
+	c := &NATSClient{logger: slog.Default(), reservedPrefixes: []string{internalSubjectPrefix}, policyHook: newTestPolicyHook(t, returnResult(result), result, policyHookDeny)}
+	set := PermissionSet{Subscribe: SubjectRules{Allow: []string{"_INBOX.>"}}}
+
+	adjusted, _, ok := c.applyPolicyHook(context.Background(), AuthorizeResult{}, authDecision{Username: "alice"}, set, RoleLimits{})
+	require.True(t, ok)
+	assert.Equal(t, []string{"_INBOX.>", "metrics.>"}, adjusted.Subscribe.Allow, "reserved subjects granted by the module are removed")
+
+	c.policyHook = newTestPolicyHook(t, trapBody, "", policyHookDeny)
+	_, _, ok = c.applyPolicyHook(context.Background(), AuthorizeResult{}, authDecision{Username: "alice"}, set, RoleLimits{})
+	assert.False(t, ok, "on_error: deny")
+
+	c.policyHook = newTestPolicyHook(t, trapBody, "", policyHookSkip)
+	unchanged, _, ok := c.applyPolicyHook(context.Background(), AuthorizeResult{}, authDecision{Username: "alice"}, set, RoleLimits{})
+	assert.True(t, ok, "on_error: skip")
+	assert.Equal(t, set, unchanged)
+}
+
+func TestPolicyInput(t *testing.T) {
+	result := AuthorizeResult{Verified: &VerifiedToken{Username: "alice", UserID: 42, TokenType: TokenTypePersonal, Groups: []string{"payments"}}}
+	decision := authDecision{Username: "alice", Account: "APP", Source: "gitlab", ServerID: "NSERVER", Tags: map[string]string{"app": "billing"}}
+	input := policyInput(result, decision, PermissionSet{Publish: SubjectRules{Allow: []string{"orders.>"}}}, RoleLimits{Subs: 100})
+
+	data, err := json.Marshal(input)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"username": "alice", "user_id": 42, "token_type": "personal_access_token", "groups": ["payments"],
+		"account": "APP", "source": "gitlab", "server_id": "NSERVER", "tags": {"app": "billing"},
+		"permissions": {"publish": {"allow": ["orders.>"]}, "subscribe": {}},
+		"limits": {"subs": 100}
+	}`, string(data))
+}
+
+func TestPolicyHookConfig_Validate(t *testing.T) {
+	require.NoError(t, PolicyHookConfig{}.Validate(), "disabled")
+	require.NoError(t, PolicyHookConfig{Module: "p.wasm", Timeout: time.Millisecond, OnError: policyHookSkip}.Validate())
+	assert.ErrorContains(t, PolicyHookConfig{Module: "p.wasm", OnError: policyHookDeny}.Validate(), "timeout")
+	assert.ErrorContains(t, PolicyHookConfig{Module: "p.wasm", Timeout: time.Millisecond, OnError: "allow"}.Validate(), "on_error")
+}
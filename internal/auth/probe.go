@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// Probe failure reasons, used as metric label values.
+const (
+	probeFailAuthorization = "authorization"
+	probeFailTimeout       = "timeout"
+	probeFailConnection    = "connection"
+)
+
+// ProbeConfig configures the canary authentication probe.
+type ProbeConfig struct {
+	Enabled  bool
+	URL      string
+	Username string
+	Token    string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// LoadProbeConfig reads the probe.* settings. The URL defaults to nats.url.
+func LoadProbeConfig() ProbeConfig {
+	cfg := ProbeConfig{
+		Enabled:  viper.GetBool("probe.enabled"),
+		URL:      viper.GetString("probe.url"),
+		Username: viper.GetString("probe.username"),
+		Token:    viper.GetString("probe.token"),
+		Interval: viper.GetDuration("probe.interval"),
+		Timeout:  viper.GetDuration("probe.timeout"),
+	}
+	if cfg.URL == "" {
+		cfg.URL = viper.GetString("nats.url")
+	}
+	return cfg
+}
+
+// Validate checks an enabled probe configuration.
+func (cfg ProbeConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Username == "" || cfg.Token == "" {
+		return fmt.Errorf("probe: username and token are required")
+	}
+	if cfg.Interval <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("probe: interval and timeout must be > 0")
+	}
+	return nil
+}
+
+// Prober periodically connects to NATS as a dedicated probe user, exercising
+// the whole path (NATS server, auth callout, GitLab, JWT issuance) the way a
+// real client does.
+type Prober struct {
+	cfg     ProbeConfig
+	connect func(url string, options ...nats.Option) (*nats.Conn, error)
+	logger  *slog.Logger
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewProber creates a prober; call Start to begin probing.
+func NewProber(cfg ProbeConfig) (*Prober, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Prober{
+		cfg:     cfg,
+		connect: nats.Connect,
+		logger:  slog.With("component", "probe"),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Start probes immediately and then every interval until Stop.
+func (p *Prober) Start() {
+	p.logger.Info("Starting canary authentication probe", "username", p.cfg.Username, "interval", p.cfg.Interval)
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			p.probe()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends probing and waits for a running probe to finish.
+func (p *Prober) Stop() {
+	close(p.stop)
+	p.done.Wait()
+}
+
+// probe performs one authentication round trip and records the outcome.
+func (p *Prober) probe() {
+	start := time.Now()
+	err := p.roundTrip()
+	elapsed := time.Since(start)
+
+	if err == nil {
+		probeUp.Set(1)
+		probeDuration.Observe(elapsed.Seconds())
+		probeLastSuccess.SetToCurrentTime()
+		p.logger.Debug("Probe succeeded", "duration", elapsed)
+		return
+	}
+
+	reason := probeFailureReason(err)
+	probeUp.Set(0)
+	probeFailuresTotal.WithLabelValues(reason).Inc()
+	p.logger.Warn("Probe failed", "reason", reason, "duration", elapsed, "error", err)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("probe_failure", reason)
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureException(fmt.Errorf("canary authentication probe failed: %w", err))
+	})
+}
+
+// roundTrip connects as the probe user and confirms the connection with a flush.
+func (p *Prober) roundTrip() error {
+	nc, err := p.connect(p.cfg.URL,
+		nats.UserInfo(p.cfg.Username, p.cfg.Token),
+		nats.Name("gcs_antal probe"),
+		nats.Timeout(p.cfg.Timeout),
+		nats.MaxReconnects(0),
+		nats.NoCallbacksAfterClientClose(),
+	)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	return nc.FlushTimeout(p.cfg.Timeout)
+}
+
+// probeFailureReason classifies a probe error.
+func probeFailureReason(err error) string {
+	switch {
+	case errors.Is(err, nats.ErrAuthorization), errors.Is(err, nats.ErrAuthExpired), errors.Is(err, nats.ErrAuthRevoked):
+		return probeFailAuthorization
+	case errors.Is(err, nats.ErrTimeout):
+		return probeFailTimeout
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return probeFailTimeout
+	}
+	return probeFailConnection
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProbeConfig() ProbeConfig {
+	return ProbeConfig{
+		Enabled:  true,
+		URL:      "nats://localhost:4222",
+		Username: "antal-probe",
+		Token:    "glpat-probe",
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+	}
+}
+
+func TestLoadProbeConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("nats.url", "nats://auth:4222")
+	viper.Set("probe.enabled", true)
+	viper.Set("probe.username", "antal-probe")
+	viper.Set("probe.token", "glpat-probe")
+	viper.Set("probe.interval", "1m")
+	viper.Set("probe.timeout", "5s")
+
+	cfg := LoadProbeConfig()
+	assert.Equal(t, "nats://auth:4222", cfg.URL)
+	assert.Equal(t, time.Minute, cfg.Interval)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Token = ""
+	assert.Error(t, cfg.Validate())
+	cfg = testProbeConfig()
+	cfg.Interval = 0
+	assert.Error(t, cfg.Validate())
+	assert.NoError(t, ProbeConfig{}.Validate(), "disabled")
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string { return "i/o timeout" }
+func (timeoutErr) Timeout() bool { return true }
+
+func TestProbeFailureReason(t *testing.T) {
+	assert.Equal(t, probeFailAuthorization, probeFailureReason(nats.ErrAuthorization))
+	assert.Equal(t, probeFailAuthorization, probeFailureReason(fmt.Errorf("connect: %w", nats.ErrAuthorization)))
+	assert.Equal(t, probeFailTimeout, probeFailureReason(nats.ErrTimeout))
+	assert.Equal(t, probeFailTimeout, probeFailureReason(&net.OpError{Op: "dial", Err: timeoutErr{}}))
+	assert.Equal(t, probeFailConnection, probeFailureReason(nats.ErrNoServers))
+	assert.Equal(t, probeFailConnection, probeFailureReason(errors.New("boom")))
+}
+
+func TestProber_RecordsFailures(t *testing.T) {
+	p, err := NewProber(testProbeConfig())
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	p.connect = func(url string, options ...nats.Option) (*nats.Conn, error) {
+		calls.Add(1)
+		assert.Equal(t, "nats://localhost:4222", url)
+		return nil, nats.ErrAuthorization
+	}
+
+	before := testutil.ToFloat64(probeFailuresTotal.WithLabelValues(probeFailAuthorization))
+	p.Start()
+	assert.Eventually(t, func() bool { return calls.Load() >= 2 }, time.Second, 5*time.Millisecond)
+	p.Stop()
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(probeFailuresTotal.WithLabelValues(probeFailAuthorization))-before, 2.0)
+	assert.Zero(t, testutil.ToFloat64(probeUp))
+
+	// No probes after Stop.
+	n := calls.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, calls.Load())
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// panicFlushTimeout bounds how long a recovered panic waits for Sentry.
+const panicFlushTimeout = 2 * time.Second
+
+// recoverHandler wraps a NATS message handler so that a panic is logged,
+// reported to Sentry and counted instead of crashing the service (and with
+// it the only way users can authenticate). onPanic, when set, runs after the
+// panic was reported.
+func (c *NATSClient) recoverHandler(name string, handler nats.MsgHandler, onPanic func(msg *nats.Msg)) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			handlerPanicsTotal.WithLabelValues(name).Inc()
+			c.logger.Error("Recovered from panic in NATS handler",
+				"handler", name,
+				"subject", msg.Subject,
+				"panic", r,
+				"stack", string(debug.Stack()),
+			)
+
+			hub := sentry.CurrentHub().Clone()
+			hub.ConfigureScope(func(scope *sentry.Scope) {
+				scope.SetTag("error_type", "panic")
+				scope.SetTag("handler", name)
+				scope.SetContext("message", sentry.Context{"subject": msg.Subject, "data_length": len(msg.Data)})
+				scope.SetLevel(sentry.LevelFatal)
+			})
+			hub.Recover(r)
+			hub.Flush(panicFlushTimeout)
+
+			if onPanic != nil {
+				onPanic(msg)
+			}
+		}()
+		handler(msg)
+	}
+}
+
+// denyAfterPanic answers an auth request whose handler panicked, so the
+// client gets a deny right away instead of waiting for the callout timeout.
+func (c *NATSClient) denyAfterPanic(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("Failed to deny auth request after panic", "panic", r)
+		}
+	}()
+
+	var userNkey, serverId, username string
+	if data, _, err := c.openRequest(msg); err == nil {
+		if rc, err := jwt.DecodeAuthorizationRequestClaims(string(data)); err == nil {
+			userNkey, serverId, username = rc.UserNkey, rc.Server.ID, rc.ConnectOptions.Username
+		}
+	}
+	c.respondMsg(msg, userNkey, serverId, "", c.denyResponse(DenyInternalError, "internal error", username))
+	exportDecision(authDecision{Username: username, ServerID: serverId, Code: DenyInternalError, Reason: "internal error"})
+}
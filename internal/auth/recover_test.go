@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+
+	var denied *nats.Msg
+	handler := c.recoverHandler("test", func(msg *nats.Msg) {
+		var m map[string]int
+		m["boom"]++ // nil map write
+	}, func(msg *nats.Msg) { denied = msg })
+
+	before := testutil.ToFloat64(handlerPanicsTotal.WithLabelValues("test"))
+	msg := &nats.Msg{Subject: "$SYS.REQ.USER.AUTH", Data: []byte("request")}
+	assert.NotPanics(t, func() { handler(msg) })
+	assert.Same(t, msg, denied)
+	assert.Equal(t, before+1, testutil.ToFloat64(handlerPanicsTotal.WithLabelValues("test")))
+
+	// Handlers that do not panic are untouched.
+	called := false
+	c.recoverHandler("test", func(*nats.Msg) { called = true }, func(*nats.Msg) { t.Fatal("unexpected onPanic") })(msg)
+	assert.True(t, called)
+	assert.Equal(t, before+1, testutil.ToFloat64(handlerPanicsTotal.WithLabelValues("test")))
+}
+
+func TestDenyAfterPanic_DoesNotPanic(t *testing.T) {
+	// Without a connection respondMsg fails; the failure must stay contained.
+	c := &NATSClient{logger: slog.Default()}
+	assert.NotPanics(t, func() { c.denyAfterPanic(&nats.Msg{Data: []byte("garbage")}) })
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// instanceAnnounceSubject carries the announcements replicas publish on
+// startup. It is a coordination subject, never granted to users.
+const instanceAnnounceSubject = internalSubjectPrefix + ".instance.announce"
+
+// ReplicaCheckConfig configures the startup comparison of signing keys
+// between replicas.
+type ReplicaCheckConfig struct {
+	Enabled bool
+	// Cluster names the fleet; only replicas announcing the same cluster are
+	// compared, so separate deployments may share a NATS system.
+	Cluster string
+	// Wait is how long a starting replica collects the answers of the others.
+	Wait time.Duration
+}
+
+// LoadReplicaCheckConfig reads the replica_check.* settings.
+func LoadReplicaCheckConfig() ReplicaCheckConfig {
+	return ReplicaCheckConfig{
+		Enabled: viper.GetBool("replica_check.enabled"),
+		Cluster: viper.GetString("replica_check.cluster"),
+		Wait:    viper.GetDuration("replica_check.wait"),
+	}
+}
+
+// instanceAnnouncement describes the keys a replica uses.
+type instanceAnnouncement struct {
+	Instance        string    `json:"instance"`
+	Cluster         string    `json:"cluster"`
+	IssuerPublicKey string    `json:"issuer_public_key"`
+	XKeyPublicKey   string    `json:"xkey_public_key,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+}
+
+// newInstanceID returns a name for this process that is unique across
+// replicas, even when they share a hostname.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "antal"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// announcement returns the announcement of this replica.
+func (c *NATSClient) announcement(cluster string) instanceAnnouncement {
+	a := instanceAnnouncement{Instance: c.instanceID, Cluster: cluster, StartedAt: c.startedAt}
+	a.IssuerPublicKey, _ = c.issuer().PublicKey()
+	if c.xKeyPair != nil {
+		a.XKeyPublicKey, _ = c.xKeyPair.PublicKey()
+	}
+	return a
+}
+
+// announceInstance publishes this replica's keys to the fleet. Every other
+// replica compares them with its own and answers with its announcement, so
+// a mismatch is reported on both sides: by the replicas already running and
+// by the one starting.
+func (c *NATSClient) announceInstance(cfg ReplicaCheckConfig) error {
+	nc := c.coordination()
+	if _, err := nc.Subscribe(instanceAnnounceSubject, c.recoverHandler("instance_announce", c.handleInstanceAnnouncement, nil)); err != nil {
+		return fmt.Errorf("failed to subscribe to instance announcements: %w", err)
+	}
+
+	inbox := nc.NewRespInbox()
+	replies, err := nc.Subscribe(inbox, c.recoverHandler("instance_announce_reply", c.handleInstanceAnnouncement, nil))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to instance announcement replies: %w", err)
+	}
+	time.AfterFunc(cfg.Wait, func() { _ = replies.Unsubscribe() })
+
+	data, _ := json.Marshal(c.announcement(cfg.Cluster))
+	if err := nc.PublishRequest(instanceAnnounceSubject, inbox, data); err != nil {
+		return fmt.Errorf("failed to publish instance announcement: %w", err)
+	}
+	c.logger.Info("Announced instance to the fleet", "instance", c.instanceID, "cluster", cfg.Cluster)
+	return nil
+}
+
+// handleInstanceAnnouncement compares the keys of another replica with ours
+// and answers announcements that expect a reply.
+func (c *NATSClient) handleInstanceAnnouncement(msg *nats.Msg) {
+	var peer instanceAnnouncement
+	if err := json.Unmarshal(msg.Data, &peer); err != nil {
+		c.logger.Warn("Ignoring malformed instance announcement", "error", err)
+		return
+	}
+	cluster := LoadReplicaCheckConfig().Cluster
+	if peer.Instance == c.instanceID || peer.Cluster != cluster {
+		return
+	}
+
+	own := c.announcement(cluster)
+	c.compareAnnouncement(own, peer)
+
+	if msg.Reply != "" {
+		data, _ := json.Marshal(own)
+		if err := msg.Respond(data); err != nil {
+			c.logger.Warn("Failed to answer instance announcement", "instance", peer.Instance, "error", err)
+		}
+	}
+}
+
+// compareAnnouncement alerts when a replica of the same cluster uses a
+// different issuer or xkey, and returns the mismatched keys. Half the fleet
+// signing with another key after a partial rollout fails authentication
+// for the clients those replicas serve.
+func (c *NATSClient) compareAnnouncement(own, peer instanceAnnouncement) []string {
+	var mismatched []string
+	if own.IssuerPublicKey != peer.IssuerPublicKey {
+		mismatched = append(mismatched, "issuer")
+	}
+	if own.XKeyPublicKey != peer.XKeyPublicKey {
+		mismatched = append(mismatched, "xkey")
+	}
+
+	for _, key := range mismatched {
+		replicaKeyMismatch.WithLabelValues(key).Set(1)
+	}
+	if len(mismatched) == 0 {
+		c.logger.Debug("Replica uses the same keys", "instance", peer.Instance)
+		return nil
+	}
+
+	attrs := []any{
+		"keys", mismatched,
+		"instance", own.Instance,
+		"peer_instance", peer.Instance,
+		"cluster", own.Cluster,
+		"issuer_public_key", own.IssuerPublicKey,
+		"peer_issuer_public_key", peer.IssuerPublicKey,
+		"xkey_public_key", own.XKeyPublicKey,
+		"peer_xkey_public_key", peer.XKeyPublicKey,
+	}
+	c.logger.Error("Replica uses different signing keys, the fleet is misconfigured", attrs...)
+	audit("replica_check", "key_mismatch", attrs...)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelFatal)
+		scope.SetTag("error_type", "replica_key_mismatch")
+		scope.SetContext("replica_check", sentry.Context{
+			"keys":                   mismatched,
+			"peer_instance":          peer.Instance,
+			"issuer_public_key":      own.IssuerPublicKey,
+			"peer_issuer_public_key": peer.IssuerPublicKey,
+			"xkey_public_key":        own.XKeyPublicKey,
+			"peer_xkey_public_key":   peer.XKeyPublicKey,
+		})
+		sentry.CaptureMessage(fmt.Sprintf("Replica %s uses a different %s key", peer.Instance, strings.Join(mismatched, " and ")))
+	})
+	return mismatched
+}
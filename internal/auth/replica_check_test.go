@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAnnouncement(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	issuer, _, issuerPub := newAccountKey(t)
+	xkey, err := nkeys.CreateCurveKeys()
+	require.NoError(t, err)
+	xkeyPub, err := xkey.PublicKey()
+	require.NoError(t, err)
+
+	c := &NATSClient{issuerSigner: NewKeyPairSigner(issuer), xKeyPair: xkey, instanceID: "antal-1", logger: slog.Default()}
+	own := c.announcement("prod")
+	assert.Equal(t, instanceAnnouncement{Instance: "antal-1", Cluster: "prod", IssuerPublicKey: issuerPub, XKeyPublicKey: xkeyPub}, own)
+
+	peer := own
+	peer.Instance = "antal-2"
+	assert.Empty(t, c.compareAnnouncement(own, peer))
+
+	peer.XKeyPublicKey = ""
+	assert.Equal(t, []string{"xkey"}, c.compareAnnouncement(own, peer), "a replica without xkey cannot open encrypted requests")
+
+	peer.IssuerPublicKey = "AOTHER"
+	assert.Equal(t, []string{"issuer", "xkey"}, c.compareAnnouncement(own, peer))
+	assert.Equal(t, float64(1), testutil.ToFloat64(replicaKeyMismatch.WithLabelValues("issuer")))
+}
+
+func TestHandleInstanceAnnouncement(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	replicaKeyMismatch.Reset()
+
+	issuer, _, _ := newAccountKey(t)
+	c := &NATSClient{issuerSigner: NewKeyPairSigner(issuer), instanceID: "antal-1", logger: slog.Default()}
+	msg := func(a instanceAnnouncement) *nats.Msg {
+		data, err := json.Marshal(a)
+		require.NoError(t, err)
+		return &nats.Msg{Data: data}
+	}
+
+	t.Run("ignores its own announcement", func(t *testing.T) {
+		c.handleInstanceAnnouncement(msg(instanceAnnouncement{Instance: "antal-1", IssuerPublicKey: "AOTHER"}))
+		assert.Equal(t, 0, testutil.CollectAndCount(replicaKeyMismatch))
+	})
+
+	t.Run("ignores other clusters", func(t *testing.T) {
+		c.handleInstanceAnnouncement(msg(instanceAnnouncement{Instance: "staging-1", Cluster: "staging", IssuerPublicKey: "AOTHER"}))
+		assert.Equal(t, 0, testutil.CollectAndCount(replicaKeyMismatch))
+	})
+
+	t.Run("ignores malformed announcements", func(t *testing.T) {
+		c.handleInstanceAnnouncement(&nats.Msg{Data: []byte("{")})
+		assert.Equal(t, 0, testutil.CollectAndCount(replicaKeyMismatch))
+	})
+
+	t.Run("alerts on a replica of the same cluster with another issuer", func(t *testing.T) {
+		c.handleInstanceAnnouncement(msg(instanceAnnouncement{Instance: "antal-2", IssuerPublicKey: "AOTHER"}))
+		assert.Equal(t, float64(1), testutil.ToFloat64(replicaKeyMismatch.WithLabelValues("issuer")))
+	})
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// defaultReservedPrefixes lists the subject namespaces that must never be
+// granted to authenticated users, regardless of templates or overrides.
+var defaultReservedPrefixes = []string{"$SYS", "$JS", "antal", "audit"}
+
+// LoadReservedPrefixes returns the reserved subject prefixes from configuration,
+// falling back to the built-in defaults when none are configured.
+func LoadReservedPrefixes() []string {
+	prefixes := viper.GetStringSlice("nats.permissions.reserved_prefixes")
+	if len(prefixes) == 0 {
+		return defaultReservedPrefixes
+	}
+
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		// Accept "antal", "antal." and "antal.>" as the same prefix.
+		p = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(p), ">"), ".")
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	// Coordination subjects stay reserved even if "antal" is not.
+	if _, ok := reservedPrefixFor(internalSubjectPrefix, out); !ok {
+		out = append(out, internalSubjectPrefix)
+	}
+	return out
+}
+
+// reservedPrefixFor reports which reserved prefix (if any) the subject's
+// namespace falls into. Only literal leading tokens are matched; wildcard
+// subjects are restricted by subtracting reservedDenySubjects.
+func reservedPrefixFor(subject string, reserved []string) (string, bool) {
+	for _, p := range reserved {
+		if subject == p || strings.HasPrefix(subject, p+".") {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// reservedDenySubjects returns the subjects covering every reserved
+// namespace, subtracted from every user's permissions.
+func reservedDenySubjects(reserved []string) []string {
+	out := make([]string, 0, len(reserved)*2)
+	for _, p := range reserved {
+		out = append(out, p, p+".>")
+	}
+	return out
+}
+
+// ValidateReservedSubjects checks the configured allow lists against the
+// reserved prefixes. Templates are rendered with a placeholder username (with
+// and without scopes) so that literal reserved namespaces hidden behind
+// template syntax are caught too.
+func ValidateReservedSubjects(reserved []string) error {
+	var conflicts []string
+	for _, list := range configuredAllowLists() {
+		for _, subject := range list.subjects {
+			renders := placeholderRenders(subject)
+			if len(renders) == 0 {
+				renders = []string{subject}
+			}
+			for _, rendered := range renders {
+				if p, hit := reservedPrefixFor(rendered, reserved); hit {
+					conflicts = append(conflicts, fmt.Sprintf("%s: %q (reserved prefix %q)", list.key, subject, p))
+					break
+				}
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("permissions grant reserved subjects: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadReservedPrefixes(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	assert.Equal(t, defaultReservedPrefixes, LoadReservedPrefixes())
+
+	viper.Set("nats.permissions.reserved_prefixes", []string{"$SYS.>", "antal.", " audit ", ""})
+	assert.Equal(t, []string{"$SYS", "antal", "audit"}, LoadReservedPrefixes())
+
+	// Coordination subjects stay reserved without "antal".
+	viper.Set("nats.permissions.reserved_prefixes", []string{"$SYS"})
+	assert.Equal(t, []string{"$SYS", "antal.internal"}, LoadReservedPrefixes())
+}
+
+func TestReservedPrefixFor(t *testing.T) {
+	reserved := []string{"$SYS", "antal"}
+
+	tests := []struct {
+		subject string
+		prefix  string
+		hit     bool
+	}{
+		{"$SYS", "$SYS", true},
+		{"$SYS.REQ.USER.AUTH", "$SYS", true},
+		{"antal.internal.>", "antal", true},
+		{"antalx.foo", "", false},
+		{"user.antal", "", false},
+		{">", "", false},
+	}
+	for _, tt := range tests {
+		prefix, hit := reservedPrefixFor(tt.subject, reserved)
+		assert.Equal(t, tt.hit, hit, tt.subject)
+		assert.Equal(t, tt.prefix, prefix, tt.subject)
+	}
+}
+
+func TestValidateReservedSubjects(t *testing.T) {
+	reserved := []string{"$SYS", "$JS", "antal", "audit"}
+
+	t.Run("clean config passes", func(t *testing.T) {
+		viper.Reset()
+		t.Cleanup(viper.Reset)
+		viper.Set("nats.permissions.publish.allow", []string{"topic.>", "user.{{.Username}}.>"})
+		viper.Set("nats.permissions.subscribe.deny", []string{"$SYS.>"})
+
+		require.NoError(t, ValidateReservedSubjects(reserved))
+	})
+
+	t.Run("literal and templated reserved grants fail", func(t *testing.T) {
+		viper.Reset()
+		t.Cleanup(viper.Reset)
+		viper.Set("nats.permissions.publish.allow", []string{"$JS.API.>"})
+		viper.Set("nats.permissions.subscribe.allow", []string{`{{"audit"}}.{{.Username}}`})
+
+		err := ValidateReservedSubjects(reserved)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `"$JS.API.>"`)
+		assert.Contains(t, err.Error(), `reserved prefix "audit"`)
+	})
+
+	t.Run("grants behind scope conditions fail", func(t *testing.T) {
+		viper.Reset()
+		t.Cleanup(viper.Reset)
+		viper.Set("nats.permissions.publish.allow", []string{`{{if .HasScope "api"}}antal.admin{{end}}`})
+
+		err := ValidateReservedSubjects(reserved)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `reserved prefix "antal"`)
+	})
+}
+
+func TestRestrictPermissions_RemovesReservedNamespaces(t *testing.T) {
+	set := PermissionSet{
+		Publish:   SubjectRules{Allow: []string{"user.antal.>", "antal.x"}},
+		Subscribe: SubjectRules{Allow: []string{">"}, Deny: []string{"private.>"}},
+	}
+
+	c := &NATSClient{logger: slog.Default(), reservedPrefixes: []string{"$SYS", "antal"}}
+	set = c.restrictPermissions(set, "antal")
+
+	assert.Equal(t, []string{"user.antal.>"}, set.Publish.Allow)
+	assert.Empty(t, set.Publish.Deny)
+	assert.Equal(t, []string{">"}, set.Subscribe.Allow)
+	assert.ElementsMatch(t, []string{"private.>", "$SYS", "$SYS.>", "antal", "antal.>"}, set.Subscribe.Deny)
+}
+
+func TestRestrictPermissions_DeniesAllWhenNothingRemains(t *testing.T) {
+	c := &NATSClient{logger: slog.Default(), reservedPrefixes: []string{"antal"}}
+	set := c.restrictPermissions(AllowOnly("antal.>"), "alice")
+
+	var perms jwt.Permissions
+	set.Apply(&perms)
+	assert.Empty(t, perms.Pub.Allow)
+	assert.Equal(t, jwt.StringList{">"}, perms.Pub.Deny)
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Golden keys derived from fixed raw seeds, so the expected wire format below
+// does not depend on randomly generated keys.
+const (
+	goldenIssuer = "ACFIRY65OQE7DFP5KLNS2PF2LVZMUZYJX4OZIEQ36N2IQANUB5XVYYOU"
+	goldenUser   = "UCATS5YOVB6ROX2WUNKGNQ2MP3GMXDMKSG2O4N5CLX3A6W4PZGZZJPJO"
+	// goldenHeader is the base64url JWT header {"typ":"JWT","alg":"ed25519-nkey"}.
+	goldenHeader = "eyJ0eXAiOiJKV1QiLCJhbGciOiJlZDI1NTE5LW5rZXkifQ"
+)
+
+func goldenKeyPair(t *testing.T, prefix nkeys.PrefixByte, b byte) nkeys.KeyPair {
+	t.Helper()
+	kp, err := nkeys.FromRawSeed(prefix, bytes.Repeat([]byte{b}, 32))
+	require.NoError(t, err)
+	return kp
+}
+
+func goldenClient(t *testing.T) *NATSClient {
+	t.Helper()
+	return &NATSClient{logger: slog.Default(), issuerSigner: NewKeyPairSigner(goldenKeyPair(t, nkeys.PrefixByteAccount, 1))}
+}
+
+// decodeGoldenResponse splits a response JWT, checks the header and the
+// signature, and returns the decoded claims and the raw payload.
+func decodeGoldenResponse(t *testing.T, token string) (*jwt.AuthorizationResponseClaims, string) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	assert.Equal(t, goldenHeader, parts[0])
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+
+	// Decoding verifies the signature against the issuer in the claims.
+	claims, err := jwt.DecodeAuthorizationResponseClaims(token)
+	require.NoError(t, err)
+	return claims, string(payload)
+}
+
+func TestBuildResponse_GoldenVectors(t *testing.T) {
+	user, err := goldenKeyPair(t, nkeys.PrefixByteUser, 2).PublicKey()
+	require.NoError(t, err)
+	require.Equal(t, goldenUser, user)
+
+	tests := []struct {
+		name     string
+		serverID string
+		userJWT  string
+		errMsg   string
+		// payload is the exact claims JSON, with the time-dependent jti and
+		// iat filled in from the decoded token.
+		payload string
+	}{
+		{
+			name:     "success",
+			serverID: "NSERVER",
+			userJWT:  "user.jwt.token",
+			payload:  `{"aud":"NSERVER","jti":"%s","iat":%d,"iss":"` + goldenIssuer + `","sub":"` + goldenUser + `","nats":{"jwt":"user.jwt.token","type":"authorization_response","version":2}}`,
+		},
+		{
+			name:     "error",
+			serverID: "NSERVER",
+			errMsg:   denyMessage(DenyInvalidCredentials, "invalid credentials"),
+			payload:  `{"aud":"NSERVER","jti":"%s","iat":%d,"iss":"` + goldenIssuer + `","sub":"` + goldenUser + `","nats":{"error":"invalid_credentials: invalid credentials","type":"authorization_response","version":2}}`,
+		},
+		{
+			name:    "no server id omits the audience",
+			errMsg:  denyMessage(DenyInternalError, "internal error"),
+			payload: `{"jti":"%s","iat":%d,"iss":"` + goldenIssuer + `","sub":"` + goldenUser + `","nats":{"error":"internal_error: internal error","type":"authorization_response","version":2}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := goldenClient(t).buildResponse(goldenUser, tt.serverID, tt.userJWT, tt.errMsg)
+			require.NoError(t, err)
+
+			claims, payload := decodeGoldenResponse(t, token)
+			assert.Equal(t, fmt.Sprintf(tt.payload, claims.ID, claims.IssuedAt), payload)
+			assert.Equal(t, goldenIssuer, claims.Issuer)
+			assert.Equal(t, goldenUser, claims.Subject)
+			assert.Equal(t, tt.serverID, claims.Audience)
+			assert.Equal(t, tt.userJWT, claims.Jwt)
+			assert.Equal(t, tt.errMsg, claims.Error)
+		})
+	}
+}
+
+func TestBuildResponse_TemporaryUserNkey(t *testing.T) {
+	for _, userNkey := range []string{"", "not-a-user-key", goldenIssuer} {
+		t.Run(fmt.Sprintf("%q", userNkey), func(t *testing.T) {
+			c := goldenClient(t)
+			token, err := c.buildResponse(userNkey, "", "", denyMessage(DenyInvalidRequest, "invalid request format"))
+			require.NoError(t, err)
+
+			claims, payload := decodeGoldenResponse(t, token)
+			assert.True(t, nkeys.IsValidPublicUserKey(claims.Subject), "subject %q", claims.Subject)
+			assert.NotEqual(t, userNkey, claims.Subject)
+			assert.Equal(t, goldenIssuer, claims.Issuer)
+			assert.Equal(t, "invalid_request: invalid request format", claims.Error)
+			assert.Equal(t,
+				fmt.Sprintf(`{"jti":"%s","iat":%d,"iss":"%s","sub":"%s","nats":{"error":"invalid_request: invalid request format","type":"authorization_response","version":2}}`,
+					claims.ID, claims.IssuedAt, goldenIssuer, claims.Subject),
+				payload)
+
+			// Every fallback gets a fresh key.
+			again, err := c.buildResponse(userNkey, "", "", "")
+			require.NoError(t, err)
+			againClaims, _ := decodeGoldenResponse(t, again)
+			assert.NotEqual(t, claims.Subject, againClaims.Subject)
+		})
+	}
+}
+
+func TestBuildResponse_SignerError(t *testing.T) {
+	c := &NATSClient{logger: slog.Default(), issuerSigner: failingSigner{}}
+	_, err := c.buildResponse(goldenUser, "NSERVER", "", "")
+	assert.ErrorContains(t, err, "failed to encode response JWT")
+}
+
+// failingSigner is a Signer whose every call fails.
+type failingSigner struct{}
+
+func (failingSigner) PublicKey() (string, error)  { return "", errors.New("signer unavailable") }
+func (failingSigner) Sign([]byte) ([]byte, error) { return nil, errors.New("signer unavailable") }
+
+func TestXKeyEncryption(t *testing.T) {
+	service, err := nkeys.CreateCurveKeys()
+	require.NoError(t, err)
+	servicePub, err := service.PublicKey()
+	require.NoError(t, err)
+	server, err := nkeys.CreateCurveKeys()
+	require.NoError(t, err)
+	serverPub, err := server.PublicKey()
+	require.NoError(t, err)
+
+	c := goldenClient(t)
+	c.xKeyPair = service
+
+	t.Run("encrypted request is opened and the response sealed for the server", func(t *testing.T) {
+		sealed, err := server.Seal([]byte("request.jwt"), servicePub)
+		require.NoError(t, err)
+		msg := &nats.Msg{Data: sealed, Header: nats.Header{}}
+		msg.Header.Set(serverXKeyHeader, serverPub)
+
+		data, gotServerXKey, err := c.openRequest(msg)
+		require.NoError(t, err)
+		assert.Equal(t, "request.jwt", string(data))
+		assert.Equal(t, serverPub, gotServerXKey)
+
+		token, err := c.buildResponse(goldenUser, "NSERVER", "user.jwt.token", "")
+		require.NoError(t, err)
+		payload, err := c.sealResponse(token, gotServerXKey)
+		require.NoError(t, err)
+		assert.NotContains(t, string(payload), token, "response is not sent in plaintext")
+
+		opened, err := server.Open(payload, servicePub)
+		require.NoError(t, err)
+		claims, _ := decodeGoldenResponse(t, string(opened))
+		assert.Equal(t, "user.jwt.token", claims.Jwt)
+	})
+
+	t.Run("plaintext requests get plaintext responses", func(t *testing.T) {
+		data, serverXKey, err := c.openRequest(&nats.Msg{Data: []byte("request.jwt")})
+		require.NoError(t, err)
+		assert.Equal(t, "request.jwt", string(data))
+		assert.Empty(t, serverXKey)
+
+		payload, err := c.sealResponse("response.jwt", serverXKey)
+		require.NoError(t, err)
+		assert.Equal(t, "response.jwt", string(payload))
+	})
+
+	t.Run("tampered or misaddressed requests fail", func(t *testing.T) {
+		other, err := nkeys.CreateCurveKeys()
+		require.NoError(t, err)
+		otherPub, _ := other.PublicKey()
+		sealed, err := server.Seal([]byte("request.jwt"), otherPub)
+		require.NoError(t, err)
+		msg := &nats.Msg{Data: sealed, Header: nats.Header{}}
+		msg.Header.Set(serverXKeyHeader, serverPub)
+
+		_, _, err = c.openRequest(msg)
+		assert.ErrorContains(t, err, "failed to decrypt request")
+	})
+
+	t.Run("encrypted requests need an xkey seed", func(t *testing.T) {
+		msg := &nats.Msg{Data: []byte("sealed"), Header: nats.Header{}}
+		msg.Header.Set(serverXKeyHeader, serverPub)
+
+		_, _, err := goldenClient(t).openRequest(msg)
+		assert.ErrorIs(t, err, errXKeyNotConfigured)
+		_, err = goldenClient(t).sealResponse("response.jwt", serverPub)
+		assert.ErrorIs(t, err, errXKeyNotConfigured)
+	})
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+)
+
+// RoleLimits are the connection limits of a role or, in nats.limits, of
+// every user; zero keeps the default (unlimited, or nats.limits for roles).
+type RoleLimits struct {
+	Subs    int64
+	Data    int64
+	Payload int64
+}
+
+// LoadUserLimits reads nats.limits.*, the connection limits of every user.
+func LoadUserLimits() RoleLimits {
+	return loadLimits("nats.limits")
+}
+
+func loadLimits(key string) RoleLimits {
+	return RoleLimits{
+		Subs:    viper.GetInt64(key + ".subs"),
+		Data:    viper.GetInt64(key + ".data"),
+		Payload: viper.GetInt64(key + ".payload"),
+	}
+}
+
+// Validate checks that no limit is below -1 (unlimited).
+func (l RoleLimits) Validate(key string) error {
+	for _, limit := range []struct {
+		name  string
+		value int64
+	}{{"subs", l.Subs}, {"data", l.Data}, {"payload", l.Payload}} {
+		if limit.value < jwt.NoLimit {
+			return fmt.Errorf("%s.%s: must be -1 (unlimited), 0 (default) or positive, got %d", key, limit.name, limit.value)
+		}
+	}
+	return nil
+}
+
+// or returns l with its zero limits taken from fallback.
+func (l RoleLimits) or(fallback RoleLimits) RoleLimits {
+	if l.Subs == 0 {
+		l.Subs = fallback.Subs
+	}
+	if l.Data == 0 {
+		l.Data = fallback.Data
+	}
+	if l.Payload == 0 {
+		l.Payload = fallback.Payload
+	}
+	return l
+}
+
+// RoleConfig is a named permission profile.
+type RoleConfig struct {
+	Permissions PermissionsConfig
+	Limits      RoleLimits
+}
+
+// RolesConfig is the roles: section of the configuration. A user's role
+// replaces the global nats.permissions block; tenant blocks and user grants
+// are still added on top.
+type RolesConfig struct {
+	// Profiles maps role names to their permissions and limits.
+	Profiles map[string]RoleConfig
+	// Users, Groups and Scopes select the role of a user by GitLab username,
+	// top-level group and token scope, in that order. Keys and role names
+	// are lowercase.
+	Users  map[string]string
+	Groups map[string]string
+	Scopes map[string]string
+	// Default is the role of users no rule matches; empty keeps nats.permissions.
+	Default string
+}
+
+// LoadRolesConfig reads the roles.* settings.
+func LoadRolesConfig() RolesConfig {
+	cfg := RolesConfig{
+		Profiles: make(map[string]RoleConfig),
+		Users:    lowerMap(viper.GetStringMapString("roles.users")),
+		Groups:   lowerMap(viper.GetStringMapString("roles.groups")),
+		Scopes:   lowerMap(viper.GetStringMapString("roles.scopes")),
+		Default:  strings.ToLower(viper.GetString("roles.default")),
+	}
+	for name := range viper.GetStringMap("roles.profiles") {
+		key := "roles.profiles." + name
+		cfg.Profiles[name] = RoleConfig{
+			Permissions: LoadPermissionsConfig(key + ".permissions"),
+			Limits:      loadLimits(key + ".limits"),
+		}
+	}
+	return cfg
+}
+
+// lowerMap lowercases keys and values; viper lowercases the role names.
+func lowerMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = strings.ToLower(v)
+	}
+	return out
+}
+
+// Validate checks that every selected role is defined and that the limits
+// of the roles are valid.
+func (r RolesConfig) Validate() error {
+	for _, name := range sortedKeys(r.Profiles) {
+		if err := r.Profiles[name].Limits.Validate("roles.profiles." + name + ".limits"); err != nil {
+			return err
+		}
+	}
+	check := func(key, role string) error {
+		if _, ok := r.Profiles[role]; !ok {
+			return fmt.Errorf("%s: unknown role %q", key, role)
+		}
+		return nil
+	}
+	for _, section := range []struct {
+		key string
+		m   map[string]string
+	}{{"roles.users", r.Users}, {"roles.groups", r.Groups}, {"roles.scopes", r.Scopes}} {
+		for _, name := range sortedKeys(section.m) {
+			if err := check(section.key+"."+name, section.m[name]); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Default != "" {
+		return check("roles.default", r.Default)
+	}
+	return nil
+}
+
+// Select returns the role of a verified user: the explicit user mapping,
+// else the role of the first (sorted) matching group, else of the first
+// (sorted) matching scope, else the default role.
+func (r RolesConfig) Select(username string, groups, scopes []string) (string, bool) {
+	if role, ok := r.Users[strings.ToLower(username)]; ok {
+		return role, true
+	}
+	if role, ok := firstMapped(r.Groups, groups); ok {
+		return role, true
+	}
+	if role, ok := firstMapped(r.Scopes, scopes); ok {
+		return role, true
+	}
+	return r.Default, r.Default != ""
+}
+
+// firstMapped returns the mapping of the first (sorted, lowercased) value
+// present in m.
+func firstMapped(m map[string]string, values []string) (string, bool) {
+	if len(m) == 0 {
+		return "", false
+	}
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	slices.Sort(lowered)
+	for _, v := range lowered {
+		if role, ok := m[v]; ok {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// userRole returns the role of an authorized user. Roles belong to the
+// verified GitLab user, not the client-supplied name. Deploy and job tokens
+// have their own permissions and no role.
+func (c *NATSClient) userRole(result AuthorizeResult) (string, RoleConfig, bool) {
+	if result.TokenType() == TokenTypeDeploy || result.TokenType() == TokenTypeJob {
+		return "", RoleConfig{}, false
+	}
+	roles := c.rolesConfig()
+	// Team members get the role of the team principal, never their own.
+	username := result.Username()
+	if principal, ok := c.teamPrincipal(result); ok {
+		username = principal
+	}
+	name, ok := roles.Select(username, result.Groups(), result.Scopes())
+	if !ok {
+		return "", RoleConfig{}, false
+	}
+	role, ok := roles.Profiles[name]
+	return name, role, ok
+}
+
+// userLimits returns the connection limits of a user's JWT: nats.limits,
+// overridden by the non-zero limits of the user's role.
+func (c *NATSClient) userLimits(result AuthorizeResult) RoleLimits {
+	limits := c.limitsConfig()
+	if _, role, ok := c.userRole(result); ok {
+		limits = role.Limits.or(limits)
+	}
+	return limits
+}
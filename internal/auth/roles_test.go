@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/callout"
+)
+
+func setRolesConfig(t *testing.T) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	viper.Set("roles.profiles.ci.permissions.publish.allow", []string{"ci.{{.Username}}.>"})
+	viper.Set("roles.profiles.ci.permissions.subscribe.allow", []string{"ci.results.>"})
+	viper.Set("roles.profiles.ci.limits.subs", 100)
+	viper.Set("roles.profiles.readonly.permissions.publish.deny", []string{">"})
+	viper.Set("roles.profiles.Admin.permissions.publish.allow", []string{">"})
+	viper.Set("roles.users", map[string]string{"Root": "admin"})
+	viper.Set("roles.groups", map[string]string{"ci-bots": "ci", "ops": "Admin"})
+	viper.Set("roles.scopes", map[string]string{"read_api": "readonly"})
+}
+
+func TestRolesConfig_Select(t *testing.T) {
+	setRolesConfig(t)
+	cfg := LoadRolesConfig()
+	require.NoError(t, cfg.Validate())
+
+	role, ok := cfg.Select("root", []string{"ci-bots"}, []string{"read_api"})
+	assert.True(t, ok)
+	assert.Equal(t, "admin", role, "the user mapping wins")
+
+	role, _ = cfg.Select("alice", []string{"OPS", "ci-bots"}, []string{"read_api"})
+	assert.Equal(t, "ci", role, "the first sorted group wins over scopes")
+
+	role, _ = cfg.Select("alice", []string{"marketing"}, []string{"read_api"})
+	assert.Equal(t, "readonly", role)
+
+	_, ok = cfg.Select("alice", nil, nil)
+	assert.False(t, ok, "no default role")
+
+	cfg.Default = "readonly"
+	role, ok = cfg.Select("alice", nil, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "readonly", role)
+}
+
+func TestRolesConfig_Validate(t *testing.T) {
+	setRolesConfig(t)
+	viper.Set("roles.groups", map[string]string{"ci-bots": "cii"})
+	assert.ErrorContains(t, LoadRolesConfig().Validate(), `roles.groups.ci-bots: unknown role "cii"`)
+
+	setRolesConfig(t)
+	viper.Set("roles.default", "guest")
+	assert.ErrorContains(t, LoadRolesConfig().Validate(), "roles.default")
+}
+
+func TestResolvePermissions_Roles(t *testing.T) {
+	setRolesConfig(t)
+	c := &NATSClient{logger: slog.Default()}
+
+	ci := AuthorizeResult{Verified: &VerifiedToken{Username: "bot", Groups: []string{"ci-bots"}}}
+	set := c.resolvePermissions(ci, "bot", nil)
+	assert.Equal(t, []string{"ci.bot.>"}, set.Publish.Allow, "the role replaces nats.permissions")
+	assert.Equal(t, []string{"ci.results.>"}, set.Subscribe.Allow)
+
+	_, role, ok := c.userRole(ci)
+	require.True(t, ok)
+	uc := callout.BuildUserClaims(callout.Identity{UserNkey: "UBOT"}, callout.Policy{Limits: callout.Limits(role.Limits)})
+	assert.Equal(t, jwt.NatsLimits{Subs: 100, Data: jwt.NoLimit, Payload: jwt.NoLimit}, uc.Limits.NatsLimits)
+
+	set = c.resolvePermissions(AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}, "alice", nil)
+	assert.Equal(t, []string{"user.alice.>"}, set.Publish.Allow, "users without a role keep nats.permissions")
+}
+
+func TestUserLimits(t *testing.T) {
+	setRolesConfig(t)
+	viper.Set("nats.limits.subs", 1000)
+	viper.Set("nats.limits.payload", 1<<20)
+	viper.Set("roles.profiles.admin.limits.payload", -1)
+	c := &NATSClient{logger: slog.Default()}
+
+	alice := AuthorizeResult{Verified: &VerifiedToken{Username: "alice"}}
+	assert.Equal(t, RoleLimits{Subs: 1000, Payload: 1 << 20}, c.userLimits(alice), "users without a role get nats.limits")
+
+	ci := AuthorizeResult{Verified: &VerifiedToken{Username: "bot", Groups: []string{"ci-bots"}}}
+	assert.Equal(t, RoleLimits{Subs: 100, Payload: 1 << 20}, c.userLimits(ci), "role limits override per field")
+
+	root := AuthorizeResult{Verified: &VerifiedToken{Username: "root"}}
+	assert.Equal(t, RoleLimits{Subs: 1000, Payload: jwt.NoLimit}, c.userLimits(root), "-1 lifts a limit")
+}
+
+func TestRoleLimits_Validate(t *testing.T) {
+	assert.NoError(t, RoleLimits{Subs: jwt.NoLimit, Payload: 1024}.Validate("nats.limits"))
+	assert.EqualError(t, RoleLimits{Data: -2}.Validate("nats.limits"),
+		"nats.limits.data: must be -1 (unlimited), 0 (default) or positive, got -2")
+
+	setRolesConfig(t)
+	viper.Set("roles.profiles.ci.limits.subs", -5)
+	assert.ErrorContains(t, LoadRolesConfig().Validate(), "roles.profiles.ci.limits.subs")
+}
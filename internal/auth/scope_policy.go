@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// Scope policy modes.
+const (
+	ScopePolicyOff     = "off"
+	ScopePolicyWarn    = "warn"
+	ScopePolicyEnforce = "enforce"
+)
+
+// ScopePolicy enforces least privilege on the PATs used for NATS access.
+type ScopePolicy struct {
+	// Mode is off, warn (log and count only) or enforce (deny).
+	Mode string
+	// MaxAllowed, when set, lists every scope a token may carry.
+	MaxAllowed []string
+	// Forbidden lists scopes a token must never carry.
+	Forbidden []string
+}
+
+// LoadScopePolicy reads auth.scope_policy, auth.max_allowed_scopes and
+// auth.forbidden_scopes. It is read on every request so the mode can be
+// tightened at runtime.
+func LoadScopePolicy() ScopePolicy {
+	mode := strings.ToLower(strings.TrimSpace(viper.GetString("auth.scope_policy")))
+	switch mode {
+	case ScopePolicyOff, ScopePolicyWarn, ScopePolicyEnforce:
+	default:
+		// Unknown values must not silently enforce (or disable) anything.
+		mode = ScopePolicyWarn
+	}
+	return ScopePolicy{
+		Mode:       mode,
+		MaxAllowed: viper.GetStringSlice("auth.max_allowed_scopes"),
+		Forbidden:  viper.GetStringSlice("auth.forbidden_scopes"),
+	}
+}
+
+// Excessive returns the scopes that are forbidden or not in MaxAllowed.
+func (p ScopePolicy) Excessive(scopes []string) []string {
+	var out []string
+	for _, s := range scopes {
+		if slices.Contains(p.Forbidden, s) || (len(p.MaxAllowed) > 0 && !slices.Contains(p.MaxAllowed, s)) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// checkScopes applies the scope policy to an authorized token and reports
+// whether the request must be denied.
+func (c *NATSClient) checkScopes(username string, result AuthorizeResult) bool {
+	policy := LoadScopePolicy()
+	if policy.Mode == ScopePolicyOff {
+		return false
+	}
+
+	scopes := result.Scopes()
+	if len(scopes) == 0 {
+		// Scopes are best-effort (not every token type exposes them).
+		c.logger.Debug("Token scopes unknown, scope policy not applied", "username", username)
+		return false
+	}
+	excessive := policy.Excessive(scopes)
+	if len(excessive) == 0 {
+		return false
+	}
+
+	for _, s := range excessive {
+		excessiveScopesTotal.WithLabelValues(s, policy.Mode).Inc()
+	}
+	c.logger.Warn("Token carries excessive scopes; mint a least-privilege PAT (e.g. read_api) for NATS access",
+		"username", username,
+		"excessive_scopes", excessive,
+		"policy", policy.Mode,
+	)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetUser(sentry.User{Username: username})
+		scope.SetTag("auth_status", "excessive_scopes")
+		scope.SetTag("scope_policy", policy.Mode)
+		scope.SetContext("scopes", sentry.Context{"excessive": excessive, "all": scopes})
+		scope.SetLevel(sentry.LevelWarning)
+		sentry.CaptureMessage("Token carries excessive scopes")
+	})
+
+	return policy.Mode == ScopePolicyEnforce
+}
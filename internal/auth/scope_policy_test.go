@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadScopePolicy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	assert.Equal(t, ScopePolicyWarn, LoadScopePolicy().Mode, "defaults to warn")
+
+	viper.Set("auth.scope_policy", " Enforce ")
+	viper.Set("auth.max_allowed_scopes", []string{"read_api", "read_user"})
+	viper.Set("auth.forbidden_scopes", []string{"sudo"})
+	p := LoadScopePolicy()
+	assert.Equal(t, ScopePolicyEnforce, p.Mode)
+	assert.Equal(t, []string{"read_api", "read_user"}, p.MaxAllowed)
+	assert.Equal(t, []string{"sudo"}, p.Forbidden)
+
+	viper.Set("auth.scope_policy", "strict")
+	assert.Equal(t, ScopePolicyWarn, LoadScopePolicy().Mode, "unknown modes fall back to warn")
+}
+
+func TestScopePolicy_Excessive(t *testing.T) {
+	p := ScopePolicy{Forbidden: []string{"api", "sudo"}}
+	assert.Empty(t, p.Excessive([]string{"read_api", "read_user"}))
+	assert.Equal(t, []string{"api", "sudo"}, p.Excessive([]string{"read_api", "api", "sudo"}))
+
+	p.MaxAllowed = []string{"read_api"}
+	assert.Equal(t, []string{"read_user", "api"}, p.Excessive([]string{"read_api", "read_user", "api"}))
+}
+
+func TestCheckScopes(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auth.forbidden_scopes", []string{"sudo"})
+
+	c := &NATSClient{logger: slog.Default()}
+	sudo := AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "root", Scopes: []string{"read_api", "sudo"}}}
+
+	t.Run("warn mode only counts", func(t *testing.T) {
+		viper.Set("auth.scope_policy", ScopePolicyWarn)
+		before := testutil.ToFloat64(excessiveScopesTotal.WithLabelValues("sudo", ScopePolicyWarn))
+		assert.False(t, c.checkScopes("root", sudo))
+		assert.Equal(t, before+1, testutil.ToFloat64(excessiveScopesTotal.WithLabelValues("sudo", ScopePolicyWarn)))
+	})
+
+	t.Run("enforce mode denies", func(t *testing.T) {
+		viper.Set("auth.scope_policy", ScopePolicyEnforce)
+		assert.True(t, c.checkScopes("root", sudo))
+
+		cached := AuthorizeResult{Allow: true, FromCache: true, Cached: &TokenCacheEntry{Scopes: "read_api,sudo"}}
+		assert.True(t, c.checkScopes("root", cached))
+	})
+
+	t.Run("unknown scopes are not judged", func(t *testing.T) {
+		viper.Set("auth.scope_policy", ScopePolicyEnforce)
+		assert.False(t, c.checkScopes("root", AuthorizeResult{Allow: true, Verified: &VerifiedToken{Username: "root"}}))
+	})
+
+	t.Run("off disables the policy", func(t *testing.T) {
+		viper.Set("auth.scope_policy", ScopePolicyOff)
+		assert.False(t, c.checkScopes("root", sudo))
+	})
+}
+
+func TestConfigOverrides_ScopePolicy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	o := newConfigOverrides(nil, slog.Default())
+
+	require.Error(t, o.apply("auth.scope_policy", "strict", false))
+	require.NoError(t, o.apply("auth.scope_policy", "ENFORCE", false))
+	assert.Equal(t, ScopePolicyEnforce, LoadScopePolicy().Mode)
+}
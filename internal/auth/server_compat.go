@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/viper"
+)
+
+// Supported values of nats.server_compat: the oldest nats-server release
+// line of the cluster.
+const (
+	ServerCompat210 = "2.10"
+	ServerCompat211 = "2.11"
+)
+
+// serverCompat describes what the servers of a release line support. Auth
+// callout itself is unchanged since 2.10: the request and response are
+// version 2 authorization claims, the response is addressed (aud) to the
+// requesting server's ID and its subject is the user nkey of the request.
+// The conformance tests in server_compat_test.go pin this per release line.
+type serverCompat struct {
+	Version string
+	// MsgTTL reports per-message TTLs on streams (2.11+), which the token
+	// cache uses for per-key expiry of TTL overrides.
+	MsgTTL bool
+}
+
+// serverCompats lists the supported release lines, oldest first.
+var serverCompats = []serverCompat{
+	{Version: ServerCompat210},
+	{Version: ServerCompat211, MsgTTL: true},
+}
+
+// LoadServerCompat reads nats.server_compat.
+func LoadServerCompat() (serverCompat, error) {
+	version := strings.TrimSpace(viper.GetString("nats.server_compat"))
+	for _, compat := range serverCompats {
+		if compat.Version == version {
+			return compat, nil
+		}
+	}
+	supported := make([]string, len(serverCompats))
+	for i, compat := range serverCompats {
+		supported[i] = compat.Version
+	}
+	return serverCompat{}, fmt.Errorf("nats.server_compat must be one of %s, got %q", strings.Join(supported, ", "), version)
+}
+
+// parseServerVersion returns the major and minor version of a nats-server
+// version string such as "2.11.4" or "2.12.0-RC.1".
+func parseServerVersion(version string) (int, int, bool) {
+	major, rest, ok := strings.Cut(version, ".")
+	if !ok {
+		return 0, 0, false
+	}
+	minor, _, _ := strings.Cut(rest, ".")
+	minor, _, _ = strings.Cut(minor, "-")
+	majorN, err1 := strconv.Atoi(major)
+	minorN, err2 := strconv.Atoi(minor)
+	return majorN, minorN, err1 == nil && err2 == nil
+}
+
+// olderThan reports whether a server of version is older than the release
+// line. Unknown versions are not judged.
+func (compat serverCompat) olderThan(version string) bool {
+	major, minor, ok := parseServerVersion(version)
+	wantMajor, wantMinor, _ := parseServerVersion(compat.Version + ".0")
+	return ok && (major < wantMajor || major == wantMajor && minor < wantMinor)
+}
+
+// serverVersions remembers the server versions already warned about, so an
+// outdated server is logged once and not on every request.
+type serverVersions struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+// first reports whether version is seen for the first time.
+func (v *serverVersions) first(version string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if slices.Contains(v.seen, version) {
+		return false
+	}
+	v.seen = append(v.seen, version)
+	return true
+}
+
+// checkServerVersion counts requests of servers older than nats.server_compat
+// and warns once per version: such a server may lack what the setting relies
+// on, e.g. per-message TTLs, so a half-upgraded or downgraded cluster is
+// noticed before issuance breaks.
+func (c *NATSClient) checkServerVersion(rc *jwt.AuthorizationRequestClaims) {
+	version := rc.Server.Version
+	if !c.serverCompat.olderThan(version) {
+		return
+	}
+	outdatedServerRequestsTotal.WithLabelValues(version).Inc()
+	if c.outdatedServers.first(version) {
+		c.logger.Warn("NATS server is older than nats.server_compat",
+			"server_version", version,
+			"server_name", rc.Server.Name,
+			"server_compat", c.serverCompat.Version,
+		)
+	}
+}
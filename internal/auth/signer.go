@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/pkg/callout"
+)
+
+// Signer types.
+const (
+	SignerLocal  = "local"
+	SignerRemote = "remote"
+)
+
+// Signer signs JWTs with the issuer key. Implementations may keep the
+// private key outside of Antal's memory.
+type Signer interface {
+	// PublicKey returns the public key of the issuer.
+	PublicKey() (string, error)
+	// Sign returns the ed25519 signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+// keyPairSigner signs with a local nkey.
+type keyPairSigner struct {
+	kp nkeys.KeyPair
+}
+
+// NewKeyPairSigner returns a Signer backed by a local nkey.
+func NewKeyPairSigner(kp nkeys.KeyPair) Signer {
+	return keyPairSigner{kp: kp}
+}
+
+func (s keyPairSigner) PublicKey() (string, error)       { return s.kp.PublicKey() }
+func (s keyPairSigner) Sign(data []byte) ([]byte, error) { return s.kp.Sign(data) }
+
+// encodeClaims encodes and signs claims with the signer.
+func encodeClaims(claims jwt.Claims, signer Signer) (string, error) {
+	return callout.Encode(claims, signer)
+}
+
+// RemoteSignerConfig configures an external signing service.
+type RemoteSignerConfig struct {
+	URL       string
+	PublicKey string
+	AuthToken string
+	CAFile    string
+	Timeout   time.Duration
+}
+
+// LoadRemoteSignerConfig reads the signer.* settings.
+func LoadRemoteSignerConfig() RemoteSignerConfig {
+	return RemoteSignerConfig{
+		URL:       strings.TrimSuffix(viper.GetString("signer.url"), "/"),
+		PublicKey: viper.GetString("signer.public_key"),
+		AuthToken: viper.GetString("signer.auth_token"),
+		CAFile:    viper.GetString("signer.ca_file"),
+		Timeout:   viper.GetDuration("signer.timeout"),
+	}
+}
+
+// RemoteSigner delegates signing to an external service (a signing sidecar,
+// HSM gateway or KMS-style service) that holds the issuer seed. It POSTs
+// {"public_key": ..., "data": <base64>} to <url>/sign and expects
+// {"signature": <base64>}. Every signature is verified before use.
+type RemoteSigner struct {
+	cfg      RemoteSignerConfig
+	verifier nkeys.KeyPair
+	client   *http.Client
+}
+
+// NewRemoteSigner creates a signer for the configured service.
+func NewRemoteSigner(cfg RemoteSignerConfig) (*RemoteSigner, error) {
+	if cfg.URL == "" || cfg.PublicKey == "" {
+		return nil, errors.New("signer: url and public_key are required for the remote signer")
+	}
+	verifier, err := nkeys.FromPublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signer: invalid public_key: %w", err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("signer: failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("signer: no certificates found in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+
+	return &RemoteSigner{
+		cfg:      cfg,
+		verifier: verifier,
+		client:   &http.Client{Timeout: cfg.Timeout, Transport: transport},
+	}, nil
+}
+
+// PublicKey returns the configured issuer public key.
+func (s *RemoteSigner) PublicKey() (string, error) {
+	return s.cfg.PublicKey, nil
+}
+
+type remoteSignRequest struct {
+	PublicKey string `json:"public_key"`
+	Data      []byte `json:"data"`
+}
+
+type remoteSignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+// Sign asks the service for a signature and verifies it.
+func (s *RemoteSigner) Sign(data []byte) ([]byte, error) {
+	start := time.Now()
+	sig, err := s.sign(data)
+	remoteSignDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		remoteSignErrorsTotal.Inc()
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	return sig, nil
+}
+
+func (s *RemoteSigner) sign(data []byte) ([]byte, error) {
+	body, err := json.Marshal(remoteSignRequest{PublicKey: s.cfg.PublicKey, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL+"/sign", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.AuthToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out remoteSignResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	// Never hand out a JWT the NATS server would reject (or that was signed
+	// with another key).
+	if err := s.verifier.Verify(data, out.Signature); err != nil {
+		return nil, fmt.Errorf("signature does not verify with %s: %w", s.cfg.PublicKey, err)
+	}
+	return out.Signature, nil
+}
+
+// newIssuerSigner creates the issuer signer selected by signer.type. With the
+// remote signer the issuer seed must not be configured, so it never ends up
+// in Antal's memory.
+func newIssuerSigner(issuerSeed string) (Signer, error) {
+	switch kind := viper.GetString("signer.type"); kind {
+	case "", SignerLocal:
+		kp, err := nkeys.FromSeed([]byte(issuerSeed))
+		if err != nil {
+			return nil, fmt.Errorf("invalid issuer seed: %w", err)
+		}
+		return NewKeyPairSigner(kp), nil
+	case SignerRemote:
+		if issuerSeed != "" {
+			return nil, errors.New("nats.issuer_seed must be empty with the remote signer")
+		}
+		return NewRemoteSigner(LoadRemoteSignerConfig())
+	default:
+		return nil, fmt.Errorf("unknown signer.type %q (local, remote)", kind)
+	}
+}
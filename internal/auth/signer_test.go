@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signerServer emulates an external signer holding kp.
+func signerServer(t *testing.T, kp nkeys.KeyPair, token string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sign" || r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req remoteSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, err := kp.Sign(req.Data)
+		require.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(remoteSignResponse{Signature: sig})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteSigner_SignsVerifiableJWT(t *testing.T) {
+	issuer, _, issuerPub := newAccountKey(t)
+	srv := signerServer(t, issuer, "secret")
+
+	signer, err := NewRemoteSigner(RemoteSignerConfig{URL: srv.URL, PublicKey: issuerPub, AuthToken: "secret"})
+	require.NoError(t, err)
+
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userPub, err := user.PublicKey()
+	require.NoError(t, err)
+
+	token, err := encodeClaims(jwt.NewUserClaims(userPub), signer)
+	require.NoError(t, err)
+
+	claims, err := jwt.DecodeUserClaims(token)
+	require.NoError(t, err)
+	assert.Equal(t, issuerPub, claims.Issuer)
+	assert.Equal(t, userPub, claims.Subject)
+}
+
+func TestRemoteSigner_RejectsForeignSignatures(t *testing.T) {
+	_, _, issuerPub := newAccountKey(t)
+	other, _, _ := newAccountKey(t)
+	srv := signerServer(t, other, "secret")
+
+	signer, err := NewRemoteSigner(RemoteSignerConfig{URL: srv.URL, PublicKey: issuerPub, AuthToken: "secret"})
+	require.NoError(t, err)
+	_, err = signer.Sign([]byte("payload"))
+	assert.ErrorContains(t, err, "does not verify")
+
+	signer, err = NewRemoteSigner(RemoteSignerConfig{URL: srv.URL, PublicKey: issuerPub, AuthToken: "wrong"})
+	require.NoError(t, err)
+	_, err = signer.Sign([]byte("payload"))
+	assert.ErrorContains(t, err, "status 401")
+}
+
+func TestNewIssuerSigner(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	_, seed, pub := newAccountKey(t)
+
+	signer, err := newIssuerSigner(seed)
+	require.NoError(t, err)
+	got, err := signer.PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, pub, got)
+
+	viper.Set("signer.type", SignerRemote)
+	viper.Set("signer.url", "https://signer.example")
+	viper.Set("signer.public_key", pub)
+	_, err = newIssuerSigner(seed)
+	assert.ErrorContains(t, err, "issuer_seed must be empty")
+
+	signer, err = newIssuerSigner("")
+	require.NoError(t, err)
+	assert.IsType(t, &RemoteSigner{}, signer)
+
+	viper.Set("signer.type", "kms")
+	_, err = newIssuerSigner("")
+	assert.ErrorContains(t, err, "unknown signer.type")
+}
+
+func TestRotateIssuer_RefusesRemoteSigner(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	_, _, pub := newAccountKey(t)
+	signer, err := NewRemoteSigner(RemoteSignerConfig{URL: "https://signer.example", PublicKey: pub})
+	require.NoError(t, err)
+
+	c := &NATSClient{issuerSigner: signer}
+	_, err = c.RotateIssuer(t.Context(), "test")
+	assert.ErrorContains(t, err, "external signer")
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// StandbyConfig configures active/passive mode.
+type StandbyConfig struct {
+	Enabled  bool
+	Bucket   string
+	Replicas int
+	// Heartbeat is how often the active replica renews its lease and the
+	// standbys check it.
+	Heartbeat time.Duration
+	// Timeout is how long the lease may go without a heartbeat before a
+	// standby takes over.
+	Timeout time.Duration
+}
+
+// LoadStandbyConfig reads the standby.* settings.
+func LoadStandbyConfig() StandbyConfig {
+	return StandbyConfig{
+		Enabled:   viper.GetBool("standby.enabled"),
+		Bucket:    viper.GetString("standby.bucket"),
+		Replicas:  viper.GetInt("standby.replicas"),
+		Heartbeat: viper.GetDuration("standby.heartbeat"),
+		Timeout:   viper.GetDuration("standby.timeout"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg StandbyConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.Bucket == "":
+		return errors.New("standby: bucket is empty")
+	case cfg.Heartbeat <= 0:
+		return errors.New("standby: heartbeat must be > 0")
+	case cfg.Timeout < 2*cfg.Heartbeat:
+		return errors.New("standby: timeout must be at least twice the heartbeat")
+	}
+	return nil
+}
+
+// standbyLeaseKey is the KV key holding the active replica's lease.
+const standbyLeaseKey = "active"
+
+// standbyLease is the value of the lease key.
+type standbyLease struct {
+	Instance  string    `json:"instance"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// Standby elects the one replica that answers auth requests. The active
+// replica renews a lease in a KV bucket every heartbeat; the others watch it
+// and take over once its revision has not changed for the timeout. Staleness
+// is judged by the standby's own clock, so clock skew between hosts does not
+// matter. Leases are only written at the revision they were read at, so two
+// standbys never both take over.
+type Standby struct {
+	kv       nats.KeyValue
+	cfg      StandbyConfig
+	instance string
+	now      func() time.Time
+	logger   *slog.Logger
+
+	// promote subscribes to auth requests; demote unsubscribes.
+	promote func() error
+	demote  func()
+
+	// Election state, only used by the loop (and Stop after it ended).
+	active bool
+	rev    uint64
+	seen   uint64
+	seenAt time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewStandby binds to (or creates) the lease bucket.
+func NewStandby(js nats.JetStreamContext, cfg StandbyConfig, instance string, promote func() error, demote func()) (*Standby, error) {
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 3
+	}
+	kv, _, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "GCS Antal active replica lease",
+		Replicas:    cfg.Replicas,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStandby(kv, cfg, instance, promote, demote), nil
+}
+
+func newStandby(kv nats.KeyValue, cfg StandbyConfig, instance string, promote func() error, demote func()) *Standby {
+	return &Standby{
+		kv:       kv,
+		cfg:      cfg,
+		instance: instance,
+		now:      time.Now,
+		logger:   slog.With("component", "standby"),
+		promote:  promote,
+		demote:   demote,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start runs the election immediately and then every heartbeat.
+func (s *Standby) Start() {
+	standbyActive.Set(0)
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(s.cfg.Heartbeat)
+		defer ticker.Stop()
+		for {
+			s.tick()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the election and releases the lease, so a standby takes over
+// on its next heartbeat instead of after the timeout.
+func (s *Standby) Stop() {
+	close(s.stop)
+	s.done.Wait()
+	if !s.active {
+		return
+	}
+	if err := s.kv.Delete(standbyLeaseKey, nats.LastRevision(s.rev)); err != nil {
+		s.logger.Warn("Failed to release the active lease", "error", err)
+	} else {
+		s.logger.Info("Released the active lease")
+	}
+	s.active = false
+	standbyActive.Set(0)
+}
+
+func (s *Standby) lease() ([]byte, error) {
+	return json.Marshal(standbyLease{Instance: s.instance, Heartbeat: s.now().UTC()})
+}
+
+// tick runs one election round.
+func (s *Standby) tick() {
+	data, err := s.lease()
+	if err != nil {
+		s.logger.Error("Failed to encode lease", "error", err)
+		return
+	}
+
+	if s.active {
+		rev, err := s.kv.Update(standbyLeaseKey, data, s.rev)
+		switch {
+		case err == nil:
+			s.rev = rev
+		case errors.Is(err, nats.ErrKeyExists):
+			// Another replica took over, e.g. after this one stalled.
+			s.logger.Warn("Lost the active lease, switching to standby")
+			s.active = false
+			standbyActive.Set(0)
+			s.demote()
+		default:
+			// Without the KV no standby can take over either; keep serving.
+			s.logger.Warn("Failed to renew the active lease", "error", err)
+		}
+		return
+	}
+
+	entry, err := s.kv.Get(standbyLeaseKey)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+		rev, err := s.kv.Create(standbyLeaseKey, data)
+		if err != nil {
+			return // another standby was faster
+		}
+		s.takeOver(rev, "no active replica")
+		return
+	case err != nil:
+		s.logger.Warn("Failed to read the active lease", "error", err)
+		return
+	}
+
+	now := s.now()
+	if entry.Revision() != s.seen {
+		s.seen, s.seenAt = entry.Revision(), now
+		return
+	}
+	if now.Sub(s.seenAt) < s.cfg.Timeout {
+		return
+	}
+	rev, err := s.kv.Update(standbyLeaseKey, data, entry.Revision())
+	if err != nil {
+		return // another standby was faster, or the active replica recovered
+	}
+	var held standbyLease
+	_ = json.Unmarshal(entry.Value(), &held)
+	s.takeOver(rev, fmt.Sprintf("no heartbeat from %s for %s", held.Instance, now.Sub(s.seenAt).Round(time.Second)))
+}
+
+// takeOver promotes this replica after it acquired the lease at rev. When
+// subscribing fails the lease is given up again.
+func (s *Standby) takeOver(rev uint64, reason string) {
+	if err := s.promote(); err != nil {
+		s.logger.Error("Failed to take over as active replica", "error", err)
+		_ = s.kv.Delete(standbyLeaseKey, nats.LastRevision(rev))
+		return
+	}
+	s.active, s.rev = true, rev
+	standbyActive.Set(1)
+	standbyPromotionsTotal.Inc()
+	s.logger.Warn("Promoted to active replica", "reason", reason)
+}
+
+// initStandby optionally prepares active/passive mode; the election starts
+// with Start.
+func (c *NATSClient) initStandby() error {
+	cfg := LoadStandbyConfig()
+	if !cfg.Enabled {
+		c.logger.Info("Standby mode disabled, subscribing to auth requests directly")
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	js, err := c.jetStream()
+	if err != nil {
+		return fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	standby, err := NewStandby(js, cfg, c.instanceID, c.subscribeAuthRequests, func() {
+		c.drainAuthRequests(viper.GetDuration("nats.drain_timeout"))
+	})
+	if err != nil {
+		return err
+	}
+	c.standby = standby
+	c.logger.Info("Standby mode enabled (JetStream KV)", "bucket", cfg.Bucket, "instance", c.instanceID)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testReplica is one replica in a standby test; subscribed tracks whether
+// it would answer auth requests.
+type testReplica struct {
+	*Standby
+	subscribed bool
+	failing    bool
+}
+
+func newTestReplica(kv *revisionKV, instance string, now *time.Time) *testReplica {
+	r := &testReplica{}
+	cfg := StandbyConfig{Enabled: true, Bucket: "standby", Heartbeat: time.Second, Timeout: 5 * time.Second}
+	r.Standby = newStandby(kv, cfg, instance, func() error {
+		if r.failing {
+			return errors.New("subscribe failed")
+		}
+		r.subscribed = true
+		return nil
+	}, func() { r.subscribed = false })
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func TestStandbyConfig_Validate(t *testing.T) {
+	valid := StandbyConfig{Enabled: true, Bucket: "b", Heartbeat: time.Second, Timeout: 3 * time.Second}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, StandbyConfig{}.Validate())
+
+	noBucket := valid
+	noBucket.Bucket = ""
+	assert.Error(t, noBucket.Validate())
+
+	short := valid
+	short.Timeout = time.Second
+	assert.Error(t, short.Validate())
+}
+
+func TestStandby_FirstReplicaBecomesActive(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	kv := newRevisionKV()
+	a, b := newTestReplica(kv, "a", &now), newTestReplica(kv, "b", &now)
+
+	a.tick()
+	b.tick()
+	assert.True(t, a.subscribed)
+	assert.False(t, b.subscribed)
+
+	// Renewals keep b waiting however long they run.
+	for range 20 {
+		now = now.Add(time.Second)
+		a.tick()
+		b.tick()
+	}
+	assert.True(t, a.subscribed)
+	assert.False(t, b.subscribed)
+}
+
+func TestStandby_TakesOverAfterTimeout(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	kv := newRevisionKV()
+	a, b := newTestReplica(kv, "a", &now), newTestReplica(kv, "b", &now)
+	a.tick()
+	b.tick()
+
+	// a stops heartbeating; b waits for the timeout by its own clock.
+	for range 4 {
+		now = now.Add(time.Second)
+		b.tick()
+	}
+	assert.False(t, b.subscribed)
+	now = now.Add(time.Second)
+	b.tick()
+	assert.True(t, b.subscribed)
+	assert.True(t, b.active)
+
+	// a recovers, fails to renew and steps down.
+	a.tick()
+	assert.False(t, a.subscribed)
+	assert.False(t, a.active)
+}
+
+func TestStandby_StopReleasesLease(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	kv := newRevisionKV()
+	a, b := newTestReplica(kv, "a", &now), newTestReplica(kv, "b", &now)
+	a.tick()
+	b.tick()
+
+	a.Stop()
+	_, ok := kv.data[standbyLeaseKey]
+	assert.False(t, ok)
+
+	b.tick()
+	assert.True(t, b.subscribed)
+}
+
+func TestStandby_PromoteFailureReleasesLease(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	kv := newRevisionKV()
+	a := newTestReplica(kv, "a", &now)
+	a.failing = true
+
+	a.tick()
+	assert.False(t, a.active)
+	_, ok := kv.data[standbyLeaseKey]
+	assert.False(t, ok)
+
+	a.failing = false
+	a.tick()
+	assert.True(t, a.active)
+}
@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// maxObservedSubjects caps the subscription subjects remembered per user, so
+// a client subscribing to ever-new subjects cannot grow memory unbounded.
+const maxObservedSubjects = 256
+
+// maxReportedSubjects caps the example subjects listed per grant in a report.
+const maxReportedSubjects = 10
+
+// SubjectUsageConfig configures the subject usage feedback loop.
+type SubjectUsageConfig struct {
+	Enabled bool
+	// Account is the users' account whose connections are sampled.
+	Account string
+	// Interval is how often subscriptions are sampled.
+	Interval time.Duration
+	// ReportInterval is how often the tightening candidates are logged.
+	ReportInterval time.Duration
+	RequestTimeout time.Duration
+}
+
+// LoadSubjectUsageConfig reads the subject_usage.* settings.
+func LoadSubjectUsageConfig() SubjectUsageConfig {
+	return SubjectUsageConfig{
+		Enabled:        viper.GetBool("subject_usage.enabled"),
+		Account:        viper.GetString("subject_usage.account"),
+		Interval:       viper.GetDuration("subject_usage.interval"),
+		ReportInterval: viper.GetDuration("subject_usage.report_interval"),
+		RequestTimeout: viper.GetDuration("subject_usage.request_timeout"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg SubjectUsageConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch {
+	case cfg.Account == "":
+		return fmt.Errorf("subject_usage: account is required")
+	case cfg.Interval <= 0 || cfg.ReportInterval <= 0 || cfg.RequestTimeout <= 0:
+		return fmt.Errorf("subject_usage: interval, report_interval and request_timeout must be > 0")
+	}
+	return nil
+}
+
+// gatherFunc sends a $SYS ping request and collects the server responses.
+type gatherFunc func(subject string, data []byte, timeout time.Duration) ([]serverAPIResponse, error)
+
+// SubjectUsage compares the subscribe permissions issued to each user with
+// the subscriptions the NATS servers actually see (CONNZ via the system
+// account), and reports grants that look broader than needed. Publish usage
+// is not tracked: NATS servers do not expose per-subject publish statistics.
+type SubjectUsage struct {
+	cfg    SubjectUsageConfig
+	gather gatherFunc
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	users   map[string]*userSubjectUsage
+	samples int
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+type userSubjectUsage struct {
+	// granted is the subscribe allow list of the user's latest JWT.
+	granted []string
+	// observed holds subscription subjects seen since startup.
+	observed map[string]struct{}
+}
+
+// NewSubjectUsage creates the tracker; call Start to begin sampling.
+func NewSubjectUsage(cfg SubjectUsageConfig, gather gatherFunc) *SubjectUsage {
+	return &SubjectUsage{
+		cfg:    cfg,
+		gather: gather,
+		logger: slog.With("component", "subject_usage"),
+		users:  make(map[string]*userSubjectUsage),
+		stop:   make(chan struct{}),
+	}
+}
+
+// RecordGrant remembers the permissions issued to a user.
+func (u *SubjectUsage) RecordGrant(username string, set PermissionSet) {
+	if username == "" {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage, ok := u.users[username]
+	if !ok {
+		usage = &userSubjectUsage{observed: map[string]struct{}{}}
+		u.users[username] = usage
+	}
+	usage.granted = slices.Clone(set.Subscribe.Allow)
+}
+
+// Start samples every interval and logs a report every report interval,
+// until Stop.
+func (u *SubjectUsage) Start() {
+	u.logger.Info("Sampling subject usage", "account", u.cfg.Account, "interval", u.cfg.Interval, "report_interval", u.cfg.ReportInterval)
+	u.done.Add(1)
+	go func() {
+		defer u.done.Done()
+		sample := time.NewTicker(u.cfg.Interval)
+		defer sample.Stop()
+		report := time.NewTicker(u.cfg.ReportInterval)
+		defer report.Stop()
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-sample.C:
+				if err := u.Sample(); err != nil {
+					subjectUsageSampleErrorsTotal.Inc()
+					u.logger.Warn("Subject usage sample failed", "error", err)
+				}
+			case <-report.C:
+				u.logReport(u.Report(time.Now()))
+			}
+		}
+	}()
+}
+
+// Stop ends sampling.
+func (u *SubjectUsage) Stop() {
+	close(u.stop)
+	u.done.Wait()
+}
+
+// connzSubscriptions is the part of a CONNZ response the tracker needs.
+type connzSubscriptions struct {
+	Connections []struct {
+		// NameTag is the name of the user JWT, i.e. the username.
+		NameTag        string `json:"name_tag"`
+		AuthorizedUser string `json:"authorized_user"`
+		Subscriptions  []struct {
+			Subject string `json:"subject"`
+		} `json:"subscriptions_list_detail"`
+	} `json:"connections"`
+}
+
+// Sample records the current subscriptions of the account's connections.
+func (u *SubjectUsage) Sample() error {
+	query, _ := json.Marshal(map[string]any{"acc": u.cfg.Account, "subscriptions_detail": true, "auth": true, "limit": 100000})
+	responses, err := u.gather("$SYS.REQ.SERVER.PING.CONNZ", query, u.cfg.RequestTimeout)
+	if err != nil {
+		return err
+	}
+	if len(responses) == 0 {
+		return errors.New("no server answered the connection list request (is the connection in the system account?)")
+	}
+
+	var errs []error
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, resp := range responses {
+		if resp.Error != nil {
+			errs = append(errs, fmt.Errorf("server %s: %s", resp.Server.ID, resp.Error.Description))
+			continue
+		}
+		var connz connzSubscriptions
+		if err := json.Unmarshal(resp.Data, &connz); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", resp.Server.ID, err))
+			continue
+		}
+		for _, conn := range connz.Connections {
+			username := conn.NameTag
+			if username == "" {
+				username = conn.AuthorizedUser
+			}
+			// Only users with a known grant are tracked.
+			usage, ok := u.users[username]
+			if !ok {
+				continue
+			}
+			for _, sub := range conn.Subscriptions {
+				if len(usage.observed) >= maxObservedSubjects {
+					break
+				}
+				usage.observed[sub.Subject] = struct{}{}
+			}
+		}
+	}
+	u.samples++
+	return errors.Join(errs...)
+}
+
+// SubjectUsageReport lists the users whose subscribe grants are candidates
+// for tightening.
+type SubjectUsageReport struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Samples     int                `json:"samples"`
+	Users       []UserSubjectUsage `json:"users"`
+}
+
+// UserSubjectUsage are the tightening candidates of one user.
+type UserSubjectUsage struct {
+	Username string `json:"username"`
+	// Unused grants matched no subscription in any sample.
+	Unused []string `json:"unused,omitempty"`
+	// Narrower are wildcard grants only used for more specific subjects.
+	Narrower []GrantNarrowing `json:"narrower,omitempty"`
+}
+
+// GrantNarrowing is a wildcard grant with the subjects it was used for.
+type GrantNarrowing struct {
+	Grant    string   `json:"grant"`
+	Observed []string `json:"observed"`
+}
+
+// Report builds the current tightening candidates. Inbox grants are never
+// reported: request/reply needs them even when no sample caught one.
+func (u *SubjectUsage) Report(now time.Time) SubjectUsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	report := SubjectUsageReport{GeneratedAt: now.UTC(), Samples: u.samples, Users: []UserSubjectUsage{}}
+	if u.samples == 0 {
+		return report
+	}
+	for _, username := range sortedKeys(u.users) {
+		usage := u.users[username]
+		observed := slices.Sorted(maps.Keys(usage.observed))
+
+		candidate := UserSubjectUsage{Username: username}
+		for _, grant := range usage.granted {
+			if strings.HasPrefix(grant, "_INBOX.") {
+				continue
+			}
+			var used []string
+			exact := false
+			for _, subject := range observed {
+				if subjectSubsetOf(subject, grant) {
+					used = append(used, subject)
+					exact = exact || subject == grant
+				}
+			}
+			switch {
+			case len(used) == 0:
+				candidate.Unused = append(candidate.Unused, grant)
+			case !exact && strings.ContainsAny(grant, "*>"):
+				candidate.Narrower = append(candidate.Narrower, GrantNarrowing{Grant: grant, Observed: used[:min(len(used), maxReportedSubjects)]})
+			}
+		}
+		if len(candidate.Unused) > 0 || len(candidate.Narrower) > 0 {
+			report.Users = append(report.Users, candidate)
+		}
+	}
+	return report
+}
+
+// logReport logs the tightening candidates and updates the metrics.
+func (u *SubjectUsage) logReport(report SubjectUsageReport) {
+	unused, narrower := 0, 0
+	for _, user := range report.Users {
+		unused += len(user.Unused)
+		narrower += len(user.Narrower)
+		u.logger.Info("Subscribe grants that could be tightened",
+			"username", user.Username, "unused", user.Unused, "narrower", user.Narrower)
+	}
+	subjectUsageUnusedGrants.Set(float64(unused))
+	subjectUsageNarrowerGrants.Set(float64(narrower))
+	u.logger.Info("Subject usage report", "samples", report.Samples, "users_with_candidates", len(report.Users),
+		"unused_grants", unused, "narrower_grants", narrower)
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "subject_usage",
+		Message:  "Subject usage report",
+		Level:    sentry.LevelInfo,
+		Data:     map[string]interface{}{"unused_grants": unused, "narrower_grants": narrower},
+	})
+}
+
+// initSubjectUsage optionally starts sampling subject usage.
+func (c *NATSClient) initSubjectUsage() error {
+	cfg := LoadSubjectUsageConfig()
+	if !cfg.Enabled {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.subjectUsage = NewSubjectUsage(cfg, c.gatherResponses)
+	c.subjectUsage.Start()
+	return nil
+}
+
+// SubjectUsageHandler serves the current subject usage report (GET only).
+func (c *NATSClient) SubjectUsageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.subjectUsage == nil {
+			http.Error(w, "subject usage tracking is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c.subjectUsage.Report(time.Now()))
+	})
+}
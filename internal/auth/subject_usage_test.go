@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connzResponse builds a CONNZ response for one server.
+func connzResponse(t *testing.T, serverID string, conns map[string][]string) serverAPIResponse {
+	t.Helper()
+	type sub struct {
+		Subject string `json:"subject"`
+	}
+	type conn struct {
+		NameTag string `json:"name_tag"`
+		Subs    []sub  `json:"subscriptions_list_detail"`
+	}
+	var data struct {
+		Connections []conn `json:"connections"`
+	}
+	for name, subjects := range conns {
+		c := conn{NameTag: name}
+		for _, s := range subjects {
+			c.Subs = append(c.Subs, sub{Subject: s})
+		}
+		data.Connections = append(data.Connections, c)
+	}
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	var resp serverAPIResponse
+	resp.Server.ID = serverID
+	resp.Data = raw
+	return resp
+}
+
+func TestSubjectUsage_Report(t *testing.T) {
+	responses := []serverAPIResponse{
+		connzResponse(t, "S1", map[string][]string{
+			"alice":   {"orders.eu.created", "_INBOX.abc.*"},
+			"unknown": {"secret.>"},
+		}),
+		connzResponse(t, "S2", map[string][]string{"alice": {"metrics.cpu"}, "bob": {"logs.>"}}),
+	}
+	var query map[string]any
+	u := NewSubjectUsage(SubjectUsageConfig{Account: "AUSERS", RequestTimeout: time.Second},
+		func(subject string, data []byte, _ time.Duration) ([]serverAPIResponse, error) {
+			assert.Equal(t, "$SYS.REQ.SERVER.PING.CONNZ", subject)
+			require.NoError(t, json.Unmarshal(data, &query))
+			return responses, nil
+		})
+
+	u.RecordGrant("alice", PermissionSet{Subscribe: SubjectRules{Allow: []string{"orders.>", "metrics.cpu", "billing.>", "_INBOX.>"}}})
+	u.RecordGrant("bob", PermissionSet{Subscribe: SubjectRules{Allow: []string{"logs.>"}}})
+	u.RecordGrant("", PermissionSet{Subscribe: SubjectRules{Allow: []string{">"}}})
+
+	assert.Empty(t, u.Report(time.Now()).Users, "no report before the first sample")
+
+	require.NoError(t, u.Sample())
+	assert.Equal(t, "AUSERS", query["acc"])
+	assert.Equal(t, true, query["subscriptions_detail"])
+
+	report := u.Report(time.Now())
+	assert.Equal(t, 1, report.Samples)
+	assert.Equal(t, []UserSubjectUsage{{
+		Username: "alice",
+		Unused:   []string{"billing.>"},
+		Narrower: []GrantNarrowing{{Grant: "orders.>", Observed: []string{"orders.eu.created"}}},
+	}}, report.Users)
+	assert.NotContains(t, u.users, "unknown", "users without a recorded grant are not tracked")
+}
+
+func TestSubjectUsage_SampleErrors(t *testing.T) {
+	u := NewSubjectUsage(SubjectUsageConfig{}, func(string, []byte, time.Duration) ([]serverAPIResponse, error) {
+		return nil, nil
+	})
+	assert.ErrorContains(t, u.Sample(), "no server answered")
+
+	failed := serverAPIResponse{}
+	failed.Server.ID = "S1"
+	failed.Error = &struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	}{Code: 403, Description: "forbidden"}
+	u.gather = func(string, []byte, time.Duration) ([]serverAPIResponse, error) {
+		return []serverAPIResponse{failed}, nil
+	}
+	assert.ErrorContains(t, u.Sample(), "server S1: forbidden")
+
+	u.gather = func(string, []byte, time.Duration) ([]serverAPIResponse, error) {
+		return nil, errors.New("no responders")
+	}
+	assert.ErrorContains(t, u.Sample(), "no responders")
+}
+
+func TestSubjectUsageConfig_Validate(t *testing.T) {
+	assert.NoError(t, SubjectUsageConfig{}.Validate())
+	cfg := SubjectUsageConfig{Enabled: true, Interval: time.Minute, ReportInterval: time.Hour, RequestTimeout: time.Second}
+	assert.ErrorContains(t, cfg.Validate(), "account is required")
+	cfg.Account = "AUSERS"
+	assert.NoError(t, cfg.Validate())
+	cfg.Interval = 0
+	assert.Error(t, cfg.Validate())
+}
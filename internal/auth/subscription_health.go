@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// authCalloutSubject is the subject the servers send auth requests to.
+const authCalloutSubject = "$SYS.REQ.USER.AUTH"
+
+// HealthConfig holds the health.* settings.
+type HealthConfig struct {
+	// MaxAuthIdle, when set, reports the replica not ready once its auth
+	// subscription has received no request for this long.
+	MaxAuthIdle time.Duration
+}
+
+// LoadHealthConfig reads the health.* settings.
+func LoadHealthConfig() HealthConfig {
+	return HealthConfig{MaxAuthIdle: viper.GetDuration("health.max_auth_idle")}
+}
+
+// authSubHealth tracks the auth callout subscription apart from the
+// connection: a connection can be up while the server silently dropped the
+// subscription (e.g. after a permissions change).
+type authSubHealth struct {
+	// subscribedAt and lastRequest are Unix nanoseconds; 0 when unset.
+	subscribedAt atomic.Int64
+	lastRequest  atomic.Int64
+	// failure is the last error the server reported for the subscription;
+	// cleared by resubscribing or by the next request received.
+	failure atomic.Pointer[string]
+}
+
+func (h *authSubHealth) subscribed(now time.Time) {
+	h.subscribedAt.Store(now.UnixNano())
+	h.failure.Store(nil)
+	authSubscriptionActive.Set(1)
+}
+
+func (h *authSubHealth) unsubscribed() {
+	h.subscribedAt.Store(0)
+	authSubscriptionActive.Set(0)
+}
+
+func (h *authSubHealth) failed(reason string) {
+	h.failure.Store(&reason)
+	authSubscriptionActive.Set(0)
+}
+
+// request records a received auth request; receiving one proves the
+// subscription works.
+func (h *authSubHealth) request(now time.Time) {
+	h.lastRequest.Store(now.UnixNano())
+	authLastRequestTimestamp.Set(float64(now.Unix()))
+	if h.failure.Load() != nil {
+		h.failure.Store(nil)
+		authSubscriptionActive.Set(1)
+	}
+}
+
+// isAuthSubscriptionError reports whether the server rejected the auth
+// callout subscription. The client reports such errors without the
+// subscription, so they are recognized by the subject.
+func isAuthSubscriptionError(err error) bool {
+	return errors.Is(err, nats.ErrPermissionViolation) &&
+		strings.Contains(err.Error(), fmt.Sprintf("Subscription to %q", authCalloutSubject))
+}
+
+// watchAuthSubscriptionErrors records server errors about the auth
+// subscription, keeping the connection's error handler.
+func (c *NATSClient) watchAuthSubscriptionErrors() {
+	next := c.nc.ErrorHandler()
+	c.nc.SetErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+		if isAuthSubscriptionError(err) {
+			c.logger.Error("Auth callout subscription rejected by the server", "error", err)
+			c.subHealth.failed(err.Error())
+		}
+		if next != nil {
+			next(nc, sub, err)
+		}
+	})
+}
+
+// Readiness is the readiness report of a replica.
+type Readiness struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+	// NATSConnected is the state of the connection, Subscription that of the
+	// auth callout subscription: active, standby, inactive or failed.
+	NATSConnected bool       `json:"nats_connected"`
+	Subscription  string     `json:"auth_subscription"`
+	LastRequest   *time.Time `json:"last_request,omitempty"`
+}
+
+// Readiness reports whether this replica can answer auth requests. A replica
+// on standby is ready: it is not meant to be subscribed.
+func (c *NATSClient) Readiness(now time.Time) Readiness {
+	r := Readiness{NATSConnected: c.nc != nil && c.nc.IsConnected()}
+	if last := c.subHealth.lastRequest.Load(); last != 0 {
+		at := time.Unix(0, last).UTC()
+		r.LastRequest = &at
+	}
+
+	sub := c.authSub.Load()
+	switch {
+	case sub == nil && c.standby != nil:
+		r.Subscription = "standby"
+	case sub == nil || !sub.IsValid():
+		r.Subscription, r.Reason = "inactive", "auth callout subscription inactive"
+	case c.subHealth.failure.Load() != nil:
+		r.Subscription, r.Reason = "failed", *c.subHealth.failure.Load()
+	default:
+		r.Subscription = "active"
+	}
+	if r.Subscription == "inactive" {
+		authSubscriptionActive.Set(0)
+	}
+
+	switch {
+	case !r.NATSConnected:
+		r.Reason = "not connected to NATS"
+	case r.Reason == "" && r.Subscription == "active":
+		r.Reason = c.authIdleReason(LoadHealthConfig().MaxAuthIdle, now)
+	}
+	r.Ready = r.Reason == ""
+	return r
+}
+
+// authIdleReason returns why the subscription counts as idle, or "".
+func (c *NATSClient) authIdleReason(maxIdle time.Duration, now time.Time) string {
+	if maxIdle <= 0 {
+		return ""
+	}
+	since := max(c.subHealth.subscribedAt.Load(), c.subHealth.lastRequest.Load())
+	if since == 0 || now.Sub(time.Unix(0, since)) <= maxIdle {
+		return ""
+	}
+	return fmt.Sprintf("no auth request received for %s", maxIdle)
+}
+
+// ReadinessHandler serves GET /ready: 200 when the replica can answer auth
+// requests, 503 otherwise, with the Readiness report as the body.
+func (c *NATSClient) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		readiness := c.Readiness(time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		if !readiness.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(readiness)
+	})
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAuthSubscriptionError(t *testing.T) {
+	rejected := fmt.Errorf("%w: %s", nats.ErrPermissionViolation, `Permissions Violation for Subscription to "$SYS.REQ.USER.AUTH" using queue "gcs_antal_auth_callout"`)
+	assert.True(t, isAuthSubscriptionError(rejected))
+
+	other := fmt.Errorf("%w: %s", nats.ErrPermissionViolation, `Permissions Violation for Subscription to "antal.can-i"`)
+	assert.False(t, isAuthSubscriptionError(other))
+	assert.False(t, isAuthSubscriptionError(errors.New(`Subscription to "$SYS.REQ.USER.AUTH"`)))
+}
+
+func TestAuthSubHealth(t *testing.T) {
+	var h authSubHealth
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	h.subscribed(now)
+	h.failed("permissions violation")
+	assert.NotNil(t, h.failure.Load())
+
+	h.request(now.Add(time.Second))
+	assert.Nil(t, h.failure.Load(), "a received request proves the subscription works")
+	assert.Equal(t, now.Add(time.Second).UnixNano(), h.lastRequest.Load())
+
+	h.failed("permissions violation")
+	h.subscribed(now.Add(2 * time.Second))
+	assert.Nil(t, h.failure.Load(), "resubscribing clears the failure")
+}
+
+func TestAuthIdleReason(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	c.subHealth.subscribed(now)
+
+	assert.Empty(t, c.authIdleReason(0, now.Add(time.Hour)), "disabled")
+	assert.Empty(t, c.authIdleReason(time.Minute, now.Add(30*time.Second)), "idle since subscribing, but not for long")
+	assert.NotEmpty(t, c.authIdleReason(time.Minute, now.Add(2*time.Minute)))
+
+	c.subHealth.request(now.Add(90 * time.Second))
+	assert.Empty(t, c.authIdleReason(time.Minute, now.Add(2*time.Minute)))
+}
+
+func TestReadiness(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	r := c.Readiness(time.Now())
+	assert.False(t, r.Ready)
+	assert.Equal(t, "not connected to NATS", r.Reason)
+	assert.Equal(t, "inactive", r.Subscription)
+
+	c.standby = &Standby{}
+	assert.Equal(t, "standby", c.Readiness(time.Now()).Subscription, "not subscribed while on standby")
+
+	rec := httptest.NewRecorder()
+	c.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"ready":false`)
+}
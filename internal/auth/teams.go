@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// TeamMapping issues the members of a top-level GitLab group as a shared
+// team principal.
+type TeamMapping struct {
+	Group string `mapstructure:"group"`
+	// Principal is the name of the issued user, e.g. team-payments.
+	Principal string `mapstructure:"principal"`
+}
+
+// LoadTeamMappings reads teams.groups.
+func LoadTeamMappings() ([]TeamMapping, error) {
+	var mappings []TeamMapping
+	if err := viper.UnmarshalKey("teams.groups", &mappings); err != nil {
+		return nil, fmt.Errorf("invalid teams.groups: %w", err)
+	}
+	return mappings, nil
+}
+
+// teamsConfigured reports whether team principals are selected by group,
+// which needs the token owner's groups.
+func teamsConfigured() bool {
+	mappings, _ := LoadTeamMappings()
+	return len(mappings) > 0
+}
+
+// teamPrincipalPattern matches principals that render into a single
+// subject token.
+var teamPrincipalPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// teamSelector selects the team principal of a user from their top-level
+// GitLab groups.
+type teamSelector struct {
+	// groups maps lowercased group paths to principals.
+	groups map[string]string
+}
+
+// newTeamSelector validates the mappings.
+func newTeamSelector(mappings []TeamMapping) (*teamSelector, error) {
+	s := &teamSelector{groups: make(map[string]string, len(mappings))}
+	for i, m := range mappings {
+		key := fmt.Sprintf("teams.groups[%d]", i)
+		group := strings.ToLower(strings.TrimSpace(m.Group))
+		switch {
+		case group == "":
+			return nil, fmt.Errorf("%s: group is required", key)
+		case !teamPrincipalPattern.MatchString(m.Principal):
+			return nil, fmt.Errorf("%s (%s): principal must be letters, digits, - and _, got %q", key, m.Group, m.Principal)
+		}
+		if _, dup := s.groups[group]; dup {
+			return nil, fmt.Errorf("%s: group %q is mapped twice", key, m.Group)
+		}
+		s.groups[group] = m.Principal
+	}
+	return s, nil
+}
+
+// Select returns the principal of the first (sorted, lowercased) group
+// mapped to one.
+func (s *teamSelector) Select(groups []string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	return selectByGroup(s.groups, groups)
+}
+
+// fingerprint describes the mappings for the JWT cache config hash.
+func (s *teamSelector) fingerprint() map[string]string {
+	if s == nil {
+		return nil
+	}
+	return s.groups
+}
+
+// teamPrincipal returns the shared principal a user is issued as, if one of
+// their groups is mapped to a team. Deploy tokens have no groups and are
+// never issued as a team.
+func (c *NATSClient) teamPrincipal(result AuthorizeResult) (string, bool) {
+	return c.teams.Select(result.Groups())
+}
+
+// initTeams optionally maps top-level GitLab groups to team principals.
+func (c *NATSClient) initTeams() error {
+	mappings, err := LoadTeamMappings()
+	if err != nil || len(mappings) == 0 {
+		return err
+	}
+	teams, err := newTeamSelector(mappings)
+	if err != nil {
+		return err
+	}
+	c.teams = teams
+	c.logger.Info("Issuing team principals from GitLab groups", "groups", len(mappings))
+	return nil
+}
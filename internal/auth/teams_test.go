@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTeamSelector_Validation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		mappings []TeamMapping
+		err      string
+	}{
+		"missing group":     {[]TeamMapping{{Principal: "team-a"}}, "group is required"},
+		"missing principal": {[]TeamMapping{{Group: "payments"}}, "principal must be"},
+		"dotted principal":  {[]TeamMapping{{Group: "payments", Principal: "team.payments"}}, "principal must be"},
+		"duplicate group":   {[]TeamMapping{{Group: "payments", Principal: "a"}, {Group: "PAYMENTS", Principal: "b"}}, "mapped twice"},
+	} {
+		_, err := newTeamSelector(tc.mappings)
+		assert.ErrorContains(t, err, tc.err, name)
+	}
+
+	s, err := newTeamSelector([]TeamMapping{{Group: "Payments", Principal: "team-payments"}, {Group: "ops", Principal: "team-ops"}})
+	require.NoError(t, err)
+	principal, ok := s.Select([]string{"payments", "ops"})
+	assert.True(t, ok)
+	assert.Equal(t, "team-ops", principal, "the first mapped group in alphabetical order wins")
+	_, ok = s.Select([]string{"marketing"})
+	assert.False(t, ok)
+	_, ok = (*teamSelector)(nil).Select([]string{"ops"})
+	assert.False(t, ok)
+}
+
+func TestResolvePermissions_TeamPrincipal(t *testing.T) {
+	setRolesConfig(t)
+	viper.Set("nats.permissions.subscribe.allow", []string{"id.{{.Identity}}.>"})
+	viper.Set("auth.identity", IdentityUserID)
+	viper.Set("roles.users", map[string]string{"team-payments": "ci", "alice": "Admin"})
+	teams, err := newTeamSelector([]TeamMapping{{Group: "payments", Principal: "team-payments"}})
+	require.NoError(t, err)
+	grants := newUserGrants(nil, slog.Default())
+	require.NoError(t, grants.apply("alice", []byte(`{"publish":["orders.created"]}`), false))
+	c := &NATSClient{logger: slog.Default(), teams: teams, userGrants: grants}
+
+	alice := AuthorizeResult{Verified: &VerifiedToken{Username: "alice", UserID: 42, Groups: []string{"payments"}}}
+	name, _, ok := c.userRole(alice)
+	require.True(t, ok)
+	assert.Equal(t, "ci", name, "the role of the principal, not alice's own")
+	set := c.resolvePermissions(alice, "alice", nil)
+	assert.Equal(t, []string{"ci.team-payments.>"}, set.Publish.Allow, "no personal grant is added")
+
+	viper.Set("roles.users", map[string]string{})
+	set = c.resolvePermissions(alice, "alice", nil)
+	assert.Equal(t, []string{"id.team-payments.>"}, set.Subscribe.Allow, "no user ID is rendered")
+
+	bob := AuthorizeResult{Verified: &VerifiedToken{Username: "bob", UserID: 7}}
+	set = c.resolvePermissions(bob, "bob", nil)
+	assert.Equal(t, []string{"id.7.>"}, set.Subscribe.Allow, "users outside team groups are issued as themselves")
+}
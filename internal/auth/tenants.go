@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// TenantConfig holds the settings a tenant can override. A tenant is a
+// GitLab top-level group; users belonging to it get the tenant settings on
+// top of the inherited defaults.
+type TenantConfig struct {
+	Permissions PermissionsConfig
+	TokenCache  TenantTokenCacheConfig
+}
+
+// TenantsConfig is the tenants: section of the configuration.
+type TenantsConfig struct {
+	// Defaults are inherited by every tenant.
+	Defaults TenantConfig
+	// Groups maps lowercase GitLab top-level group paths to tenant settings.
+	Groups map[string]TenantConfig
+}
+
+// LoadTenantsConfig reads the tenants.defaults and tenants.groups.<group> blocks.
+func LoadTenantsConfig() TenantsConfig {
+	cfg := TenantsConfig{
+		Defaults: loadTenantConfig("tenants.defaults"),
+		Groups:   make(map[string]TenantConfig),
+	}
+	for group := range viper.GetStringMap("tenants.groups") {
+		cfg.Groups[strings.ToLower(group)] = loadTenantConfig("tenants.groups." + group)
+	}
+	return cfg
+}
+
+func loadTenantConfig(key string) TenantConfig {
+	return TenantConfig{
+		Permissions: LoadPermissionsConfig(key + ".permissions"),
+		TokenCache: TenantTokenCacheConfig{
+			Bucket:   viper.GetString(key + ".token_cache.bucket"),
+			TTL:      viper.GetDuration(key + ".token_cache.ttl"),
+			Replicas: viper.GetInt(key + ".token_cache.replicas"),
+			MaxBytes: viper.GetInt64(key + ".token_cache.max_bytes"),
+		},
+	}
+}
+
+// Enabled reports whether any tenant is configured.
+func (t TenantsConfig) Enabled() bool {
+	return len(t.Groups) > 0
+}
+
+// Match returns the tenants (sorted) the given GitLab top-level groups belong to.
+func (t TenantsConfig) Match(groups []string) []string {
+	var out []string
+	for _, g := range groups {
+		g = strings.ToLower(g)
+		if _, ok := t.Groups[g]; ok && !slices.Contains(out, g) {
+			out = append(out, g)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Blocks returns the permission blocks to add for a user in the given
+// tenants: the tenant defaults followed by each tenant's own permissions.
+func (t TenantsConfig) Blocks(tenants []string) []PermissionsConfig {
+	if len(tenants) == 0 {
+		return nil
+	}
+	out := []PermissionsConfig{t.Defaults.Permissions}
+	for _, name := range tenants {
+		out = append(out, t.Groups[name].Permissions)
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
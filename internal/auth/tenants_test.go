@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTenantsConfig(t *testing.T) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	viper.Set("tenants.defaults.permissions.subscribe.allow", []string{"tenants.announcements"})
+	viper.Set("tenants.groups.Payments.permissions.publish.allow", []string{"payments.>"})
+	viper.Set("tenants.groups.Payments.permissions.publish.deny", []string{"payments.admin.>"})
+	viper.Set("tenants.groups.billing.permissions.publish.allow", []string{"billing.>", "payments.>"})
+}
+
+func TestLoadTenantsConfig(t *testing.T) {
+	setTenantsConfig(t)
+
+	cfg := LoadTenantsConfig()
+	require.True(t, cfg.Enabled())
+	assert.Equal(t, []string{"tenants.announcements"}, cfg.Defaults.Permissions.Subscribe.Allow)
+	require.Contains(t, cfg.Groups, "payments")
+	assert.Equal(t, []string{"payments.>"}, cfg.Groups["payments"].Permissions.Publish.Allow)
+	assert.Equal(t, []string{"payments.admin.>"}, cfg.Groups["payments"].Permissions.Publish.Deny)
+}
+
+func TestTenantsConfig_Match(t *testing.T) {
+	setTenantsConfig(t)
+	cfg := LoadTenantsConfig()
+
+	assert.Empty(t, cfg.Match(nil))
+	assert.Empty(t, cfg.Match([]string{"marketing"}))
+	assert.Equal(t, []string{"billing", "payments"}, cfg.Match([]string{"PAYMENTS", "marketing", "billing", "payments"}))
+}
+
+func TestTenantsConfig_Blocks(t *testing.T) {
+	setTenantsConfig(t)
+	cfg := LoadTenantsConfig()
+
+	assert.Nil(t, cfg.Blocks(nil))
+
+	blocks := cfg.Blocks([]string{"billing", "payments"})
+	require.Len(t, blocks, 3)
+	assert.Equal(t, cfg.Defaults.Permissions, blocks[0])
+	assert.Equal(t, cfg.Groups["billing"].Permissions, blocks[1])
+	assert.Equal(t, cfg.Groups["payments"].Permissions, blocks[2])
+}
+
+func TestResolvePermissions_Tenants(t *testing.T) {
+	setTenantsConfig(t)
+	c := &NATSClient{logger: slog.Default()}
+
+	t.Run("users outside tenants get global permissions only", func(t *testing.T) {
+		set := c.resolvePermissions(AuthorizeResult{}, "alice", nil)
+		assert.Equal(t, []string{"user.alice.>"}, set.Publish.Allow)
+		assert.Equal(t, []string{">"}, set.Subscribe.Allow)
+	})
+
+	t.Run("tenant users inherit defaults and union every tenant", func(t *testing.T) {
+		result := AuthorizeResult{Verified: &VerifiedToken{Groups: []string{"Billing", "payments"}}}
+		set := c.resolvePermissions(result, "alice", nil)
+		assert.Equal(t, []string{"user.alice.>", "billing.>", "payments.>"}, set.Publish.Allow)
+		assert.Equal(t, []string{"payments.admin.>"}, set.Publish.Deny)
+		// The global subscribe block allows everything; tenants cannot narrow it.
+		assert.Equal(t, []string{">"}, set.Subscribe.Allow)
+	})
+}
+
+func TestConfiguredAllowLists_IncludesTenants(t *testing.T) {
+	setTenantsConfig(t)
+
+	var keys []string
+	for _, l := range configuredAllowLists() {
+		keys = append(keys, l.key)
+	}
+	assert.Equal(t, []string{
+		"nats.permissions.publish.allow",
+		"tenants.defaults.permissions.subscribe.allow",
+		"tenants.groups.billing.permissions.publish.allow",
+		"tenants.groups.payments.permissions.publish.allow",
+	}, keys)
+}
+
+func TestAuthorizeResult_Groups(t *testing.T) {
+	assert.Nil(t, AuthorizeResult{}.Groups())
+	assert.Equal(t, []string{"a"}, AuthorizeResult{Verified: &VerifiedToken{Groups: []string{"a"}}}.Groups())
+	assert.Equal(t, []string{"a", "b"}, AuthorizeResult{Cached: &TokenCacheEntry{Groups: "a,b"}}.Groups())
+	assert.Nil(t, AuthorizeResult{Cached: &TokenCacheEntry{}}.Groups())
+}
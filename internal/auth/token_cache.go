@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"time"
 )
 
 var (
@@ -18,9 +19,30 @@ var (
 //
 // NOTE: Never store plaintext tokens.
 type TokenCacheEntry struct {
-	Username       string `json:"username"`
-	Scopes         string `json:"scopes"`
+	// UserID is the numeric GitLab user ID; 0 in entries written before it
+	// was recorded.
+	UserID   int64  `json:"user_id,omitempty"`
+	Username string `json:"username"`
+	Scopes   string `json:"scopes"`
+	Groups   string `json:"groups,omitempty"`
+	// TokenType is empty for personal and OAuth tokens written before it
+	// was recorded; deploy tokens always have it.
+	TokenType string `json:"token_type,omitempty"`
+	// Project is the job's project path of CI/CD job tokens.
+	Project        string `json:"project,omitempty"`
 	LastVerifiedAt string `json:"last_verified_at"`
+	// ExpiresAt is set when a TTL override applies to the entry; it is
+	// enforced on read when the bucket can't expire single keys.
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// expired reports whether an entry with a TTL override has expired.
+func (e *TokenCacheEntry) expired(now time.Time) bool {
+	if e.ExpiresAt == "" {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, e.ExpiresAt)
+	return err == nil && !now.Before(at)
 }
 
 // TokenCache is a token cache implemented ONLY via NATS JetStream Key-Value.
@@ -34,6 +56,12 @@ type TokenCache interface {
 	Put(ctx context.Context, token string, entry TokenCacheEntry) error
 }
 
+// TokenCacheInvalidator is implemented by caches that can drop every entry
+// at once, e.g. after an issuer key compromise.
+type TokenCacheInvalidator interface {
+	InvalidateAll(ctx context.Context) (int, error)
+}
+
 func tokenCacheKey(token string, secret []byte) (string, error) {
 	if token == "" {
 		return "", ErrInvalidToken
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// TokenCacheAdmin operates on the token cache bucket directly. It backs the
+// `antal cache` maintenance commands, for break-glass work when the admin
+// HTTP API of the running service is unreachable.
+type TokenCacheAdmin struct {
+	kv  nats.KeyValue
+	now func() time.Time
+}
+
+// TokenCacheItem is a cache entry with its (HMAC) key.
+type TokenCacheItem struct {
+	Key   string
+	Entry TokenCacheEntry
+	// Expired is set for entries past their TTL override that the bucket has
+	// not removed yet.
+	Expired bool
+}
+
+// OpenTokenCacheAdmin binds to an existing token cache bucket. Unlike the
+// service, it never creates the bucket.
+func OpenTokenCacheAdmin(js nats.JetStreamContext, bucket string) (*TokenCacheAdmin, error) {
+	if bucket == "" {
+		return nil, errors.New("token_cache.bucket is empty")
+	}
+	kv, err := js.KeyValue(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bucket %q: %w", bucket, err)
+	}
+	return &TokenCacheAdmin{kv: kv, now: time.Now}, nil
+}
+
+// List returns every entry in the bucket, including entries past their TTL
+// override. Entries that cannot be decoded are returned with an empty entry.
+func (a *TokenCacheAdmin) List() ([]TokenCacheItem, error) {
+	keys, err := a.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list token cache keys: %w", err)
+	}
+
+	now := a.now()
+	items := make([]TokenCacheItem, 0, len(keys))
+	for _, key := range keys {
+		kve, err := a.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue // expired or purged since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token cache entry %s: %w", key, err)
+		}
+		item := TokenCacheItem{Key: key}
+		if entry, err := unmarshalTokenCacheEntry(kve.Value()); err == nil {
+			item.Entry, item.Expired = *entry, entry.expired(now)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Remove purges the given keys. Unknown keys are an error, so a mistyped key
+// does not go unnoticed; the keys before it are removed.
+func (a *TokenCacheAdmin) Remove(keys ...string) (int, error) {
+	removed := 0
+	for _, key := range keys {
+		if _, err := a.kv.Get(key); err != nil {
+			if errors.Is(err, nats.ErrKeyNotFound) {
+				return removed, fmt.Errorf("no token cache entry with key %s", key)
+			}
+			return removed, fmt.Errorf("failed to read token cache entry %s: %w", key, err)
+		}
+		if err := a.kv.Purge(key); err != nil {
+			return removed, fmt.Errorf("failed to purge token cache entry %s: %w", key, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Purge removes every entry and returns how many were removed.
+func (a *TokenCacheAdmin) Purge() (int, error) {
+	keys, err := a.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to list token cache keys: %w", err)
+	}
+	purged := 0
+	for _, key := range keys {
+		if err := a.kv.Purge(key); err != nil {
+			return purged, fmt.Errorf("failed to purge token cache entry: %w", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Stats summarizes the live entries like the admin API report.
+func (a *TokenCacheAdmin) Stats(ttl time.Duration) (TokenCacheReport, error) {
+	items, err := a.List()
+	if err != nil {
+		return TokenCacheReport{}, err
+	}
+	entries := make([]TokenCacheEntry, 0, len(items))
+	for _, item := range items {
+		if !item.Expired {
+			entries = append(entries, item.Entry)
+		}
+	}
+	return summarizeTokenCache(entries, ttl, a.now()), nil
+}
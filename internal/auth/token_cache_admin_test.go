@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKV implements the parts of nats.KeyValue the token cache admin uses.
+type memoryKV struct {
+	nats.KeyValue
+	data map[string][]byte
+}
+
+type memoryKVEntry struct {
+	nats.KeyValueEntry
+	value []byte
+}
+
+func (e memoryKVEntry) Value() []byte { return e.value }
+
+func (m *memoryKV) Keys(...nats.WatchOpt) ([]string, error) {
+	if len(m.data) == 0 {
+		return nil, nats.ErrNoKeysFound
+	}
+	return slices.Sorted(maps.Keys(m.data)), nil
+}
+
+func (m *memoryKV) Get(key string) (nats.KeyValueEntry, error) {
+	value, ok := m.data[key]
+	if !ok {
+		return nil, nats.ErrKeyNotFound
+	}
+	return memoryKVEntry{value: value}, nil
+}
+
+func (m *memoryKV) Put(key string, value []byte) (uint64, error) {
+	m.data[key] = value
+	return uint64(len(m.data)), nil
+}
+
+func (m *memoryKV) Purge(key string, _ ...nats.DeleteOpt) error {
+	delete(m.data, key)
+	return nil
+}
+
+func TestTokenCacheAdmin(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(e TokenCacheEntry) []byte {
+		b, err := marshalTokenCacheEntry(e)
+		require.NoError(t, err)
+		return b
+	}
+	kv := &memoryKV{data: map[string][]byte{
+		"k1": entry(TokenCacheEntry{UserID: 1, Username: "alice", Scopes: "read_api", LastVerifiedAt: now.Add(-time.Minute).Format(time.RFC3339)}),
+		"k2": entry(TokenCacheEntry{Username: "bob", ExpiresAt: now.Add(-time.Second).Format(time.RFC3339)}),
+		"k3": []byte("garbage"),
+	}}
+	admin := &TokenCacheAdmin{kv: kv, now: func() time.Time { return now }}
+
+	items, err := admin.List()
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	assert.Equal(t, "alice", items[0].Entry.Username)
+	assert.Equal(t, int64(1), items[0].Entry.UserID)
+	assert.False(t, items[0].Expired)
+	assert.True(t, items[1].Expired, "TTL override passed")
+	assert.Equal(t, TokenCacheItem{Key: "k3"}, items[2], "undecodable entries are still listed")
+
+	report, err := admin.Stats(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Entries, "expired entries are not counted")
+
+	removed, err := admin.Remove("k3", "missing", "k2")
+	assert.Equal(t, 1, removed)
+	assert.ErrorContains(t, err, "no token cache entry with key missing")
+	assert.Contains(t, kv.data, "k2", "keys after an unknown one are kept")
+
+	purged, err := admin.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Empty(t, kv.data)
+
+	items, err = admin.List()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
@@ -7,11 +7,30 @@ import (
 )
 
 type TokenCacheConfig struct {
-	Enabled    bool
-	TTL        time.Duration
-	Bucket     string
-	Replicas   int
+	Enabled  bool
+	TTL      time.Duration
+	Bucket   string
+	Replicas int
+	// MaxBytes limits the bucket size; 0 is unlimited. Only set for tenant
+	// buckets.
+	MaxBytes   int64
 	HMACSecret string
+	// WriteQueueSize bounds the background write queue; 0 writes synchronously.
+	WriteQueueSize int
+	// WriteBatchSize is the maximum number of writes flushed together.
+	WriteBatchSize int
+	// MemorySize bounds the in-process LRU of recently verified tokens;
+	// 0 disables it.
+	MemorySize int
+	// MemoryTTL is how long a verification is trusted without GitLab.
+	MemoryTTL time.Duration
+	// GetTimeout and PutTimeout bound one KV read or write, within the
+	// deadline of the auth request; 0 leaves only that deadline.
+	GetTimeout time.Duration
+	PutTimeout time.Duration
+	// HedgeDelay sends a second read when the first did not answer within
+	// it; 0 disables hedged reads.
+	HedgeDelay time.Duration
 }
 
 func LoadTokenCacheConfig() TokenCacheConfig {
@@ -21,5 +40,15 @@ func LoadTokenCacheConfig() TokenCacheConfig {
 		Bucket:     viper.GetString("token_cache.bucket"),
 		Replicas:   viper.GetInt("token_cache.replicas"),
 		HMACSecret: viper.GetString("token_cache.hmac_secret"),
+
+		WriteQueueSize: viper.GetInt("token_cache.write_queue.size"),
+		WriteBatchSize: viper.GetInt("token_cache.write_queue.batch_size"),
+
+		MemorySize: viper.GetInt("token_cache.memory.size"),
+		MemoryTTL:  viper.GetDuration("token_cache.memory.ttl"),
+
+		GetTimeout: viper.GetDuration("token_cache.get_timeout"),
+		PutTimeout: viper.GetDuration("token_cache.put_timeout"),
+		HedgeDelay: viper.GetDuration("token_cache.hedge_delay"),
 	}
 }
@@ -17,6 +17,10 @@ func TestLoadTokenCacheConfig_ReadsValuesFromViper(t *testing.T) {
 	viper.Set("token_cache.bucket", "bucket_a")
 	viper.Set("token_cache.replicas", 2)
 	viper.Set("token_cache.hmac_secret", "secret")
+	viper.Set("token_cache.write_queue.size", 64)
+	viper.Set("token_cache.write_queue.batch_size", 8)
+	viper.Set("token_cache.get_timeout", "500ms")
+	viper.Set("token_cache.hedge_delay", "50ms")
 
 	cfg := LoadTokenCacheConfig()
 	require.True(t, cfg.Enabled)
@@ -24,4 +28,8 @@ func TestLoadTokenCacheConfig_ReadsValuesFromViper(t *testing.T) {
 	require.Equal(t, "bucket_a", cfg.Bucket)
 	require.Equal(t, 2, cfg.Replicas)
 	require.Equal(t, "secret", cfg.HMACSecret)
+	require.Equal(t, 64, cfg.WriteQueueSize)
+	require.Equal(t, 8, cfg.WriteBatchSize)
+	require.Equal(t, 500*time.Millisecond, cfg.GetTimeout)
+	require.Equal(t, 50*time.Millisecond, cfg.HedgeDelay)
 }
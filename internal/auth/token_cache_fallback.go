@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// cacheFallbackSlots is how many slots the window is split into; verifications
+// leave the window one slot at a time.
+const cacheFallbackSlots = 10
+
+// CacheFallbackAlertConfig holds the token_cache.fallback_alert.* settings.
+type CacheFallbackAlertConfig struct {
+	// Threshold is the share of verifications answered from the KV cache
+	// instead of GitLab above which GitLab is reported degraded; 0 disables
+	// the alert.
+	Threshold float64
+	// Window is the span the share is computed over.
+	Window time.Duration
+	// MinVerifications is how many verifications the window needs before
+	// the share is judged, so a handful of requests at night cannot raise it.
+	MinVerifications int
+}
+
+// LoadCacheFallbackAlertConfig reads the token_cache.fallback_alert.* settings.
+func LoadCacheFallbackAlertConfig() CacheFallbackAlertConfig {
+	return CacheFallbackAlertConfig{
+		Threshold:        viper.GetFloat64("token_cache.fallback_alert.threshold"),
+		Window:           viper.GetDuration("token_cache.fallback_alert.window"),
+		MinVerifications: viper.GetInt("token_cache.fallback_alert.min_verifications"),
+	}
+}
+
+// Validate checks an enabled configuration.
+func (cfg CacheFallbackAlertConfig) Validate() error {
+	switch {
+	case cfg.Threshold <= 0:
+		return nil
+	case cfg.Threshold > 1:
+		return fmt.Errorf("token_cache.fallback_alert: threshold must be a share between 0 and 1")
+	case cfg.Window < cacheFallbackSlots*time.Second:
+		return fmt.Errorf("token_cache.fallback_alert: window must be at least %ds", cacheFallbackSlots)
+	}
+	return nil
+}
+
+// cacheFallbackSlot counts the verifications of one slot of the window.
+type cacheFallbackSlot struct {
+	start       time.Time
+	total, hits int
+}
+
+// CacheFallbackMonitor tracks which share of successful token verifications
+// the KV cache answered because GitLab could not, over a sliding window.
+// Single failed GitLab calls are logged per request and drown in noise; a
+// growing share is the signal that GitLab is degrading.
+type CacheFallbackMonitor struct {
+	cfg    CacheFallbackAlertConfig
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	slots    [cacheFallbackSlots]cacheFallbackSlot
+	degraded bool
+}
+
+// NewCacheFallbackMonitor creates a monitor for a validated, enabled config.
+func NewCacheFallbackMonitor(cfg CacheFallbackAlertConfig) *CacheFallbackMonitor {
+	return &CacheFallbackMonitor{cfg: cfg, logger: slog.With("component", "token_cache")}
+}
+
+// Record counts a successful verification, fromCache when the KV cache
+// answered it, and returns the share of cache answers in the window and
+// whether it is above the threshold.
+func (m *CacheFallbackMonitor) Record(fromCache bool, now time.Time) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	width := m.cfg.Window / cacheFallbackSlots
+	start := now.Truncate(width)
+	slot := &m.slots[start.UnixNano()/int64(width)%cacheFallbackSlots]
+	if !slot.start.Equal(start) {
+		*slot = cacheFallbackSlot{start: start}
+	}
+	slot.total++
+	if fromCache {
+		slot.hits++
+	}
+
+	var total, hits int
+	for _, s := range m.slots {
+		if now.Sub(s.start) < m.cfg.Window {
+			total, hits = total+s.total, hits+s.hits
+		}
+	}
+	ratio := float64(hits) / float64(total)
+	cacheFallbackRatio.Set(ratio)
+
+	// Once raised, the alert holds until the share is back under the
+	// threshold, even if traffic drops below the minimum meanwhile.
+	degraded := ratio > m.cfg.Threshold && (m.degraded || total >= m.cfg.MinVerifications)
+	switch {
+	case degraded && !m.degraded:
+		m.logger.Warn("Token cache is answering a growing share of verifications, GitLab may be degraded",
+			"cache_share", ratio,
+			"threshold", m.cfg.Threshold,
+			"window", m.cfg.Window,
+			"verifications", total,
+		)
+		cacheFallbackDegraded.Set(1)
+	case !degraded && m.degraded:
+		m.logger.Info("GitLab is answering verifications again", "cache_share", ratio, "window", m.cfg.Window)
+		cacheFallbackDegraded.Set(0)
+	}
+	m.degraded = degraded
+	return ratio, degraded
+}
+
+// recordCacheFallback counts a successful verification of a token for the
+// fallback alert. Memory hits say nothing about GitLab and are skipped.
+func (c *NATSClient) recordCacheFallback(result AuthorizeResult) {
+	if c.cacheFallback == nil || result.FromMemory {
+		return
+	}
+	c.cacheFallback.Record(result.FromCache, time.Now())
+}
+
+// initCacheFallbackAlert optionally alerts when the token cache answers a
+// growing share of verifications. It needs the token cache.
+func (c *NATSClient) initCacheFallbackAlert() error {
+	cfg := LoadCacheFallbackAlertConfig()
+	if cfg.Threshold <= 0 || c.tokenCache == nil {
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	c.cacheFallback = NewCacheFallbackMonitor(cfg)
+	c.logger.Info("Token cache fallback alert enabled",
+		"threshold", cfg.Threshold, "window", cfg.Window, "min_verifications", cfg.MinVerifications)
+	return nil
+}
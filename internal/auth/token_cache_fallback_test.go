@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheFallbackMonitor(t *testing.T) {
+	m := NewCacheFallbackMonitor(CacheFallbackAlertConfig{Threshold: 0.5, Window: 10 * time.Minute, MinVerifications: 4})
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	_, degraded := m.Record(true, now)
+	assert.False(t, degraded, "too few verifications to judge")
+	m.Record(false, now)
+	m.Record(true, now.Add(time.Minute))
+	ratio, degraded := m.Record(true, now.Add(2*time.Minute))
+	assert.Equal(t, 0.75, ratio)
+	assert.True(t, degraded)
+	assert.Equal(t, 1.0, testutil.ToFloat64(cacheFallbackDegraded))
+
+	// The first minute leaves the window.
+	ratio, degraded = m.Record(false, now.Add(10*time.Minute+30*time.Second))
+	assert.Equal(t, 2.0/3, ratio)
+	assert.True(t, degraded, "the alert holds below the minimum while the share stays high")
+
+	ratio, degraded = m.Record(false, now.Add(11*time.Minute))
+	assert.Equal(t, 1.0/3, ratio)
+	assert.False(t, degraded)
+	assert.Equal(t, 0.0, testutil.ToFloat64(cacheFallbackDegraded))
+	assert.Equal(t, 1.0/3, testutil.ToFloat64(cacheFallbackRatio))
+}
+
+func TestCacheFallbackAlertConfig_Validate(t *testing.T) {
+	require.NoError(t, CacheFallbackAlertConfig{}.Validate(), "disabled")
+	require.NoError(t, CacheFallbackAlertConfig{Threshold: 0.2, Window: 5 * time.Minute}.Validate())
+	assert.ErrorContains(t, CacheFallbackAlertConfig{Threshold: 20, Window: 5 * time.Minute}.Validate(), "between 0 and 1")
+	assert.ErrorContains(t, CacheFallbackAlertConfig{Threshold: 0.2, Window: time.Second}.Validate(), "window must be")
+}
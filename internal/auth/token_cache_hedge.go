@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// kvGetResult is the outcome of one KV read of a hedged get.
+type kvGetResult struct {
+	entry jetstream.KeyValueEntry
+	err   error
+	hedge bool
+}
+
+// hedgedGet reads key and, when no answer arrived within delay, sends a
+// second read. KV buckets allow direct gets, which any replica of the stream
+// answers, so the second read usually reaches another replica than a slow
+// first one. The first hit or miss wins and the other read is canceled. Other
+// errors are returned once no read is outstanding; an error before delay is
+// not hedged (transient errors are retried, see nats.kv_retry).
+func hedgedGet(ctx context.Context, kv jetstream.KeyValue, key string, delay time.Duration) (jetstream.KeyValueEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan kvGetResult, 2)
+	get := func(hedge bool) {
+		entry, err := kv.Get(ctx, key)
+		results <- kvGetResult{entry: entry, err: err, hedge: hedge}
+	}
+	go get(false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	for {
+		select {
+		case <-timer.C:
+			pending, hedged = pending+1, true
+			go get(true)
+		case res := <-results:
+			pending--
+			if res.err == nil || errors.Is(res.err, jetstream.ErrKeyNotFound) {
+				if res.hedge {
+					tokenCacheHedgedGetsTotal.WithLabelValues("hedge").Inc()
+				} else if hedged {
+					tokenCacheHedgedGetsTotal.WithLabelValues("primary").Inc()
+				}
+				return res.entry, res.err
+			}
+			if pending == 0 {
+				if hedged {
+					tokenCacheHedgedGetsTotal.WithLabelValues("none").Inc()
+				}
+				return nil, res.err
+			}
+		}
+	}
+}
+
+// withOperationTimeout bounds one KV operation by timeout, when set, on top
+// of the deadline ctx already has.
+func withOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// countTimeout counts a token cache KV operation that ran out of time.
+func countTimeout(op string, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+		tokenCacheTimeoutsTotal.WithLabelValues(op).Inc()
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowKV answers the first stalled reads only when their context ends, like
+// a KV replica that stopped responding.
+type slowKV struct {
+	*jetstreamKV
+	stalled atomic.Int32
+	calls   atomic.Int32
+}
+
+func (k *slowKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	if k.calls.Add(1) <= k.stalled.Load() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return k.jetstreamKV.Get(ctx, key)
+}
+
+func newSlowCache(t *testing.T, stalled int32, getTimeout, hedgeDelay time.Duration) (*JetStreamTokenCache, *slowKV) {
+	t.Helper()
+	kv := &slowKV{jetstreamKV: newJetstreamKV()}
+	cache := &JetStreamTokenCache{kv: kv, secret: []byte("secret"), logger: slog.Default(), now: time.Now,
+		getTimeout: getTimeout, hedgeDelay: hedgeDelay}
+	require.NoError(t, cache.Put(context.Background(), "glpat-a", TokenCacheEntry{Username: "alice"}))
+	kv.stalled.Store(stalled)
+	return cache, kv
+}
+
+func TestJetStreamTokenCache_HedgedGet(t *testing.T) {
+	cache, kv := newSlowCache(t, 1, 5*time.Second, 10*time.Millisecond)
+	hedgeWins := testutil.ToFloat64(tokenCacheHedgedGetsTotal.WithLabelValues("hedge"))
+
+	start := time.Now()
+	entry, err := cache.Get(context.Background(), "glpat-a")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", entry.Username)
+	assert.Less(t, time.Since(start), time.Second, "the hedged read answers for the stalled one")
+	assert.Equal(t, int32(2), kv.calls.Load())
+	assert.Equal(t, hedgeWins+1, testutil.ToFloat64(tokenCacheHedgedGetsTotal.WithLabelValues("hedge")))
+
+	_, err = cache.Get(context.Background(), "glpat-unknown")
+	assert.ErrorIs(t, err, ErrTokenCacheMiss, "a fast miss is not hedged")
+	assert.Equal(t, int32(3), kv.calls.Load())
+}
+
+func TestJetStreamTokenCache_GetTimeout(t *testing.T) {
+	cache, kv := newSlowCache(t, 2, 30*time.Millisecond, 0)
+	timeouts := testutil.ToFloat64(tokenCacheTimeoutsTotal.WithLabelValues("get"))
+
+	_, err := cache.Get(context.Background(), "glpat-a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(tokenCacheTimeoutsTotal.WithLabelValues("get")))
+
+	cache.hedgeDelay = 10 * time.Millisecond
+	kv.calls.Store(0)
+	_, err = cache.Get(context.Background(), "glpat-a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "both reads stalled")
+	assert.Equal(t, int32(2), kv.calls.Load())
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// TokenCacheRemover is implemented by caches that can drop selected entries,
+// e.g. the tokens of a user whose PAT was revoked.
+type TokenCacheRemover interface {
+	Invalidate(ctx context.Context, sel TokenCacheSelection) (int, error)
+}
+
+// tokenCacheKeyer is implemented by caches that can derive an entry's key
+// from its token, so writes not stored yet can be selected by key.
+type tokenCacheKeyer interface {
+	tokenKey(token string) (string, error)
+}
+
+// tokenKey returns the key of token's entry.
+func (c *JetStreamTokenCache) tokenKey(token string) (string, error) {
+	return tokenCacheKey(token, c.secret)
+}
+
+// TokenCacheSelection selects token cache entries by owner or by key (the
+// HMAC of the token, as listed by `antal cache ls`).
+type TokenCacheSelection struct {
+	Usernames []string `json:"usernames,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+}
+
+// Empty reports whether the selection matches nothing.
+func (s TokenCacheSelection) Empty() bool {
+	return len(s.Usernames) == 0 && len(s.Keys) == 0
+}
+
+// matchesKey reports whether key is selected.
+func (s TokenCacheSelection) matchesKey(key string) bool {
+	return slices.Contains(s.Keys, key)
+}
+
+// matchesEntry reports whether an entry is selected by its owner. Usernames
+// are compared ignoring case, as in GitLab.
+func (s TokenCacheSelection) matchesEntry(entry TokenCacheEntry) bool {
+	if entry.Username == "" {
+		return false
+	}
+	return slices.ContainsFunc(s.Usernames, func(username string) bool {
+		return strings.EqualFold(username, entry.Username)
+	})
+}
+
+// Invalidate purges the selected entries from the bucket and returns how many
+// were removed. Selecting by username reads every entry, like Entries.
+func (c *JetStreamTokenCache) Invalidate(ctx context.Context, sel TokenCacheSelection) (int, error) {
+	keys, err := c.keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		if !sel.matchesKey(key) {
+			if len(sel.Usernames) == 0 {
+				continue
+			}
+			kve, err := c.kv.Get(ctx, key)
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				continue // expired or purged since listing
+			}
+			if err != nil {
+				return purged, fmt.Errorf("failed to read token cache entry: %w", err)
+			}
+			entry, err := unmarshalTokenCacheEntry(kve.Value())
+			if err != nil || !sel.matchesEntry(*entry) {
+				continue
+			}
+		}
+		if err := c.kv.Purge(ctx, key); err != nil {
+			return purged, fmt.Errorf("failed to purge token cache entry: %w", err)
+		}
+		purged++
+	}
+	c.logger.Warn("Token cache entries invalidated", "bucket", c.bucket, "usernames", sel.Usernames, "keys", len(sel.Keys), "entries", purged)
+	return purged, nil
+}
+
+// tokenCacheInvalidateRequest is the body of the invalidation endpoint.
+type tokenCacheInvalidateRequest struct {
+	TokenCacheSelection
+	Reason string `json:"reason"`
+}
+
+// TokenCacheInvalidateHandler removes selected token cache entries (POST,
+// JSON body {"usernames": [...], "keys": [...], "reason": "..."}), so the
+// next connection of those tokens is verified with GitLab again. It must be
+// mounted behind admin authentication.
+func (c *NATSClient) TokenCacheInvalidateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body tokenCacheInvalidateRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if body.Empty() {
+			http.Error(w, "usernames or keys are required", http.StatusBadRequest)
+			return
+		}
+
+		remover, ok := c.tokenCache.(TokenCacheRemover)
+		if !ok {
+			http.Error(w, "token cache is not enabled", http.StatusNotFound)
+			return
+		}
+		removed, err := remover.Invalidate(r.Context(), body.TokenCacheSelection)
+		attrs := []any{"usernames", body.Usernames, "keys", len(body.Keys), "removed", removed, "reason", body.Reason}
+		if err != nil {
+			c.logger.Error("Failed to invalidate token cache entries", append(attrs, "error", err)...)
+			audit("token_cache.invalidate", "failed", append(attrs, "error", err)...)
+			http.Error(w, "failed to invalidate token cache entries", http.StatusBadGateway)
+			return
+		}
+		audit("token_cache.invalidate", "ok", attrs...)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+	})
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	kv := newJetstreamKV()
+	kvCache := &JetStreamTokenCache{kv: kv, secret: []byte("secret"), logger: slog.Default(), now: func() time.Time { return now }}
+	for token, username := range map[string]string{"glpat-a1": "alice", "glpat-a2": "alice", "glpat-b": "bob", "glpat-c": "carol"} {
+		require.NoError(t, kvCache.Put(ctx, token, TokenCacheEntry{Username: username}))
+	}
+	kv.data["garbage"] = []byte("garbage")
+
+	cache := NewMemoryTokenCache(kvCache, "secret", 10, time.Minute)
+	cache.now = func() time.Time { return now }
+	require.NoError(t, cache.Put(ctx, "glpat-a1", TokenCacheEntry{Username: "alice"}))
+	require.NoError(t, cache.Put(ctx, "glpat-b", TokenCacheEntry{Username: "bob"}))
+	require.NoError(t, cache.Put(ctx, "glpat-c", TokenCacheEntry{Username: "carol"}))
+
+	bobKey, err := tokenCacheKey("glpat-b", []byte("secret"))
+	require.NoError(t, err)
+
+	removed, err := cache.Invalidate(ctx, TokenCacheSelection{Usernames: []string{"ALICE"}, Keys: []string{bobKey}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Len(t, kv.data, 2, "carol and the undecodable entry are kept")
+
+	_, ok := cache.Recent("glpat-a1")
+	assert.False(t, ok, "selected by username, ignoring case")
+	_, ok = cache.Recent("glpat-b")
+	assert.False(t, ok, "selected by key")
+	_, ok = cache.Recent("glpat-c")
+	assert.True(t, ok)
+
+	removed, err = cache.Invalidate(ctx, TokenCacheSelection{Usernames: []string{"nobody"}})
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestTokenCacheInvalidateHandler(t *testing.T) {
+	post := func(c *NATSClient, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.TokenCacheInvalidateHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/token_cache/invalidate", strings.NewReader(body)))
+		return rec
+	}
+
+	kv := newJetstreamKV()
+	kvCache := &JetStreamTokenCache{kv: kv, secret: []byte("secret"), logger: slog.Default(), now: time.Now}
+	require.NoError(t, kvCache.Put(context.Background(), "glpat-a", TokenCacheEntry{Username: "alice"}))
+	c := &NATSClient{logger: slog.Default(), tokenCache: kvCache}
+
+	rec := httptest.NewRecorder()
+	c.TokenCacheInvalidateHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/token_cache/invalidate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+
+	assert.Equal(t, http.StatusBadRequest, post(c, "{").Code)
+	assert.Equal(t, http.StatusBadRequest, post(c, `{"reason": "revoked"}`).Code, "an empty selection is rejected")
+	assert.Equal(t, http.StatusNotFound, post(&NATSClient{logger: slog.Default()}, `{"usernames": ["alice"]}`).Code)
+
+	rec = post(c, `{"usernames": ["alice"], "reason": "PAT revoked"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Removed int `json:"removed"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, 1, resp.Removed)
+	assert.Empty(t, kv.data)
+}
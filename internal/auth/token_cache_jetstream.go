@@ -5,18 +5,36 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+// JetStreamTokenCache stores token cache entries in a JetStream KV bucket.
+// It uses the jetstream API, so every operation ends at the deadline of the
+// context it is given.
 type JetStreamTokenCache struct {
-	kv     nats.KeyValue
+	kv     jetstream.KeyValue
 	secret []byte
 	logger *slog.Logger
 	bucket string
+	ttl    time.Duration
+	now    func() time.Time
+
+	// getTimeout and putTimeout bound single KV operations; hedgeDelay
+	// enables hedged reads (see hedgedGet).
+	getTimeout time.Duration
+	putTimeout time.Duration
+	hedgeDelay time.Duration
+
+	// js is used for per-key TTL writes when the bucket's stream allows
+	// per-message TTLs (NATS 2.11+ with allow_msg_ttl); nil otherwise.
+	js jetstream.JetStream
 }
 
-func NewJetStreamTokenCache(js nats.JetStreamContext, cfg TokenCacheConfig) (*JetStreamTokenCache, error) {
+func NewJetStreamTokenCache(ctx context.Context, js jetstream.JetStream, cfg TokenCacheConfig) (*JetStreamTokenCache, error) {
 	logger := slog.With("component", "token_cache_jetstream")
 
 	if js == nil {
@@ -36,20 +54,12 @@ func NewJetStreamTokenCache(js nats.JetStreamContext, cfg TokenCacheConfig) (*Je
 	}
 
 	// Bind to the existing KV bucket or create it if missing.
-	created := false
-	kv, err := js.KeyValue(cfg.Bucket)
-	if err != nil {
-		if errors.Is(err, nats.ErrBucketNotFound) {
-			kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
-				Bucket:   cfg.Bucket,
-				TTL:      cfg.TTL,
-				Replicas: cfg.Replicas,
-			})
-			if err == nil {
-				created = true
-			}
-		}
-	}
+	kv, created, err := bindOrCreateKeyValue(ctx, js, jetstream.KeyValueConfig{
+		Bucket:   cfg.Bucket,
+		TTL:      cfg.TTL,
+		Replicas: cfg.Replicas,
+		MaxBytes: cfg.MaxBytes,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to access token cache bucket %q: %w", cfg.Bucket, err)
 	}
@@ -68,12 +78,23 @@ func NewJetStreamTokenCache(js nats.JetStreamContext, cfg TokenCacheConfig) (*Je
 		)
 	}
 
-	return &JetStreamTokenCache{kv: kv, secret: []byte(cfg.HMACSecret), logger: logger, bucket: cfg.Bucket}, nil
+	cache := &JetStreamTokenCache{kv: kv, secret: []byte(cfg.HMACSecret), logger: logger, bucket: cfg.Bucket, ttl: cfg.TTL, now: time.Now,
+		getTimeout: cfg.GetTimeout, putTimeout: cfg.PutTimeout, hedgeDelay: cfg.HedgeDelay}
+
+	// Per-key expiry needs per-message TTL support on every server
+	// (nats.server_compat) and on the KV stream; otherwise TTL overrides are
+	// enforced on read.
+	compat, _ := LoadServerCompat()
+	if stream, err := js.Stream(ctx, "KV_"+cfg.Bucket); err == nil && compat.MsgTTL && stream.CachedInfo().Config.AllowMsgTTL {
+		cache.js = js
+	}
+	logger.Info("Token cache TTL overrides", "per_key_expiry", cache.js != nil)
+	logger.Info("Token cache operation limits", "get_timeout", cfg.GetTimeout, "put_timeout", cfg.PutTimeout, "hedge_delay", cfg.HedgeDelay)
+
+	return cache, nil
 }
 
 func (c *JetStreamTokenCache) Get(ctx context.Context, token string) (*TokenCacheEntry, error) {
-	_ = ctx // nats.go KV API doesn't accept context in v1; keep for interface stability.
-
 	key, err := tokenCacheKey(token, c.secret)
 	if err != nil {
 		return nil, err
@@ -83,15 +104,16 @@ func (c *JetStreamTokenCache) Get(ctx context.Context, token string) (*TokenCach
 		keyPrefix = keyPrefix[:12]
 	}
 
-	entry, err := c.kv.Get(key)
+	entry, err := c.get(ctx, key)
 	if err != nil {
-		if errors.Is(err, nats.ErrKeyNotFound) {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
 			c.logger.Debug("Token cache miss",
 				"bucket", c.bucket,
 				"key_prefix", keyPrefix,
 			)
 			return nil, ErrTokenCacheMiss
 		}
+		countTimeout("get", err)
 		c.logger.Warn("Token cache get failed",
 			"bucket", c.bucket,
 			"key_prefix", keyPrefix,
@@ -111,6 +133,15 @@ func (c *JetStreamTokenCache) Get(ctx context.Context, token string) (*TokenCach
 		return nil, err
 	}
 
+	if out.expired(c.now()) {
+		c.logger.Debug("Token cache entry expired (TTL override)",
+			"bucket", c.bucket,
+			"key_prefix", keyPrefix,
+			"expires_at", out.ExpiresAt,
+		)
+		return nil, ErrTokenCacheMiss
+	}
+
 	c.logger.Debug("Token cache hit",
 		"bucket", c.bucket,
 		"key_prefix", keyPrefix,
@@ -120,8 +151,6 @@ func (c *JetStreamTokenCache) Get(ctx context.Context, token string) (*TokenCach
 }
 
 func (c *JetStreamTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
-	_ = ctx // nats.go KV API doesn't accept context in v1; keep for interface stability.
-
 	key, err := tokenCacheKey(token, c.secret)
 	if err != nil {
 		return err
@@ -131,13 +160,30 @@ func (c *JetStreamTokenCache) Put(ctx context.Context, token string, entry Token
 		keyPrefix = keyPrefix[:12]
 	}
 
+	ttl, overridden := c.ttlOverride(entry)
+	if overridden {
+		entry.ExpiresAt = c.now().Add(ttl).UTC().Format(time.RFC3339)
+	}
+
 	data, err := marshalTokenCacheEntry(entry)
 	if err != nil {
 		return err
 	}
 
-	rev, err := c.kv.Put(key, data)
+	ctx, cancel := withOperationTimeout(ctx, c.putTimeout)
+	defer cancel()
+	var rev uint64
+	if overridden && c.js != nil {
+		var ack *jetstream.PubAck
+		ack, err = c.js.Publish(ctx, "$KV."+c.bucket+"."+key, data, jetstream.WithMsgTTL(ttl))
+		if err == nil {
+			rev = ack.Sequence
+		}
+	} else {
+		rev, err = c.kv.Put(ctx, key, data)
+	}
 	if err != nil {
+		countTimeout("put", err)
 		c.logger.Info("Token cache put failed",
 			"bucket", c.bucket,
 			"key_prefix", keyPrefix,
@@ -150,6 +196,85 @@ func (c *JetStreamTokenCache) Put(ctx context.Context, token string, entry Token
 		"bucket", c.bucket,
 		"key_prefix", keyPrefix,
 		"revision", rev,
+		"ttl_override", ttl,
 	)
 	return nil
 }
+
+// get reads key within the get timeout, hedged when enabled.
+func (c *JetStreamTokenCache) get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.getTimeout)
+	defer cancel()
+	if c.hedgeDelay > 0 {
+		return hedgedGet(ctx, c.kv, key, c.hedgeDelay)
+	}
+	return c.kv.Get(ctx, key)
+}
+
+// ttlOverride returns the configured TTL override for the entry's user,
+// capped at the bucket TTL, which always applies.
+func (c *JetStreamTokenCache) ttlOverride(entry TokenCacheEntry) (time.Duration, bool) {
+	overrides, err := LoadTTLOverrides()
+	if err != nil {
+		c.logger.Warn("Ignoring token cache TTL overrides", "error", err)
+		return 0, false
+	}
+
+	var groups []string
+	if entry.Groups != "" {
+		groups = strings.Split(entry.Groups, ",")
+	}
+	ttl, ok := ResolveTTL(overrides, entry.Username, groups)
+	if !ok {
+		return 0, false
+	}
+	if c.ttl > 0 && ttl > c.ttl {
+		ttl = c.ttl
+	}
+	return ttl, true
+}
+
+// InvalidateAll purges every entry from the bucket and returns how many were removed.
+func (c *JetStreamTokenCache) InvalidateAll(ctx context.Context) (int, error) {
+	keys, err := c.keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		if err := c.kv.Purge(ctx, key); err != nil {
+			return purged, fmt.Errorf("failed to purge token cache entry: %w", err)
+		}
+		purged++
+	}
+	c.logger.Warn("Token cache invalidated", "bucket", c.bucket, "entries", purged)
+	return purged, nil
+}
+
+// keys lists the keys in the bucket.
+func (c *JetStreamTokenCache) keys(ctx context.Context) ([]string, error) {
+	lister, err := c.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list token cache keys: %w", err)
+	}
+	var keys []string
+	for key := range lister.Keys() {
+		keys = append(keys, key)
+	}
+	// The listing ends early, without an error, when ctx is done.
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list token cache keys: %w", err)
+	}
+	return keys, nil
+}
+
+// migrateTokenCache applies the token cache schema migrations to bucket.
+// Migrations run on the nats.KeyValue API.
+func (c *NATSClient) migrateTokenCache(js nats.JetStreamContext, bucket string) error {
+	kv, _, err := bindOrCreateKV(js, &nats.KeyValueConfig{Bucket: bucket})
+	if err != nil {
+		return err
+	}
+	return c.migrateKV(js, tokenCacheSchema, bucket, kv)
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jetstreamKV is an in-memory jetstream.KeyValue. Like the real bucket, it
+// fails operations whose context is done.
+type jetstreamKV struct {
+	jetstream.KeyValue
+	data map[string][]byte
+	rev  uint64
+}
+
+type jetstreamKVEntry struct {
+	jetstream.KeyValueEntry
+	value []byte
+	rev   uint64
+}
+
+func (e jetstreamKVEntry) Value() []byte    { return e.value }
+func (e jetstreamKVEntry) Revision() uint64 { return e.rev }
+
+type jetstreamKeyLister chan string
+
+func (l jetstreamKeyLister) Keys() <-chan string { return l }
+func (l jetstreamKeyLister) Stop() error         { return nil }
+
+func newJetstreamKV() *jetstreamKV {
+	return &jetstreamKV{data: map[string][]byte{}}
+}
+
+func (m *jetstreamKV) Bucket() string { return "test_cache" }
+
+func (m *jetstreamKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	value, ok := m.data[key]
+	if !ok {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return jetstreamKVEntry{value: value, rev: m.rev}, nil
+}
+
+func (m *jetstreamKV) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.rev++
+	m.data[key] = value
+	return m.rev, nil
+}
+
+func (m *jetstreamKV) Purge(ctx context.Context, key string, _ ...jetstream.KVDeleteOpt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *jetstreamKV) ListKeys(ctx context.Context, _ ...jetstream.WatchOpt) (jetstream.KeyLister, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys := make(jetstreamKeyLister, len(m.data))
+	for _, key := range slices.Sorted(maps.Keys(m.data)) {
+		keys <- key
+	}
+	close(keys)
+	return keys, nil
+}
+
+func TestJetStreamTokenCache_Context(t *testing.T) {
+	kv := newJetstreamKV()
+	cache := &JetStreamTokenCache{kv: kv, secret: []byte("secret"), logger: slog.Default(), now: time.Now}
+
+	require.NoError(t, cache.Put(context.Background(), "glpat-a", TokenCacheEntry{Username: "alice"}))
+	entry, err := cache.Get(context.Background(), "glpat-a")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", entry.Username)
+	_, err = cache.Get(context.Background(), "glpat-unknown")
+	assert.ErrorIs(t, err, ErrTokenCacheMiss)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = cache.Get(ctx, "glpat-a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "a passed deadline is an error, not a miss")
+	assert.ErrorIs(t, cache.Put(ctx, "glpat-b", TokenCacheEntry{Username: "bob"}), context.DeadlineExceeded)
+	_, err = cache.InvalidateAll(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, kv.data, 1)
+
+	removed, err := cache.InvalidateAll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestRetryKeyValue_ContextNotRetried(t *testing.T) {
+	r := newRetryKeyValue(newJetstreamKV(), KVRetryConfig{Attempts: 3, Backoff: time.Hour}).(*retryKeyValue)
+	r.sleep = func(time.Duration) { t.Fatal("a done context is not retried") }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Get(ctx, "alice")
+	assert.ErrorIs(t, err, context.Canceled)
+}
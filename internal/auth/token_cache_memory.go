@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// recentVerifications is implemented by caches that remember tokens GitLab
+// verified moments ago; AuthorizeToken trusts those without asking again.
+type recentVerifications interface {
+	Recent(token string) (*TokenCacheEntry, bool)
+}
+
+type memoryTokenCacheItem struct {
+	key      string
+	entry    TokenCacheEntry
+	storedAt time.Time
+}
+
+// MemoryTokenCache is a process-local LRU in front of the KV token cache.
+// Tokens verified with GitLab are remembered for a short TTL, so a client
+// reconnecting within seconds is authorized without a GitLab or KV round
+// trip. Only fresh verifications are kept: entries read from KV during a
+// GitLab outage are not, so the layer never extends the fallback.
+//
+// Like the KV cache it is keyed by HMAC(token); plaintext tokens are never
+// held.
+type MemoryTokenCache struct {
+	next   TokenCache
+	secret []byte
+	size   int
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // of *memoryTokenCacheItem, most recently used first
+	items map[string]*list.Element
+}
+
+// NewMemoryTokenCache wraps next with an LRU of at most size entries, each
+// trusted for ttl after its GitLab verification.
+func NewMemoryTokenCache(next TokenCache, secret string, size int, ttl time.Duration) *MemoryTokenCache {
+	return &MemoryTokenCache{
+		next:   next,
+		secret: []byte(secret),
+		size:   size,
+		ttl:    ttl,
+		now:    time.Now,
+		order:  list.New(),
+		items:  make(map[string]*list.Element, size),
+	}
+}
+
+// Recent returns the entry of a token verified less than ttl ago.
+func (c *MemoryTokenCache) Recent(token string) (*TokenCacheEntry, bool) {
+	key, err := tokenCacheKey(token, c.secret)
+	if err != nil {
+		return nil, false
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		tokenCacheMemoryLookupsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	item := el.Value.(*memoryTokenCacheItem)
+	if now.Sub(item.storedAt) >= c.ttl || item.entry.expired(now) {
+		c.remove(el)
+		tokenCacheMemoryLookupsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	tokenCacheMemoryLookupsTotal.WithLabelValues("hit").Inc()
+	entry := item.entry
+	return &entry, true
+}
+
+// Get reads through to the wrapped cache; it is only used as the GitLab
+// outage fallback, which Recent does not serve.
+func (c *MemoryTokenCache) Get(ctx context.Context, token string) (*TokenCacheEntry, error) {
+	return c.next.Get(ctx, token)
+}
+
+// Put remembers a freshly verified token and writes it to the wrapped cache.
+func (c *MemoryTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	key, err := tokenCacheKey(token, c.secret)
+	if err != nil {
+		return err
+	}
+	item := &memoryTokenCacheItem{key: key, entry: entry, storedAt: c.now()}
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		el.Value = item
+		c.order.MoveToFront(el)
+	} else {
+		c.items[key] = c.order.PushFront(item)
+		for c.order.Len() > c.size {
+			c.remove(c.order.Back())
+		}
+	}
+	c.mu.Unlock()
+
+	return c.next.Put(ctx, token, entry)
+}
+
+// remove drops an element; c.mu must be held.
+func (c *MemoryTokenCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*memoryTokenCacheItem).key)
+}
+
+// Len returns the number of remembered tokens, including expired ones not
+// evicted yet.
+func (c *MemoryTokenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// InvalidateAll forgets every remembered token and invalidates the wrapped
+// cache, if it supports invalidation.
+func (c *MemoryTokenCache) InvalidateAll(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	c.order.Init()
+	clear(c.items)
+	c.mu.Unlock()
+
+	inv, ok := c.next.(TokenCacheInvalidator)
+	if !ok {
+		return 0, errors.New("token cache does not support invalidation")
+	}
+	return inv.InvalidateAll(ctx)
+}
+
+// Invalidate forgets the selected tokens and removes them from the wrapped
+// cache, if it supports removal.
+func (c *MemoryTokenCache) Invalidate(ctx context.Context, sel TokenCacheSelection) (int, error) {
+	c.mu.Lock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		item := el.Value.(*memoryTokenCacheItem)
+		if sel.matchesKey(item.key) || sel.matchesEntry(item.entry) {
+			c.remove(el)
+		}
+		el = next
+	}
+	c.mu.Unlock()
+
+	remover, ok := c.next.(TokenCacheRemover)
+	if !ok {
+		return 0, errors.New("token cache does not support removing entries")
+	}
+	return remover.Invalidate(ctx, sel)
+}
+
+// Entries lists the wrapped cache's entries.
+func (c *MemoryTokenCache) Entries(ctx context.Context) ([]TokenCacheEntry, error) {
+	enum, ok := c.next.(TokenCacheEnumerator)
+	if !ok {
+		return nil, errors.New("token cache does not support listing entries")
+	}
+	return enum.Entries(ctx)
+}
+
+// Close closes the wrapped cache, flushing its queued writes.
+func (c *MemoryTokenCache) Close() {
+	if closer, ok := c.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
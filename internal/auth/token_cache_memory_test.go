@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTokenCache(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2025, 12, 14, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+
+	kv := &mockTokenCache{secret: []byte("secret"), kv: &mockSharedKV{now: now, ttl: 24 * time.Hour, data: map[string]mockKVRecord{}}}
+	c := NewMemoryTokenCache(kv, "secret", 2, 5*time.Second)
+	c.now = now
+
+	require.NoError(t, c.Put(ctx, "glpat-a", TokenCacheEntry{Username: "alice"}))
+	assert.Equal(t, 1, kv.PutCalls(), "writes go through to KV")
+
+	entry, ok := c.Recent("glpat-a")
+	require.True(t, ok)
+	assert.Equal(t, "alice", entry.Username)
+	_, ok = c.Recent("glpat-unknown")
+	assert.False(t, ok)
+
+	t.Run("expires after the ttl", func(t *testing.T) {
+		clock = clock.Add(5 * time.Second)
+		_, ok := c.Recent("glpat-a")
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Len(), "expired entries are evicted on lookup")
+	})
+
+	t.Run("honors the expiry of TTL overrides", func(t *testing.T) {
+		require.NoError(t, c.Put(ctx, "glpat-a", TokenCacheEntry{Username: "alice", ExpiresAt: clock.Add(time.Second).Format(time.RFC3339)}))
+		clock = clock.Add(time.Second)
+		_, ok := c.Recent("glpat-a")
+		assert.False(t, ok)
+	})
+
+	t.Run("evicts the least recently used token", func(t *testing.T) {
+		require.NoError(t, c.Put(ctx, "glpat-a", TokenCacheEntry{Username: "alice"}))
+		require.NoError(t, c.Put(ctx, "glpat-b", TokenCacheEntry{Username: "bob"}))
+		_, ok := c.Recent("glpat-a")
+		require.True(t, ok)
+		require.NoError(t, c.Put(ctx, "glpat-c", TokenCacheEntry{Username: "carol"}))
+
+		assert.Equal(t, 2, c.Len())
+		_, ok = c.Recent("glpat-b")
+		assert.False(t, ok)
+		_, ok = c.Recent("glpat-a")
+		assert.True(t, ok)
+	})
+
+	t.Run("KV reads are not remembered", func(t *testing.T) {
+		require.NoError(t, kv.Put(ctx, "glpat-kv", TokenCacheEntry{Username: "dave"}))
+		_, err := c.Get(ctx, "glpat-kv")
+		require.NoError(t, err)
+		_, ok := c.Recent("glpat-kv")
+		assert.False(t, ok)
+	})
+}
+
+func TestAuthorizeToken_MemoryLayerSkipsGitLabAndKV(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2025, 12, 14, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+
+	kv := &mockTokenCache{secret: []byte("secret"), kv: &mockSharedKV{now: now, ttl: 24 * time.Hour, data: map[string]mockKVRecord{}}}
+	cache := NewMemoryTokenCache(kv, "secret", 10, 5*time.Second)
+	cache.now = now
+
+	gitlabCalls := 0
+	verifier := mockGitLabVerifier{verify: func(token string) (*VerifiedToken, error) {
+		gitlabCalls++
+		return &VerifiedToken{UserID: 42, Username: "tester", Groups: []string{"acme"}}, nil
+	}}
+
+	res, err := AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
+	require.NoError(t, err)
+	require.False(t, res.FromMemory)
+
+	kv.ResetCounts()
+	res, err = AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
+	require.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.True(t, res.FromMemory)
+	assert.Equal(t, "tester", res.Username())
+	assert.Equal(t, int64(42), res.UserID())
+	assert.Equal(t, []string{"acme"}, res.Groups())
+	assert.Equal(t, 1, gitlabCalls)
+	assert.Equal(t, 0, kv.GetCalls()+kv.PutCalls())
+
+	clock = clock.Add(5 * time.Second)
+	res, err = AuthorizeToken(ctx, "glpat-valid", verifier, cache, now)
+	require.NoError(t, err)
+	assert.False(t, res.FromMemory, "verified with GitLab again after the memory ttl")
+	assert.Equal(t, 2, gitlabCalls)
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// TokenCacheEnumerator is implemented by caches that can list their entries,
+// for capacity reports.
+type TokenCacheEnumerator interface {
+	Entries(ctx context.Context) ([]TokenCacheEntry, error)
+}
+
+// Entries returns every live entry in the bucket. Entries are read one by
+// one, so this is meant for occasional admin reports, not the request path.
+func (c *JetStreamTokenCache) Entries(ctx context.Context) ([]TokenCacheEntry, error) {
+	keys, err := c.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	entries := make([]TokenCacheEntry, 0, len(keys))
+	for _, key := range keys {
+		kve, err := c.kv.Get(ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // expired or purged since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token cache entry: %w", err)
+		}
+		entry, err := unmarshalTokenCacheEntry(kve.Value())
+		if err != nil || entry.expired(now) {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// tokenCacheAgeBuckets are the upper bounds of the last-verified age
+// distribution in the cache report.
+var tokenCacheAgeBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"lt_1h", time.Hour},
+	{"lt_6h", 6 * time.Hour},
+	{"lt_24h", 24 * time.Hour},
+	{"lt_7d", 7 * 24 * time.Hour},
+}
+
+// TokenCacheReport summarizes the token cache for capacity review. It never
+// contains keys or any other token material.
+type TokenCacheReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	TTL         string    `json:"ttl"`
+	Entries     int       `json:"entries"`
+	Users       int       `json:"users"`
+	// ByScopes counts entries per (sorted) scope set; "" means unknown scopes.
+	ByScopes []TokenCacheCount `json:"by_scopes"`
+	// LastVerifiedAge counts entries per age bucket (lt_1h ... lt_7d, older, unknown).
+	LastVerifiedAge map[string]int `json:"last_verified_age"`
+	// PerUser counts entries per user, most entries first.
+	PerUser []TokenCacheCount `json:"per_user"`
+}
+
+// TokenCacheCount is one row of a grouped count.
+type TokenCacheCount struct {
+	Key     string `json:"key"`
+	Entries int    `json:"entries"`
+}
+
+// summarizeTokenCache builds the report from the cache entries.
+func summarizeTokenCache(entries []TokenCacheEntry, ttl time.Duration, now time.Time) TokenCacheReport {
+	report := TokenCacheReport{
+		GeneratedAt:     now.UTC(),
+		TTL:             ttl.String(),
+		Entries:         len(entries),
+		LastVerifiedAge: map[string]int{},
+	}
+
+	scopes, users := map[string]int{}, map[string]int{}
+	for _, entry := range entries {
+		scopes[normalizeScopes(entry.Scopes)]++
+		users[entry.Username]++
+		report.LastVerifiedAge[ageBucket(entry.LastVerifiedAt, now)]++
+	}
+	report.Users = len(users)
+	report.ByScopes = sortedCounts(scopes)
+	report.PerUser = sortedCounts(users)
+	return report
+}
+
+// normalizeScopes makes scope sets comparable regardless of order.
+func normalizeScopes(scopes string) string {
+	var list []string
+	for _, s := range strings.Split(scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	slices.Sort(list)
+	return strings.Join(slices.Compact(list), ",")
+}
+
+func ageBucket(lastVerifiedAt string, now time.Time) string {
+	at, err := time.Parse(time.RFC3339, lastVerifiedAt)
+	if err != nil {
+		return "unknown"
+	}
+	age := now.Sub(at)
+	for _, b := range tokenCacheAgeBuckets {
+		if age < b.max {
+			return b.label
+		}
+	}
+	return "older"
+}
+
+// sortedCounts orders counts by entries (descending), then key.
+func sortedCounts(counts map[string]int) []TokenCacheCount {
+	out := make([]TokenCacheCount, 0, len(counts))
+	for key, n := range counts {
+		out = append(out, TokenCacheCount{Key: key, Entries: n})
+	}
+	slices.SortFunc(out, func(a, b TokenCacheCount) int {
+		return cmp.Or(cmp.Compare(b.Entries, a.Entries), cmp.Compare(a.Key, b.Key))
+	})
+	return out
+}
+
+// TokenCacheReportHandler serves the token cache summary (GET only).
+func (c *NATSClient) TokenCacheReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		enumerator, ok := c.tokenCache.(TokenCacheEnumerator)
+		if !ok {
+			http.Error(w, "token cache is not enabled", http.StatusNotFound)
+			return
+		}
+		entries, err := enumerator.Entries(r.Context())
+		if err != nil {
+			c.logger.Error("Failed to read token cache for report", "error", err)
+			http.Error(w, "failed to read token cache", http.StatusBadGateway)
+			return
+		}
+
+		report := summarizeTokenCache(entries, LoadTokenCacheConfig().TTL, time.Now())
+		audit("token_cache.report", "ok", "entries", report.Entries, "users", report.Users)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeTokenCache(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return now.Add(-d).Format(time.RFC3339) }
+
+	entries := []TokenCacheEntry{
+		{Username: "alice", Scopes: "read_api,read_user", LastVerifiedAt: at(10 * time.Minute)},
+		{Username: "alice", Scopes: "read_user, read_api", LastVerifiedAt: at(3 * time.Hour)},
+		{Username: "bob", Scopes: "read_api", LastVerifiedAt: at(30 * time.Hour)},
+		{Username: "ci-bot", Scopes: "", LastVerifiedAt: at(10 * 24 * time.Hour)},
+		{Username: "ci-bot", Scopes: "read_api", LastVerifiedAt: "garbage"},
+	}
+
+	report := summarizeTokenCache(entries, 24*time.Hour, now)
+	assert.Equal(t, 5, report.Entries)
+	assert.Equal(t, 3, report.Users)
+	assert.Equal(t, "24h0m0s", report.TTL)
+	assert.Equal(t, []TokenCacheCount{
+		{Key: "read_api", Entries: 2},
+		{Key: "read_api,read_user", Entries: 2},
+		{Key: "", Entries: 1},
+	}, report.ByScopes)
+	assert.Equal(t, map[string]int{"lt_1h": 1, "lt_6h": 1, "lt_7d": 1, "older": 1, "unknown": 1}, report.LastVerifiedAge)
+	assert.Equal(t, []TokenCacheCount{
+		{Key: "alice", Entries: 2},
+		{Key: "ci-bot", Entries: 2},
+		{Key: "bob", Entries: 1},
+	}, report.PerUser)
+}
+
+type enumerableCache struct {
+	TokenCache
+	entries []TokenCacheEntry
+}
+
+func (c enumerableCache) Entries(context.Context) ([]TokenCacheEntry, error) {
+	return c.entries, nil
+}
+
+func TestTokenCacheReportHandler(t *testing.T) {
+	get := func(c *NATSClient) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.TokenCacheReportHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/token_cache/report", nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(&NATSClient{logger: slog.Default()}).Code)
+
+	cache := enumerableCache{entries: []TokenCacheEntry{{Username: "alice", Scopes: "read_api"}}}
+	rec := get(&NATSClient{logger: slog.Default(), tokenCache: cache})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var report TokenCacheReport
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Equal(t, 1, report.Entries)
+	assert.Equal(t, []TokenCacheCount{{Key: "alice", Entries: 1}}, report.PerUser)
+}
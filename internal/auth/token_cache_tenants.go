@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// TenantTokenCacheConfig gives a tenant its own token cache bucket.
+type TenantTokenCacheConfig struct {
+	// Bucket is the tenant's bucket; empty keeps the tenant's entries in
+	// the shared token_cache.bucket.
+	Bucket string
+	// TTL, Replicas and MaxBytes size the bucket; zero inherits
+	// tenants.defaults.token_cache, then token_cache.
+	TTL      time.Duration
+	Replicas int
+	MaxBytes int64
+}
+
+// tenantBucketConfigs returns the token cache configuration of every tenant
+// with its own bucket, keyed by tenant.
+func (t TenantsConfig) tenantBucketConfigs(shared TokenCacheConfig) (map[string]TokenCacheConfig, error) {
+	out := make(map[string]TokenCacheConfig)
+	owners := map[string]string{shared.Bucket: "token_cache.bucket"}
+	for _, name := range sortedKeys(t.Groups) {
+		tc := t.Groups[name].TokenCache
+		if tc.Bucket == "" {
+			continue
+		}
+		if owner, taken := owners[tc.Bucket]; taken {
+			return nil, fmt.Errorf("tenants.groups.%s.token_cache.bucket: bucket %q is already used by %s", name, tc.Bucket, owner)
+		}
+		owners[tc.Bucket] = "tenant " + name
+
+		cfg := shared
+		cfg.Bucket = tc.Bucket
+		cfg.TTL = firstNonZero(tc.TTL, t.Defaults.TokenCache.TTL, shared.TTL)
+		cfg.Replicas = firstNonZero(tc.Replicas, t.Defaults.TokenCache.Replicas, shared.Replicas)
+		cfg.MaxBytes = firstNonZero(tc.MaxBytes, t.Defaults.TokenCache.MaxBytes, shared.MaxBytes)
+		out[name] = cfg
+	}
+	return out, nil
+}
+
+func firstNonZero[T comparable](values ...T) T {
+	var zero T
+	for _, v := range values {
+		if v != zero {
+			return v
+		}
+	}
+	return zero
+}
+
+// TenantTokenCache shards the token cache into per-tenant buckets, so a
+// tenant with many tokens cannot fill the shared bucket or hit its limits.
+// Entries are written to the bucket of the first (sorted) tenant the owner
+// belongs to, or to the shared bucket. The key does not reveal the owner,
+// so reads try the shared bucket first and then every tenant bucket; reads
+// only happen when GitLab is unavailable.
+type TenantTokenCache struct {
+	shared  *JetStreamTokenCache
+	tenants map[string]*JetStreamTokenCache
+	order   []string
+}
+
+// NewTenantTokenCache routes entries between shared and the tenant buckets.
+func NewTenantTokenCache(shared *JetStreamTokenCache, tenants map[string]*JetStreamTokenCache) *TenantTokenCache {
+	return &TenantTokenCache{shared: shared, tenants: tenants, order: sortedKeys(tenants)}
+}
+
+// buckets returns every bucket, the shared one first.
+func (c *TenantTokenCache) buckets() []*JetStreamTokenCache {
+	out := []*JetStreamTokenCache{c.shared}
+	for _, name := range c.order {
+		out = append(out, c.tenants[name])
+	}
+	return out
+}
+
+// bucketFor returns the bucket an entry is written to.
+func (c *TenantTokenCache) bucketFor(entry TokenCacheEntry) *JetStreamTokenCache {
+	var groups []string
+	if entry.Groups != "" {
+		groups = strings.Split(entry.Groups, ",")
+	}
+	for _, name := range c.order {
+		for _, group := range groups {
+			if strings.EqualFold(group, name) {
+				return c.tenants[name]
+			}
+		}
+	}
+	return c.shared
+}
+
+// Get returns the first live entry for token in any bucket. Errors of single
+// buckets are only returned when no bucket has the entry.
+func (c *TenantTokenCache) Get(ctx context.Context, token string) (*TokenCacheEntry, error) {
+	var errs []error
+	for _, bucket := range c.buckets() {
+		entry, err := bucket.Get(ctx, token)
+		if err == nil {
+			return entry, nil
+		}
+		if !errors.Is(err, ErrTokenCacheMiss) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, ErrTokenCacheMiss
+}
+
+// Put writes the entry to the bucket of the owner's tenant.
+func (c *TenantTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	return c.bucketFor(entry).Put(ctx, token, entry)
+}
+
+// InvalidateAll purges every bucket.
+func (c *TenantTokenCache) InvalidateAll(ctx context.Context) (int, error) {
+	total := 0
+	for _, bucket := range c.buckets() {
+		n, err := bucket.InvalidateAll(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Invalidate removes the selected entries from every bucket.
+func (c *TenantTokenCache) Invalidate(ctx context.Context, sel TokenCacheSelection) (int, error) {
+	total := 0
+	for _, bucket := range c.buckets() {
+		n, err := bucket.Invalidate(ctx, sel)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Entries lists the entries of every bucket.
+func (c *TenantTokenCache) Entries(ctx context.Context) ([]TokenCacheEntry, error) {
+	var out []TokenCacheEntry
+	for _, bucket := range c.buckets() {
+		entries, err := bucket.Entries(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entries...)
+	}
+	return out, nil
+}
+
+// initTenantTokenCaches opens the buckets of the tenants that have their own.
+func (c *NATSClient) initTenantTokenCaches(js nats.JetStreamContext, jsAPI jetstream.JetStream, shared TokenCacheConfig) (map[string]*JetStreamTokenCache, error) {
+	configs, err := LoadTenantsConfig().tenantBucketConfigs(shared)
+	if err != nil {
+		return nil, err
+	}
+	caches := make(map[string]*JetStreamTokenCache, len(configs))
+	for _, name := range sortedKeys(configs) {
+		cfg := configs[name]
+		cache, err := NewJetStreamTokenCache(context.Background(), jsAPI, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+		if err := c.migrateTokenCache(js, cfg.Bucket); err != nil {
+			return nil, err
+		}
+		caches[name] = cache
+		c.logger.Info("Tenant token cache bucket enabled", "tenant", name, "bucket", cfg.Bucket,
+			"ttl", cfg.TTL, "replicas", cfg.Replicas, "max_bytes", cfg.MaxBytes)
+	}
+	return caches, nil
+}
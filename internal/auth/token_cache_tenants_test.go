@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantBucketConfigs(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("tenants.defaults.token_cache.max_bytes", 1024)
+	viper.Set("tenants.groups.payments.token_cache.bucket", "cache_payments")
+	viper.Set("tenants.groups.payments.token_cache.ttl", "1h")
+	viper.Set("tenants.groups.search.permissions.publish.allow", []string{"search.>"})
+
+	shared := TokenCacheConfig{Bucket: "cache", TTL: 24 * time.Hour, Replicas: 3, HMACSecret: "secret"}
+	configs, err := LoadTenantsConfig().tenantBucketConfigs(shared)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]TokenCacheConfig{
+		"payments": {Bucket: "cache_payments", TTL: time.Hour, Replicas: 3, MaxBytes: 1024, HMACSecret: "secret"},
+	}, configs, "tenants without a bucket use the shared one")
+
+	viper.Set("tenants.groups.search.token_cache.bucket", "cache_payments")
+	_, err = LoadTenantsConfig().tenantBucketConfigs(shared)
+	assert.ErrorContains(t, err, "already used by tenant payments")
+}
+
+func TestTenantTokenCache(t *testing.T) {
+	ctx := context.Background()
+	newCache := func(kv *jetstreamKV) *JetStreamTokenCache {
+		return &JetStreamTokenCache{kv: kv, secret: []byte("secret"), logger: slog.Default(), now: time.Now}
+	}
+	sharedKV, paymentsKV, searchKV := newJetstreamKV(), newJetstreamKV(), newJetstreamKV()
+	cache := NewTenantTokenCache(newCache(sharedKV), map[string]*JetStreamTokenCache{
+		"payments": newCache(paymentsKV),
+		"search":   newCache(searchKV),
+	})
+
+	require.NoError(t, cache.Put(ctx, "glpat-a", TokenCacheEntry{Username: "alice", Groups: "Search,payments"}))
+	require.NoError(t, cache.Put(ctx, "glpat-b", TokenCacheEntry{Username: "bob", Groups: "search"}))
+	require.NoError(t, cache.Put(ctx, "glpat-c", TokenCacheEntry{Username: "carol", Groups: "other"}))
+	assert.Len(t, paymentsKV.data, 1, "the first tenant in sorted order")
+	assert.Len(t, searchKV.data, 1)
+	assert.Len(t, sharedKV.data, 1)
+
+	for token, username := range map[string]string{"glpat-a": "alice", "glpat-b": "bob", "glpat-c": "carol"} {
+		entry, err := cache.Get(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, username, entry.Username)
+	}
+	_, err := cache.Get(ctx, "glpat-unknown")
+	assert.ErrorIs(t, err, ErrTokenCacheMiss)
+
+	entries, err := cache.Entries(ctx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	removed, err := cache.Invalidate(ctx, TokenCacheSelection{Usernames: []string{"alice", "carol"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Empty(t, paymentsKV.data)
+	assert.Empty(t, sharedKV.data)
+	assert.Len(t, searchKV.data, 1)
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TTLOverride sets a custom token cache TTL for the listed users and/or
+// members of the listed GitLab top-level groups.
+type TTLOverride struct {
+	Users  []string      `mapstructure:"users" json:"users,omitempty"`
+	Groups []string      `mapstructure:"groups" json:"groups,omitempty"`
+	TTL    time.Duration `mapstructure:"ttl" json:"ttl"`
+}
+
+// LoadTTLOverrides reads token_cache.ttl_overrides. It is read on every
+// cache write, so changes made through config overrides apply immediately.
+func LoadTTLOverrides() ([]TTLOverride, error) {
+	var out []TTLOverride
+	if err := viper.UnmarshalKey("token_cache.ttl_overrides", &out); err != nil {
+		return nil, fmt.Errorf("invalid token_cache.ttl_overrides: %w", err)
+	}
+	for i, o := range out {
+		if o.TTL <= 0 {
+			return nil, fmt.Errorf("invalid token_cache.ttl_overrides[%d]: ttl must be > 0", i)
+		}
+	}
+	return out, nil
+}
+
+// ResolveTTL returns the TTL override for a user. A rule naming the user
+// wins over group rules; among matching group rules the shortest TTL wins.
+func ResolveTTL(overrides []TTLOverride, username string, groups []string) (time.Duration, bool) {
+	for _, o := range overrides {
+		if slices.ContainsFunc(o.Users, func(u string) bool { return strings.EqualFold(u, username) }) {
+			return o.TTL, true
+		}
+	}
+
+	var ttl time.Duration
+	for _, o := range overrides {
+		for _, g := range groups {
+			if slices.ContainsFunc(o.Groups, func(og string) bool { return strings.EqualFold(og, g) }) {
+				if ttl == 0 || o.TTL < ttl {
+					ttl = o.TTL
+				}
+				break
+			}
+		}
+	}
+	return ttl, ttl > 0
+}
+
+// parseTTLOverridesOverride accepts token_cache.ttl_overrides as a JSON list,
+// e.g. [{"users":["alice"],"ttl":"1h"}].
+func parseTTLOverridesOverride(raw string) (any, error) {
+	var value []any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("expected a JSON list: %w", err)
+	}
+
+	// Decode exactly like LoadTTLOverrides will, to reject bad values up front.
+	v := viper.New()
+	v.Set("ttl_overrides", value)
+	var check []TTLOverride
+	if err := v.UnmarshalKey("ttl_overrides", &check); err != nil {
+		return nil, err
+	}
+	for i, o := range check {
+		if o.TTL <= 0 {
+			return nil, fmt.Errorf("entry %d: ttl must be > 0", i)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTTLOverrides(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	overrides, err := LoadTTLOverrides()
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	viper.Set("token_cache.ttl_overrides", []map[string]any{
+		{"users": []string{"root"}, "ttl": "15m"},
+		{"groups": []string{"ci-bots"}, "ttl": "72h"},
+	})
+	overrides, err = LoadTTLOverrides()
+	require.NoError(t, err)
+	assert.Equal(t, []TTLOverride{
+		{Users: []string{"root"}, TTL: 15 * time.Minute},
+		{Groups: []string{"ci-bots"}, TTL: 72 * time.Hour},
+	}, overrides)
+
+	viper.Set("token_cache.ttl_overrides", []map[string]any{{"users": []string{"root"}}})
+	_, err = LoadTTLOverrides()
+	assert.ErrorContains(t, err, "ttl must be > 0")
+}
+
+func TestResolveTTL(t *testing.T) {
+	overrides := []TTLOverride{
+		{Groups: []string{"ci-bots"}, TTL: 72 * time.Hour},
+		{Groups: []string{"Admins"}, TTL: time.Hour},
+		{Users: []string{"Deploy-Bot"}, TTL: 168 * time.Hour},
+	}
+
+	_, ok := ResolveTTL(overrides, "alice", nil)
+	assert.False(t, ok)
+
+	ttl, ok := ResolveTTL(overrides, "deploy-bot", []string{"admins"})
+	require.True(t, ok)
+	assert.Equal(t, 168*time.Hour, ttl, "user rules win over group rules")
+
+	ttl, ok = ResolveTTL(overrides, "carol", []string{"ci-bots", "admins"})
+	require.True(t, ok)
+	assert.Equal(t, time.Hour, ttl, "shortest matching group TTL wins")
+}
+
+func TestJetStreamTokenCache_TTLOverrideIsCapped(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("token_cache.ttl_overrides", []map[string]any{
+		{"users": []string{"admin"}, "ttl": "10m"},
+		{"groups": []string{"ci-bots"}, "ttl": "72h"},
+	})
+
+	c := &JetStreamTokenCache{ttl: 24 * time.Hour, logger: slog.Default()}
+
+	ttl, ok := c.ttlOverride(TokenCacheEntry{Username: "admin"})
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Minute, ttl)
+
+	ttl, ok = c.ttlOverride(TokenCacheEntry{Username: "bot", Groups: "tools,ci-bots"})
+	require.True(t, ok)
+	assert.Equal(t, 24*time.Hour, ttl)
+
+	_, ok = c.ttlOverride(TokenCacheEntry{Username: "alice"})
+	assert.False(t, ok)
+}
+
+func TestTokenCacheEntry_Expired(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.False(t, (&TokenCacheEntry{}).expired(now))
+	assert.False(t, (&TokenCacheEntry{ExpiresAt: "2026-01-01T12:00:01Z"}).expired(now))
+	assert.True(t, (&TokenCacheEntry{ExpiresAt: "2026-01-01T12:00:00Z"}).expired(now))
+}
+
+func TestConfigOverrides_TTLOverrides(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	o := newConfigOverrides(nil, slog.Default())
+
+	require.Error(t, o.apply("token_cache.ttl_overrides", `{"users":["a"]}`, false))
+	require.Error(t, o.apply("token_cache.ttl_overrides", `[{"users":["a"],"ttl":"soon"}]`, false))
+
+	require.NoError(t, o.apply("token_cache.ttl_overrides", `[{"users":["a"],"ttl":"5m"}]`, false))
+	overrides, err := LoadTTLOverrides()
+	require.NoError(t, err)
+	assert.Equal(t, []TTLOverride{{Users: []string{"a"}, TTL: 5 * time.Minute}}, overrides)
+
+	require.NoError(t, o.apply("token_cache.ttl_overrides", "", true))
+	overrides, err = LoadTTLOverrides()
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+}
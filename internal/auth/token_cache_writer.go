@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrTokenCacheQueueFull is returned by BufferedTokenCache.Put when the write
+// queue is full. The write is dropped; authorization is not affected.
+var ErrTokenCacheQueueFull = errors.New("token cache write queue full")
+
+// tokenCacheWriteTimeout bounds a single background KV write when
+// token_cache.put_timeout is 0: unlike writes on the auth path, background
+// writes have no request deadline to fall back to.
+const tokenCacheWriteTimeout = 5 * time.Second
+
+type tokenCacheWrite struct {
+	token string
+	entry TokenCacheEntry
+}
+
+// tokenCacheDrop asks the writer to drop the queued writes selected by
+// Invalidate; done is closed once the rest are written.
+type tokenCacheDrop struct {
+	selected func(tokenCacheWrite) bool
+	done     chan struct{}
+}
+
+// BufferedTokenCache moves cache writes off the authorization path. Put only
+// enqueues the entry; a background writer drains the bounded queue in small
+// batches, coalescing repeated writes for the same token, so KV latency during
+// connect storms does not delay successful authentications. Get is passed
+// through unchanged.
+type BufferedTokenCache struct {
+	next         TokenCache
+	batchSize    int
+	writeTimeout time.Duration
+	logger       *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan tokenCacheWrite
+	drops  chan tokenCacheDrop
+	done   chan struct{}
+}
+
+// NewBufferedTokenCache wraps next with a write queue of the given size and
+// starts the background writer, which bounds each write by writeTimeout
+// (token_cache.put_timeout). Call Close to flush pending writes.
+func NewBufferedTokenCache(next TokenCache, queueSize, batchSize int, writeTimeout time.Duration) *BufferedTokenCache {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = tokenCacheWriteTimeout
+	}
+	c := &BufferedTokenCache{
+		next:         next,
+		batchSize:    batchSize,
+		writeTimeout: writeTimeout,
+		logger:       slog.With("component", "token_cache_writer"),
+		queue:        make(chan tokenCacheWrite, queueSize),
+		drops:        make(chan tokenCacheDrop),
+		done:         make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *BufferedTokenCache) Get(ctx context.Context, token string) (*TokenCacheEntry, error) {
+	return c.next.Get(ctx, token)
+}
+
+// Put enqueues the entry without waiting for the KV write. It fails fast
+// with ErrTokenCacheQueueFull instead of blocking when the queue is full.
+func (c *BufferedTokenCache) Put(_ context.Context, token string, entry TokenCacheEntry) error {
+	if token == "" {
+		return ErrInvalidToken
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return errors.New("token cache writer is closed")
+	}
+
+	select {
+	case c.queue <- tokenCacheWrite{token: token, entry: entry}:
+		tokenCacheWriteQueueDepth.Set(float64(len(c.queue)))
+		return nil
+	default:
+		tokenCacheWritesDroppedTotal.Inc()
+		return ErrTokenCacheQueueFull
+	}
+}
+
+// InvalidateAll drops queued writes and invalidates the wrapped cache, if it
+// supports invalidation. A batch already being written finishes first, so
+// no write recreates an entry after the purge.
+func (c *BufferedTokenCache) InvalidateAll(ctx context.Context) (int, error) {
+	inv, ok := c.next.(TokenCacheInvalidator)
+	if !ok {
+		return 0, errors.New("token cache does not support invalidation")
+	}
+
+	if err := c.dropQueued(ctx, func(tokenCacheWrite) bool { return true }); err != nil {
+		return 0, err
+	}
+	return inv.InvalidateAll(ctx)
+}
+
+// Invalidate removes the selected entries from the wrapped cache, if it
+// supports removal. The selected queued writes are dropped and the others
+// written first, so no queued write recreates a removed entry. Queued writes
+// are selected by key only when the wrapped cache can derive keys.
+func (c *BufferedTokenCache) Invalidate(ctx context.Context, sel TokenCacheSelection) (int, error) {
+	remover, ok := c.next.(TokenCacheRemover)
+	if !ok {
+		return 0, errors.New("token cache does not support removing entries")
+	}
+
+	keyer, _ := c.next.(tokenCacheKeyer)
+	err := c.dropQueued(ctx, func(w tokenCacheWrite) bool {
+		if sel.matchesEntry(w.entry) {
+			return true
+		}
+		if keyer == nil || len(sel.Keys) == 0 {
+			return false
+		}
+		key, err := keyer.tokenKey(w.token)
+		return err == nil && sel.matchesKey(key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return remover.Invalidate(ctx, sel)
+}
+
+// dropQueued hands the selection to the writer goroutine and waits until it
+// dropped the selected queued writes and wrote the others.
+func (c *BufferedTokenCache) dropQueued(ctx context.Context, selected func(tokenCacheWrite) bool) error {
+	drop := tokenCacheDrop{done: make(chan struct{}), selected: selected}
+	select {
+	case c.drops <- drop:
+		select {
+		case <-drop.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case <-c.done: // closed and flushed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Entries lists the wrapped cache's entries; queued writes are not included.
+func (c *BufferedTokenCache) Entries(ctx context.Context) ([]TokenCacheEntry, error) {
+	enum, ok := c.next.(TokenCacheEnumerator)
+	if !ok {
+		return nil, errors.New("token cache does not support listing entries")
+	}
+	return enum.Entries(ctx)
+}
+
+// Close stops accepting writes and blocks until the queued ones are flushed.
+func (c *BufferedTokenCache) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.done
+		return
+	}
+	c.closed = true
+	close(c.queue)
+	c.mu.Unlock()
+
+	<-c.done
+}
+
+func (c *BufferedTokenCache) run() {
+	defer close(c.done)
+
+	for {
+		var first tokenCacheWrite
+		select {
+		case w, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			first = w
+		case drop := <-c.drops:
+			c.drop(drop)
+			continue
+		}
+		batch := []tokenCacheWrite{first}
+	drain:
+		for len(batch) < c.batchSize {
+			select {
+			case w, ok := <-c.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+		tokenCacheWriteQueueDepth.Set(float64(len(c.queue)))
+		c.flush(batch)
+	}
+}
+
+// drop writes the queued writes not selected by an invalidation and discards
+// the rest. Only the writes queued when it starts are looked at; later ones
+// follow the invalidation and wait for the next batch.
+func (c *BufferedTokenCache) drop(d tokenCacheDrop) {
+	defer close(d.done)
+
+	var kept []tokenCacheWrite
+	for n := len(c.queue); n > 0; n-- {
+		if w := <-c.queue; !d.selected(w) {
+			kept = append(kept, w)
+		}
+	}
+	tokenCacheWriteQueueDepth.Set(float64(len(c.queue)))
+	c.flush(kept)
+}
+
+// flush writes a batch, keeping only the latest entry per token.
+func (c *BufferedTokenCache) flush(batch []tokenCacheWrite) {
+	latest := make(map[string]int, len(batch))
+	for i, w := range batch {
+		latest[w.token] = i
+	}
+
+	for i, w := range batch {
+		if latest[w.token] != i {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.writeTimeout)
+		if err := c.next.Put(ctx, w.token, w.entry); err != nil {
+			tokenCacheWriteErrorsTotal.Inc()
+			c.logger.Warn("Background token cache write failed",
+				"username", w.entry.Username,
+				"error", err,
+			)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingTokenCache blocks every Put until release is closed.
+type blockingTokenCache struct {
+	mockTokenCache
+	release chan struct{}
+}
+
+func (b *blockingTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	<-b.release
+	return b.mockTokenCache.Put(ctx, token, entry)
+}
+
+func newTestMockTokenCache() *mockTokenCache {
+	return &mockTokenCache{
+		secret: []byte("secret"),
+		kv:     &mockSharedKV{now: time.Now, data: map[string]mockKVRecord{}},
+	}
+}
+
+func TestBufferedTokenCache_FlushesOnClose(t *testing.T) {
+	next := newTestMockTokenCache()
+	c := NewBufferedTokenCache(next, 16, 4, time.Second)
+
+	for _, user := range []string{"a", "b", "c"} {
+		require.NoError(t, c.Put(context.Background(), "token-"+user, TokenCacheEntry{Username: user}))
+	}
+	c.Close()
+
+	for _, user := range []string{"a", "b", "c"} {
+		entry, err := c.Get(context.Background(), "token-"+user)
+		require.NoError(t, err)
+		assert.Equal(t, user, entry.Username)
+	}
+	assert.Error(t, c.Put(context.Background(), "token-d", TokenCacheEntry{Username: "d"}))
+}
+
+func TestBufferedTokenCache_CoalescesWritesPerToken(t *testing.T) {
+	next := &blockingTokenCache{mockTokenCache: *newTestMockTokenCache(), release: make(chan struct{})}
+	c := NewBufferedTokenCache(next, 16, 16, time.Second)
+
+	// The first write occupies the writer; the rest queue up behind it.
+	require.NoError(t, c.Put(context.Background(), "token-a", TokenCacheEntry{Username: "first"}))
+	require.Eventually(t, func() bool { return len(c.queue) == 0 }, time.Second, time.Millisecond)
+	for _, name := range []string{"second", "third", "last"} {
+		require.NoError(t, c.Put(context.Background(), "token-a", TokenCacheEntry{Username: name}))
+	}
+	close(next.release)
+	c.Close()
+
+	assert.LessOrEqual(t, next.PutCalls(), 2, "queued writes for one token are coalesced")
+	entry, err := c.Get(context.Background(), "token-a")
+	require.NoError(t, err)
+	assert.Equal(t, "last", entry.Username)
+}
+
+func TestBufferedTokenCache_DropsWhenQueueFull(t *testing.T) {
+	next := &blockingTokenCache{mockTokenCache: *newTestMockTokenCache(), release: make(chan struct{})}
+	c := NewBufferedTokenCache(next, 1, 1, time.Second)
+	defer func() {
+		close(next.release)
+		c.Close()
+	}()
+
+	before := testutil.ToFloat64(tokenCacheWritesDroppedTotal)
+
+	require.NoError(t, c.Put(context.Background(), "token-a", TokenCacheEntry{Username: "a"}))
+	require.Eventually(t, func() bool { return len(c.queue) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, c.Put(context.Background(), "token-b", TokenCacheEntry{Username: "b"}))
+
+	err := c.Put(context.Background(), "token-c", TokenCacheEntry{Username: "c"})
+	assert.ErrorIs(t, err, ErrTokenCacheQueueFull)
+	assert.Equal(t, before+1, testutil.ToFloat64(tokenCacheWritesDroppedTotal))
+}
+
+// deadlineTokenCache records the time left for each Put.
+type deadlineTokenCache struct {
+	mockTokenCache
+	left chan time.Duration
+}
+
+func (d *deadlineTokenCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	deadline, _ := ctx.Deadline()
+	d.left <- time.Until(deadline)
+	return d.mockTokenCache.Put(ctx, token, entry)
+}
+
+func TestBufferedTokenCache_WriteTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"put_timeout", 200 * time.Millisecond, 200 * time.Millisecond},
+		{"no put_timeout", 0, tokenCacheWriteTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &deadlineTokenCache{mockTokenCache: *newTestMockTokenCache(), left: make(chan time.Duration, 1)}
+			c := NewBufferedTokenCache(next, 1, 1, tc.timeout)
+			require.NoError(t, c.Put(context.Background(), "token-a", TokenCacheEntry{Username: "a"}))
+			c.Close()
+
+			left := <-next.left
+			assert.LessOrEqual(t, left, tc.want)
+			assert.Greater(t, left, tc.want-100*time.Millisecond)
+		})
+	}
+}
+
+// invalidatingTokenCache is a mock cache that supports InvalidateAll.
+type invalidatingTokenCache struct {
+	mockTokenCache
+}
+
+func (m *invalidatingTokenCache) InvalidateAll(context.Context) (int, error) {
+	n := len(m.kv.data)
+	m.kv.data = map[string]mockKVRecord{}
+	return n, nil
+}
+
+func TestBufferedTokenCache_InvalidateAll(t *testing.T) {
+	next := &invalidatingTokenCache{mockTokenCache: *newTestMockTokenCache()}
+	c := NewBufferedTokenCache(next, 16, 4, time.Second)
+	require.NoError(t, c.Put(context.Background(), "token-a", TokenCacheEntry{Username: "a"}))
+	c.Close()
+
+	n, err := c.InvalidateAll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = c.Get(context.Background(), "token-a")
+	assert.ErrorIs(t, err, ErrTokenCacheMiss)
+
+	plain := NewBufferedTokenCache(newTestMockTokenCache(), 1, 1, time.Second)
+	defer plain.Close()
+	_, err = plain.InvalidateAll(context.Background())
+	assert.Error(t, err, "wrapped cache without invalidation support")
+}
+
+// blockingInvalidatingCache blocks every Put until release is closed.
+type blockingInvalidatingCache struct {
+	invalidatingTokenCache
+	release chan struct{}
+}
+
+func (b *blockingInvalidatingCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	<-b.release
+	return b.invalidatingTokenCache.Put(ctx, token, entry)
+}
+
+func TestBufferedTokenCache_InvalidateAllDropsQueuedWrites(t *testing.T) {
+	next := &blockingInvalidatingCache{invalidatingTokenCache: invalidatingTokenCache{mockTokenCache: *newTestMockTokenCache()}, release: make(chan struct{})}
+	c := NewBufferedTokenCache(next, 16, 1, time.Second)
+	ctx := context.Background()
+
+	// The first write occupies the writer; the rest stay queued.
+	require.NoError(t, c.Put(ctx, "token-first", TokenCacheEntry{Username: "carol"}))
+	require.Eventually(t, func() bool { return len(c.queue) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, c.Put(ctx, "token-a", TokenCacheEntry{Username: "alice"}))
+	require.NoError(t, c.Put(ctx, "token-b", TokenCacheEntry{Username: "bob"}))
+
+	invalidated := make(chan error)
+	go func() {
+		_, err := c.InvalidateAll(ctx)
+		invalidated <- err
+	}()
+	select {
+	case err := <-invalidated:
+		t.Fatalf("purged while a write was in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(next.release)
+	require.NoError(t, <-invalidated)
+	c.Close()
+
+	for _, token := range []string{"token-first", "token-a", "token-b"} {
+		_, err := c.Get(ctx, token)
+		assert.ErrorIs(t, err, ErrTokenCacheMiss, "%s is not written after the purge", token)
+	}
+}
+
+// blockingJetStreamCache blocks every Put until release is closed.
+type blockingJetStreamCache struct {
+	*JetStreamTokenCache
+	release chan struct{}
+}
+
+func (b *blockingJetStreamCache) Put(ctx context.Context, token string, entry TokenCacheEntry) error {
+	<-b.release
+	return b.JetStreamTokenCache.Put(ctx, token, entry)
+}
+
+func TestBufferedTokenCache_InvalidateDropsQueuedWrites(t *testing.T) {
+	kvCache := &JetStreamTokenCache{kv: newJetstreamKV(), secret: []byte("secret"), logger: slog.Default(), now: time.Now}
+	next := &blockingJetStreamCache{JetStreamTokenCache: kvCache, release: make(chan struct{})}
+	c := NewBufferedTokenCache(next, 16, 1, time.Second)
+	ctx := context.Background()
+
+	// The first write occupies the writer; the rest stay queued.
+	require.NoError(t, c.Put(ctx, "token-first", TokenCacheEntry{Username: "carol"}))
+	require.Eventually(t, func() bool { return len(c.queue) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, c.Put(ctx, "token-a", TokenCacheEntry{Username: "Alice"}))
+	require.NoError(t, c.Put(ctx, "token-b", TokenCacheEntry{Username: "bob"}))
+	require.NoError(t, c.Put(ctx, "token-d", TokenCacheEntry{Username: "dave"}))
+	keyB, err := kvCache.tokenKey("token-b")
+	require.NoError(t, err)
+
+	invalidated := make(chan error)
+	go func() {
+		_, err := c.Invalidate(ctx, TokenCacheSelection{Usernames: []string{"alice"}, Keys: []string{keyB}})
+		invalidated <- err
+	}()
+	close(next.release)
+	require.NoError(t, <-invalidated)
+	c.Close()
+
+	for token, want := range map[string]bool{"token-first": true, "token-a": false, "token-b": false, "token-d": true} {
+		_, err := c.Get(ctx, token)
+		if want {
+			assert.NoError(t, err, token)
+		} else {
+			assert.ErrorIs(t, err, ErrTokenCacheMiss, "%s is not recreated by its queued write", token)
+		}
+	}
+}
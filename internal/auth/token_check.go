@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// maxTokenCheckBody bounds the request body of the token check endpoint.
+const maxTokenCheckBody = 64 << 10
+
+// TokenCheckConfig holds the token_check.* settings.
+type TokenCheckConfig struct {
+	Enabled bool
+	// MaxPerIP caps the checks per client IP within a sliding minute; 0
+	// disables the limit.
+	MaxPerIP int
+}
+
+// LoadTokenCheckConfig reads the token_check.* settings. It is read on every
+// request, so the endpoint can be switched on and off at runtime.
+func LoadTokenCheckConfig() TokenCheckConfig {
+	return TokenCheckConfig{
+		Enabled:  viper.GetBool("token_check.enabled"),
+		MaxPerIP: viper.GetInt("token_check.max_checks_per_ip_per_minute"),
+	}
+}
+
+// tokenCheckRequest is the body of a token check: the token and, optionally,
+// the username the client would connect with.
+type tokenCheckRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+}
+
+// TokenCheckRules are the rendered rules of one direction.
+type TokenCheckRules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// TokenCheckPermissions are the permissions a JWT would be issued with.
+type TokenCheckPermissions struct {
+	Publish   TokenCheckRules `json:"publish"`
+	Subscribe TokenCheckRules `json:"subscribe"`
+}
+
+// TokenCheckCache is the token's state in the token cache.
+type TokenCheckCache struct {
+	Enabled        bool   `json:"enabled"`
+	Cached         bool   `json:"cached"`
+	LastVerifiedAt string `json:"last_verified_at,omitempty"`
+}
+
+// TokenCheckReport is what a NATS connect with the token would yield.
+type TokenCheckReport struct {
+	Valid bool `json:"valid"`
+	// Source is gitlab, or cache when GitLab is unavailable and the token
+	// cache answers as it would for a connect.
+	Source string `json:"source,omitempty"`
+	// Deny is the deny code a connect would get; empty when it succeeds.
+	Deny      DenyCode `json:"deny,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	// Owner and UserID identify the token owner; Username is the username
+	// a connect is issued for, and Principal the team principal the
+	// permissions are rendered for instead, if any.
+	Owner           string                 `json:"owner,omitempty"`
+	UserID          int64                  `json:"user_id,omitempty"`
+	Username        string                 `json:"username,omitempty"`
+	Principal       string                 `json:"principal,omitempty"`
+	Scopes          []string               `json:"scopes,omitempty"`
+	ExcessiveScopes []string               `json:"excessive_scopes,omitempty"`
+	Groups          []string               `json:"groups,omitempty"`
+	Role            string                 `json:"role,omitempty"`
+	Permissions     *TokenCheckPermissions `json:"permissions,omitempty"`
+	Cache           TokenCheckCache        `json:"cache"`
+}
+
+// checkToken builds the report of a token, following the decisions of
+// handleAuthRequest. Nothing is written: the token cache, issuance limits
+// and account budget are left alone, and client tags are not applied.
+func (c *NATSClient) checkToken(ctx context.Context, token, username string) TokenCheckReport {
+	report := TokenCheckReport{TokenType: tokenType(token)}
+
+	var entry *TokenCacheEntry
+	if c.tokenCache != nil {
+		report.Cache.Enabled = true
+		if cached, err := c.tokenCache.Get(ctx, token); err == nil {
+			entry = cached
+			report.Cache.Cached, report.Cache.LastVerifiedAt = true, cached.LastVerifiedAt
+		}
+	}
+
+	var result AuthorizeResult
+	vt, err := verifyToken(ctx, c.gitlabClient, token)
+	switch {
+	case err == nil:
+		result, report.Source = AuthorizeResult{Allow: true, Verified: vt}, "gitlab"
+	case errors.Is(err, errUnknownToken) && passwordDetectionMode() == PasswordDetectionStrict && looksLikePassword(token):
+		report.Deny, report.Error = DenyPasswordSent, passwordSentText
+		return report
+	case errors.Is(err, ErrInvalidToken):
+		report.Deny, report.Error = DenyInvalidCredentials, "GitLab rejected the token"
+		return report
+	case isFallbackToCacheError(err) && entry != nil:
+		result, report.Source = AuthorizeResult{Allow: true, FromCache: true, Cached: entry}, "cache"
+	case isFallbackToCacheError(err):
+		report.Deny, report.Error = DenyInvalidCredentials, "GitLab is unavailable and the token is not cached"
+		return report
+	default:
+		report.Deny, report.Error = DenyAuthError, "authentication error"
+		return report
+	}
+
+	report.Valid = true
+	if kind := result.TokenType(); kind != "" {
+		report.TokenType = kind
+	}
+	report.Owner, report.UserID = result.Username(), result.UserID()
+	report.Scopes, report.Groups = result.Scopes(), result.Groups()
+
+	username, code, text := connectUsername(username, result)
+	report.Username = username
+	if code != "" {
+		report.Deny, report.Error = code, text
+		return report
+	}
+
+	if policy := LoadScopePolicy(); policy.Mode != ScopePolicyOff {
+		report.ExcessiveScopes = policy.Excessive(report.Scopes)
+		if len(report.ExcessiveScopes) > 0 && policy.Mode == ScopePolicyEnforce {
+			report.Deny, report.Error = DenyExcessiveScopes, "token scopes exceed the allowed scopes"
+			return report
+		}
+	}
+	report.Principal, _ = c.teamPrincipal(result)
+	if _, ok := templateIdentity(identityMode(), username, result); !ok && report.Principal == "" {
+		report.Deny, report.Error = DenyAuthError, "user ID unknown, retry when GitLab is reachable"
+		return report
+	}
+
+	report.Role, _, _ = c.userRole(result)
+	set := c.resolvePermissions(result, username, nil)
+	report.Permissions = &TokenCheckPermissions{
+		Publish:   TokenCheckRules{Allow: nonNil(set.Publish.Allow), Deny: set.Publish.Deny},
+		Subscribe: TokenCheckRules{Allow: nonNil(set.Subscribe.Allow), Deny: set.Subscribe.Deny},
+	}
+	return report
+}
+
+// nonNil returns s, or an empty slice for nil, so an empty allow list is
+// encoded as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// TokenCheckHandler serves POST /token/check, where users check a token
+// without connecting a NATS client. The token in the body
+// ({"token": "...", "username": "..."}) is the only credential: the report
+// covers nothing but that token.
+func (c *NATSClient) TokenCheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := LoadTokenCheckConfig()
+		if !cfg.Enabled {
+			http.Error(w, "token check is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var req tokenCheckRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTokenCheckBody)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		req.Token = strings.TrimSpace(req.Token)
+		if req.Token == "" {
+			http.Error(w, "token is required", http.StatusBadRequest)
+			return
+		}
+
+		// Checks are throttled like connects, so the endpoint cannot be used
+		// to guess tokens past the attempt limits and lockouts.
+		host := remoteHost(r)
+		if limited := c.checkTokenCheckRate(host, cfg.MaxPerIP); limited || c.checkAuthAttempts(req.Username, host) != "" {
+			tokenChecksTotal.WithLabelValues("limited").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(issuanceWindow.Seconds())))
+			http.Error(w, "too many token checks, slow down", http.StatusTooManyRequests)
+			return
+		}
+		if until, locked := c.checkLockout(req.Username); locked {
+			tokenChecksTotal.WithLabelValues("limited").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			http.Error(w, lockedOutText(until), http.StatusTooManyRequests)
+			return
+		}
+
+		report := c.checkToken(r.Context(), req.Token, req.Username)
+		if !report.Valid && (report.Deny == DenyInvalidCredentials || report.Deny == DenyPasswordSent) {
+			c.recordAuthFailure(req.Username)
+		}
+		outcome := "valid"
+		switch {
+		case !report.Valid && report.Deny == DenyAuthError:
+			outcome = "error"
+		case !report.Valid:
+			outcome = "invalid"
+		case report.Deny != "":
+			outcome = "denied"
+		}
+		tokenChecksTotal.WithLabelValues(outcome).Inc()
+		c.logger.Info("Token checked", "username", report.Username, "owner", report.Owner, "outcome", outcome, "deny", report.Deny)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// checkTokenCheckRate counts a token check against
+// token_check.max_checks_per_ip_per_minute and reports whether it exceeded
+// the limit. Checks share the attempt limiter but not its keys, so they do
+// not use up the budget of connects from the same IP.
+func (c *NATSClient) checkTokenCheckRate(host string, limit int) bool {
+	if c.attempts == nil || host == "" || limit <= 0 {
+		return false
+	}
+	allowed, count := c.attempts.Allow("check:"+host, limit, time.Now())
+	if !allowed {
+		c.attemptLimited("token_check", "", host, count, limit)
+	}
+	return !allowed
+}
+
+// remoteHost returns the host of the request's remote address.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTokenCheckClient(t *testing.T) (*NATSClient, *mockTokenCache) {
+	t.Helper()
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("PRIVATE-TOKEN")
+		switch {
+		case token == "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case token != "good":
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 3, "username": "alice"}`))
+		case "/api/v4/personal_access_tokens/self":
+			_, _ = w.Write([]byte(`{"id": 7, "scopes": ["read_api", "api"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(testServer.Close)
+
+	cache := &mockTokenCache{secret: []byte("secret"), kv: &mockSharedKV{now: time.Now, data: map[string]mockKVRecord{}}}
+	c := &NATSClient{
+		logger:       slog.Default(),
+		gitlabClient: &GitLabClient{baseURL: testServer.URL, timeout: time.Second},
+		tokenCache:   cache,
+	}
+	return c, cache
+}
+
+func TestCheckToken(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Username}}.>"})
+	viper.Set("auth.scope_policy", ScopePolicyWarn)
+	viper.Set("auth.forbidden_scopes", []string{"api"})
+	c, cache := newTokenCheckClient(t)
+	c.permissions = loadPermissionsSnapshot()
+	ctx := context.Background()
+
+	report := c.checkToken(ctx, "good", "")
+	assert.True(t, report.Valid)
+	assert.Empty(t, report.Deny)
+	assert.Equal(t, "gitlab", report.Source)
+	assert.Equal(t, "alice", report.Owner)
+	assert.Equal(t, int64(3), report.UserID)
+	assert.Equal(t, "alice", report.Username, "the owner's username is used without one")
+	assert.Equal(t, []string{"api"}, report.ExcessiveScopes)
+	require.NotNil(t, report.Permissions)
+	assert.Equal(t, []string{"user.alice.>"}, report.Permissions.Publish.Allow)
+	assert.False(t, report.Cache.Cached)
+	assert.Zero(t, cache.PutCalls(), "the check writes nothing")
+
+	report = c.checkToken(ctx, "good", "Alice")
+	assert.Empty(t, report.Deny)
+	assert.Equal(t, "alice", report.Username, "GitLab's spelling, as for a connect")
+	require.NotNil(t, report.Permissions)
+	assert.Equal(t, []string{"user.alice.>"}, report.Permissions.Publish.Allow)
+
+	viper.Set("auth.scope_policy", ScopePolicyEnforce)
+	report = c.checkToken(ctx, "good", "bob")
+	assert.True(t, report.Valid)
+	assert.Equal(t, DenyExcessiveScopes, report.Deny)
+	assert.Nil(t, report.Permissions)
+
+	viper.Set("auth.require_token_owner", true)
+	report = c.checkToken(ctx, "good", "bob")
+	assert.True(t, report.Valid)
+	assert.Equal(t, DenyUsernameMismatch, report.Deny, "as for a connect with someone else's username")
+	assert.Nil(t, report.Permissions)
+
+	report = c.checkToken(ctx, "bad", "")
+	assert.False(t, report.Valid)
+	assert.Equal(t, DenyInvalidCredentials, report.Deny)
+
+	report = c.checkToken(ctx, "down", "")
+	assert.False(t, report.Valid)
+	assert.Equal(t, DenyInvalidCredentials, report.Deny, "not cached")
+
+	require.NoError(t, cache.Put(ctx, "down", TokenCacheEntry{UserID: 4, Username: "carol", LastVerifiedAt: "2026-10-15T08:00:00Z"}))
+	report = c.checkToken(ctx, "down", "")
+	assert.True(t, report.Valid)
+	assert.Equal(t, "cache", report.Source)
+	assert.Equal(t, "carol", report.Owner)
+	assert.Equal(t, TokenCheckCache{Enabled: true, Cached: true, LastVerifiedAt: "2026-10-15T08:00:00Z"}, report.Cache)
+}
+
+func TestCheckToken_TeamPrincipal(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("nats.permissions.publish.allow", []string{"user.{{.Identity}}.>"})
+	viper.Set("auth.identity", IdentityUserID)
+	c, cache := newTokenCheckClient(t)
+	c.permissions = loadPermissionsSnapshot()
+	teams, err := newTeamSelector([]TeamMapping{{Group: "payments", Principal: "team-payments"}})
+	require.NoError(t, err)
+	c.teams = teams
+	ctx := context.Background()
+
+	require.NoError(t, cache.Put(ctx, "down", TokenCacheEntry{Username: "carol", Groups: "payments"}))
+	report := c.checkToken(ctx, "down", "")
+	assert.Empty(t, report.Deny, "team principals render no user ID")
+	assert.Equal(t, "carol", report.Username)
+	assert.Equal(t, "team-payments", report.Principal)
+	require.NotNil(t, report.Permissions)
+	assert.Equal(t, []string{"user.team-payments.>"}, report.Permissions.Publish.Allow)
+
+	report = c.checkToken(ctx, "good", "")
+	assert.Empty(t, report.Deny)
+	assert.Empty(t, report.Principal)
+	require.NotNil(t, report.Permissions)
+	assert.Equal(t, []string{"user.3.>"}, report.Permissions.Publish.Allow)
+}
+
+func TestTokenCheckHandler(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	c, _ := newTokenCheckClient(t)
+	c.permissions = loadPermissionsSnapshot()
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.TokenCheckHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/token/check", strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, post(`{"token": "good"}`).Code)
+
+	viper.Set("token_check.enabled", true)
+	assert.Equal(t, http.StatusBadRequest, post(`{"token": ""}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`not json`).Code)
+
+	rec := post(`{"token": "good", "username": "alice"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	var report TokenCheckReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.True(t, report.Valid)
+	assert.Equal(t, "alice", report.Username)
+
+	rec = httptest.NewRecorder()
+	c.TokenCheckHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/token/check", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestTokenCheckHandler_Throttled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("token_check.enabled", true)
+	viper.Set("token_check.max_checks_per_ip_per_minute", 2)
+	c, _ := newTokenCheckClient(t)
+	c.permissions = loadPermissionsSnapshot()
+	c.attempts = newIssuanceLimiter()
+	post := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token/check", strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		c.TokenCheckHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, post("192.0.2.1:1234", `{"token": "bad"}`).Code)
+	assert.Equal(t, http.StatusOK, post("192.0.2.1:1235", `{"token": "good"}`).Code)
+	rec := post("192.0.2.1:1236", `{"token": "good"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "the IP used up its checks")
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, post("192.0.2.2:1234", `{"token": "good"}`).Code, "other IPs are not affected")
+
+	viper.Set("token_check.max_checks_per_ip_per_minute", 0)
+	viper.Set("auth.max_attempts_per_minute", 1)
+	assert.Equal(t, http.StatusOK, post("192.0.2.3:1234", `{"token": "bad", "username": "bob"}`).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post("192.0.2.4:1234", `{"token": "bad", "username": "BOB"}`).Code,
+		"the attempt limits of connects apply")
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
+)
+
+// traceOverridePrefix starts the config override keys that trace every auth
+// request of one replica: trace.<instance ID or hostname>.
+const traceOverridePrefix = "trace."
+
+// traceOverride samples every auth request transaction of this replica for
+// a while, for debugging a live problem without raising
+// sentry.sample_rate fleet-wide. It reverts on its own when the duration
+// given in the flag has passed.
+type traceOverride struct {
+	// instance and host are the targets that select this replica.
+	instance string
+	host     string
+	logger   *slog.Logger
+	now      func() time.Time
+
+	// until is when full tracing ends, in Unix nanoseconds; 0 when off.
+	until atomic.Int64
+
+	// mu guards timer, which clears the gauge once full tracing ends.
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func newTraceOverride(instance string, logger *slog.Logger) *traceOverride {
+	host, _ := os.Hostname()
+	return &traceOverride{instance: instance, host: host, logger: logger, now: time.Now}
+}
+
+// matches reports whether a flag targets this replica.
+func (t *traceOverride) matches(target string) bool {
+	return target != "" && (target == t.instance || target == t.host)
+}
+
+// apply handles the flag of one target: a duration (e.g. "15m") counted from
+// when the flag was written, capped at sentry.trace_override_max. Deleting
+// the flag ends full tracing early. Flags of other replicas are ignored.
+func (t *traceOverride) apply(target, raw string, written time.Time, deleted bool) error {
+	if !t.matches(target) {
+		return nil
+	}
+	if deleted {
+		t.set(time.Time{})
+		t.logger.Info("Full tracing ended, flag removed")
+		return nil
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid trace duration %q, expected e.g. 15m", raw)
+	}
+	if limit := viper.GetDuration("sentry.trace_override_max"); limit > 0 && d > limit {
+		t.logger.Warn("Trace duration capped", "requested", d, "max", limit)
+		d = limit
+	}
+	until := written.Add(d)
+	if !until.After(t.now()) {
+		t.logger.Debug("Ignoring expired trace flag", "until", until)
+		return nil
+	}
+
+	t.set(until)
+	t.logger.Info("Full tracing enabled for this replica", "until", until)
+	if !sentryEnabled() || !viper.GetBool("sentry.enable_tracing") {
+		t.logger.Warn("Full tracing has no effect: Sentry tracing is not enabled (sentry.dsn, sentry.enable_tracing)")
+	}
+	return nil
+}
+
+// set switches full tracing on until the given time, or off for the zero time.
+func (t *traceOverride) set(until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if until.IsZero() {
+		t.until.Store(0)
+		traceOverrideActive.Set(0)
+		return
+	}
+	t.until.Store(until.UnixNano())
+	traceOverrideActive.Set(1)
+	t.timer = time.AfterFunc(until.Sub(t.now()), func() {
+		if t.until.CompareAndSwap(until.UnixNano(), 0) {
+			traceOverrideActive.Set(0)
+			t.logger.Info("Full tracing expired")
+		}
+	})
+}
+
+// Active reports whether every request is traced at now.
+func (t *traceOverride) Active(now time.Time) bool {
+	if t == nil {
+		return false
+	}
+	until := t.until.Load()
+	return until != 0 && now.UnixNano() < until
+}
+
+// traceOptions forces sampling of an auth request transaction while full
+// tracing is on for this replica.
+func (c *NATSClient) traceOptions(now time.Time) []sentry.SpanOption {
+	if c.configOverrides == nil || !c.configOverrides.trace.Active(now) {
+		return nil
+	}
+	return []sentry.SpanOption{sentry.WithSpanSampled(sentry.SampledTrue)}
+}
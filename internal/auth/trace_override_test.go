@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceOverride(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("sentry.trace_override_max", time.Hour)
+
+	now := time.Now()
+	tr := &traceOverride{instance: "antal-1-3f2a9c01", host: "antal-1", logger: slog.Default(), now: func() time.Time { return now }}
+	t.Cleanup(func() { tr.set(time.Time{}) })
+
+	require.NoError(t, tr.apply("antal-2", "15m", now, false))
+	assert.False(t, tr.Active(now), "flags of other replicas are ignored")
+
+	require.Error(t, tr.apply("antal-1", "soon", now, false))
+	require.Error(t, tr.apply("antal-1", "-5m", now, false))
+
+	require.NoError(t, tr.apply("antal-1", "15m", now.Add(-5*time.Minute), false))
+	assert.True(t, tr.Active(now))
+	assert.False(t, tr.Active(now.Add(10*time.Minute)), "counted from when the flag was written")
+
+	require.NoError(t, tr.apply("antal-1-3f2a9c01", "", now, true))
+	assert.False(t, tr.Active(now), "deleting the flag ends it")
+
+	require.NoError(t, tr.apply("antal-1-3f2a9c01", "24h", now, false))
+	assert.True(t, tr.Active(now.Add(59*time.Minute)))
+	assert.False(t, tr.Active(now.Add(61*time.Minute)), "capped at sentry.trace_override_max")
+
+	tr.set(time.Time{})
+	require.NoError(t, tr.apply("antal-1", "10m", now.Add(-time.Hour), false))
+	assert.False(t, tr.Active(now), "an expired flag replayed at startup stays off")
+}
+
+func TestTraceOverride_Expires(t *testing.T) {
+	tr := newTraceOverride("antal-1", slog.Default())
+	tr.set(time.Now().Add(20 * time.Millisecond))
+	assert.True(t, tr.Active(time.Now()))
+	assert.Eventually(t, func() bool { return tr.until.Load() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestTraceOptions(t *testing.T) {
+	c := &NATSClient{logger: slog.Default()}
+	assert.Nil(t, c.traceOptions(time.Now()), "without config overrides")
+
+	c.configOverrides = newConfigOverrides(nil, slog.Default())
+	assert.Nil(t, c.traceOptions(time.Now()))
+	c.configOverrides.trace.set(time.Now().Add(time.Minute))
+	t.Cleanup(func() { c.configOverrides.trace.set(time.Time{}) })
+	assert.Len(t, c.traceOptions(time.Now()), 1)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/viper"
+)
+
+// TrustedCluster lists the server IDs (public server nkeys) of one NATS
+// cluster allowed to send auth callout requests.
+type TrustedCluster struct {
+	// Name is the cluster name the servers report; "" for servers that are
+	// not part of a cluster.
+	Name      string   `mapstructure:"name" json:"name"`
+	ServerIDs []string `mapstructure:"server_ids" json:"server_ids"`
+}
+
+// TrustedServersConfig configures which servers are answered.
+type TrustedServersConfig struct {
+	Enabled  bool
+	Clusters []TrustedCluster
+}
+
+// LoadTrustedServersConfig reads the trusted_servers.* settings.
+func LoadTrustedServersConfig() (TrustedServersConfig, error) {
+	cfg := TrustedServersConfig{Enabled: viper.GetBool("trusted_servers.enabled")}
+	if err := viper.UnmarshalKey("trusted_servers.clusters", &cfg.Clusters); err != nil {
+		return cfg, fmt.Errorf("invalid trusted_servers.clusters: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate checks an enabled configuration.
+func (cfg TrustedServersConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if len(cfg.Clusters) == 0 {
+		return fmt.Errorf("trusted_servers.clusters must list at least one cluster")
+	}
+	seen := make(map[string]bool, len(cfg.Clusters))
+	for i, cluster := range cfg.Clusters {
+		if seen[cluster.Name] {
+			return fmt.Errorf("trusted_servers.clusters[%d]: duplicate cluster %q", i, cluster.Name)
+		}
+		seen[cluster.Name] = true
+		if len(cluster.ServerIDs) == 0 {
+			return fmt.Errorf("trusted_servers.clusters[%d]: server_ids is empty", i)
+		}
+		for _, id := range cluster.ServerIDs {
+			if !nkeys.IsValidPublicServerKey(id) {
+				return fmt.Errorf("trusted_servers.clusters[%d]: %q is not a server public key", i, id)
+			}
+		}
+	}
+	return nil
+}
+
+// trustedServers answers whether a request comes from a known server.
+type trustedServers struct {
+	clusters map[string][]string
+}
+
+func newTrustedServers(cfg TrustedServersConfig) *trustedServers {
+	t := &trustedServers{clusters: make(map[string][]string, len(cfg.Clusters))}
+	for _, cluster := range cfg.Clusters {
+		t.clusters[cluster.Name] = cluster.ServerIDs
+	}
+	return t
+}
+
+// Check returns "" when rc was sent by a trusted server, otherwise why it
+// is not trusted. The request JWT is signed by the sending server, so its
+// issuer proves the server ID; the ID in the server block is only a claim.
+func (t *trustedServers) Check(rc *jwt.AuthorizationRequestClaims) string {
+	if t == nil {
+		return ""
+	}
+	if rc.Issuer != rc.Server.ID {
+		return "issuer_mismatch"
+	}
+	ids, ok := t.clusters[rc.Server.Cluster]
+	if !ok {
+		return "unknown_cluster"
+	}
+	if !slices.Contains(ids, rc.Server.ID) {
+		return "unknown_server"
+	}
+	return ""
+}
+
+// checkServer reports whether rc comes from an untrusted server. Such
+// requests are not answered: a server that is not ours, even one connected
+// to the callout account, must not collect signed user JWTs.
+func (c *NATSClient) checkServer(rc *jwt.AuthorizationRequestClaims) bool {
+	reason := c.trustedServers.Check(rc)
+	if reason == "" {
+		if c.trustedServers != nil {
+			trustedServerRequestsTotal.WithLabelValues(rc.Server.Cluster).Inc()
+		}
+		return false
+	}
+	untrustedServerRequestsTotal.WithLabelValues(reason).Inc()
+	c.logger.Warn("Ignoring auth request from an untrusted server",
+		"reason", reason,
+		"server_id", rc.Server.ID,
+		"server_name", rc.Server.Name,
+		"server_cluster", rc.Server.Cluster,
+		"issuer", rc.Issuer,
+	)
+	return true
+}
+
+// initTrustedServers optionally restricts the servers whose requests are
+// answered.
+func (c *NATSClient) initTrustedServers() error {
+	cfg, err := LoadTrustedServersConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	c.trustedServers = newTrustedServers(cfg)
+	c.logger.Info("Answering trusted servers only", "clusters", len(cfg.Clusters))
+	return nil
+}
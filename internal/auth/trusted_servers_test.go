@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServerKey(t *testing.T) (nkeys.KeyPair, string) {
+	t.Helper()
+	kp, err := nkeys.CreateServer()
+	require.NoError(t, err)
+	pub, err := kp.PublicKey()
+	require.NoError(t, err)
+	return kp, pub
+}
+
+// signedRequest returns the request a server signed with kp sends, claiming
+// to be server id of cluster.
+func signedRequest(t *testing.T, kp nkeys.KeyPair, id, cluster string) *jwt.AuthorizationRequestClaims {
+	t.Helper()
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userPub, err := user.PublicKey()
+	require.NoError(t, err)
+	rc := jwt.NewAuthorizationRequestClaims(userPub)
+	rc.Server = jwt.ServerID{ID: id, Name: "nats-1", Cluster: cluster}
+	rc.UserNkey = userPub
+	token, err := rc.Encode(kp)
+	require.NoError(t, err)
+	decoded, err := jwt.DecodeAuthorizationRequestClaims(token)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestTrustedServersConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	_, id := newServerKey(t)
+	viper.Set("trusted_servers.enabled", true)
+	viper.Set("trusted_servers.clusters", []any{
+		map[string]any{"name": "east", "server_ids": []any{id}},
+		map[string]any{"name": "", "server_ids": []any{id}},
+	})
+	cfg, err := LoadTrustedServersConfig()
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, []TrustedCluster{{Name: "east", ServerIDs: []string{id}}, {Name: "", ServerIDs: []string{id}}}, cfg.Clusters)
+
+	assert.ErrorContains(t, TrustedServersConfig{Enabled: true}.Validate(), "at least one cluster")
+	assert.ErrorContains(t, TrustedServersConfig{Enabled: true, Clusters: []TrustedCluster{{Name: "east"}}}.Validate(), "server_ids is empty")
+	assert.ErrorContains(t, TrustedServersConfig{Enabled: true, Clusters: []TrustedCluster{{Name: "east", ServerIDs: []string{"nats-1"}}}}.Validate(), "not a server public key")
+	assert.ErrorContains(t, TrustedServersConfig{Enabled: true, Clusters: []TrustedCluster{
+		{Name: "east", ServerIDs: []string{id}}, {Name: "east", ServerIDs: []string{id}},
+	}}.Validate(), "duplicate cluster")
+	assert.NoError(t, TrustedServersConfig{}.Validate())
+}
+
+func TestTrustedServersCheck(t *testing.T) {
+	eastKP, eastID := newServerKey(t)
+	westKP, westID := newServerKey(t)
+	soloKP, soloID := newServerKey(t)
+	rogueKP, rogueID := newServerKey(t)
+
+	trusted := newTrustedServers(TrustedServersConfig{Enabled: true, Clusters: []TrustedCluster{
+		{Name: "east", ServerIDs: []string{eastID}},
+		{Name: "west", ServerIDs: []string{westID}},
+		{Name: "", ServerIDs: []string{soloID}},
+	}})
+
+	assert.Empty(t, trusted.Check(signedRequest(t, eastKP, eastID, "east")))
+	assert.Empty(t, trusted.Check(signedRequest(t, westKP, westID, "west")))
+	assert.Empty(t, trusted.Check(signedRequest(t, soloKP, soloID, "")))
+
+	assert.Equal(t, "unknown_server", trusted.Check(signedRequest(t, eastKP, eastID, "west")), "trusted per cluster")
+	assert.Equal(t, "unknown_server", trusted.Check(signedRequest(t, rogueKP, rogueID, "east")))
+	assert.Equal(t, "unknown_cluster", trusted.Check(signedRequest(t, rogueKP, rogueID, "south")))
+	assert.Equal(t, "issuer_mismatch", trusted.Check(signedRequest(t, rogueKP, eastID, "east")), "a copied server ID is not enough")
+
+	var disabled *trustedServers
+	assert.Empty(t, disabled.Check(signedRequest(t, rogueKP, rogueID, "south")))
+}
+
+func TestCheckServer(t *testing.T) {
+	untrustedServerRequestsTotal.Reset()
+	trustedServerRequestsTotal.Reset()
+
+	kp, id := newServerKey(t)
+	rogueKP, rogueID := newServerKey(t)
+	c := &NATSClient{logger: slog.Default()}
+	assert.False(t, c.checkServer(signedRequest(t, rogueKP, rogueID, "")), "every server is answered when disabled")
+	assert.Equal(t, 0, testutil.CollectAndCount(trustedServerRequestsTotal))
+
+	c.trustedServers = newTrustedServers(TrustedServersConfig{Enabled: true, Clusters: []TrustedCluster{{Name: "east", ServerIDs: []string{id}}}})
+	assert.False(t, c.checkServer(signedRequest(t, kp, id, "east")))
+	assert.True(t, c.checkServer(signedRequest(t, rogueKP, rogueID, "east")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(trustedServerRequestsTotal.WithLabelValues("east")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(untrustedServerRequestsTotal.WithLabelValues("unknown_server")))
+}
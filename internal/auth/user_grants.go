@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// UserGrant is a set of extra allow permissions for one user, stored as JSON
+// in the user grants KV bucket under the username.
+type UserGrant struct {
+	Publish   []string `json:"publish,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+	// Issues references the approved access requests the grant comes from.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// PermissionSet returns the grant as allow-only permissions.
+func (g UserGrant) PermissionSet() PermissionSet {
+	return PermissionSet{
+		Publish:   SubjectRules{Allow: g.Publish},
+		Subscribe: SubjectRules{Allow: g.Subscribe},
+	}
+}
+
+// UserGrants keeps an in-memory copy of the user grants bucket, updated by a
+// KV watcher, so looking up a grant never costs a KV round trip.
+type UserGrants struct {
+	kv      nats.KeyValue
+	watcher nats.KeyWatcher
+	logger  *slog.Logger
+
+	mu     sync.RWMutex
+	grants map[string]UserGrant
+}
+
+// NewUserGrants binds to (or creates) the user grants bucket.
+func NewUserGrants(js nats.JetStreamContext, bucket string, replicas int) (*UserGrants, error) {
+	logger := slog.With("component", "user_grants")
+
+	if bucket == "" {
+		return nil, fmt.Errorf("user grants bucket is empty")
+	}
+	if replicas <= 0 {
+		replicas = 3
+	}
+
+	kv, created, err := bindOrCreateKV(js, &nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "GCS Antal per-user permission grants",
+		Replicas:    replicas,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("User grants bucket ready", "bucket", bucket, "created", created)
+
+	return newUserGrants(kv, logger), nil
+}
+
+func newUserGrants(kv nats.KeyValue, logger *slog.Logger) *UserGrants {
+	return &UserGrants{kv: kv, logger: logger, grants: make(map[string]UserGrant)}
+}
+
+// Start loads the current grants and keeps watching for changes. It returns
+// once the initial grants are loaded.
+func (g *UserGrants) Start() error {
+	watcher, err := g.kv.WatchAll()
+	if err != nil {
+		return fmt.Errorf("failed to watch user grants: %w", err)
+	}
+	g.watcher = watcher
+
+	// The watcher sends a nil entry once all existing values were delivered.
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		g.applyEntry(entry)
+	}
+
+	go func() {
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				g.applyEntry(entry)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the bucket.
+func (g *UserGrants) Stop() {
+	if g.watcher != nil {
+		_ = g.watcher.Stop()
+	}
+}
+
+func (g *UserGrants) applyEntry(entry nats.KeyValueEntry) {
+	deleted := entry.Operation() == nats.KeyValueDelete || entry.Operation() == nats.KeyValuePurge
+	if err := g.apply(entry.Key(), entry.Value(), deleted); err != nil {
+		g.logger.Warn("Ignoring user grant", "username", entry.Key(), "revision", entry.Revision(), "error", err)
+	}
+}
+
+// apply stores (or, when deleted, removes) the grant of a single user.
+func (g *UserGrants) apply(username string, raw []byte, deleted bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if deleted {
+		delete(g.grants, username)
+		g.logger.Info("User grant removed", "username", username)
+		return nil
+	}
+
+	var grant UserGrant
+	if err := json.Unmarshal(raw, &grant); err != nil {
+		return fmt.Errorf("invalid grant: %w", err)
+	}
+	g.grants[username] = grant
+	g.logger.Info("User grant applied", "username", username, "publish", grant.Publish, "subscribe", grant.Subscribe, "issues", grant.Issues)
+	return nil
+}
+
+// Lookup returns the grant of a user.
+func (g *UserGrants) Lookup(username string) (UserGrant, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	grant, ok := g.grants[username]
+	return grant, ok
+}
+
+// Grants returns a copy of all grants.
+func (g *UserGrants) Grants() map[string]UserGrant {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return maps.Clone(g.grants)
+}
+
+// Put writes a user's grant to the bucket.
+func (g *UserGrants) Put(username string, grant UserGrant) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	_, err = g.kv.Put(username, data)
+	return err
+}
+
+// Delete removes a user's grant from the bucket.
+func (g *UserGrants) Delete(username string) error {
+	return g.kv.Delete(username)
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// serverXKeyHeader carries the public xkey of a server that encrypts its auth
+// callout requests (auth_callout { xkey: ... } in the server config).
+const serverXKeyHeader = "Nats-Server-Xkey"
+
+// errXKeyNotConfigured is returned for encrypted requests without nats.xkey_seed.
+var errXKeyNotConfigured = errors.New("request is encrypted, but nats.xkey_seed is not configured")
+
+// openRequest returns the authorization request JWT of msg. Requests sealed
+// by the server for our xkey are decrypted; the server's public xkey is
+// returned so the response can be sealed for it ("" for plaintext requests).
+func (c *NATSClient) openRequest(msg *nats.Msg) ([]byte, string, error) {
+	serverXKey := msg.Header.Get(serverXKeyHeader)
+	if serverXKey == "" {
+		return msg.Data, "", nil
+	}
+	if c.xKeyPair == nil {
+		return nil, serverXKey, errXKeyNotConfigured
+	}
+	data, err := c.xKeyPair.Open(msg.Data, serverXKey)
+	if err != nil {
+		return nil, serverXKey, fmt.Errorf("failed to decrypt request: %w", err)
+	}
+	return data, serverXKey, nil
+}
+
+// sealResponse returns the response payload: the JWT itself, or the JWT
+// sealed for the server when the request was encrypted.
+func (c *NATSClient) sealResponse(token, serverXKey string) ([]byte, error) {
+	if serverXKey == "" {
+		return []byte(token), nil
+	}
+	if c.xKeyPair == nil {
+		return nil, errXKeyNotConfigured
+	}
+	sealed, err := c.xKeyPair.Seal([]byte(token), serverXKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt response: %w", err)
+	}
+	return sealed, nil
+}
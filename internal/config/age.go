@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// This file decrypts the age format (https://age-encryption.org/v1) for
+// X25519 recipients, the keys SOPS and the age CLI use. Encryption, scrypt
+// passphrases and SSH keys are not supported.
+
+const (
+	ageArmorBegin   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd     = "-----END AGE ENCRYPTED FILE-----"
+	ageIntro        = "age-encryption.org/v1"
+	ageX25519Label  = "age-encryption.org/v1/X25519"
+	ageKeyHRP       = "age-secret-key-"
+	ageChunkSize    = 64 * 1024
+	ageStanzaColumn = 64
+)
+
+var (
+	errAgeNoIdentity = errors.New("age: no identity matches any recipient")
+	errAgeMalformed  = errors.New("age: malformed encrypted value")
+)
+
+// ageIdentity is an X25519 age secret key.
+type ageIdentity struct {
+	key *ecdh.PrivateKey
+}
+
+// parseAgeIdentity parses an AGE-SECRET-KEY-1... string.
+func parseAgeIdentity(s string) (ageIdentity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return ageIdentity{}, fmt.Errorf("age: invalid secret key: %w", err)
+	}
+	if hrp != ageKeyHRP {
+		return ageIdentity{}, fmt.Errorf("age: not a secret key (%q)", hrp)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return ageIdentity{}, fmt.Errorf("age: invalid secret key: %w", err)
+	}
+	return ageIdentity{key: key}, nil
+}
+
+// parseAgeIdentities parses an identity file: one secret key per line,
+// with blank lines and # comments ignored.
+func parseAgeIdentities(text string) ([]ageIdentity, error) {
+	var ids []ageIdentity
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := parseAgeIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// isAgeArmored reports whether a value is an ASCII-armored age file.
+func isAgeArmored(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), ageArmorBegin)
+}
+
+// dearmorAge returns the binary age file of an ASCII-armored one. Line
+// lengths are not checked, as YAML block scalars may re-indent them.
+func dearmorAge(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	body, ok := strings.CutPrefix(s, ageArmorBegin)
+	if !ok {
+		return nil, errAgeMalformed
+	}
+	if body, ok = strings.CutSuffix(body, ageArmorEnd); !ok {
+		return nil, fmt.Errorf("%w: missing %s", errAgeMalformed, ageArmorEnd)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAgeMalformed, err)
+	}
+	return data, nil
+}
+
+// ageStanza is a recipient stanza of the header.
+type ageStanza struct {
+	args []string
+	body []byte
+}
+
+// decryptAge decrypts an ASCII-armored age file with the first identity
+// that matches one of its recipients.
+func decryptAge(armored string, ids []ageIdentity) ([]byte, error) {
+	data, err := dearmorAge(armored)
+	if err != nil {
+		return nil, err
+	}
+	stanzas, header, mac, payload, err := parseAgeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, st := range stanzas {
+		if len(st.args) != 2 || st.args[0] != "X25519" {
+			continue
+		}
+		for _, id := range ids {
+			if fileKey, err = id.unwrap(st); err == nil {
+				break
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, errAgeNoIdentity
+	}
+
+	macKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write(header)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, fmt.Errorf("%w: header MAC mismatch", errAgeMalformed)
+	}
+	return decryptAgePayload(fileKey, payload)
+}
+
+// parseAgeHeader splits an age file into its stanzas, the header bytes
+// covered by the MAC, the MAC and the payload.
+func parseAgeHeader(data []byte) (stanzas []ageStanza, header, mac, payload []byte, err error) {
+	r := &ageLineReader{data: data}
+	if line, err := r.next(); err != nil || line != ageIntro {
+		return nil, nil, nil, nil, fmt.Errorf("%w: unsupported version", errAgeMalformed)
+	}
+	for {
+		start := r.off
+		line, err := r.next()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, "--- "); ok {
+			mac, err := base64.RawStdEncoding.Strict().DecodeString(rest)
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, nil, fmt.Errorf("%w: invalid header MAC", errAgeMalformed)
+			}
+			// The MAC covers the header up to and including "---".
+			return stanzas, data[:start+len("---")], mac, data[r.off:], nil
+		}
+		args, ok := strings.CutPrefix(line, "-> ")
+		if !ok {
+			return nil, nil, nil, nil, fmt.Errorf("%w: unexpected header line", errAgeMalformed)
+		}
+		st := ageStanza{args: strings.Split(args, " ")}
+		for {
+			line, err := r.next()
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			chunk, err := base64.RawStdEncoding.Strict().DecodeString(line)
+			if err != nil || len(line) > ageStanzaColumn {
+				return nil, nil, nil, nil, fmt.Errorf("%w: invalid stanza body", errAgeMalformed)
+			}
+			st.body = append(st.body, chunk...)
+			// The last body line is shorter than a full one, possibly empty.
+			if len(line) < ageStanzaColumn {
+				break
+			}
+		}
+		stanzas = append(stanzas, st)
+	}
+}
+
+// ageLineReader reads the header lines, keeping the offset of the payload.
+type ageLineReader struct {
+	data []byte
+	off  int
+}
+
+func (r *ageLineReader) next() (string, error) {
+	end := bytes.IndexByte(r.data[r.off:], '\n')
+	if end < 0 {
+		return "", fmt.Errorf("%w: truncated header", errAgeMalformed)
+	}
+	line := string(r.data[r.off : r.off+end])
+	r.off += end + 1
+	return line, nil
+}
+
+// unwrap decrypts the file key of an X25519 stanza.
+func (id ageIdentity) unwrap(st ageStanza) ([]byte, error) {
+	share, err := base64.RawStdEncoding.Strict().DecodeString(st.args[1])
+	if err != nil {
+		return nil, errAgeMalformed
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, errAgeMalformed
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(share, id.key.PublicKey().Bytes()...)
+	wrapKey, err := hkdf.Key(sha256.New, shared, salt, ageX25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), st.body, nil)
+	if err != nil || len(fileKey) != 16 {
+		return nil, errAgeNoIdentity
+	}
+	return fileKey, nil
+}
+
+// decryptAgePayload decrypts the STREAM payload: a 16 byte nonce followed by
+// 64 KiB chunks, each sealed with a counter nonce whose last byte marks the
+// final chunk.
+func decryptAgePayload(fileKey, payload []byte) ([]byte, error) {
+	if len(payload) < 16 {
+		return nil, fmt.Errorf("%w: truncated payload", errAgeMalformed)
+	}
+	key, err := hkdf.Key(sha256.New, fileKey, payload[:16], "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	rest := payload[16:]
+	for counter := uint64(0); ; counter++ {
+		n := min(len(rest), ageChunkSize+aead.Overhead())
+		last := n == len(rest)
+		for i := range 11 {
+			nonce[10-i] = byte(counter >> (8 * i))
+		}
+		nonce[11] = 0
+		if last {
+			nonce[11] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, rest[:n], nil)
+		if err != nil || (last && len(chunk) == 0 && counter > 0) {
+			return nil, fmt.Errorf("%w: payload authentication failed", errAgeMalformed)
+		}
+		out = append(out, chunk...)
+		if last {
+			return out, nil
+		}
+		rest = rest[n:]
+	}
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode decodes a bech32 string (without the 90 character limit, as
+// age keys are longer) into its lowercase HRP and 8-bit data.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	// Regroup the 5-bit values (without the checksum) into bytes.
+	var out []byte
+	acc, bits := 0, 0
+	for _, v := range values[:len(values)-6] {
+		acc = acc<<5 | int(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+			acc &= 1<<bits - 1
+		}
+	}
+	if bits >= 5 || acc != 0 {
+		return "", nil, errors.New("invalid padding")
+	}
+	return hrp, out, nil
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// newAgeKey returns a random age secret key in its AGE-SECRET-KEY-1 form.
+func newAgeKey(t *testing.T) (string, *ecdh.PrivateKey) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return strings.ToUpper(bech32Encode(ageKeyHRP, key.Bytes())), key
+}
+
+// encryptAge seals plaintext to an X25519 recipient as "age --armor" does.
+func encryptAge(t *testing.T, recipient *ecdh.PublicKey, plaintext []byte) string {
+	t.Helper()
+	fileKey := make([]byte, 16)
+	_, _ = rand.Read(fileKey)
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	shared, err := ephemeral.ECDH(recipient)
+	require.NoError(t, err)
+	share := ephemeral.PublicKey().Bytes()
+	wrapKey, err := hkdf.Key(sha256.New, shared, append(bytes.Clone(share), recipient.Bytes()...), ageX25519Label, 32)
+	require.NoError(t, err)
+	aead, err := chacha20poly1305.New(wrapKey)
+	require.NoError(t, err)
+	body := aead.Seal(nil, make([]byte, 12), fileKey, nil)
+
+	b64 := base64.RawStdEncoding
+	header := ageIntro + "\n-> X25519 " + b64.EncodeToString(share) + "\n" + b64.EncodeToString(body) + "\n---"
+	macKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	require.NoError(t, err)
+	h := hmac.New(sha256.New, macKey)
+	h.Write([]byte(header))
+
+	var out bytes.Buffer
+	out.WriteString(header + " " + b64.EncodeToString(h.Sum(nil)) + "\n")
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	out.Write(nonce)
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", 32)
+	require.NoError(t, err)
+	aead, err = chacha20poly1305.New(payloadKey)
+	require.NoError(t, err)
+	for counter := 0; ; counter++ {
+		n := min(len(plaintext), ageChunkSize)
+		chunkNonce := make([]byte, 12)
+		chunkNonce[10] = byte(counter)
+		if n == len(plaintext) {
+			chunkNonce[11] = 1
+		}
+		out.Write(aead.Seal(nil, chunkNonce, plaintext[:n], nil))
+		plaintext = plaintext[n:]
+		if chunkNonce[11] == 1 {
+			break
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(out.Bytes())
+	var armored strings.Builder
+	armored.WriteString(ageArmorBegin + "\n")
+	for len(encoded) > 64 {
+		armored.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	armored.WriteString(encoded + "\n" + ageArmorEnd + "\n")
+	return armored.String()
+}
+
+func bech32Encode(hrp string, data []byte) string {
+	var values []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+		acc &= 1<<bits - 1
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+	mod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := range 6 {
+		values = append(values, byte(mod>>(5*(5-i))&31))
+	}
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String()
+}
+
+func TestParseAgeIdentity(t *testing.T) {
+	encoded, key := newAgeKey(t)
+	id, err := parseAgeIdentity(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, key.Bytes(), id.key.Bytes())
+
+	last := "Q"
+	if strings.HasSuffix(encoded, last) {
+		last = "P"
+	}
+	_, err = parseAgeIdentity(encoded[:len(encoded)-1] + last)
+	assert.ErrorContains(t, err, "checksum")
+	_, err = parseAgeIdentity(strings.ToUpper(bech32Encode("age", key.Bytes())))
+	assert.ErrorContains(t, err, "not a secret key")
+
+	ids, err := parseAgeIdentities("# created: 2026-01-01\n# public key: age1...\n" + encoded + "\n\n")
+	require.NoError(t, err)
+	assert.Len(t, ids, 1)
+}
+
+func TestDecryptAge(t *testing.T) {
+	encoded, key := newAgeKey(t)
+	id, err := parseAgeIdentity(encoded)
+	require.NoError(t, err)
+	_, other := newAgeKey(t)
+
+	for _, size := range []int{0, 42, ageChunkSize, ageChunkSize + 1} {
+		plaintext := bytes.Repeat([]byte("s"), size)
+		got, err := decryptAge(encryptAge(t, key.PublicKey(), plaintext), []ageIdentity{id})
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, len(plaintext), len(got))
+	}
+
+	_, err = decryptAge(encryptAge(t, other.PublicKey(), []byte("secret")), []ageIdentity{id})
+	assert.ErrorIs(t, err, errAgeNoIdentity)
+
+	armored := encryptAge(t, key.PublicKey(), []byte("secret"))
+	data, err := dearmorAge(armored)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	tampered := ageArmorBegin + "\n" + base64.StdEncoding.EncodeToString(data) + "\n" + ageArmorEnd
+	_, err = decryptAge(tampered, []ageIdentity{id})
+	assert.ErrorIs(t, err, errAgeMalformed)
+}
+
+func TestLoadEncryptedValues(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	encoded, key := newAgeKey(t)
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600))
+
+	viper.Reset()
+	viper.Set("nats.issuer_seed", encryptAge(t, key.PublicKey(), []byte("SAEXAMPLE\n")))
+	viper.Set("nats.url", "nats://localhost:4222")
+	viper.Set("encryption.age_key_file", keyFile)
+	keys, err := LoadEncryptedValues()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nats.issuer_seed"}, keys)
+	assert.Equal(t, "SAEXAMPLE", viper.GetString("nats.issuer_seed"), "trailing newline is trimmed")
+	assert.Equal(t, "nats://localhost:4222", viper.GetString("nats.url"))
+
+	viper.Reset()
+	t.Setenv("SOPS_AGE_KEY", encoded)
+	viper.Set("nats.pass", encryptAge(t, key.PublicKey(), []byte("hunter2")))
+	_, err = LoadEncryptedValues()
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", viper.GetString("nats.pass"), "keys can come from SOPS_AGE_KEY")
+
+	viper.Reset()
+	t.Setenv("SOPS_AGE_KEY", "")
+	viper.Set("nats.pass", encryptAge(t, key.PublicKey(), []byte("hunter2")))
+	_, err = LoadEncryptedValues()
+	assert.ErrorContains(t, err, "nats.pass is encrypted: no age key configured")
+
+	viper.Reset()
+	keys, err = LoadEncryptedValues()
+	require.NoError(t, err)
+	assert.Empty(t, keys, "no key is needed without encrypted values")
+}
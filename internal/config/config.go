@@ -0,0 +1,602 @@
+// Package config describes the complete GCS Antal configuration file as
+// typed structs. It is the single source for the JSON Schema of the file and
+// for validating a loaded configuration; feature packages keep reading their
+// settings through viper so that runtime overrides apply.
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// Config is the root of the configuration file.
+type Config struct {
+	Server          Server          `mapstructure:"server" json:"server" desc:"HTTP server for health checks, metrics and the admin API"`
+	Health          Health          `mapstructure:"health" json:"health" desc:"Readiness of the auth callout subscription"`
+	GitLab          GitLab          `mapstructure:"gitlab" json:"gitlab" desc:"GitLab instance used to verify tokens"`
+	Auth            Auth            `mapstructure:"auth" json:"auth" desc:"Authorization policy"`
+	TokenCache      TokenCache      `mapstructure:"token_cache" json:"token_cache" desc:"JetStream KV token cache"`
+	JWTCache        JWTCache        `mapstructure:"jwt_cache" json:"jwt_cache" desc:"In-memory cache of issued user JWTs"`
+	CanI            CanI            `mapstructure:"can_i" json:"can_i" desc:"Permission probes answered for connected clients"`
+	TokenCheck      TokenCheck      `mapstructure:"token_check" json:"token_check" desc:"HTTP endpoint where users check a token without connecting"`
+	ReplicaCheck    ReplicaCheck    `mapstructure:"replica_check" json:"replica_check" desc:"Startup comparison of issuer and xkey between replicas"`
+	ClientTags      ClientTags      `mapstructure:"client_tags" json:"client_tags" desc:"Tags clients may send in their connection names"`
+	TrustedServers  TrustedServers  `mapstructure:"trusted_servers" json:"trusted_servers" desc:"NATS servers whose auth callout requests are answered"`
+	ConfigOverrides ConfigOverrides `mapstructure:"config_overrides" json:"config_overrides" desc:"Fleet-wide config overrides from a JetStream KV bucket"`
+	Migrations      Migrations      `mapstructure:"migrations" json:"migrations" desc:"Schema versions and migrations of the KV buckets"`
+	NATS            NATS            `mapstructure:"nats" json:"nats" desc:"NATS connection, issuer keys and user permissions"`
+	AccessRequests  AccessRequests  `mapstructure:"access_requests" json:"access_requests" desc:"Self-service permission requests via GitLab issues"`
+	Platform        Platform        `mapstructure:"platform" json:"platform" desc:"Platform account for coordination subjects (antal.internal.>)"`
+	Probe           Probe           `mapstructure:"probe" json:"probe" desc:"Canary authentication probe"`
+	DeployTokens    DeployTokens    `mapstructure:"deploy_tokens" json:"deploy_tokens" desc:"GitLab deploy tokens (gldt-) with their own permissions"`
+	CIJobTokens     CIJobTokens     `mapstructure:"ci_job_tokens" json:"ci_job_tokens" desc:"GitLab CI/CD job tokens (glcbt-) with project-derived permissions"`
+	Roles           Roles           `mapstructure:"roles" json:"roles" desc:"Named permission profiles selected per user, group or token scope"`
+	Tenants         Tenants         `mapstructure:"tenants" json:"tenants" desc:"Per GitLab top-level group settings"`
+	Accounts        Accounts        `mapstructure:"accounts" json:"accounts" desc:"NATS accounts selected by GitLab top-level group"`
+	Teams           Teams           `mapstructure:"teams" json:"teams" desc:"Shared team principals selected by GitLab top-level group"`
+	PolicyHook      PolicyHook      `mapstructure:"policy_hook" json:"policy_hook" desc:"WebAssembly policy module adjusting grants before issuance"`
+	Audit           Audit           `mapstructure:"audit" json:"audit" desc:"Audit event export"`
+	Admin           Admin           `mapstructure:"admin" json:"admin" desc:"Admin HTTP API"`
+	AccountBudget   AccountBudget   `mapstructure:"account_budget" json:"account_budget" desc:"Connection budget of the users' account"`
+	LoadShedding    LoadShedding    `mapstructure:"load_shedding" json:"load_shedding" desc:"Shedding of auth requests when the queue is too deep"`
+	Lockout         Lockout         `mapstructure:"lockout" json:"lockout" desc:"Lockout of usernames after repeated invalid tokens"`
+	Standby         Standby         `mapstructure:"standby" json:"standby" desc:"Active/passive mode with a KV lease"`
+	DenyMessages    DenyMessages    `mapstructure:"deny_messages" json:"deny_messages" desc:"Custom messages of denied authentications"`
+	SubjectUsage    SubjectUsage    `mapstructure:"subject_usage" json:"subject_usage" desc:"Comparison of issued subscribe grants with actual subscriptions"`
+	Signer          Signer          `mapstructure:"signer" json:"signer" desc:"Signing of issued JWTs (local seed or external signer)"`
+	IssuerRotation  IssuerRotation  `mapstructure:"issuer_rotation" json:"issuer_rotation" desc:"Emergency issuer key rotation"`
+	KeyUsage        KeyUsage        `mapstructure:"key_usage" json:"key_usage" desc:"Issuer key usage statistics and anomaly alerts"`
+	Soak            Soak            `mapstructure:"soak" json:"soak" desc:"Soak test (antal soak) settings"`
+	Replay          Replay          `mapstructure:"replay" json:"replay" desc:"Audit replay (antal replay) settings"`
+	ConfigReload    ConfigReload    `mapstructure:"config_reload" json:"config_reload" desc:"Reloading of the config file at runtime"`
+	Vault           Vault           `mapstructure:"vault" json:"vault" desc:"Issuer seed, xkey seed and HMAC secret from HashiCorp Vault"`
+	Encryption      Encryption      `mapstructure:"encryption" json:"encryption" desc:"Decryption of age-encrypted config values"`
+	Logging         Logging         `mapstructure:"logging" json:"logging" desc:"Logging"`
+	Sentry          Sentry          `mapstructure:"sentry" json:"sentry" desc:"Sentry error tracking"`
+}
+
+type Server struct {
+	Host         string   `mapstructure:"host" json:"host" desc:"Address to bind to"`
+	Hosts        []string `mapstructure:"hosts" json:"hosts" desc:"Addresses to bind to (IPv4, IPv6, link-local with zone); overrides host"`
+	Port         int      `mapstructure:"port" json:"port" desc:"Port to listen on"`
+	Timeout      int      `mapstructure:"timeout" json:"timeout" desc:"Request timeout in seconds"`
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs" json:"allowed_cidrs" desc:"Source networks allowed to reach the HTTP server; empty allows all"`
+	ReusePort    bool     `mapstructure:"reuse_port" json:"reuse_port" desc:"Bind with SO_REUSEPORT so a new binary can take over the port before the old one exits"`
+}
+
+type Health struct {
+	MaxAuthIdle time.Duration `mapstructure:"max_auth_idle" json:"max_auth_idle" desc:"Report not ready after this long without an auth request; 0 disables"`
+}
+
+type GitLab struct {
+	URL                       string       `mapstructure:"url" json:"url" desc:"GitLab instance URL, without trailing slash"`
+	Timeout                   int          `mapstructure:"timeout" json:"timeout" desc:"Timeout for GitLab API requests in seconds"`
+	Retries                   int          `mapstructure:"retries" json:"retries" desc:"Retries before giving up"`
+	RetryDelaySeconds         int          `mapstructure:"retryDelaySeconds" json:"retryDelaySeconds" desc:"Delay between retries in seconds"`
+	RateLimitPauseSeconds     int          `mapstructure:"rateLimitPauseSeconds" json:"rateLimitPauseSeconds" desc:"Verification pause after a 429 without Retry-After, in seconds"`
+	CircuitBreakerFailures    int          `mapstructure:"circuitBreakerFailures" json:"circuitBreakerFailures" desc:"Consecutive failed verifications that open the circuit breaker; 0 disables it"`
+	CircuitBreakerOpenSeconds int          `mapstructure:"circuitBreakerOpenSeconds" json:"circuitBreakerOpenSeconds" desc:"How long the circuit breaker stays open before probing GitLab, in seconds"`
+	Shadow                    GitLabShadow `mapstructure:"shadow" json:"shadow" desc:"Verification against a second GitLab instance, compared but never enforced"`
+}
+
+type GitLabShadow struct {
+	Enabled       bool          `mapstructure:"enabled" json:"enabled" desc:"Also verify tokens with the shadow instance"`
+	URL           string        `mapstructure:"url" json:"url" desc:"Shadow GitLab instance URL"`
+	Timeout       time.Duration `mapstructure:"timeout" json:"timeout" desc:"Timeout of a shadow verification, retries included"`
+	MaxConcurrent int           `mapstructure:"max_concurrent" json:"max_concurrent" desc:"Shadow verifications in flight; more are skipped"`
+}
+
+type Auth struct {
+	MonitorOnly               bool          `mapstructure:"monitor_only" json:"monitor_only" desc:"Allow every request while still verifying and logging (migration only)"`
+	ScopePolicy               string        `mapstructure:"scope_policy" json:"scope_policy" desc:"Least-privilege scope enforcement" enum:"off,warn,enforce"`
+	ForbiddenScopes           []string      `mapstructure:"forbidden_scopes" json:"forbidden_scopes" desc:"Scopes a token must never carry"`
+	MaxAllowedScopes          []string      `mapstructure:"max_allowed_scopes" json:"max_allowed_scopes" desc:"When set, the only scopes a token may carry"`
+	CalloutTimeout            time.Duration `mapstructure:"callout_timeout" json:"callout_timeout" desc:"Auth callout timeout of the NATS servers"`
+	StaleRequests             string        `mapstructure:"stale_requests" json:"stale_requests" desc:"What to do with requests older than callout_timeout" enum:"process,drop"`
+	ResponseReserve           time.Duration `mapstructure:"response_reserve" json:"response_reserve" desc:"Time kept from a request's remaining budget for cache fallback, signing and responding"`
+	MaxJWTsPerMinute          int           `mapstructure:"max_jwts_per_minute" json:"max_jwts_per_minute" desc:"JWTs issued per user per minute before denying; 0 disables the limit"`
+	MaxAttemptsPerMinute      int           `mapstructure:"max_attempts_per_minute" json:"max_attempts_per_minute" desc:"Auth requests per username per minute before denying, checked before verification; 0 disables the limit"`
+	MaxAttemptsPerIPPerMinute int           `mapstructure:"max_attempts_per_ip_per_minute" json:"max_attempts_per_ip_per_minute" desc:"Auth requests per client IP per minute before denying, checked before verification; 0 disables the limit"`
+	PasswordDetection         string        `mapstructure:"password_detection" json:"password_detection" desc:"Rejected credentials that look like account passwords: ignore, count, or deny with password_sent" enum:"off,warn,strict"`
+	Identity                  string        `mapstructure:"identity" json:"identity" desc:"What {{.Identity}} renders in permission templates" enum:"username,user_id"`
+	EmptyUsername             string        `mapstructure:"empty_username" json:"empty_username" desc:"Requests without a username: use the token owner's, or deny" enum:"derive,deny"`
+	RequireTokenOwner         bool          `mapstructure:"require_token_owner" json:"require_token_owner" desc:"Deny requests whose username is not the token owner's"`
+	UnresolvedTemplates       string        `mapstructure:"unresolved_templates" json:"unresolved_templates" desc:"Permission templates referencing variables that never resolve: refuse or warn" enum:"error,warn"`
+}
+
+type TokenCache struct {
+	Enabled        bool          `mapstructure:"enabled" json:"enabled" desc:"Enable the JetStream KV token cache"`
+	TTL            time.Duration `mapstructure:"ttl" json:"ttl" desc:"Lifetime of cache entries (bucket MaxAge)"`
+	Bucket         string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket name"`
+	Replicas       int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+	HMACSecret     string        `mapstructure:"hmac_secret" json:"hmac_secret" desc:"Secret used to HMAC tokens into KV keys"`
+	HMACSecretFile string        `mapstructure:"hmac_secret_file" json:"hmac_secret_file" desc:"File holding hmac_secret (instead of setting it inline)"`
+	TTLOverrides   []TTLOverride `mapstructure:"ttl_overrides" json:"ttl_overrides" desc:"Per-user or per-group TTLs, capped at ttl"`
+	WriteQueue     WriteQueue    `mapstructure:"write_queue" json:"write_queue" desc:"Background writer for cache entries"`
+	Memory         MemoryCache   `mapstructure:"memory" json:"memory" desc:"In-process LRU of recently verified tokens"`
+	FallbackAlert  FallbackAlert `mapstructure:"fallback_alert" json:"fallback_alert" desc:"Alert when the cache answers a growing share of verifications"`
+	GetTimeout     time.Duration `mapstructure:"get_timeout" json:"get_timeout" desc:"Longest KV read of one cache lookup; 0 leaves only the auth request deadline"`
+	PutTimeout     time.Duration `mapstructure:"put_timeout" json:"put_timeout" desc:"Longest KV write of one cache entry; 0 disables the limit"`
+	HedgeDelay     time.Duration `mapstructure:"hedge_delay" json:"hedge_delay" desc:"Send a second KV read when the first did not answer within this delay; 0 disables hedging"`
+}
+
+type JWTCache struct {
+	Enabled    bool          `mapstructure:"enabled" json:"enabled" desc:"Reuse issued JWTs for reconnects"`
+	TTL        time.Duration `mapstructure:"ttl" json:"ttl" desc:"How long an issued JWT is reused"`
+	MaxEntries int           `mapstructure:"max_entries" json:"max_entries" desc:"Maximum cached JWTs"`
+}
+
+type CanI struct {
+	Enabled  bool   `mapstructure:"enabled" json:"enabled" desc:"Answer permission probes of connected clients"`
+	Subject  string `mapstructure:"subject" json:"subject" desc:"Probe request subject (import it with share: true)"`
+	MaxUsers int    `mapstructure:"max_users" json:"max_users" desc:"Maximum users whose issued grants are remembered"`
+}
+
+type TokenCheck struct {
+	Enabled                 bool `mapstructure:"enabled" json:"enabled" desc:"Serve POST /token/check"`
+	MaxChecksPerIPPerMinute int  `mapstructure:"max_checks_per_ip_per_minute" json:"max_checks_per_ip_per_minute" desc:"Token checks accepted per client IP within a sliding minute (0 = unlimited)"`
+}
+
+type ReplicaCheck struct {
+	Enabled bool          `mapstructure:"enabled" json:"enabled" desc:"Announce keys on startup and alert on replicas using other keys"`
+	Cluster string        `mapstructure:"cluster" json:"cluster" desc:"Fleet name; only replicas of the same cluster are compared"`
+	Wait    time.Duration `mapstructure:"wait" json:"wait" desc:"How long a starting replica collects the answers of the others"`
+}
+
+type ClientTags struct {
+	Allowed []ClientTagRule `mapstructure:"allowed" json:"allowed" desc:"Trusted tags; others are ignored"`
+}
+
+type ClientTagRule struct {
+	Name   string   `mapstructure:"name" json:"name" desc:"Tag name"`
+	Values []string `mapstructure:"values" json:"values" desc:"Accepted values; empty accepts any single subject token"`
+}
+
+type TrustedServers struct {
+	Enabled  bool             `mapstructure:"enabled" json:"enabled" desc:"Answer only requests of the listed servers"`
+	Clusters []TrustedCluster `mapstructure:"clusters" json:"clusters" desc:"Trusted servers, per NATS cluster"`
+}
+
+type TrustedCluster struct {
+	Name      string   `mapstructure:"name" json:"name" desc:"Cluster name reported by the servers (empty for servers outside a cluster)"`
+	ServerIDs []string `mapstructure:"server_ids" json:"server_ids" desc:"Server IDs (public server nkeys, N...)"`
+}
+
+type TTLOverride struct {
+	Users  []string      `mapstructure:"users" json:"users" desc:"GitLab usernames"`
+	Groups []string      `mapstructure:"groups" json:"groups" desc:"GitLab top-level group paths"`
+	TTL    time.Duration `mapstructure:"ttl" json:"ttl" desc:"Cache entry lifetime"`
+}
+
+type WriteQueue struct {
+	Size      int `mapstructure:"size" json:"size" desc:"Maximum queued writes (0 writes synchronously)"`
+	BatchSize int `mapstructure:"batch_size" json:"batch_size" desc:"Maximum writes flushed per batch"`
+}
+
+type MemoryCache struct {
+	Size int           `mapstructure:"size" json:"size" desc:"Maximum remembered tokens (0 disables the layer)"`
+	TTL  time.Duration `mapstructure:"ttl" json:"ttl" desc:"How long a GitLab verification is trusted without asking again"`
+}
+
+type FallbackAlert struct {
+	Threshold        float64       `mapstructure:"threshold" json:"threshold" desc:"Share of verifications answered from the cache above which GitLab is reported degraded (0 disables)"`
+	Window           time.Duration `mapstructure:"window" json:"window" desc:"Span the share is computed over"`
+	MinVerifications int           `mapstructure:"min_verifications" json:"min_verifications" desc:"Verifications needed in the window before the share is judged"`
+}
+
+type ConfigOverrides struct {
+	Enabled  bool   `mapstructure:"enabled" json:"enabled" desc:"Watch the overrides bucket"`
+	Bucket   string `mapstructure:"bucket" json:"bucket" desc:"KV bucket name"`
+	Replicas int    `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+}
+
+type Migrations struct {
+	Enabled  bool          `mapstructure:"enabled" json:"enabled" desc:"Version the KV buckets and migrate them on startup"`
+	Bucket   string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket holding schema versions and migration locks"`
+	Replicas int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+	LockTTL  time.Duration `mapstructure:"lock_ttl" json:"lock_ttl" desc:"How long a replica may hold a migration lock before it can be taken over"`
+	Wait     time.Duration `mapstructure:"wait" json:"wait" desc:"How long a starting replica waits for another one to finish migrating"`
+}
+
+type NATS struct {
+	URL            string        `mapstructure:"url" json:"url" desc:"NATS server URL"`
+	User           string        `mapstructure:"user" json:"user" desc:"User of the auth callout connection"`
+	Pass           string        `mapstructure:"pass" json:"pass" desc:"Password of the auth callout connection"`
+	PassFile       string        `mapstructure:"pass_file" json:"pass_file" desc:"File holding pass (instead of setting it inline)"`
+	CredsFile      string        `mapstructure:"creds_file" json:"creds_file" desc:"Creds file (user JWT and nkey seed) of the auth callout connection, instead of user/pass"`
+	NkeySeed       string        `mapstructure:"nkey_seed" json:"nkey_seed" desc:"User nkey seed of the auth callout connection, instead of user/pass"`
+	NkeySeedFile   string        `mapstructure:"nkey_seed_file" json:"nkey_seed_file" desc:"File holding nkey_seed (instead of setting it inline)"`
+	TLS            NATSTLS       `mapstructure:"tls" json:"tls" desc:"TLS of the connections to NATS"`
+	Audience       string        `mapstructure:"audience" json:"audience" desc:"Audience (account) of issued user JWTs"`
+	IssuerSeed     string        `mapstructure:"issuer_seed" json:"issuer_seed" desc:"Seed of the key signing user JWTs"`
+	IssuerSeedFile string        `mapstructure:"issuer_seed_file" json:"issuer_seed_file" desc:"File holding issuer_seed (instead of setting it inline)"`
+	XKeySeed       string        `mapstructure:"xkey_seed" json:"xkey_seed" desc:"XKey seed for encrypted callouts (optional)"`
+	XKeySeedFile   string        `mapstructure:"xkey_seed_file" json:"xkey_seed_file" desc:"File holding xkey_seed (instead of setting it inline)"`
+	DrainTimeout   time.Duration `mapstructure:"drain_timeout" json:"drain_timeout" desc:"How long shutdown waits for delivered auth requests to be answered"`
+	KVConnection   string        `mapstructure:"kv_connection" json:"kv_connection" desc:"Connection for JetStream KV traffic: shared (the auth connection) or dedicated"`
+	KVRetry        KVRetry       `mapstructure:"kv_retry" json:"kv_retry" desc:"Retries of KV operations no JetStream server answered"`
+	ServerCompat   string        `mapstructure:"server_compat" json:"server_compat" desc:"Oldest nats-server release line of the cluster" enum:"2.10,2.11"`
+	Account        Account       `mapstructure:"account" json:"account" desc:"Subjects valid in the users' account"`
+	Limits         RoleLimits    `mapstructure:"limits" json:"limits" desc:"Connection limits of every user; 0 keeps unlimited, roles override"`
+	Permissions    Permissions   `mapstructure:"permissions" json:"permissions" desc:"Permissions of every authenticated user"`
+}
+
+type NATSTLS struct {
+	CAFile   string `mapstructure:"ca_file" json:"ca_file" desc:"CA bundle for the servers' certificates (system roots when empty)"`
+	CertFile string `mapstructure:"cert_file" json:"cert_file" desc:"Client certificate presented to the servers"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file" desc:"Key of the client certificate"`
+	Insecure bool   `mapstructure:"insecure" json:"insecure" desc:"Skip certificate verification (test clusters only)"`
+}
+
+type KVRetry struct {
+	Attempts int           `mapstructure:"attempts" json:"attempts" desc:"Tries of a KV operation in total; 1 disables retries"`
+	Backoff  time.Duration `mapstructure:"backoff" json:"backoff" desc:"Wait before the first retry, doubled for every further one"`
+}
+
+type Account struct {
+	Exports []string `mapstructure:"exports" json:"exports" desc:"Subjects exported from the account"`
+	Imports []string `mapstructure:"imports" json:"imports" desc:"Subjects imported into the account"`
+}
+
+type Permissions struct {
+	ReservedPrefixes []string        `mapstructure:"reserved_prefixes" json:"reserved_prefixes" desc:"Subject namespaces never granted to users"`
+	Publish          PermissionRules `mapstructure:"publish" json:"publish" desc:"Publish permissions"`
+	Subscribe        PermissionRules `mapstructure:"subscribe" json:"subscribe" desc:"Subscribe permissions"`
+}
+
+type PermissionRules struct {
+	Allow []string `mapstructure:"allow" json:"allow" desc:"Allowed subject templates"`
+	Deny  []string `mapstructure:"deny" json:"deny" desc:"Denied subject templates"`
+}
+
+type AccessRequests struct {
+	Enabled       bool          `mapstructure:"enabled" json:"enabled" desc:"Turn approved access request issues into user grants"`
+	Project       string        `mapstructure:"project" json:"project" desc:"GitLab project (ID or full path) holding the requests"`
+	Token         string        `mapstructure:"token" json:"token" desc:"PAT able to read the project's issues"`
+	Label         string        `mapstructure:"label" json:"label" desc:"Label marking an issue as an access request"`
+	ApprovedLabel string        `mapstructure:"approved_label" json:"approved_label" desc:"Label added by maintainers to approve a request"`
+	Interval      time.Duration `mapstructure:"interval" json:"interval" desc:"Time between syncs"`
+	Bucket        string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket holding the user grants"`
+	Replicas      int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+}
+
+type Platform struct {
+	Enabled bool   `mapstructure:"enabled" json:"enabled" desc:"Connect with separate platform credentials"`
+	URL     string `mapstructure:"url" json:"url" desc:"NATS server URL (defaults to nats.url)"`
+	User    string `mapstructure:"user" json:"user" desc:"Platform user"`
+	Pass    string `mapstructure:"pass" json:"pass" desc:"Platform user password"`
+	Creds   string `mapstructure:"creds" json:"creds" desc:"Creds file, instead of user and pass"`
+}
+
+type Probe struct {
+	Enabled  bool          `mapstructure:"enabled" json:"enabled" desc:"Periodically authenticate as the probe user"`
+	URL      string        `mapstructure:"url" json:"url" desc:"NATS server URL (defaults to nats.url)"`
+	Username string        `mapstructure:"username" json:"username" desc:"GitLab username of the probe user"`
+	Token    string        `mapstructure:"token" json:"token" desc:"Personal access token of the probe user"`
+	Interval time.Duration `mapstructure:"interval" json:"interval" desc:"Time between probes"`
+	Timeout  time.Duration `mapstructure:"timeout" json:"timeout" desc:"Timeout of one probe"`
+}
+
+type DeployTokens struct {
+	Enabled        bool              `mapstructure:"enabled" json:"enabled" desc:"Accept GitLab deploy tokens"`
+	AdminToken     string            `mapstructure:"admin_token" json:"admin_token" desc:"Administrator PAT used to look up deploy tokens"`
+	AdminTokenFile string            `mapstructure:"admin_token_file" json:"admin_token_file" desc:"File holding admin_token (instead of setting it inline)"`
+	Permissions    TenantPermissions `mapstructure:"permissions" json:"permissions" desc:"Permissions of deploy tokens, replacing every other block"`
+}
+
+type CIJobTokens struct {
+	Enabled     bool              `mapstructure:"enabled" json:"enabled" desc:"Accept GitLab CI/CD job tokens"`
+	Permissions TenantPermissions `mapstructure:"permissions" json:"permissions" desc:"Permissions of job tokens, replacing every other block"`
+}
+
+type Roles struct {
+	Profiles map[string]Role   `mapstructure:"profiles" json:"profiles" desc:"Permission profiles keyed by role name"`
+	Users    map[string]string `mapstructure:"users" json:"users" desc:"Role per GitLab username"`
+	Groups   map[string]string `mapstructure:"groups" json:"groups" desc:"Role per GitLab top-level group"`
+	Scopes   map[string]string `mapstructure:"scopes" json:"scopes" desc:"Role per token scope"`
+	Default  string            `mapstructure:"default" json:"default" desc:"Role of users no mapping matches; empty keeps nats.permissions"`
+}
+
+type Role struct {
+	Permissions TenantPermissions `mapstructure:"permissions" json:"permissions" desc:"Permissions replacing nats.permissions"`
+	Limits      RoleLimits        `mapstructure:"limits" json:"limits" desc:"Connection limits; 0 keeps unlimited"`
+}
+
+type RoleLimits struct {
+	Subs    int64 `mapstructure:"subs" json:"subs" desc:"Maximum subscriptions"`
+	Data    int64 `mapstructure:"data" json:"data" desc:"Maximum bytes"`
+	Payload int64 `mapstructure:"payload" json:"payload" desc:"Maximum message payload in bytes"`
+}
+
+type Tenants struct {
+	Defaults Tenant            `mapstructure:"defaults" json:"defaults" desc:"Settings inherited by every tenant"`
+	Groups   map[string]Tenant `mapstructure:"groups" json:"groups" desc:"Tenants keyed by GitLab top-level group path"`
+}
+
+type Accounts struct {
+	Groups []AccountMapping `mapstructure:"groups" json:"groups" desc:"Accounts of GitLab top-level groups; the first (sorted) mapped group of a user wins"`
+}
+
+type Teams struct {
+	Groups []TeamMapping `mapstructure:"groups" json:"groups" desc:"Team principals of GitLab top-level groups; the first (sorted) mapped group of a user wins"`
+}
+
+type PolicyHook struct {
+	Module  string        `mapstructure:"module" json:"module" desc:"Path of the .wasm policy module; empty disables the hook"`
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout" desc:"Maximum time of one evaluation"`
+	OnError string        `mapstructure:"on_error" json:"on_error" desc:"When the module fails: deny the connection or skip the adjustment" enum:"deny,skip"`
+}
+
+type TeamMapping struct {
+	Group     string `mapstructure:"group" json:"group" desc:"GitLab top-level group path"`
+	Principal string `mapstructure:"principal" json:"principal" desc:"Name the members are issued as, e.g. team-payments"`
+}
+
+type AccountMapping struct {
+	Group          string `mapstructure:"group" json:"group" desc:"GitLab top-level group path"`
+	Account        string `mapstructure:"account" json:"account" desc:"Account name, or the account public key in operator mode"`
+	SigningKeySeed string `mapstructure:"signing_key_seed" json:"signing_key_seed" desc:"Signing key of the account (operator mode); empty signs with the issuer"`
+}
+
+type Tenant struct {
+	Permissions TenantPermissions `mapstructure:"permissions" json:"permissions" desc:"Permissions added for tenant members"`
+	TokenCache  TenantTokenCache  `mapstructure:"token_cache" json:"token_cache" desc:"Separate token cache bucket for tenant members"`
+}
+
+type TenantTokenCache struct {
+	Bucket   string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket of the tenant; empty uses token_cache.bucket (ignored in defaults)"`
+	TTL      time.Duration `mapstructure:"ttl" json:"ttl" desc:"Lifetime of the tenant's cache entries; defaults to token_cache.ttl"`
+	Replicas int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas; defaults to token_cache.replicas"`
+	MaxBytes int64         `mapstructure:"max_bytes" json:"max_bytes" desc:"Size limit of the tenant's bucket; 0 is unlimited"`
+}
+
+type TenantPermissions struct {
+	Publish   PermissionRules `mapstructure:"publish" json:"publish" desc:"Publish permissions"`
+	Subscribe PermissionRules `mapstructure:"subscribe" json:"subscribe" desc:"Subscribe permissions"`
+}
+
+type Audit struct {
+	Kafka  AuditKafka  `mapstructure:"kafka" json:"kafka" desc:"Kafka producer for audit events"`
+	File   AuditFile   `mapstructure:"file" json:"file" desc:"JSON Lines file of audit events"`
+	NATS   AuditNATS   `mapstructure:"nats" json:"nats" desc:"NATS subject receiving audit events"`
+	SQLite AuditSQLite `mapstructure:"sqlite" json:"sqlite" desc:"Embedded SQLite store of audit events and usage stats"`
+}
+
+type AuditSQLite struct {
+	Enabled        bool          `mapstructure:"enabled" json:"enabled" desc:"Store audit events and usage stats in a local SQLite database"`
+	Path           string        `mapstructure:"path" json:"path" desc:"Database file, created when missing"`
+	Decisions      bool          `mapstructure:"decisions" json:"decisions" desc:"Also store every authorization decision (usage stats are counted either way)"`
+	Retention      time.Duration `mapstructure:"retention" json:"retention" desc:"How long audit events are kept (0 = forever)"`
+	StatsRetention time.Duration `mapstructure:"stats_retention" json:"stats_retention" desc:"How long daily usage stats are kept (0 = forever)"`
+}
+
+type AuditFile struct {
+	Enabled   bool   `mapstructure:"enabled" json:"enabled" desc:"Append audit events to a file"`
+	Path      string `mapstructure:"path" json:"path" desc:"File the events are appended to, one JSON object per line"`
+	Decisions bool   `mapstructure:"decisions" json:"decisions" desc:"Also write every authorization decision"`
+}
+
+type AuditNATS struct {
+	Enabled   bool   `mapstructure:"enabled" json:"enabled" desc:"Publish audit events to a NATS subject"`
+	Subject   string `mapstructure:"subject" json:"subject" desc:"Subject the events are published to"`
+	Decisions bool   `mapstructure:"decisions" json:"decisions" desc:"Also publish every authorization decision"`
+}
+
+type AuditKafka struct {
+	Enabled   bool      `mapstructure:"enabled" json:"enabled" desc:"Export audit events to Kafka"`
+	Brokers   []string  `mapstructure:"brokers" json:"brokers" desc:"Bootstrap brokers (host:port)"`
+	Topic     string    `mapstructure:"topic" json:"topic" desc:"Topic receiving the events"`
+	Decisions bool      `mapstructure:"decisions" json:"decisions" desc:"Also export every authorization decision"`
+	SASL      KafkaSASL `mapstructure:"sasl" json:"sasl" desc:"SASL authentication"`
+	TLS       KafkaTLS  `mapstructure:"tls" json:"tls" desc:"TLS settings"`
+}
+
+type KafkaSASL struct {
+	Mechanism string `mapstructure:"mechanism" json:"mechanism" desc:"SASL mechanism; empty disables SASL" enum:",plain,scram-sha-256,scram-sha-512"`
+	Username  string `mapstructure:"username" json:"username" desc:"SASL username"`
+	Password  string `mapstructure:"password" json:"password" desc:"SASL password"`
+}
+
+type KafkaTLS struct {
+	Enabled            bool   `mapstructure:"enabled" json:"enabled" desc:"Connect with TLS"`
+	CAFile             string `mapstructure:"ca_file" json:"ca_file" desc:"CA bundle for the broker certificates (system roots when empty)"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify" desc:"Skip certificate verification (test clusters only)"`
+}
+
+type Admin struct {
+	Token string `mapstructure:"token" json:"token" desc:"Bearer token for /admin endpoints; empty disables the admin API"`
+}
+
+type AccountBudget struct {
+	Mode           string        `mapstructure:"mode" json:"mode" desc:"off, warn (log and count) or enforce (deny with account_at_capacity)" enum:"off,warn,enforce"`
+	Account        string        `mapstructure:"account" json:"account" desc:"Account whose connections are counted; defaults to nats.audience"`
+	MaxConnections int           `mapstructure:"max_connections" json:"max_connections" desc:"Connection budget of the account"`
+	Refresh        time.Duration `mapstructure:"refresh" json:"refresh" desc:"How often the connection count is fetched"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout" json:"request_timeout" desc:"Timeout for NATS system requests"`
+}
+
+type DenyMessages struct {
+	HelpURL   string            `mapstructure:"help_url" json:"help_url" desc:"URL templates can point users to ({{.HelpURL}})"`
+	Templates map[string]string `mapstructure:"templates" json:"templates" desc:"Go templates of the message per deny code; {{.Message}} is the built-in text"`
+}
+
+type LoadShedding struct {
+	Enabled    bool          `mapstructure:"enabled" json:"enabled" desc:"Deny some requests with retry_later while the queue is too deep"`
+	QueueDepth int           `mapstructure:"queue_depth" json:"queue_depth" desc:"Pending auth requests from which new requests are shed"`
+	Percent    int           `mapstructure:"percent" json:"percent" desc:"Percentage of requests shed while the queue is too deep"`
+	RetryAfter time.Duration `mapstructure:"retry_after" json:"retry_after" desc:"Delay suggested to shed clients"`
+}
+
+type Lockout struct {
+	Enabled     bool          `mapstructure:"enabled" json:"enabled" desc:"Lock usernames out after repeated invalid tokens"`
+	MaxFailures int           `mapstructure:"max_failures" json:"max_failures" desc:"Consecutive invalid tokens within window that lock a username out"`
+	Window      time.Duration `mapstructure:"window" json:"window" desc:"Period in which the failures are counted"`
+	Duration    time.Duration `mapstructure:"duration" json:"duration" desc:"How long a username stays locked out"`
+	Bucket      string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket holding the failure counts"`
+	Replicas    int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+}
+
+type Standby struct {
+	Enabled   bool          `mapstructure:"enabled" json:"enabled" desc:"Answer auth requests only while holding the active lease"`
+	Bucket    string        `mapstructure:"bucket" json:"bucket" desc:"JetStream KV bucket holding the lease"`
+	Replicas  int           `mapstructure:"replicas" json:"replicas" desc:"Replicas of the lease bucket"`
+	Heartbeat time.Duration `mapstructure:"heartbeat" json:"heartbeat" desc:"How often the lease is renewed and checked"`
+	Timeout   time.Duration `mapstructure:"timeout" json:"timeout" desc:"How long a lease may go unrenewed before a standby takes over"`
+}
+
+type SubjectUsage struct {
+	Enabled        bool          `mapstructure:"enabled" json:"enabled" desc:"Sample subscriptions via the system account"`
+	Account        string        `mapstructure:"account" json:"account" desc:"Users' account whose connections are sampled"`
+	Interval       time.Duration `mapstructure:"interval" json:"interval" desc:"How often subscriptions are sampled"`
+	ReportInterval time.Duration `mapstructure:"report_interval" json:"report_interval" desc:"How often tightening candidates are logged"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout" json:"request_timeout" desc:"Timeout for NATS system requests"`
+}
+
+type Signer struct {
+	Type      string        `mapstructure:"type" json:"type" desc:"local signs with nats.issuer_seed; remote delegates to an external signer" enum:"local,remote"`
+	URL       string        `mapstructure:"url" json:"url" desc:"Base URL of the external signer"`
+	PublicKey string        `mapstructure:"public_key" json:"public_key" desc:"Issuer public key held by the external signer"`
+	AuthToken string        `mapstructure:"auth_token" json:"auth_token" desc:"Bearer token sent to the external signer"`
+	CAFile    string        `mapstructure:"ca_file" json:"ca_file" desc:"CA bundle for the external signer's TLS certificate"`
+	Timeout   time.Duration `mapstructure:"timeout" json:"timeout" desc:"Timeout of a signing request"`
+}
+
+type IssuerRotation struct {
+	StandbySeed    string        `mapstructure:"standby_seed" json:"standby_seed" desc:"Standby issuer seed switched to on rotation"`
+	OperatorSeed   string        `mapstructure:"operator_seed" json:"operator_seed" desc:"Operator seed for re-signing the account JWT (operator mode)"`
+	Account        string        `mapstructure:"account" json:"account" desc:"Public key of the users' account (operator mode)"`
+	KickAccount    string        `mapstructure:"kick_account" json:"kick_account" desc:"Account whose clients are disconnected on rotation"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout" json:"request_timeout" desc:"Timeout for NATS system requests"`
+}
+
+type KeyUsage struct {
+	Enabled              bool          `mapstructure:"enabled" json:"enabled" desc:"Alert on unusual issuer key usage"`
+	SpikeFactor          float64       `mapstructure:"spike_factor" json:"spike_factor" desc:"Minutes with more than this times the baseline are spikes"`
+	SpikeMinPerMinute    int           `mapstructure:"spike_min_per_minute" json:"spike_min_per_minute" desc:"JWTs a minute needs at least to count as a spike"`
+	BaselineMinutes      int           `mapstructure:"baseline_minutes" json:"baseline_minutes" desc:"Span of the moving average used as baseline"`
+	BusinessHours        string        `mapstructure:"business_hours" json:"business_hours" desc:"Business hours as HH:MM-HH:MM; empty disables the off-hours check"`
+	BusinessDays         []string      `mapstructure:"business_days" json:"business_days" desc:"Business days (mon, tue, ...)"`
+	Timezone             string        `mapstructure:"timezone" json:"timezone" desc:"Time zone of the business hours"`
+	OffHoursMaxPerMinute int           `mapstructure:"off_hours_max_per_minute" json:"off_hours_max_per_minute" desc:"JWTs per minute allowed outside business hours before alerting; 0 disables the check"`
+	AlertInterval        time.Duration `mapstructure:"alert_interval" json:"alert_interval" desc:"Minimum time between alerts of the same kind"`
+}
+
+type Soak struct {
+	Duration        time.Duration `mapstructure:"duration" json:"duration" desc:"How long to run"`
+	ReportInterval  time.Duration `mapstructure:"report_interval" json:"report_interval" desc:"How often to report statistics"`
+	ConnectRate     int           `mapstructure:"connect_rate" json:"connect_rate" desc:"Connections per second"`
+	Users           int           `mapstructure:"users" json:"users" desc:"Distinct synthetic users"`
+	MaxLatencyDrift float64       `mapstructure:"max_latency_drift" json:"max_latency_drift" desc:"Tolerated relative p99 latency increase"`
+	MaxHeapGrowth   float64       `mapstructure:"max_heap_growth" json:"max_heap_growth" desc:"Tolerated relative heap increase"`
+	Profile         []SoakPhase   `mapstructure:"profile" json:"profile" desc:"Fake GitLab behaviour phases, repeated in order"`
+}
+
+type Replay struct {
+	Concurrency int `mapstructure:"concurrency" json:"concurrency" desc:"Replayed connections in flight"`
+}
+
+type SoakPhase struct {
+	Name          string        `mapstructure:"name" json:"name" desc:"Phase name"`
+	Duration      time.Duration `mapstructure:"duration" json:"duration" desc:"Phase length"`
+	Latency       time.Duration `mapstructure:"latency" json:"latency" desc:"Added response latency"`
+	Jitter        time.Duration `mapstructure:"jitter" json:"jitter" desc:"Random extra latency"`
+	ErrorRate     float64       `mapstructure:"error_rate" json:"error_rate" desc:"Fraction of 500 responses"`
+	RateLimitRate float64       `mapstructure:"rate_limit_rate" json:"rate_limit_rate" desc:"Fraction of 429 responses"`
+	InvalidRate   float64       `mapstructure:"invalid_rate" json:"invalid_rate" desc:"Fraction of 401 responses"`
+}
+
+type ConfigReload struct {
+	Watch     bool            `mapstructure:"watch" json:"watch" desc:"Reload the config file whenever it changes, in addition to on SIGHUP"`
+	Changelog ConfigChangelog `mapstructure:"changelog" json:"changelog" desc:"Record of applied reloads in a KV bucket"`
+	Drift     ConfigDrift     `mapstructure:"drift" json:"drift" desc:"Detection of replicas running a stale config"`
+}
+
+type ConfigDrift struct {
+	Enabled     bool          `mapstructure:"enabled" json:"enabled" desc:"Publish the config hash and compare it with the other replicas"`
+	Bucket      string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket holding the hash of every live replica"`
+	Replicas    int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+	Interval    time.Duration `mapstructure:"interval" json:"interval" desc:"How often the hash is published and compared"`
+	GracePeriod time.Duration `mapstructure:"grace_period" json:"grace_period" desc:"How long replicas may differ before it is reported"`
+}
+
+type ConfigChangelog struct {
+	Enabled  bool          `mapstructure:"enabled" json:"enabled" desc:"Record applied config reloads"`
+	Bucket   string        `mapstructure:"bucket" json:"bucket" desc:"KV bucket holding the changes"`
+	Replicas int           `mapstructure:"replicas" json:"replicas" desc:"KV bucket replicas"`
+	MaxAge   time.Duration `mapstructure:"max_age" json:"max_age" desc:"How long changes are kept; 0 keeps them forever"`
+}
+
+type Vault struct {
+	Enabled      bool          `mapstructure:"enabled" json:"enabled" desc:"Fetch secrets from Vault on startup"`
+	Address      string        `mapstructure:"address" json:"address" desc:"Vault address (defaults to VAULT_ADDR)"`
+	Namespace    string        `mapstructure:"namespace" json:"namespace" desc:"Vault Enterprise namespace (optional)"`
+	CAFile       string        `mapstructure:"ca_file" json:"ca_file" desc:"CA bundle for Vault's TLS certificate"`
+	Timeout      time.Duration `mapstructure:"timeout" json:"timeout" desc:"Timeout of login and read"`
+	Auth         string        `mapstructure:"auth" json:"auth" desc:"Auth method" enum:"token,approle"`
+	Token        string        `mapstructure:"token" json:"token" desc:"Vault token (defaults to VAULT_TOKEN)"`
+	AppRoleMount string        `mapstructure:"approle_mount" json:"approle_mount" desc:"Mount path of the AppRole auth method"`
+	RoleID       string        `mapstructure:"role_id" json:"role_id" desc:"AppRole role ID"`
+	SecretID     string        `mapstructure:"secret_id" json:"secret_id" desc:"AppRole secret ID (defaults to VAULT_SECRET_ID)"`
+	Mount        string        `mapstructure:"mount" json:"mount" desc:"Mount path of the KV v2 secrets engine"`
+	Path         string        `mapstructure:"path" json:"path" desc:"Path of the secret holding issuer_seed, xkey_seed and hmac_secret"`
+}
+
+type Encryption struct {
+	AgeKeyFile string `mapstructure:"age_key_file" json:"age_key_file" desc:"File with age secret keys (defaults to SOPS_AGE_KEY_FILE)"`
+}
+
+type Logging struct {
+	Level  string `mapstructure:"level" json:"level" desc:"Log level" enum:"debug,info,warn,error"`
+	Format string `mapstructure:"format" json:"format" desc:"Log format; restart to change" enum:"text,json"`
+	// ClaimsUsers are logged with the full claims of every JWT issued to them.
+	ClaimsUsers []string `mapstructure:"claims_users" json:"claims_users" desc:"Usernames whose issued user claims are logged in full"`
+}
+
+type Sentry struct {
+	DSN                  string        `mapstructure:"dsn" json:"dsn" desc:"Sentry DSN; empty disables Sentry"`
+	Environment          string        `mapstructure:"environment" json:"environment" desc:"Sentry environment"`
+	SampleRate           float64       `mapstructure:"sample_rate" json:"sample_rate" desc:"Fraction of transactions sent"`
+	EnableTracing        bool          `mapstructure:"enable_tracing" json:"enable_tracing" desc:"Enable performance tracing"`
+	Debug                bool          `mapstructure:"debug" json:"debug" desc:"Sentry SDK debug output"`
+	BreadcrumbsPerSecond int           `mapstructure:"breadcrumbs_per_second" json:"breadcrumbs_per_second" desc:"Breadcrumbs kept per category and second (0 keeps all)"`
+	TraceOverrideMax     time.Duration `mapstructure:"trace_override_max" json:"trace_override_max" desc:"Longest full tracing a trace.<replica> config override may ask for"`
+}
+
+// flagKeys are command line flags bound to viper that are not part of the file.
+var flagKeys = []string{"config", "version", "creds", "from", "tokens", "rate", "json"}
+
+// Load decodes the current viper configuration into a Config. Values of the
+// wrong type are an error; unknown keys (usually typos) are returned so the
+// caller can report them.
+func Load() (Config, []string, error) {
+	settings := viper.AllSettings()
+	for _, k := range flagKeys {
+		delete(settings, k)
+	}
+
+	var (
+		cfg  Config
+		meta mapstructure.Metadata
+	)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Metadata:         &meta,
+		Result:           &cfg,
+	})
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if err := decoder.Decode(settings); err != nil {
+		return Config{}, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	slices.Sort(meta.Unused)
+	return cfg, meta.Unused, nil
+}
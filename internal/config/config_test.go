@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadSchema(t *testing.T) map[string]any {
+	t.Helper()
+	raw, err := Schema()
+	require.NoError(t, err)
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(raw, &schema))
+	return schema
+}
+
+// lookup walks the schema properties along a dotted viper key.
+func lookup(schema map[string]any, key string) (map[string]any, bool) {
+	node := schema
+	for _, part := range strings.Split(key, ".") {
+		props, ok := node["properties"].(map[string]any)
+		if !ok {
+			// Maps (e.g. tenants.groups) accept any key.
+			if _, isMap := node["additionalProperties"].(map[string]any); isMap {
+				node = node["additionalProperties"].(map[string]any)
+				continue
+			}
+			return nil, false
+		}
+		var found bool
+		for name, p := range props {
+			// viper lowercases keys
+			if strings.EqualFold(name, part) {
+				node, found = p.(map[string]any), true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func TestSchema(t *testing.T) {
+	schema := loadSchema(t)
+
+	assert.Equal(t, schemaID, schema["$schema"])
+	assert.Equal(t, false, schema["additionalProperties"])
+
+	level, ok := lookup(schema, "logging.level")
+	require.True(t, ok)
+	assert.Equal(t, []any{"debug", "info", "warn", "error"}, level["enum"])
+
+	ttl, ok := lookup(schema, "token_cache.ttl")
+	require.True(t, ok)
+	assert.Equal(t, "string", ttl["type"])
+	assert.Equal(t, durationPattern, ttl["pattern"])
+
+	groups, ok := lookup(schema, "tenants.groups")
+	require.True(t, ok)
+	assert.Equal(t, "object", groups["type"])
+	assert.IsType(t, map[string]any{}, groups["additionalProperties"])
+}
+
+func TestSchema_CoversExampleConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigFile("../../config.yaml.example")
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadInConfig())
+
+	schema := loadSchema(t)
+	for _, key := range v.AllKeys() {
+		_, ok := lookup(schema, key)
+		assert.True(t, ok, "key %q from config.yaml.example is missing in the schema", key)
+	}
+}
+
+func TestLoad_ExampleConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.SetConfigFile("../../config.yaml.example")
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadInConfig())
+
+	_, unknown, err := Load()
+	require.NoError(t, err)
+	assert.Empty(t, unknown)
+}
+
+func TestLoad(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("config", "/etc/antal/config.yaml")
+	viper.Set("token_cache.ttl", "2h")
+	viper.Set("auth.forbidden_scopes", []string{"api"})
+	viper.Set("tenants.groups.acme.permissions.publish.allow", []string{"acme.>"})
+	viper.Set("server.prot", 8080)
+
+	cfg, unknown, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, cfg.TokenCache.TTL)
+	assert.Equal(t, []string{"api"}, cfg.Auth.ForbiddenScopes)
+	assert.Equal(t, []string{"acme.>"}, cfg.Tenants.Groups["acme"].Permissions.Publish.Allow)
+	assert.Equal(t, []string{"server.prot"}, unknown)
+}
+
+func TestLoad_WrongType(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("server.port", "eighty")
+
+	_, _, err := Load()
+	assert.Error(t, err)
+}
+
+func TestSchemaHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	SchemaHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info/schema", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/schema+json", rec.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(rec.Body.Bytes()))
+
+	rec = httptest.NewRecorder()
+	SchemaHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/info/schema", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
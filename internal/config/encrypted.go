@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EncryptionConfig holds the encryption.* settings. The age secret keys are
+// read from AgeKeyFile, falling back to SOPS_AGE_KEY_FILE, and from the
+// SOPS_AGE_KEY environment variable, the variables SOPS itself uses.
+type EncryptionConfig struct {
+	AgeKeyFile string
+	AgeKeys    string
+}
+
+// LoadEncryptionConfig reads the encryption.* settings.
+func LoadEncryptionConfig() EncryptionConfig {
+	return EncryptionConfig{
+		AgeKeyFile: firstSet(viper.GetString("encryption.age_key_file"), os.Getenv("SOPS_AGE_KEY_FILE")),
+		AgeKeys:    os.Getenv("SOPS_AGE_KEY"),
+	}
+}
+
+// identities returns the configured age secret keys.
+func (cfg EncryptionConfig) identities() ([]ageIdentity, error) {
+	ids, err := parseAgeIdentities(cfg.AgeKeys)
+	if err != nil {
+		return nil, fmt.Errorf("SOPS_AGE_KEY: %w", err)
+	}
+	if cfg.AgeKeyFile != "" {
+		data, err := os.ReadFile(cfg.AgeKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption.age_key_file: %w", err)
+		}
+		fileIDs, err := parseAgeIdentities(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("encryption.age_key_file: %w", err)
+		}
+		ids = append(ids, fileIDs...)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no age key configured (encryption.age_key_file, SOPS_AGE_KEY_FILE or SOPS_AGE_KEY)")
+	}
+	return ids, nil
+}
+
+// LoadEncryptedValues decrypts every setting holding an ASCII-armored age
+// file (as written by "age --armor") and replaces it with the trimmed
+// plaintext. It returns the decrypted keys. Any value can be encrypted, so
+// the config file, seeds included, can be kept in git.
+func LoadEncryptedValues() ([]string, error) {
+	var keys []string
+	for _, key := range viper.AllKeys() {
+		if value, ok := viper.Get(key).(string); ok && isAgeArmored(value) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	slices.Sort(keys)
+
+	ids, err := LoadEncryptionConfig().identities()
+	if err != nil {
+		return nil, fmt.Errorf("%s is encrypted: %w", keys[0], err)
+	}
+	for _, key := range keys {
+		plaintext, err := decryptAge(viper.GetString(key), ids)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		viper.Set(key, strings.TrimSpace(string(plaintext)))
+	}
+	return keys, nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaID is the draft of the generated JSON Schema.
+const schemaID = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches Go duration strings such as "90s" or "1h30m".
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema returns the JSON Schema of the configuration file, generated from
+// Config so that it cannot drift from what Load accepts.
+func Schema() ([]byte, error) {
+	root := schemaFor(reflect.TypeOf(Config{}))
+	root["$schema"] = schemaID
+	root["title"] = "GCS Antal configuration"
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	if t == durationType {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			s := schemaFor(f.Type)
+			if desc := f.Tag.Get("desc"); desc != "" {
+				s["description"] = desc
+			}
+			if enum := f.Tag.Get("enum"); enum != "" {
+				s["enum"] = strings.Split(enum, ",")
+			}
+			props[name] = s
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// SchemaHandler serves the JSON Schema, e.g. on /info/schema.
+func SchemaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		schema, err := Schema()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write(schema)
+	})
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SecretKeys are the settings that may be read from a file named by
+// <key>_file instead of being set inline, e.g. a mounted Kubernetes secret.
+var SecretKeys = []string{
+	"nats.pass",
+	"nats.issuer_seed",
+	"nats.xkey_seed",
+	"nats.nkey_seed",
+	"token_cache.hmac_secret",
+	"deploy_tokens.admin_token",
+}
+
+// LoadSecretFiles sets every secret whose <key>_file is configured to the
+// trimmed content of that file. Setting both the key and its file is an
+// error, as it is unclear which one is meant.
+func LoadSecretFiles() error {
+	for _, key := range SecretKeys {
+		path := viper.GetString(key + "_file")
+		if path == "" {
+			continue
+		}
+		if viper.GetString(key) != "" {
+			return fmt.Errorf("%s and %s_file are both set", key, key)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", key, err)
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return fmt.Errorf("%s_file: %s is empty", key, path)
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSecretFiles(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	seedFile := filepath.Join(dir, "issuer_seed")
+	require.NoError(t, os.WriteFile(seedFile, []byte("SAEXAMPLE\n"), 0o600))
+
+	viper.Reset()
+	viper.Set("nats.issuer_seed_file", seedFile)
+	viper.Set("nats.pass", "inline")
+	require.NoError(t, LoadSecretFiles())
+	assert.Equal(t, "SAEXAMPLE", viper.GetString("nats.issuer_seed"), "trailing newline is trimmed")
+	assert.Equal(t, "inline", viper.GetString("nats.pass"), "inline secrets are kept")
+
+	viper.Reset()
+	viper.Set("nats.issuer_seed", "SAINLINE")
+	viper.Set("nats.issuer_seed_file", seedFile)
+	assert.ErrorContains(t, LoadSecretFiles(), "both set")
+
+	viper.Reset()
+	viper.Set("token_cache.hmac_secret_file", filepath.Join(dir, "missing"))
+	assert.ErrorContains(t, LoadSecretFiles(), "token_cache.hmac_secret_file")
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, []byte("\n"), 0o600))
+	viper.Reset()
+	viper.Set("nats.xkey_seed_file", empty)
+	assert.ErrorContains(t, LoadSecretFiles(), "is empty")
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Vault auth methods.
+const (
+	VaultAuthToken   = "token"
+	VaultAuthAppRole = "approle"
+)
+
+// vaultFields maps the fields of the Vault secret to the settings they set.
+var vaultFields = map[string]string{
+	"issuer_seed": "nats.issuer_seed",
+	"xkey_seed":   "nats.xkey_seed",
+	"hmac_secret": "token_cache.hmac_secret",
+}
+
+// VaultConfig holds the vault.* settings. Address, Token and SecretID fall
+// back to the VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_ID environment
+// variables, so no credential has to be written to the config file.
+type VaultConfig struct {
+	Enabled      bool
+	Address      string
+	Namespace    string
+	CAFile       string
+	Timeout      time.Duration
+	Auth         string
+	Token        string
+	AppRoleMount string
+	RoleID       string
+	SecretID     string
+	// Mount and Path locate the KV v2 secret, read from
+	// <address>/v1/<mount>/data/<path>.
+	Mount string
+	Path  string
+}
+
+// LoadVaultConfig reads the vault.* settings.
+func LoadVaultConfig() VaultConfig {
+	return VaultConfig{
+		Enabled:      viper.GetBool("vault.enabled"),
+		Address:      strings.TrimSuffix(firstSet(viper.GetString("vault.address"), os.Getenv("VAULT_ADDR")), "/"),
+		Namespace:    viper.GetString("vault.namespace"),
+		CAFile:       viper.GetString("vault.ca_file"),
+		Timeout:      viper.GetDuration("vault.timeout"),
+		Auth:         viper.GetString("vault.auth"),
+		Token:        firstSet(viper.GetString("vault.token"), os.Getenv("VAULT_TOKEN")),
+		AppRoleMount: viper.GetString("vault.approle_mount"),
+		RoleID:       viper.GetString("vault.role_id"),
+		SecretID:     firstSet(viper.GetString("vault.secret_id"), os.Getenv("VAULT_SECRET_ID")),
+		Mount:        strings.Trim(viper.GetString("vault.mount"), "/"),
+		Path:         strings.Trim(viper.GetString("vault.path"), "/"),
+	}
+}
+
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Validate checks an enabled configuration.
+func (cfg VaultConfig) Validate() error {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.Address == "":
+		return errors.New("vault: address (or VAULT_ADDR) is required")
+	case cfg.Mount == "" || cfg.Path == "":
+		return errors.New("vault: mount and path are required")
+	}
+	switch cfg.Auth {
+	case VaultAuthToken:
+		if cfg.Token == "" {
+			return errors.New("vault: token (or VAULT_TOKEN) is required with auth: token")
+		}
+	case VaultAuthAppRole:
+		if cfg.RoleID == "" || cfg.SecretID == "" {
+			return errors.New("vault: role_id and secret_id (or VAULT_SECRET_ID) are required with auth: approle")
+		}
+	default:
+		return fmt.Errorf("vault: unknown auth %q (token, approle)", cfg.Auth)
+	}
+	return nil
+}
+
+// vaultClient is a minimal client of the Vault HTTP API.
+type vaultClient struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func newVaultClient(cfg VaultConfig) (*vaultClient, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("vault: failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("vault: no certificates found in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	return &vaultClient{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout, Transport: transport}}, nil
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out.
+func (v *vaultClient) do(ctx context.Context, method, path, token string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.cfg.Address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if len(apiErr.Errors) > 0 {
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.Join(apiErr.Errors, "; "))
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// login returns the token used to read the secret.
+func (v *vaultClient) login(ctx context.Context) (string, error) {
+	if v.cfg.Auth != VaultAuthAppRole {
+		return v.cfg.Token, nil
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": v.cfg.RoleID, "secret_id": v.cfg.SecretID}
+	if err := v.do(ctx, http.MethodPost, "auth/"+v.cfg.AppRoleMount+"/login", "", body, &resp); err != nil {
+		return "", fmt.Errorf("approle login: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("approle login: no client token in response")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// read returns the fields of the latest version of the KV v2 secret.
+func (v *vaultClient) read(ctx context.Context, token string) (map[string]any, error) {
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, v.cfg.Mount+"/data/"+v.cfg.Path, token, nil, &resp); err != nil {
+		return nil, fmt.Errorf("read secret: %w", err)
+	}
+	return resp.Data.Data, nil
+}
+
+// LoadVaultSecrets fetches the issuer seed, xkey seed and HMAC secret from
+// the configured Vault KV v2 secret (fields issuer_seed, xkey_seed and
+// hmac_secret) and sets them. Fields missing from the secret are left alone;
+// a field whose setting is also configured locally is an error. It returns
+// the settings it set.
+func LoadVaultSecrets(ctx context.Context) ([]string, error) {
+	cfg := LoadVaultConfig()
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	client, err := newVaultClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, client.client.Timeout)
+	defer cancel()
+
+	token, err := client.login(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	fields, err := client.read(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+
+	var set []string
+	for _, field := range slices.Sorted(maps.Keys(vaultFields)) {
+		raw, ok := fields[field]
+		if !ok {
+			continue
+		}
+		key := vaultFields[field]
+		value, ok := raw.(string)
+		if !ok || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("vault: field %s of %s/%s is not a non-empty string", field, cfg.Mount, cfg.Path)
+		}
+		if viper.GetString(key) != "" {
+			return nil, fmt.Errorf("vault: %s is set locally and in field %s of %s/%s", key, field, cfg.Mount, cfg.Path)
+		}
+		viper.Set(key, strings.TrimSpace(value))
+		set = append(set, key)
+	}
+	return set, nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["role_id"] != "antal" || body["secret_id"] != "s3cret" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"approle-token"}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/gcs_antal":
+			if tok := r.Header.Get("X-Vault-Token"); tok != "root" && tok != "approle-token" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":{"issuer_seed":"SAEXAMPLE","hmac_secret":"hmac\n"},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func setVaultConfig(address string) {
+	viper.Reset()
+	viper.Set("vault.enabled", true)
+	viper.Set("vault.address", address)
+	viper.Set("vault.auth", VaultAuthToken)
+	viper.Set("vault.approle_mount", "approle")
+	viper.Set("vault.mount", "secret")
+	viper.Set("vault.path", "gcs_antal")
+}
+
+func TestLoadVaultSecrets(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	vault := newTestVault(t)
+
+	setVaultConfig(vault.URL)
+	viper.Set("vault.token", "root")
+	keys, err := LoadVaultSecrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token_cache.hmac_secret", "nats.issuer_seed"}, keys)
+	assert.Equal(t, "SAEXAMPLE", viper.GetString("nats.issuer_seed"))
+	assert.Equal(t, "hmac", viper.GetString("token_cache.hmac_secret"))
+	assert.Empty(t, viper.GetString("nats.xkey_seed"), "fields missing from the secret are left alone")
+
+	setVaultConfig(vault.URL)
+	viper.Set("vault.auth", VaultAuthAppRole)
+	viper.Set("vault.role_id", "antal")
+	viper.Set("vault.secret_id", "s3cret")
+	_, err = LoadVaultSecrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "SAEXAMPLE", viper.GetString("nats.issuer_seed"))
+
+	setVaultConfig(vault.URL)
+	viper.Set("vault.auth", VaultAuthAppRole)
+	viper.Set("vault.role_id", "antal")
+	viper.Set("vault.secret_id", "wrong")
+	_, err = LoadVaultSecrets(context.Background())
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+
+	setVaultConfig(vault.URL)
+	viper.Set("vault.token", "other")
+	_, err = LoadVaultSecrets(context.Background())
+	assert.ErrorContains(t, err, "permission denied")
+
+	setVaultConfig(vault.URL)
+	viper.Set("vault.token", "root")
+	viper.Set("nats.issuer_seed", "SAINLINE")
+	_, err = LoadVaultSecrets(context.Background())
+	assert.ErrorContains(t, err, "set locally")
+}
+
+func TestVaultConfig_Validate(t *testing.T) {
+	valid := VaultConfig{Enabled: true, Address: "https://vault:8200", Auth: VaultAuthToken, Token: "t", Mount: "secret", Path: "antal"}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, VaultConfig{}.Validate(), "disabled")
+
+	noToken := valid
+	noToken.Token = ""
+	assert.Error(t, noToken.Validate())
+
+	appRole := valid
+	appRole.Auth = VaultAuthAppRole
+	assert.Error(t, appRole.Validate(), "role_id and secret_id are required")
+	appRole.RoleID, appRole.SecretID = "role", "secret"
+	assert.NoError(t, appRole.Validate())
+
+	unknown := valid
+	unknown.Auth = "kubernetes"
+	assert.ErrorContains(t, unknown.Validate(), "unknown auth")
+}
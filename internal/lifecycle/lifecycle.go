@@ -0,0 +1,150 @@
+// Package lifecycle starts and stops the service's subsystems in a fixed
+// order, so shutdown sequencing lives in one place instead of main.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout bounds a hook's Stop when the hook sets no timeout.
+const DefaultStopTimeout = 10 * time.Second
+
+// Hook is a subsystem managed by the Manager. Start and Stop are optional.
+type Hook struct {
+	Name string
+	// Start brings the subsystem up. Long-running work must be started in the
+	// background; Start is expected to return once the subsystem is ready.
+	Start func(ctx context.Context) error
+	// Stop shuts the subsystem down, within the context deadline.
+	Stop func(ctx context.Context) error
+	// StopTimeout bounds Stop; zero uses DefaultStopTimeout.
+	StopTimeout time.Duration
+}
+
+// Manager starts hooks in registration order and stops them in reverse
+// order, so a subsystem is always stopped before the ones it depends on.
+type Manager struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+	fatal   chan error
+}
+
+// New creates an empty manager.
+func New() *Manager {
+	return &Manager{
+		logger: slog.With("component", "lifecycle"),
+		fatal:  make(chan error, 1),
+	}
+}
+
+// Register adds a hook. Hooks registered after Start are neither started nor
+// stopped.
+func (m *Manager) Register(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, h)
+}
+
+// Start starts every hook in registration order. When a hook fails, the hooks
+// started so far are stopped again and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	for i, h := range hooks {
+		if h.Start != nil {
+			m.logger.Debug("Starting subsystem", "name", h.Name)
+			if err := h.Start(ctx); err != nil {
+				err = fmt.Errorf("failed to start %s: %w", h.Name, err)
+				m.setStarted(i)
+				if stopErr := m.Stop(context.Background()); stopErr != nil {
+					m.logger.Error("Failed to stop subsystems after a failed start", "error", stopErr)
+				}
+				return err
+			}
+		}
+		m.setStarted(i + 1)
+	}
+	return nil
+}
+
+func (m *Manager) setStarted(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = n
+}
+
+// Fail reports an error of a running subsystem (e.g. a listener that died);
+// it ends Wait. Only the first error is kept.
+func (m *Manager) Fail(name string, err error) {
+	select {
+	case m.fatal <- fmt.Errorf("%s failed: %w", name, err):
+	default:
+	}
+}
+
+// Wait blocks until ctx is done (e.g. on a shutdown signal) or a subsystem
+// fails, and returns the failure, if any.
+func (m *Manager) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-m.fatal:
+		return err
+	}
+}
+
+// Stop stops the started hooks in reverse order. Every hook is stopped even
+// when an earlier one fails or times out; the errors are joined.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := m.hooks[:m.started]
+	m.started = 0
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.Stop == nil {
+			continue
+		}
+		start := time.Now()
+		if err := m.stopHook(ctx, h); err != nil {
+			m.logger.Error("Failed to stop subsystem", "name", h.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.Name, err))
+			continue
+		}
+		m.logger.Debug("Subsystem stopped", "name", h.Name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// stopHook runs Stop with the hook's timeout. A Stop that ignores its
+// context is abandoned when the timeout passes, so one stuck subsystem
+// cannot block the rest of the shutdown.
+func (m *Manager) stopHook(ctx context.Context, h Hook) error {
+	timeout := h.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- h.Stop(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("stop timed out: %w", ctx.Err())
+	}
+}
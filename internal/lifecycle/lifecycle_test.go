@@ -0,0 +1,84 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHook records its start and stop calls in events.
+func recordingHook(name string, events *[]string, startErr error) Hook {
+	return Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			*events = append(*events, "start "+name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManager_StartsInOrderAndStopsInReverse(t *testing.T) {
+	var events []string
+	m := New()
+	m.Register(recordingHook("a", &events, nil))
+	m.Register(Hook{Name: "stop-only", Stop: func(context.Context) error {
+		events = append(events, "stop stop-only")
+		return nil
+	}})
+	m.Register(recordingHook("b", &events, nil))
+
+	require.NoError(t, m.Start(context.Background()))
+	require.NoError(t, m.Stop(context.Background()))
+	assert.Equal(t, []string{"start a", "start b", "stop b", "stop stop-only", "stop a"}, events)
+
+	// A second Stop is a no-op.
+	require.NoError(t, m.Stop(context.Background()))
+	assert.Len(t, events, 5)
+}
+
+func TestManager_FailedStartStopsStartedHooks(t *testing.T) {
+	var events []string
+	m := New()
+	m.Register(recordingHook("a", &events, nil))
+	m.Register(recordingHook("b", &events, errors.New("boom")))
+	m.Register(recordingHook("c", &events, nil))
+
+	err := m.Start(context.Background())
+	assert.ErrorContains(t, err, "failed to start b: boom")
+	assert.Equal(t, []string{"start a", "start b", "stop a"}, events)
+}
+
+func TestManager_StopContinuesPastFailuresAndTimeouts(t *testing.T) {
+	var events []string
+	m := New()
+	m.Register(recordingHook("a", &events, nil))
+	m.Register(Hook{Name: "stuck", StopTimeout: 10 * time.Millisecond, Stop: func(context.Context) error {
+		select {} // ignores its context
+	}})
+	m.Register(Hook{Name: "broken", Stop: func(context.Context) error { return errors.New("boom") }})
+
+	require.NoError(t, m.Start(context.Background()))
+	err := m.Stop(context.Background())
+	assert.ErrorContains(t, err, "broken: boom")
+	assert.ErrorContains(t, err, "stuck: stop timed out")
+	assert.Equal(t, []string{"start a", "stop a"}, events)
+}
+
+func TestManager_WaitReturnsFirstFailure(t *testing.T) {
+	m := New()
+	m.Fail("http_server", errors.New("address in use"))
+	m.Fail("other", errors.New("ignored"))
+	assert.EqualError(t, m.Wait(context.Background()), "http_server failed: address in use")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, m.Wait(ctx))
+}
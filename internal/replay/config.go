@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config holds the replay settings.
+type Config struct {
+	// From is the audit JSON Lines file to replay (--from).
+	From string
+	// TokensFile maps recorded usernames to synthetic tokens (--tokens).
+	TokensFile string
+	Rate       Rate
+	// Concurrency bounds the connections in flight; records replayed while
+	// the limit is reached are skipped.
+	Concurrency int
+}
+
+// Rate is the replay pace: a constant number of requests per second, or
+// zero to keep the recorded spacing.
+type Rate float64
+
+// ParseRate parses "original", "<n>/s" or "<n>/m".
+func ParseRate(s string) (Rate, error) {
+	if s == "" || s == "original" {
+		return 0, nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute}[unit]
+	value, err := strconv.ParseFloat(n, 64)
+	if !ok || per == 0 || err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q (use original, <n>/s or <n>/m)", s)
+	}
+	return Rate(value * float64(time.Second) / float64(per)), nil
+}
+
+// interval returns the time between two requests, or zero for the recorded
+// spacing.
+func (r Rate) interval() time.Duration {
+	if r <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / float64(r))
+}
+
+// LoadConfig reads the --from, --tokens and --rate flags and the replay.*
+// settings.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		From:        viper.GetString("from"),
+		TokensFile:  viper.GetString("tokens"),
+		Concurrency: viper.GetInt("replay.concurrency"),
+	}
+	rate, err := ParseRate(viper.GetString("rate"))
+	if err != nil {
+		return Config{}, fmt.Errorf("--rate: %w", err)
+	}
+	cfg.Rate = rate
+
+	switch {
+	case cfg.From == "":
+		return Config{}, fmt.Errorf("--from is required")
+	case cfg.TokensFile == "":
+		return Config{}, fmt.Errorf("--tokens is required")
+	case cfg.Concurrency <= 0:
+		return Config{}, fmt.Errorf("replay.concurrency must be > 0")
+	}
+	return cfg, nil
+}
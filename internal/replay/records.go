@@ -0,0 +1,122 @@
+// Package replay implements `antal replay`: it replays the auth decisions
+// recorded in an audit file (audit.file) against a staging instance, with
+// synthetic tokens in place of the original ones, to load test and to catch
+// policy regressions with the shape of production traffic.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Record is one recorded auth decision. Audit files never hold tokens, so a
+// record only says who connected, when, with which client tags and how the
+// request was decided.
+type Record struct {
+	Time     time.Time
+	Username string
+	Allowed  bool
+	// Code is the deny code of denied requests.
+	Code string
+	Tags map[string]string
+}
+
+// auditLine is the subset of an audit event read by ReadRecords.
+type auditLine struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Action  string    `json:"action"`
+	Outcome string    `json:"outcome"`
+	Attrs   struct {
+		Username string            `json:"username"`
+		Code     string            `json:"code"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"attrs"`
+}
+
+// ReadRecords reads the auth decisions of an audit JSON Lines file in file
+// order. Other events are ignored; lines that are not valid JSON are
+// counted in skipped, so a truncated last line does not stop a replay.
+func ReadRecords(r io.Reader) (records []Record, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var event auditLine
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			skipped++
+			continue
+		}
+		if event.Kind != "decision" || event.Action != "auth" {
+			continue
+		}
+		records = append(records, Record{
+			Time:     event.Time,
+			Username: event.Attrs.Username,
+			Allowed:  event.Outcome == "allow",
+			Code:     event.Attrs.Code,
+			Tags:     event.Attrs.Tags,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, fmt.Errorf("failed to read audit records: %w", err)
+	}
+	return records, skipped, nil
+}
+
+// InvalidToken is sent for records denied because of their credentials, so
+// the staging instance sees the same mix of failed verifications.
+const InvalidToken = "replay-invalid-token"
+
+// credentialCodes are the deny codes caused by the token itself.
+var credentialCodes = []string{"invalid_credentials", "locked_out", "password_sent"}
+
+// Tokens maps recorded usernames to the tokens replayed for them, e.g.
+// personal access tokens of test users on the staging GitLab.
+type Tokens map[string]string
+
+// LoadTokens reads a YAML (or JSON) file mapping usernames to tokens.
+func LoadTokens(path string) (Tokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tokens: %w", err)
+	}
+	var tokens Tokens
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("tokens: %s must map usernames to tokens: %w", path, err)
+	}
+	return tokens, nil
+}
+
+// For returns the synthetic token replayed for a record, or false when the
+// username has no token. Records denied because of their credentials are
+// replayed with InvalidToken, whatever the username.
+func (t Tokens) For(rec Record) (string, bool) {
+	if !rec.Allowed && slices.Contains(credentialCodes, rec.Code) {
+		return InvalidToken, true
+	}
+	token, ok := t[rec.Username]
+	return token, ok
+}
+
+// ConnectionName returns the connection name carrying the record's client
+// tags, so the staging instance renders the same grants.
+func ConnectionName(rec Record) string {
+	name := "antal-replay"
+	for _, tag := range slices.Sorted(maps.Keys(rec.Tags)) {
+		name += ";" + tag + "=" + rec.Tags[tag]
+	}
+	return name
+}
@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const auditLog = `{"time":"2026-10-01T10:00:00Z","kind":"decision","action":"auth","outcome":"allow","attrs":{"username":"alice","tags":{"app":"ci"}}}
+{"time":"2026-10-01T10:00:01Z","kind":"admin","action":"cache.purge","outcome":"success"}
+{"time":"2026-10-01T10:00:02Z","kind":"decision","action":"auth","outcome":"deny","attrs":{"username":"bob","code":"invalid_credentials"}}
+
+{"time":"2026-10-01T10:00:03Z","kind":"decision","action":"auth","outcome":"deny","attrs":{"username":"alice","code":"permission_denied"}}
+{"time":"2026-10-01T10:00:04Z","kind":"decision","action":"au`
+
+func TestReadRecords(t *testing.T) {
+	records, skipped, err := ReadRecords(strings.NewReader(auditLog))
+	require.NoError(t, err)
+	assert.Equal(t, 1, skipped, "truncated last line")
+	require.Len(t, records, 3)
+
+	assert.Equal(t, Record{
+		Time:     time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC),
+		Username: "alice",
+		Allowed:  true,
+		Tags:     map[string]string{"app": "ci"},
+	}, records[0])
+	assert.Equal(t, "invalid_credentials", records[1].Code)
+	assert.False(t, records[2].Allowed)
+}
+
+func TestTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("alice: glpat-alice\n"), 0o600))
+	tokens, err := LoadTokens(path)
+	require.NoError(t, err)
+
+	token, ok := tokens.For(Record{Username: "alice", Allowed: true})
+	assert.True(t, ok)
+	assert.Equal(t, "glpat-alice", token)
+	_, ok = tokens.For(Record{Username: "bob", Allowed: true})
+	assert.False(t, ok)
+	token, ok = tokens.For(Record{Username: "bob", Code: "invalid_credentials"})
+	assert.True(t, ok)
+	assert.Equal(t, InvalidToken, token, "credential denials need no token")
+
+	_, err = LoadTokens(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestParseRate(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"original": 0,
+		"":         0,
+		"100/s":    10 * time.Millisecond,
+		"600/m":    100 * time.Millisecond,
+	} {
+		rate, err := ParseRate(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, rate.interval(), in)
+	}
+	for _, in := range []string{"100", "0/s", "-1/s", "10/h", "fast/s"} {
+		_, err := ParseRate(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestConnectionName(t *testing.T) {
+	assert.Equal(t, "antal-replay", ConnectionName(Record{}))
+	assert.Equal(t, "antal-replay;app=ci;env=prod",
+		ConnectionName(Record{Tags: map[string]string{"env": "prod", "app": "ci"}}))
+}
+
+func TestRunner(t *testing.T) {
+	records, _, err := ReadRecords(strings.NewReader(auditLog))
+	require.NoError(t, err)
+	records = append(records, Record{Username: "carol", Allowed: true})
+
+	var mu sync.Mutex
+	var names []string
+	connect := func(name, username, token string) (bool, error) {
+		mu.Lock()
+		names = append(names, name)
+		mu.Unlock()
+		switch {
+		case token == InvalidToken:
+			return false, nil
+		case username == "alice":
+			// Staging now allows what production denied.
+			return true, nil
+		}
+		return false, errors.New("unexpected")
+	}
+	cfg := Config{Rate: 1000, Concurrency: 10}
+	report := NewRunner(cfg, Tokens{"alice": "glpat-alice"}, connect).Run(context.Background(), records)
+
+	assert.Equal(t, 3, report.Replayed)
+	assert.Equal(t, 2, report.Allowed)
+	assert.Equal(t, 1, report.Denied)
+	assert.Equal(t, 1, report.Mismatches)
+	assert.Equal(t, 1, report.NoToken)
+	assert.Zero(t, report.Errors)
+	assert.True(t, report.Failed())
+	assert.Contains(t, names, "antal-replay;app=ci")
+}
+
+func TestRunner_OriginalSpacing(t *testing.T) {
+	now := time.Now()
+	records := []Record{
+		{Time: now, Username: "alice", Allowed: true},
+		{Time: now.Add(50 * time.Millisecond), Username: "alice", Allowed: true},
+	}
+	connect := func(name, username, token string) (bool, error) { return true, nil }
+
+	start := time.Now()
+	report := NewRunner(Config{Concurrency: 1}, Tokens{"alice": "t"}, connect).Run(context.Background(), records)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, 2, report.Allowed)
+	assert.False(t, report.Failed())
+}
+
+func TestRunner_Cancelled(t *testing.T) {
+	now := time.Now()
+	records := []Record{
+		{Time: now, Username: "alice", Allowed: true},
+		{Time: now.Add(time.Hour), Username: "alice", Allowed: true},
+	}
+	connect := func(name, username, token string) (bool, error) { return true, nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report := NewRunner(Config{Concurrency: 1}, Tokens{"alice": "t"}, connect).Run(ctx, records)
+	assert.Equal(t, 1, report.Replayed)
+}
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConnectFunc opens and closes one client connection through the auth
+// callout. It reports whether the connection was authorized; err is set
+// for failures other than a denial (e.g. the server being unreachable).
+type ConnectFunc func(name, username, token string) (allowed bool, err error)
+
+// Report is the outcome of a replay.
+type Report struct {
+	Replayed int
+	Allowed  int
+	Denied   int
+	Errors   int
+	// Mismatches counts replayed requests decided differently than recorded.
+	Mismatches int
+	// NoToken counts records skipped because their username has no token.
+	NoToken int
+	// Skipped counts records not replayed because too many were in flight.
+	Skipped int
+	P50     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// Failed reports whether a replayed decision differed from the recorded one
+// or a request could not be decided.
+func (r Report) Failed() bool {
+	return r.Mismatches > 0 || r.Errors > 0
+}
+
+// Runner replays records at the configured rate.
+type Runner struct {
+	cfg     Config
+	tokens  Tokens
+	connect ConnectFunc
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	report    Report
+	latencies []time.Duration
+}
+
+// NewRunner creates a runner.
+func NewRunner(cfg Config, tokens Tokens, connect ConnectFunc) *Runner {
+	return &Runner{
+		cfg:     cfg,
+		tokens:  tokens,
+		connect: connect,
+		logger:  slog.With("component", "replay"),
+	}
+}
+
+// Run replays records in order until all were sent or ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, records []Record) Report {
+	inflight := make(chan struct{}, r.cfg.Concurrency)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+replay:
+	for i, rec := range records {
+		timer.Reset(time.Until(start.Add(r.offset(records, i))))
+		select {
+		case <-ctx.Done():
+			break replay
+		case <-timer.C:
+		}
+
+		token, ok := r.tokens.For(rec)
+		if !ok {
+			r.mu.Lock()
+			r.report.NoToken++
+			r.mu.Unlock()
+			continue
+		}
+		select {
+		case inflight <- struct{}{}:
+		default:
+			r.mu.Lock()
+			r.report.Skipped++
+			r.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inflight }()
+			r.replay(rec, token)
+		}()
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report
+	slices.Sort(r.latencies)
+	report.P50 = percentile(r.latencies, 0.50)
+	report.P99 = percentile(r.latencies, 0.99)
+	if len(r.latencies) > 0 {
+		report.Max = r.latencies[len(r.latencies)-1]
+	}
+	return report
+}
+
+// offset returns when the i-th record is due, relative to the start of the
+// replay: at the configured rate, or after the recorded time since the first
+// record.
+func (r *Runner) offset(records []Record, i int) time.Duration {
+	if interval := r.cfg.Rate.interval(); interval > 0 {
+		return time.Duration(i) * interval
+	}
+	return max(records[i].Time.Sub(records[0].Time), 0)
+}
+
+func (r *Runner) replay(rec Record, token string) {
+	start := time.Now()
+	allowed, err := r.connect(ConnectionName(rec), rec.Username, token)
+	elapsed := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Replayed++
+	r.latencies = append(r.latencies, elapsed)
+	switch {
+	case err != nil:
+		r.report.Errors++
+		r.logger.Warn("Replayed request failed", "username", rec.Username, "error", err)
+		return
+	case allowed:
+		r.report.Allowed++
+	default:
+		r.report.Denied++
+	}
+	if allowed != rec.Allowed {
+		r.report.Mismatches++
+		r.logger.Warn("Replayed decision differs from recorded",
+			"username", rec.Username,
+			"recorded_at", rec.Time,
+			"recorded", outcome(rec.Allowed),
+			"recorded_code", rec.Code,
+			"replayed", outcome(allowed),
+		)
+	}
+}
+
+func outcome(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// percentile returns the q-quantile of sorted latencies.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(q * float64(len(sorted)-1))
+	return sorted[idx]
+}
@@ -0,0 +1,57 @@
+//go:build !nosentry
+
+// Package sentry is the error reporting backend of the service: the Sentry
+// SDK, or a no-op when built with the nosentry tag for deployments whose
+// policy forbids the SDK. It exposes the subset of the SDK the service uses,
+// under the same names.
+package sentry
+
+import (
+	"github.com/getsentry/sentry-go"
+)
+
+// Available reports whether this build can report to Sentry.
+const Available = true
+
+type (
+	Breadcrumb     = sentry.Breadcrumb
+	BreadcrumbHint = sentry.BreadcrumbHint
+	Client         = sentry.Client
+	ClientOptions  = sentry.ClientOptions
+	Context        = sentry.Context
+	EventID        = sentry.EventID
+	Hub            = sentry.Hub
+	Level          = sentry.Level
+	Sampled        = sentry.Sampled
+	Scope          = sentry.Scope
+	Span           = sentry.Span
+	SpanOption     = sentry.SpanOption
+	SpanStatus     = sentry.SpanStatus
+	User           = sentry.User
+)
+
+const (
+	LevelDebug   = sentry.LevelDebug
+	LevelInfo    = sentry.LevelInfo
+	LevelWarning = sentry.LevelWarning
+	LevelError   = sentry.LevelError
+	LevelFatal   = sentry.LevelFatal
+
+	SpanStatusInternalError = sentry.SpanStatusInternalError
+
+	SampledTrue = sentry.SampledTrue
+)
+
+var (
+	Init             = sentry.Init
+	Flush            = sentry.Flush
+	CurrentHub       = sentry.CurrentHub
+	SetHubOnContext  = sentry.SetHubOnContext
+	CaptureException = sentry.CaptureException
+	CaptureMessage   = sentry.CaptureMessage
+	WithScope        = sentry.WithScope
+	AddBreadcrumb    = sentry.AddBreadcrumb
+	StartSpan        = sentry.StartSpan
+	StartTransaction = sentry.StartTransaction
+	WithSpanSampled  = sentry.WithSpanSampled
+)
@@ -0,0 +1,105 @@
+//go:build nosentry
+
+package sentry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Available reports whether this build can report to Sentry.
+const Available = false
+
+// ErrUnavailable is returned by Init in builds without Sentry.
+var ErrUnavailable = errors.New("built without Sentry support (nosentry)")
+
+type Level string
+
+const (
+	LevelDebug   Level = "debug"
+	LevelInfo    Level = "info"
+	LevelWarning Level = "warning"
+	LevelError   Level = "error"
+	LevelFatal   Level = "fatal"
+)
+
+type (
+	Context        = map[string]interface{}
+	BreadcrumbHint map[string]interface{}
+	EventID        string
+	SpanStatus     uint8
+	SpanOption     func(*Span)
+	Sampled        int8
+)
+
+const SpanStatusInternalError SpanStatus = 13
+
+const SampledTrue Sampled = 1
+
+type Breadcrumb struct {
+	Type      string
+	Category  string
+	Message   string
+	Data      map[string]interface{}
+	Level     Level
+	Timestamp time.Time
+}
+
+type User struct {
+	ID       string
+	Email    string
+	Username string
+	Name     string
+}
+
+type ClientOptions struct {
+	Dsn              string
+	Environment      string
+	TracesSampleRate float64
+	EnableTracing    bool
+	Debug            bool
+	AttachStacktrace bool
+	BeforeBreadcrumb func(*Breadcrumb, *BreadcrumbHint) *Breadcrumb
+}
+
+type Client struct{}
+
+type Scope struct{}
+
+func (*Scope) SetTag(string, string)        {}
+func (*Scope) SetContext(string, Context)   {}
+func (*Scope) SetUser(User)                 {}
+func (*Scope) SetLevel(Level)               {}
+func (*Scope) SetExtra(string, interface{}) {}
+
+type Span struct {
+	Status SpanStatus
+}
+
+func (*Span) Finish()                     {}
+func (*Span) SetTag(string, string)       {}
+func (*Span) SetData(string, interface{}) {}
+
+type Hub struct{}
+
+func (h *Hub) Clone() *Hub                 { return h }
+func (*Hub) Client() *Client               { return nil }
+func (*Hub) ConfigureScope(f func(*Scope)) { f(&Scope{}) }
+func (*Hub) Recover(interface{}) *EventID  { return nil }
+func (*Hub) Flush(time.Duration) bool      { return true }
+
+var hub = &Hub{}
+
+func Init(ClientOptions) error                                    { return ErrUnavailable }
+func Flush(time.Duration) bool                                    { return true }
+func CurrentHub() *Hub                                            { return hub }
+func SetHubOnContext(ctx context.Context, _ *Hub) context.Context { return ctx }
+func CaptureException(error) *EventID                             { return nil }
+func CaptureMessage(string) *EventID                              { return nil }
+func WithScope(f func(*Scope))                                    { f(&Scope{}) }
+func AddBreadcrumb(*Breadcrumb)                                   {}
+
+func StartSpan(context.Context, string, ...SpanOption) *Span        { return &Span{} }
+func StartTransaction(context.Context, string, ...SpanOption) *Span { return &Span{} }
+func WithSpanSampled(Sampled) SpanOption                            { return func(*Span) {} }
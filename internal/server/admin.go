@@ -0,0 +1,26 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// RequireBearerToken protects an admin handler with a static bearer token.
+// Requests without the exact "Authorization: Bearer <token>" header are
+// rejected with 401. An empty token rejects every request.
+func RequireBearerToken(token string, next http.Handler) http.Handler {
+	logger := slog.With("component", "http_server")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logger.Warn("Rejected unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gcs_antal admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
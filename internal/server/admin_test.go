@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireBearerToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "valid token", token: "s3cret", header: "Bearer s3cret", want: http.StatusNoContent},
+		{name: "wrong token", token: "s3cret", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "missing header", token: "s3cret", header: "", want: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "s3cret", header: "Basic s3cret", want: http.StatusUnauthorized},
+		{name: "empty configured token", token: "", header: "Bearer ", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/x", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			RequireBearerToken(tt.token, ok).ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestHandle_RegistersHandler(t *testing.T) {
+	s := NewServer("localhost", 0, 0)
+	h := http.NotFoundHandler()
+	s.Handle("/admin/x", h)
+	assert.Contains(t, s.handlers, "/admin/x")
+}
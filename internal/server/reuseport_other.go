@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(_, _ string, _ syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
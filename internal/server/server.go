@@ -3,9 +3,15 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -13,15 +19,60 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	server *http.Server
-	logger *slog.Logger
+	server   *http.Server
+	logger   *slog.Logger
+	handlers map[string]http.Handler
+
+	// addrs are the listen addresses; the first one is also server.Addr.
+	addrs []string
+	// allowed restricts the source addresses of requests; empty allows all.
+	allowed []netip.Prefix
+	// reusePort binds the listeners with SO_REUSEPORT.
+	reusePort bool
+}
+
+// Option customizes a Server.
+type Option func(*Server)
+
+// WithHosts binds the server to several hosts (IPv4, IPv6 or link-local
+// IPv6 with a zone, e.g. "fe80::1%eth0") on the same port, instead of the
+// host passed to NewServer. IPv6 hosts may be given with or without brackets.
+func WithHosts(hosts ...string) Option {
+	return func(s *Server) {
+		if len(hosts) == 0 {
+			return
+		}
+		port := s.port()
+		s.addrs = s.addrs[:0]
+		for _, host := range hosts {
+			s.addrs = append(s.addrs, joinHostPort(host, port))
+		}
+		s.server.Addr = s.addrs[0]
+	}
+}
+
+// WithAllowedSources only serves requests from the given networks; other
+// sources get 403 Forbidden.
+func WithAllowedSources(prefixes ...netip.Prefix) Option {
+	return func(s *Server) {
+		s.allowed = prefixes
+	}
+}
+
+// WithReusePort binds the listeners with SO_REUSEPORT, so a new process can
+// bind the same addresses before the old one stops serving (binary upgrades
+// without a gap). The kernel spreads new connections over both processes.
+func WithReusePort(enabled bool) Option {
+	return func(s *Server) {
+		s.reusePort = enabled
+	}
 }
 
 // NewServer creates a new HTTP server
-func NewServer(host string, port int, timeout time.Duration) *Server {
+func NewServer(host string, port int, timeout time.Duration, opts ...Option) *Server {
 	logger := slog.With("component", "http_server")
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := joinHostPort(host, strconv.Itoa(port))
 	srv := &http.Server{
 		Addr:              addr,
 		ReadTimeout:       timeout,
@@ -30,10 +81,69 @@ func NewServer(host string, port int, timeout time.Duration) *Server {
 		IdleTimeout:       timeout * 2,
 	}
 
-	return &Server{
-		server: srv,
-		logger: logger,
+	s := &Server{
+		server:   srv,
+		logger:   logger,
+		handlers: map[string]http.Handler{},
+		addrs:    []string{addr},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// joinHostPort formats a listen address, bracketing IPv6 hosts.
+func joinHostPort(host, port string) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, port)
+}
+
+func (s *Server) port() string {
+	_, port, _ := net.SplitHostPort(s.server.Addr)
+	return port
+}
+
+// ParseAllowedSources parses CIDRs (or single addresses) for
+// WithAllowedSources.
+func ParseAllowedSources(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source CIDR %q", entry)
+		}
+		addr = addr.WithZone("").Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
 	}
+	return prefixes, nil
+}
+
+// allowSources rejects requests whose source address is not in allowed.
+func (s *Server) allowSources(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			addr := ap.Addr().WithZone("").Unmap()
+			for _, prefix := range s.allowed {
+				if prefix.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		s.logger.Warn("Rejected request from a source outside the allow-list", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
+
+// Handle registers an additional handler; it must be called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.handlers[pattern] = handler
 }
 
 // Start starts the HTTP server
@@ -53,11 +163,54 @@ func (s *Server) Start() error {
 	// Metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
 
+	for pattern, handler := range s.handlers {
+		mux.Handle(pattern, handler)
+	}
+
 	s.server.Handler = mux
+	if len(s.allowed) > 0 {
+		s.server.Handler = s.allowSources(mux)
+	}
+
+	// Bind every address first, so a bad one fails the start as a whole.
+	var lc net.ListenConfig
+	if s.reusePort {
+		lc.Control = reusePort
+	}
+	listeners := make([]net.Listener, 0, len(s.addrs))
+	for _, addr := range s.addrs {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, open := range listeners {
+				_ = open.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
 
-	s.logger.Info("Starting HTTP server", "address", s.server.Addr)
+	s.logger.Info("Starting HTTP server", "addresses", s.addrs, "allowed_sources", len(s.allowed), "reuse_port", s.reusePort)
 
-	return s.server.ListenAndServe()
+	// Serve blocks until Shutdown; report the first real failure.
+	errs := make([]error, len(listeners))
+	var wg sync.WaitGroup
+	for i, ln := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.server.Serve(ln)
+			if !errors.Is(errs[i], http.ErrServerClosed) {
+				_ = s.server.Close() // don't keep serving on a subset of the addresses
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	return http.ErrServerClosed
 }
 
 // Stop gracefully shuts down the HTTP server
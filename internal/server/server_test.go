@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -167,3 +168,96 @@ func TestNewServer(t *testing.T) {
 		})
 	}
 }
+
+func TestNewServer_Hosts(t *testing.T) {
+	s := NewServer("::", 8080, time.Second)
+	assert.Equal(t, "[::]:8080", s.server.Addr)
+
+	s = NewServer("0.0.0.0", 8080, time.Second, WithHosts("0.0.0.0", "[::1]", "fe80::1%eth0"))
+	assert.Equal(t, []string{"0.0.0.0:8080", "[::1]:8080", "[fe80::1%eth0]:8080"}, s.addrs)
+	assert.Equal(t, "0.0.0.0:8080", s.server.Addr)
+
+	s = NewServer("localhost", 8080, time.Second, WithHosts())
+	assert.Equal(t, []string{"localhost:8080"}, s.addrs)
+}
+
+func TestStart_MultipleHosts(t *testing.T) {
+	s := NewServer("127.0.0.1", 18081, time.Second, WithHosts("127.0.0.1", "::1"))
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, addr := range []string{"127.0.0.1:18081", "[::1]:18081"} {
+		resp, err := http.Get("http://" + addr + "/health")
+		if err != nil && addr == "[::1]:18081" {
+			t.Logf("IPv6 loopback unavailable: %v", err)
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			_ = resp.Body.Close()
+		}
+	}
+
+	assert.NoError(t, s.Stop(context.Background()))
+	assert.ErrorIs(t, <-errCh, http.ErrServerClosed)
+}
+
+func TestStart_ReusePort(t *testing.T) {
+	oldServer := NewServer("127.0.0.1", 18082, time.Second, WithReusePort(true))
+	newServer := NewServer("127.0.0.1", 18082, time.Second, WithReusePort(true))
+	oldErr, newErr := make(chan error, 1), make(chan error, 1)
+	go func() { oldErr <- oldServer.Start() }()
+	time.Sleep(100 * time.Millisecond)
+	go func() { newErr <- newServer.Start() }()
+	time.Sleep(100 * time.Millisecond)
+
+	// The old process stops; the new one keeps serving on the same port.
+	assert.NoError(t, oldServer.Stop(context.Background()))
+	assert.ErrorIs(t, <-oldErr, http.ErrServerClosed)
+
+	resp, err := http.Get("http://127.0.0.1:18082/health")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+
+	assert.NoError(t, newServer.Stop(context.Background()))
+	assert.ErrorIs(t, <-newErr, http.ErrServerClosed)
+}
+
+func TestParseAllowedSources(t *testing.T) {
+	prefixes, err := ParseAllowedSources([]string{"10.0.0.0/8", "fd00:cafe::1/48", "192.0.2.7", "fe80::1%eth0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "fd00:cafe::/48", "192.0.2.7/32", "fe80::1/128"},
+		[]string{prefixes[0].String(), prefixes[1].String(), prefixes[2].String(), prefixes[3].String()})
+
+	_, err = ParseAllowedSources([]string{"10.0.0.0/33"})
+	assert.ErrorContains(t, err, "invalid source CIDR")
+}
+
+func TestAllowSources(t *testing.T) {
+	allowed, err := ParseAllowedSources([]string{"10.0.0.0/8", "fd00:cafe::/48", "fe80::/10"})
+	assert.NoError(t, err)
+	s := NewServer("localhost", 0, 0, WithAllowedSources(allowed...))
+	h := s.allowSources(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := map[string]int{
+		"10.1.2.3:1234":          http.StatusNoContent,
+		"[::ffff:10.1.2.3]:1234": http.StatusNoContent,
+		"[fd00:cafe::5]:1234":    http.StatusNoContent,
+		"[fe80::1%eth0]:1234":    http.StatusNoContent,
+		"192.0.2.1:1234":         http.StatusForbidden,
+		"[2001:db8::1]:1234":     http.StatusForbidden,
+		"garbage":                http.StatusForbidden,
+	}
+	for remote, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, want, rec.Code, remote)
+	}
+}
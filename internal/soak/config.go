@@ -0,0 +1,88 @@
+// Package soak implements `antal soak`: a long-running load test of the full
+// NATS auth callout pipeline against an embedded fake GitLab, used to spot
+// memory and latency drift before a release.
+package soak
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Phase is one step of the fake GitLab behaviour profile. Phases run in
+// order for their Duration and the profile repeats until the soak ends.
+type Phase struct {
+	Name     string        `mapstructure:"name"`
+	Duration time.Duration `mapstructure:"duration"`
+	// Latency is added to every GitLab response, plus a random Jitter.
+	Latency time.Duration `mapstructure:"latency"`
+	Jitter  time.Duration `mapstructure:"jitter"`
+	// ErrorRate, RateLimitRate and InvalidRate are the fractions (0..1) of
+	// requests answered with 500, 429 and 401 respectively.
+	ErrorRate     float64 `mapstructure:"error_rate"`
+	RateLimitRate float64 `mapstructure:"rate_limit_rate"`
+	InvalidRate   float64 `mapstructure:"invalid_rate"`
+}
+
+// Config holds the soak test settings.
+type Config struct {
+	Duration       time.Duration
+	ReportInterval time.Duration
+	// ConnectRate is the number of NATS connections attempted per second.
+	ConnectRate int
+	// Users is the number of distinct synthetic GitLab users.
+	Users   int
+	Profile []Phase
+	// MaxLatencyDrift and MaxHeapGrowth are the tolerated relative increases
+	// (e.g. 0.5 = +50%) of p99 latency and heap between the first and the
+	// last report window. Zero disables the check.
+	MaxLatencyDrift float64
+	MaxHeapGrowth   float64
+}
+
+// defaultProfile is used when soak.profile is not configured.
+var defaultProfile = []Phase{
+	{Name: "steady", Duration: 10 * time.Minute, Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond},
+	{Name: "slow", Duration: 2 * time.Minute, Latency: 800 * time.Millisecond, Jitter: 400 * time.Millisecond},
+	{Name: "flaky", Duration: 2 * time.Minute, Latency: 100 * time.Millisecond, ErrorRate: 0.2, InvalidRate: 0.05},
+	{Name: "throttled", Duration: time.Minute, Latency: 50 * time.Millisecond, RateLimitRate: 0.3},
+}
+
+// LoadConfig reads the soak configuration from viper (soak.*).
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Duration:        viper.GetDuration("soak.duration"),
+		ReportInterval:  viper.GetDuration("soak.report_interval"),
+		ConnectRate:     viper.GetInt("soak.connect_rate"),
+		Users:           viper.GetInt("soak.users"),
+		MaxLatencyDrift: viper.GetFloat64("soak.max_latency_drift"),
+		MaxHeapGrowth:   viper.GetFloat64("soak.max_heap_growth"),
+	}
+	if err := viper.UnmarshalKey("soak.profile", &cfg.Profile); err != nil {
+		return Config{}, fmt.Errorf("invalid soak.profile: %w", err)
+	}
+	if len(cfg.Profile) == 0 {
+		cfg.Profile = defaultProfile
+	}
+
+	switch {
+	case cfg.Duration <= 0:
+		return Config{}, fmt.Errorf("soak.duration must be > 0")
+	case cfg.ReportInterval <= 0:
+		return Config{}, fmt.Errorf("soak.report_interval must be > 0")
+	case cfg.ConnectRate <= 0:
+		return Config{}, fmt.Errorf("soak.connect_rate must be > 0")
+	case cfg.Users <= 0:
+		return Config{}, fmt.Errorf("soak.users must be > 0")
+	}
+	for i, p := range cfg.Profile {
+		if p.Duration <= 0 {
+			return Config{}, fmt.Errorf("soak.profile[%d] (%s): duration must be > 0", i, p.Name)
+		}
+		if p.ErrorRate+p.RateLimitRate+p.InvalidRate > 1 {
+			return Config{}, fmt.Errorf("soak.profile[%d] (%s): error, rate limit and invalid rates add up to more than 1", i, p.Name)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,120 @@
+package soak
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenPrefix marks tokens issued by the stub; the rest of the token is the username.
+const tokenPrefix = "soak-"
+
+// TokenFor returns the stub token that authenticates as username.
+func TokenFor(username string) string {
+	return tokenPrefix + username
+}
+
+// GitLabStub is a fake GitLab API serving the endpoints used for token
+// verification, with latency and failures driven by a Phase profile.
+type GitLabStub struct {
+	server  *httptest.Server
+	profile []Phase
+	cycle   time.Duration
+	now     func() time.Time
+	sleep   func(time.Duration)
+
+	mu      sync.Mutex
+	started time.Time
+	rand    *rand.Rand
+}
+
+// NewGitLabStub starts a fake GitLab following the given profile.
+func NewGitLabStub(profile []Phase) *GitLabStub {
+	s := &GitLabStub{
+		profile: profile,
+		now:     time.Now,
+		sleep:   time.Sleep,
+		rand:    rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+	for _, p := range profile {
+		s.cycle += p.Duration
+	}
+	s.started = s.now()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns the stub's base URL, to be used as gitlab.url.
+func (s *GitLabStub) URL() string {
+	return s.server.URL
+}
+
+// Close shuts the stub down.
+func (s *GitLabStub) Close() {
+	s.server.Close()
+}
+
+// Phase returns the profile phase active at the given time.
+func (s *GitLabStub) Phase(at time.Time) Phase {
+	if len(s.profile) == 0 {
+		return Phase{}
+	}
+	offset := at.Sub(s.started) % s.cycle
+	for _, p := range s.profile {
+		if offset < p.Duration {
+			return p
+		}
+		offset -= p.Duration
+	}
+	return s.profile[len(s.profile)-1]
+}
+
+func (s *GitLabStub) handle(w http.ResponseWriter, r *http.Request) {
+	phase := s.Phase(s.now())
+
+	s.mu.Lock()
+	delay := phase.Latency
+	if phase.Jitter > 0 {
+		delay += time.Duration(s.rand.Int64N(int64(phase.Jitter)))
+	}
+	roll := s.rand.Float64()
+	s.mu.Unlock()
+
+	s.sleep(delay)
+
+	token := r.Header.Get("PRIVATE-TOKEN")
+	username, ok := strings.CutPrefix(token, tokenPrefix)
+	switch {
+	case !ok || username == "" || roll < phase.InvalidRate:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "401 Unauthorized"})
+		return
+	case roll < phase.InvalidRate+phase.ErrorRate:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "500 Internal Server Error"})
+		return
+	case roll < phase.InvalidRate+phase.ErrorRate+phase.RateLimitRate:
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"message": "429 Too Many Requests"})
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v4") {
+	case "/user":
+		writeJSON(w, http.StatusOK, map[string]any{"id": 1, "username": username})
+	case "/personal_access_tokens/self":
+		writeJSON(w, http.StatusOK, map[string]any{"id": 1, "name": "soak", "scopes": []string{"read_api"}, "active": true})
+	case "/groups":
+		writeJSON(w, http.StatusOK, []map[string]any{{"id": 1, "full_path": "soak"}})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,220 @@
+package soak
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConnectFunc opens and closes one client connection through the auth
+// callout, returning the connection error, if any.
+type ConnectFunc func(username, token string) error
+
+// Window holds the statistics of one report interval.
+type Window struct {
+	Start    time.Time
+	Phase    string
+	Attempts int
+	Failures int
+	// Skipped counts attempts not made because too many were still in flight.
+	Skipped    int
+	P50        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	HeapAlloc  uint64
+	Goroutines int
+}
+
+// Report is the outcome of a soak run.
+type Report struct {
+	Windows []Window
+}
+
+// Runner drives connections at a constant rate and records latency and
+// memory per report window.
+type Runner struct {
+	cfg     Config
+	connect ConnectFunc
+	stub    *GitLabStub
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	attempts  int
+	failures  int
+	skipped   int
+}
+
+// NewRunner creates a runner; stub is only used to label windows with the
+// active phase and may be nil.
+func NewRunner(cfg Config, connect ConnectFunc, stub *GitLabStub) *Runner {
+	return &Runner{
+		cfg:     cfg,
+		connect: connect,
+		stub:    stub,
+		logger:  slog.With("component", "soak"),
+	}
+}
+
+// Run attempts connections until cfg.Duration elapses or ctx is cancelled.
+func (r *Runner) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Duration)
+	defer cancel()
+
+	// Bound in-flight attempts so a stalled pipeline shows up as skipped
+	// attempts instead of an ever-growing number of goroutines.
+	inflight := make(chan struct{}, r.cfg.ConnectRate*10)
+	var wg sync.WaitGroup
+
+	connectTicker := time.NewTicker(time.Second / time.Duration(r.cfg.ConnectRate))
+	defer connectTicker.Stop()
+	reportTicker := time.NewTicker(r.cfg.ReportInterval)
+	defer reportTicker.Stop()
+
+	var report Report
+	windowStart := time.Now()
+	n := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			// A trailing partial window would skew drift; keep it only
+			// when the run was shorter than one report interval.
+			if len(report.Windows) == 0 {
+				w := r.closeWindow(windowStart)
+				report.Windows = append(report.Windows, w)
+				r.logWindow(w)
+			}
+			return report
+		case <-reportTicker.C:
+			w := r.closeWindow(windowStart)
+			report.Windows = append(report.Windows, w)
+			r.logWindow(w)
+			windowStart = time.Now()
+		case <-connectTicker.C:
+			username := fmt.Sprintf("soak-user-%d", n%r.cfg.Users)
+			n++
+			select {
+			case inflight <- struct{}{}:
+			default:
+				r.mu.Lock()
+				r.skipped++
+				r.mu.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-inflight }()
+				r.attempt(username)
+			}()
+		}
+	}
+}
+
+func (r *Runner) attempt(username string) {
+	start := time.Now()
+	err := r.connect(username, TokenFor(username))
+	elapsed := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	r.latencies = append(r.latencies, elapsed)
+	if err != nil {
+		r.failures++
+	}
+}
+
+// closeWindow summarizes and resets the current window's counters.
+func (r *Runner) closeWindow(start time.Time) Window {
+	r.mu.Lock()
+	latencies := r.latencies
+	w := Window{Start: start, Attempts: r.attempts, Failures: r.failures, Skipped: r.skipped}
+	r.latencies, r.attempts, r.failures, r.skipped = nil, 0, 0, 0
+	r.mu.Unlock()
+
+	if r.stub != nil {
+		w.Phase = r.stub.Phase(start).Name
+	}
+
+	slices.Sort(latencies)
+	w.P50 = percentile(latencies, 0.50)
+	w.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		w.Max = latencies[len(latencies)-1]
+	}
+
+	// Measure the live heap, not garbage waiting for collection.
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	w.HeapAlloc = ms.HeapAlloc
+	w.Goroutines = runtime.NumGoroutine()
+	return w
+}
+
+func (r *Runner) logWindow(w Window) {
+	r.logger.Info("Soak window",
+		"phase", w.Phase,
+		"attempts", w.Attempts,
+		"failures", w.Failures,
+		"skipped", w.Skipped,
+		"p50", w.P50,
+		"p99", w.P99,
+		"max", w.Max,
+		"heap_alloc_bytes", w.HeapAlloc,
+		"goroutines", w.Goroutines,
+	)
+}
+
+// percentile returns the q-quantile of sorted latencies.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(q * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+// Drift compares the last window against the first one of the same phase
+// (so that a slow phase is not mistaken for degradation) and returns the
+// relative change of p99 latency and heap size.
+func (r Report) Drift() (latency, heap float64) {
+	if len(r.Windows) < 2 {
+		return 0, 0
+	}
+	first := r.Windows[0]
+	last := first
+	for _, w := range r.Windows[1:] {
+		if w.Phase == first.Phase {
+			last = w
+		}
+	}
+	if first.P99 > 0 {
+		latency = float64(last.P99-first.P99) / float64(first.P99)
+	}
+	if first.HeapAlloc > 0 {
+		heap = (float64(last.HeapAlloc) - float64(first.HeapAlloc)) / float64(first.HeapAlloc)
+	}
+	return latency, heap
+}
+
+// Violations lists the drift limits from cfg exceeded by the report.
+func (r Report) Violations(cfg Config) []string {
+	latency, heap := r.Drift()
+
+	var out []string
+	if cfg.MaxLatencyDrift > 0 && latency > cfg.MaxLatencyDrift {
+		out = append(out, fmt.Sprintf("p99 latency drift %+.0f%% exceeds %.0f%%", latency*100, cfg.MaxLatencyDrift*100))
+	}
+	if cfg.MaxHeapGrowth > 0 && heap > cfg.MaxHeapGrowth {
+		out = append(out, fmt.Sprintf("heap growth %+.0f%% exceeds %.0f%%", heap*100, cfg.MaxHeapGrowth*100))
+	}
+	return out
+}
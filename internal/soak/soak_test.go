@@ -0,0 +1,163 @@
+package soak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("soak.duration", "2h")
+	viper.Set("soak.report_interval", "30s")
+	viper.Set("soak.connect_rate", 5)
+	viper.Set("soak.users", 10)
+	viper.Set("soak.profile", []map[string]any{
+		{"name": "slow", "duration": "1m", "latency": "500ms", "error_rate": 0.1},
+	})
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, cfg.Duration)
+	assert.Equal(t, 30*time.Second, cfg.ReportInterval)
+	require.Len(t, cfg.Profile, 1)
+	assert.Equal(t, Phase{Name: "slow", Duration: time.Minute, Latency: 500 * time.Millisecond, ErrorRate: 0.1}, cfg.Profile[0])
+
+	viper.Set("soak.profile", []map[string]any{{"name": "broken", "duration": "1m", "error_rate": 0.8, "invalid_rate": 0.5}})
+	_, err = LoadConfig()
+	assert.ErrorContains(t, err, "broken")
+}
+
+func TestLoadConfig_DefaultProfile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("soak.duration", "1h")
+	viper.Set("soak.report_interval", "1m")
+	viper.Set("soak.connect_rate", 1)
+	viper.Set("soak.users", 1)
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, defaultProfile, cfg.Profile)
+}
+
+func TestGitLabStub_Phase(t *testing.T) {
+	stub := NewGitLabStub([]Phase{
+		{Name: "a", Duration: time.Minute},
+		{Name: "b", Duration: 2 * time.Minute},
+	})
+	defer stub.Close()
+
+	start := stub.started
+	assert.Equal(t, "a", stub.Phase(start).Name)
+	assert.Equal(t, "b", stub.Phase(start.Add(90*time.Second)).Name)
+	assert.Equal(t, "a", stub.Phase(start.Add(3*time.Minute)).Name, "profile repeats")
+}
+
+func stubGet(t *testing.T, stub *GitLabStub, path, token string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, stub.URL()+"/api/v4"+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("PRIVATE-TOKEN", token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestGitLabStub_Responses(t *testing.T) {
+	t.Run("healthy phase accepts stub tokens", func(t *testing.T) {
+		stub := NewGitLabStub([]Phase{{Name: "ok", Duration: time.Hour}})
+		defer stub.Close()
+
+		assert.Equal(t, http.StatusOK, stubGet(t, stub, "/user", TokenFor("alice")))
+		assert.Equal(t, http.StatusOK, stubGet(t, stub, "/personal_access_tokens/self", TokenFor("alice")))
+		assert.Equal(t, http.StatusUnauthorized, stubGet(t, stub, "/user", "not-a-stub-token"))
+	})
+
+	t.Run("failure rates are applied", func(t *testing.T) {
+		for _, tt := range []struct {
+			phase Phase
+			want  int
+		}{
+			{Phase{Duration: time.Hour, ErrorRate: 1}, http.StatusInternalServerError},
+			{Phase{Duration: time.Hour, RateLimitRate: 1}, http.StatusTooManyRequests},
+			{Phase{Duration: time.Hour, InvalidRate: 1}, http.StatusUnauthorized},
+		} {
+			stub := NewGitLabStub([]Phase{tt.phase})
+			assert.Equal(t, tt.want, stubGet(t, stub, "/user", TokenFor("alice")))
+			stub.Close()
+		}
+	})
+
+	t.Run("latency is applied", func(t *testing.T) {
+		stub := NewGitLabStub([]Phase{{Duration: time.Hour, Latency: 100 * time.Millisecond}})
+		defer stub.Close()
+		var slept time.Duration
+		stub.sleep = func(d time.Duration) { slept = d }
+
+		stubGet(t, stub, "/user", TokenFor("alice"))
+		assert.Equal(t, 100*time.Millisecond, slept)
+	})
+}
+
+func TestRunner_Run(t *testing.T) {
+	cfg := Config{
+		Duration:       350 * time.Millisecond,
+		ReportInterval: 100 * time.Millisecond,
+		ConnectRate:    200,
+		Users:          3,
+	}
+
+	seen := make(chan string, 1000)
+	connect := func(username, token string) error {
+		seen <- username
+		if token != TokenFor(username) {
+			return errors.New("wrong token")
+		}
+		if username == "soak-user-2" {
+			return errors.New("denied")
+		}
+		return nil
+	}
+
+	report := NewRunner(cfg, connect, nil).Run(context.Background())
+	require.GreaterOrEqual(t, len(report.Windows), 2)
+
+	attempts, failures := 0, 0
+	for _, w := range report.Windows {
+		attempts += w.Attempts
+		failures += w.Failures
+		assert.NotZero(t, w.HeapAlloc)
+	}
+	assert.Positive(t, attempts)
+	assert.InDelta(t, attempts/3, failures, 2, "one of three users is denied")
+	// The trailing partial window is not reported.
+	assert.GreaterOrEqual(t, len(seen), attempts)
+}
+
+func TestReport_DriftAndViolations(t *testing.T) {
+	report := Report{Windows: []Window{
+		{Phase: "steady", P99: 100 * time.Millisecond, HeapAlloc: 1000},
+		{Phase: "steady", P99: 180 * time.Millisecond, HeapAlloc: 1200},
+		// A slower phase is not compared against the steady baseline.
+		{Phase: "slow", P99: time.Second, HeapAlloc: 1300},
+	}}
+
+	latency, heap := report.Drift()
+	assert.InDelta(t, 0.8, latency, 0.001)
+	assert.InDelta(t, 0.2, heap, 0.001)
+
+	assert.Empty(t, report.Violations(Config{MaxLatencyDrift: 1, MaxHeapGrowth: 0.5}))
+	assert.Len(t, report.Violations(Config{MaxLatencyDrift: 0.5, MaxHeapGrowth: 0.1}), 2)
+	assert.Empty(t, report.Violations(Config{}), "zero disables the checks")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/pflag"
+)
+
+const keysUsage = `Usage: antal keys generate [--json]
+
+Generates the keys of a new deployment: the issuer account nkey that signs
+user JWTs and the curve xkey that encrypts auth callout requests. Prints the
+seeds for config.yaml and the public keys for the auth_callout block of the
+NATS server config; with --json, all four as JSON.
+
+The seeds are secrets: redirect the output to a protected file or a secret
+store, not to a shared terminal log.
+`
+
+// generatedKeys is the output of `antal keys generate`.
+type generatedKeys struct {
+	IssuerSeed string `json:"issuer_seed"`
+	Issuer     string `json:"issuer"`
+	XKeySeed   string `json:"xkey_seed"`
+	XKey       string `json:"xkey"`
+}
+
+// runKeys implements `antal keys`, replacing the nk/nsc steps of the setup.
+// It needs no config file. It returns the process exit code.
+func runKeys(args []string) int {
+	if len(args) != 1 || args[0] != "generate" {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	keys, err := generateKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate keys: %v\n", err)
+		return 1
+	}
+	if asJSON, _ := pflag.CommandLine.GetBool("json"); asJSON {
+		err = writeKeysJSON(os.Stdout, keys)
+	} else {
+		err = writeKeysText(os.Stdout, keys)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write keys: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// generateKeys creates a new issuer account key pair and a new curve key pair.
+func generateKeys() (generatedKeys, error) {
+	var keys generatedKeys
+	issuer, err := nkeys.CreateAccount()
+	if err != nil {
+		return keys, fmt.Errorf("issuer: %w", err)
+	}
+	if keys.IssuerSeed, keys.Issuer, err = seedAndPublicKey(issuer); err != nil {
+		return keys, fmt.Errorf("issuer: %w", err)
+	}
+	xkey, err := nkeys.CreateCurveKeys()
+	if err != nil {
+		return keys, fmt.Errorf("xkey: %w", err)
+	}
+	if keys.XKeySeed, keys.XKey, err = seedAndPublicKey(xkey); err != nil {
+		return keys, fmt.Errorf("xkey: %w", err)
+	}
+	return keys, nil
+}
+
+func seedAndPublicKey(kp nkeys.KeyPair) (string, string, error) {
+	seed, err := kp.Seed()
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return "", "", err
+	}
+	return string(seed), pub, nil
+}
+
+func writeKeysJSON(out io.Writer, keys generatedKeys) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(keys)
+}
+
+var keysText = template.Must(template.New("keys").Parse(`# config.yaml (secret; or nats.issuer_seed_file / nats.xkey_seed_file)
+nats:
+  issuer_seed: "{{.IssuerSeed}}"
+  xkey_seed: "{{.XKeySeed}}"
+
+# nats-server.conf
+authorization {
+  auth_callout {
+    issuer: "{{.Issuer}}"
+    xkey: "{{.XKey}}"
+    # The account and user of Antal's NATS connection (see antal info)
+    account: "SYS"
+    auth_users: ["nats_auth_user"]
+  }
+}
+`))
+
+func writeKeysText(out io.Writer, keys generatedKeys) error {
+	return keysText.Execute(out, keys)
+}
@@ -4,18 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/getsentry/sentry-go"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"git.sgw.equipment/restricted/gcs_antal/internal/auth"
+	"git.sgw.equipment/restricted/gcs_antal/internal/config"
+	"git.sgw.equipment/restricted/gcs_antal/internal/lifecycle"
+	"git.sgw.equipment/restricted/gcs_antal/internal/sentry"
 	"git.sgw.equipment/restricted/gcs_antal/internal/server"
 )
 
@@ -27,6 +33,13 @@ func init() {
 	// Define command line flags
 	pflag.String("config", "", "Path to config file")
 	pflag.Bool("version", false, "Display version information")
+	pflag.String("creds", "", "NATS credentials file for maintenance commands (antal cache)")
+	pflag.String("from", "", "Audit file to replay (antal replay)")
+	pflag.String("tokens", "", "File mapping usernames to replay tokens (antal replay)")
+	pflag.String("rate", "original", "Replay rate: original, <n>/s or <n>/m (antal replay)")
+	pflag.Bool("json", false, "Print JSON for provisioning tools (antal info, antal keys)")
+	pflag.String("since", "", "Export records from this RFC 3339 time or duration back (antal audit export)")
+	pflag.Bool("stats", false, "Export the daily usage stats instead of the events (antal audit export)")
 	pflag.Parse()
 
 	// Check if a version flag is passed
@@ -35,6 +48,16 @@ func init() {
 		os.Exit(0)
 	}
 
+	// The schema describes the config file, so it must not need one
+	if pflag.Arg(0) == "schema" {
+		os.Exit(printSchema())
+	}
+
+	// New keys do not depend on any config either
+	if pflag.Arg(0) == "keys" {
+		os.Exit(runKeys(pflag.Args()[1:]))
+	}
+
 	// Bind command line flags to viper
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
 		slog.Error("Failed to bind command line flags", "error", err)
@@ -47,12 +70,221 @@ func init() {
 	viper.AddConfigPath(".")
 	viper.AutomaticEnv()
 
+	// Server and NATS handoff defaults (binary upgrades)
+	viper.SetDefault("server.reuse_port", false)
+	viper.SetDefault("nats.drain_timeout", "5s")
+	viper.SetDefault("nats.kv_connection", "shared")
+	viper.SetDefault("nats.kv_retry.attempts", 3)
+	viper.SetDefault("nats.kv_retry.backoff", "100ms")
+	viper.SetDefault("nats.server_compat", "2.11")
+	viper.SetDefault("health.max_auth_idle", "0s")
+
+	// Authorization defaults
+	viper.SetDefault("auth.monitor_only", false)
+	viper.SetDefault("auth.scope_policy", "warn")
+	viper.SetDefault("auth.max_allowed_scopes", []string{})
+	viper.SetDefault("auth.forbidden_scopes", []string{"api", "sudo"})
+	viper.SetDefault("auth.callout_timeout", "2s")
+	viper.SetDefault("auth.stale_requests", "process")
+	viper.SetDefault("auth.response_reserve", "250ms")
+	viper.SetDefault("auth.max_jwts_per_minute", 0)
+	viper.SetDefault("auth.max_attempts_per_minute", 0)
+	viper.SetDefault("auth.max_attempts_per_ip_per_minute", 0)
+	viper.SetDefault("auth.password_detection", "off")
+	viper.SetDefault("auth.identity", "username")
+	viper.SetDefault("auth.empty_username", "derive")
+	viper.SetDefault("auth.require_token_owner", true)
+	viper.SetDefault("auth.unresolved_templates", "error")
+
+	// Issued JWT cache defaults
+	viper.SetDefault("jwt_cache.enabled", false)
+	viper.SetDefault("jwt_cache.ttl", "30s")
+	viper.SetDefault("jwt_cache.max_entries", 10000)
+
+	// Permission probe (antal.can-i) defaults
+	viper.SetDefault("can_i.enabled", false)
+	viper.SetDefault("can_i.subject", "antal.can-i")
+	viper.SetDefault("can_i.max_users", 10000)
+
+	// Token check defaults
+	viper.SetDefault("token_check.enabled", false)
+	viper.SetDefault("token_check.max_checks_per_ip_per_minute", 10)
+
+	// Replica key comparison defaults
+	viper.SetDefault("replica_check.enabled", true)
+	viper.SetDefault("replica_check.cluster", "")
+	viper.SetDefault("replica_check.wait", "5s")
+
+	// Trusted server defaults
+	viper.SetDefault("trusted_servers.enabled", false)
+
+	// GitLab defaults
+	viper.SetDefault("gitlab.rateLimitPauseSeconds", 30)
+	viper.SetDefault("gitlab.circuitBreakerFailures", 5)
+	viper.SetDefault("gitlab.circuitBreakerOpenSeconds", 30)
+	viper.SetDefault("gitlab.shadow.enabled", false)
+	viper.SetDefault("gitlab.shadow.timeout", "10s")
+	viper.SetDefault("gitlab.shadow.max_concurrent", 20)
+
+	// Sentry defaults
+	viper.SetDefault("sentry.breadcrumbs_per_second", 10)
+	viper.SetDefault("sentry.trace_override_max", "1h")
+
+	// Logging defaults
+	viper.SetDefault("logging.format", "text")
+	viper.SetDefault("logging.claims_users", []string{})
+
 	// Token cache (JetStream KV) defaults
 	viper.SetDefault("token_cache.enabled", false)
 	viper.SetDefault("token_cache.ttl", "24h")
 	viper.SetDefault("token_cache.bucket", "gitlab_token_cache")
 	viper.SetDefault("token_cache.replicas", 3)
 	viper.SetDefault("token_cache.hmac_secret", "")
+	viper.SetDefault("token_cache.hmac_secret_file", "")
+	viper.SetDefault("token_cache.write_queue.size", 0)
+	viper.SetDefault("token_cache.write_queue.batch_size", 32)
+	viper.SetDefault("token_cache.memory.size", 0)
+	viper.SetDefault("token_cache.memory.ttl", "5s")
+	viper.SetDefault("token_cache.get_timeout", "1s")
+	viper.SetDefault("token_cache.put_timeout", "2s")
+	viper.SetDefault("token_cache.hedge_delay", "0s")
+	viper.SetDefault("token_cache.fallback_alert.threshold", 0.2)
+	viper.SetDefault("token_cache.fallback_alert.window", "5m")
+	viper.SetDefault("token_cache.fallback_alert.min_verifications", 20)
+
+	// Deploy token defaults
+	viper.SetDefault("deploy_tokens.enabled", false)
+	viper.SetDefault("deploy_tokens.admin_token", "")
+	viper.SetDefault("deploy_tokens.admin_token_file", "")
+
+	// CI/CD job token defaults
+	viper.SetDefault("ci_job_tokens.enabled", false)
+
+	// Policy hook (WebAssembly module) defaults
+	viper.SetDefault("policy_hook.module", "")
+	viper.SetDefault("policy_hook.timeout", "50ms")
+	viper.SetDefault("policy_hook.on_error", "deny")
+
+	// Config overrides (JetStream KV) defaults
+	viper.SetDefault("config_overrides.enabled", false)
+	viper.SetDefault("config_overrides.bucket", "antal_config_overrides")
+	viper.SetDefault("config_overrides.replicas", 3)
+	viper.SetDefault("migrations.enabled", true)
+	viper.SetDefault("migrations.bucket", "antal_schema")
+	viper.SetDefault("migrations.replicas", 3)
+	viper.SetDefault("migrations.lock_ttl", "5m")
+	viper.SetDefault("migrations.wait", "2m")
+
+	// Self-service access requests (GitLab issues) defaults
+	viper.SetDefault("access_requests.enabled", false)
+	viper.SetDefault("access_requests.label", "nats-access")
+	viper.SetDefault("access_requests.approved_label", "nats-access::approved")
+	viper.SetDefault("access_requests.interval", "5m")
+	viper.SetDefault("access_requests.bucket", "antal_user_grants")
+	viper.SetDefault("access_requests.replicas", 3)
+
+	// Platform account defaults (coordination uses the auth connection)
+	viper.SetDefault("platform.enabled", false)
+
+	// Canary authentication probe defaults
+	viper.SetDefault("probe.enabled", false)
+	viper.SetDefault("probe.interval", "1m")
+	viper.SetDefault("probe.timeout", "5s")
+
+	// Kafka audit sink defaults
+	viper.SetDefault("audit.kafka.enabled", false)
+	viper.SetDefault("audit.kafka.topic", "gcs_antal.audit")
+	viper.SetDefault("audit.kafka.decisions", true)
+	viper.SetDefault("audit.file.enabled", false)
+	viper.SetDefault("audit.file.path", "audit.jsonl")
+	viper.SetDefault("audit.file.decisions", true)
+	viper.SetDefault("audit.nats.enabled", false)
+	viper.SetDefault("audit.nats.subject", "antal.internal.audit")
+	viper.SetDefault("audit.nats.decisions", true)
+	viper.SetDefault("audit.sqlite.enabled", false)
+	viper.SetDefault("audit.sqlite.path", "audit.db")
+	viper.SetDefault("audit.sqlite.decisions", true)
+	viper.SetDefault("audit.sqlite.retention", "2160h")
+	viper.SetDefault("audit.sqlite.stats_retention", "8760h")
+
+	// JWT signer defaults (local issuer seed)
+	viper.SetDefault("signer.type", "local")
+	viper.SetDefault("signer.timeout", "2s")
+
+	// Account connection budget defaults
+	viper.SetDefault("account_budget.mode", "off")
+	viper.SetDefault("account_budget.refresh", "5s")
+	viper.SetDefault("account_budget.request_timeout", "1s")
+	viper.SetDefault("load_shedding.enabled", false)
+	viper.SetDefault("load_shedding.queue_depth", 500)
+	viper.SetDefault("load_shedding.percent", 50)
+	viper.SetDefault("load_shedding.retry_after", "5s")
+
+	viper.SetDefault("lockout.enabled", false)
+	viper.SetDefault("lockout.max_failures", 5)
+	viper.SetDefault("lockout.window", "10m")
+	viper.SetDefault("lockout.duration", "15m")
+	viper.SetDefault("lockout.bucket", "antal_lockouts")
+	viper.SetDefault("lockout.replicas", 3)
+	viper.SetDefault("standby.enabled", false)
+	viper.SetDefault("standby.bucket", "antal_standby")
+	viper.SetDefault("standby.replicas", 3)
+	viper.SetDefault("standby.heartbeat", "2s")
+	viper.SetDefault("standby.timeout", "10s")
+	viper.SetDefault("config_reload.watch", false)
+	viper.SetDefault("config_reload.changelog.enabled", false)
+	viper.SetDefault("config_reload.changelog.bucket", "antal_config_changelog")
+	viper.SetDefault("config_reload.changelog.replicas", 3)
+	viper.SetDefault("config_reload.changelog.max_age", "2160h")
+	viper.SetDefault("config_reload.drift.enabled", false)
+	viper.SetDefault("config_reload.drift.bucket", "antal_config_hashes")
+	viper.SetDefault("config_reload.drift.replicas", 3)
+	viper.SetDefault("config_reload.drift.interval", "30s")
+	viper.SetDefault("config_reload.drift.grace_period", "5m")
+	viper.SetDefault("deny_messages.help_url", "")
+
+	// Subject usage feedback defaults
+	viper.SetDefault("subject_usage.enabled", false)
+	viper.SetDefault("subject_usage.interval", "5m")
+	viper.SetDefault("subject_usage.report_interval", "24h")
+	viper.SetDefault("subject_usage.request_timeout", "5s")
+
+	// Issuer key rotation defaults
+	viper.SetDefault("issuer_rotation.request_timeout", "5s")
+
+	viper.SetDefault("key_usage.enabled", false)
+	viper.SetDefault("key_usage.spike_factor", 5)
+	viper.SetDefault("key_usage.spike_min_per_minute", 50)
+	viper.SetDefault("key_usage.baseline_minutes", 60)
+	viper.SetDefault("key_usage.business_hours", "")
+	viper.SetDefault("key_usage.business_days", []string{"mon", "tue", "wed", "thu", "fri"})
+	viper.SetDefault("key_usage.timezone", "UTC")
+	viper.SetDefault("key_usage.off_hours_max_per_minute", 0)
+	viper.SetDefault("key_usage.alert_interval", "15m")
+
+	// Vault secrets provider defaults
+	viper.SetDefault("vault.enabled", false)
+	viper.SetDefault("vault.auth", "token")
+	viper.SetDefault("vault.approle_mount", "approle")
+	viper.SetDefault("vault.mount", "secret")
+	viper.SetDefault("vault.timeout", "5s")
+
+	// Age key of encrypted config values (defaults to SOPS_AGE_KEY_FILE)
+	viper.SetDefault("encryption.age_key_file", "")
+
+	// Admin API defaults (disabled without a token)
+	viper.SetDefault("admin.token", "")
+
+	// Soak test (`antal soak`) defaults
+	viper.SetDefault("soak.duration", "4h")
+	viper.SetDefault("soak.report_interval", "1m")
+	viper.SetDefault("soak.connect_rate", 20)
+	viper.SetDefault("soak.users", 500)
+	viper.SetDefault("soak.max_latency_drift", 0.5)
+	viper.SetDefault("soak.max_heap_growth", 0.5)
+
+	// Replay (`antal replay`) defaults
+	viper.SetDefault("replay.concurrency", 100)
 
 	// Use custom a config file if specified
 	if configFile := viper.GetString("config"); configFile != "" {
@@ -75,28 +307,54 @@ func init() {
 		slog.Info("Config loaded successfully", "file", viper.ConfigFileUsed())
 	}
 
+	// Read secrets mounted as files (nats.pass_file, nats.issuer_seed_file, ...)
+	if err := config.LoadSecretFiles(); err != nil {
+		slog.Error("Failed to read secret file", "error", err)
+		os.Exit(1)
+	}
+
+	// Decrypt age-encrypted values, so the config file can be kept in git
+	if keys, err := config.LoadEncryptedValues(); err != nil {
+		slog.Error("Failed to decrypt config values", "error", err)
+		os.Exit(1)
+	} else if len(keys) > 0 {
+		slog.Info("Encrypted config values decrypted", "keys", keys)
+	}
+
+	// Optional: fetch the issuer seed, xkey seed and HMAC secret from Vault
+	if keys, err := config.LoadVaultSecrets(context.Background()); err != nil {
+		slog.Error("Failed to load secrets from Vault", "error", err)
+		os.Exit(1)
+	} else if len(keys) > 0 {
+		slog.Info("Secrets loaded from Vault", "keys", keys)
+	}
+
 	// Configure logging
-	logLevel := slog.LevelInfo
-	if levelStr := viper.GetString("logging.level"); levelStr != "" {
-		switch levelStr {
-		case "debug":
-			logLevel = slog.LevelDebug
-		case "info":
-			logLevel = slog.LevelInfo
-		case "warn":
-			logLevel = slog.LevelWarn
-		case "error":
-			logLevel = slog.LevelError
-		}
+	if level, ok := parseLogLevel(viper.GetString("logging.level")); ok {
+		logLevel.Set(level)
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
+	// antal info prints for provisioning tools on stdout, so it logs to stderr
+	logOutput := os.Stdout
+	if pflag.Arg(0) == "info" {
+		logOutput = os.Stderr
+	}
+	handler, formatOK := newLogHandler(logOutput, viper.GetString("logging.format"), &slog.HandlerOptions{
+		Level: &logLevel,
 	})
 	slog.SetDefault(slog.New(handler))
+	if !formatOK {
+		slog.Warn("Unknown logging.format, logging as text", "format", viper.GetString("logging.format"))
+	}
 
-	// Initialize Sentry if configured
-	if dsn := viper.GetString("sentry.dsn"); dsn != "" {
+	// The log level can be overridden at runtime via KV config overrides
+	auth.OnConfigOverride("logging.level", func(value any) {
+		level, _ := parseLogLevel(fmt.Sprint(value))
+		logLevel.Set(level)
+	})
+
+	// Initialize Sentry if configured and compiled in
+	if dsn := viper.GetString("sentry.dsn"); dsn != "" && sentry.Available {
 		err := sentry.Init(sentry.ClientOptions{
 			Dsn:              dsn,
 			Environment:      viper.GetString("sentry.environment"),
@@ -104,6 +362,7 @@ func init() {
 			EnableTracing:    viper.GetBool("sentry.enable_tracing"),
 			Debug:            viper.GetBool("sentry.debug"),
 			AttachStacktrace: true,
+			BeforeBreadcrumb: breadcrumbLimiter(viper.GetInt("sentry.breadcrumbs_per_second")),
 		})
 		if err != nil {
 			slog.Error("Failed to initialize Sentry", "error", err)
@@ -126,12 +385,89 @@ func init() {
 				})
 			}
 		}
+	} else if dsn != "" {
+		slog.Warn("Sentry DSN ignored, built without Sentry support (nosentry) - error tracking disabled")
 	} else {
 		slog.Warn("Sentry DSN not provided - error tracking disabled")
 	}
 }
 
+// breadcrumbLimiter drops breadcrumbs beyond perSecond per category, so a
+// burst of auth requests cannot push the context of an error out of the
+// breadcrumb buffer. A limit <= 0 keeps every breadcrumb.
+func breadcrumbLimiter(perSecond int) func(*sentry.Breadcrumb, *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+	var mu sync.Mutex
+	window := time.Now()
+	counts := map[string]int{}
+	return func(b *sentry.Breadcrumb, _ *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+		if perSecond <= 0 {
+			return b
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if now := time.Now(); now.Sub(window) >= time.Second {
+			window = now
+			clear(counts)
+		}
+		counts[b.Category]++
+		if counts[b.Category] > perSecond {
+			return nil
+		}
+		return b
+	}
+}
+
+// logLevel is the active log level; it can change at runtime.
+var logLevel slog.LevelVar
+
+// newLogHandler returns the slog handler of a logging.format value: json for
+// log collectors such as Loki or ELK, text otherwise. Unknown values log as
+// text and report false.
+func newLogHandler(w io.Writer, format string, opts *slog.HandlerOptions) (slog.Handler, bool) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "json":
+		return slog.NewJSONHandler(w, opts), true
+	case "", "text":
+		return slog.NewTextHandler(w, opts), true
+	}
+	return slog.NewTextHandler(w, opts), false
+}
+
+// parseLogLevel maps a logging.level value to a slog level. Unknown values
+// map to info and report false.
+func parseLogLevel(levelStr string) (slog.Level, bool) {
+	switch levelStr {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
 func main() {
+	switch cmd := pflag.Arg(0); cmd {
+	case "":
+		// No command: run the auth callout service.
+	case "soak":
+		os.Exit(runSoak())
+	case "cache":
+		os.Exit(runCache(pflag.Args()[1:]))
+	case "replay":
+		os.Exit(runReplay())
+	case "info":
+		os.Exit(runInfo())
+	case "audit":
+		os.Exit(runAudit(pflag.Args()[1:]))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q (available: soak, cache, replay, info, audit, schema, keys)\n", cmd)
+		os.Exit(2)
+	}
+
 	logger := slog.With("component", "main")
 	logger.Info("Starting GCS Antal, a NATS-GitLab Authentication Service", "version", version)
 
@@ -146,6 +482,71 @@ func main() {
 		// No CaptureMessage here to prevent noise in Sentry
 	}
 
+	// Validate the configuration against its typed description
+	if _, unknown, err := config.Load(); err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	} else if len(unknown) > 0 {
+		logger.Warn("Unknown configuration keys (see `antal schema`)", "keys", unknown)
+	}
+
+	// Subsystems are started in registration order and stopped in reverse.
+	lc := lifecycle.New()
+
+	// Registered first so queued Sentry events are flushed last.
+	lc.Register(lifecycle.Hook{
+		Name: "sentry",
+		Stop: func(context.Context) error {
+			sentry.Flush(2 * time.Second)
+			return nil
+		},
+	})
+
+	// Optional: export audit events and decisions to Kafka
+	if kafkaCfg := auth.LoadKafkaSinkConfig(); kafkaCfg.Enabled {
+		kafkaSink, err := auth.NewKafkaAuditSink(kafkaCfg)
+		if err != nil {
+			logger.Error("Failed to create Kafka audit sink", "error", err)
+			os.Exit(1)
+		}
+		auth.AddAuditSink(kafkaSink)
+		// Stopped after the NATS client, so the last decisions are delivered.
+		lc.Register(lifecycle.Hook{
+			Name: "kafka_audit_sink",
+			Stop: func(context.Context) error { return kafkaSink.Close() },
+		})
+	}
+
+	// Optional: append audit events and decisions to a JSON Lines file
+	if fileCfg := auth.LoadFileSinkConfig(); fileCfg.Enabled {
+		fileSink, err := auth.NewFileAuditSink(fileCfg)
+		if err != nil {
+			logger.Error("Failed to create file audit sink", "error", err)
+			os.Exit(1)
+		}
+		auth.AddAuditSink(fileSink)
+		// Stopped after the NATS client, so the last decisions are written.
+		lc.Register(lifecycle.Hook{
+			Name: "file_audit_sink",
+			Stop: func(context.Context) error { return fileSink.Close() },
+		})
+	}
+
+	// Optional: keep audit events and usage stats in an embedded SQLite store
+	if sqliteCfg := auth.LoadSQLiteSinkConfig(); sqliteCfg.Enabled {
+		sqliteSink, err := auth.NewSQLiteAuditSink(sqliteCfg)
+		if err != nil {
+			logger.Error("Failed to open SQLite audit store", "error", err)
+			os.Exit(1)
+		}
+		auth.AddAuditSink(sqliteSink)
+		// Stopped after the NATS client, so the last decisions are stored.
+		lc.Register(lifecycle.Hook{
+			Name: "sqlite_audit_sink",
+			Stop: func(context.Context) error { return sqliteSink.Close() },
+		})
+	}
+
 	// Create a GitLab client
 	gitlabClient := auth.NewGitLabClient()
 
@@ -162,50 +563,148 @@ func main() {
 		logger.Error("Failed to create NATS client", "error", err)
 		os.Exit(1)
 	}
+	lc.Register(lifecycle.Hook{
+		Name:  "nats_client",
+		Start: func(context.Context) error { return natsClient.Start() },
+		Stop: func(context.Context) error {
+			natsClient.Stop()
+			return nil
+		},
+	})
 
-	// Start the NATS client
-	if err := natsClient.Start(); err != nil {
-		logger.Error("Failed to start NATS client", "error", err)
-		os.Exit(1)
+	// Optional: end-to-end canary authentication probe, stopped before the
+	// service it probes
+	if probeCfg := auth.LoadProbeConfig(); probeCfg.Enabled {
+		prober, err := auth.NewProber(probeCfg)
+		if err != nil {
+			logger.Error("Failed to create canary probe", "error", err)
+			os.Exit(1)
+		}
+		lc.Register(lifecycle.Hook{
+			Name: "probe",
+			Start: func(context.Context) error {
+				prober.Start()
+				return nil
+			},
+			Stop: func(context.Context) error {
+				prober.Stop()
+				return nil
+			},
+		})
 	}
 
 	// Create an HTTP server
+	allowedSources, err := server.ParseAllowedSources(viper.GetStringSlice("server.allowed_cidrs"))
+	if err != nil {
+		logger.Error("Invalid server.allowed_cidrs", "error", err)
+		os.Exit(1)
+	}
 	srv := server.NewServer(
 		viper.GetString("server.host"),
 		viper.GetInt("server.port"),
 		time.Duration(viper.GetInt("server.timeout"))*time.Second,
+		server.WithHosts(viper.GetStringSlice("server.hosts")...),
+		server.WithAllowedSources(allowedSources...),
+		server.WithReusePort(viper.GetBool("server.reuse_port")),
 	)
 
-	// Start an HTTP server in a goroutine
+	srv.Handle("/info/schema", config.SchemaHandler())
+	srv.Handle("/token/check", natsClient.TokenCheckHandler())
+	srv.Handle("/ready", natsClient.ReadinessHandler())
+
+	// Config reloads (SIGHUP, file changes, admin API) run one at a time
+	var reloadMu sync.Mutex
+	reloadConfig := func(source auth.ReloadSource) error {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		logger.Info("Reloading config", "trigger", source.Trigger, "actor", source.Actor, "file", viper.ConfigFileUsed())
+		if err := natsClient.ReloadConfig(source); err != nil {
+			logger.Error("Config reload failed", "error", err)
+			sentry.CaptureException(err)
+			return err
+		}
+		if level, ok := parseLogLevel(viper.GetString("logging.level")); ok {
+			logLevel.Set(level)
+		}
+		return nil
+	}
+
+	// Admin endpoints are only exposed when an admin token is configured
+	if adminToken := viper.GetString("admin.token"); adminToken != "" {
+		srv.Handle("/admin/issuer/rotate", server.RequireBearerToken(adminToken, natsClient.IssuerRotationHandler()))
+		srv.Handle("/admin/token_cache/report", server.RequireBearerToken(adminToken, natsClient.TokenCacheReportHandler()))
+		srv.Handle("/admin/token_cache/invalidate", server.RequireBearerToken(adminToken, natsClient.TokenCacheInvalidateHandler()))
+		srv.Handle("/admin/subject_usage", server.RequireBearerToken(adminToken, natsClient.SubjectUsageHandler()))
+		srv.Handle("/admin/config/reload", server.RequireBearerToken(adminToken, auth.ConfigReloadHandler(reloadConfig)))
+		srv.Handle("/admin/config/changelog", server.RequireBearerToken(adminToken, natsClient.ConfigChangelogHandler()))
+		srv.Handle("/admin/config/drift", server.RequireBearerToken(adminToken, natsClient.ConfigDriftHandler()))
+		logger.Info("Admin API enabled")
+	} else {
+		logger.Info("Admin API disabled (admin.token not set)")
+	}
+
+	lc.Register(lifecycle.Hook{
+		Name: "http_server",
+		Start: func(context.Context) error {
+			go func() {
+				if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					lc.Fail("http_server", err)
+				}
+			}()
+			return nil
+		},
+		Stop: srv.Stop,
+	})
+
+	if err := lc.Start(context.Background()); err != nil {
+		logger.Error("Failed to start", "error", err)
+		os.Exit(1)
+	}
+
+	// Re-read the config file on SIGHUP and, optionally, whenever it changes
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
 	go func() {
-		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Error("Failed to start HTTP server", "error", err)
-			os.Exit(1)
+		for range hangups {
+			_ = reloadConfig(auth.ReloadSource{Trigger: auth.ReloadTriggerSIGHUP})
 		}
 	}()
+	if viper.GetBool("config_reload.watch") {
+		viper.OnConfigChange(func(fsnotify.Event) { _ = reloadConfig(auth.ReloadSource{Trigger: auth.ReloadTriggerFileChange}) })
+		viper.WatchConfig()
+	}
 
-	// Set up signal handling for graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	// Wait for the interrupt signal
-	<-quit
+	// Run until SIGINT/SIGTERM or until a subsystem fails
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	failure := lc.Wait(signalCtx)
+	if failure != nil {
+		logger.Error("Subsystem failed, shutting down", "error", failure)
+	}
 	logger.Info("Shutting down server...")
 
 	// Create context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Stop HTTP server
-	if err := srv.Stop(ctx); err != nil {
-		logger.Error("Server shutdown failed", "error", err)
+	if err := lc.Stop(ctx); err != nil {
+		logger.Error("Shutdown incomplete", "error", err)
+	}
+	if failure != nil {
+		os.Exit(1)
 	}
-
-	// Stop NATS client
-	natsClient.Stop()
-
-	// Flush sentry events
-	sentry.Flush(2 * time.Second)
 
 	logger.Info("Server exited properly")
 }
+
+// printSchema writes the JSON Schema of the config file to stdout.
+func printSchema() int {
+	schema, err := config.Schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate schema: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(schema))
+	return 0
+}
@@ -0,0 +1,83 @@
+// Package antalclient helps Go applications connect to a NATS cluster that
+// uses GCS Antal for auth callout, authenticating with a GitLab username and
+// Personal Access Token (PAT).
+//
+// Typical usage:
+//
+//	nc, err := antalclient.Connect("nats://nats.example:4222", "alice", os.Getenv("GITLAB_TOKEN"))
+//	if err != nil {
+//		log.Fatal(antalclient.Explain(err))
+//	}
+package antalclient
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	// ErrMissingToken is returned when no GitLab token was provided.
+	ErrMissingToken = errors.New("antalclient: GitLab token is empty")
+	// ErrMissingUsername is returned when no GitLab username was provided.
+	ErrMissingUsername = errors.New("antalclient: GitLab username is empty")
+)
+
+// Connect connects to NATS using the GitLab username and PAT as user
+// credentials. Recommended reconnect options are applied first, so any
+// options passed by the caller take precedence.
+func Connect(url, username, token string, opts ...nats.Option) (*nats.Conn, error) {
+	all, err := Options(username, token)
+	if err != nil {
+		return nil, err
+	}
+	return nats.Connect(url, append(all, opts...)...)
+}
+
+// Options returns the connection options Antal clients should use:
+// the GitLab credentials plus reconnect settings that play well with the
+// auth callout. Reconnects are jittered so a NATS restart doesn't turn into a
+// GitLab verification storm, and the client stops reconnecting after repeated
+// authorization violations, since retrying a revoked or expired PAT cannot succeed.
+func Options(username, token string) ([]nats.Option, error) {
+	username = strings.TrimSpace(username)
+	token = strings.TrimSpace(token)
+	if username == "" {
+		return nil, ErrMissingUsername
+	}
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	return append([]nats.Option{nats.UserInfo(username, token)}, reconnectOptions()...), nil
+}
+
+// ConnectToken connects to NATS with the PAT alone, sent as a bearer token;
+// GCS Antal issues the JWT for the token owner's username.
+func ConnectToken(url, token string, opts ...nats.Option) (*nats.Conn, error) {
+	all, err := TokenOptions(token)
+	if err != nil {
+		return nil, err
+	}
+	return nats.Connect(url, append(all, opts...)...)
+}
+
+// TokenOptions returns the connection options of ConnectToken: the PAT as
+// the auth token plus the reconnect settings of Options.
+func TokenOptions(token string) ([]nats.Option, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	return append([]nats.Option{nats.Token(token)}, reconnectOptions()...), nil
+}
+
+func reconnectOptions() []nats.Option {
+	return []nats.Option{
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.ReconnectJitter(time.Second, 2*time.Second),
+	}
+}
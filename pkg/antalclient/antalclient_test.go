@@ -0,0 +1,107 @@
+package antalclient
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	t.Run("rejects missing credentials", func(t *testing.T) {
+		_, err := Options("", "glpat-x")
+		assert.ErrorIs(t, err, ErrMissingUsername)
+
+		_, err = Options("alice", "  ")
+		assert.ErrorIs(t, err, ErrMissingToken)
+	})
+
+	t.Run("sets credentials and reconnect policy", func(t *testing.T) {
+		opts, err := Options("alice", "glpat-x")
+		require.NoError(t, err)
+
+		o := nats.GetDefaultOptions()
+		for _, opt := range opts {
+			require.NoError(t, opt(&o))
+		}
+		assert.Equal(t, "alice", o.User)
+		assert.Equal(t, "glpat-x", o.Password)
+		assert.Equal(t, -1, o.MaxReconnect)
+		assert.Equal(t, 2*time.Second, o.ReconnectWait)
+		assert.Equal(t, time.Second, o.ReconnectJitter)
+		assert.False(t, o.IgnoreAuthErrorAbort)
+	})
+}
+
+func TestTokenOptions(t *testing.T) {
+	_, err := TokenOptions(" ")
+	assert.ErrorIs(t, err, ErrMissingToken)
+
+	opts, err := TokenOptions("glpat-x")
+	require.NoError(t, err)
+	o := nats.GetDefaultOptions()
+	for _, opt := range opts {
+		require.NoError(t, opt(&o))
+	}
+	assert.Equal(t, "glpat-x", o.Token)
+	assert.Empty(t, o.User)
+	assert.Equal(t, -1, o.MaxReconnect)
+}
+
+func TestParseDenyCode(t *testing.T) {
+	code, ok := ParseDenyCode("invalid_credentials: invalid credentials")
+	assert.True(t, ok)
+	assert.Equal(t, DenyInvalidCredentials, code)
+
+	_, ok = ParseDenyCode("something else: entirely")
+	assert.False(t, ok)
+
+	_, ok = ParseDenyCode("no code here")
+	assert.False(t, ok)
+}
+
+func TestRetryableAndExplain(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+		contains  string
+	}{
+		{nats.ErrAuthorization, false, "authorization violation"},
+		{fmt.Errorf("connect: %w", nats.ErrAuthExpired), false, "expired"},
+		{nats.ErrNoServers, true, "no NATS servers"},
+		{errors.New("auth_error: authentication error"), true, "retry later"},
+		{errors.New("invalid_credentials: invalid credentials"), false, "GitLab rejected the token"},
+		{errors.New("rate_limited: too many JWTs issued"), true, "reconnect loop"},
+		{errors.New("account_at_capacity: account is at its connection budget"), true, "connection budget"},
+		{errors.New("username_required: connect with a username"), false, "GitLab username"},
+		{errors.New("retry_later: overloaded, retry after 5s"), true, "overloaded"},
+		{ErrMissingToken, false, "token is empty"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.retryable, Retryable(tt.err), tt.err.Error())
+		assert.Contains(t, Explain(tt.err), tt.contains)
+	}
+	assert.False(t, Retryable(nil))
+	assert.Empty(t, Explain(nil))
+}
+
+func TestRetryAfter(t *testing.T) {
+	delay, ok := RetryAfter(errors.New("retry_later: overloaded, retry after 1.5s"))
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, delay)
+
+	delay, ok = RetryAfter(fmt.Errorf("nats: authorization violation: %w", errors.New("retry_later: overloaded, retry after 5s'")))
+	assert.False(t, ok, "not a deny message on its own")
+	assert.Zero(t, delay)
+
+	_, ok = RetryAfter(errors.New("rate_limited: retry after 5s"))
+	assert.False(t, ok, "only retry_later carries a delay")
+	_, ok = RetryAfter(errors.New("retry_later: overloaded, retry after"))
+	assert.False(t, ok)
+	_, ok = RetryAfter(nil)
+	assert.False(t, ok)
+}
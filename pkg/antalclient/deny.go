@@ -0,0 +1,129 @@
+package antalclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DenyCode is the machine-readable reason GCS Antal attaches to denied
+// authorization responses ("<code>: <message>"). NATS servers log this text;
+// clients themselves only observe an authorization violation.
+type DenyCode string
+
+const (
+	DenyInvalidRequest     DenyCode = "invalid_request"
+	DenyInvalidCredentials DenyCode = "invalid_credentials"
+	DenyAuthError          DenyCode = "auth_error"
+	DenyInvalidClaims      DenyCode = "invalid_claims"
+	DenyInternalError      DenyCode = "internal_error"
+	DenyExcessiveScopes    DenyCode = "excessive_scopes"
+	DenyRateLimited        DenyCode = "rate_limited"
+	DenyAccountAtCapacity  DenyCode = "account_at_capacity"
+	DenyUsernameRequired   DenyCode = "username_required"
+	DenyRetryLater         DenyCode = "retry_later"
+	DenyLockedOut          DenyCode = "locked_out"
+	DenyPasswordSent       DenyCode = "password_sent"
+	DenyUsernameMismatch   DenyCode = "username_mismatch"
+)
+
+// denyAdvice describes what a user can do about each deny code.
+var denyAdvice = map[DenyCode]string{
+	DenyInvalidRequest:     "the NATS server sent a malformed auth request; report this to the NATS operators",
+	DenyInvalidCredentials: "GitLab rejected the token; check that the PAT is valid, not expired and not revoked",
+	DenyAuthError:          "the token could not be verified (GitLab unavailable?); retry later",
+	DenyInvalidClaims:      "the issued permissions are invalid; report this to the GCS Antal operators",
+	DenyInternalError:      "GCS Antal failed to issue credentials; retry later or report this to the operators",
+	DenyExcessiveScopes:    "the PAT has more scopes than allowed for NATS access; create a least-privilege token (e.g. read_api only)",
+	DenyRateLimited:        "too many connections in the last minute; check for a reconnect loop and back off before retrying",
+	DenyAccountAtCapacity:  "the NATS account has reached its connection budget; retry later or ask the operators to raise it",
+	DenyUsernameRequired:   "no username was sent; connect with your GitLab username as the NATS user",
+	DenyRetryLater:         "GCS Antal is overloaded; reconnect after the delay in the message",
+	DenyLockedOut:          "too many invalid tokens were sent for this username; fix the token and reconnect after the time in the message",
+	DenyPasswordSent:       "the GitLab account password was sent; create a personal access token in GitLab (User Settings > Access tokens) and use it as the NATS password",
+	DenyUsernameMismatch:   "the token belongs to another GitLab user; connect with the token owner's username, or with no username",
+}
+
+// ParseDenyCode extracts the deny code from an Antal deny message, as found
+// in NATS server logs or auth callout responses.
+func ParseDenyCode(msg string) (DenyCode, bool) {
+	code, _, ok := strings.Cut(msg, ":")
+	if !ok {
+		return "", false
+	}
+	dc := DenyCode(strings.TrimSpace(code))
+	if _, known := denyAdvice[dc]; !known {
+		return "", false
+	}
+	return dc, true
+}
+
+// Retryable reports whether reconnecting with the same credentials may
+// succeed. Authorization failures are considered final: the token has to be
+// fixed first.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if code, ok := ParseDenyCode(err.Error()); ok {
+		return code == DenyAuthError || code == DenyInternalError || code == DenyRateLimited || code == DenyAccountAtCapacity ||
+			code == DenyRetryLater
+	}
+	switch {
+	case errors.Is(err, ErrMissingToken), errors.Is(err, ErrMissingUsername),
+		errors.Is(err, nats.ErrAuthorization), errors.Is(err, nats.ErrAuthExpired),
+		errors.Is(err, nats.ErrAuthRevoked):
+		return false
+	}
+	return true
+}
+
+// retryAfterPrefix precedes the suggested delay in retry_later messages.
+const retryAfterPrefix = "retry after "
+
+// RetryAfter returns the delay suggested by a retry_later denial.
+func RetryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	msg := err.Error()
+	if code, ok := ParseDenyCode(msg); !ok || code != DenyRetryLater {
+		return 0, false
+	}
+	_, after, ok := strings.Cut(msg, retryAfterPrefix)
+	fields := strings.Fields(after)
+	if !ok || len(fields) == 0 {
+		return 0, false
+	}
+	delay, parseErr := time.ParseDuration(strings.TrimRight(fields[0], `'".,`))
+	if parseErr != nil || delay <= 0 {
+		return 0, false
+	}
+	return delay, true
+}
+
+// Explain returns a human-readable hint for a connection error.
+func Explain(err error) string {
+	if err == nil {
+		return ""
+	}
+	if code, ok := ParseDenyCode(err.Error()); ok {
+		return fmt.Sprintf("%s: %s", code, denyAdvice[code])
+	}
+	switch {
+	case errors.Is(err, ErrMissingToken), errors.Is(err, ErrMissingUsername):
+		return err.Error()
+	case errors.Is(err, nats.ErrAuthorization):
+		return "authorization violation: the token was rejected or could not be verified; check the PAT and see the NATS server log for the Antal deny code"
+	case errors.Is(err, nats.ErrAuthExpired), errors.Is(err, nats.ErrAuthRevoked):
+		return "credentials expired or were revoked; reconnect to obtain fresh credentials"
+	case errors.Is(err, nats.ErrPermissionViolation):
+		return "permissions violation: the subject is not granted to this user"
+	case errors.Is(err, nats.ErrNoServers):
+		return "no NATS servers available; check the URL and network connectivity"
+	}
+	return err.Error()
+}
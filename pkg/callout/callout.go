@@ -0,0 +1,126 @@
+// Package callout builds the JWTs GCS Antal answers NATS auth callout
+// requests with: the user claims of an authenticated user and the signed
+// authorization response around them. The functions hold no state and make no
+// GitLab or NATS calls, so tests and tools can produce exactly what the
+// server sends.
+//
+// Typical usage:
+//
+//	uc := callout.BuildUserClaims(
+//		callout.Identity{UserNkey: req.UserNkey, Name: "alice", Audience: "APP"},
+//		callout.Policy{Permissions: perms},
+//	)
+//	userJwt, err := callout.Encode(uc, signer)
+//	...
+//	rc, err := callout.BuildResponse(req.UserNkey, req.Server.ID, userJwt, "")
+//	token, err := callout.Encode(rc, signer)
+package callout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// Identity is who a user JWT is issued to.
+type Identity struct {
+	// UserNkey is the public key of the connecting client, from the request.
+	UserNkey string
+	// Name is the JWT name, reported by the servers in CONNZ.
+	Name string
+	// Audience is the account the user is placed in.
+	Audience string
+	// IssuerAccount is the public key of the account when the JWT is signed
+	// with one of its signing keys (operator mode); empty otherwise.
+	IssuerAccount string
+	// Tags are added as "name:value" strings.
+	Tags []string
+}
+
+// Limits are NATS connection limits. A zero field keeps the default of the
+// claims, which is unlimited.
+type Limits struct {
+	Subs    int64
+	Data    int64
+	Payload int64
+}
+
+// Policy is what a user JWT grants.
+type Policy struct {
+	Permissions jwt.Permissions
+	Limits      Limits
+}
+
+// BuildUserClaims returns the user claims for an identity and policy. The
+// claims are not validated; check them with their Validate method before
+// encoding.
+func BuildUserClaims(id Identity, policy Policy) *jwt.UserClaims {
+	uc := jwt.NewUserClaims(id.UserNkey)
+	uc.Name = id.Name
+	uc.Audience = id.Audience
+	uc.IssuerAccount = id.IssuerAccount
+	uc.Permissions = policy.Permissions
+	policy.Limits.apply(&uc.Limits.NatsLimits)
+	uc.Tags.Add(id.Tags...)
+	return uc
+}
+
+// apply sets the non-zero limits.
+func (l Limits) apply(limits *jwt.NatsLimits) {
+	if l.Subs != 0 {
+		limits.Subs = l.Subs
+	}
+	if l.Data != 0 {
+		limits.Data = l.Data
+	}
+	if l.Payload != 0 {
+		limits.Payload = l.Payload
+	}
+}
+
+// BuildResponse returns the authorization response claims for a request. An
+// empty errMsg allows the connection with userJwt; otherwise the connection
+// is denied with errMsg. serverID, when set, addresses the response to the
+// server that sent the request.
+func BuildResponse(userNkey, serverID, userJwt, errMsg string) (*jwt.AuthorizationResponseClaims, error) {
+	if !strings.HasPrefix(userNkey, "U") {
+		return nil, fmt.Errorf("callout: invalid user nkey %q", userNkey)
+	}
+	rc := jwt.NewAuthorizationResponseClaims(userNkey)
+	if serverID != "" {
+		rc.Audience = serverID
+	}
+	rc.Error = errMsg
+	rc.Jwt = userJwt
+	return rc, nil
+}
+
+// Signer signs JWTs as the issuer account. nkeys.KeyPair implements it, as
+// does any signer backed by an external service.
+type Signer interface {
+	PublicKey() (string, error)
+	Sign(data []byte) ([]byte, error)
+}
+
+// Encode encodes and signs claims with the signer.
+func Encode(claims jwt.Claims, signer Signer) (string, error) {
+	if signer == nil {
+		return "", errors.New("no issuer signer configured")
+	}
+	pub, err := signer.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("issuer public key: %w", err)
+	}
+	// The JWT library only needs the public key from the key pair; signing
+	// goes through the signer.
+	issuer, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("issuer public key: %w", err)
+	}
+	return claims.EncodeWithSigner(issuer, func(_ string, data []byte) ([]byte, error) {
+		return signer.Sign(data)
+	})
+}
@@ -0,0 +1,90 @@
+package callout
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUserKey(t *testing.T) string {
+	t.Helper()
+	kp, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	pub, err := kp.PublicKey()
+	require.NoError(t, err)
+	return pub
+}
+
+func TestBuildUserClaims(t *testing.T) {
+	user := newUserKey(t)
+	var perms jwt.Permissions
+	perms.Pub.Allow.Add("user.alice.>")
+	perms.Sub.Allow.Add("_INBOX.>")
+
+	uc := BuildUserClaims(
+		Identity{UserNkey: user, Name: "alice", Audience: "APP", Tags: []string{"env:prod"}},
+		Policy{Permissions: perms, Limits: Limits{Subs: 10}},
+	)
+
+	assert.Equal(t, user, uc.Subject)
+	assert.Equal(t, "alice", uc.Name)
+	assert.Equal(t, "APP", uc.Audience)
+	assert.Empty(t, uc.IssuerAccount, "signed by the issuer itself")
+	assert.Equal(t, perms, uc.Permissions)
+	assert.Equal(t, jwt.TagList{"env:prod"}, uc.Tags)
+	assert.Equal(t, jwt.NatsLimits{Subs: 10, Data: jwt.NoLimit, Payload: jwt.NoLimit}, uc.Limits.NatsLimits,
+		"zero limits keep the default")
+
+	vr := jwt.CreateValidationResults()
+	uc.Validate(vr)
+	assert.Empty(t, vr.Errors())
+}
+
+func TestBuildResponse(t *testing.T) {
+	user := newUserKey(t)
+
+	rc, err := BuildResponse(user, "NSERVER", "user.jwt", "")
+	require.NoError(t, err)
+	assert.Equal(t, user, rc.Subject)
+	assert.Equal(t, "NSERVER", rc.Audience)
+	assert.Equal(t, "user.jwt", rc.Jwt)
+	assert.Empty(t, rc.Error)
+
+	rc, err = BuildResponse(user, "", "", "invalid_credentials: invalid credentials")
+	require.NoError(t, err)
+	assert.Empty(t, rc.Audience)
+	assert.Equal(t, "invalid_credentials: invalid credentials", rc.Error)
+
+	_, err = BuildResponse("", "NSERVER", "", "denied")
+	assert.ErrorContains(t, err, "invalid user nkey")
+}
+
+func TestEncode(t *testing.T) {
+	issuer, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	issuerPub, err := issuer.PublicKey()
+	require.NoError(t, err)
+	user := newUserKey(t)
+
+	userJwt, err := Encode(BuildUserClaims(Identity{UserNkey: user, Name: "alice"}, Policy{}), issuer)
+	require.NoError(t, err)
+	rc, err := BuildResponse(user, "NSERVER", userJwt, "")
+	require.NoError(t, err)
+	token, err := Encode(rc, issuer)
+	require.NoError(t, err)
+
+	// Decoding verifies the signatures.
+	decoded, err := jwt.DecodeAuthorizationResponseClaims(token)
+	require.NoError(t, err)
+	assert.Equal(t, issuerPub, decoded.Issuer)
+	uc, err := jwt.DecodeUserClaims(decoded.Jwt)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", uc.Name)
+	assert.Equal(t, issuerPub, uc.Issuer)
+
+	_, err = Encode(rc, nil)
+	assert.ErrorContains(t, err, "no issuer signer")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/replay"
+	"git.sgw.equipment/restricted/gcs_antal/pkg/antalclient"
+)
+
+// runReplay implements `antal replay`: it replays the auth decisions of an
+// audit file against the NATS server at nats.url, with the synthetic tokens
+// of --tokens, and compares the decisions with the recorded ones. It
+// returns the process exit code.
+//
+// Point nats.url at a staging server: every replayed request is a real
+// connection attempt and counts towards lockouts and rate limits there.
+func runReplay() int {
+	logger := slog.With("component", "replay")
+
+	cfg, err := replay.LoadConfig()
+	if err != nil {
+		logger.Error("Invalid replay configuration", "error", err)
+		return 1
+	}
+	tokens, err := replay.LoadTokens(cfg.TokensFile)
+	if err != nil {
+		logger.Error("Failed to load replay tokens", "error", err)
+		return 1
+	}
+	f, err := os.Open(cfg.From)
+	if err != nil {
+		logger.Error("Failed to open audit file", "error", err)
+		return 1
+	}
+	records, invalid, err := replay.ReadRecords(f)
+	_ = f.Close()
+	if err != nil {
+		logger.Error("Failed to read audit file", "error", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	natsURL := viper.GetString("nats.url")
+	logger.Info("Starting replay",
+		"from", cfg.From,
+		"records", len(records),
+		"invalid_lines", invalid,
+		"users_with_tokens", len(tokens),
+		"rate", viper.GetString("rate"),
+		"nats_url", natsURL,
+	)
+
+	connect := func(name, username, token string) (bool, error) {
+		nc, err := antalclient.Connect(natsURL, username, token, nats.Name(name), nats.MaxReconnects(0))
+		if errors.Is(err, nats.ErrAuthorization) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		nc.Close()
+		return true, nil
+	}
+	report := replay.NewRunner(cfg, tokens, connect).Run(ctx, records)
+
+	logger.Info("Replay finished",
+		"replayed", report.Replayed,
+		"allowed", report.Allowed,
+		"denied", report.Denied,
+		"errors", report.Errors,
+		"mismatches", report.Mismatches,
+		"no_token", report.NoToken,
+		"skipped", report.Skipped,
+		"p50", report.P50,
+		"p99", report.P99,
+		"max", report.Max,
+	)
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+
+	"git.sgw.equipment/restricted/gcs_antal/internal/auth"
+	"git.sgw.equipment/restricted/gcs_antal/internal/soak"
+	"git.sgw.equipment/restricted/gcs_antal/pkg/antalclient"
+)
+
+// runSoak implements `antal soak`: it runs the auth callout service against
+// an embedded fake GitLab and keeps connecting synthetic users through NATS,
+// reporting latency and memory drift. It returns the process exit code.
+//
+// The NATS server at nats.url must route its auth callout to this instance's
+// issuer; use a dedicated server, since production instances in the same
+// queue group would receive part of the synthetic traffic.
+func runSoak() int {
+	logger := slog.With("component", "soak")
+
+	cfg, err := soak.LoadConfig()
+	if err != nil {
+		logger.Error("Invalid soak configuration", "error", err)
+		return 1
+	}
+
+	stub := soak.NewGitLabStub(cfg.Profile)
+	defer stub.Close()
+	viper.Set("gitlab.url", stub.URL())
+
+	natsURL := viper.GetString("nats.url")
+	natsClient, err := auth.NewNATSClient(
+		natsURL,
+		viper.GetString("nats.user"),
+		viper.GetString("nats.pass"),
+		viper.GetString("nats.issuer_seed"),
+		viper.GetString("nats.xkey_seed"),
+		auth.NewGitLabClient(),
+	)
+	if err != nil {
+		logger.Error("Failed to create NATS client", "error", err)
+		return 1
+	}
+	defer natsClient.Stop()
+
+	if err := natsClient.Start(); err != nil {
+		logger.Error("Failed to start NATS client", "error", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Starting soak test",
+		"duration", cfg.Duration,
+		"connect_rate", cfg.ConnectRate,
+		"users", cfg.Users,
+		"phases", len(cfg.Profile),
+		"gitlab_stub", stub.URL(),
+	)
+
+	connect := func(username, token string) error {
+		nc, err := antalclient.Connect(natsURL, username, token, nats.MaxReconnects(0))
+		if err != nil {
+			return err
+		}
+		nc.Close()
+		return nil
+	}
+	report := soak.NewRunner(cfg, connect, stub).Run(ctx)
+
+	latencyDrift, heapGrowth := report.Drift()
+	violations := report.Violations(cfg)
+	logger.Info("Soak test finished",
+		"windows", len(report.Windows),
+		"p99_latency_drift", latencyDrift,
+		"heap_growth", heapGrowth,
+		"violations", violations,
+	)
+	if len(violations) > 0 {
+		for _, v := range violations {
+			logger.Error("Soak drift limit exceeded", "violation", v)
+		}
+		return 1
+	}
+	return 0
+}